@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+const (
+	subBucketBits  = 7                  // values are recorded with a precision of 1/64 (< 1.6 %)
+	subBucketCount = 1 << subBucketBits // number of buckets of exact values, also the size of each further range
+	subBucketHalf  = subBucketCount / 2 // number of buckets per power of two above the exact values
+	maxLatency     = time.Hour          // latencies above are recorded as the maximum latency
+	latencyUnit    = time.Microsecond   // resolution of the recorded latencies
+	maxValue       = uint64(maxLatency / latencyUnit)
+)
+
+// Histogram records latencies in logarithmic buckets with linear sub-buckets, like HdrHistogram.
+// Its size does not depend on the number of recorded values, so that large load tests do not keep
+// every single latency in memory. Min and max are recorded exactly.
+// It is not safe for concurrent use.
+type Histogram struct {
+	counts []uint64
+	total  uint64
+	min    time.Duration
+	max    time.Duration
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{counts: make([]uint64, bucketIndex(maxValue)+1)}
+}
+
+// bucketIndex returns the index of the bucket of the value. Values below subBucketCount have a bucket
+// each, above, each power of two is divided into subBucketHalf buckets of equal width.
+func bucketIndex(v uint64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits
+	return subBucketCount + (shift-1)*subBucketHalf + int(v>>uint(shift)) - subBucketHalf
+}
+
+// bucketValue returns the middle of the range of values of the bucket with the given index
+func bucketValue(i int) uint64 {
+	if i < subBucketCount {
+		return uint64(i)
+	}
+	shift := (i-subBucketCount)/subBucketHalf + 1
+	lowest := uint64((i-subBucketCount)%subBucketHalf+subBucketHalf) << uint(shift)
+	return lowest + (1<<uint(shift))/2
+}
+
+// Record adds a latency to the histogram
+func (h *Histogram) Record(latency time.Duration) {
+	if h.total == 0 || latency < h.min {
+		h.min = latency
+	}
+	if latency > h.max {
+		h.max = latency
+	}
+	h.total++
+
+	v := uint64(0)
+	if latency > 0 {
+		v = uint64(latency / latencyUnit)
+	}
+	if v > maxValue {
+		v = maxValue
+	}
+	h.counts[bucketIndex(v)]++
+}
+
+// Count returns the number of recorded latencies
+func (h *Histogram) Count() uint64 {
+	return h.total
+}
+
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Percentile returns the latency below which the given percentage of the recorded latencies are,
+// or 0 if no latency was recorded
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	// the rank of the latency, rounded up, so that p99 of 10 latencies is the highest latency
+	rank := uint64(p / 100 * float64(h.total))
+	if float64(rank) < p/100*float64(h.total) {
+		rank++
+	}
+	if rank <= 1 {
+		return h.min
+	}
+	if rank >= h.total {
+		return h.max
+	}
+
+	var seen uint64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			latency := time.Duration(bucketValue(i)) * latencyUnit
+			// the middle of the bucket may be outside of the recorded range
+			if latency < h.min {
+				return h.min
+			}
+			if latency > h.max {
+				return h.max
+			}
+			return latency
+		}
+	}
+	return h.max
+}
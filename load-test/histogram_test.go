@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketIndex(t *testing.T) {
+	// the buckets are contiguous and each value lies within 1/64 of the middle of its bucket
+	prev := -1
+	for v := uint64(0); v <= 1<<20; v++ {
+		i := bucketIndex(v)
+		if i != prev && i != prev+1 {
+			t.Fatalf("%d: bucket %d does not follow bucket %d", v, i, prev)
+		}
+		prev = i
+
+		if mid := bucketValue(i); float64(diff(mid, v)) > float64(v)/64 {
+			t.Fatalf("%d: middle of bucket %d is %d", v, i, mid)
+		}
+	}
+
+	if bucketIndex(maxValue) >= len(NewHistogram().counts) {
+		t.Error("maximum value has no bucket")
+	}
+}
+
+func diff(a, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram()
+	if h.Percentile(50) != 0 {
+		t.Error("percentile of empty histogram is not 0")
+	}
+
+	// 1ms, 2ms, ..., 1000ms
+	for i := 1000; i >= 1; i-- {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	h.Record(2 * time.Hour)
+
+	if h.Count() != 1001 || h.Min() != time.Millisecond || h.Max() != 2*time.Hour {
+		t.Errorf("unexpected count, min or max: %d, %s, %s", h.Count(), h.Min(), h.Max())
+	}
+
+	for p, expected := range map[float64]time.Duration{
+		0:   time.Millisecond,
+		50:  501 * time.Millisecond,
+		90:  901 * time.Millisecond,
+		99:  991 * time.Millisecond,
+		100: 2 * time.Hour,
+	} {
+		actual := h.Percentile(p)
+		if float64(diff(uint64(actual), uint64(expected))) > float64(expected)/64 {
+			t.Errorf("p%v: expected %s, got %s", p, expected, actual)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -15,6 +16,9 @@ const (
 )
 
 func main() {
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
 	testCtx := NewTestCtx()
 	sender := NewSender(testCtx)
 
@@ -37,6 +41,12 @@ func main() {
 	}
 
 	testCtx.wg.Wait()
-	log.Infof(" = = = => [ %4d ] requests done after [ %7.3f ] seconds <= = = = ", len(testCtx.identities)*numberOfRequestsPerID, time.Since(start).Seconds())
+	elapsed := time.Since(start)
+	log.Infof(" = = = => [ %4d ] requests done after [ %7.3f ] seconds <= = = = ", len(testCtx.identities)*numberOfRequestsPerID, elapsed.Seconds())
 	testCtx.finish()
+
+	err := testCtx.results.report(elapsed).print(*asJSON)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Result is the outcome of a single signing request
+type Result struct {
+	Latency time.Duration
+	Err     error
+}
+
+// statusError is returned if the client responded with another status than 200
+type statusError struct {
+	status string
+}
+
+func (e statusError) Error() string {
+	return e.status
+}
+
+// Report summarizes the results of a load test. The latencies are those of all requests, also of failed requests.
+type Report struct {
+	Requests           uint64            `json:"requests"`
+	Successes          uint64            `json:"successes"`
+	Errors             uint64            `json:"errors"`
+	ErrorsByCause      map[string]uint64 `json:"errorsByCause,omitempty"`
+	DurationSeconds    float64           `json:"durationSeconds"`
+	SuccessesPerSecond float64           `json:"successesPerSecond"`
+	LatencyMillis      LatencyReport     `json:"latencyMillis"`
+}
+
+type LatencyReport struct {
+	Min float64 `json:"min"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// ResultCollector aggregates the results of the requests for the final report
+type ResultCollector struct {
+	Results   chan Result
+	ctx       context.Context
+	cancel    context.CancelFunc
+	latencies *Histogram
+	successes uint64
+	errors    map[string]uint64
+}
+
+func NewResultCollector() *ResultCollector {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := &ResultCollector{
+		Results:   make(chan Result, 100),
+		ctx:       ctx,
+		cancel:    cancel,
+		latencies: NewHistogram(),
+		errors:    make(map[string]uint64),
+	}
+
+	// start result collector routine
+	go r.collect()
+
+	return r
+}
+
+func (r *ResultCollector) finish() {
+	close(r.Results)
+	<-r.ctx.Done()
+}
+
+func (r *ResultCollector) collect() {
+	defer r.cancel()
+
+	for result := range r.Results {
+		r.latencies.Record(result.Latency)
+		if result.Err == nil {
+			r.successes++
+		} else {
+			r.errors[errorCause(result.Err)]++
+		}
+	}
+}
+
+// errorCause returns the status of error responses or the kind of error of failed requests
+func errorCause(err error) string {
+	if statusErr, ok := err.(statusError); ok {
+		return statusErr.status
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return "timeout"
+	}
+	return "request failed"
+}
+
+// report returns the report of the collected results. It must be called after finish.
+func (r *ResultCollector) report(elapsed time.Duration) Report {
+	rep := Report{
+		Requests:        r.latencies.Count(),
+		Successes:       r.successes,
+		Errors:          r.latencies.Count() - r.successes,
+		DurationSeconds: elapsed.Seconds(),
+		LatencyMillis: LatencyReport{
+			Min: millis(r.latencies.Min()),
+			P50: millis(r.latencies.Percentile(50)),
+			P90: millis(r.latencies.Percentile(90)),
+			P99: millis(r.latencies.Percentile(99)),
+			Max: millis(r.latencies.Max()),
+		},
+	}
+	if len(r.errors) > 0 {
+		rep.ErrorsByCause = r.errors
+	}
+	if elapsed > 0 {
+		rep.SuccessesPerSecond = float64(r.successes) / elapsed.Seconds()
+	}
+	return rep
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// print writes the report to stdout as table or as JSON
+func (rep Report) print(asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+	return rep.writeTable(os.Stdout)
+}
+
+func (rep Report) writeTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "requests\t%d\n", rep.Requests)
+	fmt.Fprintf(w, "successes\t%d\n", rep.Successes)
+	fmt.Fprintf(w, "errors\t%d\n", rep.Errors)
+
+	causes := make([]string, 0, len(rep.ErrorsByCause))
+	for cause := range rep.ErrorsByCause {
+		causes = append(causes, cause)
+	}
+	sort.Strings(causes)
+	for _, cause := range causes {
+		fmt.Fprintf(w, "  %s\t%d\n", cause, rep.ErrorsByCause[cause])
+	}
+
+	fmt.Fprintf(w, "duration [s]\t%.3f\n", rep.DurationSeconds)
+	fmt.Fprintf(w, "successes per second\t%.1f\n", rep.SuccessesPerSecond)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "latency [ms]\tmin\tp50\tp90\tp99\tmax")
+	l := rep.LatencyMillis
+	fmt.Fprintf(w, "\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\n", l.Min, l.P50, l.P90, l.P99, l.Max)
+
+	return w.Flush()
+}
@@ -108,7 +108,9 @@ func (s *Sender) sendAndCheckResponse(clientURL string, header http.Header) {
 		return
 	}
 
+	start := time.Now()
 	resp, err := s.sendRequest(clientURL, header, hash)
+	s.testCtx.results.Results <- Result{Latency: time.Since(start), Err: err}
 	if err != nil {
 		log.Error(err)
 		return
@@ -141,7 +143,7 @@ func (s *Sender) sendRequest(clientURL string, header http.Header, hash []byte)
 	s.testCtx.statusCounter.StatusCodes <- resp.Status
 
 	if resp.StatusCode != 200 {
-		return SigningResponse{}, fmt.Errorf(resp.Status)
+		return SigningResponse{}, statusError{status: resp.Status}
 	}
 
 	clientResponse := SigningResponse{}
@@ -10,6 +10,7 @@ type TestCtx struct {
 	wg            *sync.WaitGroup
 	chainChecker  *ChainChecker
 	statusCounter *StatusCounter
+	results       *ResultCollector
 	identities    map[string]string
 	registerAuth  string
 }
@@ -28,6 +29,7 @@ func NewTestCtx() *TestCtx {
 		wg:            &sync.WaitGroup{},
 		chainChecker:  NewChainChecker(),
 		statusCounter: NewStatusCounter(),
+		results:       NewResultCollector(),
 		identities:    getTestIdentities(c),
 		registerAuth:  c.RegisterAuth,
 	}
@@ -36,4 +38,5 @@ func NewTestCtx() *TestCtx {
 func (t *TestCtx) finish() {
 	t.chainChecker.finish()
 	t.statusCounter.finish()
+	t.results.finish()
 }
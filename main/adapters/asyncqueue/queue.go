@@ -0,0 +1,114 @@
+package asyncqueue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Consumer processes the items of a queue until the items channel is closed. The context is cancelled,
+// if the drain timeout elapses on Close, after which the remaining items should be dropped.
+type Consumer func(ctx context.Context, items <-chan interface{})
+
+// Queue is a bounded queue, whose items are added without blocking and processed asynchronously by a consumer,
+// so that the producer, e.g. signing, is never blocked by a slow consumer. If the queue is full, further items
+// are dropped and counted.
+type Queue struct {
+	items     chan interface{}
+	drain     chan struct{} // closed when the drain timeout elapsed on Close
+	drainOnce *sync.Once
+	done      chan struct{}
+	dropped   uint64
+	closed    bool
+	mutex     *sync.RWMutex // guards closed, so that no item is sent after the items channel was closed
+}
+
+// New returns a queue of the given size and starts the consumer
+func New(size int, consume Consumer) *Queue {
+	q := &Queue{
+		items:     make(chan interface{}, size),
+		drain:     make(chan struct{}),
+		drainOnce: &sync.Once{},
+		done:      make(chan struct{}),
+		mutex:     &sync.RWMutex{},
+	}
+
+	go q.run(consume)
+	return q
+}
+
+func (q *Queue) run(consume Consumer) {
+	defer close(q.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-q.drain:
+			cancel()
+		case <-q.done:
+		}
+	}()
+
+	consume(ctx, q.items)
+}
+
+// Add adds an item to the queue without waiting for it to be processed.
+// It returns false if the queue is full or was closed and the item was dropped.
+func (q *Queue) Add(item interface{}) bool {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	if q.closed {
+		q.Drop(1)
+		return false
+	}
+
+	select {
+	case q.items <- item:
+		return true
+	default:
+		q.Drop(1)
+		return false
+	}
+}
+
+// Drop counts n items as dropped, e.g. items which the consumer failed to process
+func (q *Queue) Drop(n int) {
+	atomic.AddUint64(&q.dropped, uint64(n))
+}
+
+// Dropped returns the number of items which were dropped
+func (q *Queue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+// Done returns a channel which is closed when the consumer returned after the queue was closed
+func (q *Queue) Done() <-chan struct{} {
+	return q.done
+}
+
+// Close closes the queue and waits until the consumer processed the queued items. If the drain timeout
+// elapses before, the context of the consumer is cancelled. Without drain timeout, it waits until all
+// items were processed. Items which are added after the queue was closed are dropped.
+func (q *Queue) Close(drainTimeout time.Duration) {
+	q.mutex.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.items)
+	}
+	q.mutex.Unlock()
+
+	if drainTimeout <= 0 {
+		<-q.done
+		return
+	}
+
+	select {
+	case <-q.done:
+	case <-time.After(drainTimeout):
+		q.drainOnce.Do(func() { close(q.drain) })
+		<-q.done
+	}
+}
@@ -0,0 +1,72 @@
+package asyncqueue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	release := make(chan struct{})
+	processed := make(chan interface{}, 10)
+
+	q := New(2, func(ctx context.Context, items <-chan interface{}) {
+		<-release
+		for item := range items {
+			processed <- item
+		}
+	})
+
+	// the consumer is blocked, so items beyond the size of the queue are dropped
+	for i, expected := range []bool{true, true, false} {
+		if ok := q.Add(i); ok != expected {
+			t.Errorf("item %d: unexpected result: %t", i, ok)
+		}
+	}
+	if q.Dropped() != 1 {
+		t.Errorf("unexpected number of dropped items: %d", q.Dropped())
+	}
+
+	// the queued items are processed on close
+	close(release)
+	q.Close(0)
+	if len(processed) != 2 || <-processed != 0 || <-processed != 1 {
+		t.Errorf("queued items were not processed in order")
+	}
+
+	if q.Add(3) {
+		t.Error("item was added after the queue was closed")
+	}
+	if q.Dropped() != 2 {
+		t.Errorf("unexpected number of dropped items: %d", q.Dropped())
+	}
+	select {
+	case <-q.Done():
+	default:
+		t.Error("consumer did not return")
+	}
+	q.Close(time.Second) // closing again has no effect
+}
+
+func TestQueue_DrainTimeout(t *testing.T) {
+	var q *Queue
+	q = New(10, func(ctx context.Context, items <-chan interface{}) {
+		<-ctx.Done()
+		for range items {
+			// the drain timeout elapsed, the remaining items are dropped
+			q.Drop(1)
+		}
+	})
+	for i := 0; i < 3; i++ {
+		q.Add(i)
+	}
+
+	start := time.Now()
+	q.Close(10 * time.Millisecond)
+	if time.Since(start) > time.Second {
+		t.Error("drain timeout was not applied")
+	}
+	if q.Dropped() != 3 {
+		t.Errorf("unexpected number of dropped items: %d", q.Dropped())
+	}
+}
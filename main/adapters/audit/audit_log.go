@@ -0,0 +1,227 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/asyncqueue"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	filePerm = 0644
+
+	DefaultBufferSize    = 1000
+	DefaultFlushInterval = time.Second
+
+	chainOperation = "chain" // operation of the entries of chained UPPs
+
+	// KeyRotationOperation is the operation of the entries which record a key rotation of an identity.
+	// UPPs which were chained after the entry are signed with the new key.
+	KeyRotationOperation = "key-rotation"
+	// ChainResetOperation is the operation of the entries which record a chain reset of an identity.
+	// The UPP which was chained after the entry is chained to the genesis signature.
+	ChainResetOperation = "chain-reset"
+)
+
+// Entry records a signed UPP or an event which changes the chain of an identity, i.e. a key rotation
+// or a chain reset. It must never contain secrets like auth tokens.
+type Entry struct {
+	Timestamp     time.Time `json:"timestamp"`
+	UUID          uuid.UUID `json:"uuid"`
+	Operation     string    `json:"operation"`
+	Hash          []byte    `json:"hash"`
+	UPP           []byte    `json:"upp"`
+	RequestID     string    `json:"requestID,omitempty"`
+	BackendStatus int       `json:"backendStatus"`           // status code of the signing response, 202 if the UPP was queued for re-submission
+	PrevPublicKey []byte    `json:"prevPublicKey,omitempty"` // PEM encoded public key which was replaced by a key rotation
+	PublicKey     []byte    `json:"publicKey,omitempty"`     // PEM encoded public key which is valid after a key rotation
+}
+
+// Log is an append-only audit log, which writes one JSON encoded Entry per line to a file.
+// Entries are appended asynchronously, so that signing is never blocked by a slow disk. They are
+// written to the file by a single writer and synced at the flush interval, so on a crash at most
+// the entries of one flush interval and the buffered entries are lost. If the buffer is full,
+// further entries are dropped and counted.
+type Log struct {
+	file          string
+	flushInterval time.Duration
+	queue         *asyncqueue.Queue
+	queries       chan query
+}
+
+type query struct {
+	uid    uuid.UUID
+	result chan queryResult
+}
+
+type queryResult struct {
+	entries []Entry
+	err     error
+}
+
+func NewLog(file string, bufferSize int, flushInterval time.Duration) (*Log, error) {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	if flushInterval <= 0 {
+		flushInterval = DefaultFlushInterval
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, filePerm)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log file: %v", err)
+	}
+
+	l := &Log{
+		file:          file,
+		flushInterval: flushInterval,
+		queries:       make(chan query),
+	}
+	l.queue = asyncqueue.New(bufferSize, func(_ context.Context, entries <-chan interface{}) {
+		l.write(f, entries)
+	})
+	return l, nil
+}
+
+// Append adds an entry to the audit log without waiting for it to be written.
+// It returns false if the buffer is full or the log was closed and the entry was dropped.
+func (l *Log) Append(e Entry) bool {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+
+	return l.queue.Add(e)
+}
+
+// Dropped returns the number of entries which were dropped because the buffer was full or the log was closed
+func (l *Log) Dropped() uint64 {
+	return l.queue.Dropped()
+}
+
+// Query returns the entries of the identity in the order in which they were appended,
+// or all entries if the UUID is uuid.Nil. Entries which are still buffered are written first.
+func (l *Log) Query(uid uuid.UUID) ([]Entry, error) {
+	q := query{uid: uid, result: make(chan queryResult, 1)}
+
+	select {
+	case l.queries <- q:
+	case <-l.queue.Done():
+		return nil, fmt.Errorf("audit log closed")
+	}
+
+	r := <-q.result
+	return r.entries, r.err
+}
+
+// Chain returns the entries of the chained UPPs of the identity which were accepted by the backend or
+// queued for re-submission, i.e. which are part of the chain of the identity, together with the key
+// rotations and chain resets of the identity, in the order in which they happened
+func (l *Log) Chain(uid uuid.UUID) ([]Entry, error) {
+	entries, err := l.Query(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []Entry
+	for _, e := range entries {
+		switch {
+		case e.Operation == chainOperation && e.BackendStatus < 300,
+			e.Operation == KeyRotationOperation,
+			e.Operation == ChainResetOperation:
+			chain = append(chain, e)
+		}
+	}
+	return chain, nil
+}
+
+// Close writes the buffered entries to the file and stops the writer.
+// Entries which are appended after the log was closed are dropped.
+func (l *Log) Close() error {
+	// the buffered entries are written without drain timeout, since writing to a file does not block indefinitely
+	l.queue.Close(0)
+	return nil
+}
+
+// write appends the entries to the file and answers queries until the log is closed
+func (l *Log) write(f *os.File, entries <-chan interface{}) {
+	//noinspection GoUnhandledErrorResult
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	flush := func() {
+		err := w.Flush()
+		if err == nil {
+			err = f.Sync()
+		}
+		if err != nil {
+			log.Errorf("writing audit log failed: %v", err)
+		}
+	}
+
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-entries:
+			if !ok {
+				flush()
+				return
+			}
+			writeEntry(w, e.(Entry))
+		case <-ticker.C:
+			if w.Buffered() > 0 {
+				flush()
+			}
+		case q := <-l.queries:
+			// write the entries which were appended before the query
+			for n := len(entries); n > 0; n-- {
+				writeEntry(w, (<-entries).(Entry))
+			}
+			flush()
+			entries, err := l.read(q.uid)
+			q.result <- queryResult{entries: entries, err: err}
+		}
+	}
+}
+
+func writeEntry(w *bufio.Writer, e Entry) {
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = w.Write(append(line, '\n'))
+	}
+	if err != nil {
+		log.Errorf("%s: writing audit log entry failed: %v", e.UUID, err)
+	}
+}
+
+func (l *Log) read(uid uuid.UUID) ([]Entry, error) {
+	f, err := os.Open(l.file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log file: %v", err)
+	}
+	//noinspection GoUnhandledErrorResult
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse audit log entry: %v", err)
+		}
+		if uid == uuid.Nil || e.UUID == uid {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := NewLog(file, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	uid := uuid.New()
+	otherUID := uuid.New()
+	hash := sha256.Sum256([]byte("1"))
+
+	for _, e := range []Entry{
+		{UUID: uid, Operation: "chain", Hash: hash[:], UPP: []byte("upp 1"), RequestID: "request 1", BackendStatus: 200},
+		{UUID: otherUID, Operation: "anchor", Hash: hash[:], UPP: []byte("upp 2"), BackendStatus: 200},
+		{UUID: uid, Operation: "chain", Hash: hash[:], UPP: []byte("upp 3"), BackendStatus: 500},
+		{UUID: uid, Operation: KeyRotationOperation, PrevPublicKey: []byte("key 1"), PublicKey: []byte("key 2")},
+		{UUID: uid, Operation: "chain", Hash: hash[:], UPP: []byte("upp 4"), BackendStatus: 202},
+		{UUID: uid, Operation: "disable", Hash: hash[:], UPP: []byte("upp 5"), BackendStatus: 200},
+	} {
+		if !l.Append(e) {
+			t.Fatal("entry was dropped")
+		}
+	}
+
+	// the entries are queried before the flush interval has passed
+	entries, err := l.Query(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("unexpected number of entries: expected 5, got %d", len(entries))
+	}
+	if entries[0].UUID != uid || entries[0].Operation != "chain" || !bytes.Equal(entries[0].Hash, hash[:]) ||
+		string(entries[0].UPP) != "upp 1" || entries[0].RequestID != "request 1" || entries[0].BackendStatus != 200 {
+		t.Errorf("unexpected entry: %+v", entries[0])
+	}
+	if entries[0].Timestamp.IsZero() {
+		t.Error("entry has no timestamp")
+	}
+
+	all, err := l.Query(uuid.Nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 6 {
+		t.Errorf("unexpected number of entries: expected 6, got %d", len(all))
+	}
+
+	// only chained UPPs which are part of the chain and key rotations and chain resets are in the chain history
+	chain, err := l.Chain(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || string(chain[0].UPP) != "upp 1" || string(chain[1].PublicKey) != "key 2" || string(chain[2].UPP) != "upp 4" {
+		t.Errorf("unexpected chain history: %+v", chain)
+	}
+}
+
+func TestLog_Reopen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	uid := uuid.New()
+
+	for i := 0; i < 2; i++ {
+		l, err := NewLog(file, 0, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Append(Entry{UUID: uid, Operation: "chain", UPP: []byte{byte(i)}, BackendStatus: 200})
+
+		// the buffered entries are written when the log is closed
+		err = l.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := NewLog(file, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	entries, err := l.Query(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].UPP[0] != 0 || entries[1].UPP[0] != 1 {
+		t.Errorf("entries of previous runs were not kept: %+v", entries)
+	}
+}
+
+func TestLog_Closed(t *testing.T) {
+	l, err := NewLog(filepath.Join(t.TempDir(), "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.Append(Entry{UUID: uuid.New()}) {
+		t.Error("entry was appended to closed log")
+	}
+	if l.Dropped() != 1 {
+		t.Errorf("unexpected number of dropped entries: expected 1, got %d", l.Dropped())
+	}
+	if _, err = l.Query(uuid.Nil); err == nil {
+		t.Error("query of closed log did not fail")
+	}
+}
@@ -0,0 +1,137 @@
+package clients
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	prom "github.com/ubirch/ubirch-client-go/main/prometheus"
+)
+
+// ErrCircuitOpen is returned instead of sending a request to a backend service,
+// if the circuit breaker of the service is open
+var ErrCircuitOpen = fmt.Errorf("backend service unavailable: circuit breaker is open")
+
+type BreakerState int
+
+const (
+	Closed   BreakerState = iota // requests are sent to the backend service
+	HalfOpen                     // a single probe request is sent to the backend service
+	Open                         // requests fail fast without being sent to the backend service
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker stops sending requests to a backend service after a number of consecutive failures.
+// While the breaker is open, requests fail fast with ErrCircuitOpen. After the cooldown, a single probe
+// request is let through. If it succeeds, the breaker is closed again, otherwise it stays open for
+// another cooldown.
+//
+// A nil *CircuitBreaker lets all requests through.
+type CircuitBreaker struct {
+	service          string
+	failureThreshold int
+	cooldown         time.Duration
+
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	mutex    *sync.Mutex
+	now      func() time.Time
+}
+
+// NewCircuitBreaker returns a closed circuit breaker for the service, which opens after
+// failureThreshold consecutive failures and lets a probe request through after the cooldown
+func NewCircuitBreaker(service string, failureThreshold int, cooldown time.Duration) *CircuitBreaker {
+	b := &CircuitBreaker{
+		service:          service,
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+		mutex:            &sync.Mutex{},
+		now:              time.Now,
+	}
+	b.setState(Closed)
+	return b
+}
+
+// Allow returns ErrCircuitOpen if a request to the backend service must not be sent
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.setState(HalfOpen)
+		return nil
+	case HalfOpen:
+		// the probe request is still pending
+		return ErrCircuitOpen
+	default:
+		return nil
+	}
+}
+
+// Record registers the result of a request to the backend service. Transport errors
+// and 5xx responses are failures.
+func (b *CircuitBreaker) Record(statusCode int, err error) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err == nil && statusCode < 500 {
+		b.failures = 0
+		if b.state != Closed {
+			log.Infof("%s: circuit breaker closed", b.service)
+			b.setState(Closed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.failureThreshold {
+		if b.state != Open {
+			log.Warnf("%s: circuit breaker opened after %d consecutive failures", b.service, b.failures)
+		}
+		b.openedAt = b.now()
+		b.setState(Open)
+	}
+}
+
+// State returns the current state of the circuit breaker
+func (b *CircuitBreaker) State() BreakerState {
+	if b == nil {
+		return Closed
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state
+}
+
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.state = state
+	prom.BackendCircuitBreakerState.WithLabelValues(b.service).Set(float64(state))
+}
@@ -0,0 +1,101 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	backendCode := http.StatusServiceUnavailable
+	requests := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(backendCode)
+	}))
+	defer backend.Close()
+
+	breaker := NewCircuitBreaker("test", 3, time.Minute)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+
+	c := &Client{AuthServiceURL: backend.URL, AuthBreaker: breaker}
+
+	send := func() error {
+		_, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", []byte("upp"))
+		return err
+	}
+
+	// the breaker opens after 3 consecutive failures
+	for i := 0; i < 3; i++ {
+		if breaker.State() != Closed {
+			t.Fatalf("breaker opened after %d failures", i)
+		}
+		if err := send(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if breaker.State() != Open {
+		t.Fatalf("unexpected breaker state: %s", breaker.State())
+	}
+
+	// requests fail fast while the breaker is open
+	if err := send(); err != ErrCircuitOpen {
+		t.Errorf("request was not rejected by open breaker: %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("unexpected number of backend requests: %d", requests)
+	}
+
+	// after the cooldown, a failed probe opens the breaker again
+	now = now.Add(time.Minute)
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("probe request was rejected: %v", err)
+	}
+	if breaker.State() != HalfOpen {
+		t.Fatalf("unexpected breaker state: %s", breaker.State())
+	}
+	if err := breaker.Allow(); err != ErrCircuitOpen {
+		t.Errorf("concurrent request was not rejected while probing: %v", err)
+	}
+	breaker.Record(http.StatusBadGateway, nil)
+	if breaker.State() != Open {
+		t.Fatalf("unexpected breaker state after failed probe: %s", breaker.State())
+	}
+
+	// a successful probe closes the breaker
+	backendCode = http.StatusOK
+	now = now.Add(time.Minute)
+	if err := send(); err != nil {
+		t.Fatal(err)
+	}
+	if breaker.State() != Closed {
+		t.Fatalf("unexpected breaker state after successful probe: %s", breaker.State())
+	}
+
+	// client errors do not count as failures
+	backendCode = http.StatusBadRequest
+	for i := 0; i < 5; i++ {
+		if err := send(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if breaker.State() != Closed {
+		t.Errorf("breaker opened on client errors")
+	}
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	var breaker *CircuitBreaker
+
+	for i := 0; i < 10; i++ {
+		breaker.Record(0, http.ErrHandlerTimeout)
+	}
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("disabled breaker rejected request: %v", err)
+	}
+}
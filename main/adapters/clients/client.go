@@ -16,31 +16,60 @@ package clients
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
 
 	log "github.com/sirupsen/logrus"
 	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+	prom "github.com/ubirch/ubirch-client-go/main/prometheus"
 )
 
 type Client struct {
-	AuthServiceURL     string
-	VerifyServiceURL   string
-	KeyServiceURL      string
-	IdentityServiceURL string
+	AuthServiceURL        string
+	VerifyServiceURL      string
+	KeyServiceURL         string
+	IdentityServiceURL    string
+	BackendRequestTimeout time.Duration       // time after which requests to the ubirch backend will be canceled
+	AuthBreaker           *CircuitBreaker     // circuit breaker for requests to the authentication service, disabled if nil
+	AuthLimiter           *ConcurrencyLimiter // limits the number of concurrent requests to the authentication service, unlimited if nil
+	AuthFailover          *Failover           // fails over to further endpoints of the authentication service, only AuthServiceURL is used if nil
+	VerifyBreaker         *CircuitBreaker     // circuit breaker for requests to the verification service, disabled if nil
+	HTTP                  *http.Client        // reused for all requests to the backend services, a shared default client if nil
+}
+
+// HTTPClient returns the configured HTTP client for requests to the backend services or
+// the shared default client if none was configured
+func (c *Client) HTTPClient() *http.Client {
+	if c == nil || c.HTTP == nil {
+		return defaultHTTPClient
+	}
+	return c.HTTP
+}
+
+// RequestTimeout returns the configured backend request timeout or
+// the default timeout if none was configured
+func (c *Client) RequestTimeout() time.Duration {
+	if c.BackendRequestTimeout <= 0 {
+		return h.BackendRequestTimeout
+	}
+	return c.BackendRequestTimeout
 }
 
 // RequestPublicKeys requests a devices public keys at the identity service
 // returns a list of the retrieved public key certificates
 func (c *Client) RequestPublicKeys(id uuid.UUID) ([]ubirch.SignedKeyRegistration, error) {
 	url := c.KeyServiceURL + "/current/hardwareId/" + id.String()
-	resp, err := http.Get(url)
+	resp, err := c.HTTPClient().Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve public key info: %v", err)
 	}
@@ -92,7 +121,7 @@ func (c *Client) SubmitKeyRegistration(uid uuid.UUID, cert []byte, auth string)
 	keyRegHeader := ubirchHeader(uid, auth)
 	keyRegHeader["content-type"] = "application/json"
 
-	resp, err := Post(c.KeyServiceURL, cert, keyRegHeader)
+	resp, err := c.post(c.KeyServiceURL, cert, keyRegHeader)
 	if err != nil {
 		return fmt.Errorf("error sending key registration: %v", err)
 	}
@@ -103,35 +132,142 @@ func (c *Client) SubmitKeyRegistration(uid uuid.UUID, cert []byte, auth string)
 	return nil
 }
 
+// DeleteKey requests the key service to delete the public key of the identity
+func (c *Client) DeleteKey(uid uuid.UUID, keyDeletion []byte) error {
+	log.Debugf("%s: deleting public key at key service", uid)
+
+	keyDelHeader := map[string]string{"content-type": "application/json"}
+
+	resp, err := send(c.HTTPClient(), http.MethodDelete, c.KeyServiceURL, keyDeletion, keyDelHeader, c.RequestTimeout())
+	if err != nil {
+		return fmt.Errorf("error sending key deletion: %v", err)
+	}
+	if h.HttpFailed(resp.StatusCode) {
+		return fmt.Errorf("key deletion failed: (%d) %q", resp.StatusCode, resp.Content)
+	}
+	log.Debugf("%s: key deletion successful: (%d) %s", uid, resp.StatusCode, string(resp.Content))
+	return nil
+}
+
 // SubmitCSR submits a X.509 Certificate Signing Request for the public key to the identity service
 func (c *Client) SubmitCSR(uid uuid.UUID, csr []byte) error {
+	resp, err := c.SendCSR(uid, csr)
+	if err != nil {
+		return err
+	}
+	if h.HttpFailed(resp.StatusCode) {
+		return fmt.Errorf("request to %s failed: (%d) %q", c.IdentityServiceURL, resp.StatusCode, resp.Content)
+	}
+	return nil
+}
+
+// SendCSR sends a X.509 Certificate Signing Request for the public key to the identity service
+// and returns the response of the identity service
+func (c *Client) SendCSR(uid uuid.UUID, csr []byte) (h.HTTPResponse, error) {
 	log.Debugf("%s: submitting CSR to identity service", uid)
 
 	CSRHeader := map[string]string{"content-type": "application/octet-stream"}
 
-	resp, err := Post(c.IdentityServiceURL, csr, CSRHeader)
+	resp, err := c.post(c.IdentityServiceURL, csr, CSRHeader)
 	if err != nil {
-		return fmt.Errorf("error sending CSR: %v", err)
+		return h.HTTPResponse{}, fmt.Errorf("error sending CSR: %v", err)
 	}
+	log.Debugf("%s: CSR submitted: (%d) %s", uid, resp.StatusCode, string(resp.Content))
+	return resp, nil
+}
+
+// SendToAuthService sends the UPP to the authentication service. The request ID from the context is
+// forwarded in the "X-Request-ID" header. The context is not used to cancel the request.
+func (c *Client) SendToAuthService(ctx context.Context, uid uuid.UUID, auth string, upp []byte) (h.HTTPResponse, error) {
+	// the slot is acquired before asking the circuit breaker, so that a probe request
+	// which was let through by the breaker is actually sent
+	if err := c.AuthLimiter.Acquire(); err != nil {
+		return h.HTTPResponse{}, err
+	}
+	defer c.AuthLimiter.Release()
+
+	if err := c.AuthBreaker.Allow(); err != nil {
+		return h.HTTPResponse{}, err
+	}
+
+	prom.BackendRequestsInFlight.Inc()
+	timer := prometheus.NewTimer(prom.UpstreamResponseDuration)
+	header := ubirchHeader(uid, auth)
+	if requestID := h.GetRequestID(ctx); requestID != "" {
+		header[h.RequestIDHeader] = requestID
+	}
+
+	resp, err := c.postWithFailover(c.authServiceURLs(), upp, header)
+	timer.ObserveDuration()
+	prom.BackendRequestsInFlight.Dec()
+	c.AuthBreaker.Record(resp.StatusCode, err)
+	if err != nil {
+		return h.HTTPResponse{}, err
+	}
+
 	if h.HttpFailed(resp.StatusCode) {
-		return fmt.Errorf("request to %s failed: (%d) %q", c.IdentityServiceURL, resp.StatusCode, resp.Content)
+		prom.BackendErrorResponses.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
 	}
-	log.Debugf("%s: CSR submitted: (%d) %s", uid, resp.StatusCode, string(resp.Content))
-	return nil
+
+	return resp, nil
+}
+
+// authServiceURLs returns the URLs of the authentication service in the order in which they should be tried
+func (c *Client) authServiceURLs() []string {
+	if c.AuthFailover == nil {
+		return []string{c.AuthServiceURL}
+	}
+	return c.AuthFailover.URLs()
+}
+
+// postWithFailover submits the message to the first of the URLs. If the request fails with a connection error
+// or a 5xx response, the same message is submitted to the next URL. A 4xx response is a rejection of the message,
+// which would be the same for every URL, so it is returned without failing over.
+func (c *Client) postWithFailover(urls []string, data []byte, header map[string]string) (resp h.HTTPResponse, err error) {
+	for i, url := range urls {
+		resp, err = c.post(url, data, header)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			c.AuthFailover.Succeeded(url)
+			return resp, nil
+		}
+
+		if i < len(urls)-1 {
+			if err != nil {
+				log.Warnf("request to %s failed: %v, failing over to %s", url, err, urls[i+1])
+			} else {
+				log.Warnf("request to %s failed: (%d), failing over to %s", url, resp.StatusCode, urls[i+1])
+			}
+		}
+	}
+	return resp, err
 }
 
-func (c *Client) SendToAuthService(uid uuid.UUID, auth string, upp []byte) (h.HTTPResponse, error) {
-	return Post(c.AuthServiceURL, upp, ubirchHeader(uid, auth))
+// post submits a message to a backend service with the HTTP client of the client
+// returns the response or encountered errors
+func (c *Client) post(serviceURL string, data []byte, header map[string]string) (h.HTTPResponse, error) {
+	return send(c.HTTPClient(), http.MethodPost, serviceURL, data, header, c.RequestTimeout())
 }
 
-// post submits a message to a backend service
+// Post submits a message to a backend service
 // returns the response or encountered errors
-func Post(serviceURL string, data []byte, header map[string]string) (h.HTTPResponse, error) {
-	client := &http.Client{Timeout: h.BackendRequestTimeout}
+func Post(serviceURL string, data []byte, header map[string]string, timeout time.Duration) (h.HTTPResponse, error) {
+	return send(defaultHTTPClient, http.MethodPost, serviceURL, data, header, timeout)
+}
+
+// Delete sends a delete request with the message to a backend service
+// returns the response or encountered errors
+func Delete(serviceURL string, data []byte, header map[string]string, timeout time.Duration) (h.HTTPResponse, error) {
+	return send(defaultHTTPClient, http.MethodDelete, serviceURL, data, header, timeout)
+}
+
+func send(client *http.Client, method string, serviceURL string, data []byte, header map[string]string, timeout time.Duration) (h.HTTPResponse, error) {
+	// the timeout covers reading the response body, so the context is canceled when the function returns
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	req, err := http.NewRequest(http.MethodPost, serviceURL, bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, method, serviceURL, bytes.NewBuffer(data))
 	if err != nil {
-		return h.HTTPResponse{}, fmt.Errorf("can't make new post request: %v", err)
+		return h.HTTPResponse{}, fmt.Errorf("can't make new %s request: %v", method, err)
 	}
 
 	for k, v := range header {
@@ -0,0 +1,61 @@
+package clients
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultSlotWait is the time a request waits for a free slot before it is rejected
+const DefaultSlotWait = time.Second
+
+// ErrBackendBusy is returned instead of sending a request to a backend service, if the
+// maximum number of concurrent requests is reached and no slot became available in time
+var ErrBackendBusy = fmt.Errorf("backend service busy: maximum number of concurrent requests reached")
+
+// ConcurrencyLimiter is a semaphore which limits the number of concurrent requests to a backend service.
+// A nil *ConcurrencyLimiter does not limit requests.
+type ConcurrencyLimiter struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// NewConcurrencyLimiter returns a limiter which allows max concurrent requests.
+// Requests wait for a free slot for the given duration.
+func NewConcurrencyLimiter(max int, wait time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		slots: make(chan struct{}, max),
+		wait:  wait,
+	}
+}
+
+// Acquire waits for a free slot and returns ErrBackendBusy if none became available in time.
+// The slot must be released with Release after the request was completed.
+func (l *ConcurrencyLimiter) Acquire() error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrBackendBusy
+	}
+}
+
+// Release frees a slot acquired with Acquire
+func (l *ConcurrencyLimiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
@@ -0,0 +1,68 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	release := make(chan struct{})
+	inFlight := make(chan struct{}, 10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	c := &Client{
+		AuthServiceURL: backend.URL,
+		AuthLimiter:    NewConcurrencyLimiter(2, 200*time.Millisecond),
+	}
+
+	// saturate the backend with slow requests
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", []byte("upp"))
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	<-inFlight
+	<-inFlight
+
+	// further requests are rejected after the wait
+	start := time.Now()
+	_, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", []byte("upp"))
+	if err != ErrBackendBusy {
+		t.Errorf("request to saturated backend was not rejected: %v", err)
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Error("request was rejected without waiting for a free slot")
+	}
+
+	// a request gets a slot as soon as one is released
+	result := make(chan error)
+	go func() {
+		_, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", []byte("upp"))
+		result <- err
+	}()
+	release <- struct{}{}
+	<-inFlight
+	close(release)
+
+	if err = <-result; err != nil {
+		t.Errorf("request was rejected after slot was released: %v", err)
+	}
+	wg.Wait()
+}
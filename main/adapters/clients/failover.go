@@ -0,0 +1,84 @@
+package clients
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultFailbackInterval is the time after which requests are sent to the primary endpoint again
+const DefaultFailbackInterval = time.Minute
+
+// Failover distributes the requests to a backend service over several endpoints. Requests are sent to the
+// endpoint which answered last, followed by the other endpoints in the configured order, so that not every
+// request has to wait for the timeout of an unavailable endpoint. After the failback interval, the primary
+// (first) endpoint is tried first again.
+//
+// A nil *Failover has no endpoints.
+type Failover struct {
+	service  string
+	urls     []string
+	failback time.Duration
+
+	active      int
+	activeSince time.Time
+	mutex       *sync.Mutex
+	now         func() time.Time
+}
+
+// NewFailover returns a failover for the service with the primary endpoint as active endpoint
+func NewFailover(service string, urls []string, failback time.Duration) *Failover {
+	return &Failover{
+		service:  service,
+		urls:     urls,
+		failback: failback,
+		mutex:    &sync.Mutex{},
+		now:      time.Now,
+	}
+}
+
+// URLs returns the URLs of the endpoints in the order in which they should be tried
+func (f *Failover) URLs() []string {
+	if f == nil {
+		return nil
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.active != 0 && f.now().Sub(f.activeSince) >= f.failback {
+		f.active = 0
+	}
+
+	urls := make([]string, 0, len(f.urls))
+	urls = append(urls, f.urls[f.active])
+	for i, url := range f.urls {
+		if i != f.active {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// Succeeded makes the endpoint with the URL the active endpoint
+func (f *Failover) Succeeded(url string) {
+	if f == nil {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.urls[f.active] == url {
+		return
+	}
+	for i := range f.urls {
+		if f.urls[i] == url {
+			log.Warnf("%s: failed over to %s", f.service, url)
+			f.active = i
+			f.activeSince = f.now()
+			return
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package clients
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testEndpoint is a backend endpoint which responds with the status code and counts the requests
+type testEndpoint struct {
+	*httptest.Server
+	requests int32
+	body     []byte
+}
+
+func newTestEndpoint(statusCode int) *testEndpoint {
+	e := &testEndpoint{}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&e.requests, 1)
+		e.body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(statusCode)
+	}))
+	return e
+}
+
+func TestClient_Failover(t *testing.T) {
+	primary := newTestEndpoint(http.StatusServiceUnavailable)
+	defer primary.Close()
+	secondary := newTestEndpoint(http.StatusOK)
+	defer secondary.Close()
+
+	failover := NewFailover("test", []string{primary.URL, secondary.URL}, time.Minute)
+	now := time.Now()
+	failover.now = func() time.Time { return now }
+
+	c := &Client{AuthServiceURL: primary.URL, AuthFailover: failover}
+	upp := []byte("upp")
+
+	send := func() {
+		resp, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", upp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected response code: expected %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	send()
+	if primary.requests != 1 || secondary.requests != 1 {
+		t.Fatalf("unexpected number of requests: primary %d, secondary %d", primary.requests, secondary.requests)
+	}
+	if string(secondary.body) != string(upp) {
+		t.Errorf("UPP was not preserved: %q", secondary.body)
+	}
+
+	// the healthy secondary endpoint is tried first
+	send()
+	if primary.requests != 1 || secondary.requests != 2 {
+		t.Fatalf("unexpected number of requests: primary %d, secondary %d", primary.requests, secondary.requests)
+	}
+
+	// the primary endpoint is tried first again after the failback interval
+	now = now.Add(time.Minute)
+	send()
+	if primary.requests != 2 || secondary.requests != 3 {
+		t.Fatalf("unexpected number of requests: primary %d, secondary %d", primary.requests, secondary.requests)
+	}
+}
+
+func TestClient_FailoverConnectionError(t *testing.T) {
+	primary := newTestEndpoint(http.StatusOK)
+	primary.Close()
+	secondary := newTestEndpoint(http.StatusOK)
+	defer secondary.Close()
+
+	c := &Client{
+		AuthServiceURL: primary.URL,
+		AuthFailover:   NewFailover("test", []string{primary.URL, secondary.URL}, time.Minute),
+	}
+
+	resp, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", []byte("upp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || secondary.requests != 1 {
+		t.Errorf("no failover on connection error: (%d), %d requests to secondary", resp.StatusCode, secondary.requests)
+	}
+}
+
+func TestClient_NoFailoverOnClientError(t *testing.T) {
+	primary := newTestEndpoint(http.StatusBadRequest)
+	defer primary.Close()
+	secondary := newTestEndpoint(http.StatusOK)
+	defer secondary.Close()
+
+	c := &Client{
+		AuthServiceURL: primary.URL,
+		AuthFailover:   NewFailover("test", []string{primary.URL, secondary.URL}, time.Minute),
+	}
+
+	resp, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", []byte("upp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if secondary.requests != 0 {
+		t.Error("failed over on client error")
+	}
+}
+
+func TestClient_FailoverAllEndpointsFail(t *testing.T) {
+	primary := newTestEndpoint(http.StatusInternalServerError)
+	defer primary.Close()
+	secondary := newTestEndpoint(http.StatusBadGateway)
+	defer secondary.Close()
+
+	failover := NewFailover("test", []string{primary.URL, secondary.URL}, time.Minute)
+	c := &Client{AuthServiceURL: primary.URL, AuthFailover: failover}
+
+	resp, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", []byte("upp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusBadGateway, resp.StatusCode)
+	}
+
+	// the active endpoint does not change, if no endpoint answered
+	if urls := failover.URLs(); urls[0] != primary.URL {
+		t.Errorf("unexpected active endpoint: %s", urls[0])
+	}
+}
@@ -0,0 +1,65 @@
+package clients
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultMaxIdleConns        = 100
+	DefaultMaxIdleConnsPerHost = 100
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// defaultHTTPClient is used for requests of clients without a configured HTTP client and for callbacks
+var defaultHTTPClient = NewHTTPClient(TransportParams{})
+
+// TransportParams are the settings of the connection pool for requests to the ubirch backend,
+// the default is used for each setting which is not set
+type TransportParams struct {
+	MaxIdleConns        int           // maximum number of idle connections across all hosts
+	MaxIdleConnsPerHost int           // maximum number of idle connections per host
+	IdleConnTimeout     time.Duration // time after which an idle connection is closed
+
+	// Proxy is the proxy for all requests. If nil, the proxy is taken from the
+	// environment variables HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+	Proxy *url.URL
+	// RootCAs are the CAs which are trusted for TLS connections, the system CAs if nil
+	RootCAs *x509.CertPool
+}
+
+// NewHTTPClient returns a HTTP client which keeps the connections to the backend services alive and reuses
+// them across requests, so that not every request has to establish a new (TLS) connection. The client has
+// no timeout, requests are canceled with the backend request timeout of the Client.
+func NewHTTPClient(params TransportParams) *http.Client {
+	params.setDefaults()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = params.MaxIdleConns
+	transport.MaxIdleConnsPerHost = params.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = params.IdleConnTimeout
+
+	if params.Proxy != nil {
+		transport.Proxy = http.ProxyURL(params.Proxy)
+	}
+	if params.RootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: params.RootCAs}
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+func (p *TransportParams) setDefaults() {
+	if p.MaxIdleConns <= 0 {
+		p.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if p.MaxIdleConnsPerHost <= 0 {
+		p.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	if p.IdleConnTimeout <= 0 {
+		p.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+}
@@ -0,0 +1,159 @@
+package clients
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestClient_ReusesConnections(t *testing.T) {
+	var newConns int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&newConns, 1)
+		}
+	}
+	backend.StartTLS()
+	defer backend.Close()
+
+	c := &Client{AuthServiceURL: backend.URL, HTTP: newTestHTTPClient(backend)}
+
+	for i := 0; i < 10; i++ {
+		resp, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", []byte("upp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected response code: %d", resp.StatusCode)
+		}
+	}
+
+	if n := atomic.LoadInt32(&newConns); n != 1 {
+		t.Errorf("connection was not reused: %d connections for 10 requests", n)
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	client := NewHTTPClient(TransportParams{MaxIdleConnsPerHost: 10})
+
+	transport := client.Transport.(*http.Transport)
+	if transport.MaxIdleConns != DefaultMaxIdleConns {
+		t.Errorf("unexpected max idle connections: %d", transport.MaxIdleConns)
+	}
+	if transport.MaxIdleConnsPerHost != 10 {
+		t.Errorf("unexpected max idle connections per host: %d", transport.MaxIdleConnsPerHost)
+	}
+	if transport.IdleConnTimeout != DefaultIdleConnTimeout {
+		t.Errorf("unexpected idle connection timeout: %s", transport.IdleConnTimeout)
+	}
+	if transport.DisableKeepAlives {
+		t.Error("keep-alives are disabled")
+	}
+}
+
+func TestClient_Proxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request was sent to the backend directly")
+	}))
+	defer backend.Close()
+
+	// the stub proxy answers the requests itself instead of forwarding them to the backend
+	var proxiedHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHost = r.URL.Host
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Client{AuthServiceURL: backend.URL, HTTP: NewHTTPClient(TransportParams{Proxy: proxyURL})}
+
+	resp, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", []byte("upp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	if proxiedHost != backend.Listener.Addr().String() {
+		t.Errorf("unexpected host of proxied request: expected %s, got %s", backend.Listener.Addr(), proxiedHost)
+	}
+}
+
+func TestClient_RootCAs(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	// the certificate of the test server is not trusted by the system CAs
+	c := &Client{AuthServiceURL: backend.URL, HTTP: NewHTTPClient(TransportParams{})}
+	if _, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", []byte("upp")); err == nil {
+		t.Error("request with untrusted certificate did not fail")
+	}
+
+	c.HTTP = newTestHTTPClient(backend)
+	resp, err := c.SendToAuthService(context.Background(), uuid.New(), "auth", []byte("upp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+}
+
+// BenchmarkSend compares sending requests with a new transport per request, which has to establish
+// a new TLS connection for each request, with the shared HTTP client, which reuses the connection
+func BenchmarkSend(b *testing.B) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	header := map[string]string{"content-type": "application/octet-stream"}
+
+	b.Run("new transport per request", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			client := newTestHTTPClient(backend)
+			_, err := send(client, http.MethodPost, backend.URL, []byte("upp"), header, time.Second)
+			if err != nil {
+				b.Fatal(err)
+			}
+			client.CloseIdleConnections()
+		}
+	})
+
+	b.Run("shared client", func(b *testing.B) {
+		client := newTestHTTPClient(backend)
+		defer client.CloseIdleConnections()
+
+		for i := 0; i < b.N; i++ {
+			_, err := send(client, http.MethodPost, backend.URL, []byte("upp"), header, time.Second)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// newTestHTTPClient returns a HTTP client which trusts the certificate of the test server
+func newTestHTTPClient(backend *httptest.Server) *http.Client {
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(backend.Certificate())
+	return NewHTTPClient(TransportParams{RootCAs: rootCAs})
+}
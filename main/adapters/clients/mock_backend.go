@@ -0,0 +1,251 @@
+package clients
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	MockBackendChain = "MOCK_BACKEND" // name of the blockchain of the anchors which are returned by the mock backend
+	maxMockUPPs      = 100000         // maximum number of UPPs which are kept for verification, the oldest are forgotten first
+)
+
+// MockBackendUUID is the UUID of the mock backend, with which the backend response UPPs are signed
+var MockBackendUUID = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://mock.ubirch.com"))
+
+// MockBackend answers the requests of a Client to the UBIRCH backend services locally, so that the full
+// HTTP path can be exercised by integration tests without a connection to the UBIRCH backend:
+//   - the authentication service acknowledges valid UPPs with a backend response UPP, which contains a request ID
+//     derived from the UPP, so that the same UPP always gets the same request ID
+//   - the verification service returns the acknowledged UPPs, with a mock blockchain anchor
+//   - the key service and identity service accept all registrations, deletions and CSRs
+//
+// It is used as the transport of the HTTP client of the Client. UPPs are never anchored, so it must not be
+// used in production.
+type MockBackend struct {
+	client     *Client
+	crypto     *ubirch.ECDSACryptoContext
+	privKeyPEM []byte
+
+	upps  map[string]mockUPP // acknowledged UPPs by their base64 encoded hash
+	order []string           // hashes of the acknowledged UPPs in the order in which they were acknowledged
+	mutex *sync.RWMutex
+}
+
+type mockUPP struct {
+	upp       []byte
+	requestID uuid.UUID
+	timestamp time.Time
+}
+
+// NewMockBackend returns a mock backend for the service URLs of the client. Set it as the transport of
+// the HTTP client of the client to answer the requests to the backend services.
+func NewMockBackend(c *Client) (*MockBackend, error) {
+	crypto := &ubirch.ECDSACryptoContext{}
+	privKeyPEM, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("generating key of mock backend failed: %v", err)
+	}
+
+	return &MockBackend{
+		client:     c,
+		crypto:     crypto,
+		privKeyPEM: privKeyPEM,
+		upps:       map[string]mockUPP{},
+		mutex:      &sync.RWMutex{},
+	}, nil
+}
+
+// RoundTrip answers a request to one of the backend services of the client
+func (m *MockBackend) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	url := req.URL.String()
+	switch {
+	case m.isAuthService(url) && req.Method == http.MethodPost:
+		return m.authenticate(req, body), nil
+	case url == m.client.VerifyServiceURL+"/anchor" && req.Method == http.MethodPost:
+		return m.verify(req, body, true), nil
+	case url == m.client.VerifyServiceURL && req.Method == http.MethodPost:
+		return m.verify(req, body, false), nil
+	case url == m.client.KeyServiceURL && (req.Method == http.MethodPost || req.Method == http.MethodDelete):
+		return mockResponse(req, http.StatusOK, "application/json", body), nil
+	case url == m.client.IdentityServiceURL && req.Method == http.MethodPost:
+		return mockResponse(req, http.StatusOK, "text/plain", nil), nil
+	default:
+		// also public key lookups, which are answered as if no key was registered
+		return mockResponse(req, http.StatusNotFound, "text/plain", []byte(http.StatusText(http.StatusNotFound))), nil
+	}
+}
+
+func (m *MockBackend) isAuthService(url string) bool {
+	for _, u := range m.client.authServiceURLs() {
+		if url == u {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate acknowledges a UPP with a backend response UPP, which is chained to the UPP and contains the request ID
+func (m *MockBackend) authenticate(req *http.Request, upp []byte) *http.Response {
+	uppStruct, err := ubirch.Decode(upp)
+	if err != nil {
+		return mockResponse(req, http.StatusBadRequest, "text/plain", []byte(fmt.Sprintf("invalid UPP: %v", err)))
+	}
+	if req.Header.Get("x-ubirch-hardware-id") != uppStruct.GetUuid().String() {
+		return mockResponse(req, http.StatusBadRequest, "text/plain", []byte("UUID of UPP does not match X-Ubirch-Hardware-Id header"))
+	}
+	if req.Header.Get("x-ubirch-credential") == "" {
+		return mockResponse(req, http.StatusUnauthorized, "text/plain", []byte(http.StatusText(http.StatusUnauthorized)))
+	}
+
+	requestID := uuid.NewSHA1(MockBackendUUID, upp)
+	hash := base64.StdEncoding.EncodeToString(uppStruct.GetPayload())
+
+	switch uppStruct.GetHint() {
+	case ubirch.Binary:
+		if conflict := m.store(hash, mockUPP{upp: upp, requestID: requestID, timestamp: time.Now().UTC()}); conflict {
+			return mockResponse(req, http.StatusConflict, "text/plain", []byte("hash already exists"))
+		}
+	case ubirch.Delete:
+		m.forget(hash)
+	}
+
+	respUPP, err := m.sign(&ubirch.ChainedUPP{
+		Version:       ubirch.Chained,
+		Uuid:          MockBackendUUID,
+		PrevSignature: uppStruct.GetSignature(),
+		Hint:          ubirch.Binary,
+		Payload:       requestID[:],
+	})
+	if err != nil {
+		log.Errorf("mock backend: signing response UPP failed: %v", err)
+		return mockResponse(req, http.StatusInternalServerError, "text/plain", []byte(http.StatusText(http.StatusInternalServerError)))
+	}
+	return mockResponse(req, http.StatusOK, "application/octet-stream", respUPP)
+}
+
+// store stores an acknowledged UPP and returns true if a different UPP with the same hash was acknowledged before.
+// The same UPP can be sent again, e.g. if the response got lost.
+func (m *MockBackend) store(hash string, u mockUPP) (conflict bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if stored, found := m.upps[hash]; found {
+		return !bytes.Equal(stored.upp, u.upp)
+	}
+
+	if len(m.order) >= maxMockUPPs {
+		delete(m.upps, m.order[0])
+		m.order = m.order[1:]
+	}
+	m.upps[hash] = u
+	m.order = append(m.order, hash)
+	return false
+}
+
+func (m *MockBackend) forget(hash string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, found := m.upps[hash]; !found {
+		return
+	}
+	delete(m.upps, hash)
+	for i, h := range m.order {
+		if h == hash {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (m *MockBackend) load(hash string) (mockUPP, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	u, found := m.upps[hash]
+	return u, found
+}
+
+// sign encodes and signs a UPP. The payload of the UPP may have any size, unlike with ubirch.Protocol.Sign.
+func (m *MockBackend) sign(upp ubirch.UPP) ([]byte, error) {
+	encoded, err := ubirch.Encode(upp)
+	if err != nil {
+		return nil, err
+	}
+	data := encoded[:len(encoded)-1] // cut off the empty signature
+
+	signature, err := m.crypto.Sign(m.privKeyPEM, data)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, 0xC4, byte(len(signature)))
+	return append(data, signature...), nil
+}
+
+// verify returns the acknowledged UPP which contains the base64 encoded hash in the request body
+// and, if requested, a mock blockchain anchor of the UPP
+func (m *MockBackend) verify(req *http.Request, body []byte, withAnchors bool) *http.Response {
+	u, found := m.load(strings.TrimSpace(string(body)))
+	if !found {
+		return mockResponse(req, http.StatusNotFound, "text/plain", []byte(http.StatusText(http.StatusNotFound)))
+	}
+
+	var v interface{}
+	if withAnchors {
+		anchor := map[string]interface{}{
+			"label": "PUBLIC_CHAIN",
+			"properties": map[string]string{
+				"public_chain": MockBackendChain,
+				"hash":         hex.EncodeToString(u.requestID[:]),
+				"timestamp":    u.timestamp.Format(time.RFC3339),
+			},
+		}
+		v = map[string]interface{}{"upp": u.upp, "anchors": []interface{}{anchor}}
+	} else {
+		v = map[string]interface{}{"upp": u.upp, "prev": nil, "anchors": nil}
+	}
+
+	respBody, err := json.Marshal(v)
+	if err != nil {
+		return mockResponse(req, http.StatusInternalServerError, "text/plain", []byte(err.Error()))
+	}
+	return mockResponse(req, http.StatusOK, "application/json", respBody)
+}
+
+func mockResponse(req *http.Request, code int, contentType string, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {contentType}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,133 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+)
+
+func newMockClient(t *testing.T) (*Client, *MockBackend) {
+	c := &Client{
+		AuthServiceURL:     "https://niomon.dev.ubirch.com/",
+		VerifyServiceURL:   "https://verify.dev.ubirch.com/api/upp/verify",
+		KeyServiceURL:      "https://identity.dev.ubirch.com/api/keyService/v1/pubkey",
+		IdentityServiceURL: "https://identity.dev.ubirch.com/api/certs/v1/csr/register",
+	}
+	mock, err := NewMockBackend(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.HTTP = &http.Client{Transport: mock}
+	return c, mock
+}
+
+func newMockUPP(t *testing.T, uid uuid.UUID, hint ubirch.Hint, payload []byte) []byte {
+	p := &ubirch.Protocol{Crypto: &ubirch.ECDSACryptoContext{}}
+	privKeyPEM, err := p.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	upp, err := p.Sign(privKeyPEM, &ubirch.SignedUPP{Version: ubirch.Signed, Uuid: uid, Hint: hint, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return upp
+}
+
+func TestMockBackend_Authenticate(t *testing.T) {
+	c, mock := newMockClient(t)
+	ctx := context.Background()
+	uid := uuid.New()
+	hash := bytes.Repeat([]byte{1}, 32)
+	upp := newMockUPP(t, uid, ubirch.Binary, hash)
+
+	resp, err := c.SendToAuthService(ctx, uid, "password", upp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response: (%d) %s", resp.StatusCode, resp.Content)
+	}
+
+	// the backend response UPP is signed by the mock backend, chained to the UPP and contains the request ID
+	backendUPP, err := ubirch.DecodeChained(resp.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestID := uuid.NewSHA1(MockBackendUUID, upp)
+	if backendUPP.Uuid != MockBackendUUID || !bytes.Equal(backendUPP.Payload, requestID[:]) {
+		t.Errorf("unexpected backend response UPP: %+v", backendUPP)
+	}
+	if !bytes.Equal(backendUPP.PrevSignature, upp[len(upp)-64:]) {
+		t.Error("backend response UPP is not chained to the UPP")
+	}
+	pubKeyPEM, err := mock.crypto.GetPublicKeyFromPrivateKey(mock.privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ubirch.Protocol{Crypto: mock.crypto}
+	if verified, err := p.Verify(pubKeyPEM, resp.Content); !verified {
+		t.Errorf("signature of backend response UPP is invalid: %v", err)
+	}
+
+	// the same UPP is acknowledged again with the same response, another UPP with the same hash is rejected
+	again, err := c.SendToAuthService(ctx, uid, "password", upp)
+	if err != nil || again.StatusCode != http.StatusOK {
+		t.Errorf("resent UPP was not acknowledged: (%d) %v", again.StatusCode, err)
+	}
+	if duplicate, _ := c.SendToAuthService(ctx, uid, "password", newMockUPP(t, uid, ubirch.Binary, hash)); duplicate.StatusCode != http.StatusConflict {
+		t.Errorf("unexpected response code for duplicate hash: %d", duplicate.StatusCode)
+	}
+
+	// after deletion, the hash can be anchored again
+	if resp, _ = c.SendToAuthService(ctx, uid, "password", newMockUPP(t, uid, ubirch.Delete, hash)); resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected response code for deletion: %d", resp.StatusCode)
+	}
+	if resp, _ = c.SendToAuthService(ctx, uid, "password", newMockUPP(t, uid, ubirch.Binary, hash)); resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected response code for hash after deletion: %d", resp.StatusCode)
+	}
+
+	// invalid requests are rejected
+	if resp, _ = c.SendToAuthService(ctx, uid, "password", []byte("not a UPP")); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected response code for invalid UPP: %d", resp.StatusCode)
+	}
+	if resp, _ = c.SendToAuthService(ctx, uuid.New(), "password", upp); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected response code for UUID mismatch: %d", resp.StatusCode)
+	}
+}
+
+func TestMockBackend_Failover(t *testing.T) {
+	c, _ := newMockClient(t)
+	c.AuthFailover = NewFailover("niomon", []string{"https://niomon.dev.ubirch.com/", "https://niomon-2.dev.ubirch.com/"}, DefaultFailbackInterval)
+
+	for i, url := range c.AuthFailover.URLs() {
+		uid := uuid.New()
+		resp, err := c.post(url, newMockUPP(t, uid, ubirch.Binary, bytes.Repeat([]byte{byte(i)}, 32)), ubirchHeader(uid, "password"))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: unexpected response: (%d) %v", url, resp.StatusCode, err)
+		}
+	}
+}
+
+func TestMockBackend_KeyService(t *testing.T) {
+	c, _ := newMockClient(t)
+	uid := uuid.New()
+
+	if err := c.SubmitKeyRegistration(uid, []byte(`{}`), "password"); err != nil {
+		t.Error(err)
+	}
+	if err := c.DeleteKey(uid, []byte(`{}`)); err != nil {
+		t.Error(err)
+	}
+	if err := c.SubmitCSR(uid, []byte("CSR")); err != nil {
+		t.Error(err)
+	}
+	keys, err := c.RequestPublicKeys(uid)
+	if err != nil || len(keys) != 0 {
+		t.Errorf("unexpected public keys: %v, %v", keys, err)
+	}
+}
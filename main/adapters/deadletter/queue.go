@@ -0,0 +1,208 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	prom "github.com/ubirch/ubirch-client-go/main/prometheus"
+)
+
+const filePerm = 0644
+
+// ErrNotFound is returned if there is no entry with the requested ID
+var ErrNotFound = fmt.Errorf("dead letter not found")
+
+// Entry is a UPP which could not be delivered to the ubirch backend
+type Entry struct {
+	ID        uuid.UUID `json:"id"`
+	UID       uuid.UUID `json:"uuid"`
+	Operation string    `json:"operation"`
+	UPP       []byte    `json:"upp"`
+	Created   time.Time `json:"created"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError"`
+}
+
+// Queue keeps the UPPs which could not be delivered to the ubirch backend in the order in which
+// they were added. The entries are persisted to a file, so that they survive a restart.
+type Queue struct {
+	file    string
+	entries []Entry
+	mutex   *sync.Mutex
+}
+
+// NewQueue loads the entries of a previous run from the file
+func NewQueue(file string) (*Queue, error) {
+	q := &Queue{
+		file:  file,
+		mutex: &sync.Mutex{},
+	}
+
+	err := q.load()
+	if err != nil {
+		return nil, err
+	}
+	prom.QueuedUPPs.Set(float64(len(q.entries)))
+	return q, nil
+}
+
+// Add appends a UPP to the queue
+func (q *Queue) Add(uid uuid.UUID, op string, upp []byte, lastError string) (Entry, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	e := Entry{
+		ID:        uuid.New(),
+		UID:       uid,
+		Operation: op,
+		UPP:       upp,
+		Created:   time.Now().UTC(),
+		Attempts:  1,
+		LastError: lastError,
+	}
+	q.entries = append(q.entries, e)
+
+	err := q.persist()
+	if err != nil {
+		q.entries = q.entries[:len(q.entries)-1]
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// List returns all entries in the order in which they were added
+func (q *Queue) List() []Entry {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return append([]Entry{}, q.entries...)
+}
+
+// Len returns the number of entries
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.entries)
+}
+
+// Get returns the entry with the given ID
+func (q *Queue) Get(id uuid.UUID) (Entry, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	i := q.index(id)
+	if i < 0 {
+		return Entry{}, ErrNotFound
+	}
+	return q.entries[i], nil
+}
+
+// Has returns true if there are entries of the identity
+func (q *Queue) Has(uid uuid.UUID) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, e := range q.entries {
+		if e.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// Heads returns the oldest entry of each identity
+func (q *Queue) Heads() []Entry {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var heads []Entry
+	seen := map[uuid.UUID]bool{}
+	for _, e := range q.entries {
+		if !seen[e.UID] {
+			seen[e.UID] = true
+			heads = append(heads, e)
+		}
+	}
+	return heads
+}
+
+// Remove removes the entry with the given ID
+func (q *Queue) Remove(id uuid.UUID) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	i := q.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	q.entries = append(q.entries[:i], q.entries[i+1:]...)
+	return q.persist()
+}
+
+// Failed registers a failed delivery attempt of the entry with the given ID
+func (q *Queue) Failed(id uuid.UUID, lastError string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	i := q.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	q.entries[i].Attempts++
+	q.entries[i].LastError = lastError
+	return q.persist()
+}
+
+func (q *Queue) index(id uuid.UUID) int {
+	for i, e := range q.entries {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *Queue) load() error {
+	data, err := ioutil.ReadFile(q.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read dead letter file: %v", err)
+	}
+
+	err = json.Unmarshal(data, &q.entries)
+	if err != nil {
+		return fmt.Errorf("unable to parse dead letter file %s: %v", q.file, err)
+	}
+	return nil
+}
+
+// persist writes all entries to the file. The file is replaced atomically,
+// so that a crash can not leave a partially written file behind.
+func (q *Queue) persist() error {
+	data, err := json.Marshal(q.entries)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := q.file + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, filePerm)
+	if err != nil {
+		return fmt.Errorf("unable to write dead letter file: %v", err)
+	}
+	err = os.Rename(tmpFile, q.file)
+	if err != nil {
+		return err
+	}
+
+	prom.QueuedUPPs.Set(float64(len(q.entries)))
+	return nil
+}
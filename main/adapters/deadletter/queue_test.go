@@ -0,0 +1,76 @@
+package deadletter
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestQueue(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dead_letters.json")
+
+	q, err := NewQueue(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uidA, uidB := uuid.New(), uuid.New()
+
+	a1, err := q.Add(uidA, "chain", []byte("a1"), "(503) unavailable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = q.Add(uidB, "chain", []byte("b1"), "(503) unavailable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := q.Add(uidA, "chain", []byte("a2"), "(503) unavailable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if q.Len() != 3 {
+		t.Errorf("unexpected number of entries: %d", q.Len())
+	}
+	if !q.Has(uidA) || q.Has(uuid.New()) {
+		t.Error("unexpected result of Has")
+	}
+
+	err = q.Failed(a1.ID, "(500) internal error")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a restart: queued entries must survive in order
+	q, err = NewQueue(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	heads := q.Heads()
+	if len(heads) != 2 || heads[0].ID != a1.ID || heads[1].UID != uidB {
+		t.Fatalf("unexpected heads: %v", heads)
+	}
+	if heads[0].Attempts != 2 || heads[0].LastError != "(500) internal error" {
+		t.Errorf("failed attempt was not registered: %d, %s", heads[0].Attempts, heads[0].LastError)
+	}
+	if string(heads[0].UPP) != "a1" {
+		t.Errorf("unexpected UPP: %s", heads[0].UPP)
+	}
+
+	err = q.Remove(a1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if heads = q.Heads(); len(heads) != 2 || heads[1].ID != a2.ID {
+		t.Errorf("next entry of identity is not the head: %v", heads)
+	}
+
+	if err = q.Remove(a1.ID); err != ErrNotFound {
+		t.Errorf("unexpected error removing unknown entry: %v", err)
+	}
+	if _, err = q.Get(a1.ID); err != ErrNotFound {
+		t.Errorf("unexpected error getting unknown entry: %v", err)
+	}
+}
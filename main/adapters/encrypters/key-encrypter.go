@@ -1,12 +1,17 @@
 package encrypters
 
 import (
+	"encoding/pem"
 	"fmt"
 
 	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
 	"github.com/youmark/pkcs8"
 )
 
+// KeyReferencePEMType is the PEM type of references to private keys which are held by the crypto backend itself,
+// e.g. by AWS KMS or an HSM. A key reference is not secret and is stored as-is instead of an encrypted private key.
+const KeyReferencePEMType = "UBIRCH KEY REFERENCE"
+
 type KeyEncrypter struct {
 	Secret []byte
 	Crypto ubirch.Crypto
@@ -25,6 +30,10 @@ func NewKeyEncrypter(secret []byte, crypto ubirch.Crypto) (*KeyEncrypter, error)
 // Encrypt takes a PEM-encoded private key, AES256-encrypts it using a 32 byte secret
 // and returns the encrypted DER-encoded PKCS#8 private key
 func (enc *KeyEncrypter) Encrypt(privateKeyPem []byte) ([]byte, error) {
+	if IsKeyReference(privateKeyPem) {
+		return privateKeyPem, nil
+	}
+
 	privateKey, err := enc.Crypto.DecodePrivateKey(privateKeyPem)
 	if err != nil {
 		return nil, err
@@ -35,9 +44,20 @@ func (enc *KeyEncrypter) Encrypt(privateKeyPem []byte) ([]byte, error) {
 // Decrypt takes a AES256-encrypted DER-encoded PKCS#8 private key, decrypts it
 // using a 32 byte secret and returns the decrypted PEM-encoded private key
 func (enc *KeyEncrypter) Decrypt(encryptedPrivateKey []byte) (privateKeyPem []byte, err error) {
+	if IsKeyReference(encryptedPrivateKey) {
+		return encryptedPrivateKey, nil
+	}
+
 	privateKey, err := pkcs8.ParsePKCS8PrivateKey(encryptedPrivateKey, enc.Secret)
 	if err != nil {
 		return nil, err
 	}
 	return enc.Crypto.EncodePrivateKey(privateKey)
 }
+
+// IsKeyReference returns true if the PEM encoded private key is a reference to
+// a private key which is held by the crypto backend
+func IsKeyReference(privateKeyPem []byte) bool {
+	block, _ := pem.Decode(privateKeyPem)
+	return block != nil && block.Type == KeyReferencePEMType
+}
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
+	"github.com/ubirch/ubirch-client-go/main/adapters/jobs"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const (
+	CallbackURLHeader = "X-Callback-URL"
+	JobIDHeader       = "X-Job-ID"
+	JobIDKey          = "jobID"
+	JobsPath          = "jobs"
+
+	asyncWorkers = 4
+
+	DefaultAsyncDrainTimeout = 20 * time.Second // time to process the queued jobs on shutdown before they are suspended
+)
+
+var (
+	ErrQueueFull    = fmt.Errorf("async signing queue is full")
+	ErrShuttingDown = fmt.Errorf("async signing queue does not accept new jobs: shutting down")
+)
+
+type asyncJob struct {
+	id          uuid.UUID
+	msg         h.HTTPRequest
+	op          operation
+	callbackURL string
+}
+
+// asyncJobPayload is the persisted representation of a job which was suspended on shutdown.
+// The auth token of the request is not persisted, the request was authenticated before the job was
+// queued, and the job is resumed with the auth token of the identity.
+type asyncJobPayload struct {
+	UID         uuid.UUID `json:"uuid"`
+	Hash        []byte    `json:"hash"`
+	Hint        *uint8    `json:"hint,omitempty"`
+	Operation   operation `json:"operation"`
+	CallbackURL string    `json:"callbackURL"`
+}
+
+type jobResponse struct {
+	JobID  uuid.UUID   `json:"jobID"`
+	Status jobs.Status `json:"status"`
+}
+
+// AsyncSigner signs and sends UPPs to the ubirch backend in the background and delivers
+// the signing response to a callback URL. Jobs are buffered in a queue of fixed size which
+// is processed by a fixed number of workers, so a burst of requests can not spawn an unbounded
+// number of go routines.
+//
+// On shutdown, the queue stops accepting new jobs and the queued jobs are processed until the
+// queue is drained or the drain timeout elapses. The remaining jobs are suspended, i.e. persisted
+// in the job store, and resumed on the next start.
+//
+// Callbacks are only delivered to the allowed callback URLs, so that the client can not be used to
+// send requests to arbitrary hosts in its network.
+type AsyncSigner struct {
+	*Signer
+	Jobs                *jobs.Store
+	DrainTimeout        time.Duration // time to process the queued jobs on shutdown before they are suspended
+	AllowedCallbackURLs []*url.URL    // a callback URL must have the same scheme and host and a path below the path of one of them
+	queue               chan asyncJob
+	closed              bool
+	mutex               *sync.Mutex
+	suspending          chan struct{} // closed when the drain timeout elapsed
+	workers             *sync.WaitGroup
+	done                chan struct{} // closed when the queue was drained or suspended on shutdown
+}
+
+func NewAsyncSigner(signer *Signer, store *jobs.Store, queueSize int) *AsyncSigner {
+	return &AsyncSigner{
+		Signer:       signer,
+		Jobs:         store,
+		DrainTimeout: DefaultAsyncDrainTimeout,
+		queue:        make(chan asyncJob, queueSize),
+		mutex:        &sync.Mutex{},
+		suspending:   make(chan struct{}),
+		workers:      &sync.WaitGroup{},
+		done:         make(chan struct{}),
+	}
+}
+
+// Start starts the workers which process the queue and resumes the jobs which were suspended on
+// the last shutdown. When the context is canceled, the queue is drained.
+func (a *AsyncSigner) Start(ctx context.Context) {
+	for i := 0; i < asyncWorkers; i++ {
+		a.workers.Add(1)
+		go func() {
+			defer a.workers.Done()
+			for job := range a.queue {
+				select {
+				case <-a.suspending:
+					a.suspend(job)
+				default:
+					a.process(job)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		a.drain()
+	}()
+
+	a.resume()
+}
+
+// Wait blocks until the queue was drained or suspended after the context passed to Start was canceled
+func (a *AsyncSigner) Wait() {
+	<-a.done
+}
+
+// Enqueue adds a signing job to the queue and returns the job ID.
+// Returns ErrQueueFull if the queue has no capacity left and ErrShuttingDown on shutdown.
+func (a *AsyncSigner) Enqueue(msg h.HTTPRequest, op operation, callbackURL string) (uuid.UUID, error) {
+	job := asyncJob{
+		id:          uuid.New(),
+		msg:         msg,
+		op:          op,
+		callbackURL: callbackURL,
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.closed {
+		return uuid.Nil, ErrShuttingDown
+	}
+
+	err := a.Jobs.Add(job.id, msg.ID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	select {
+	case a.queue <- job:
+		return job.id, nil
+	default:
+		_ = a.Jobs.Finish(job.id, jobs.Failed)
+		return uuid.Nil, ErrQueueFull
+	}
+}
+
+// drain stops accepting new jobs and waits for the workers to process the queued jobs.
+// Jobs which are still queued when the drain timeout elapses are suspended.
+func (a *AsyncSigner) drain() {
+	defer close(a.done)
+
+	a.mutex.Lock()
+	a.closed = true
+	close(a.queue)
+	a.mutex.Unlock()
+
+	log.Infof("draining async signing queue: %d jobs", len(a.queue))
+
+	drained := make(chan struct{})
+	go func() {
+		a.workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Debug("drained async signing queue")
+	case <-time.After(a.DrainTimeout):
+		log.Warnf("async signing queue not drained within %s, suspending %d jobs", a.DrainTimeout, len(a.queue))
+		close(a.suspending)
+		<-drained
+	}
+}
+
+// resume adds the jobs which were suspended on the last shutdown to the queue
+func (a *AsyncSigner) resume() {
+	suspended, err := a.Jobs.Resume()
+	if err != nil {
+		log.Errorf("could not update job store: %v", err)
+	}
+
+	for _, s := range suspended {
+		var payload asyncJobPayload
+		err = json.Unmarshal(s.Payload, &payload)
+		if err != nil {
+			log.Errorf("could not resume job %s: %v", s.ID, err)
+			_ = a.Jobs.Finish(s.ID, jobs.Failed)
+			continue
+		}
+
+		if !a.isAllowedCallbackURL(payload.CallbackURL) {
+			log.Errorf("%s: could not resume job %s: callback URL is not allowed anymore", payload.UID, s.ID)
+			_ = a.Jobs.Finish(s.ID, jobs.Failed)
+			continue
+		}
+
+		auth, err := a.getAuth(payload.UID)
+		if err != nil {
+			log.Errorf("%s: could not resume job %s: %v", payload.UID, s.ID, err)
+			_ = a.Jobs.Finish(s.ID, jobs.Failed)
+			continue
+		}
+
+		job := asyncJob{
+			id:          s.ID,
+			msg:         h.HTTPRequest{ID: payload.UID, Auth: auth, Hash: payload.Hash, Hint: payload.Hint},
+			op:          payload.Operation,
+			callbackURL: payload.CallbackURL,
+		}
+
+		a.mutex.Lock()
+		if a.closed {
+			a.suspend(job)
+		} else {
+			// the workers are running, so the queue will not stay full
+			a.queue <- job
+		}
+		a.mutex.Unlock()
+	}
+}
+
+// suspend persists the job in the job store, so that it is resumed on the next start
+func (a *AsyncSigner) suspend(job asyncJob) {
+	payload, err := json.Marshal(asyncJobPayload{
+		UID:         job.msg.ID,
+		Hash:        job.msg.Hash,
+		Hint:        job.msg.Hint,
+		Operation:   job.op,
+		CallbackURL: job.callbackURL,
+	})
+	if err == nil {
+		err = a.Jobs.Suspend(job.id, payload)
+	}
+	if err != nil {
+		log.Errorf("%s: could not suspend job %s: %v", job.msg.ID, job.id, err)
+		_ = a.Jobs.Finish(job.id, jobs.Failed)
+	}
+}
+
+// HandleJobRequest responds with the status of the job with the ID from the request URL.
+// The request must be authorized with the auth token of the identity which owns the job.
+func (a *AsyncSigner) HandleJobRequest(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(chi.URLParam(r, JobIDKey))
+	if err != nil {
+		h.Error(uuid.Nil, w, fmt.Errorf("invalid job ID: %v", err), http.StatusNotFound)
+		return
+	}
+
+	status := a.Jobs.Status(jobID)
+	owner := a.Jobs.Owner(jobID)
+
+	// jobs of previous versions have no owner, their status can not be requested
+	if status == jobs.Unknown || owner == uuid.Nil {
+		sendJobResponse(w, http.StatusNotFound, jobID, jobs.Unknown)
+		return
+	}
+
+	idAuth, err := a.getAcceptedAuth(owner)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", owner, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = checkAuth(r, owner, idAuth, a.BearerAuth)
+	if err != nil {
+		h.Error(owner, w, err, http.StatusUnauthorized)
+		return
+	}
+
+	sendJobResponse(w, http.StatusOK, jobID, status)
+}
+
+func (a *AsyncSigner) process(job asyncJob) {
+	resp := a.Sign(context.Background(), job.msg, job.op)
+
+	status := jobs.Completed
+	err := a.sendCallback(job, resp)
+	if err != nil {
+		log.Errorf("%s: delivery of result of job %s to callback URL failed: %v", job.msg.ID, job.id, err)
+		status = jobs.Failed
+	} else {
+		log.Infof("%s: delivered result of job %s to callback URL", job.msg.ID, job.id)
+	}
+
+	err = a.Jobs.Finish(job.id, status)
+	if err != nil {
+		log.Errorf("%s: could not store status of job %s: %v", job.msg.ID, job.id, err)
+	}
+}
+
+func (a *AsyncSigner) sendCallback(job asyncJob, resp h.HTTPResponse) error {
+	header := map[string]string{
+		"Content-Type": h.ContentType(resp.Header),
+		JobIDHeader:    job.id.String(),
+	}
+
+	callbackResp, err := clients.Post(job.callbackURL, resp.Content, header, a.Protocol.RequestTimeout())
+	if err != nil {
+		return err
+	}
+	if h.HttpFailed(callbackResp.StatusCode) {
+		return fmt.Errorf("(%d) %q", callbackResp.StatusCode, callbackResp.Content)
+	}
+	return nil
+}
+
+// getCallbackURL returns the callback URL from the request header or an empty string if none was set
+func (a *AsyncSigner) getCallbackURL(r *http.Request) (string, error) {
+	callbackURL := r.Header.Get(CallbackURLHeader)
+	if callbackURL == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(callbackURL)
+	if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid callback URL: %q", callbackURL)
+	}
+	if !a.isAllowedCallbackURL(callbackURL) {
+		return "", fmt.Errorf("callback URL is not allowed: %q", callbackURL)
+	}
+	return callbackURL, nil
+}
+
+// isAllowedCallbackURL returns true if the callback URL has the same scheme and host as one of
+// the allowed callback URLs and its path is the path of the allowed URL or below it
+func (a *AsyncSigner) isAllowedCallbackURL(callbackURL string) bool {
+	u, err := url.Parse(callbackURL)
+	if err != nil || u.User != nil {
+		return false
+	}
+
+	p := path.Clean("/" + u.Path)
+	for _, allowed := range a.AllowedCallbackURLs {
+		if u.Scheme != allowed.Scheme || !strings.EqualFold(u.Host, allowed.Host) {
+			continue
+		}
+		prefix := strings.TrimSuffix(path.Clean("/"+allowed.Path), "/")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func sendJobResponse(w http.ResponseWriter, respCode int, jobID uuid.UUID, status jobs.Status) {
+	content, err := json.Marshal(jobResponse{JobID: jobID, Status: status})
+	if err != nil {
+		log.Warnf("error serializing job response: %v", err)
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: respCode,
+		Header:     http.Header{"Content-Type": {h.JSONType}, JobIDHeader: {jobID.String()}},
+		Content:    content,
+	})
+}
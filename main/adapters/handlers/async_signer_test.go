@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/jobs"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestAsyncSigner(t *testing.T) {
+	var tests = []struct {
+		name           string
+		callbackCode   int
+		expectedStatus jobs.Status
+	}{
+		{
+			name:           "callback delivered",
+			callbackCode:   http.StatusOK,
+			expectedStatus: jobs.Completed,
+		},
+		{
+			name:           "callback failed",
+			callbackCode:   http.StatusInternalServerError,
+			expectedStatus: jobs.Failed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer backend.Close()
+
+			callbacks := make(chan signingResponse, 1)
+			callbackJobIDs := make(chan string, 1)
+			callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var resp signingResponse
+				body, _ := ioutil.ReadAll(r.Body)
+				_ = json.Unmarshal(body, &resp)
+				callbacks <- resp
+				callbackJobIDs <- r.Header.Get(JobIDHeader)
+				w.WriteHeader(test.callbackCode)
+			}))
+			defer callback.Close()
+
+			router, async := newTestAsyncRouter(t, backend.URL, 1)
+			async.AllowedCallbackURLs = parseTestURLs(t, callback.URL)
+			uid := newTestIdentity(t, async.Protocol)
+
+			w := sendAsyncRequest(router, uid, callback.URL)
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+			}
+
+			var accepted jobResponse
+			err := json.Unmarshal(w.Body.Bytes(), &accepted)
+			if err != nil {
+				t.Fatalf("unable to decode response: %v", err)
+			}
+
+			select {
+			case resp := <-callbacks:
+				if resp.UPP == nil {
+					t.Error("callback did not contain signing response")
+				}
+				if jobID := <-callbackJobIDs; jobID != accepted.JobID.String() {
+					t.Errorf("unexpected job ID in callback: expected %s, got %s", accepted.JobID, jobID)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("callback was not delivered")
+			}
+
+			// wait for the job to be finished
+			deadline := time.Now().Add(time.Second)
+			for async.Jobs.Status(accepted.JobID) == jobs.Pending && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// the status of the job is only reported to the identity which owns the job
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%s/%s", JobsPath, accepted.JobID), nil)
+			r.Header.Set("X-Auth-Token", "wrong")
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("unexpected response code for unauthorized job status request: %d", w.Code)
+			}
+
+			r = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%s/%s", JobsPath, accepted.JobID), nil)
+			r.Header.Set("X-Auth-Token", testAuth)
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			var status jobResponse
+			err = json.Unmarshal(w.Body.Bytes(), &status)
+			if err != nil {
+				t.Fatalf("unable to decode job status response: %v", err)
+			}
+			if status.Status != test.expectedStatus {
+				t.Errorf("unexpected job status: expected %s, got %s", test.expectedStatus, status.Status)
+			}
+		})
+	}
+}
+
+func TestAsyncSigner_QueueFull(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	// the workers are not started, so the queue is never drained
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+	store, err := jobs.NewStore(filepath.Join(t.TempDir(), "jobs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &SigningService{Signer: signer, Async: NewAsyncSigner(signer, store, 1)}
+	service.Async.AllowedCallbackURLs = parseTestURLs(t, "http://localhost/callback")
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/{%s}/%s", h.UUIDKey, h.OperationKey, h.HashEndpoint), service.HandleRequest)
+
+	if w := sendAsyncRequest(router, uid, "http://localhost/callback"); w.Code != http.StatusAccepted {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusAccepted, w.Code)
+	}
+	if w := sendAsyncRequest(router, uid, "http://localhost/callback"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w := sendAsyncRequest(router, uid, "not a URL"); w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := sendAsyncRequest(router, uid, "http://169.254.169.254/callback"); w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected response code for callback URL which is not allowed: expected %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestAsyncSigner_AllowedCallbackURLs(t *testing.T) {
+	async := &AsyncSigner{AllowedCallbackURLs: parseTestURLs(t, "https://callback.example.com/results", "http://localhost:8080")}
+
+	for callbackURL, allowed := range map[string]bool{
+		"https://callback.example.com/results":           true,
+		"https://CALLBACK.example.com/results/1":         true,
+		"http://localhost:8080/any":                      true,
+		"http://callback.example.com/results":            false,
+		"https://callback.example.com/results2":          false,
+		"https://callback.example.com/results/../admin":  false,
+		"https://callback.example.com.evil.com/results":  false,
+		"https://user@callback.example.com/results":      false,
+		"http://localhost:8081/any":                      false,
+		"http://169.254.169.254/latest/meta-data":        false,
+		"https://callback.example.com/results?redirect=": true,
+	} {
+		if async.isAllowedCallbackURL(callbackURL) != allowed {
+			t.Errorf("%s: expected allowed %t", callbackURL, allowed)
+		}
+	}
+}
+
+func parseTestURLs(t *testing.T, rawURLs ...string) []*url.URL {
+	var urls []*url.URL
+	for _, rawURL := range rawURLs {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+func newTestAsyncRouter(t *testing.T, backendURL string, queueSize int) (*chi.Mux, *AsyncSigner) {
+	signer, _ := newTestSigner(t, backendURL)
+
+	store, err := jobs.NewStore(filepath.Join(t.TempDir(), "jobs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	async := NewAsyncSigner(signer, store, queueSize)
+	async.Start(ctx)
+
+	service := &SigningService{Signer: signer, Async: async}
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/{%s}/%s", h.UUIDKey, h.OperationKey, h.HashEndpoint), service.HandleRequest)
+	router.Get(fmt.Sprintf("/%s/{%s}", JobsPath, JobIDKey), async.HandleJobRequest)
+	return router, async
+}
+
+func sendAsyncRequest(router http.Handler, uid uuid.UUID, callbackURL string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/anchor/%s", uid, h.HashEndpoint), strings.NewReader(testHash("test")))
+	r.Header.Set("X-Auth-Token", testAuth)
+	r.Header.Set("Content-Type", h.TextType)
+	r.Header.Set(CallbackURLHeader, callbackURL)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestAsyncSigner_Drain(t *testing.T) {
+	const numJobs = 10
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	var mutex sync.Mutex
+	delivered := map[string]bool{}
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		delivered[r.Header.Get(JobIDHeader)] = true
+		mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer callback.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+	jobsFile := filepath.Join(t.TempDir(), "jobs.json")
+
+	store, err := jobs.NewStore(jobsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	async := NewAsyncSigner(signer, store, numJobs)
+	async.DrainTimeout = 20 * time.Millisecond
+	async.AllowedCallbackURLs = parseTestURLs(t, callback.URL)
+	async.Start(ctx)
+
+	var jobIDs []uuid.UUID
+	for i := 0; i < numJobs; i++ {
+		jobID, err := async.Enqueue(h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256(fmt.Sprint(i))}, anchorHash, callback.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+
+	// shut down: the jobs are either processed or suspended
+	cancel()
+	async.Wait()
+
+	if _, err = async.Enqueue(h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("late")}, anchorHash, callback.URL); err != ErrShuttingDown {
+		t.Errorf("unexpected error enqueueing job on shutdown: %v", err)
+	}
+
+	// the auth token of the requests is not persisted
+	persisted, err := ioutil.ReadFile(jobsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(persisted), testAuth) {
+		t.Error("job store contains the auth token of the suspended jobs")
+	}
+
+	// restart: the suspended jobs are resumed
+	store, err = jobs.NewStore(jobsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var suspended []uuid.UUID
+	for _, id := range jobIDs {
+		mutex.Lock()
+		processed := delivered[id.String()]
+		mutex.Unlock()
+
+		if processed {
+			if status := store.Status(id); status == jobs.Pending {
+				t.Errorf("processed job %s is still pending after restart", id)
+			}
+		} else {
+			if status := store.Status(id); status != jobs.Pending {
+				t.Errorf("job %s was neither processed nor suspended: %s", id, status)
+			}
+			suspended = append(suspended, id)
+		}
+	}
+	if len(suspended) == 0 {
+		t.Fatal("no jobs were suspended after the drain timeout")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	async = NewAsyncSigner(signer, store, numJobs)
+	async.AllowedCallbackURLs = parseTestURLs(t, callback.URL)
+	async.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range suspended {
+		for store.Status(id) == jobs.Pending && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if status := store.Status(id); status != jobs.Completed {
+			t.Errorf("unexpected status of job %s after restart: %s", id, status)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(delivered) != numJobs {
+		t.Errorf("unexpected number of delivered callbacks: expected %d, got %d", numJobs, len(delivered))
+	}
+}
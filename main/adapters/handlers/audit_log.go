@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/audit"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const AuditPath = "audit"
+
+// AuditService responds with the entries of the audit log. The service does not check
+// the auth token of the identity and must only be reachable via the admin API.
+type AuditService struct {
+	Log *audit.Log
+}
+
+var _ h.Service = (*AuditService)(nil)
+
+// HandleRequest responds with the audit log entries of the identity in the query parameter "uuid"
+// as JSON array, or with all entries if the parameter is not set
+func (s *AuditService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	var uid uuid.UUID
+	if param := r.URL.Query().Get("uuid"); param != "" {
+		var err error
+		uid, err = uuid.Parse(param)
+		if err != nil {
+			h.Error(uuid.Nil, w, fmt.Errorf("invalid UUID: \"%s\": %v", param, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := s.Log.Query(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("querying audit log failed: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+
+	content, err := json.Marshal(entries)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {h.JSONType}},
+		Content:    content,
+	})
+}
+
+// auditChainEvent records a key rotation or chain reset of an identity in the audit log, if set, so that the
+// local chain can be verified across it. If the entry is dropped, the verification of the chain fails after it.
+func auditChainEvent(ctx context.Context, l *audit.Log, e audit.Entry) {
+	if l == nil {
+		return
+	}
+	if !l.Append(e) {
+		log.WithContext(ctx).Errorf("%s: audit log buffer full, %s entry dropped", e.UUID, e.Operation)
+	}
+}
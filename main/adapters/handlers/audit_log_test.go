@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ubirch/ubirch-client-go/main/adapters/audit"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestSigner_Audit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	auditLog, err := audit.NewLog(filepath.Join(t.TempDir(), "audit.log"), 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	signer.Audit = auditLog
+	uid := newTestIdentity(t, signer.Protocol)
+
+	ctx := h.WithRequestID(context.Background(), "test-request")
+
+	var upps [][]byte
+	for i := 0; i < 2; i++ {
+		resp := signer.chainWithLock(ctx, h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256(strconv.Itoa(i))})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected response code: expected %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var signingResp signingResponse
+		err = json.Unmarshal(resp.Content, &signingResp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upps = append(upps, signingResp.UPP)
+	}
+
+	resp := signer.Sign(ctx, h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("disable")}, disableHash)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response code: expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// query the audit log via the admin API
+	auditService := &AuditService{Log: auditLog}
+	w := httptest.NewRecorder()
+	auditService.HandleRequest(w, httptest.NewRequest(http.MethodGet, "/audit?uuid="+uid.String(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	var entries []audit.Entry
+	err = json.Unmarshal(w.Body.Bytes(), &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("unexpected number of audit log entries: expected 3, got %d", len(entries))
+	}
+
+	for i, op := range []operation{chainHash, chainHash, disableHash} {
+		e := entries[i]
+		if e.UUID != uid || e.Operation != string(op) || e.RequestID != "test-request" || e.BackendStatus != http.StatusOK {
+			t.Errorf("unexpected audit log entry: %+v", e)
+		}
+		if i < len(upps) && !bytes.Equal(e.UPP, upps[i]) {
+			t.Errorf("unexpected UPP in audit log entry: expected %x, got %x", upps[i], e.UPP)
+		}
+	}
+
+	// the audit log is the UPP history of the local chain verification
+	pubKeyPEM, err := signer.Protocol.GetPublicKey(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := auditLog.Chain(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("unexpected length of UPP history: expected 2, got %d", len(history))
+	}
+	if _, chainBreak := verifyChain(&signer.Protocol.Protocol, uid, pubKeyPEM, history); chainBreak != nil {
+		t.Errorf("chain from audit log is broken: %s", chainBreak.Reason)
+	}
+}
+
+func TestAuditService_InvalidUUID(t *testing.T) {
+	auditLog, err := audit.NewLog(filepath.Join(t.TempDir(), "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	auditService := &AuditService{Log: auditLog}
+
+	w := httptest.NewRecorder()
+	auditService.HandleRequest(w, httptest.NewRequest(http.MethodGet, "/audit?uuid=invalid", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// without UUID, all entries are returned
+	w = httptest.NewRecorder()
+	auditService.HandleRequest(w, httptest.NewRequest(http.MethodGet, "/audit", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "[]" {
+		t.Errorf("unexpected response: (%d) %s", w.Code, w.Body)
+	}
+}
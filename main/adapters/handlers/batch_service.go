@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+// batchItemResponse is the response to a single hash of a batch request
+type batchItemResponse struct {
+	StatusCode int `json:"statusCode"`
+	signingResponse
+}
+
+// BatchSigningService accepts a JSON array of base64 encoded hashes for a single UUID
+// and responds with a JSON array of signing responses in the same order
+type BatchSigningService struct {
+	*Signer
+	MaxBatchSize int
+}
+
+var _ h.Service = (*BatchSigningService)(nil)
+
+func (s *BatchSigningService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	var msg h.HTTPRequest
+	var err error
+
+	msg.ID, err = h.GetUUID(r)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusNotFound)
+		return
+	}
+
+	exists, err := s.checkExists(msg.ID)
+	if err != nil {
+		log.Errorf("%s: %v", msg.ID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		h.Error(msg.ID, w, fmt.Errorf("unknown UUID"), http.StatusNotFound)
+		return
+	}
+
+	idAuth, err := s.getAcceptedAuth(msg.ID)
+	if err != nil {
+		log.Errorf("%s: %v", msg.ID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	msg.Auth, err = checkAuth(r, msg.ID, idAuth, s.BearerAuth)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusUnauthorized)
+		return
+	}
+
+	verifySignature, err := s.checkBodySignature(r, msg.ID)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusUnauthorized)
+		return
+	}
+
+	op, err := getBatchOperation(r)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusNotFound)
+		return
+	}
+
+	hashAlg, err := s.getHashAlgorithm(r, msg.ID)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusBadRequest)
+		return
+	}
+
+	hashes, err := getBatchHashes(r)
+	if err != nil {
+		h.Error(msg.ID, w, err, h.BodyErrorCode(err))
+		return
+	}
+
+	err = verifySignature()
+	if err != nil {
+		h.Error(msg.ID, w, err, h.BodyErrorCode(err))
+		return
+	}
+
+	// the nonce is only registered once the request is authentic, so that a forged request
+	// can not use up the nonce of the genuine request
+	err = s.checkReplay(r, msg.ID)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusUnauthorized)
+		return
+	}
+
+	if len(hashes) > s.MaxBatchSize {
+		h.Error(msg.ID, w, fmt.Errorf("batch size exceeds maximum of %d hashes (got %d)", s.MaxBatchSize, len(hashes)), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if !s.checkRequestLimits(w, r, msg.ID, len(hashes)) {
+		return
+	}
+
+	log.Infof("%s: batch of %d hashes [%s]", msg.ID, len(hashes), op)
+
+	// hashes are processed one after another, so that the chain order matches the order of the batch
+	results := make([]batchItemResponse, len(hashes))
+	failed := false
+	for i, hash := range hashes {
+		results[i] = s.signBatchItem(r.Context(), msg, op, hashAlg, hash)
+		if h.HttpFailed(results[i].StatusCode) {
+			failed = true
+		}
+	}
+
+	respCode := http.StatusOK
+	if failed {
+		respCode = http.StatusMultiStatus
+	}
+
+	content, err := json.Marshal(results)
+	if err != nil {
+		log.Errorf("%s: error serializing batch response: %v", msg.ID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: respCode,
+		Header:     http.Header{"Content-Type": {h.JSONType}},
+		Content:    content,
+	})
+}
+
+// signBatchItem signs a single hash of a batch and converts the result into a batch item response
+func (s *BatchSigningService) signBatchItem(ctx context.Context, msg h.HTTPRequest, op operation, hashAlg h.HashAlgorithm, encodedHash string) batchItemResponse {
+	hash, err := base64.StdEncoding.DecodeString(encodedHash)
+	if err != nil || len(hash) != hashAlg.Size {
+		return batchItemError(http.StatusBadRequest, fmt.Sprintf("invalid %s hash: expected base64 encoded %d bytes", hashAlg.Name, hashAlg.Size))
+	}
+	msg.Hash = hash
+
+	var resp h.HTTPResponse
+	if op == chainHash {
+		// if the submission may be completed in the background, the transaction
+		// must not be bound to the lifetime of the request
+		txCtx := ctx
+		if s.SlowBackendThreshold > 0 {
+			txCtx = context.Background()
+		}
+
+		done := s.startChaining(msg.ID)
+		defer done()
+
+		tx, identity, err := s.Protocol.FetchIdentityWithLock(txCtx, msg.ID)
+		if err != nil {
+			log.Errorf("%s: %v", msg.ID, err)
+			return batchItemError(http.StatusServiceUnavailable, "")
+		}
+
+		resp = s.chain(ctx, msg, tx, identity)
+	} else {
+		resp = s.Sign(ctx, msg, op)
+	}
+
+	item := batchItemResponse{StatusCode: resp.StatusCode}
+	if h.ContentType(resp.Header) == h.JSONType {
+		err = json.Unmarshal(resp.Content, &item.signingResponse)
+		if err != nil {
+			log.Warnf("%s: unable to decode signing response: %v", msg.ID, err)
+		}
+	} else {
+		item.Error = string(resp.Content)
+	}
+	item.Hash = msg.Hash
+	return item
+}
+
+func batchItemError(code int, message string) batchItemResponse {
+	if message == "" {
+		message = http.StatusText(code)
+	}
+	return batchItemResponse{
+		StatusCode:      code,
+		signingResponse: signingResponse{Error: message},
+	}
+}
+
+// getBatchHashes returns the base64 encoded hashes from the JSON array in the request body
+func getBatchHashes(r *http.Request) ([]string, error) {
+	rBody, err := h.ReadBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	err = json.Unmarshal(rBody, &hashes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse request body: expected JSON array of base64 encoded hashes: %v", err)
+	}
+
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("empty batch")
+	}
+
+	return hashes, nil
+}
+
+// getBatchOperation returns the operation parameter from the request URL of a batch request,
+// which may also be the chaining operation
+func getBatchOperation(r *http.Request) (operation, error) {
+	if operation(chi.URLParam(r, h.OperationKey)) == chainHash {
+		return chainHash, nil
+	}
+	return getOperation(r)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestBatchSigningService(t *testing.T) {
+	var tests = []struct {
+		name          string
+		operation     string
+		hashes        []string
+		expectedCode  int
+		expectedItems []int
+	}{
+		{
+			name:          "chain",
+			operation:     "chain",
+			hashes:        []string{testHash("1"), testHash("2"), testHash("3")},
+			expectedCode:  http.StatusOK,
+			expectedItems: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:          "anchor",
+			operation:     "anchor",
+			hashes:        []string{testHash("1"), testHash("2")},
+			expectedCode:  http.StatusOK,
+			expectedItems: []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			name:          "partial failure",
+			operation:     "chain",
+			hashes:        []string{testHash("1"), "invalid", testHash("3")},
+			expectedCode:  http.StatusMultiStatus,
+			expectedItems: []int{http.StatusOK, http.StatusBadRequest, http.StatusOK},
+		},
+		{
+			name:         "too large",
+			operation:    "anchor",
+			hashes:       []string{testHash("1"), testHash("2"), testHash("3"), testHash("4")},
+			expectedCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:         "invalid operation",
+			operation:    "invalid",
+			hashes:       []string{testHash("1")},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer backend.Close()
+
+			signer, ctxManager := newTestSigner(t, backend.URL)
+			uid := newTestIdentity(t, signer.Protocol)
+			service := &BatchSigningService{Signer: signer, MaxBatchSize: 3}
+
+			router := chi.NewMux()
+			router.Post(fmt.Sprintf("/{%s}/{%s}/%s", h.UUIDKey, h.OperationKey, h.BatchEndpoint), service.HandleRequest)
+
+			w := sendBatchRequest(t, router, uid, test.operation, test.hashes)
+			if w.Code != test.expectedCode {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", test.expectedCode, w.Code, w.Body.String())
+			}
+			if test.expectedItems == nil {
+				return
+			}
+
+			var items []batchItemResponse
+			err := json.Unmarshal(w.Body.Bytes(), &items)
+			if err != nil {
+				t.Fatalf("unable to decode response: %v", err)
+			}
+			if len(items) != len(test.expectedItems) {
+				t.Fatalf("unexpected number of items: expected %d, got %d", len(test.expectedItems), len(items))
+			}
+
+			var lastUPP []byte
+			for i, item := range items {
+				if item.StatusCode != test.expectedItems[i] {
+					t.Errorf("item %d: unexpected status code: expected %d, got %d", i, test.expectedItems[i], item.StatusCode)
+				}
+				if h.HttpFailed(item.StatusCode) {
+					if item.Error == "" {
+						t.Errorf("item %d: missing error", i)
+					}
+					continue
+				}
+				if base64.StdEncoding.EncodeToString(item.Hash) != test.hashes[i] {
+					t.Errorf("item %d: unexpected hash: %x", i, item.Hash)
+				}
+				lastUPP = item.UPP
+			}
+
+			// the stored signature must be the one of the last chained UPP in the batch
+			if test.operation == "chain" {
+				signature := lastUPP[len(lastUPP)-signer.Protocol.SignatureLength():]
+				if !bytes.Equal(ctxManager.signature(uid), signature) {
+					t.Error("chain order was not preserved")
+				}
+			}
+		})
+	}
+}
+
+func testHash(data string) string {
+	hash := sha256.Sum256([]byte(data))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func sendBatchRequest(t *testing.T, router http.Handler, uid uuid.UUID, op string, hashes []string) *httptest.ResponseRecorder {
+	body, err := json.Marshal(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s/%s", uid, op, h.BatchEndpoint), bytes.NewReader(body))
+	r.Header.Set("X-Auth-Token", testAuth)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/audit"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const confirmQueryKey = "confirm"
+
+var ErrChainInFlight = fmt.Errorf("chaining requests of the identity are in flight, retry when they are completed")
+
+// ChainResetService resets the chain of identities, i.e. the next chained UPP of the identity is chained
+// to the genesis signature instead of the signature of its predecessor. The service does not check the
+// auth token of the identity and must only be reachable via the admin API.
+type ChainResetService struct {
+	*Signer
+}
+
+var _ h.Service = (*ChainResetService)(nil)
+
+type chainResetResponse struct {
+	PrevSignature []byte `json:"prevSignature"`
+}
+
+// HandleRequest replaces the stored signature of the identity with the genesis signature and responds with
+// the replaced signature. Since the chain can not be continued afterwards, the request must have the query
+// parameter "confirm=true". The chain is not reset while chaining requests of the identity are in flight.
+func (s *ChainResetService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	uid, err := h.GetUUID(r)
+	if err != nil {
+		h.Error(uid, w, err, http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get(confirmQueryKey) != "true" {
+		h.Error(uid, w, fmt.Errorf("the chain can not be continued after the reset: set query parameter \"%s=true\" to confirm", confirmQueryKey), http.StatusBadRequest)
+		return
+	}
+
+	exists, err := s.Protocol.Exists(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		h.Error(uid, w, fmt.Errorf("unknown UUID"), http.StatusNotFound)
+		return
+	}
+
+	prevSignature, err := s.resetChain(r, uid)
+	if err == ErrChainInFlight {
+		h.Error(uid, w, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: chain reset failed: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	content, err := json.Marshal(chainResetResponse{PrevSignature: prevSignature})
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {h.JSONType}},
+		Content:    content,
+	})
+}
+
+// resetChain locks the identity and replaces its signature with the genesis signature,
+// unless chaining requests of the identity are in flight. Returns the replaced signature.
+func (s *ChainResetService) resetChain(r *http.Request, uid uuid.UUID) ([]byte, error) {
+	tx, identity, err := s.Protocol.FetchIdentityWithLock(r.Context(), uid)
+	if err != nil {
+		return nil, err
+	}
+
+	// requests which wait for the lock or in the queue of the chaining worker are in flight as well
+	if s.chainingInFlight(uid) {
+		_ = s.Protocol.CloseTransaction(tx, repository.Rollback)
+		return nil, ErrChainInFlight
+	}
+
+	err = s.Protocol.SetSignature(tx, uid, make([]byte, s.Protocol.SignatureLength()))
+	if err != nil {
+		_ = s.Protocol.CloseTransaction(tx, repository.Rollback)
+		return nil, err
+	}
+
+	log.WithContext(r.Context()).Warnf("%s: chain reset, previous signature: %s",
+		uid, base64.StdEncoding.EncodeToString(identity.Signature))
+	auditChainEvent(r.Context(), s.Audit, audit.Entry{
+		UUID:      uid,
+		Operation: audit.ChainResetOperation,
+		RequestID: h.GetRequestID(r.Context()),
+	})
+	return identity.Signature, nil
+}
+
+// chainingRequests counts the chaining requests per identity, which are in flight
+type chainingRequests struct {
+	mutex  sync.Mutex
+	counts map[uuid.UUID]int
+}
+
+// startChaining registers a chaining request of the identity as in flight. The returned function
+// must be called when the request is completed.
+func (s *Signer) startChaining(uid uuid.UUID) (done func()) {
+	s.chaining.mutex.Lock()
+	if s.chaining.counts == nil {
+		s.chaining.counts = map[uuid.UUID]int{}
+	}
+	s.chaining.counts[uid]++
+	s.chaining.mutex.Unlock()
+
+	return func() {
+		s.chaining.mutex.Lock()
+		s.chaining.counts[uid]--
+		if s.chaining.counts[uid] == 0 {
+			delete(s.chaining.counts, uid)
+		}
+		s.chaining.mutex.Unlock()
+	}
+}
+
+// chainingInFlight returns true if chaining requests of the identity are in flight
+func (s *Signer) chainingInFlight(uid uuid.UUID) bool {
+	s.chaining.mutex.Lock()
+	defer s.chaining.mutex.Unlock()
+
+	return s.chaining.counts[uid] > 0
+}
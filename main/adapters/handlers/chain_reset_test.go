@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/audit"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestChainResetService(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	auditLog, err := audit.NewLog(filepath.Join(t.TempDir(), "audit.log"), 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	signer, ctxManager := newTestSigner(t, backend.URL)
+	signer.Audit = auditLog
+	uid := newTestIdentity(t, signer.Protocol)
+	genesis := make([]byte, signer.Protocol.SignatureLength())
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+	router.Post(fmt.Sprintf("/{%s}/%s/%s", h.UUIDKey, h.ChainEndpoint, h.ResetPath), (&ChainResetService{Signer: signer}).HandleRequest)
+
+	chain := func(hash []byte) ubirch.UPP {
+		r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s", uid, h.HashEndpoint), bytes.NewReader(hash))
+		r.Header.Set(h.XAuthHeader, testAuth)
+		r.Header.Set("Content-Type", h.BinType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected response code: %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp signingResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		upp, err := ubirch.Decode(resp.UPP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return upp
+	}
+	reset := func(uid uuid.UUID, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s/%s%s", uid, h.ChainEndpoint, h.ResetPath, query), nil))
+		return w
+	}
+
+	chain(testSHA256("1"))
+	second := chain(testSHA256("2"))
+	if bytes.Equal(second.GetPrevSignature(), genesis) {
+		t.Fatal("second UPP is chained to the genesis signature")
+	}
+	signature := ctxManager.signature(uid)
+
+	if w := reset(uid, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected response code for unconfirmed reset: %d", w.Code)
+	}
+	if w := reset(uid, "?confirm=false"); w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected response code for unconfirmed reset: %d", w.Code)
+	}
+	if w := reset(uuid.New(), "?confirm=true"); w.Code != http.StatusNotFound {
+		t.Errorf("unexpected response code for unknown UUID: %d", w.Code)
+	}
+
+	// the chain is not reset while chaining requests are in flight
+	done := signer.startChaining(uid)
+	if w := reset(uid, "?confirm=true"); w.Code != http.StatusConflict {
+		t.Errorf("unexpected response code for reset with request in flight: %d", w.Code)
+	}
+	done()
+	if !bytes.Equal(ctxManager.signature(uid), signature) {
+		t.Fatal("chain was reset although it was not confirmed or requests were in flight")
+	}
+
+	w := reset(uid, "?confirm=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: %d: %s", w.Code, w.Body.String())
+	}
+	var resp chainResetResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp.PrevSignature, signature) {
+		t.Errorf("unexpected previous signature: %x", resp.PrevSignature)
+	}
+
+	// the next chained UPP is a genesis link
+	next := chain(testSHA256("3"))
+	if !bytes.Equal(next.GetPrevSignature(), genesis) {
+		t.Errorf("UPP after reset is not chained to the genesis signature: %x", next.GetPrevSignature())
+	}
+	if following := chain(testSHA256("4")); bytes.Equal(following.GetPrevSignature(), genesis) {
+		t.Error("chain was not continued after the genesis link")
+	}
+
+	// the reset is recorded in the audit log, so that the chain can be verified across it
+	length, chainBreak := verifyAuditedChain(t, signer, uid)
+	if length != 4 || chainBreak != nil {
+		t.Errorf("chain from audit log could not be verified across the reset: %d UPPs, %+v", length, chainBreak)
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/audit"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+// UPPHistory provides the locally persisted chained UPPs, key rotations and chain resets of an identity
+// in the order in which they happened
+type UPPHistory interface {
+	Chain(uid uuid.UUID) ([]audit.Entry, error)
+}
+
+// ChainVerificationService verifies the chain of the locally persisted UPPs of an identity without
+// the UBIRCH backend. The service does not check the auth token of the identity and must only be
+// reachable via the admin API.
+type ChainVerificationService struct {
+	*IdentityHandler
+	History UPPHistory // if nil, no local history is kept and the chain can not be verified
+}
+
+var _ h.Service = (*ChainVerificationService)(nil)
+
+type chainVerificationResponse struct {
+	Valid  bool        `json:"valid"`
+	Length int         `json:"length"` // number of UPPs in the local history
+	Break  *chainBreak `json:"break,omitempty"`
+}
+
+// chainBreak describes the first UPP of the history which breaks the chain
+type chainBreak struct {
+	Index  int    `json:"index"`
+	UPP    []byte `json:"upp"`
+	Reason string `json:"reason"`
+}
+
+// HandleRequest walks the local UPP history of the identity and verifies the signature of each UPP with the
+// public key of the identity which was valid when the UPP was chained, and that each UPP is chained to its
+// predecessor, or to the genesis signature after a chain reset. It responds with the first break of the chain, if any.
+func (s *ChainVerificationService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	uid, err := h.GetUUID(r)
+	if err != nil {
+		h.Error(uid, w, err, http.StatusNotFound)
+		return
+	}
+
+	if s.History == nil {
+		h.Error(uid, w, fmt.Errorf("no local UPP history is kept, the chain can not be verified locally"), http.StatusNotImplemented)
+		return
+	}
+
+	exists, err := s.Protocol.Exists(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		h.Error(uid, w, fmt.Errorf("unknown UUID"), http.StatusNotFound)
+		return
+	}
+
+	pubKeyPEM, err := s.Protocol.GetPublicKey(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	history, err := s.History.Chain(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: unable to load UPP history: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var resp chainVerificationResponse
+	resp.Length, resp.Break = verifyChain(&s.Protocol.Protocol, uid, pubKeyPEM, history)
+	resp.Valid = resp.Break == nil
+
+	if resp.Valid {
+		log.WithContext(r.Context()).Infof("%s: local chain of %d UPPs verified", uid, resp.Length)
+	} else {
+		log.WithContext(r.Context()).Warnf("%s: local chain broken at UPP #%d: %s", uid, resp.Break.Index, resp.Break.Reason)
+	}
+
+	content, err := json.Marshal(resp)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {h.JSONType}},
+		Content:    content,
+	})
+}
+
+// verifyChain returns the number of UPPs in the history and the first UPP which is not a chained UPP of the
+// identity, has an invalid signature, or is not chained to its predecessor, or nil if the chain is intact.
+// The UPPs before the first key rotation in the history are verified with the public key which was replaced
+// by it, the UPPs after a key rotation with the new public key, and the UPPs after the last key rotation with
+// the current public key of the identity.
+func verifyChain(p *ubirch.Protocol, uid uuid.UUID, pubKeyPEM []byte, history []audit.Entry) (int, *chainBreak) {
+	for _, e := range history {
+		if e.Operation == audit.KeyRotationOperation {
+			pubKeyPEM = e.PrevPublicKey
+			break
+		}
+	}
+
+	var prevSignature []byte
+	i := 0
+
+	for _, e := range history {
+		switch e.Operation {
+		case audit.KeyRotationOperation:
+			pubKeyPEM = e.PublicKey
+			continue
+		case audit.ChainResetOperation:
+			prevSignature = make([]byte, p.SignatureLength())
+			continue
+		}
+
+		upp := e.UPP
+		broken := func(format string, a ...interface{}) (int, *chainBreak) {
+			return countUPPs(history), &chainBreak{Index: i, UPP: upp, Reason: fmt.Sprintf(format, a...)}
+		}
+
+		uppStruct, err := ubirch.Decode(upp)
+		if err != nil {
+			return broken("invalid UPP: %v", err)
+		}
+		if uppStruct.GetVersion() != ubirch.Chained {
+			return broken("not a chained UPP")
+		}
+		if uppStruct.GetUuid() != uid {
+			return broken("UPP of other identity: %s", uppStruct.GetUuid())
+		}
+
+		verified, err := p.Verify(pubKeyPEM, upp)
+		if !verified {
+			if err != nil {
+				return broken("invalid signature: %v", err)
+			}
+			return broken("invalid signature")
+		}
+
+		if prevSignature != nil && !bytes.Equal(uppStruct.GetPrevSignature(), prevSignature) {
+			return broken("previous signature does not match the signature of the preceding UPP")
+		}
+		prevSignature = uppStruct.GetSignature()
+		i++
+	}
+
+	return i, nil
+}
+
+// countUPPs returns the number of UPPs in the history, i.e. the number of entries
+// which are neither key rotations nor chain resets
+func countUPPs(history []audit.Entry) int {
+	n := 0
+	for _, e := range history {
+		if e.Operation != audit.KeyRotationOperation && e.Operation != audit.ChainResetOperation {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/audit"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+type mockUPPHistory map[uuid.UUID][]audit.Entry
+
+func (m mockUPPHistory) Chain(uid uuid.UUID) ([]audit.Entry, error) {
+	return m[uid], nil
+}
+
+// chainEntries returns the audit log entries of the chained UPPs
+func chainEntries(upps ...[]byte) []audit.Entry {
+	var entries []audit.Entry
+	for _, upp := range upps {
+		entries = append(entries, audit.Entry{Operation: string(chainHash), UPP: upp, BackendStatus: http.StatusOK})
+	}
+	return entries
+}
+
+// rotateTestKey replaces the key of the identity with a new key and returns the audit log entry of the rotation
+func rotateTestKey(t *testing.T, signer *Signer, uid uuid.UUID) audit.Entry {
+	oldPubKeyPEM, err := signer.Protocol.GetPublicKey(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPrivKeyPEM, err := signer.Protocol.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPubKeyPEM, err := signer.Protocol.GetPublicKeyFromPrivateKey(newPrivKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, identity, err := signer.Protocol.FetchIdentityWithLock(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = signer.Protocol.DeleteIdentity(tx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity.PrivateKey = newPrivKeyPEM
+	identity.PublicKey = newPubKeyPEM
+	err = signer.Protocol.StoreNewIdentity(tx, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = signer.Protocol.CloseTransaction(tx, repository.Commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return audit.Entry{UUID: uid, Operation: audit.KeyRotationOperation, PrevPublicKey: oldPubKeyPEM, PublicKey: newPubKeyPEM}
+}
+
+// verifyAuditedChain verifies the chain of the identity from the audit log of the signer
+func verifyAuditedChain(t *testing.T, signer *Signer, uid uuid.UUID) (int, *chainBreak) {
+	pubKeyPEM, err := signer.Protocol.GetPublicKey(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := signer.Audit.Chain(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifyChain(&signer.Protocol.Protocol, uid, pubKeyPEM, history)
+}
+
+// newTestChain creates a chain of UPPs of the identity, which starts with the previous signature
+func newTestChain(t *testing.T, signer *Signer, uid uuid.UUID, prevSignature []byte, length int) [][]byte {
+	privKeyPEM, err := signer.Protocol.GetPrivateKey(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var upps [][]byte
+	for i := 0; i < length; i++ {
+		upp, err := signer.getChainedUPP(context.Background(), uid, testSHA256(strconv.Itoa(i)), privKeyPEM, prevSignature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upps = append(upps, upp)
+		prevSignature = upp[len(upp)-signer.Protocol.SignatureLength():]
+	}
+	return upps
+}
+
+func verifyTestChain(t *testing.T, service *ChainVerificationService, uid uuid.UUID) *httptest.ResponseRecorder {
+	router := chi.NewMux()
+	router.Get(fmt.Sprintf("/{%s}/%s/%s", h.UUIDKey, h.ChainEndpoint, h.VerifyPath), service.HandleRequest)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%s/%s/%s", uid, h.ChainEndpoint, h.VerifyPath), nil))
+	return w
+}
+
+func TestChainVerificationService(t *testing.T) {
+	signer, _ := newTestSigner(t, "")
+	uid := newTestIdentity(t, signer.Protocol)
+
+	valid := newTestChain(t, signer, uid, make([]byte, signer.Protocol.SignatureLength()), 3)
+
+	// a UPP which is validly signed, but not chained to its predecessor
+	gap := append([][]byte{}, valid...)
+	gap[1] = newTestChain(t, signer, uid, make([]byte, signer.Protocol.SignatureLength()), 2)[1]
+
+	// a UPP which was manipulated after signing
+	tampered := append([][]byte{}, valid...)
+	tampered[2] = append([]byte{}, valid[2]...)
+	tampered[2][40] ^= 0xff
+
+	// a UPP of another identity
+	otherUID := newTestIdentity(t, signer.Protocol)
+	foreign := append([][]byte{}, valid...)
+	foreign[0] = newTestChain(t, signer, otherUID, make([]byte, signer.Protocol.SignatureLength()), 1)[0]
+
+	// a chain which is reset and continued with a genesis link
+	genesis := make([]byte, signer.Protocol.SignatureLength())
+	reset := newTestChain(t, signer, uid, genesis, 2)
+	resetEntry := audit.Entry{UUID: uid, Operation: audit.ChainResetOperation}
+
+	// a chain whose key is rotated, the chain continues across the rotation with the new key.
+	// The identity keeps the new key for the remaining tests.
+	rotatedUID := newTestIdentity(t, signer.Protocol)
+	beforeRotation := newTestChain(t, signer, rotatedUID, genesis, 2)
+	rotation := rotateTestKey(t, signer, rotatedUID)
+	afterRotation := newTestChain(t, signer, rotatedUID, beforeRotation[1][len(beforeRotation[1])-len(genesis):], 2)
+
+	tests := []struct {
+		name          string
+		uid           uuid.UUID
+		history       []audit.Entry
+		expectedValid bool
+		expectedIndex int
+		expectedLen   int
+	}{
+		{
+			name:          "valid chain",
+			history:       chainEntries(valid...),
+			expectedValid: true,
+			expectedLen:   3,
+		},
+		{
+			name:          "empty history",
+			expectedValid: true,
+		},
+		{
+			name:          "broken linkage",
+			history:       chainEntries(gap...),
+			expectedIndex: 1,
+			expectedLen:   3,
+		},
+		{
+			name:          "invalid signature",
+			history:       chainEntries(tampered...),
+			expectedIndex: 2,
+			expectedLen:   3,
+		},
+		{
+			name:          "UPP of other identity",
+			history:       chainEntries(foreign...),
+			expectedIndex: 0,
+			expectedLen:   3,
+		},
+		{
+			name:          "chain reset",
+			history:       append(append(chainEntries(valid...), resetEntry), chainEntries(reset...)...),
+			expectedValid: true,
+			expectedLen:   5,
+		},
+		{
+			name:          "genesis link without chain reset",
+			history:       chainEntries(append(valid, reset...)...),
+			expectedIndex: 3,
+			expectedLen:   5,
+		},
+		{
+			name:          "chain reset not followed by genesis link",
+			history:       append(append(chainEntries(valid[:1]...), resetEntry), chainEntries(valid[1:]...)...),
+			expectedIndex: 1,
+			expectedLen:   3,
+		},
+		{
+			name:          "key rotation",
+			uid:           rotatedUID,
+			history:       append(append(chainEntries(beforeRotation...), rotation), chainEntries(afterRotation...)...),
+			expectedValid: true,
+			expectedLen:   4,
+		},
+		{
+			name:          "key rotation not recorded",
+			uid:           rotatedUID,
+			history:       chainEntries(append(beforeRotation, afterRotation...)...),
+			expectedIndex: 0,
+			expectedLen:   4,
+		},
+		{
+			name:          "UPP with old key after key rotation",
+			uid:           rotatedUID,
+			history:       append(append(chainEntries(beforeRotation[:1]...), rotation), chainEntries(beforeRotation[1:]...)...),
+			expectedIndex: 1,
+			expectedLen:   2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testUID := uid
+			if test.uid != uuid.Nil {
+				testUID = test.uid
+			}
+			service := &ChainVerificationService{
+				IdentityHandler: &IdentityHandler{Protocol: signer.Protocol},
+				History:         mockUPPHistory{testUID: test.history},
+			}
+
+			w := verifyTestChain(t, service, testUID)
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			var resp chainVerificationResponse
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Valid != test.expectedValid {
+				t.Fatalf("unexpected result: expected valid=%v, got %s", test.expectedValid, w.Body.String())
+			}
+			if resp.Length != test.expectedLen {
+				t.Errorf("unexpected length: expected %d, got %d", test.expectedLen, resp.Length)
+			}
+			if test.expectedValid {
+				if resp.Break != nil {
+					t.Errorf("valid chain has break: %v", resp.Break)
+				}
+				return
+			}
+			if resp.Break == nil || resp.Break.Index != test.expectedIndex {
+				t.Errorf("unexpected break: expected at index %d, got %s", test.expectedIndex, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestChainVerificationService_NoHistory(t *testing.T) {
+	signer, _ := newTestSigner(t, "")
+	uid := newTestIdentity(t, signer.Protocol)
+
+	service := &ChainVerificationService{IdentityHandler: &IdentityHandler{Protocol: signer.Protocol}}
+
+	w := verifyTestChain(t, service, uid)
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusNotImplemented, w.Code)
+	}
+}
+
+func TestChainVerificationService_UnknownUUID(t *testing.T) {
+	signer, _ := newTestSigner(t, "")
+
+	service := &ChainVerificationService{
+		IdentityHandler: &IdentityHandler{Protocol: signer.Protocol},
+		History:         mockUPPHistory{},
+	}
+
+	w := verifyTestChain(t, service, uuid.New())
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+	prom "github.com/ubirch/ubirch-client-go/main/prometheus"
+)
+
+const (
+	DefaultChainQueueSize         = 100         // maximum number of queued chaining requests per UUID
+	DefaultChainWorkerIdleTimeout = time.Minute // time after which an idle chaining worker is stopped
+)
+
+var (
+	ErrTooManyChainWorkers = fmt.Errorf("maximum number of active chaining workers reached")
+	ErrChainQueueFull      = fmt.Errorf("chaining queue of identity is full")
+)
+
+type chainJob struct {
+	ctx  context.Context
+	msg  h.HTTPRequest
+	resp chan h.HTTPResponse
+}
+
+type chainWorker struct {
+	uid   uuid.UUID
+	queue chan chainJob
+}
+
+// ChainWorkers processes chaining requests with one worker go routine per UUID. Each worker has its own
+// queue, so the requests of an identity are chained in the order in which they arrived, and a slow backend
+// response for one identity does not block the chaining of other identities.
+//
+// The number of active workers is bounded. Workers which have been idle for the idle timeout are stopped.
+type ChainWorkers struct {
+	*Signer
+	MaxWorkers  int           // maximum number of active workers, i.e. of UUIDs with pending chaining requests
+	QueueSize   int           // maximum number of queued requests per UUID
+	IdleTimeout time.Duration // time after which an idle worker is stopped
+	workers     map[uuid.UUID]*chainWorker
+	mutex       *sync.Mutex
+}
+
+func NewChainWorkers(signer *Signer, maxWorkers, queueSize int) *ChainWorkers {
+	if queueSize <= 0 {
+		queueSize = DefaultChainQueueSize
+	}
+	return &ChainWorkers{
+		Signer:      signer,
+		MaxWorkers:  maxWorkers,
+		QueueSize:   queueSize,
+		IdleTimeout: DefaultChainWorkerIdleTimeout,
+		workers:     map[uuid.UUID]*chainWorker{},
+		mutex:       &sync.Mutex{},
+	}
+}
+
+// SendChainedUpp adds the chaining request to the queue of the identity and waits until the
+// worker of the identity created the chained UPP and sent it to the ubirch backend
+func (c *ChainWorkers) SendChainedUpp(ctx context.Context, msg h.HTTPRequest) h.HTTPResponse {
+	done := c.startChaining(msg.ID)
+	defer done()
+
+	job := chainJob{
+		ctx:  ctx,
+		msg:  msg,
+		resp: make(chan h.HTTPResponse, 1),
+	}
+
+	err := c.enqueue(job)
+	if err != nil {
+		log.WithContext(ctx).Warnf("%s: %v", msg.ID, err)
+		return errorResponse(http.StatusServiceUnavailable, err.Error())
+	}
+
+	select {
+	case resp := <-job.resp:
+		return resp
+	case <-ctx.Done():
+		log.WithContext(ctx).Warnf("%s: chaining request canceled: %v", msg.ID, ctx.Err())
+		return errorResponse(http.StatusServiceUnavailable, "")
+	}
+}
+
+// ActiveWorkers returns the number of active workers
+func (c *ChainWorkers) ActiveWorkers() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.workers)
+}
+
+func (c *ChainWorkers) enqueue(job chainJob) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	w, found := c.workers[job.msg.ID]
+	if !found {
+		if len(c.workers) >= c.MaxWorkers {
+			return ErrTooManyChainWorkers
+		}
+		w = &chainWorker{
+			uid:   job.msg.ID,
+			queue: make(chan chainJob, c.QueueSize),
+		}
+		c.workers[w.uid] = w
+		go c.run(w)
+	}
+
+	select {
+	case w.queue <- job:
+		prom.ChainQueueDepth.WithLabelValues(w.uid.String()).Inc()
+		return nil
+	default:
+		return ErrChainQueueFull
+	}
+}
+
+// run processes the queue of the worker until the worker was idle for the idle timeout
+func (c *ChainWorkers) run(w *chainWorker) {
+	for {
+		select {
+		case job := <-w.queue:
+			prom.ChainQueueDepth.WithLabelValues(w.uid.String()).Dec()
+			job.resp <- c.process(job)
+		case <-time.After(c.IdleTimeout):
+			if c.stop(w) {
+				return
+			}
+		}
+	}
+}
+
+// stop removes the worker if no requests were queued in the meantime
+func (c *ChainWorkers) stop(w *chainWorker) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(w.queue) > 0 {
+		return false
+	}
+	delete(c.workers, w.uid)
+	prom.ChainQueueDepth.DeleteLabelValues(w.uid.String())
+	log.Debugf("%s: stopped idle chaining worker", w.uid)
+	return true
+}
+
+func (c *ChainWorkers) process(job chainJob) h.HTTPResponse {
+	// the request was canceled while it was queued
+	if job.ctx.Err() != nil {
+		return errorResponse(http.StatusServiceUnavailable, "")
+	}
+	return c.chainWithLock(job.ctx, job.msg)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestChainWorkers_StalledIdentity(t *testing.T) {
+	stalled := make(chan struct{})
+	var stalledUID uuid.UUID
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-ubirch-hardware-id") == stalledUID.String() {
+			<-stalled
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	defer close(stalled)
+
+	signer, _ := newTestSigner(t, backend.URL)
+	workers := NewChainWorkers(signer, 2, 10)
+	stalledUID = newTestIdentity(t, signer.Protocol)
+	otherUID := newTestIdentity(t, signer.Protocol)
+
+	// the requests of the stalled identity are queued behind each other
+	stalledResps := make(chan h.HTTPResponse, 2)
+	for _, data := range []string{"first", "second"} {
+		go func(data string) {
+			stalledResps <- workers.SendChainedUpp(context.Background(), h.HTTPRequest{ID: stalledUID, Auth: testAuth, Hash: testSHA256(data)})
+		}(data)
+		time.Sleep(10 * time.Millisecond) // keep the order of the requests
+	}
+
+	// the requests of the other identity are not blocked
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		resp := workers.SendChainedUpp(context.Background(), h.HTTPRequest{ID: otherUID, Auth: testAuth, Hash: testSHA256("other")})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected response code: expected %d, got %d: %s", http.StatusOK, resp.StatusCode, resp.Content)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("requests of other identity were blocked by stalled identity: %s", elapsed)
+	}
+
+	select {
+	case resp := <-stalledResps:
+		t.Fatalf("request of stalled identity returned: (%d) %s", resp.StatusCode, resp.Content)
+	default:
+	}
+
+	// the number of active workers is bounded
+	resp := workers.SendChainedUpp(context.Background(), h.HTTPRequest{ID: uuid.New(), Auth: testAuth, Hash: testSHA256("third")})
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected response code with too many workers: expected %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	// the stalled identity recovers: the UPPs are chained in the order of the requests
+	stalled <- struct{}{}
+	stalled <- struct{}{}
+
+	var upps [2][]byte
+	for i := range upps {
+		resp := <-stalledResps
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected response code: expected %d, got %d: %s", http.StatusOK, resp.StatusCode, resp.Content)
+		}
+		var signingResp signingResponse
+		err := json.Unmarshal(resp.Content, &signingResp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upps[i] = signingResp.UPP
+	}
+	if !bytes.Contains(upps[1], upps[0][len(upps[0])-64:]) {
+		t.Error("second UPP is not chained to the first UPP")
+	}
+}
+
+func TestChainWorkers_IdleTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	workers := NewChainWorkers(signer, 1, 10)
+	workers.IdleTimeout = 20 * time.Millisecond
+	uid := newTestIdentity(t, signer.Protocol)
+
+	resp := workers.SendChainedUpp(context.Background(), h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("test")})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response code: expected %d, got %d: %s", http.StatusOK, resp.StatusCode, resp.Content)
+	}
+	if workers.ActiveWorkers() != 1 {
+		t.Errorf("unexpected number of active workers: %d", workers.ActiveWorkers())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for workers.ActiveWorkers() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if workers.ActiveWorkers() != 0 {
+		t.Fatal("idle worker was not stopped")
+	}
+
+	// a new worker is started for the next request
+	resp = workers.SendChainedUpp(context.Background(), h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("test")})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected response code: expected %d, got %d: %s", http.StatusOK, resp.StatusCode, resp.Content)
+	}
+}
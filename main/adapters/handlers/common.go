@@ -6,6 +6,7 @@ import (
 
 // Later we can add authenticator
 type Globals struct {
-	Config  config.Config
-	Version string
+	Config   config.Config
+	Version  string
+	Revision string
 }
\ No newline at end of file
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/config"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// revokedAuthToken is stored as auth token of identities whose device was removed from the
+// configuration. No auth token is accepted for these identities, not even this one.
+const revokedAuthToken = "-revoked-"
+
+// ConfigReloader re-reads the configuration when the client receives a SIGHUP and applies the
+// changes of the device map at runtime, without restarting the HTTP server: added devices are
+// initialized and changed auth tokens are stored, so that they can be used right away.
+// Additional auth tokens of a device, e.g. the old token during a token rotation, are accepted
+// until they are removed from the configuration.
+//
+// Removed devices are not deleted, since this would irrevocably delete their keys. Use the
+// identity deregistration endpoint for that. Instead, the auth token of a removed device is
+// revoked, so that it can not be used anymore, until the device is added again. Changes of
+// other configurations are only logged, since they require a restart.
+type ConfigReloader struct {
+	*IdentityHandler
+	Signer  *Signer
+	devices map[string]config.AuthTokens
+	conf    config.Config
+	mutex   *sync.Mutex // guards the device map while a reload is applied
+}
+
+// NewConfigReloader returns a reloader for the configuration, the additional
+// auth tokens of the devices in the configuration are accepted right away
+func NewConfigReloader(conf config.Config, idHandler *IdentityHandler, signer *Signer) *ConfigReloader {
+	devices := make(map[string]config.AuthTokens, len(conf.Devices))
+	for name, auth := range conf.Devices {
+		devices[name] = auth
+
+		if uid, err := uuid.Parse(name); err == nil {
+			signer.SetAdditionalAuthTokens(uid, auth.Additional())
+		}
+	}
+
+	return &ConfigReloader{
+		IdentityHandler: idHandler,
+		Signer:          signer,
+		devices:         devices,
+		conf:            conf,
+		mutex:           &sync.Mutex{},
+	}
+}
+
+// Start reloads the configuration with the load function whenever the client
+// receives a SIGHUP, until the context is canceled
+func (c *ConfigReloader) Start(ctx context.Context, load func() (config.Config, error)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				log.Infof("reloading configuration after receiving SIGHUP")
+				conf, err := load()
+				if err != nil {
+					log.Errorf("reloading configuration failed: %v", err)
+					continue
+				}
+				err = c.Reload(conf)
+				if err != nil {
+					log.Errorf("applying reloaded configuration failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Devices returns a copy of the current device map
+func (c *ConfigReloader) Devices() map[string]config.AuthTokens {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	devices := make(map[string]config.AuthTokens, len(c.devices))
+	for name, auth := range c.devices {
+		devices[name] = auth
+	}
+	return devices
+}
+
+// Reload applies the changes of the device map and logs the changes which require a restart.
+// Devices which could not be initialized or updated are retried with the next reload.
+func (c *ConfigReloader) Reload(conf config.Config) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.logRestartRequired(conf)
+
+	var failed int
+	for name, auth := range conf.Devices {
+		if current, found := c.devices[name]; found && current.Equal(auth) {
+			continue
+		}
+
+		err := c.applyDevice(name, auth)
+		if err != nil {
+			log.Errorf("%s: %v", name, err)
+			failed++
+			continue
+		}
+		c.devices[name] = auth
+	}
+
+	for name := range c.devices {
+		if _, found := conf.Devices[name]; !found {
+			err := c.removeDevice(name)
+			if err != nil {
+				log.Errorf("%s: %v", name, err)
+				failed++
+				continue
+			}
+			log.Warnf("%s: device was removed from the configuration, its auth token was revoked, but the identity is kept. "+
+				"Use the deregistration endpoint to delete the identity", name)
+			delete(c.devices, name)
+		}
+	}
+
+	c.conf = conf
+
+	if failed > 0 {
+		return fmt.Errorf("%d devices could not be applied", failed)
+	}
+	return nil
+}
+
+// applyDevice initializes a new device or stores the changed auth token of an existing device
+// and sets its additional auth tokens
+func (c *ConfigReloader) applyDevice(name string, authTokens config.AuthTokens) error {
+	uid, err := uuid.Parse(name)
+	if err != nil {
+		return fmt.Errorf("invalid identity name \"%s\" (not a UUID): %s", name, err)
+	}
+
+	if len(authTokens) == 0 {
+		return fmt.Errorf("missing auth token for identity %s", name)
+	}
+	for _, token := range authTokens {
+		if len(token) == 0 {
+			return fmt.Errorf("missing auth token for identity %s", name)
+		}
+	}
+
+	err = c.storeDevice(uid, authTokens.Primary())
+	if err != nil {
+		return err
+	}
+
+	c.Signer.SetAdditionalAuthTokens(uid, authTokens.Additional())
+	return nil
+}
+
+// removeDevice revokes the auth token and the additional auth tokens of a device which was removed
+// from the configuration
+func (c *ConfigReloader) removeDevice(name string) error {
+	uid, err := uuid.Parse(name)
+	if err != nil {
+		return nil
+	}
+	c.Signer.SetAdditionalAuthTokens(uid, nil)
+
+	exists, err := c.Protocol.Exists(uid)
+	if err != nil {
+		return fmt.Errorf("can not check existing context for %s: %s", uid, err)
+	}
+	if !exists {
+		return nil
+	}
+
+	err = c.Protocol.SetAuthTokenWithLock(context.Background(), uid, revokedAuthToken)
+	if err != nil {
+		return fmt.Errorf("revoking auth token failed: %v", err)
+	}
+	c.Signer.ForgetAuthToken(uid)
+	return nil
+}
+
+// storeDevice initializes a new device or stores the changed auth token of an existing device
+func (c *ConfigReloader) storeDevice(uid uuid.UUID, auth string) error {
+	exists, err := c.Protocol.Exists(uid)
+	if err != nil {
+		return fmt.Errorf("can not check existing context for %s: %s", uid, err)
+	}
+
+	if !exists {
+		_, err = c.InitIdentity(uid, auth)
+		if err != nil {
+			return err
+		}
+		log.Infof("%s: initialized new device from reloaded configuration", uid)
+		return nil
+	}
+
+	storedAuth, err := c.Protocol.GetAuthToken(uid)
+	if err != nil {
+		return err
+	}
+	if storedAuth == auth {
+		return nil
+	}
+
+	err = c.Protocol.SetAuthTokenWithLock(context.Background(), uid, auth)
+	if err != nil {
+		return fmt.Errorf("storing auth token failed: %v", err)
+	}
+	c.Signer.ForgetAuthToken(uid)
+
+	log.Infof("%s: updated auth token from reloaded configuration", uid)
+	return nil
+}
+
+// logRestartRequired logs the changes of configurations which can not be applied at runtime
+func (c *ConfigReloader) logRestartRequired(conf config.Config) {
+	changes := []struct {
+		key     string
+		changed bool
+	}{
+		{"env", c.conf.Env != conf.Env},
+		{"postgresDSN", c.conf.PostgresDSN != conf.PostgresDSN},
+		{"secret32", c.conf.Secret32Base64 != conf.Secret32Base64},
+		{"TCP_addr", c.conf.TCP_addr != conf.TCP_addr},
+		{"TLS", c.conf.TLS != conf.TLS},
+		{"TLSCertFile", c.conf.TLS_CertFile != conf.TLS_CertFile},
+		{"TLSKeyFile", c.conf.TLS_KeyFile != conf.TLS_KeyFile},
+		{"TLSClientCA", c.conf.TLS_ClientCA != conf.TLS_ClientCA},
+		{"TLSRequireClientCert", c.conf.TLS_RequireClientCert != conf.TLS_RequireClientCert},
+		{"TLSMinVersion", c.conf.TLS_MinVersion != conf.TLS_MinVersion},
+		{"TLSCipherSuites", fmt.Sprint(c.conf.TLS_CipherSuites) != fmt.Sprint(conf.TLS_CipherSuites)},
+		{"TLSACME", c.conf.TLS_ACME != conf.TLS_ACME},
+		{"TLSACMEHosts", fmt.Sprint(c.conf.TLS_ACME_Hosts) != fmt.Sprint(conf.TLS_ACME_Hosts)},
+		{"UDP", c.conf.UDP != conf.UDP},
+		{"UDP_addr", c.conf.UDP_addr != conf.UDP_addr},
+	}
+
+	for _, change := range changes {
+		if change.changed {
+			log.Warnf("configuration '%s' changed: requires restart", change.key)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/config"
+)
+
+func newTestConfigReloader(t *testing.T, conf config.Config) *ConfigReloader {
+	// accepts key registrations, CSRs and UPPs
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+
+	signer, _ := newTestSigner(t, backend.URL)
+	signer.Protocol.KeyServiceURL = backend.URL
+	signer.Protocol.IdentityServiceURL = backend.URL
+
+	return NewConfigReloader(conf, &IdentityHandler{Protocol: signer.Protocol}, signer)
+}
+
+func TestConfigReloader_SIGHUP(t *testing.T) {
+	reloader := newTestConfigReloader(t, config.Config{Devices: map[string]config.AuthTokens{}})
+
+	uid := uuid.New()
+	load := func() (config.Config, error) {
+		return config.Config{Devices: map[string]config.AuthTokens{uid.String(): {testAuth}}}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloader.Start(ctx, load)
+
+	err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		exists, err := reloader.Signer.checkExists(uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("device added to configuration was not initialized after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	auth, err := reloader.Signer.getAuth(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != testAuth {
+		t.Errorf("unexpected auth token of new device: %s", auth)
+	}
+
+	// the new device can sign
+	resp := chainTestHash(t, reloader.Signer, uid, "reloaded")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("chaining with new device failed: (%d) %s", resp.StatusCode, resp.Content)
+	}
+}
+
+func TestConfigReloader_Reload(t *testing.T) {
+	uid := uuid.New()
+	reloader := newTestConfigReloader(t, config.Config{Devices: map[string]config.AuthTokens{}})
+
+	err := reloader.Reload(config.Config{Devices: map[string]config.AuthTokens{uid.String(): {testAuth}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// buffer the auth token
+	if _, err = reloader.Signer.getAuth(uid); err != nil {
+		t.Fatal(err)
+	}
+
+	// changed auth token
+	err = reloader.Reload(config.Config{Devices: map[string]config.AuthTokens{uid.String(): {"new-auth"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := reloader.Signer.getAuth(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != "new-auth" {
+		t.Errorf("auth token was not updated: %s", auth)
+	}
+
+	// token rotation: the new and the old auth token are accepted
+	err = reloader.Reload(config.Config{Devices: map[string]config.AuthTokens{uid.String(): {"rotated-auth", "new-auth"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acceptedAuth, err := reloader.Signer.getAcceptedAuth(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acceptedAuth) != 2 || acceptedAuth[0] != "rotated-auth" || acceptedAuth[1] != "new-auth" {
+		t.Errorf("unexpected accepted auth tokens: %v", acceptedAuth)
+	}
+
+	// removed device is kept
+	err = reloader.Reload(config.Config{Devices: map[string]config.AuthTokens{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloader.Devices()) != 0 {
+		t.Errorf("removed device is still in device map: %v", reloader.Devices())
+	}
+	exists, err := reloader.Protocol.Exists(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("identity of removed device was deleted")
+	}
+
+	// the auth tokens of the removed device are revoked
+	acceptedAuth, err = reloader.Signer.getAcceptedAuth(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acceptedAuth) != 0 {
+		t.Errorf("auth tokens of removed device are still accepted: %v", acceptedAuth)
+	}
+	for _, auth := range []string{"rotated-auth", "new-auth", revokedAuthToken} {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("X-Auth-Token", auth)
+		if _, err = checkAuth(r, uid, acceptedAuth, false); err == nil {
+			t.Errorf("auth token %q of removed device was accepted", auth)
+		}
+	}
+
+	// the auth token is restored, when the device is added again
+	err = reloader.Reload(config.Config{Devices: map[string]config.AuthTokens{uid.String(): {"new-auth"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptedAuth, err = reloader.Signer.getAcceptedAuth(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acceptedAuth) != 1 || acceptedAuth[0] != "new-auth" {
+		t.Errorf("auth token of re-added device was not restored: %v", acceptedAuth)
+	}
+
+	// ... also if it is added again while the client is not running
+	err = reloader.Reload(config.Config{Devices: map[string]config.AuthTokens{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = reloader.InitIdentities(map[string]config.AuthTokens{uid.String(): {"new-auth"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth, _ := reloader.Protocol.GetAuthToken(uid); auth != "new-auth" {
+		t.Errorf("revoked auth token was not restored on startup: %q", auth)
+	}
+
+	// invalid device
+	err = reloader.Reload(config.Config{Devices: map[string]config.AuthTokens{"not-a-uuid": {testAuth}}})
+	if err == nil {
+		t.Error("no error for invalid device")
+	}
+	if len(reloader.Devices()) != 0 {
+		t.Errorf("invalid device was added to device map: %v", reloader.Devices())
+	}
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/deadletter"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const (
+	DeadLetterPath     = "deadletter"
+	DeadLetterIDKey    = "deadLetterID"
+	DeadLetterIDHeader = "X-Dead-Letter-ID"
+
+	DefaultDeadLetterRetryInterval = 30 * time.Second
+)
+
+var (
+	errPreviousUndelivered = fmt.Errorf("previous UPPs of identity have not been delivered yet")
+	errOffline             = fmt.Errorf("UBIRCH Authentication Service unreachable (offline mode)")
+)
+
+type deadLetterRetryResponse struct {
+	ID        uuid.UUID      `json:"id"`
+	Delivered bool           `json:"delivered"`
+	Error     string         `json:"error,omitempty"`
+	Response  h.HTTPResponse `json:"response,omitempty"`
+}
+
+// mustQueue returns the reason why a new UPP of the identity must be added to the dead letter queue
+// instead of being sent, or nil if it can be sent. If there are undelivered UPPs of the identity,
+// new UPPs must be queued behind them, so that the UPPs are delivered in order.
+func (s *Signer) mustQueue(uid uuid.UUID) error {
+	if s.DeadLetters == nil {
+		return nil
+	}
+	if s.DeadLetters.Has(uid) {
+		return errPreviousUndelivered
+	}
+	if s.Offline.IsOffline() {
+		return errOffline
+	}
+	return nil
+}
+
+// deadLetterIfUndelivered adds the UPP to the dead letter queue if the backend could not be
+// reached or failed to process it, and returns a 202 response in that case
+func (s *Signer) deadLetterIfUndelivered(msg h.HTTPRequest, op operation, upp []byte, resp h.HTTPResponse) h.HTTPResponse {
+	if s.DeadLetters == nil || resp.StatusCode < http.StatusInternalServerError {
+		return resp
+	}
+	return s.deadLetter(msg, op, upp, fmt.Sprintf("(%d) %s", resp.StatusCode, resp.Content))
+}
+
+// deadLetter adds the UPP to the dead letter queue for re-submission in the background
+func (s *Signer) deadLetter(msg h.HTTPRequest, op operation, upp []byte, reason string) h.HTTPResponse {
+	entry, err := s.DeadLetters.Add(msg.ID, string(op), upp, reason)
+	if err != nil {
+		log.Errorf("%s: adding UPP to dead letter queue failed: %v", msg.ID, err)
+		return errorResponse(http.StatusInternalServerError, "")
+	}
+	log.Warnf("%s: UPP could not be delivered: %s, queued for re-submission as %s", msg.ID, reason, entry.ID)
+
+	resp := getSigningResponse(http.StatusAccepted, msg, upp, h.HTTPResponse{}, "",
+		"UPP could not be delivered to the UBIRCH backend yet and was queued for re-submission")
+	resp.Header.Set(DeadLetterIDHeader, entry.ID.String())
+	return resp
+}
+
+// DeadLetterService re-submits the UPPs from the dead letter queue to the ubirch backend in the
+// background, and offers endpoints to list the queued UPPs and to retry or drop them manually.
+// The endpoints are privileged operations, which must be served by the admin router.
+// The UPPs of an identity are re-submitted in the order in which they were queued.
+type DeadLetterService struct {
+	*Signer
+	RetryInterval time.Duration // time between re-submissions of queued UPPs
+	mutex         *sync.Mutex   // serializes re-submissions, so that the order per identity is preserved
+}
+
+func NewDeadLetterService(signer *Signer, retryInterval time.Duration) *DeadLetterService {
+	if retryInterval <= 0 {
+		retryInterval = DefaultDeadLetterRetryInterval
+	}
+	return &DeadLetterService{
+		Signer:        signer,
+		RetryInterval: retryInterval,
+		mutex:         &sync.Mutex{},
+	}
+}
+
+// Start re-submits the queued UPPs periodically until the context is canceled
+func (d *DeadLetterService) Start(ctx context.Context) {
+	if n := d.DeadLetters.Len(); n > 0 {
+		log.Warnf("%d undelivered UPPs in dead letter queue", n)
+	}
+
+	go func() {
+		ticker := time.NewTicker(d.RetryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.ResubmitAll()
+			}
+		}
+	}()
+}
+
+// ResubmitAll re-submits the queued UPPs of all identities in order. The re-submission of the
+// UPPs of an identity stops at the first UPP which can not be delivered.
+func (d *DeadLetterService) ResubmitAll() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.DeadLetters.Len() == 0 {
+		// nothing to forward, so the next request will show whether the backend is reachable
+		d.Offline.setOnline()
+		return
+	}
+
+	failed := map[uuid.UUID]bool{}
+	for {
+		progress := false
+		for _, e := range d.DeadLetters.Heads() {
+			if failed[e.UID] {
+				continue
+			}
+			if _, err := d.resubmit(e); err != nil {
+				failed[e.UID] = true
+				continue
+			}
+			progress = true
+		}
+		if !progress {
+			return
+		}
+	}
+}
+
+// resubmit sends the queued UPP to the ubirch backend and removes it from the queue if it was delivered
+func (d *DeadLetterService) resubmit(e deadletter.Entry) (h.HTTPResponse, error) {
+	auth, err := d.getAuth(e.UID)
+	if err != nil {
+		return h.HTTPResponse{}, d.failed(e, err)
+	}
+
+	ctx := context.Background()
+	resp, err := d.Protocol.SendToAuthService(ctx, e.UID, auth, e.UPP)
+	if err != nil {
+		if isUnreachable(ctx, err) {
+			d.Offline.setOffline(err)
+		}
+		return resp, d.failed(e, err)
+	}
+	d.Offline.setOnline()
+
+	// a conflict means that the backend already received the UPP
+	if h.HttpFailed(resp.StatusCode) && resp.StatusCode != http.StatusConflict {
+		return resp, d.failed(e, fmt.Errorf("(%d) %s", resp.StatusCode, resp.Content))
+	}
+
+	log.Infof("%s: re-submitted UPP %s: (%d)", e.UID, e.ID, resp.StatusCode)
+	err = d.DeadLetters.Remove(e.ID)
+	if err != nil {
+		log.Errorf("%s: removing delivered UPP %s from dead letter queue failed: %v", e.UID, e.ID, err)
+	}
+	return resp, nil
+}
+
+func (d *DeadLetterService) failed(e deadletter.Entry, err error) error {
+	log.Warnf("%s: re-submission of UPP %s failed: %v", e.UID, e.ID, err)
+	if storeErr := d.DeadLetters.Failed(e.ID, err.Error()); storeErr != nil {
+		log.Errorf("%s: updating UPP %s in dead letter queue failed: %v", e.UID, e.ID, storeErr)
+	}
+	return err
+}
+
+// HandleList responds with all queued UPPs
+func (d *DeadLetterService) HandleList(w http.ResponseWriter, r *http.Request) {
+	content, err := json.Marshal(d.DeadLetters.List())
+	if err != nil {
+		log.Errorf("error serializing dead letters: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {h.JSONType}},
+		Content:    content,
+	})
+}
+
+// HandleRetry re-submits the queued UPP with the ID from the request URL. Only the oldest
+// queued UPP of an identity can be re-submitted, so that the order of the UPPs is preserved.
+func (d *DeadLetterService) HandleRetry(w http.ResponseWriter, r *http.Request) {
+	e, ok := d.getEntry(w, r)
+	if !ok {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, head := range d.DeadLetters.Heads() {
+		if head.UID == e.UID && head.ID != e.ID {
+			h.Error(e.UID, w, fmt.Errorf("%v: retry dead letter %s first", errPreviousUndelivered, head.ID), http.StatusConflict)
+			return
+		}
+	}
+
+	backendResp, err := d.resubmit(e)
+
+	respCode := http.StatusOK
+	retryResp := deadLetterRetryResponse{ID: e.ID, Delivered: err == nil, Response: backendResp}
+	if err != nil {
+		respCode = http.StatusBadGateway
+		retryResp.Error = err.Error()
+	}
+
+	content, err := json.Marshal(retryResp)
+	if err != nil {
+		log.Warnf("error serializing dead letter retry response: %v", err)
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: respCode,
+		Header:     http.Header{"Content-Type": {h.JSONType}},
+		Content:    content,
+	})
+}
+
+// HandleDrop removes the queued UPP with the ID from the request URL without re-submitting it
+func (d *DeadLetterService) HandleDrop(w http.ResponseWriter, r *http.Request) {
+	e, ok := d.getEntry(w, r)
+	if !ok {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	err := d.DeadLetters.Remove(e.ID)
+	if err == deadletter.ErrNotFound {
+		h.Error(e.UID, w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("%s: dropping UPP %s failed: %v", e.UID, e.ID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	log.Warnf("%s: dropped undelivered UPP %s", e.UID, e.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *DeadLetterService) getEntry(w http.ResponseWriter, r *http.Request) (deadletter.Entry, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, DeadLetterIDKey))
+	if err != nil {
+		h.Error(uuid.Nil, w, fmt.Errorf("invalid dead letter ID: %v", err), http.StatusNotFound)
+		return deadletter.Entry{}, false
+	}
+
+	e, err := d.DeadLetters.Get(id)
+	if err != nil {
+		h.Error(uuid.Nil, w, err, http.StatusNotFound)
+		return deadletter.Entry{}, false
+	}
+	return e, true
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/deadletter"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const testAdminAuth = "test-admin-auth"
+
+// deadLetterBackend is a mock of the ubirch backend which can be switched between outage and recovery
+type deadLetterBackend struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	down     bool
+	received [][]byte
+}
+
+func newDeadLetterBackend() *deadLetterBackend {
+	b := &deadLetterBackend{down: true}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		if b.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b.received = append(b.received, body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	return b
+}
+
+func (b *deadLetterBackend) setDown(down bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.down = down
+}
+
+func newTestDeadLetterService(t *testing.T, backendURL string) (*DeadLetterService, *mockCtxManager) {
+	signer, ctxManager := newTestSigner(t, backendURL)
+
+	var err error
+	signer.DeadLetters, err = deadletter.NewQueue(filepath.Join(t.TempDir(), "dead_letters.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewDeadLetterService(signer, 0), ctxManager
+}
+
+func chainTestHash(t *testing.T, s *Signer, uid uuid.UUID, data string) h.HTTPResponse {
+	tx, identity, err := s.Protocol.FetchIdentityWithLock(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.chain(context.Background(), h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256(data)}, tx, identity)
+}
+
+func TestDeadLetterService_Outage(t *testing.T) {
+	backend := newDeadLetterBackend()
+	defer backend.Close()
+
+	d, ctxManager := newTestDeadLetterService(t, backend.URL)
+	uid := newTestIdentity(t, d.Protocol)
+
+	// backend outage: UPPs are queued and the chain advances
+	var queued [][]byte
+	for _, data := range []string{"first", "second"} {
+		resp := chainTestHash(t, d.Signer, uid, data)
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("unexpected response code: expected %d, got %d", http.StatusAccepted, resp.StatusCode)
+		}
+		if resp.Header.Get(DeadLetterIDHeader) == "" {
+			t.Errorf("response is missing %s header", DeadLetterIDHeader)
+		}
+
+		var signingResp signingResponse
+		err := json.Unmarshal(resp.Content, &signingResp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ctxManager.signature(uid), signingResp.UPP[len(signingResp.UPP)-64:]) {
+			t.Error("signature of queued UPP was not persisted")
+		}
+		queued = append(queued, signingResp.UPP)
+	}
+
+	if d.DeadLetters.Len() != 2 {
+		t.Fatalf("unexpected number of dead letters: %d", d.DeadLetters.Len())
+	}
+
+	// still down: nothing is delivered and the UPPs stay queued
+	d.ResubmitAll()
+	if d.DeadLetters.Len() != 2 {
+		t.Fatalf("unexpected number of dead letters after failed re-submission: %d", d.DeadLetters.Len())
+	}
+	if attempts := d.DeadLetters.Heads()[0].Attempts; attempts != 2 {
+		t.Errorf("unexpected number of attempts: %d", attempts)
+	}
+
+	// recovery: the UPPs are delivered in order
+	backend.setDown(false)
+	d.ResubmitAll()
+	if d.DeadLetters.Len() != 0 {
+		t.Fatalf("dead letters were not delivered: %d left", d.DeadLetters.Len())
+	}
+	if len(backend.received) != len(queued) {
+		t.Fatalf("unexpected number of delivered UPPs: %d", len(backend.received))
+	}
+	for i := range queued {
+		if !bytes.Equal(backend.received[i], queued[i]) {
+			t.Errorf("UPP %d was not delivered in order", i+1)
+		}
+	}
+
+	// new UPPs are sent directly again
+	resp := chainTestHash(t, d.Signer, uid, "third")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestDeadLetterService_Endpoints(t *testing.T) {
+	backend := newDeadLetterBackend()
+	defer backend.Close()
+
+	d, _ := newTestDeadLetterService(t, backend.URL)
+	uid := newTestIdentity(t, d.Protocol)
+
+	first := chainTestHash(t, d.Signer, uid, "first").Header.Get(DeadLetterIDHeader)
+	second := chainTestHash(t, d.Signer, uid, "second").Header.Get(DeadLetterIDHeader)
+
+	router := chi.NewMux()
+	router.Use(h.RequireAdmin(testAdminAuth))
+	router.Get("/"+DeadLetterPath, d.HandleList)
+	router.Post("/"+DeadLetterPath+"/{"+DeadLetterIDKey+"}/retry", d.HandleRetry)
+	router.Delete("/"+DeadLetterPath+"/{"+DeadLetterIDKey+"}", d.HandleDrop)
+
+	request := func(method, path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(h.AdminTokenHeader, auth)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// the registration auth token is not accepted instead of the admin token
+	if w := request(http.MethodGet, "/deadletter", testRegisterAuth); w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected response code with wrong auth: %d", w.Code)
+	}
+
+	w := request(http.MethodGet, "/deadletter", testAdminAuth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: %d", w.Code)
+	}
+	var entries []deadletter.Entry
+	err := json.Unmarshal(w.Body.Bytes(), &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID.String() != first || entries[1].ID.String() != second {
+		t.Errorf("unexpected dead letters: %v", entries)
+	}
+
+	// only the oldest UPP of an identity can be retried
+	if w = request(http.MethodPost, "/deadletter/"+second+"/retry", testAdminAuth); w.Code != http.StatusConflict {
+		t.Errorf("unexpected response code retrying queued UPP out of order: %d", w.Code)
+	}
+
+	if w = request(http.MethodPost, "/deadletter/"+first+"/retry", testAdminAuth); w.Code != http.StatusBadGateway {
+		t.Errorf("unexpected response code retrying during outage: %d", w.Code)
+	}
+
+	backend.setDown(false)
+	if w = request(http.MethodPost, "/deadletter/"+first+"/retry", testAdminAuth); w.Code != http.StatusOK {
+		t.Errorf("unexpected response code retrying after recovery: %d", w.Code)
+	}
+
+	if w = request(http.MethodDelete, "/deadletter/"+second, testAdminAuth); w.Code != http.StatusNoContent {
+		t.Errorf("unexpected response code dropping UPP: %d", w.Code)
+	}
+	if w = request(http.MethodDelete, "/deadletter/"+second, testAdminAuth); w.Code != http.StatusNotFound {
+		t.Errorf("unexpected response code dropping unknown UPP: %d", w.Code)
+	}
+
+	if d.DeadLetters.Len() != 0 {
+		t.Errorf("unexpected number of dead letters: %d", d.DeadLetters.Len())
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	DeduplicatedHeader        = "X-Deduplicated"
+	DefaultIdempotencyEntries = 10000
+
+	maxIdempotencyKeyLength = 255
+)
+
+var (
+	ErrInvalidIdempotencyKey = fmt.Errorf("invalid %s header: expected 1 to %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength)
+	ErrIdempotencyKeyReused  = fmt.Errorf("%s was already used for a different request", IdempotencyKeyHeader)
+	ErrIdempotencyCacheFull  = fmt.Errorf("too many requests in progress")
+)
+
+// IdempotencyCache keeps the signing responses of requests with an Idempotency-Key header, so that a client,
+// which retries a request, e.g. after a timeout, gets the response of the first request instead of a second UPP.
+// With the operation and the hash as key, it deduplicates requests which are resent with the same hash.
+//
+// Only successful responses are kept, for the duration of the TTL. Requests with the same key, which arrive while
+// the first request is being processed, wait for its response. The number of entries is bounded: if the maximum
+// number of entries is reached, the least recently used response is evicted. Requests which are being processed
+// are never evicted, so new requests are rejected, if all entries are requests which are being processed.
+type IdempotencyCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	entries map[string]*list.Element
+	order   *list.List // least recently used entry at the back
+	mutex   *sync.Mutex
+	now     func() time.Time
+}
+
+type idempotentRequest struct {
+	key     string
+	op      operation
+	hash    []byte
+	done    chan struct{} // closed when the response is available
+	resp    h.HTTPResponse
+	expires time.Time
+}
+
+// NewIdempotencyCache returns an idempotency cache which keeps at most maxEntries responses for the given TTL
+func NewIdempotencyCache(ttl time.Duration, maxEntries int) *IdempotencyCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultIdempotencyEntries
+	}
+	return &IdempotencyCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+		mutex:      &sync.Mutex{},
+		now:        time.Now,
+	}
+}
+
+// Do returns the response of a previous request of the identity with the same idempotency key, or calls sign
+// and keeps its response, if it was successful. Returns true, if the response is the response of a previous
+// request, and ErrIdempotencyKeyReused, if the previous request was for a different hash or operation.
+// Returns ErrIdempotencyCacheFull, if the maximum number of requests is being processed.
+func (c *IdempotencyCache) Do(ctx context.Context, uid uuid.UUID, key string, op operation, hash []byte,
+	sign func() h.HTTPResponse) (resp h.HTTPResponse, replayed bool, err error) {
+
+	if len(key) == 0 || len(key) > maxIdempotencyKeyLength {
+		return h.HTTPResponse{}, false, ErrInvalidIdempotencyKey
+	}
+	key = uid.String() + ":" + key
+
+	c.mutex.Lock()
+	if elem, found := c.entries[key]; found && c.expired(elem) {
+		c.remove(elem)
+	}
+
+	if elem, found := c.entries[key]; found {
+		c.order.MoveToFront(elem)
+		req := elem.Value.(*idempotentRequest)
+		c.mutex.Unlock()
+
+		if req.op != op || !bytes.Equal(req.hash, hash) {
+			return h.HTTPResponse{}, false, ErrIdempotencyKeyReused
+		}
+
+		select {
+		case <-req.done:
+			return req.resp, true, nil
+		case <-ctx.Done():
+			return errorResponse(http.StatusServiceUnavailable, ""), false, nil
+		}
+	}
+
+	if c.order.Len() >= c.maxEntries && !c.evictResponse() {
+		c.mutex.Unlock()
+		return h.HTTPResponse{}, false, ErrIdempotencyCacheFull
+	}
+
+	req := &idempotentRequest{
+		key:  key,
+		op:   op,
+		hash: hash,
+		done: make(chan struct{}),
+	}
+	c.entries[key] = c.order.PushFront(req)
+	c.mutex.Unlock()
+
+	req.resp = sign()
+
+	c.mutex.Lock()
+	if h.HttpSuccess(req.resp.StatusCode) {
+		req.expires = c.now().Add(c.ttl)
+	} else if elem, found := c.entries[key]; found && elem.Value == req {
+		// a failed request may be retried
+		c.remove(elem)
+	}
+	c.mutex.Unlock()
+	close(req.done)
+
+	return req.resp, false, nil
+}
+
+// Len returns the number of entries
+func (c *IdempotencyCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.order.Len()
+}
+
+// expired returns true if the TTL of the response has elapsed. Requests which are being processed do not expire.
+func (c *IdempotencyCache) expired(elem *list.Element) bool {
+	expires := elem.Value.(*idempotentRequest).expires
+	return !expires.IsZero() && !expires.After(c.now())
+}
+
+// evictResponse removes the least recently used response and returns false, if there is none,
+// i.e. if all entries are requests which are being processed
+func (c *IdempotencyCache) evictResponse() bool {
+	for elem := c.order.Back(); elem != nil; elem = elem.Prev() {
+		if !elem.Value.(*idempotentRequest).expires.IsZero() {
+			c.remove(elem)
+			return true
+		}
+	}
+	return false
+}
+
+func (c *IdempotencyCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*idempotentRequest).key)
+}
+
+// idempotencyKey returns the Idempotency-Key header of the request
+func idempotencyKey(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader))
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	c := NewIdempotencyCache(time.Minute, 2)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	uid := uuid.New()
+	var calls int
+	sign := func(code int) func() h.HTTPResponse {
+		return func() h.HTTPResponse {
+			calls++
+			return h.HTTPResponse{StatusCode: code, Content: []byte(fmt.Sprint(calls))}
+		}
+	}
+	ctx := context.Background()
+
+	resp, replayed, err := c.Do(ctx, uid, "a", chainHash, testSHA256("a"), sign(http.StatusOK))
+	if err != nil || replayed || string(resp.Content) != "1" {
+		t.Fatalf("unexpected result of first request: %s, %t, %v", resp.Content, replayed, err)
+	}
+	resp, replayed, err = c.Do(ctx, uid, "a", chainHash, testSHA256("a"), sign(http.StatusOK))
+	if err != nil || !replayed || string(resp.Content) != "1" {
+		t.Errorf("unexpected result of repeated request: %s, %t, %v", resp.Content, replayed, err)
+	}
+
+	// the key is bound to the request
+	if _, _, err = c.Do(ctx, uid, "a", chainHash, testSHA256("b"), sign(http.StatusOK)); err != ErrIdempotencyKeyReused {
+		t.Errorf("unexpected error for different hash: %v", err)
+	}
+	if _, _, err = c.Do(ctx, uid, "a", anchorHash, testSHA256("a"), sign(http.StatusOK)); err != ErrIdempotencyKeyReused {
+		t.Errorf("unexpected error for different operation: %v", err)
+	}
+	if _, replayed, _ = c.Do(ctx, uuid.New(), "a", chainHash, testSHA256("a"), sign(http.StatusOK)); replayed {
+		t.Error("response of other identity was returned")
+	}
+	if _, _, err = c.Do(ctx, uid, "", chainHash, testSHA256("a"), sign(http.StatusOK)); err != ErrInvalidIdempotencyKey {
+		t.Errorf("unexpected error for empty key: %v", err)
+	}
+
+	// failed requests may be retried
+	if _, _, err = c.Do(ctx, uid, "b", chainHash, testSHA256("b"), sign(http.StatusInternalServerError)); err != nil {
+		t.Fatal(err)
+	}
+	if _, replayed, _ = c.Do(ctx, uid, "b", chainHash, testSHA256("b"), sign(http.StatusOK)); replayed {
+		t.Error("failed response was returned for retried request")
+	}
+	if c.Len() != 2 {
+		t.Errorf("unexpected number of entries: %d", c.Len())
+	}
+
+	// responses expire after the TTL
+	now = now.Add(time.Minute)
+	if _, replayed, _ = c.Do(ctx, uid, "b", chainHash, testSHA256("b"), sign(http.StatusOK)); replayed {
+		t.Error("expired response was returned")
+	}
+}
+
+func TestIdempotencyCache_PendingNotEvicted(t *testing.T) {
+	c := NewIdempotencyCache(time.Minute, 2)
+	uid := uuid.New()
+	ctx := context.Background()
+
+	ok := func() h.HTTPResponse { return h.HTTPResponse{StatusCode: http.StatusOK} }
+
+	// the first request is still being processed when the cache is full
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pending := make(chan bool)
+	go func() {
+		_, replayed, _ := c.Do(ctx, uid, "pending", chainHash, testSHA256("pending"), func() h.HTTPResponse {
+			close(started)
+			<-release
+			return ok()
+		})
+		pending <- replayed
+	}()
+	<-started
+
+	if _, _, err := c.Do(ctx, uid, "a", chainHash, testSHA256("a"), ok); err != nil {
+		t.Fatal(err)
+	}
+	// the response of "a" is evicted instead of the pending request
+	if _, _, err := c.Do(ctx, uid, "b", chainHash, testSHA256("b"), ok); err != nil {
+		t.Fatal(err)
+	}
+	if _, replayed, _ := c.Do(ctx, uid, "a", chainHash, testSHA256("a"), ok); replayed {
+		t.Error("evicted response was returned")
+	}
+
+	// a retry of the pending request waits for its response instead of signing again
+	retried := make(chan bool)
+	go func() {
+		_, replayed, _ := c.Do(ctx, uid, "pending", chainHash, testSHA256("pending"), func() h.HTTPResponse {
+			t.Error("pending request was signed twice")
+			return ok()
+		})
+		retried <- replayed
+	}()
+
+	// new requests are rejected, if all entries are requests which are being processed
+	blocked := make(chan struct{})
+	go func() {
+		_, _, _ = c.Do(ctx, uid, "blocking", chainHash, testSHA256("blocking"), func() h.HTTPResponse {
+			<-blocked
+			return ok()
+		})
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, _, err := c.Do(ctx, uid, "c", chainHash, testSHA256("c"), ok); err == ErrIdempotencyCacheFull {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("new request was accepted while all entries are being processed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	if <-pending {
+		t.Error("pending request was replayed")
+	}
+	if !<-retried {
+		t.Error("retry of pending request was not replayed")
+	}
+	close(blocked)
+}
+
+func TestChainingService_Idempotency(t *testing.T) {
+	var backendRequests int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backendRequests, 1)
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, ctxManager := newTestSigner(t, backend.URL)
+	signer.Idempotency = NewIdempotencyCache(time.Minute, 0)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+
+	send := func(hash []byte, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s", uid, h.HashEndpoint), bytes.NewReader(hash))
+		r.Header.Set(h.XAuthHeader, testAuth)
+		r.Header.Set("Content-Type", h.BinType)
+		r.Header.Set(IdempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	hash := testSHA256("event")
+	first := send(hash, "event-1")
+	if first.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: %d: %s", first.Code, first.Body.String())
+	}
+	signature := ctxManager.signature(uid)
+
+	// the retry returns the identical UPP without advancing the chain
+	second := send(hash, "event-1")
+	if second.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: %d: %s", second.Code, second.Body.String())
+	}
+	if second.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Error("repeated response is not marked as replayed")
+	}
+
+	var firstResp, secondResp signingResponse
+	if err := json.Unmarshal(first.Body.Bytes(), &firstResp); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(second.Body.Bytes(), &secondResp); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(firstResp.UPP, secondResp.UPP) {
+		t.Error("repeated request returned a different UPP")
+	}
+	if !bytes.Equal(ctxManager.signature(uid), signature) {
+		t.Error("repeated request advanced the chain")
+	}
+	if atomic.LoadInt32(&backendRequests) != 1 {
+		t.Errorf("unexpected number of backend requests: %d", backendRequests)
+	}
+
+	if w := send(testSHA256("other event"), "event-1"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unexpected response code for reused key: %d", w.Code)
+	}
+
+	// concurrent retries are signed once
+	wg := &sync.WaitGroup{}
+	upps := make([][]byte, 5)
+	for i := range upps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := send(testSHA256("concurrent event"), "event-2")
+			var resp signingResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Error(err)
+				return
+			}
+			upps[i] = resp.UPP
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range upps {
+		if !bytes.Equal(upps[i], upps[0]) {
+			t.Errorf("concurrent request %d returned a different UPP", i)
+		}
+	}
+	if atomic.LoadInt32(&backendRequests) != 2 {
+		t.Errorf("unexpected number of backend requests: %d", backendRequests)
+	}
+}
+
+func TestServices_Dedup(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, ctxManager := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+	router.Post(fmt.Sprintf("/{%s}/{%s}/%s", h.UUIDKey, h.OperationKey, h.HashEndpoint), (&SigningService{Signer: signer}).HandleRequest)
+
+	send := func(path string, hash []byte) (*httptest.ResponseRecorder, []byte) {
+		r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s%s/%s", uid, path, h.HashEndpoint), bytes.NewReader(hash))
+		r.Header.Set(h.XAuthHeader, testAuth)
+		r.Header.Set("Content-Type", h.BinType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected response code: %d: %s", w.Code, w.Body.String())
+		}
+		var resp signingResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return w, resp.UPP
+	}
+
+	// without deduplication, the same hash is signed again
+	hash := testSHA256("resent")
+	_, first := send("", hash)
+	_, second := send("", hash)
+	if bytes.Equal(first, second) {
+		t.Fatal("hash was deduplicated although deduplication is disabled")
+	}
+	_, first = send("/anchor", hash)
+	_, second = send("/anchor", hash)
+	if bytes.Equal(first, second) {
+		t.Fatal("re-anchored hash was deduplicated although deduplication is disabled")
+	}
+
+	signer.Dedup = NewIdempotencyCache(time.Minute, 0)
+	now := time.Now()
+	signer.Dedup.now = func() time.Time { return now }
+
+	// dedup hit: the resent hash gets the response of the first request without advancing the chain
+	_, first = send("", hash)
+	signature := ctxManager.signature(uid)
+	w, second := send("", hash)
+	if !bytes.Equal(first, second) {
+		t.Error("resent hash was signed again")
+	}
+	if w.Header().Get(DeduplicatedHeader) != "true" {
+		t.Error("response is not marked as deduplicated")
+	}
+	if !bytes.Equal(ctxManager.signature(uid), signature) {
+		t.Error("resent hash advanced the chain")
+	}
+
+	// dedup miss: other hashes, other operations and hashes resent after the window are signed
+	if _, other := send("", testSHA256("other")); bytes.Equal(other, first) {
+		t.Error("other hash was deduplicated")
+	}
+	w, anchored := send("/anchor", hash)
+	if bytes.Equal(anchored, first) || w.Header().Get(DeduplicatedHeader) != "" {
+		t.Error("hash was deduplicated across operations")
+	}
+	now = now.Add(time.Minute)
+	w, third := send("", hash)
+	if bytes.Equal(third, first) || w.Header().Get(DeduplicatedHeader) != "" {
+		t.Error("hash was deduplicated after the dedup window")
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-client-go/main/ent"
 
 	log "github.com/sirupsen/logrus"
 	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
@@ -25,6 +28,17 @@ type IdentityPayload struct {
 
 type StoreIdentity func(uid uuid.UUID, auth string) (csr []byte, err error)
 type CheckIdentityExists func(uid uuid.UUID) (bool, error)
+type DeleteIdentity func(uid uuid.UUID, deactivateKey bool) error
+type ListIdentities func(limit, offset int) ([]ent.IdentityInfo, error)
+
+const (
+	deactivateKeyQueryKey = "deactivateKey"
+	limitQueryKey         = "limit"
+	offsetQueryKey        = "offset"
+
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
 
 func NewIdentityCreator(auth string) IdentityCreator {
 	return IdentityCreator{auth: auth}
@@ -33,7 +47,7 @@ func NewIdentityCreator(auth string) IdentityCreator {
 func (i *IdentityCreator) Put(storeId StoreIdentity, idExists CheckIdentityExists) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get(h.XAuthHeader)
-		if authHeader != i.auth {
+		if !equalAuth(i.auth, authHeader) {
 			log.Warnf("unauthorized registration attempt")
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
@@ -84,7 +98,115 @@ func (i *IdentityCreator) Put(storeId StoreIdentity, idExists CheckIdentityExist
 		}
 
 		prom.IdentityCreationCounter.Inc()
+
+		// the service is ready as soon as the first identity is registered
+		if !h.IsReady() {
+			h.SetReady()
+			log.Info("ready")
+		}
+	}
+}
+
+// Delete deletes the identity with the UUID from the request URL. It is a privileged operation,
+// which must be served by the admin router.
+func (i *IdentityCreator) Delete(deleteId DeleteIdentity, idExists CheckIdentityExists) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uid, err := h.GetUUID(r)
+		if err != nil {
+			h.Error(uid, w, err, http.StatusNotFound)
+			return
+		}
+
+		exists, err := idExists(uid)
+		if err != nil {
+			log.Errorf("%s: %v", uid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if !exists {
+			h.Error(uid, w, fmt.Errorf("unknown UUID"), http.StatusNotFound)
+			return
+		}
+
+		deactivateKey := r.URL.Query().Get(deactivateKeyQueryKey) == "true"
+
+		err = deleteId(uid, deactivateKey)
+		if err == repository.ErrNotExist {
+			h.Error(uid, w, fmt.Errorf("unknown UUID"), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Errorf("%s: %v", uid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		log.Infof("%s: identity deleted", uid)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// List responds with a JSON array of the registered identities. The number of identities
+// in the response can be controlled with the "limit" and "offset" query parameters.
+// It is a privileged operation, which must be served by the admin router.
+func (i *IdentityCreator) List(listIds ListIdentities) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, err := getQueryInt(r, limitQueryKey, DefaultListLimit)
+		if err != nil {
+			h.Respond400(w, err.Error())
+			return
+		}
+		if limit < 1 || limit > MaxListLimit {
+			h.Respond400(w, fmt.Sprintf("invalid %s: must be between 1 and %d", limitQueryKey, MaxListLimit))
+			return
+		}
+
+		offset, err := getQueryInt(r, offsetQueryKey, 0)
+		if err != nil {
+			h.Respond400(w, err.Error())
+			return
+		}
+		if offset < 0 {
+			h.Respond400(w, fmt.Sprintf("invalid %s: must not be negative", offsetQueryKey))
+			return
+		}
+
+		infos, err := listIds(limit, offset)
+		if err != nil {
+			log.Errorf("could not load identities: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		content, err := json.Marshal(infos)
+		if err != nil {
+			log.Errorf("error serializing identities: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		h.SendResponse(w, h.HTTPResponse{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {h.JSONType}},
+			Content:    content,
+		})
+	}
+}
+
+// getQueryInt returns the integer value of the query parameter with the given key
+// or the default value if the parameter is not set
+func getQueryInt(r *http.Request, key string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", key, value)
 	}
+	return i, nil
 }
 
 func IdentityFromBody(r *http.Request) (IdentityPayload, error) {
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-client-go/main/ent"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const testRegisterAuth = "test-register-auth"
+
+func TestIdentityCreator_Delete(t *testing.T) {
+	var tests = []struct {
+		name               string
+		auth               string
+		unknownUUID        bool
+		query              string
+		keyServiceCode     int
+		expectedCode       int
+		expectedDeleted    bool
+		expectedKeyDeleted bool
+	}{
+		{
+			name:            "delete",
+			auth:            testAdminAuth,
+			expectedCode:    http.StatusNoContent,
+			expectedDeleted: true,
+		},
+		{
+			name:               "delete and deactivate key",
+			auth:               testAdminAuth,
+			query:              "?deactivateKey=true",
+			keyServiceCode:     http.StatusOK,
+			expectedCode:       http.StatusNoContent,
+			expectedDeleted:    true,
+			expectedKeyDeleted: true,
+		},
+		{
+			name:           "key deactivation failed",
+			auth:           testAdminAuth,
+			query:          "?deactivateKey=true",
+			keyServiceCode: http.StatusBadRequest,
+			expectedCode:   http.StatusInternalServerError,
+		},
+		{
+			name:         "unknown UUID",
+			auth:         testAdminAuth,
+			unknownUUID:  true,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "registration auth token instead of admin token",
+			auth:         testRegisterAuth,
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var keyDel *keyDeletion
+			keyService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodDelete {
+					t.Errorf("unexpected method of key service request: %s", r.Method)
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				keyDel = &keyDeletion{}
+				_ = json.Unmarshal(body, keyDel)
+				w.WriteHeader(test.keyServiceCode)
+			}))
+			defer keyService.Close()
+
+			p, err := repository.NewExtendedProtocol(newMockCtxManager(), testSecret, &clients.Client{KeyServiceURL: keyService.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			uid := newTestIdentity(t, p)
+
+			pubKeyPEM, err := p.GetPublicKey(uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pubKey, err := p.PublicKeyPEMToBytes(pubKeyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			idHandler := &IdentityHandler{Protocol: p}
+			deleteId := func(uid uuid.UUID, deactivateKey bool) error {
+				return idHandler.DeleteIdentity(uid, deactivateKey)
+			}
+			creator := NewIdentityCreator(testRegisterAuth)
+
+			router := chi.NewMux()
+			router.Use(h.RequireAdmin(testAdminAuth))
+			router.Delete(fmt.Sprintf("/%s/{%s}", h.RegisterEndpoint, h.UUIDKey), creator.Delete(deleteId, p.Exists))
+
+			requestUID := uid
+			if test.unknownUUID {
+				requestUID = uuid.New()
+			}
+
+			r := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/%s/%s%s", h.RegisterEndpoint, requestUID, test.query), nil)
+			r.Header.Set(h.AdminTokenHeader, test.auth)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Errorf("unexpected response code: expected %d, got %d: %s", test.expectedCode, w.Code, w.Body.String())
+			}
+
+			exists, err := p.Exists(uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists == test.expectedDeleted {
+				t.Errorf("unexpected existence of identity after request: %v", exists)
+			}
+
+			if test.expectedKeyDeleted {
+				if keyDel == nil {
+					t.Fatal("key service was not requested to delete the public key")
+				}
+				ok, err := p.Crypto.Verify(pubKeyPEM, keyDel.PublicKey, keyDel.Signature)
+				if err != nil || !ok {
+					t.Errorf("invalid signature of key deletion: %v", err)
+				}
+				if string(keyDel.PublicKey) != string(pubKey) {
+					t.Errorf("unexpected public key in key deletion: %x", keyDel.PublicKey)
+				}
+			}
+		})
+	}
+}
+
+func TestIdentityCreator_List(t *testing.T) {
+	p, err := repository.NewExtendedProtocol(newMockCtxManager(), testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var uids []string
+	for i := 0; i < 3; i++ {
+		uids = append(uids, newTestIdentity(t, p).String())
+	}
+	sort.Strings(uids)
+
+	// chain a UPP with the second identity
+	signature := make([]byte, p.SignatureLength())
+	signature[0] = 1
+	err = p.SetSignature(nil, uuid.MustParse(uids[1]), signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name         string
+		auth         string
+		query        string
+		expectedCode int
+		expectedUIDs []string
+	}{
+		{
+			name:         "list all",
+			auth:         testAdminAuth,
+			expectedCode: http.StatusOK,
+			expectedUIDs: uids,
+		},
+		{
+			name:         "limit and offset",
+			auth:         testAdminAuth,
+			query:        "?limit=1&offset=1",
+			expectedCode: http.StatusOK,
+			expectedUIDs: uids[1:2],
+		},
+		{
+			name:         "offset out of range",
+			auth:         testAdminAuth,
+			query:        "?offset=10",
+			expectedCode: http.StatusOK,
+			expectedUIDs: []string{},
+		},
+		{
+			name:         "invalid limit",
+			auth:         testAdminAuth,
+			query:        "?limit=0",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid offset",
+			auth:         testAdminAuth,
+			query:        "?offset=x",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "registration auth token instead of admin token",
+			auth:         testRegisterAuth,
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creator := NewIdentityCreator(testRegisterAuth)
+
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%s%s", h.RegisterEndpoint, test.query), nil)
+			r.Header.Set(h.AdminTokenHeader, test.auth)
+			w := httptest.NewRecorder()
+			h.RequireAdmin(testAdminAuth)(creator.List(p.GetIdentityInfos)).ServeHTTP(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", test.expectedCode, w.Code, w.Body.String())
+			}
+			if test.expectedCode != http.StatusOK {
+				return
+			}
+
+			if strings.Contains(w.Body.String(), testAuth) {
+				t.Error("response contains auth token")
+			}
+
+			var infos []ent.IdentityInfo
+			err := json.Unmarshal(w.Body.Bytes(), &infos)
+			if err != nil {
+				t.Fatalf("unable to decode response: %v", err)
+			}
+			if len(infos) != len(test.expectedUIDs) {
+				t.Fatalf("unexpected number of identities: expected %d, got %d", len(test.expectedUIDs), len(infos))
+			}
+			for i, info := range infos {
+				if info.Uid != test.expectedUIDs[i] {
+					t.Errorf("unexpected UUID: expected %s, got %s", test.expectedUIDs[i], info.Uid)
+				}
+				if !info.HasPublicKey {
+					t.Errorf("%s: public key not reported", info.Uid)
+				}
+				if info.HasSignature != (info.Uid == uids[1]) {
+					t.Errorf("%s: unexpected signature state: %v", info.Uid, info.HasSignature)
+				}
+			}
+		})
+	}
+}
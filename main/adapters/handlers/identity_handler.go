@@ -16,25 +16,33 @@ package handlers
 
 import (
 	"context"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 
 	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/audit"
 	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-client-go/main/config"
 	"github.com/ubirch/ubirch-client-go/main/ent"
 
 	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
 )
 
 type IdentityHandler struct {
 	Protocol            *repository.ExtendedProtocol
 	SubjectCountry      string
 	SubjectOrganization string
+	Audit               *audit.Log // if set, key rotations are recorded in the audit log
 }
 
-func (i *IdentityHandler) InitIdentities(identities map[string]string) error {
+func (i *IdentityHandler) InitIdentities(identities map[string]config.AuthTokens) error {
 	// create and register keys for identities
 	log.Debugf("initializing %d identities...", len(identities))
-	for name, auth := range identities {
+	for name, authTokens := range identities {
 		// make sure identity name is a valid UUID
 		uid, err := uuid.Parse(name)
 		if err != nil {
@@ -47,17 +55,22 @@ func (i *IdentityHandler) InitIdentities(identities map[string]string) error {
 			return fmt.Errorf("can not check existing context for %s: %s", name, err)
 		}
 
-		if exists {
-			// already initialized
-			log.Debugf("%s already initialized (skip)", uid)
-			continue
-		}
-
 		// make sure identity has an auth token
+		auth := authTokens.Primary()
 		if len(auth) == 0 {
 			return fmt.Errorf("missing auth token for identity %s", name)
 		}
 
+		if exists {
+			// already initialized, but the auth token was revoked, when the device was removed from the configuration
+			err = i.restoreRevokedAuthToken(uid, auth)
+			if err != nil {
+				return err
+			}
+			log.Debugf("%s already initialized (skip)", uid)
+			continue
+		}
+
 		_, err = i.InitIdentity(uid, auth)
 		if err != nil {
 			return err
@@ -67,11 +80,30 @@ func (i *IdentityHandler) InitIdentities(identities map[string]string) error {
 	return nil
 }
 
+// restoreRevokedAuthToken stores the auth token of an identity whose auth token was revoked,
+// because its device was removed from the configuration, when the device is configured again
+func (i *IdentityHandler) restoreRevokedAuthToken(uid uuid.UUID, auth string) error {
+	storedAuth, err := i.Protocol.GetAuthToken(uid)
+	if err != nil {
+		return fmt.Errorf("can not get auth token of %s: %v", uid, err)
+	}
+	if storedAuth != revokedAuthToken {
+		return nil
+	}
+
+	err = i.Protocol.SetAuthTokenWithLock(context.Background(), uid, auth)
+	if err != nil {
+		return fmt.Errorf("restoring revoked auth token of %s failed: %v", uid, err)
+	}
+	log.Infof("%s: restored revoked auth token from configuration", uid)
+	return nil
+}
+
 func (i *IdentityHandler) InitIdentity(uid uuid.UUID, auth string) (csr []byte, err error) {
 	log.Infof("initializing new identity %s", uid)
 
 	// generate a new private key
-	privKeyPEM, err := i.Protocol.GenerateKey()
+	privKeyPEM, err := i.Protocol.GenerateKeyForUUID(uid)
 	if err != nil {
 		return nil, fmt.Errorf("generating new key for UUID %s failed: %v", uid, err)
 	}
@@ -111,6 +143,188 @@ func (i *IdentityHandler) InitIdentity(uid uuid.UUID, auth string) (csr []byte,
 	return csr, i.Protocol.CloseTransaction(tx, repository.Commit)
 }
 
+// CheckImportKey checks that the PEM encoded private key is an ECDSA key on the curve of the ubirch protocol
+// (P-256) and returns the PEM encoded public key derived from it
+func (i *IdentityHandler) CheckImportKey(privKeyPEM []byte) (pubKeyPEM []byte, err error) {
+	block, _ := pem.Decode(privKeyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("unable to decode PEM block of private key")
+	}
+
+	privKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse EC private key: %v", err)
+	}
+
+	if privKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("unsupported curve: expected %s, got %s", elliptic.P256().Params().Name, privKey.Curve.Params().Name)
+	}
+
+	pubKeyPEM, err = i.Protocol.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("unable to derive public key from private key: %v", err)
+	}
+
+	_, err = i.Protocol.PublicKeyPEMToBytes(pubKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("derived public key is invalid: %v", err)
+	}
+
+	return pubKeyPEM, nil
+}
+
+// ImportKey stores an externally generated private key for the identity. If the identity does not exist, it is
+// created with the given auth token. An existing key is only replaced if overwrite is set. In this case the auth
+// token of the identity is kept, unless a new one is given, and the chain of the identity starts anew. If register
+// is set, the public key is registered at the ubirch backend. Returns repository.ErrExists if the identity exists
+// and overwrite is not set. The private key must be checked with CheckImportKey before.
+func (i *IdentityHandler) ImportKey(uid uuid.UUID, privKeyPEM []byte, auth string, overwrite, register bool) error {
+	log.Infof("importing key for identity %s", uid)
+
+	pubKeyPEM, err := i.Protocol.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	return i.storeKey(uid, privKeyPEM, pubKeyPEM, auth, overwrite, register)
+}
+
+// GenerateKey generates a new private key for the identity, stores it like ImportKey does and returns the
+// PEM encoded public key. The public key is not registered at the ubirch backend.
+// Returns repository.ErrExists if the identity exists and overwrite is not set.
+func (i *IdentityHandler) GenerateKey(uid uuid.UUID, auth string, overwrite bool) (pubKeyPEM []byte, err error) {
+	log.Infof("generating new key for identity %s", uid)
+
+	privKeyPEM, err := i.Protocol.GenerateKeyForUUID(uid)
+	if err != nil {
+		return nil, fmt.Errorf("generating new key for UUID %s failed: %v", uid, err)
+	}
+
+	pubKeyPEM, err = i.Protocol.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return pubKeyPEM, i.storeKey(uid, privKeyPEM, pubKeyPEM, auth, overwrite, false)
+}
+
+func (i *IdentityHandler) storeKey(uid uuid.UUID, privKeyPEM, pubKeyPEM []byte, auth string, overwrite, register bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.GatewayTimeout)
+	defer cancel()
+
+	tx, err := i.Protocol.StartTransactionWithLock(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("starting transaction with lock failed: %v", err)
+	}
+
+	err = i.replaceKey(tx, uid, privKeyPEM, pubKeyPEM, auth, overwrite, register)
+	if err != nil {
+		_ = i.Protocol.CloseTransaction(tx, repository.Rollback)
+		return err
+	}
+
+	return i.Protocol.CloseTransaction(tx, repository.Commit)
+}
+
+func (i *IdentityHandler) replaceKey(tx interface{}, uid uuid.UUID, privKeyPEM, pubKeyPEM []byte, auth string, overwrite, register bool) error {
+	exists, err := i.Protocol.Exists(uid)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		if !overwrite {
+			return repository.ErrExists
+		}
+
+		if len(auth) == 0 {
+			stored, err := i.Protocol.FetchIdentity(tx, uid)
+			if err != nil {
+				return fmt.Errorf("could not fetch identity: %v", err)
+			}
+			auth = stored.AuthToken
+		}
+
+		err = i.Protocol.DeleteIdentity(tx, uid)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = i.Protocol.StoreNewIdentity(tx, &ent.Identity{
+		Uid:        uid.String(),
+		PrivateKey: privKeyPEM,
+		PublicKey:  pubKeyPEM,
+		Signature:  make([]byte, i.Protocol.SignatureLength()),
+		AuthToken:  auth,
+	})
+	if err != nil {
+		return err
+	}
+
+	if register {
+		_, err = i.registerPublicKey(privKeyPEM, uid, auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// VerifyKeys checks for all stored identities, if the stored public key matches the
+// public key derived from the stored private key. Returns error if any mismatch was found.
+func (i *IdentityHandler) VerifyKeys() error {
+	uids, err := i.Protocol.GetUIDs()
+	if err != nil {
+		return fmt.Errorf("could not load stored identities: %v", err)
+	}
+	log.Debugf("verifying keys of %d identities...", len(uids))
+
+	var inconsistent int
+	for _, uid := range uids {
+		err = i.Protocol.CheckKeyConsistency(uid)
+		if err != nil {
+			log.Errorf("%s: %v", uid, err)
+			inconsistent++
+		}
+	}
+
+	if inconsistent > 0 {
+		return fmt.Errorf("found %d of %d identities with inconsistent keys", inconsistent, len(uids))
+	}
+	return nil
+}
+
+// CheckChainStates loads the chain state of all stored identities, so that chaining continues
+// from the last persisted signature, and logs a summary. Returns error if any invalid state was found.
+func (i *IdentityHandler) CheckChainStates() error {
+	uids, err := i.Protocol.GetUIDs()
+	if err != nil {
+		return fmt.Errorf("could not load stored identities: %v", err)
+	}
+
+	var chained, initial, invalid int
+	for _, uid := range uids {
+		isChained, err := i.Protocol.CheckChainState(uid)
+		if err != nil {
+			log.Errorf("%s: invalid chain state: %v", uid, err)
+			invalid++
+		} else if isChained {
+			chained++
+		} else {
+			initial++
+		}
+	}
+
+	log.Infof("restored chain state of %d identities, %d identities have not been used for chaining yet", chained, initial)
+
+	if invalid > 0 {
+		return fmt.Errorf("found %d of %d identities with invalid chain state", invalid, len(uids))
+	}
+	return nil
+}
+
 func (i *IdentityHandler) FetchIdentity(uid uuid.UUID) (*ent.Identity, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -123,6 +337,176 @@ func (i *IdentityHandler) FetchIdentity(uid uuid.UUID) (*ent.Identity, error) {
 	return i.Protocol.FetchIdentity(tx, uid)
 }
 
+type keyDeletion struct {
+	PublicKey []byte `json:"publicKey"`
+	Signature []byte `json:"signature"`
+}
+
+// DeleteIdentity removes the identity with its keys, signature and auth token. If deactivateKey is set,
+// the key service is requested to delete the public key first, so the identity can not be used anymore.
+// Returns repository.ErrNotExist if the identity does not exist.
+func (i *IdentityHandler) DeleteIdentity(uid uuid.UUID, deactivateKey bool) error {
+	log.Infof("deleting identity %s", uid)
+
+	if deactivateKey {
+		err := i.deletePublicKey(uid)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.GatewayTimeout)
+	defer cancel()
+
+	return i.Protocol.DeleteIdentityWithLock(ctx, uid)
+}
+
+// deletePublicKey requests the key service to delete the public key of the identity.
+// The deletion request is signed with the private key of the identity.
+func (i *IdentityHandler) deletePublicKey(uid uuid.UUID) error {
+	privKeyPEM, err := i.Protocol.GetPrivateKey(uid)
+	if err != nil {
+		return fmt.Errorf("could not fetch private key: %v", err)
+	}
+
+	pubKeyPEM, err := i.Protocol.GetPublicKey(uid)
+	if err != nil {
+		return fmt.Errorf("could not fetch public key: %v", err)
+	}
+
+	return i.deleteKeyPair(uid, privKeyPEM, pubKeyPEM)
+}
+
+// deleteKeyPair requests the key service to delete the public key of a key pair of the identity,
+// which does not need to be the stored key pair. The deletion request is signed with the private key.
+func (i *IdentityHandler) deleteKeyPair(uid uuid.UUID, privKeyPEM, pubKeyPEM []byte) error {
+	pubKey, err := i.Protocol.PublicKeyPEMToBytes(pubKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	signature, err := i.Protocol.Crypto.Sign(privKeyPEM, pubKey)
+	if err != nil {
+		return fmt.Errorf("signing key deletion failed: %v", err)
+	}
+
+	keyDel, err := json.Marshal(keyDeletion{
+		PublicKey: pubKey,
+		Signature: signature,
+	})
+	if err != nil {
+		return err
+	}
+
+	return i.Protocol.DeleteKey(uid, keyDel)
+}
+
+// RotateKey replaces the key of the identity with a newly generated key. The new public key is registered at the
+// ubirch backend before the new key is stored, so that UPPs signed with the new key are accepted right away. The
+// identity is locked during the rotation, so that in-flight chaining requests are completed with the old key first.
+// The chain is continued, i.e. the first UPP signed with the new key chains to the last UPP signed with the old key.
+// After the new key is stored, the key service is requested to delete the old public key. Returns the PEM encoded
+// old and new public keys. If only the deletion of the old public key failed, the keys are returned with the error.
+// Returns repository.ErrNotExist if the identity does not exist.
+func (i *IdentityHandler) RotateKey(uid uuid.UUID) (oldPubKeyPEM, newPubKeyPEM []byte, err error) {
+	log.Infof("rotating key of identity %s", uid)
+
+	exists, err := i.Protocol.Exists(uid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return nil, nil, repository.ErrNotExist
+	}
+
+	newPrivKeyPEM, err := i.Protocol.GenerateKeyForUUID(uid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating new key for UUID %s failed: %v", uid, err)
+	}
+
+	newPubKeyPEM, err = i.Protocol.GetPublicKeyFromPrivateKey(newPrivKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.GatewayTimeout)
+	defer cancel()
+
+	tx, old, err := i.Protocol.FetchIdentityWithLock(ctx, uid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = i.rotateKey(tx, old, newPrivKeyPEM, newPubKeyPEM)
+	if err != nil {
+		_ = i.Protocol.CloseTransaction(tx, repository.Rollback)
+		return nil, nil, err
+	}
+
+	err = i.Protocol.CloseTransaction(tx, repository.Commit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// recorded before the identity can be used to chain UPPs with the new key
+	auditChainEvent(ctx, i.Audit, audit.Entry{
+		UUID:          uid,
+		Operation:     audit.KeyRotationOperation,
+		PrevPublicKey: old.PublicKey,
+		PublicKey:     newPubKeyPEM,
+	})
+
+	err = i.deleteKeyPair(uid, old.PrivateKey, old.PublicKey)
+	if err != nil {
+		return old.PublicKey, newPubKeyPEM, fmt.Errorf("deleting old public key failed: %v", err)
+	}
+
+	return old.PublicKey, newPubKeyPEM, nil
+}
+
+func (i *IdentityHandler) rotateKey(tx interface{}, old *ent.Identity, newPrivKeyPEM, newPubKeyPEM []byte) error {
+	uid, err := uuid.Parse(old.Uid)
+	if err != nil {
+		return err
+	}
+
+	_, err = i.registerPublicKey(newPrivKeyPEM, uid, old.AuthToken)
+	if err != nil {
+		return err
+	}
+
+	err = i.Protocol.DeleteIdentity(tx, uid)
+	if err != nil {
+		return err
+	}
+
+	return i.Protocol.StoreNewIdentity(tx, &ent.Identity{
+		Uid:        old.Uid,
+		PrivateKey: newPrivKeyPEM,
+		PublicKey:  newPubKeyPEM,
+		Signature:  old.Signature,
+		AuthToken:  old.AuthToken,
+	})
+}
+
+// ResubmitCSR regenerates the X.509 Certificate Signing Request from the stored key of the identity
+// with the current subject information and submits it to the identity service.
+// Returns the response of the identity service.
+func (i *IdentityHandler) ResubmitCSR(uid uuid.UUID) (h.HTTPResponse, error) {
+	privKeyPEM, err := i.Protocol.GetPrivateKey(uid)
+	if err != nil {
+		return h.HTTPResponse{}, fmt.Errorf("could not fetch private key: %v", err)
+	}
+
+	csr, err := i.Protocol.GetCSR(privKeyPEM, uid, i.SubjectCountry, i.SubjectOrganization)
+	if err != nil {
+		return h.HTTPResponse{}, fmt.Errorf("creating CSR for UUID %s failed: %v", uid, err)
+	}
+	log.Debugf("%s: CSR [der]: %x", uid, csr)
+
+	return i.Protocol.SendCSR(uid, csr)
+}
+
 func (i *IdentityHandler) registerPublicKey(privKeyPEM []byte, uid uuid.UUID, auth string) (csr []byte, err error) {
 	keyRegistration, err := i.Protocol.GetSignedKeyRegistration(privKeyPEM, uid)
 	if err != nil {
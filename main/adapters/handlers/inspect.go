@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+// InspectionService decodes UPPs for debugging. It does not require an auth token,
+// since it only decodes the UPP from the request and neither signs nor sends anything.
+type InspectionService struct{}
+
+var _ h.Service = (*InspectionService)(nil)
+
+type uppInspection struct {
+	Version       string    `json:"version"`
+	UUID          uuid.UUID `json:"uuid"`
+	Hint          string    `json:"hint"`
+	Payload       []byte    `json:"payload"`
+	PayloadHex    string    `json:"payloadHex"`
+	PrevSignature []byte    `json:"prevSignature,omitempty"`
+	Signature     []byte    `json:"signature"`
+}
+
+var hintNames = map[ubirch.Hint]string{
+	ubirch.Binary:  "binary",
+	ubirch.Disable: "disable",
+	ubirch.Enable:  "enable",
+	ubirch.Delete:  "delete",
+}
+
+// HandleRequest decodes the UPP from the request body and responds with its fields as JSON.
+// The UPP is expected as binary ("application/octet-stream") or base64 encoded ("text/plain"),
+// or hex encoded ("text/plain" with "Content-Transfer-Encoding: hex").
+func (s *InspectionService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	upp, err := getUPP(r)
+	if err != nil {
+		h.Error(uuid.Nil, w, err, h.BodyErrorCode(err))
+		return
+	}
+
+	inspection, err := inspectUPP(upp)
+	if err != nil {
+		h.Error(uuid.Nil, w, err, http.StatusBadRequest)
+		return
+	}
+
+	content, err := json.Marshal(inspection)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", inspection.UUID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {h.JSONType}},
+		Content:    content,
+	})
+}
+
+func getUPP(r *http.Request) ([]byte, error) {
+	data, err := h.ReadBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch h.ContentType(r.Header) {
+	case h.BinType:
+		return data, nil
+	case h.TextType:
+		text := strings.TrimSpace(string(data))
+		if h.ContentEncoding(r.Header) == h.HexEncoding {
+			data, err = hex.DecodeString(text)
+			if err != nil {
+				return nil, fmt.Errorf("decoding hex encoded UPP failed: %v", err)
+			}
+		} else {
+			data, err = base64.StdEncoding.DecodeString(text)
+			if err != nil {
+				return nil, fmt.Errorf("decoding base64 encoded UPP failed: %v", err)
+			}
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("invalid content-type for UPP: "+
+			"expected (\"%s\" | \"%s\")", h.BinType, h.TextType)
+	}
+}
+
+func inspectUPP(upp []byte) (*uppInspection, error) {
+	uppStruct, err := ubirch.Decode(upp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UPP: %v", err)
+	}
+
+	inspection := &uppInspection{
+		UUID:       uppStruct.GetUuid(),
+		Hint:       hintName(uppStruct.GetHint()),
+		Payload:    uppStruct.GetPayload(),
+		PayloadHex: hex.EncodeToString(uppStruct.GetPayload()),
+		Signature:  uppStruct.GetSignature(),
+	}
+
+	switch uppStruct.GetVersion() {
+	case ubirch.Signed:
+		inspection.Version = "signed"
+	case ubirch.Chained:
+		inspection.Version = "chained"
+		inspection.PrevSignature = uppStruct.GetPrevSignature()
+	default:
+		inspection.Version = fmt.Sprintf("0x%02x", uppStruct.GetVersion())
+	}
+
+	return inspection, nil
+}
+
+func hintName(hint ubirch.Hint) string {
+	if name, ok := hintNames[hint]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(hint))
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const (
+	testInspectUUID = "6eac4d0b-16e6-4508-8c46-22e7451ea5a1"
+
+	// signed UPP with the SHA256 hash of "1"
+	testSignedUPP = "9522c4106eac4d0b16e645088c4622e7451ea5a100c4206b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4bc44095ca78858062b6e627240bb320b70cc012787f70878135612d01365f0bf34a18d60311155b487f72b7dcec7996b525c108dfe8e2900938bd6504f3b7df092d9b"
+	// chained UPP with the SHA256 hash of "2", which is chained to testSignedUPP
+	testChainedUPP = "9623c4106eac4d0b16e645088c4622e7451ea5a1c44095ca78858062b6e627240bb320b70cc012787f70878135612d01365f0bf34a18d60311155b487f72b7dcec7996b525c108dfe8e2900938bd6504f3b7df092d9b00c420d4735e3a265e16eee03f59718b9b5d03019c07d8b6c51f90da3a666eec13ab35c440b5cc024bf2d830b5a79ed10b51efebb9fbe2f5f718c70bb0a0d8aa6d83990a6c6c1fb39a46f185b0dcc0a9b7761f8a6de865b784349ca61e510ce5ff2bbec511"
+
+	testSignedPayload   = "6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b"
+	testSignedSignature = "95ca78858062b6e627240bb320b70cc012787f70878135612d01365f0bf34a18d60311155b487f72b7dcec7996b525c108dfe8e2900938bd6504f3b7df092d9b"
+	testChainedPayload  = "d4735e3a265e16eee03f59718b9b5d03019c07d8b6c51f90da3a666eec13ab35"
+)
+
+func inspect(t *testing.T, body []byte, header http.Header) *httptest.ResponseRecorder {
+	router := chi.NewMux()
+	router.Post("/"+h.InspectPath, (&InspectionService{}).HandleRequest)
+
+	r := httptest.NewRequest(http.MethodPost, "/"+h.InspectPath, bytes.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestInspectionService(t *testing.T) {
+	tests := []struct {
+		name          string
+		upp           string
+		version       string
+		payload       string
+		prevSignature string
+		signature     string
+	}{
+		{
+			name:      "signed UPP",
+			upp:       testSignedUPP,
+			version:   "signed",
+			payload:   testSignedPayload,
+			signature: testSignedSignature,
+		},
+		{
+			name:          "chained UPP",
+			upp:           testChainedUPP,
+			version:       "chained",
+			payload:       testChainedPayload,
+			prevSignature: testSignedSignature,
+			signature:     testChainedUPP[len(testChainedUPP)-128:],
+		},
+	}
+
+	for _, test := range tests {
+		upp := mustDecodeHex(t, test.upp)
+
+		encodings := map[string]struct {
+			body   []byte
+			header http.Header
+		}{
+			"binary": {upp, http.Header{"Content-Type": {h.BinType}}},
+			"base64": {[]byte(base64.StdEncoding.EncodeToString(upp)), http.Header{"Content-Type": {h.TextType}}},
+			"hex":    {[]byte(test.upp), http.Header{"Content-Type": {h.TextType}, "Content-Transfer-Encoding": {h.HexEncoding}}},
+		}
+
+		for encoding, req := range encodings {
+			t.Run(fmt.Sprintf("%s %s", test.name, encoding), func(t *testing.T) {
+				w := inspect(t, req.body, req.header)
+				if w.Code != http.StatusOK {
+					t.Fatalf("unexpected response code: expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+				}
+
+				var inspection uppInspection
+				err := json.Unmarshal(w.Body.Bytes(), &inspection)
+				if err != nil {
+					t.Fatalf("unable to decode response: %v", err)
+				}
+
+				if inspection.Version != test.version {
+					t.Errorf("unexpected version: expected %s, got %s", test.version, inspection.Version)
+				}
+				if inspection.UUID.String() != testInspectUUID {
+					t.Errorf("unexpected UUID: %s", inspection.UUID)
+				}
+				if inspection.Hint != "binary" {
+					t.Errorf("unexpected hint: %s", inspection.Hint)
+				}
+				if inspection.PayloadHex != test.payload || !bytes.Equal(inspection.Payload, mustDecodeHex(t, test.payload)) {
+					t.Errorf("unexpected payload: %s", inspection.PayloadHex)
+				}
+				if !bytes.Equal(inspection.PrevSignature, mustDecodeHex(t, test.prevSignature)) {
+					t.Errorf("unexpected previous signature: %x", inspection.PrevSignature)
+				}
+				if !bytes.Equal(inspection.Signature, mustDecodeHex(t, test.signature)) {
+					t.Errorf("unexpected signature: %x", inspection.Signature)
+				}
+			})
+		}
+	}
+}
+
+func TestInspectionService_SignedUPPHasNoPrevSignature(t *testing.T) {
+	w := inspect(t, mustDecodeHex(t, testSignedUPP), http.Header{"Content-Type": {h.BinType}})
+
+	var fields map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := fields["prevSignature"]; found {
+		t.Error("signed UPP has previous signature")
+	}
+}
+
+func TestInspectionService_BadRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   []byte
+		header http.Header
+	}{
+		{
+			name:   "invalid UPP",
+			body:   []byte("not a UPP"),
+			header: http.Header{"Content-Type": {h.BinType}},
+		},
+		{
+			name:   "truncated UPP",
+			body:   mustDecodeHex(t, testChainedUPP[:100]),
+			header: http.Header{"Content-Type": {h.BinType}},
+		},
+		{
+			name:   "invalid base64",
+			body:   []byte("not base64!"),
+			header: http.Header{"Content-Type": {h.TextType}},
+		},
+		{
+			name:   "invalid content type",
+			body:   mustDecodeHex(t, testSignedUPP),
+			header: http.Header{"Content-Type": {h.JSONType}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := inspect(t, test.body, test.header)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("unexpected response code: expected %d, got %d", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	prom "github.com/ubirch/ubirch-client-go/main/prometheus"
+)
+
+const (
+	DefaultKeyCacheTTL = time.Hour // time after which a cached public key is fetched from the key service again
+
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// KeyCache is a concurrency-safe LRU cache for the public keys of unknown identities,
+// which were fetched from the key service. Entries expire after the TTL, so rotated
+// keys are eventually fetched again.
+type KeyCache struct {
+	size    int
+	ttl     time.Duration
+	entries map[uuid.UUID]*list.Element
+	order   *list.List // least recently used entry at the back
+	mutex   *sync.Mutex
+	now     func() time.Time
+}
+
+type keyCacheEntry struct {
+	uid       uuid.UUID
+	pubKeyPEM []byte
+	expires   time.Time
+}
+
+// NewKeyCache returns a key cache which holds at most size public keys for the duration of the ttl
+func NewKeyCache(size int, ttl time.Duration) *KeyCache {
+	if ttl <= 0 {
+		ttl = DefaultKeyCacheTTL
+	}
+	return &KeyCache{
+		size:    size,
+		ttl:     ttl,
+		entries: map[uuid.UUID]*list.Element{},
+		order:   list.New(),
+		mutex:   &sync.Mutex{},
+		now:     time.Now,
+	}
+}
+
+// Get returns the cached public key of the identity, or false if there is no valid entry
+func (c *KeyCache) Get(uid uuid.UUID) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, found := c.entries[uid]
+	if !found {
+		prom.KeyCacheRequests.WithLabelValues(cacheMiss).Inc()
+		return nil, false
+	}
+
+	entry := elem.Value.(*keyCacheEntry)
+	if c.now().After(entry.expires) {
+		c.remove(elem)
+		prom.KeyCacheRequests.WithLabelValues(cacheMiss).Inc()
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	prom.KeyCacheRequests.WithLabelValues(cacheHit).Inc()
+	return entry.pubKeyPEM, true
+}
+
+// Put adds the public key of the identity to the cache and evicts the least recently used
+// entry if the cache is full
+func (c *KeyCache) Put(uid uuid.UUID, pubKeyPEM []byte) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, found := c.entries[uid]; found {
+		c.remove(elem)
+	}
+
+	c.entries[uid] = c.order.PushFront(&keyCacheEntry{
+		uid:       uid,
+		pubKeyPEM: pubKeyPEM,
+		expires:   c.now().Add(c.ttl),
+	})
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate removes the public key of the identity from the cache
+func (c *KeyCache) Invalidate(uid uuid.UUID) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, found := c.entries[uid]; found {
+		c.remove(elem)
+	}
+}
+
+// Len returns the number of cached public keys
+func (c *KeyCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *KeyCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*keyCacheEntry).uid)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestKeyCache(t *testing.T) {
+	c := NewKeyCache(2, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	uid1, uid2, uid3 := uuid.New(), uuid.New(), uuid.New()
+
+	c.Put(uid1, []byte("key1"))
+	c.Put(uid2, []byte("key2"))
+
+	key, found := c.Get(uid1)
+	if !found || string(key) != "key1" {
+		t.Errorf("cached key was not returned: %q", key)
+	}
+
+	// uid2 is the least recently used entry now and is evicted
+	c.Put(uid3, []byte("key3"))
+
+	if _, found = c.Get(uid2); found {
+		t.Error("least recently used entry was not evicted")
+	}
+	if _, found = c.Get(uid1); !found {
+		t.Error("recently used entry was evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("unexpected number of entries: %d", c.Len())
+	}
+
+	c.Invalidate(uid3)
+	if _, found = c.Get(uid3); found {
+		t.Error("invalidated entry was returned")
+	}
+
+	// entries expire after the TTL
+	now = now.Add(2 * time.Minute)
+	if _, found = c.Get(uid1); found {
+		t.Error("expired entry was returned")
+	}
+	if c.Len() != 0 {
+		t.Errorf("unexpected number of entries: %d", c.Len())
+	}
+}
+
+func TestKeyCache_Disabled(t *testing.T) {
+	c := NewKeyCache(-1, 0)
+
+	c.Put(uuid.Nil, []byte("key"))
+
+	if _, found := c.Get(uuid.Nil); found {
+		t.Error("disabled cache returned entry")
+	}
+}
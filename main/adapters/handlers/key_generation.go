@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+// KeyGenerationService generates keys for identities whose public key is registered by the operator,
+// e.g. at another backend. The service does not check the auth token of the identity and must only
+// be reachable via the admin API.
+type KeyGenerationService struct {
+	*IdentityHandler
+	Signer *Signer
+}
+
+var _ h.Service = (*KeyGenerationService)(nil)
+
+// HandleRequest generates a new key for the identity and responds with the PEM encoded public key.
+// The auth token of a new identity is taken from the "X-Auth-Token" header. An existing key is only
+// replaced if the request has the query parameter "force=true", since the chain of the old key can
+// not be continued with the new key.
+func (s *KeyGenerationService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	uid, err := h.GetUUID(r)
+	if err != nil {
+		h.Error(uid, w, err, http.StatusNotFound)
+		return
+	}
+
+	exists, err := s.Protocol.Exists(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	force := r.URL.Query().Get(forceQueryKey) == "true"
+	if exists && !force {
+		h.Error(uid, w, fmt.Errorf("identity already has a key: set query parameter \"%s=true\" to replace it", forceQueryKey), http.StatusConflict)
+		return
+	}
+
+	auth := h.AuthToken(r.Header)
+	if !exists && len(auth) == 0 {
+		h.Error(uid, w, fmt.Errorf("missing auth token for new identity"), http.StatusBadRequest)
+		return
+	}
+
+	pubKeyPEM, err := s.GenerateKey(uid, auth, force)
+	if err == repository.ErrExists {
+		h.Error(uid, w, fmt.Errorf("identity already has a key: set query parameter \"%s=true\" to replace it", forceQueryKey), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: key generation failed: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if s.Signer != nil {
+		s.Signer.ForgetAuthToken(uid)
+	}
+	log.WithContext(r.Context()).Infof("%s: key generated", uid)
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {h.PEMType}},
+		Content:    pubKeyPEM,
+	})
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestKeyGenerationService(t *testing.T) {
+	tests := []struct {
+		name            string
+		existing        bool
+		auth            string
+		query           string
+		expectedCode    int
+		expectGenerated bool
+	}{
+		{
+			name:            "new identity",
+			auth:            "generated-auth",
+			expectedCode:    http.StatusOK,
+			expectGenerated: true,
+		},
+		{
+			name:         "new identity without auth token",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "existing key is not regenerated",
+			existing:     true,
+			auth:         "generated-auth",
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:            "existing key is regenerated with force",
+			existing:        true,
+			query:           "?force=true",
+			expectedCode:    http.StatusOK,
+			expectGenerated: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := repository.NewExtendedProtocol(newMockCtxManager(), testSecret, &clients.Client{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			uid := uuid.New()
+			if test.existing {
+				uid = newTestIdentity(t, p)
+			}
+			storedPubKey, _ := p.GetPublicKey(uid)
+
+			service := &KeyGenerationService{IdentityHandler: &IdentityHandler{Protocol: p}}
+			router := chi.NewMux()
+			router.Post(fmt.Sprintf("/{%s}/%s/%s", h.UUIDKey, h.KeyEndpoint, h.GenerateEndpoint), service.HandleRequest)
+
+			r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s/%s%s", uid, h.KeyEndpoint, h.GenerateEndpoint, test.query), nil)
+			if test.auth != "" {
+				r.Header.Set("X-Auth-Token", test.auth)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", test.expectedCode, w.Code, w.Body.String())
+			}
+
+			pubKey, err := p.GetPublicKey(uid)
+			if !test.expectGenerated {
+				if !bytes.Equal(pubKey, storedPubKey) {
+					t.Error("stored key was changed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != h.PEMType {
+				t.Errorf("unexpected content type: %s", ct)
+			}
+			if !bytes.Equal(w.Body.Bytes(), pubKey) {
+				t.Error("public key in response does not match stored public key")
+			}
+			if test.existing && bytes.Equal(pubKey, storedPubKey) {
+				t.Error("key was not regenerated")
+			}
+			if err = p.CheckKeyConsistency(uid); err != nil {
+				t.Error(err)
+			}
+
+			block, _ := pem.Decode(w.Body.Bytes())
+			if block == nil || block.Type != "PUBLIC KEY" {
+				t.Fatalf("response is not a PEM encoded public key: %s", w.Body.String())
+			}
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				t.Fatalf("unable to parse public key: %v", err)
+			}
+			ecdsaKey, ok := key.(*ecdsa.PublicKey)
+			if !ok || ecdsaKey.Curve != elliptic.P256() {
+				t.Errorf("public key is not an ECDSA P-256 key: %T", key)
+			}
+
+			// the generated key signs UPPs which are verifiable with the returned public key
+			privKeyPEM, err := p.GetPrivateKey(uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signature, err := p.Crypto.Sign(privKeyPEM, []byte("test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			verified, err := p.Crypto.Verify(w.Body.Bytes(), []byte("test"), signature)
+			if err != nil || !verified {
+				t.Errorf("signature could not be verified with the returned public key: %v", err)
+			}
+		})
+	}
+}
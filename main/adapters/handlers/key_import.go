@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const (
+	forceQueryKey    = "force"
+	registerQueryKey = "register"
+)
+
+// KeyImportService imports externally generated keys, e.g. keys which were generated in an HSM.
+// The service does not check the auth token of the identity and must only be reachable via the admin API.
+type KeyImportService struct {
+	*IdentityHandler
+	Signer *Signer
+}
+
+var _ h.Service = (*KeyImportService)(nil)
+
+type keyImportResponse struct {
+	PublicKey  []byte `json:"publicKey"`
+	Registered bool   `json:"registered"`
+}
+
+// HandleRequest stores the PEM encoded private key from the request body for the identity.
+// The auth token of a new identity is taken from the "X-Auth-Token" header. An existing key
+// is only replaced if the request has the query parameter "force=true". With the query
+// parameter "register=true", the public key is registered at the ubirch backend.
+func (s *KeyImportService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	uid, err := h.GetUUID(r)
+	if err != nil {
+		h.Error(uid, w, err, http.StatusNotFound)
+		return
+	}
+
+	privKeyPEM, err := h.ReadBody(r)
+	if err != nil {
+		h.Error(uid, w, err, h.BodyErrorCode(err))
+		return
+	}
+
+	pubKeyPEM, err := s.CheckImportKey(privKeyPEM)
+	if err != nil {
+		h.Error(uid, w, fmt.Errorf("invalid private key: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	exists, err := s.Protocol.Exists(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	auth := h.AuthToken(r.Header)
+	if !exists && len(auth) == 0 {
+		h.Error(uid, w, fmt.Errorf("missing auth token for new identity"), http.StatusBadRequest)
+		return
+	}
+
+	force := r.URL.Query().Get(forceQueryKey) == "true"
+	register := r.URL.Query().Get(registerQueryKey) == "true"
+
+	err = s.ImportKey(uid, privKeyPEM, auth, force, register)
+	if err == repository.ErrExists {
+		h.Error(uid, w, fmt.Errorf("identity already has a key: set query parameter \"%s=true\" to replace it", forceQueryKey), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: key import failed: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if s.Signer != nil {
+		s.Signer.ForgetAuthToken(uid)
+	}
+	log.WithContext(r.Context()).Infof("%s: key imported", uid)
+
+	pubKey, err := s.Protocol.PublicKeyPEMToBytes(pubKeyPEM)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	content, err := json.Marshal(keyImportResponse{
+		PublicKey:  pubKey,
+		Registered: register,
+	})
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {h.JSONType}},
+		Content:    content,
+	})
+}
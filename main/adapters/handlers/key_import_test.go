@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestKeyImportService(t *testing.T) {
+	tests := []struct {
+		name           string
+		curve          elliptic.Curve
+		existing       bool
+		auth           string
+		query          string
+		expectedCode   int
+		expectImported bool
+		expectRegister bool
+	}{
+		{
+			name:           "new identity",
+			curve:          elliptic.P256(),
+			auth:           "imported-auth",
+			expectedCode:   http.StatusOK,
+			expectImported: true,
+		},
+		{
+			name:           "new identity with registration",
+			curve:          elliptic.P256(),
+			auth:           "imported-auth",
+			query:          "?register=true",
+			expectedCode:   http.StatusOK,
+			expectImported: true,
+			expectRegister: true,
+		},
+		{
+			name:         "new identity without auth token",
+			curve:        elliptic.P256(),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "curve mismatch",
+			curve:        elliptic.P384(),
+			auth:         "imported-auth",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "existing key is not overwritten",
+			curve:        elliptic.P256(),
+			existing:     true,
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:           "existing key is overwritten with force",
+			curve:          elliptic.P256(),
+			existing:       true,
+			query:          "?force=true",
+			expectedCode:   http.StatusOK,
+			expectImported: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var registrations int32
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost && r.Header.Get("Content-Type") == "application/json" {
+					atomic.AddInt32(&registrations, 1)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer backend.Close()
+
+			p, err := repository.NewExtendedProtocol(newMockCtxManager(), testSecret,
+				&clients.Client{KeyServiceURL: backend.URL, IdentityServiceURL: backend.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			uid := uuid.New()
+			if test.existing {
+				uid = newTestIdentity(t, p)
+			}
+			storedPubKey, _ := p.GetPublicKey(uid)
+
+			privKeyPEM, pubKeyPEM := newTestKeyPEM(t, test.curve)
+
+			service := &KeyImportService{IdentityHandler: &IdentityHandler{Protocol: p}}
+			router := chi.NewMux()
+			router.Post(fmt.Sprintf("/{%s}/%s/%s", h.UUIDKey, h.KeyEndpoint, h.ImportEndpoint), service.HandleRequest)
+
+			r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s/%s%s", uid, h.KeyEndpoint, h.ImportEndpoint, test.query), bytes.NewReader(privKeyPEM))
+			if test.auth != "" {
+				r.Header.Set("X-Auth-Token", test.auth)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", test.expectedCode, w.Code, w.Body.String())
+			}
+
+			pubKey, err := p.GetPublicKey(uid)
+			if test.expectImported {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(pubKey, pubKeyPEM) {
+					t.Error("stored public key does not match public key of imported private key")
+				}
+				if err = p.CheckKeyConsistency(uid); err != nil {
+					t.Error(err)
+				}
+
+				expectedAuth := test.auth
+				if test.existing {
+					expectedAuth = testAuth
+				}
+				auth, err := p.GetAuthToken(uid)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if auth != expectedAuth {
+					t.Errorf("unexpected auth token: expected %s, got %s", expectedAuth, auth)
+				}
+			} else if !bytes.Equal(pubKey, storedPubKey) {
+				t.Error("stored key was changed")
+			}
+
+			if registered := atomic.LoadInt32(&registrations) > 0; registered != test.expectRegister {
+				t.Errorf("unexpected key registration: expected %v, got %v", test.expectRegister, registered)
+			}
+		})
+	}
+}
+
+func newTestKeyPEM(t *testing.T, curve elliptic.Curve) (privKeyPEM, pubKeyPEM []byte) {
+	privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privDER, err := x509.MarshalECPrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+// KeyRotationService rotates the keys of identities. The service does not check the auth token
+// of the identity and must only be reachable via the admin API.
+type KeyRotationService struct {
+	*IdentityHandler
+}
+
+var _ h.Service = (*KeyRotationService)(nil)
+
+type keyRotationResponse struct {
+	OldPublicKey       string `json:"oldPublicKey"`
+	NewPublicKey       string `json:"newPublicKey"`
+	OldKeyDeactivated  bool   `json:"oldKeyDeactivated"`
+	DeactivationFailed string `json:"deactivationFailed,omitempty"`
+}
+
+// HandleRequest replaces the key of the identity with a new key, registers the new public key,
+// deactivates the old public key and responds with the PEM encoded old and new public keys.
+func (s *KeyRotationService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	uid, err := h.GetUUID(r)
+	if err != nil {
+		h.Error(uid, w, err, http.StatusNotFound)
+		return
+	}
+
+	oldPubKeyPEM, newPubKeyPEM, err := s.RotateKey(uid)
+	if err == repository.ErrNotExist {
+		h.Error(uid, w, fmt.Errorf("unknown UUID"), http.StatusNotFound)
+		return
+	}
+	if err != nil && newPubKeyPEM == nil {
+		log.WithContext(r.Context()).Errorf("%s: key rotation failed: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	resp := keyRotationResponse{
+		OldPublicKey:      string(oldPubKeyPEM),
+		NewPublicKey:      string(newPubKeyPEM),
+		OldKeyDeactivated: err == nil,
+	}
+	if err != nil {
+		// the new key is in use, only the old key could not be deactivated
+		log.WithContext(r.Context()).Warnf("%s: key rotated, but old key was not deactivated: %v", uid, err)
+		resp.DeactivationFailed = err.Error()
+	} else {
+		log.WithContext(r.Context()).Infof("%s: key rotated", uid)
+	}
+
+	content, err := json.Marshal(resp)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {h.JSONType}},
+		Content:    content,
+	})
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/audit"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestKeyRotationService(t *testing.T) {
+	var (
+		upps          [][]byte
+		keyServiceOps []string
+		mutex         sync.Mutex
+	)
+	niomon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upp, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		upps = append(upps, upp)
+		mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer niomon.Close()
+
+	keyService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		keyServiceOps = append(keyServiceOps, r.Method)
+		mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer keyService.Close()
+
+	identityService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer identityService.Close()
+
+	auditLog, err := audit.NewLog(filepath.Join(t.TempDir(), "audit.log"), 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	signer, _ := newTestSigner(t, niomon.URL)
+	signer.Audit = auditLog
+	signer.Protocol.KeyServiceURL = keyService.URL
+	signer.Protocol.IdentityServiceURL = identityService.URL
+	uid := newTestIdentity(t, signer.Protocol)
+
+	oldPubKeyPEM, err := signer.Protocol.GetPublicKey(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// chain a UPP with the old key
+	resp := signer.chainWithLock(context.Background(), h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("before rotation")})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("chaining before rotation failed: %d", resp.StatusCode)
+	}
+
+	service := &KeyRotationService{IdentityHandler: &IdentityHandler{Protocol: signer.Protocol, Audit: auditLog}}
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s/%s", h.UUIDKey, h.KeyEndpoint, h.RotateEndpoint), service.HandleRequest)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s/%s", uid, h.KeyEndpoint, h.RotateEndpoint), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var rotation keyRotationResponse
+	err = json.Unmarshal(w.Body.Bytes(), &rotation)
+	if err != nil {
+		t.Fatalf("unable to decode response: %v", err)
+	}
+
+	newPubKeyPEM, err := signer.Protocol.GetPublicKey(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotation.OldPublicKey != string(oldPubKeyPEM) {
+		t.Errorf("unexpected old public key in response: %s", rotation.OldPublicKey)
+	}
+	if rotation.NewPublicKey != string(newPubKeyPEM) || bytes.Equal(newPubKeyPEM, oldPubKeyPEM) {
+		t.Errorf("unexpected new public key in response: %s", rotation.NewPublicKey)
+	}
+	if !rotation.OldKeyDeactivated {
+		t.Errorf("old key was not deactivated: %s", rotation.DeactivationFailed)
+	}
+	if err = signer.Protocol.CheckKeyConsistency(uid); err != nil {
+		t.Error(err)
+	}
+
+	mutex.Lock()
+	ops := keyServiceOps
+	mutex.Unlock()
+	if len(ops) != 2 || ops[0] != http.MethodPost || ops[1] != http.MethodDelete {
+		t.Errorf("new key was not registered before old key was deleted: %v", ops)
+	}
+
+	// chain a UPP with the new key
+	resp = signer.chainWithLock(context.Background(), h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("after rotation")})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("chaining after rotation failed: %d", resp.StatusCode)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(upps) != 2 {
+		t.Fatalf("unexpected number of UPPs: %d", len(upps))
+	}
+
+	before, err := ubirch.Decode(upps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := ubirch.Decode(upps[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the chain continues across the rotation
+	if !bytes.Equal(after.GetPrevSignature(), before.GetSignature()) {
+		t.Error("UPP after rotation does not chain to the UPP before rotation")
+	}
+
+	verified, err := signer.Protocol.Protocol.Verify(newPubKeyPEM, upps[1])
+	if err != nil || !verified {
+		t.Errorf("UPP after rotation could not be verified with the new key: %v", err)
+	}
+	verified, _ = signer.Protocol.Protocol.Verify(oldPubKeyPEM, upps[1])
+	if verified {
+		t.Error("UPP after rotation was signed with the old key")
+	}
+
+	// the rotation is recorded in the audit log, so that the chain can be verified across it
+	length, chainBreak := verifyAuditedChain(t, signer, uid)
+	if length != 2 || chainBreak != nil {
+		t.Errorf("chain from audit log could not be verified across the rotation: %d UPPs, %+v", length, chainBreak)
+	}
+}
+
+func TestKeyRotationService_UnknownUUID(t *testing.T) {
+	signer, _ := newTestSigner(t, "")
+
+	service := &KeyRotationService{IdentityHandler: &IdentityHandler{Protocol: signer.Protocol}}
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s/%s", h.UUIDKey, h.KeyEndpoint, h.RotateEndpoint), service.HandleRequest)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s/%s", uuid.New(), h.KeyEndpoint, h.RotateEndpoint), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestMockBackend_SignAndVerify(t *testing.T) {
+	client := &clients.Client{
+		AuthServiceURL:   "https://niomon.dev.ubirch.com/",
+		VerifyServiceURL: "https://verify.dev.ubirch.com/api/upp/verify",
+	}
+	mock, err := clients.NewMockBackend(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.HTTP = &http.Client{Transport: mock}
+
+	p, err := repository.NewExtendedProtocol(newMockCtxManager(), testSecret, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &Signer{
+		Protocol:             p,
+		AuthTokensBuffer:     map[uuid.UUID]string{},
+		AuthTokenBufferMutex: &sync.RWMutex{},
+	}
+	verifier := &Verifier{Protocol: p, VerifyFromKnownIdentitiesOnly: true}
+	uid := newTestIdentity(t, p)
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+	router.Post("/verify/hash", (&VerificationService{Verifier: verifier}).HandleRequest)
+	router.Post("/verify/anchor/hash", (&AnchoredVerificationService{Verifier: verifier}).HandleRequest)
+
+	post := func(path string, hash []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(hash))
+		r.Header.Set(h.XAuthHeader, testAuth)
+		r.Header.Set("Content-Type", h.BinType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// the UPP is acknowledged with a backend response UPP, which contains the deterministic request ID
+	hash := testSHA256("mock")
+	w := post(fmt.Sprintf("/%s/%s", uid, h.HashEndpoint), hash)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: %d: %s", w.Code, w.Body.String())
+	}
+	var resp signingResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp.Hash, hash) || resp.Response.StatusCode != http.StatusOK {
+		t.Errorf("unexpected signing response: %s", w.Body.String())
+	}
+	if resp.RequestID != uuid.NewSHA1(clients.MockBackendUUID, resp.UPP).String() {
+		t.Errorf("unexpected request ID: %s", resp.RequestID)
+	}
+
+	upp, err := ubirch.Decode(resp.UPP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendUPP, err := ubirch.DecodeChained(resp.Response.Content)
+	if err != nil {
+		t.Fatalf("invalid backend response UPP: %v", err)
+	}
+	if backendUPP.Uuid != clients.MockBackendUUID || !bytes.Equal(backendUPP.PrevSignature, upp.GetSignature()) {
+		t.Errorf("backend response UPP is not chained to the UPP: %x", resp.Response.Content)
+	}
+
+	// the UPP of the locally known identity can be verified
+	w = post("/verify/hash", hash)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected verification response code: %d: %s", w.Code, w.Body.String())
+	}
+	var vf verificationResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &vf); err != nil {
+		t.Fatal(err)
+	}
+	if vf.UUID != uid.String() || !bytes.Equal(vf.UPP, resp.UPP) {
+		t.Errorf("unexpected verification response: %s", w.Body.String())
+	}
+
+	w = post("/verify/anchor/hash", hash)
+	if err = json.Unmarshal(w.Body.Bytes(), &vf); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(vf.Anchors) != 1 || vf.Anchors[0].Blockchain != clients.MockBackendChain {
+		t.Errorf("unexpected anchored verification response: (%d) %s", w.Code, w.Body.String())
+	}
+}
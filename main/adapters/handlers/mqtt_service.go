@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/mqtt"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+// mqttMessage is the payload of a signing request on the request topic of an identity
+type mqttMessage struct {
+	Auth      string    `json:"auth"`
+	Hash      []byte    `json:"hash"`
+	Operation operation `json:"operation,omitempty"` // defaults to "chain"
+	UPP       []byte    `json:"upp,omitempty"`       // complete UPP to relay instead of a hash
+}
+
+// mqttReply is published to the response topic of the identity
+type mqttReply struct {
+	StatusCode int             `json:"statusCode"`
+	Hash       []byte          `json:"hash"`
+	Response   json.RawMessage `json:"response,omitempty"` // the response of the HTTP signing endpoints
+	Error      string          `json:"error,omitempty"`
+}
+
+// MQTTSigningService handles messages on the request topic of an identity of the form
+// {"auth": "<auth token>", "hash": "<base64 encoded hash>", "operation": "chain"}
+// and replies with the status code and the response of the HTTP signing endpoints.
+// If relaying is enabled, messages of the form
+// {"auth": "<auth token>", "upp": "<base64 encoded UPP>"}
+// are accepted as well. The UPP is verified and forwarded to the backend as it is.
+type MQTTSigningService struct {
+	*Signer
+	Workers   *ChainWorkers // if set, requests are chained by one worker per UUID
+	RelayUPPs bool          // accept complete UPPs, which are verified and forwarded to the backend
+}
+
+// Ensure MQTTSigningService implements the MessageHandler interface
+var _ mqtt.MessageHandler = (*MQTTSigningService)(nil)
+
+func (s *MQTTSigningService) HandleMessage(uid uuid.UUID, payload []byte) ([]byte, error) {
+	msg, op, upp, err := s.parseMQTTMessage(uid, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := s.checkExists(msg.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", msg.ID, err)
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("%s: unknown UUID", msg.ID)
+	}
+
+	idAuth, err := s.getAcceptedAuth(msg.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", msg.ID, err)
+	}
+
+	if !matchAuth(idAuth, msg.Auth) {
+		return nil, fmt.Errorf("%s: invalid auth token", msg.ID)
+	}
+
+	if err = s.checkHTTPOnly(msg.ID); err != nil {
+		return nil, fmt.Errorf("%s: %v", msg.ID, err)
+	}
+
+	var resp h.HTTPResponse
+	if limitErr := s.checkLimits(msg.ID, 1); limitErr != nil {
+		resp = limitResponse(msg.ID, limitErr)
+	} else if op == relayUPP {
+		resp = s.Relay(context.Background(), msg, upp)
+	} else if op == chainHash {
+		resp = s.sendChained(context.Background(), s.Workers, msg)
+	} else {
+		resp = s.Sign(context.Background(), msg, op)
+	}
+
+	reply := mqttReply{
+		StatusCode: resp.StatusCode,
+		Hash:       msg.Hash,
+	}
+	if h.ContentType(resp.Header) == h.JSONType {
+		reply.Response = resp.Content
+	} else {
+		reply.Error = string(resp.Content)
+	}
+	return json.Marshal(reply)
+}
+
+// parseMQTTMessage parses the payload of a signing request. The length of the hash must match
+// the digest size of the default hash algorithm of the identity. For UPPs to relay, the operation
+// "relay" and the UPP are returned, the hash of the request is the payload of the UPP.
+func (s *MQTTSigningService) parseMQTTMessage(uid uuid.UUID, payload []byte) (h.HTTPRequest, operation, []byte, error) {
+	msg := h.HTTPRequest{ID: uid}
+
+	var m mqttMessage
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return msg, "", nil, fmt.Errorf("%s: malformed message: %v", uid, err)
+	}
+	msg.Auth = m.Auth
+
+	if m.UPP != nil {
+		if !s.RelayUPPs {
+			return msg, "", nil, fmt.Errorf("%s: relaying UPPs is disabled", uid)
+		}
+		if m.Hash != nil || m.Operation != "" {
+			return msg, "", nil, fmt.Errorf("%s: malformed message: a UPP to relay must not have a hash or an operation", uid)
+		}
+		uppStruct, err := ubirch.Decode(m.UPP)
+		if err != nil {
+			return msg, "", nil, fmt.Errorf("%s: malformed message: invalid UPP: %v", uid, err)
+		}
+		msg.Hash = uppStruct.GetPayload()
+		return msg, relayUPP, m.UPP, nil
+	}
+
+	op := m.Operation
+	if op == "" {
+		op = chainHash
+	}
+	if _, found := hintLookup[op]; !found && op != chainHash {
+		return msg, "", nil, fmt.Errorf("%s: invalid operation: "+
+			"expected (\"%s\" | \"%s\" | \"%s\" | \"%s\" | \"%s\"), got \"%s\"",
+			uid, chainHash, anchorHash, disableHash, enableHash, deleteHash, op)
+	}
+
+	alg, err := h.GetHashAlgorithm(s.HashAlgorithms[uid])
+	if err != nil {
+		return msg, "", nil, fmt.Errorf("%s: %v", uid, err)
+	}
+	if len(m.Hash) != alg.Size {
+		return msg, "", nil, fmt.Errorf("%s: invalid %s hash size: expected %d bytes, got %d bytes", uid, alg.Name, alg.Size, len(m.Hash))
+	}
+
+	msg.Hash = m.Hash
+	return msg, op, nil, nil
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/quota"
+)
+
+func TestMQTTSigningService_HandleMessage(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, ctxManager := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+	service := &MQTTSigningService{Signer: signer}
+
+	handle := func(uid uuid.UUID, m mqttMessage) (mqttReply, error) {
+		payload, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply mqttReply
+		replyBytes, err := service.HandleMessage(uid, payload)
+		if err != nil {
+			return reply, err
+		}
+		if err = json.Unmarshal(replyBytes, &reply); err != nil {
+			t.Fatalf("unable to decode reply: %v", err)
+		}
+		return reply, nil
+	}
+
+	// the operation defaults to chaining
+	hash := testSHA256("chained")
+	reply, err := handle(uid, mqttMessage{Auth: testAuth, Hash: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp signingResponse
+	if err = json.Unmarshal(reply.Response, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if reply.StatusCode != http.StatusOK || !bytes.Equal(reply.Hash, hash) || !bytes.Equal(resp.Hash, hash) {
+		t.Errorf("unexpected reply: %d, %x, %x", reply.StatusCode, reply.Hash, resp.Hash)
+	}
+	signature := ctxManager.signature(uid)
+	if signature == nil {
+		t.Error("signature was not stored")
+	}
+
+	// other operations create signed UPPs
+	hash = testSHA256("anchored")
+	reply, err = handle(uid, mqttMessage{Auth: testAuth, Hash: hash, Operation: anchorHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var anchored signingResponse
+	if err = json.Unmarshal(reply.Response, &anchored); err != nil {
+		t.Fatal(err)
+	}
+	if reply.StatusCode != http.StatusOK || anchored.PrevSignature != nil {
+		t.Errorf("unexpected reply: %d, %x", reply.StatusCode, anchored.PrevSignature)
+	}
+	if !bytes.Equal(ctxManager.signature(uid), signature) {
+		t.Error("signed UPP advanced the chain")
+	}
+
+	// malformed and unauthorized messages are rejected
+	for name, m := range map[string]mqttMessage{
+		"invalid token":     {Auth: "wrong", Hash: hash},
+		"invalid hash":      {Auth: testAuth, Hash: hash[:16]},
+		"invalid operation": {Auth: testAuth, Hash: hash, Operation: "unknown"},
+	} {
+		if _, err = handle(uid, m); err == nil {
+			t.Errorf("%s: message was not rejected", name)
+		}
+	}
+	if _, err = handle(uuid.New(), mqttMessage{Auth: testAuth, Hash: hash}); err == nil {
+		t.Error("message of unknown identity was not rejected")
+	}
+	if _, err = service.HandleMessage(uid, []byte("not JSON")); err == nil {
+		t.Error("malformed message was not rejected")
+	}
+}
+
+func TestMQTTSigningService_Limits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+	service := &MQTTSigningService{Signer: signer, Workers: NewChainWorkers(signer, 1, 10)}
+
+	handle := func(data string) mqttReply {
+		payload, err := json.Marshal(mqttMessage{Auth: testAuth, Hash: testSHA256(data)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		replyBytes, err := service.HandleMessage(uid, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply mqttReply
+		if err = json.Unmarshal(replyBytes, &reply); err != nil {
+			t.Fatalf("unable to decode reply: %v", err)
+		}
+		return reply
+	}
+
+	// the rate limit of the identity applies to MQTT messages
+	signer.RateLimiter = NewRateLimiter(Rate{Limit: 1, Interval: time.Minute}, nil, 0)
+	if reply := handle("1"); reply.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected reply: (%d) %s", reply.StatusCode, reply.Error)
+	}
+	if reply := handle("2"); reply.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("message beyond the rate limit: unexpected status code: %d", reply.StatusCode)
+	}
+	signer.RateLimiter = nil
+
+	// so does the daily quota, which counts the requests of all interfaces
+	var err error
+	signer.Quota, err = quota.NewCounter(filepath.Join(t.TempDir(), "quota_usage.json"), 1, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Quota.Close()
+	if reply := handle("3"); reply.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected reply: (%d) %s", reply.StatusCode, reply.Error)
+	}
+	if reply := handle("4"); reply.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("message beyond the quota: unexpected status code: %d", reply.StatusCode)
+	}
+	if usage := signer.Quota.Usage(uid); usage.Count != 1 {
+		t.Errorf("unexpected quota usage: %d", usage.Count)
+	}
+}
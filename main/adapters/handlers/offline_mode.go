@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	prom "github.com/ubirch/ubirch-client-go/main/prometheus"
+)
+
+// OfflineMode keeps track of the connectivity to the UBIRCH authentication service. While the
+// authentication service is unreachable, signed UPPs are stored in the dead letter queue right away,
+// without trying to send them, and are forwarded by the DeadLetterService once connectivity returns.
+//
+// A nil *OfflineMode is always online.
+type OfflineMode struct {
+	offline bool
+	since   time.Time
+	mutex   *sync.Mutex
+}
+
+func NewOfflineMode() *OfflineMode {
+	prom.BackendOffline.Set(0)
+	return &OfflineMode{
+		mutex: &sync.Mutex{},
+	}
+}
+
+// IsOffline returns true if the authentication service was unreachable at the last attempt
+func (o *OfflineMode) IsOffline() bool {
+	if o == nil {
+		return false
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.offline
+}
+
+// setOffline marks the authentication service as unreachable
+func (o *OfflineMode) setOffline(err error) {
+	if o == nil {
+		return
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.offline {
+		return
+	}
+	log.Warnf("UBIRCH Authentication Service unreachable, switching to offline mode: %v", err)
+	o.offline = true
+	o.since = time.Now()
+	prom.BackendOffline.Set(1)
+}
+
+// setOnline marks the authentication service as reachable again
+func (o *OfflineMode) setOnline() {
+	if o == nil {
+		return
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if !o.offline {
+		return
+	}
+	log.Infof("UBIRCH Authentication Service reachable again after %s, leaving offline mode", time.Since(o.since).Round(time.Second))
+	o.offline = false
+	prom.BackendOffline.Set(0)
+}
+
+// isUnreachable returns true if a request to the authentication service failed because the service could not be
+// reached, i.e. with a dial or network error while the context of the request was still alive. Requests which were
+// canceled by the caller or not sent at all, because the circuit breaker is open or the backend is busy, do not
+// indicate that the service is unreachable.
+func isUnreachable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	// the connection was closed by the service or a proxy before a response was received
+	return errors.As(err, &opErr) || errors.As(err, &dnsErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
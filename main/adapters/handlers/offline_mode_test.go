@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+// unreachableBackend is a mock of the ubirch backend which drops the connections while it is unreachable
+type unreachableBackend struct {
+	*httptest.Server
+	mutex       sync.Mutex
+	unreachable bool
+	requests    int
+	received    [][]byte
+}
+
+func newUnreachableBackend() *unreachableBackend {
+	b := &unreachableBackend{unreachable: true}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		b.requests++
+		if b.unreachable {
+			panic(http.ErrAbortHandler)
+		}
+		b.received = append(b.received, body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	return b
+}
+
+func (b *unreachableBackend) setUnreachable(unreachable bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.unreachable = unreachable
+}
+
+func (b *unreachableBackend) numRequests() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.requests
+}
+
+func TestOfflineMode(t *testing.T) {
+	backend := newUnreachableBackend()
+	defer backend.Close()
+
+	d, _ := newTestDeadLetterService(t, backend.URL)
+	d.Offline = NewOfflineMode()
+	uidA := newTestIdentity(t, d.Protocol)
+	uidB := newTestIdentity(t, d.Protocol)
+
+	// the first request detects that the backend is unreachable
+	resp := chainTestHash(t, d.Signer, uidA, "a1")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected response code: expected %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	if !d.Offline.IsOffline() {
+		t.Fatal("offline mode was not entered")
+	}
+	if backend.numRequests() != 1 {
+		t.Fatalf("unexpected number of backend requests: %d", backend.numRequests())
+	}
+
+	// further requests of all identities are queued without sending them
+	for _, req := range []struct {
+		uid  uuid.UUID
+		data string
+	}{{uidB, "b1"}, {uidA, "a2"}} {
+		resp = chainTestHash(t, d.Signer, req.uid, req.data)
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("unexpected response code: expected %d, got %d", http.StatusAccepted, resp.StatusCode)
+		}
+		if resp.Header.Get(DeadLetterIDHeader) == "" {
+			t.Errorf("response is missing %s header", DeadLetterIDHeader)
+		}
+	}
+	if backend.numRequests() != 1 {
+		t.Errorf("UPPs were sent in offline mode: %d backend requests", backend.numRequests())
+	}
+
+	var queued [][]byte
+	for _, e := range d.DeadLetters.List() {
+		queued = append(queued, e.UPP)
+	}
+	if len(queued) != 3 {
+		t.Fatalf("unexpected number of queued UPPs: %d", len(queued))
+	}
+
+	// the replay worker stays offline while the backend is unreachable
+	d.ResubmitAll()
+	if !d.Offline.IsOffline() || d.DeadLetters.Len() != 3 {
+		t.Fatalf("unexpected state after failed replay: offline: %v, queued: %d", d.Offline.IsOffline(), d.DeadLetters.Len())
+	}
+
+	// connectivity returns: the queued UPPs are forwarded in order
+	backend.setUnreachable(false)
+	d.ResubmitAll()
+	if d.Offline.IsOffline() {
+		t.Error("offline mode was not left")
+	}
+	if d.DeadLetters.Len() != 0 {
+		t.Fatalf("queued UPPs were not forwarded: %d left", d.DeadLetters.Len())
+	}
+	if len(backend.received) != len(queued) {
+		t.Fatalf("unexpected number of forwarded UPPs: %d", len(backend.received))
+	}
+	for i := range queued {
+		if !bytes.Equal(backend.received[i], queued[i]) {
+			t.Errorf("UPP %d was not forwarded in order", i+1)
+		}
+	}
+
+	// the chain of the forwarded UPPs continues with direct requests
+	resp = chainTestHash(t, d.Signer, uidA, "a3")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response code: expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	var signingResp signingResponse
+	err := json.Unmarshal(resp.Content, &signingResp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevSignature := queued[2][len(queued[2])-64:]
+	if !bytes.Contains(signingResp.UPP, prevSignature) {
+		t.Error("UPP is not chained to the last UPP which was queued offline")
+	}
+}
+
+func TestOfflineMode_CanceledRequest(t *testing.T) {
+	backend := newUnreachableBackend()
+	defer backend.Close()
+
+	d, _ := newTestDeadLetterService(t, backend.URL)
+	d.Offline = NewOfflineMode()
+	uid := newTestIdentity(t, d.Protocol)
+
+	// the client of the request disconnected while the backend request was in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tx, identity, err := d.Protocol.FetchIdentityWithLock(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.chain(ctx, h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("canceled")}, tx, identity)
+
+	if backend.numRequests() != 1 {
+		t.Fatalf("unexpected number of backend requests: %d", backend.numRequests())
+	}
+	if d.Offline.IsOffline() {
+		t.Error("canceled request enabled offline mode")
+	}
+}
+
+func TestIsUnreachable(t *testing.T) {
+	live := context.Background()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	dialErr := &url.Error{Op: "Post", URL: "https://niomon", Err: &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}}
+	closedErr := &url.Error{Op: "Post", URL: "https://niomon", Err: io.EOF}
+	timeoutErr := &url.Error{Op: "Post", URL: "https://niomon", Err: context.DeadlineExceeded}
+
+	for name, c := range map[string]struct {
+		ctx         context.Context
+		err         error
+		unreachable bool
+	}{
+		"dial error":             {live, dialErr, true},
+		"connection closed":      {live, closedErr, true},
+		"DNS error":              {live, &url.Error{Op: "Post", URL: "https://niomon", Err: &net.DNSError{Err: "no such host"}}, true},
+		"no error":               {live, nil, false},
+		"canceled request":       {canceled, dialErr, false},
+		"request timeout":        {live, timeoutErr, false},
+		"circuit breaker open":   {live, clients.ErrCircuitOpen, false},
+		"backend busy":           {live, clients.ErrBackendBusy, false},
+		"canceled by the caller": {live, context.Canceled, false},
+	} {
+		if isUnreachable(c.ctx, c.err) != c.unreachable {
+			t.Errorf("%s: expected unreachable: %v", name, c.unreachable)
+		}
+	}
+}
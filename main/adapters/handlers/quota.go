@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/quota"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const QuotaPath = "quota"
+
+// QuotaService responds with the daily usage of the identities. The service does not check
+// the auth token of the identity and must only be reachable via the admin API.
+type QuotaService struct {
+	Quota *quota.Counter
+}
+
+var _ h.Service = (*QuotaService)(nil)
+
+// HandleRequest responds with the usage of the identity in the query parameter "uuid" as JSON object,
+// or with the usage of all identities which have sent signing requests today as JSON array
+func (s *QuotaService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	var usage interface{}
+	if param := r.URL.Query().Get("uuid"); param != "" {
+		uid, err := uuid.Parse(param)
+		if err != nil {
+			h.Error(uuid.Nil, w, fmt.Errorf("invalid UUID: \"%s\": %v", param, err), http.StatusBadRequest)
+			return
+		}
+		usage = s.Quota.Usage(uid)
+	} else {
+		usage = s.Quota.List()
+	}
+
+	content, err := json.Marshal(usage)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {h.JSONType}},
+		Content:    content,
+	})
+}
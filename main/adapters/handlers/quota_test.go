@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/quota"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestChainingService_Quota(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	var err error
+	signer.Quota, err = quota.NewCounter(filepath.Join(t.TempDir(), "quota_usage.json"), 3, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Quota.Close()
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+	router.Post(fmt.Sprintf("/{%s}/{%s}/%s", h.UUIDKey, h.OperationKey, h.BatchEndpoint),
+		(&BatchSigningService{Signer: signer, MaxBatchSize: 3}).HandleRequest)
+
+	sendHash := func(data string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s", uid, h.HashEndpoint), bytes.NewReader(testSHA256(data)))
+		r.Header.Set(h.XAuthHeader, testAuth)
+		r.Header.Set("Content-Type", h.BinType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 1; i <= 2; i++ {
+		if w := sendHash(fmt.Sprint(i)); w.Code != http.StatusOK {
+			t.Fatalf("request %d: unexpected response code: %d: %s", i, w.Code, w.Body.String())
+		}
+	}
+
+	// the batch would exceed the quota, although the first hash is within the quota
+	w := sendBatchRequest(t, router, uid, "chain", []string{testHash("3"), testHash("4")})
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("unexpected response code of batch request: %d: %s", w.Code, w.Body.String())
+	}
+	w = sendBatchRequest(t, router, uid, "chain", []string{testHash("3")})
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected response code of batch request: %d: %s", w.Code, w.Body.String())
+	}
+
+	w = sendHash("4")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request beyond the quota: unexpected response code: %d", w.Code)
+	}
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter <= 0 || retryAfter > 24*60*60 {
+		t.Errorf("unexpected Retry-After header: %q", w.Header().Get("Retry-After"))
+	}
+
+	// query the usage via the admin API
+	quotaService := &QuotaService{Quota: signer.Quota}
+	w = httptest.NewRecorder()
+	quotaService.HandleRequest(w, httptest.NewRequest(http.MethodGet, "/quota?uuid="+uid.String(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: %d", w.Code)
+	}
+
+	var usage quota.Usage
+	err = json.Unmarshal(w.Body.Bytes(), &usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.UID != uid || usage.Count != 3 || usage.Quota != 3 {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+
+	w = httptest.NewRecorder()
+	quotaService.HandleRequest(w, httptest.NewRequest(http.MethodGet, "/quota", nil))
+	var list []quota.Usage
+	err = json.Unmarshal(w.Body.Bytes(), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].UID != uid {
+		t.Errorf("unexpected usage list: %+v", list)
+	}
+
+	w = httptest.NewRecorder()
+	quotaService.HandleRequest(w, httptest.NewRequest(http.MethodGet, "/quota?uuid=invalid", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid UUID was accepted: %d", w.Code)
+	}
+
+	if usage = signer.Quota.Usage(uuid.New()); usage.Count != 0 {
+		t.Errorf("unexpected usage of unknown UUID: %+v", usage)
+	}
+}
+
+func TestChainingService_QuotaIdempotentReplay(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+	signer.Idempotency = NewIdempotencyCache(time.Minute, 0)
+
+	var err error
+	signer.Quota, err = quota.NewCounter(filepath.Join(t.TempDir(), "quota_usage.json"), 1, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Quota.Close()
+	signer.RateLimiter = NewRateLimiter(Rate{Limit: 1, Interval: time.Minute}, nil, 0)
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+
+	sendHash := func(data, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s", uid, h.HashEndpoint), bytes.NewReader(testSHA256(data)))
+		r.Header.Set(h.XAuthHeader, testAuth)
+		r.Header.Set("Content-Type", h.BinType)
+		r.Header.Set(IdempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := sendHash("1", "key-1"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: %d: %s", w.Code, w.Body.String())
+	}
+
+	// replays of the request neither count towards the quota nor the rate limit
+	for i := 0; i < 3; i++ {
+		w := sendHash("1", "key-1")
+		if w.Code != http.StatusOK || w.Header().Get(IdempotentReplayedHeader) != "true" {
+			t.Fatalf("replay %d: unexpected response: %d: %s", i, w.Code, w.Body.String())
+		}
+	}
+	if usage := signer.Quota.Usage(uid); usage.Count != 1 {
+		t.Errorf("replays were counted: %+v", usage)
+	}
+
+	if w := sendHash("2", "key-2"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("new request beyond the limits: unexpected response: %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultMaxRateLimitBuckets is the maximum number of UUIDs, for which the rate limiter keeps track of requests
+const DefaultMaxRateLimitBuckets = 10000
+
+// Rate is a number of requests per interval
+type Rate struct {
+	Limit    int
+	Interval time.Duration
+}
+
+// ParseRate parses a rate in the form "<limit>/<interval>", e.g. "10/s", "600/m" or "5/10s"
+func ParseRate(s string) (Rate, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return Rate{}, fmt.Errorf("invalid rate \"%s\": expected \"<limit>/<interval>\", e.g. \"10/s\"", s)
+	}
+
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || limit <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate \"%s\": limit must be a positive integer", s)
+	}
+
+	interval := strings.TrimSpace(parts[1])
+	if interval != "" && (interval[0] < '0' || interval[0] > '9') {
+		interval = "1" + interval // "s" -> "1s"
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate \"%s\": interval must be a positive duration, e.g. \"s\", \"m\" or \"10s\"", s)
+	}
+
+	return Rate{Limit: limit, Interval: d}, nil
+}
+
+func (r Rate) perSecond() float64 {
+	return float64(r.Limit) / r.Interval.Seconds()
+}
+
+// RateLimiter limits the number of requests per UUID with a token bucket for each UUID.
+// A bucket holds up to Limit tokens and is refilled at Limit tokens per Interval, so bursts
+// of up to Limit requests are allowed.
+//
+// The number of buckets is bounded: buckets which have been idle long enough to be refilled
+// completely are removed, and if the maximum number of buckets is reached nevertheless,
+// the least recently used bucket is evicted.
+type RateLimiter struct {
+	defaultRate Rate
+	rates       map[uuid.UUID]Rate // rates which override the default rate
+	maxBuckets  int
+
+	buckets map[uuid.UUID]*list.Element
+	order   *list.List // least recently used bucket at the back
+	mutex   *sync.Mutex
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	uid    uuid.UUID
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a rate limiter with a default rate for all UUIDs and optional rates for
+// specific UUIDs. UUIDs without a rate limit are not limited, if the default rate is the zero value.
+// At most maxBuckets UUIDs are tracked at the same time.
+func NewRateLimiter(defaultRate Rate, rates map[uuid.UUID]Rate, maxBuckets int) *RateLimiter {
+	if maxBuckets <= 0 {
+		maxBuckets = DefaultMaxRateLimitBuckets
+	}
+	return &RateLimiter{
+		defaultRate: defaultRate,
+		rates:       rates,
+		maxBuckets:  maxBuckets,
+		buckets:     map[uuid.UUID]*list.Element{},
+		order:       list.New(),
+		mutex:       &sync.Mutex{},
+		now:         time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of the UUID. If the bucket is empty, it returns false
+// and the time after which the next request will be allowed.
+func (l *RateLimiter) Allow(uid uuid.UUID) (ok bool, retryAfter time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.evictIdle(now)
+
+	var b *tokenBucket
+	if elem, found := l.buckets[uid]; found {
+		l.order.MoveToFront(elem)
+		b = elem.Value.(*tokenBucket)
+		b.refill(now)
+	} else {
+		rate, found := l.rates[uid]
+		if !found {
+			rate = l.defaultRate
+		}
+		if rate.Limit <= 0 {
+			return true, 0 // no limit
+		}
+		b = &tokenBucket{uid: uid, rate: rate, tokens: float64(rate.Limit), last: now}
+		l.buckets[uid] = l.order.PushFront(b)
+
+		for l.order.Len() > l.maxBuckets {
+			l.remove(l.order.Back())
+		}
+	}
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / b.rate.perSecond() * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Len returns the number of tracked UUIDs
+func (l *RateLimiter) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.order.Len()
+}
+
+// evictIdle removes the least recently used buckets which have been refilled completely in the meantime,
+// since they do not differ from new buckets
+func (l *RateLimiter) evictIdle(now time.Time) {
+	for elem := l.order.Back(); elem != nil; elem = l.order.Back() {
+		b := elem.Value.(*tokenBucket)
+		if b.refillTime() > now.Sub(b.last) {
+			return
+		}
+		l.remove(elem)
+	}
+}
+
+func (l *RateLimiter) remove(elem *list.Element) {
+	l.order.Remove(elem)
+	delete(l.buckets, elem.Value.(*tokenBucket).uid)
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate.perSecond()
+	if b.tokens > float64(b.rate.Limit) {
+		b.tokens = float64(b.rate.Limit)
+	}
+	b.last = now
+}
+
+// refillTime returns the time it takes to refill the bucket completely
+func (b *tokenBucket) refillTime() time.Duration {
+	return time.Duration((float64(b.rate.Limit) - b.tokens) / b.rate.perSecond() * float64(time.Second))
+}
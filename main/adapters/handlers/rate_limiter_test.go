@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestParseRate(t *testing.T) {
+	var tests = []struct {
+		rate     string
+		expected Rate
+		invalid  bool
+	}{
+		{rate: "10/s", expected: Rate{Limit: 10, Interval: time.Second}},
+		{rate: "600/m", expected: Rate{Limit: 600, Interval: time.Minute}},
+		{rate: "5/10s", expected: Rate{Limit: 5, Interval: 10 * time.Second}},
+		{rate: "10", invalid: true},
+		{rate: "0/s", invalid: true},
+		{rate: "x/s", invalid: true},
+		{rate: "10/x", invalid: true},
+		{rate: "10/-1s", invalid: true},
+	}
+
+	for _, test := range tests {
+		rate, err := ParseRate(test.rate)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: invalid rate was accepted", test.rate)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.rate, err)
+		}
+		if rate != test.expected {
+			t.Errorf("%s: unexpected rate: %+v", test.rate, rate)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	uid, limitedUID, unlimitedUID := uuid.New(), uuid.New(), uuid.New()
+
+	l := NewRateLimiter(Rate{Limit: 3, Interval: time.Second}, map[uuid.UUID]Rate{
+		limitedUID:   {Limit: 1, Interval: time.Second},
+		unlimitedUID: {Limit: 100, Interval: time.Second},
+	}, 0)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	// the 4th request of a burst is rejected
+	for i := 1; i <= 4; i++ {
+		ok, retryAfter := l.Allow(uid)
+		if ok != (i <= 3) {
+			t.Errorf("request %d: unexpected result: %v", i, ok)
+		}
+		if !ok && retryAfter != time.Second/3 {
+			t.Errorf("unexpected retry after: %s", retryAfter)
+		}
+	}
+
+	// the configured rate overrides the default rate
+	if ok, _ := l.Allow(limitedUID); !ok {
+		t.Error("first request of UUID with configured rate was rejected")
+	}
+	if ok, _ := l.Allow(limitedUID); ok {
+		t.Error("second request of UUID with configured rate was accepted")
+	}
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.Allow(unlimitedUID); !ok {
+			t.Errorf("request %d of UUID with higher configured rate was rejected", i+1)
+		}
+	}
+
+	// the bucket is refilled over time
+	now = now.Add(time.Second / 2)
+	if ok, _ := l.Allow(uid); !ok {
+		t.Error("request was rejected after refill")
+	}
+	if ok, _ := l.Allow(uid); ok {
+		t.Error("request exceeding refilled tokens was accepted")
+	}
+}
+
+func TestRateLimiter_Eviction(t *testing.T) {
+	l := NewRateLimiter(Rate{Limit: 1, Interval: time.Second}, nil, 10)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		l.Allow(uuid.New())
+	}
+	if l.Len() != 10 {
+		t.Errorf("number of buckets is not bounded: %d", l.Len())
+	}
+
+	// idle buckets are removed
+	now = now.Add(time.Second)
+	l.Allow(uuid.New())
+	if l.Len() != 1 {
+		t.Errorf("idle buckets were not removed: %d", l.Len())
+	}
+}
+
+func TestRateLimiter_NoDefault(t *testing.T) {
+	l := NewRateLimiter(Rate{}, nil, 0)
+
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.Allow(uuid.Nil); !ok {
+			t.Fatal("request of UUID without rate limit was rejected")
+		}
+	}
+	if l.Len() != 0 {
+		t.Errorf("UUID without rate limit was tracked")
+	}
+}
+
+func TestChainingService_RateLimit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	signer.RateLimiter = NewRateLimiter(Rate{Limit: 2, Interval: time.Minute}, nil, 0)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+
+	for i := 1; i <= 3; i++ {
+		r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s", uid, h.HashEndpoint), bytes.NewReader(testSHA256(fmt.Sprint(i))))
+		r.Header.Set(h.XAuthHeader, testAuth)
+		r.Header.Set("Content-Type", h.BinType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		if i <= 2 && w.Code != http.StatusOK {
+			t.Errorf("request %d: unexpected response code: %d: %s", i, w.Code, w.Body.String())
+		}
+		if i == 3 {
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("request %d: unexpected response code: %d", i, w.Code)
+			}
+			if w.Header().Get("Retry-After") != "30" {
+				t.Errorf("unexpected Retry-After header: %q", w.Header().Get("Retry-After"))
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+// relayUPP is the operation of UPPs which were created by the device itself and are only forwarded by the client
+const relayUPP operation = "relay"
+
+// Relay verifies the signature of a complete UPP, which was received from a device, with the public key of the
+// identity from the local context, and forwards it to the ubirch backend as it is. UPPs which are not from the
+// identity or whose signature is invalid are rejected with 422, so that the client can not be used to relay
+// forged UPPs. Relayed UPPs are chained by the device, they do not advance the chain of the identity in the client.
+func (s *Signer) Relay(ctx context.Context, msg h.HTTPRequest, upp []byte) h.HTTPResponse {
+	payload, err := s.verifyRelayedUPP(msg.ID, upp)
+	if err != nil {
+		log.WithContext(ctx).Warnf("%s: rejected relayed UPP: %v", msg.ID, err)
+		return errorResponse(http.StatusUnprocessableEntity, fmt.Sprintf("invalid UPP: %v", err))
+	}
+	msg.Hash = payload
+
+	log.WithContext(ctx).Infof("%s: relay UPP with payload: %s", msg.ID, base64.StdEncoding.EncodeToString(payload))
+
+	finish := func(resp h.HTTPResponse) h.HTTPResponse {
+		s.auditUPP(ctx, msg, relayUPP, upp, resp)
+		s.publishUPP(ctx, msg, relayUPP, upp, resp)
+		return resp
+	}
+
+	if err := s.mustQueue(msg.ID); err != nil {
+		return finish(s.deadLetter(msg, relayUPP, upp, err.Error()))
+	}
+	return s.submit(ctx, msg, upp, func(resp h.HTTPResponse) h.HTTPResponse {
+		return finish(s.deadLetterIfUndelivered(msg, relayUPP, upp, resp))
+	})
+}
+
+// verifyRelayedUPP verifies that the UPP is from the identity and that its signature is valid,
+// and returns the payload of the UPP
+func (s *Signer) verifyRelayedUPP(uid uuid.UUID, upp []byte) ([]byte, error) {
+	uppStruct, err := ubirch.Decode(upp)
+	if err != nil {
+		return nil, err
+	}
+
+	if uppStruct.GetUuid() != uid {
+		return nil, fmt.Errorf("UUID of UPP does not match: expected %s, got %s", uid, uppStruct.GetUuid())
+	}
+
+	pubKeyPEM, err := s.Protocol.GetPublicKey(uid)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch public key: %v", err)
+	}
+
+	verified, err := s.Protocol.Verify(pubKeyPEM, upp)
+	if !verified {
+		if err != nil {
+			log.Debugf("%s: verification of relayed UPP failed: %v", uid, err)
+		}
+		return nil, fmt.Errorf("signature could not be verified")
+	}
+
+	return uppStruct.GetPayload(), nil
+}
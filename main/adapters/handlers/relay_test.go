@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+)
+
+// relayBackend records the UPPs which were received by the backend
+type relayBackend struct {
+	*httptest.Server
+	mutex sync.Mutex
+	upps  [][]byte
+}
+
+func newRelayBackend() *relayBackend {
+	b := &relayBackend{}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upp, _ := ioutil.ReadAll(r.Body)
+		b.mutex.Lock()
+		b.upps = append(b.upps, upp)
+		b.mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	return b
+}
+
+func (b *relayBackend) received() [][]byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.upps
+}
+
+// newDeviceUPP returns a chained UPP, which was created by the device with the key of the identity
+func newDeviceUPP(t *testing.T, signer *Signer, uid uuid.UUID, payload []byte) []byte {
+	privKeyPEM, err := signer.Protocol.GetPrivateKey(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upp, err := signer.Protocol.Sign(privKeyPEM, &ubirch.ChainedUPP{
+		Version:       ubirch.Chained,
+		Uuid:          uid,
+		PrevSignature: make([]byte, 64),
+		Hint:          ubirch.Binary,
+		Payload:       payload,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return upp
+}
+
+func TestUDPChainingService_RelayUPP(t *testing.T) {
+	backend := newRelayBackend()
+	defer backend.Close()
+
+	signer, ctxManager := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+	other := newTestIdentity(t, signer.Protocol)
+	service := &UDPChainingService{Signer: signer, RelayUPPs: true}
+
+	hash := testSHA256("relayed")
+	upp := newDeviceUPP(t, signer, uid, hash)
+
+	tampered := append([]byte{}, upp...)
+	tampered[len(tampered)-signer.Protocol.SignatureLength()-3] ^= 0xff // last byte of the payload, before the signature header
+
+	packet := func(upp []byte) []byte {
+		p, err := newUDPPacket(udpRelayUPP, uid, testAuth, upp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// a valid UPP is forwarded as it is
+	reply, err := service.HandlePacket(packet(upp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp signingResponse
+	if err = json.Unmarshal(reply, &resp); err != nil {
+		t.Fatalf("unexpected reply: %s", reply)
+	}
+	if !bytes.Equal(resp.Hash, hash) || !bytes.Equal(resp.UPP, upp) {
+		t.Errorf("unexpected reply: %x, %x", resp.Hash, resp.UPP)
+	}
+	if received := backend.received(); len(received) != 1 || !bytes.Equal(received[0], upp) {
+		t.Errorf("UPP was not forwarded: %x", received)
+	}
+	if ctxManager.signature(uid) != nil {
+		t.Error("relayed UPP advanced the chain of the identity")
+	}
+
+	reply, err = service.HandlePacket(packet(tampered))
+	if err != nil || string(reply) != "invalid UPP: signature could not be verified" {
+		t.Errorf("tampered UPP was not rejected: %s, %v", reply, err)
+	}
+
+	// UPPs of another identity, malformed and unauthorized UPPs are rejected
+	for name, p := range map[string][]byte{
+		"other identity":     packet(newDeviceUPP(t, signer, other, hash)),
+		"no UPP":             packet([]byte("no UPP")),
+		"invalid auth token": func() []byte { p, _ := newUDPPacket(udpRelayUPP, uid, "wrong", upp); return p }(),
+	} {
+		reply, err = service.HandlePacket(p)
+		if err == nil && !bytes.HasPrefix(reply, []byte("invalid UPP")) {
+			t.Errorf("%s: UPP was not rejected: %s", name, reply)
+		}
+	}
+	if received := backend.received(); len(received) != 1 {
+		t.Errorf("rejected UPPs were forwarded: %d UPPs", len(received))
+	}
+
+	// UPPs are only accepted if relaying is enabled
+	service.RelayUPPs = false
+	if _, err = service.HandlePacket(packet(upp)); err == nil {
+		t.Error("UPP was accepted while relaying is disabled")
+	}
+}
+
+func TestMQTTSigningService_RelayUPP(t *testing.T) {
+	backend := newRelayBackend()
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+	service := &MQTTSigningService{Signer: signer, RelayUPPs: true}
+
+	hash := testSHA256("relayed")
+	upp := newDeviceUPP(t, signer, uid, hash)
+
+	tampered := append([]byte{}, upp...)
+	tampered[len(tampered)-1] ^= 0xff // last byte of the signature
+
+	handle := func(m mqttMessage) (mqttReply, error) {
+		payload, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply mqttReply
+		replyBytes, err := service.HandleMessage(uid, payload)
+		if err != nil {
+			return reply, err
+		}
+		if err = json.Unmarshal(replyBytes, &reply); err != nil {
+			t.Fatalf("unable to decode reply: %v", err)
+		}
+		return reply, nil
+	}
+
+	reply, err := handle(mqttMessage{Auth: testAuth, UPP: upp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.StatusCode != http.StatusOK || !bytes.Equal(reply.Hash, hash) {
+		t.Errorf("unexpected reply: %d, %x", reply.StatusCode, reply.Hash)
+	}
+	if received := backend.received(); len(received) != 1 || !bytes.Equal(received[0], upp) {
+		t.Errorf("UPP was not forwarded: %x", received)
+	}
+
+	reply, err = handle(mqttMessage{Auth: testAuth, UPP: tampered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("tampered UPP was not rejected: %d %s", reply.StatusCode, reply.Error)
+	}
+	if received := backend.received(); len(received) != 1 {
+		t.Errorf("tampered UPP was forwarded: %d UPPs", len(received))
+	}
+
+	// a UPP must not be combined with a hash, and is only accepted if relaying is enabled
+	if _, err = handle(mqttMessage{Auth: testAuth, UPP: upp, Hash: hash}); err == nil {
+		t.Error("UPP with hash was accepted")
+	}
+	service.RelayUPPs = false
+	if _, err = handle(mqttMessage{Auth: testAuth, UPP: upp}); err == nil {
+		t.Error("UPP was accepted while relaying is disabled")
+	}
+}
+
+func TestHTTPOnlyChecks(t *testing.T) {
+	backend := newRelayBackend()
+	defer backend.Close()
+
+	signer, ctxManager := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+	udpService := &UDPChainingService{Signer: signer, RelayUPPs: true}
+	mqttService := &MQTTSigningService{Signer: signer, RelayUPPs: true}
+
+	hash := testSHA256("http only")
+	upp := newDeviceUPP(t, signer, uid, hash)
+
+	requests := map[string]func() ([]byte, error){
+		"UDP hash": func() ([]byte, error) {
+			p, _ := newUDPPacket(udpChainHash, uid, testAuth, hash)
+			return udpService.HandlePacket(p)
+		},
+		"UDP relay": func() ([]byte, error) {
+			p, _ := newUDPPacket(udpRelayUPP, uid, testAuth, upp)
+			return udpService.HandlePacket(p)
+		},
+		"MQTT hash": func() ([]byte, error) {
+			payload, _ := json.Marshal(mqttMessage{Auth: testAuth, Hash: hash})
+			return mqttService.HandleMessage(uid, payload)
+		},
+		"MQTT relay": func() ([]byte, error) {
+			payload, _ := json.Marshal(mqttMessage{Auth: testAuth, UPP: upp})
+			return mqttService.HandleMessage(uid, payload)
+		},
+	}
+
+	// the HMAC over the request body and the replay protection can only be checked for HTTP requests,
+	// so they can not be bypassed via UDP or MQTT
+	for name, setup := range map[string]func(){
+		"body secret": func() { signer.BodySecrets = map[uuid.UUID][]byte{uid: []byte("shared secret")} },
+		"replay protection": func() {
+			signer.BodySecrets = nil
+			signer.ReplayGuard = NewReplayGuard(time.Minute, 0)
+		},
+	} {
+		setup()
+		for request, handle := range requests {
+			if reply, err := handle(); err == nil {
+				t.Errorf("%s: %s was accepted: %s", name, request, reply)
+			}
+		}
+		signer.BodySecrets, signer.ReplayGuard = nil, nil
+	}
+	if received := backend.received(); len(received) != 0 {
+		t.Errorf("rejected UPPs were forwarded: %d UPPs", len(received))
+	}
+	if ctxManager.signature(uid) != nil {
+		t.Error("rejected hash was chained")
+	}
+}
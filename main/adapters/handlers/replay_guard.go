@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+
+	// DefaultMaxNonces is the maximum number of nonces, which the replay guard keeps track of
+	DefaultMaxNonces = 100000
+
+	maxNonceLength = 128
+)
+
+var (
+	ErrInvalidTimestamp = fmt.Errorf("invalid %s header: expected unix time in seconds or RFC 3339 timestamp", TimestampHeader)
+	ErrStaleTimestamp   = fmt.Errorf("%s header is outside of the replay window", TimestampHeader)
+	ErrInvalidNonce     = fmt.Errorf("invalid %s header: expected 1 to %d characters", NonceHeader, maxNonceLength)
+	ErrNonceReused      = fmt.Errorf("%s header was already used", NonceHeader)
+)
+
+// ReplayGuard rejects replayed signing requests. A request must carry a timestamp, which may differ from the
+// time of the client by at most the replay window, and a nonce, which must not have been used by the same
+// identity within the replay window. So a captured request can not be sent again, neither with its
+// original timestamp after the replay window, nor within the replay window with its original nonce.
+//
+// The number of nonces is bounded: nonces are forgotten once the timestamps they were sent with are outside
+// of the replay window, and if the maximum number of nonces is reached nevertheless, the oldest nonce is evicted.
+type ReplayGuard struct {
+	window    time.Duration
+	maxNonces int
+
+	nonces map[string]*list.Element
+	order  *list.List // oldest nonce at the back
+	mutex  *sync.Mutex
+	now    func() time.Time
+}
+
+type seenNonce struct {
+	key     string
+	expires time.Time
+}
+
+// NewReplayGuard returns a replay guard which accepts timestamps within the given window around the current time
+// and keeps track of at most maxNonces nonces
+func NewReplayGuard(window time.Duration, maxNonces int) *ReplayGuard {
+	if maxNonces <= 0 {
+		maxNonces = DefaultMaxNonces
+	}
+	return &ReplayGuard{
+		window:    window,
+		maxNonces: maxNonces,
+		nonces:    map[string]*list.Element{},
+		order:     list.New(),
+		mutex:     &sync.Mutex{},
+		now:       time.Now,
+	}
+}
+
+// Check returns an error if the timestamp is invalid or outside of the replay window, or if the nonce
+// was already used by the identity. Otherwise, the nonce is registered as used.
+func (g *ReplayGuard) Check(uid uuid.UUID, timestamp, nonce string) error {
+	ts, err := parseTimestamp(timestamp)
+	if err != nil {
+		return err
+	}
+	if len(nonce) == 0 || len(nonce) > maxNonceLength {
+		return ErrInvalidNonce
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.now()
+	if ts.Before(now.Add(-g.window)) || ts.After(now.Add(g.window)) {
+		return ErrStaleTimestamp
+	}
+
+	g.evictExpired(now)
+
+	key := uid.String() + ":" + nonce
+	if _, found := g.nonces[key]; found {
+		return ErrNonceReused
+	}
+
+	// a timestamp is accepted until at most twice the window from now, if it lies in the future
+	g.nonces[key] = g.order.PushFront(&seenNonce{key: key, expires: now.Add(2 * g.window)})
+	for g.order.Len() > g.maxNonces {
+		g.remove(g.order.Back())
+	}
+	return nil
+}
+
+// Len returns the number of tracked nonces
+func (g *ReplayGuard) Len() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.order.Len()
+}
+
+// evictExpired removes the oldest nonces, which can not be replayed anymore, since every timestamp which
+// they may have been sent with is outside of the replay window
+func (g *ReplayGuard) evictExpired(now time.Time) {
+	for elem := g.order.Back(); elem != nil; elem = g.order.Back() {
+		if elem.Value.(*seenNonce).expires.After(now) {
+			return
+		}
+		g.remove(elem)
+	}
+}
+
+func (g *ReplayGuard) remove(elem *list.Element) {
+	g.order.Remove(elem)
+	delete(g.nonces, elem.Value.(*seenNonce).key)
+}
+
+// parseTimestamp parses a unix timestamp in seconds or an RFC 3339 timestamp
+func parseTimestamp(timestamp string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	ts, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return time.Time{}, ErrInvalidTimestamp
+	}
+	return ts, nil
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestReplayGuard(t *testing.T) {
+	g := NewReplayGuard(time.Minute, 0)
+	now := time.Now()
+	g.now = func() time.Time { return now }
+
+	uid, otherUID := uuid.New(), uuid.New()
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	var tests = []struct {
+		name      string
+		uid       uuid.UUID
+		timestamp string
+		nonce     string
+		expected  error
+	}{
+		{"valid", uid, ts, "1", nil},
+		{"RFC 3339 timestamp", uid, now.UTC().Format(time.RFC3339), "2", nil},
+		{"duplicate nonce", uid, ts, "1", ErrNonceReused},
+		{"nonce of other identity", otherUID, ts, "1", nil},
+		{"stale timestamp", uid, strconv.FormatInt(now.Add(-2*time.Minute).Unix(), 10), "3", ErrStaleTimestamp},
+		{"future timestamp", uid, strconv.FormatInt(now.Add(2*time.Minute).Unix(), 10), "4", ErrStaleTimestamp},
+		{"missing timestamp", uid, "", "5", ErrInvalidTimestamp},
+		{"invalid timestamp", uid, "yesterday", "6", ErrInvalidTimestamp},
+		{"missing nonce", uid, ts, "", ErrInvalidNonce},
+		{"long nonce", uid, ts, string(make([]byte, maxNonceLength+1)), ErrInvalidNonce},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := g.Check(test.uid, test.timestamp, test.nonce); err != test.expected {
+				t.Errorf("unexpected error: expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+
+	// a rejected request does not use up its nonce
+	if err := g.Check(uid, ts, "3"); err != nil {
+		t.Errorf("nonce of rejected request was registered: %v", err)
+	}
+
+	// nonces are forgotten when their timestamps can not be within the replay window anymore
+	now = now.Add(2 * time.Minute)
+	if err := g.Check(uid, strconv.FormatInt(now.Unix(), 10), "1"); err != nil {
+		t.Errorf("expired nonce was rejected: %v", err)
+	}
+	if g.Len() != 1 {
+		t.Errorf("expired nonces were not evicted: %d", g.Len())
+	}
+}
+
+func TestReplayGuard_MaxNonces(t *testing.T) {
+	g := NewReplayGuard(time.Minute, 10)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	for i := 0; i < 100; i++ {
+		if err := g.Check(uuid.Nil, ts, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g.Len() != 10 {
+		t.Errorf("unexpected number of nonces: %d", g.Len())
+	}
+	if err := g.Check(uuid.Nil, ts, "99"); err != ErrNonceReused {
+		t.Errorf("latest nonce was evicted: %v", err)
+	}
+}
+
+func TestChainingService_ReplayProtection(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, ctxManager := newTestSigner(t, backend.URL)
+	signer.ReplayGuard = NewReplayGuard(time.Minute, 0)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+
+	send := func(hash []byte, timestamp time.Time, nonce string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s", uid, h.HashEndpoint), bytes.NewReader(hash))
+		r.Header.Set(h.XAuthHeader, testAuth)
+		r.Header.Set("Content-Type", h.BinType)
+		r.Header.Set(TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+		r.Header.Set(NonceHeader, nonce)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	hash := testSHA256("replayed")
+	now := time.Now()
+
+	if w := send(hash, now, "nonce-1"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: %d: %s", w.Code, w.Body.String())
+	}
+	signature := ctxManager.signature(uid)
+
+	// replay of the captured request
+	if w := send(hash, now, "nonce-1"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected response code for duplicate nonce: %d", w.Code)
+	}
+	// replay with a new nonce, but the captured timestamp after the replay window
+	if w := send(hash, now.Add(-2*time.Minute), "nonce-2"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected response code for stale timestamp: %d", w.Code)
+	}
+	if !bytes.Equal(ctxManager.signature(uid), signature) {
+		t.Error("replayed request was chained")
+	}
+
+	if w := send(testSHA256("next"), now, "nonce-2"); w.Code != http.StatusOK {
+		t.Errorf("unexpected response code: %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestChainingService_ReplayProtectionWithBodySignature(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	signer.ReplayGuard = NewReplayGuard(time.Minute, 0)
+	uid := newTestIdentity(t, signer.Protocol)
+	secret := []byte("shared secret")
+	signer.BodySecrets = map[uuid.UUID][]byte{uid: secret}
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+
+	sign := func(timestamp, nonce string, hash []byte) string {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(timestamp + "\n" + nonce + "\n"))
+		mac.Write(hash)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	send := func(hash []byte, timestamp, nonce, signature string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s", uid, h.HashEndpoint), bytes.NewReader(hash))
+		r.Header.Set(h.XAuthHeader, testAuth)
+		r.Header.Set("Content-Type", h.BinType)
+		r.Header.Set(TimestampHeader, timestamp)
+		r.Header.Set(NonceHeader, nonce)
+		r.Header.Set(h.SignatureHeader, signature)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	hash := testSHA256("signed")
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// a forged request does not use up the nonce of the genuine request
+	if w := send(hash, timestamp, "nonce-1", sign(timestamp, "nonce-1", testSHA256("forged"))); w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected response code for forged request: %d", w.Code)
+	}
+	if w := send(hash, timestamp, "nonce-1", sign(timestamp, "nonce-1", hash)); w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: %d: %s", w.Code, w.Body.String())
+	}
+
+	// the headers are covered by the signature, so a captured request can not be sent with a new nonce
+	if w := send(hash, timestamp, "nonce-2", sign(timestamp, "nonce-1", hash)); w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected response code for captured request with new nonce: %d", w.Code)
+	}
+}
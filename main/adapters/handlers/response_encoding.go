@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+
+	log "github.com/sirupsen/logrus"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+// responseEncoding is the encoding of the UPP and the hash in the signing response
+type responseEncoding string
+
+const (
+	base64ResponseEncoding  responseEncoding = "base64"  // JSON with base64 encoded UPP and hash (default)
+	hexResponseEncoding     responseEncoding = "hex"     // JSON with hex encoded UPP and hash
+	rawResponseEncoding     responseEncoding = "raw"     // the UPP as binary data
+	msgpackResponseEncoding responseEncoding = "msgpack" // MessagePack with binary UPP and hash
+	cborResponseEncoding    responseEncoding = "cbor"    // CBOR with binary UPP and hash
+
+	encodingQueryKey = "encoding"
+	encodingParam    = "encoding" // parameter of the JSON media type in the Accept header
+)
+
+// hexSigningResponse is the signing response with hex encoded UPP, hash and previous signature
+type hexSigningResponse struct {
+	Error         string         `json:"error,omitempty"`
+	Hash          string         `json:"hash,omitempty"`
+	UPP           string         `json:"upp,omitempty"`
+	PrevSignature string         `json:"prevSignature,omitempty"`
+	Response      h.HTTPResponse `json:"response,omitempty"`
+	RequestID     string         `json:"requestID,omitempty"`
+}
+
+// getResponseEncoding returns the encoding of the signing response requested by the "encoding" query parameter,
+// or by the "Accept" header: "application/octet-stream" for the raw UPP, "application/json" for JSON with base64
+// encoded UPP and hash, "application/json; encoding=hex" for JSON with hex encoded UPP and hash, and
+// "application/msgpack" or "application/cbor" for the signing response in the respective binary format.
+// The query parameter takes precedence. Returns an error if no supported encoding was requested.
+func getResponseEncoding(r *http.Request) (responseEncoding, error) {
+	if query := r.URL.Query(); query.Get(encodingQueryKey) != "" {
+		switch enc := responseEncoding(strings.ToLower(query.Get(encodingQueryKey))); enc {
+		case base64ResponseEncoding, hexResponseEncoding, rawResponseEncoding, msgpackResponseEncoding, cborResponseEncoding:
+			return enc, nil
+		default:
+			return "", fmt.Errorf("invalid response encoding: expected (\"%s\" | \"%s\" | \"%s\" | \"%s\" | \"%s\"), got \"%s\"",
+				base64ResponseEncoding, hexResponseEncoding, rawResponseEncoding, msgpackResponseEncoding, cborResponseEncoding, enc)
+		}
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return base64ResponseEncoding, nil
+	}
+
+	// the first supported media type is selected, quality values are ignored
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case h.JSONType, "application/*", "*/*":
+			switch enc := responseEncoding(strings.ToLower(params[encodingParam])); enc {
+			case "":
+				return base64ResponseEncoding, nil
+			case base64ResponseEncoding, hexResponseEncoding:
+				return enc, nil
+			}
+		case h.BinType:
+			return rawResponseEncoding, nil
+		case h.MsgpackType, "application/x-msgpack":
+			return msgpackResponseEncoding, nil
+		case h.CBORType:
+			return cborResponseEncoding, nil
+		}
+	}
+
+	return "", fmt.Errorf("none of the accepted media types is supported: "+
+		"expected (\"%s\" | \"%s; %s=%s\" | \"%s\" | \"%s\" | \"%s\"), got \"%s\"",
+		h.JSONType, h.JSONType, encodingParam, hexResponseEncoding, h.BinType, h.MsgpackType, h.CBORType, accept)
+}
+
+// encodeSigningResponse re-encodes the JSON signing response with the given encoding. Responses which contain
+// no signing response, and responses without UPP in raw encoding, e.g. error responses, are returned unchanged.
+func encodeSigningResponse(resp h.HTTPResponse, enc responseEncoding) h.HTTPResponse {
+	if enc == base64ResponseEncoding || resp.Header.Get("Content-Type") != h.JSONType {
+		return resp
+	}
+
+	var signingResp signingResponse
+	err := json.Unmarshal(resp.Content, &signingResp)
+	if err != nil {
+		log.Warnf("unable to decode signing response: %v", err)
+		return resp
+	}
+
+	switch enc {
+	case hexResponseEncoding:
+		content, err := json.Marshal(hexSigningResponse{
+			Error:         signingResp.Error,
+			Hash:          hex.EncodeToString(signingResp.Hash),
+			UPP:           hex.EncodeToString(signingResp.UPP),
+			PrevSignature: hex.EncodeToString(signingResp.PrevSignature),
+			Response:      signingResp.Response,
+			RequestID:     signingResp.RequestID,
+		})
+		if err != nil {
+			log.Warnf("error serializing signing response: %v", err)
+			return resp
+		}
+		resp.Content = content
+	case rawResponseEncoding:
+		if len(signingResp.UPP) == 0 {
+			return resp
+		}
+		resp.Header = http.Header{"Content-Type": {h.BinType}}
+		resp.Content = signingResp.UPP
+	case msgpackResponseEncoding:
+		return encodeBinary(resp, signingResp, marshalMsgpack, h.MsgpackType)
+	case cborResponseEncoding:
+		return encodeBinary(resp, signingResp, marshalCBOR, h.CBORType)
+	}
+
+	return resp
+}
+
+// encodeBinary encodes the signing response in a binary format with the field names of the JSON encoding,
+// in which the UPP, the hash and the previous signature are binary data instead of base64 encoded strings
+func encodeBinary(resp h.HTTPResponse, signingResp signingResponse, marshal func(interface{}) ([]byte, error), contentType string) h.HTTPResponse {
+	content, err := marshal(signingResp)
+	if err != nil {
+		log.Warnf("error serializing signing response: %v", err)
+		return resp
+	}
+	resp.Header = http.Header{"Content-Type": {contentType}}
+	resp.Content = content
+	return resp
+}
+
+// marshalMsgpack encodes the value as MessagePack with sorted map keys
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetSortMapKeys(true)
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+// marshalCBOR encodes the value as canonical CBOR, i.e. with sorted map keys
+func marshalCBOR(v interface{}) ([]byte, error) {
+	encMode, err := cbor.CanonicalEncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+	return encMode.Marshal(v)
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+	"github.com/vmihailenco/msgpack/v5"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestChainingService_ResponseEncoding(t *testing.T) {
+	backendRequests := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendRequests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	router := h.NewRouter()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+
+	var tests = []struct {
+		name         string
+		query        string
+		accept       string
+		expectedCode int
+		expectedType string
+		expectedEnc  responseEncoding
+	}{
+		{
+			name:         "default",
+			expectedCode: http.StatusOK,
+			expectedType: h.JSONType,
+			expectedEnc:  base64ResponseEncoding,
+		},
+		{
+			name:         "any media type",
+			accept:       "text/html, */*;q=0.8",
+			expectedCode: http.StatusOK,
+			expectedType: h.JSONType,
+			expectedEnc:  base64ResponseEncoding,
+		},
+		{
+			name:         "query base64",
+			query:        "?encoding=base64",
+			expectedCode: http.StatusOK,
+			expectedType: h.JSONType,
+			expectedEnc:  base64ResponseEncoding,
+		},
+		{
+			name:         "query hex",
+			query:        "?encoding=hex",
+			expectedCode: http.StatusOK,
+			expectedType: h.JSONType,
+			expectedEnc:  hexResponseEncoding,
+		},
+		{
+			name:         "query raw",
+			query:        "?encoding=raw",
+			accept:       h.JSONType, // the query parameter takes precedence
+			expectedCode: http.StatusOK,
+			expectedType: h.BinType,
+			expectedEnc:  rawResponseEncoding,
+		},
+		{
+			name:         "accept hex",
+			accept:       "application/json; encoding=hex",
+			expectedCode: http.StatusOK,
+			expectedType: h.JSONType,
+			expectedEnc:  hexResponseEncoding,
+		},
+		{
+			name:         "accept raw",
+			accept:       h.BinType,
+			expectedCode: http.StatusOK,
+			expectedType: h.BinType,
+			expectedEnc:  rawResponseEncoding,
+		},
+		{
+			name:         "accept msgpack",
+			accept:       h.MsgpackType,
+			expectedCode: http.StatusOK,
+			expectedType: h.MsgpackType,
+			expectedEnc:  msgpackResponseEncoding,
+		},
+		{
+			name:         "query msgpack",
+			query:        "?encoding=msgpack",
+			expectedCode: http.StatusOK,
+			expectedType: h.MsgpackType,
+			expectedEnc:  msgpackResponseEncoding,
+		},
+		{
+			name:         "accept cbor",
+			accept:       "application/cbor, application/json;q=0.5",
+			expectedCode: http.StatusOK,
+			expectedType: h.CBORType,
+			expectedEnc:  cborResponseEncoding,
+		},
+		{
+			name:         "unknown query encoding",
+			query:        "?encoding=base32",
+			expectedCode: http.StatusNotAcceptable,
+		},
+		{
+			name:         "unsupported accept",
+			accept:       "text/html",
+			expectedCode: http.StatusNotAcceptable,
+		},
+		{
+			name:         "unknown accept encoding",
+			accept:       "application/json; encoding=base32",
+			expectedCode: http.StatusNotAcceptable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash := testSHA256(test.name)
+			requestsBefore := backendRequests
+
+			r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s%s", uid, h.HashEndpoint, test.query), bytes.NewReader(hash))
+			r.Header.Set("Content-Type", h.BinType)
+			r.Header.Set("X-Auth-Token", testAuth)
+			if test.accept != "" {
+				r.Header.Set("Accept", test.accept)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", test.expectedCode, w.Code, w.Body)
+			}
+			if w.Code == http.StatusNotAcceptable {
+				if backendRequests != requestsBefore {
+					t.Error("UPP was sent although the response encoding is not acceptable")
+				}
+				return
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != test.expectedType {
+				t.Errorf("unexpected content type: expected %s, got %s", test.expectedType, contentType)
+			}
+
+			var upp, respHash []byte
+			switch test.expectedEnc {
+			case base64ResponseEncoding:
+				var resp signingResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				upp, respHash = resp.UPP, resp.Hash
+			case hexResponseEncoding:
+				var resp hexSigningResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				if upp, err = hex.DecodeString(resp.UPP); err != nil {
+					t.Fatal(err)
+				}
+				if respHash, err = hex.DecodeString(resp.Hash); err != nil {
+					t.Fatal(err)
+				}
+				if resp.PrevSignature == "" {
+					t.Error("previous signature is missing in hex encoded response")
+				}
+			case rawResponseEncoding:
+				upp, respHash = w.Body.Bytes(), hash
+			case msgpackResponseEncoding, cborResponseEncoding:
+				var resp signingResponse
+				var err error
+				if test.expectedEnc == cborResponseEncoding {
+					err = cbor.Unmarshal(w.Body.Bytes(), &resp)
+				} else {
+					dec := msgpack.NewDecoder(bytes.NewReader(w.Body.Bytes()))
+					dec.SetCustomStructTag("json")
+					err = dec.Decode(&resp)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.Response.StatusCode != http.StatusOK || len(resp.PrevSignature) == 0 || resp.Response.Header.Get("Date") == "" {
+					t.Errorf("incomplete signing response: %+v", resp)
+				}
+				upp, respHash = resp.UPP, resp.Hash
+			}
+
+			if !bytes.Equal(respHash, hash) {
+				t.Errorf("unexpected hash: expected %x, got %x", hash, respHash)
+			}
+			decoded, err := ubirch.Decode(upp)
+			if err != nil {
+				t.Fatalf("response does not contain a valid UPP: %v", err)
+			}
+			if !bytes.Equal(decoded.GetPayload(), hash) {
+				t.Errorf("unexpected payload of UPP: expected %x, got %x", hash, decoded.GetPayload())
+			}
+		})
+	}
+}
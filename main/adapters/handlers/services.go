@@ -1,11 +1,20 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/jobs"
 
 	log "github.com/sirupsen/logrus"
 	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
@@ -13,6 +22,7 @@ import (
 
 type ChainingService struct {
 	*Signer
+	Workers *ChainWorkers // if set, requests are chained by one worker per UUID
 }
 
 // Ensure ChainingService implements the Service interface
@@ -30,7 +40,7 @@ func (s *ChainingService) HandleRequest(w http.ResponseWriter, r *http.Request)
 
 	exists, err := s.checkExists(msg.ID)
 	if err != nil {
-		log.Errorf("%s: %v", msg.ID, err)
+		log.WithContext(r.Context()).Errorf("%s: %v", msg.ID, err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
@@ -40,38 +50,97 @@ func (s *ChainingService) HandleRequest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	idAuth, err := s.getAuth(msg.ID)
+	idAuth, err := s.getAcceptedAuth(msg.ID)
 	if err != nil {
-		log.Errorf("%s: %v", msg.ID, err)
+		log.WithContext(r.Context()).Errorf("%s: %v", msg.ID, err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	msg.Auth, err = checkAuth(r, idAuth)
+	msg.Auth, err = checkAuth(r, msg.ID, idAuth, s.BearerAuth)
 	if err != nil {
 		h.Error(msg.ID, w, err, http.StatusUnauthorized)
 		return
 	}
 
-	msg.Hash, err = h.GetHash(r)
+	verifySignature, err := s.checkBodySignature(r, msg.ID)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusUnauthorized)
+		return
+	}
+
+	enc, err := getResponseEncoding(r)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusNotAcceptable)
+		return
+	}
+
+	requestedType, err := getUPPType(r, chainedUPP)
 	if err != nil {
 		h.Error(msg.ID, w, err, http.StatusBadRequest)
 		return
 	}
 
-	tx, identity, err := s.Protocol.FetchIdentityWithLock(r.Context(), msg.ID)
+	msg.Hint, err = getUPPHint(r)
 	if err != nil {
-		log.Errorf("%s: %v", msg.ID, err)
-		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		h.Error(msg.ID, w, err, http.StatusBadRequest)
 		return
 	}
 
-	resp := s.chain(msg, tx, identity)
-	h.SendResponse(w, resp)
+	if msg.Hint != nil && requestedType == chainedUPP {
+		h.Error(msg.ID, w, ErrHintForChainedUPP, http.StatusBadRequest)
+		return
+	}
+
+	hashAlg, err := s.getHashAlgorithm(r, msg.ID)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusBadRequest)
+		return
+	}
+
+	msg.Hash, err = h.GetHash(r, hashAlg, s.Canonicalization)
+	if err != nil {
+		h.Error(msg.ID, w, err, h.BodyErrorCode(err))
+		return
+	}
+
+	err = verifySignature()
+	if err != nil {
+		h.Error(msg.ID, w, err, h.BodyErrorCode(err))
+		return
+	}
+
+	// the nonce is only registered once the request is authentic, so that a forged request
+	// can not use up the nonce of the genuine request
+	err = s.checkReplay(r, msg.ID)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusUnauthorized)
+		return
+	}
+
+	op := chainHash
+	sign := func() h.HTTPResponse {
+		return s.sendChained(r.Context(), s.Workers, msg)
+	}
+	if requestedType == signedUPP {
+		// a standalone signed UPP does not advance the chain
+		op = anchorHash
+		sign = func() h.HTTPResponse {
+			return s.Sign(r.Context(), msg, anchorHash)
+		}
+	}
+
+	resp, ok := s.signIdempotent(w, r, msg, op, sign)
+	if !ok {
+		return
+	}
+	h.SendResponse(w, encodeSigningResponse(resp, enc))
 }
 
 type SigningService struct {
 	*Signer
+	Async   *AsyncSigner  // if set, requests with a callback URL are processed asynchronously
+	Workers *ChainWorkers // if set, requests for chained UPPs are chained by one worker per UUID
 }
 
 var _ h.Service = (*SigningService)(nil)
@@ -88,7 +157,7 @@ func (s *SigningService) HandleRequest(w http.ResponseWriter, r *http.Request) {
 
 	exists, err := s.checkExists(msg.ID)
 	if err != nil {
-		log.Errorf("%s: %v", msg.ID, err)
+		log.WithContext(r.Context()).Errorf("%s: %v", msg.ID, err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
@@ -98,33 +167,295 @@ func (s *SigningService) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	idAuth, err := s.getAuth(msg.ID)
+	idAuth, err := s.getAcceptedAuth(msg.ID)
 	if err != nil {
-		log.Errorf("%s: %v", msg.ID, err)
+		log.WithContext(r.Context()).Errorf("%s: %v", msg.ID, err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	msg.Auth, err = checkAuth(r, idAuth)
+	msg.Auth, err = checkAuth(r, msg.ID, idAuth, s.BearerAuth)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusUnauthorized)
+		return
+	}
+
+	verifySignature, err := s.checkBodySignature(r, msg.ID)
 	if err != nil {
 		h.Error(msg.ID, w, err, http.StatusUnauthorized)
 		return
 	}
 
+	enc, err := getResponseEncoding(r)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusNotAcceptable)
+		return
+	}
+
 	op, err := getOperation(r)
 	if err != nil {
 		h.Error(msg.ID, w, err, http.StatusNotFound)
 		return
 	}
 
-	msg.Hash, err = h.GetHash(r)
+	requestedType, err := getUPPType(r, signedUPP)
 	if err != nil {
 		h.Error(msg.ID, w, err, http.StatusBadRequest)
 		return
 	}
 
-	resp := s.Sign(msg, op)
-	h.SendResponse(w, resp)
+	if requestedType == chainedUPP && op != anchorHash {
+		h.Error(msg.ID, w, fmt.Errorf("chained UPPs can only be requested for operation \"%s\", got \"%s\"", anchorHash, op), http.StatusBadRequest)
+		return
+	}
+
+	msg.Hint, err = getUPPHint(r)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusBadRequest)
+		return
+	}
+
+	if msg.Hint != nil && requestedType == chainedUPP {
+		h.Error(msg.ID, w, ErrHintForChainedUPP, http.StatusBadRequest)
+		return
+	}
+
+	hashAlg, err := s.getHashAlgorithm(r, msg.ID)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusBadRequest)
+		return
+	}
+
+	msg.Hash, err = h.GetHash(r, hashAlg, s.Canonicalization)
+	if err != nil {
+		h.Error(msg.ID, w, err, h.BodyErrorCode(err))
+		return
+	}
+
+	err = verifySignature()
+	if err != nil {
+		h.Error(msg.ID, w, err, h.BodyErrorCode(err))
+		return
+	}
+
+	// the nonce is only registered once the request is authentic, so that a forged request
+	// can not use up the nonce of the genuine request
+	err = s.checkReplay(r, msg.ID)
+	if err != nil {
+		h.Error(msg.ID, w, err, http.StatusUnauthorized)
+		return
+	}
+
+	if s.Async != nil {
+		callbackURL, err := s.Async.getCallbackURL(r)
+		if err != nil {
+			h.Error(msg.ID, w, err, http.StatusBadRequest)
+			return
+		}
+
+		if callbackURL != "" && requestedType == chainedUPP {
+			h.Error(msg.ID, w, fmt.Errorf("asynchronous requests are only supported for signed UPPs"), http.StatusBadRequest)
+			return
+		}
+
+		if callbackURL != "" {
+			if !s.checkRequestLimits(w, r, msg.ID, 1) {
+				return
+			}
+
+			jobID, err := s.Async.Enqueue(msg, op, callbackURL)
+			if err == ErrQueueFull || err == ErrShuttingDown {
+				h.Error(msg.ID, w, err, http.StatusServiceUnavailable)
+				return
+			}
+			if err != nil {
+				log.WithContext(r.Context()).Errorf("%s: %v", msg.ID, err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			log.WithContext(r.Context()).Infof("%s: %s hash: accepted as job %s", msg.ID, op, jobID)
+			sendJobResponse(w, http.StatusAccepted, jobID, jobs.Pending)
+			return
+		}
+	}
+
+	sign := func() h.HTTPResponse {
+		return s.Sign(r.Context(), msg, op)
+	}
+	if requestedType == chainedUPP {
+		op = chainHash
+		sign = func() h.HTTPResponse {
+			return s.sendChained(r.Context(), s.Workers, msg)
+		}
+	}
+
+	resp, ok := s.signIdempotent(w, r, msg, op, sign)
+	if !ok {
+		return
+	}
+	h.SendResponse(w, encodeSigningResponse(resp, enc))
+}
+
+type PublicKeyService struct {
+	*Signer
+}
+
+var _ h.Service = (*PublicKeyService)(nil)
+
+const (
+	formatQueryKey = "format"
+	pemFormat      = "pem"
+	base64Format   = "base64"
+)
+
+// HandleRequest responds with the public key of the identity, PEM encoded by default
+// or as base64 encoded raw key if the request has the query parameter "format=base64"
+func (s *PublicKeyService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	uid, err := h.GetUUID(r)
+	if err != nil {
+		h.Error(uid, w, err, http.StatusNotFound)
+		return
+	}
+
+	exists, err := s.checkExists(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		h.Error(uid, w, fmt.Errorf("unknown UUID"), http.StatusNotFound)
+		return
+	}
+
+	idAuth, err := s.getAcceptedAuth(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = checkAuth(r, uid, idAuth, s.BearerAuth)
+	if err != nil {
+		h.Error(uid, w, err, http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get(formatQueryKey)
+	if format != "" && format != pemFormat && format != base64Format {
+		h.Error(uid, w, fmt.Errorf("invalid format: "+
+			"expected (\"%s\" | \"%s\"), got \"%s\"", pemFormat, base64Format, format), http.StatusBadRequest)
+		return
+	}
+
+	pubKeyPEM, err := s.Protocol.GetPublicKey(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: could not fetch public key: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if format != base64Format {
+		h.SendResponse(w, h.HTTPResponse{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {h.PEMType}},
+			Content:    pubKeyPEM,
+		})
+		return
+	}
+
+	pubKey, err := s.Protocol.PublicKeyPEMToBytes(pubKeyPEM)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: could not decode public key: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {h.TextType}},
+		Content:    []byte(base64.StdEncoding.EncodeToString(pubKey)),
+	})
+}
+
+type CSRService struct {
+	*IdentityHandler
+	Signer     *Signer
+	BearerAuth bool // accept the auth token as bearer token in the Authorization header
+}
+
+var _ h.Service = (*CSRService)(nil)
+
+// CSRSubjectHeader contains the subject of the re-submitted CSR, so that the subject information can be confirmed
+const CSRSubjectHeader = "X-CSR-Subject"
+
+// HandleRequest regenerates the CSR of the identity with the current subject information
+// and re-submits it to the identity service
+func (s *CSRService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	uid, err := h.GetUUID(r)
+	if err != nil {
+		h.Error(uid, w, err, http.StatusNotFound)
+		return
+	}
+
+	exists, err := s.Protocol.Exists(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		h.Error(uid, w, fmt.Errorf("unknown UUID"), http.StatusNotFound)
+		return
+	}
+
+	idAuth, err := s.Signer.getAcceptedAuth(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = checkAuth(r, uid, idAuth, s.BearerAuth)
+	if err != nil {
+		h.Error(uid, w, err, http.StatusUnauthorized)
+		return
+	}
+
+	idServiceResp, err := s.ResubmitCSR(uid)
+	if err != nil {
+		log.WithContext(r.Context()).Errorf("%s: %v", uid, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if idServiceResp.StatusCode == http.StatusConflict {
+		log.WithContext(r.Context()).Infof("%s: CSR re-submitted: certificate already registered", uid)
+	} else if h.HttpFailed(idServiceResp.StatusCode) {
+		log.WithContext(r.Context()).Warnf("%s: CSR re-submission failed: (%d) %q", uid, idServiceResp.StatusCode, idServiceResp.Content)
+	} else {
+		log.WithContext(r.Context()).Infof("%s: CSR re-submitted", uid)
+	}
+
+	// the response of the identity service is forwarded as it is
+	subject := pkix.Name{
+		Country:      []string{s.SubjectCountry},
+		Organization: []string{s.SubjectOrganization},
+		CommonName:   uid.String(),
+	}
+	header := http.Header{CSRSubjectHeader: {subject.String()}}
+	if contentType := idServiceResp.Header.Get("Content-Type"); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	h.SendResponse(w, h.HTTPResponse{
+		StatusCode: idServiceResp.StatusCode,
+		Header:     header,
+		Content:    idServiceResp.Content,
+	})
 }
 
 type VerificationService struct {
@@ -134,27 +465,264 @@ type VerificationService struct {
 var _ h.Service = (*VerificationService)(nil)
 
 func (v *VerificationService) HandleRequest(w http.ResponseWriter, r *http.Request) {
-	hash, err := h.GetHash(r)
+	hashAlg, err := h.RequestHashAlgorithm(r.Header, h.DefaultHashAlgorithm)
+	if err != nil {
+		h.Error(uuid.Nil, w, err, http.StatusBadRequest)
+		return
+	}
+
+	hash, err := h.GetHash(r, hashAlg, v.Canonicalization)
+	if err != nil {
+		h.Error(uuid.Nil, w, err, h.BodyErrorCode(err))
+		return
+	}
+
+	resp := v.Verify(r.Context(), hash[:])
+	h.SendResponse(w, resp)
+}
+
+type AnchoredVerificationService struct {
+	*Verifier
+}
+
+var _ h.Service = (*AnchoredVerificationService)(nil)
+
+// HandleRequest responds with the blockchain anchors of the UPP which contains the hash,
+// as soon as the UPP was anchored or the anchor timeout elapsed
+func (v *AnchoredVerificationService) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	hashAlg, err := h.RequestHashAlgorithm(r.Header, h.DefaultHashAlgorithm)
 	if err != nil {
 		h.Error(uuid.Nil, w, err, http.StatusBadRequest)
 		return
 	}
 
-	resp := v.Verify(hash[:])
+	hash, err := h.GetHash(r, hashAlg, v.Canonicalization)
+	if err != nil {
+		h.Error(uuid.Nil, w, err, h.BodyErrorCode(err))
+		return
+	}
+
+	resp := v.VerifyAnchored(r.Context(), hash)
 	h.SendResponse(w, resp)
 }
 
-// checkAuth compares the auth token from the request header with a given string and returns it if valid
+// checkBodySignature returns a function which verifies the HMAC over the request body in the X-Signature header
+// once the body was read, if the identity has a shared secret. Returns an error if the header is missing or invalid.
+// Identities without shared secret are not required to sign their requests. With replay protection, the X-Timestamp
+// and X-Nonce headers are covered by the HMAC as well, so that a captured request can not be replayed with new ones.
+func (s *Signer) checkBodySignature(r *http.Request, uid uuid.UUID) (verify func() error, err error) {
+	secret, found := s.BodySecrets[uid]
+	if !found {
+		return func() error { return nil }, nil
+	}
+
+	var signedHeaders []string
+	if s.ReplayGuard != nil {
+		signedHeaders = []string{TimestampHeader, NonceHeader}
+	}
+
+	signature, err := h.NewBodySignature(r, secret, signedHeaders...)
+	if err != nil {
+		return nil, err
+	}
+	return signature.Verify, nil
+}
+
+// checkReplay returns an error if the request has no valid X-Timestamp and X-Nonce headers,
+// or if it is a replay of a previous request of the identity
+func (s *Signer) checkReplay(r *http.Request, uid uuid.UUID) error {
+	if s.ReplayGuard == nil {
+		return nil
+	}
+	return s.ReplayGuard.Check(uid, r.Header.Get(TimestampHeader), r.Header.Get(NonceHeader))
+}
+
+// checkHTTPOnly returns an error if the requests of the identity must carry an HMAC over the request body or
+// replay protection headers, which only HTTP requests can carry, so that these checks can not be bypassed via
+// MQTT or UDP
+func (s *Signer) checkHTTPOnly(uid uuid.UUID) error {
+	if _, found := s.BodySecrets[uid]; found {
+		return fmt.Errorf("requests of the identity must be signed, which is only supported via HTTP")
+	}
+	if s.ReplayGuard != nil {
+		return fmt.Errorf("replay protection is only supported via HTTP")
+	}
+	return nil
+}
+
+// signIdempotent calls sign, or returns the response of a previous request of the identity with the same
+// Idempotency-Key header, without signing the hash again. Without Idempotency-Key header, the response of a
+// previous request of the identity with the same hash and operation within the dedup window is returned, if
+// deduplication is enabled. If the idempotency key is invalid or was used for a different request, it responds
+// with an error and returns false. Only requests which are not answered with the response of a previous request
+// count towards the rate limit and the daily quota of the identity.
+func (s *Signer) signIdempotent(w http.ResponseWriter, r *http.Request, msg h.HTTPRequest, op operation,
+	signUnlimited func() h.HTTPResponse) (h.HTTPResponse, bool) {
+
+	sign := func() h.HTTPResponse {
+		if err := s.checkLimits(msg.ID, 1); err != nil {
+			return limitResponse(msg.ID, err)
+		}
+		return signUnlimited()
+	}
+
+	// requests with a different custom hint result in a different UPP
+	requestOp := op
+	if msg.Hint != nil {
+		requestOp = operation(fmt.Sprintf("%s:%d", op, *msg.Hint))
+	}
+
+	if key := idempotencyKey(r); s.Idempotency != nil && key != "" {
+		resp, replayed, err := s.Idempotency.Do(r.Context(), msg.ID, key, requestOp, msg.Hash, sign)
+		switch err {
+		case nil:
+		case ErrIdempotencyKeyReused:
+			h.Error(msg.ID, w, err, http.StatusUnprocessableEntity)
+			return h.HTTPResponse{}, false
+		case ErrIdempotencyCacheFull:
+			h.Error(msg.ID, w, err, http.StatusServiceUnavailable)
+			return h.HTTPResponse{}, false
+		default:
+			h.Error(msg.ID, w, err, http.StatusBadRequest)
+			return h.HTTPResponse{}, false
+		}
+
+		if replayed {
+			log.WithContext(r.Context()).Infof("%s: %s hash: %s: response of previous request with the same idempotency key",
+				msg.ID, op, base64.StdEncoding.EncodeToString(msg.Hash))
+			w.Header().Set(IdempotentReplayedHeader, "true")
+		}
+		return resp, true
+	}
+
+	if s.Dedup != nil {
+		hashBase64 := base64.StdEncoding.EncodeToString(msg.Hash)
+
+		// the key can not be reused for a different request, since it consists of the operation and the hash
+		resp, replayed, err := s.Dedup.Do(r.Context(), msg.ID, string(requestOp)+":"+hashBase64, requestOp, msg.Hash, sign)
+		if err == ErrIdempotencyCacheFull {
+			h.Error(msg.ID, w, err, http.StatusServiceUnavailable)
+			return h.HTTPResponse{}, false
+		}
+		if err != nil {
+			log.WithContext(r.Context()).Errorf("%s: %v", msg.ID, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return h.HTTPResponse{}, false
+		}
+
+		if replayed {
+			log.WithContext(r.Context()).Infof("%s: %s hash: %s: response of previous request with the same hash",
+				msg.ID, op, hashBase64)
+			w.Header().Set(DeduplicatedHeader, "true")
+		}
+		return resp, true
+	}
+
+	return sign(), true
+}
+
+// limitError is returned by checkLimits if the signing requests exceed the rate limit or the daily quota of the identity
+type limitError struct {
+	err        error
+	retryAfter time.Duration // time until the requests would be allowed
+}
+
+func (e *limitError) Error() string {
+	return e.err.Error()
+}
+
+// checkLimits counts n signing requests of the identity against its rate limit and its daily quota.
+// A request of n hashes counts as one request towards the rate limit, but as n requests towards the quota.
+// Returns a *limitError if either is exceeded. The checks apply to the requests of all interfaces,
+// i.e. HTTP, MQTT and UDP, so that they can not be bypassed by another interface.
+func (s *Signer) checkLimits(uid uuid.UUID, n int) *limitError {
+	if s.RateLimiter != nil {
+		ok, retryAfter := s.RateLimiter.Allow(uid)
+		if !ok {
+			return &limitError{err: fmt.Errorf("rate limit exceeded"), retryAfter: retryAfter}
+		}
+	}
+
+	if s.Quota != nil {
+		ok, resetsIn := s.Quota.Take(uid, n)
+		if !ok {
+			return &limitError{
+				err:        fmt.Errorf("daily quota of %d signing requests exceeded", s.Quota.Usage(uid).Quota),
+				retryAfter: resetsIn,
+			}
+		}
+	}
+	return nil
+}
+
+// checkRequestLimits calls checkLimits for n signing requests of the identity and returns true if they are allowed.
+// Otherwise it responds with 429 and returns false.
+func (s *Signer) checkRequestLimits(w http.ResponseWriter, r *http.Request, uid uuid.UUID, n int) bool {
+	err := s.checkLimits(uid, n)
+	if err == nil {
+		return true
+	}
+	h.SendResponse(w, limitResponse(uid, err))
+	return false
+}
+
+// limitResponse returns the 429 response to signing requests which were rejected by checkLimits. The
+// Retry-After header contains the seconds until the requests would be allowed.
+func limitResponse(uid uuid.UUID, err *limitError) h.HTTPResponse {
+	log.Warnf("%s: %v", uid, err)
+	resp := errorResponse(http.StatusTooManyRequests, err.Error())
+	resp.Header.Set("Retry-After", strconv.Itoa(int(math.Ceil(err.retryAfter.Seconds()))))
+	return resp
+}
+
+// checkAuth compares the auth token from the request header with the accepted auth tokens of the identity
+// and returns it if valid. The first accepted auth token is the stored auth token of the identity, the
+// others are accepted in addition, e.g. the old auth token during a token rotation.
+// If bearerAuth is set and the request has no X-Auth-Token header, the bearer token from the
+// Authorization header is used instead.
+// A verified client certificate which was issued for the UUID is accepted instead of the auth token,
+// in this case the stored auth token is returned for the requests to the ubirch backend.
 // Returns error if auth token is invalid
-func checkAuth(r *http.Request, actualAuth string) (string, error) {
+func checkAuth(r *http.Request, uid uuid.UUID, acceptedAuth []string, bearerAuth bool) (string, error) {
+	if len(acceptedAuth) == 0 {
+		return "", fmt.Errorf("invalid auth token")
+	}
+
+	if h.HasClientCertFor(r, uid) {
+		return acceptedAuth[0], nil
+	}
+
 	headerAuthToken := h.AuthToken(r.Header)
-	if actualAuth != headerAuthToken {
+	if headerAuthToken == "" && bearerAuth {
+		headerAuthToken = h.BearerToken(r.Header)
+	}
+	if !matchAuth(acceptedAuth, headerAuthToken) {
 		return "", fmt.Errorf("invalid auth token")
 	}
 
 	return headerAuthToken, nil
 }
 
+// matchAuth returns true if the auth token matches one of the accepted auth tokens. All accepted
+// tokens are compared, so that the time needed does not reveal which of them matched.
+func matchAuth(acceptedAuth []string, actual string) bool {
+	match := false
+	for _, expected := range acceptedAuth {
+		if equalAuth(expected, actual) {
+			match = true
+		}
+	}
+	return match
+}
+
+// equalAuth compares two auth tokens in constant time. The tokens are hashed before comparison,
+// so that the time needed for the comparison also does not reveal the length of the expected token.
+func equalAuth(expected, actual string) bool {
+	expectedHash := sha256.Sum256([]byte(expected))
+	actualHash := sha256.Sum256([]byte(actual))
+	return subtle.ConstantTimeCompare(expectedHash[:], actualHash[:]) == 1
+}
+
 // getOperation returns the operation parameter from the request URL
 func getOperation(r *http.Request) (operation, error) {
 	opParam := chi.URLParam(r, h.OperationKey)
@@ -167,3 +735,50 @@ func getOperation(r *http.Request) (operation, error) {
 			anchorHash, disableHash, enableHash, deleteHash, opParam)
 	}
 }
+
+const (
+	UPPHintHeader = "X-UPP-Hint" // request header with a custom hint for signed UPPs
+	UPPTypeHeader = "X-UPP-Type" // request header which selects a chained or a signed UPP
+)
+
+var (
+	ErrInvalidUPPHint    = fmt.Errorf("invalid %s header: expected integer between 0 and 255", UPPHintHeader)
+	ErrHintForChainedUPP = fmt.Errorf("%s header is only supported for signed UPPs", UPPHintHeader)
+)
+
+type uppType string
+
+const (
+	signedUPP  uppType = "signed"
+	chainedUPP uppType = "chained"
+)
+
+// getUPPType returns the UPP type from the X-UPP-Type header of the request,
+// or the default UPP type of the endpoint if the header is not set
+func getUPPType(r *http.Request, defaultType uppType) (uppType, error) {
+	typeHeader := uppType(strings.ToLower(strings.TrimSpace(r.Header.Get(UPPTypeHeader))))
+	switch typeHeader {
+	case "":
+		return defaultType, nil
+	case signedUPP, chainedUPP:
+		return typeHeader, nil
+	default:
+		return "", fmt.Errorf("invalid %s header: expected (\"%s\" | \"%s\"), got \"%s\"",
+			UPPTypeHeader, signedUPP, chainedUPP, typeHeader)
+	}
+}
+
+// getUPPHint returns the custom hint from the X-UPP-Hint header of the request, or nil if the header is not set
+func getUPPHint(r *http.Request) (*uint8, error) {
+	hintHeader := strings.TrimSpace(r.Header.Get(UPPHintHeader))
+	if hintHeader == "" {
+		return nil, nil
+	}
+
+	hint, err := strconv.ParseUint(hintHeader, 10, 8)
+	if err != nil {
+		return nil, ErrInvalidUPPHint
+	}
+	b := uint8(hint)
+	return &b, nil
+}
@@ -0,0 +1,868 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+func TestCheckAuth(t *testing.T) {
+	uid := uuid.New()
+
+	var tests = []struct {
+		name       string
+		header     map[string]string
+		bearerAuth bool
+		clientCert *x509.Certificate
+		valid      bool
+	}{
+		{
+			name:   "X-Auth-Token",
+			header: map[string]string{"X-Auth-Token": testAuth},
+			valid:  true,
+		},
+		{
+			name:       "X-Auth-Token with bearer auth enabled",
+			header:     map[string]string{"X-Auth-Token": testAuth},
+			bearerAuth: true,
+			valid:      true,
+		},
+		{
+			name:       "bearer token",
+			header:     map[string]string{"Authorization": "Bearer " + testAuth},
+			bearerAuth: true,
+			valid:      true,
+		},
+		{
+			name:       "bearer token with bearer auth disabled",
+			header:     map[string]string{"Authorization": "Bearer " + testAuth},
+			bearerAuth: false,
+			valid:      false,
+		},
+		{
+			name:       "X-Auth-Token takes precedence",
+			header:     map[string]string{"X-Auth-Token": "wrong", "Authorization": "Bearer " + testAuth},
+			bearerAuth: true,
+			valid:      false,
+		},
+		{
+			name:       "invalid token",
+			header:     map[string]string{"X-Auth-Token": "wrong"},
+			bearerAuth: true,
+			valid:      false,
+		},
+		{
+			name:       "client certificate",
+			clientCert: &x509.Certificate{Subject: pkix.Name{CommonName: uid.String()}},
+			valid:      true,
+		},
+		{
+			name:       "client certificate with UUID in SAN",
+			clientCert: &x509.Certificate{URIs: []*url.URL{{Scheme: "urn", Opaque: "uuid:" + uid.String()}}},
+			valid:      true,
+		},
+		{
+			name:       "client certificate of other UUID",
+			clientCert: &x509.Certificate{Subject: pkix.Name{CommonName: uuid.NewString()}},
+			valid:      false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			for k, v := range test.header {
+				r.Header.Set(k, v)
+			}
+			if test.clientCert != nil {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{test.clientCert}}}
+			}
+
+			auth, err := checkAuth(r, uid, []string{testAuth}, test.bearerAuth)
+			if test.valid {
+				if err != nil {
+					t.Errorf("valid auth token was rejected: %v", err)
+				}
+				if auth != testAuth {
+					t.Errorf("unexpected auth token: expected %s, got %s", testAuth, auth)
+				}
+			} else if err == nil {
+				t.Error("invalid auth token was accepted")
+			}
+		})
+	}
+}
+
+func TestCheckAuth_MultipleTokens(t *testing.T) {
+	uid := uuid.New()
+	acceptedAuth := []string{"new-auth", "old-auth"}
+
+	for _, token := range acceptedAuth {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("X-Auth-Token", token)
+
+		auth, err := checkAuth(r, uid, acceptedAuth, false)
+		if err != nil {
+			t.Errorf("accepted auth token %s was rejected: %v", token, err)
+		}
+		if auth != token {
+			t.Errorf("unexpected auth token: expected %s, got %s", token, auth)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("X-Auth-Token", "other-auth")
+	if _, err := checkAuth(r, uid, acceptedAuth, false); err == nil {
+		t.Error("auth token which is not in the list of accepted auth tokens was accepted")
+	}
+}
+
+func TestEqualAuth(t *testing.T) {
+	if !equalAuth(testAuth, testAuth) {
+		t.Error("matching tokens were not recognized as equal")
+	}
+	for _, token := range []string{"", "wrong", testAuth + "x", testAuth[:len(testAuth)-1]} {
+		if equalAuth(testAuth, token) {
+			t.Errorf("mismatching token %q was recognized as equal", token)
+		}
+	}
+}
+
+func TestPublicKeyService(t *testing.T) {
+	signer, _ := newTestSigner(t, "")
+	uid := newTestIdentity(t, signer.Protocol)
+
+	pubKeyPEM, err := signer.Protocol.GetPublicKey(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := signer.Protocol.PublicKeyPEMToBytes(pubKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name                string
+		uid                 uuid.UUID
+		auth                string
+		query               string
+		expectedCode        int
+		expectedContentType string
+		expectedContent     string
+	}{
+		{
+			name:                "PEM",
+			uid:                 uid,
+			auth:                testAuth,
+			expectedCode:        http.StatusOK,
+			expectedContentType: h.PEMType,
+			expectedContent:     string(pubKeyPEM),
+		},
+		{
+			name:                "base64",
+			uid:                 uid,
+			auth:                testAuth,
+			query:               "?format=base64",
+			expectedCode:        http.StatusOK,
+			expectedContentType: h.TextType,
+			expectedContent:     base64.StdEncoding.EncodeToString(pubKey),
+		},
+		{
+			name:         "invalid format",
+			uid:          uid,
+			auth:         testAuth,
+			query:        "?format=der",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "unknown UUID",
+			uid:          uuid.New(),
+			auth:         testAuth,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "invalid auth token",
+			uid:          uid,
+			auth:         "wrong",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	router := newTestPublicKeyRouter(signer)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := sendPublicKeyRequest(router, test.uid, test.auth, test.query)
+			if w.Code != test.expectedCode {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", test.expectedCode, w.Code, w.Body.String())
+			}
+			if test.expectedContentType == "" {
+				return
+			}
+			if w.Header().Get("Content-Type") != test.expectedContentType {
+				t.Errorf("unexpected content type: expected %s, got %s", test.expectedContentType, w.Header().Get("Content-Type"))
+			}
+			if w.Body.String() != test.expectedContent {
+				t.Errorf("unexpected content: expected %s, got %s", test.expectedContent, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPublicKeyService_KeyStoreFailure(t *testing.T) {
+	ctxManager := &failingKeyCtxManager{newMockCtxManager()}
+
+	p, err := repository.NewExtendedProtocol(ctxManager, testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := newTestSigner(t, "")
+	signer.Protocol = p
+	uid := newTestIdentity(t, p)
+
+	w := sendPublicKeyRequest(newTestPublicKeyRouter(signer), uid, testAuth, "")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+}
+
+// failingKeyCtxManager is a mockCtxManager which fails to read public keys
+type failingKeyCtxManager struct {
+	*mockCtxManager
+}
+
+func (m *failingKeyCtxManager) GetPublicKey(uuid.UUID) ([]byte, error) {
+	return nil, fmt.Errorf("key store unavailable")
+}
+
+func newTestPublicKeyRouter(signer *Signer) *chi.Mux {
+	router := chi.NewMux()
+	router.Get(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.KeyEndpoint), (&PublicKeyService{Signer: signer}).HandleRequest)
+	return router
+}
+
+func sendPublicKeyRequest(router http.Handler, uid uuid.UUID, auth, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%s/%s%s", uid, h.KeyEndpoint, query), nil)
+	r.Header.Set("X-Auth-Token", auth)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestCSRService(t *testing.T) {
+	var tests = []struct {
+		name                string
+		identityServiceCode int
+		auth                string
+		expectedCode        int
+	}{
+		{
+			name:                "registered",
+			identityServiceCode: http.StatusOK,
+			auth:                testAuth,
+			expectedCode:        http.StatusOK,
+		},
+		{
+			name:                "already registered",
+			identityServiceCode: http.StatusConflict,
+			auth:                testAuth,
+			expectedCode:        http.StatusConflict,
+		},
+		{
+			name:                "rejected",
+			identityServiceCode: http.StatusBadRequest,
+			auth:                testAuth,
+			expectedCode:        http.StatusBadRequest,
+		},
+		{
+			name:                "additional auth token",
+			identityServiceCode: http.StatusOK,
+			auth:                "additional-auth",
+			expectedCode:        http.StatusOK,
+		},
+		{
+			name:                "invalid auth token",
+			identityServiceCode: http.StatusOK,
+			auth:                "wrong",
+			expectedCode:        http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var receivedCSR []byte
+			identityService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				receivedCSR, _ = ioutil.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.identityServiceCode)
+				_, _ = w.Write([]byte(`{"identity":"service response"}`))
+			}))
+			defer identityService.Close()
+
+			signer, _ := newTestSigner(t, "")
+			signer.Protocol.IdentityServiceURL = identityService.URL
+			uid := newTestIdentity(t, signer.Protocol)
+			signer.SetAdditionalAuthTokens(uid, []string{"additional-auth"})
+
+			service := &CSRService{
+				IdentityHandler: &IdentityHandler{Protocol: signer.Protocol, SubjectCountry: "DE", SubjectOrganization: "test"},
+				Signer:          signer,
+			}
+			router := chi.NewMux()
+			router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.CSREndpoint), service.HandleRequest)
+
+			r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s", uid, h.CSREndpoint), nil)
+			r.Header.Set("X-Auth-Token", test.auth)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", test.expectedCode, w.Code, w.Body.String())
+			}
+			if test.expectedCode == http.StatusUnauthorized {
+				return
+			}
+
+			// the response of the identity service is forwarded as it is
+			if w.Body.String() != `{"identity":"service response"}` || w.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("identity service response was not forwarded: %s: %s", w.Header().Get("Content-Type"), w.Body.String())
+			}
+			expectedSubject := fmt.Sprintf("CN=%s,O=test,C=DE", uid)
+			if subject := w.Header().Get(CSRSubjectHeader); subject != expectedSubject {
+				t.Errorf("unexpected subject: expected %s, got %s", expectedSubject, subject)
+			}
+
+			csr, err := x509.ParseCertificateRequest(receivedCSR)
+			if err != nil {
+				t.Fatalf("unable to parse CSR: %v", err)
+			}
+			if csr.Subject.String() != expectedSubject {
+				t.Errorf("unexpected CSR subject: %s", csr.Subject)
+			}
+		})
+	}
+}
+
+func TestServices_ProblemJSON(t *testing.T) {
+	signer, _ := newTestSigner(t, "")
+	uid := newTestIdentity(t, signer.Protocol)
+
+	router := chi.NewMux()
+	router.Use(h.RequestID)
+	router.Use(h.ProblemJSON)
+	router.Post(fmt.Sprintf("/{%s}", h.UUIDKey), (&ChainingService{Signer: signer}).HandleRequest)
+	router.Post(fmt.Sprintf("/%s", h.VerifyPath), (&VerificationService{Verifier: &Verifier{Protocol: signer.Protocol}}).HandleRequest)
+
+	tests := []struct {
+		name           string
+		path           string
+		auth           string
+		header         http.Header
+		expectedStatus int
+		expectedDetail string
+	}{
+		{
+			name:           "signing: unknown UUID",
+			path:           "/" + uuid.NewString(),
+			auth:           testAuth,
+			expectedStatus: http.StatusNotFound,
+			expectedDetail: "unknown UUID",
+		},
+		{
+			name:           "signing: invalid auth token",
+			path:           "/" + uid.String(),
+			auth:           "wrong",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "signing: invalid content type",
+			path:           "/" + uid.String(),
+			auth:           testAuth,
+			header:         http.Header{"Content-Type": {"text/csv"}},
+			expectedStatus: http.StatusBadRequest,
+			expectedDetail: "invalid content-type for original data: expected (\"application/octet-stream\" | \"application/json\")",
+		},
+		{
+			name:           "verification: unknown hash algorithm",
+			path:           "/" + h.VerifyPath,
+			header:         http.Header{"X-Hash-Algorithm": {"md5"}},
+			expectedStatus: http.StatusBadRequest,
+			expectedDetail: "unknown hash algorithm: expected (\"sha256\" | \"sha512\"), got \"md5\"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, test.path, bytes.NewBufferString("data"))
+			for k, v := range test.header {
+				r.Header[k] = v
+			}
+			r.Header.Set("X-Auth-Token", test.auth)
+			r.Header.Set(h.RequestIDHeader, "test-request-id")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.expectedStatus {
+				t.Errorf("unexpected response code: expected %d, got %d", test.expectedStatus, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != h.MimeApplicationProblem {
+				t.Errorf("unexpected content type: %s", ct)
+			}
+
+			var problem map[string]interface{}
+			err := json.Unmarshal(w.Body.Bytes(), &problem)
+			if err != nil {
+				t.Fatalf("response is not JSON: %v: %s", err, w.Body.String())
+			}
+
+			expected := map[string]interface{}{
+				"type":      "about:blank",
+				"title":     http.StatusText(test.expectedStatus),
+				"status":    float64(test.expectedStatus),
+				"requestId": "test-request-id",
+			}
+			if test.expectedDetail != "" {
+				expected["detail"] = test.expectedDetail
+			}
+			for k, v := range expected {
+				if problem[k] != v {
+					t.Errorf("unexpected %q: expected %v, got %v", k, v, problem[k])
+				}
+			}
+		})
+	}
+}
+
+func TestServices_RequestID(t *testing.T) {
+	var backendRequestID string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendRequestID = r.Header.Get(h.RequestIDHeader)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	// capture the log entries with the request ID hook
+	logHook := &logtest.Hook{}
+	oldHooks := log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+	defer log.StandardLogger().ReplaceHooks(oldHooks)
+	log.AddHook(h.RequestIDLogHook{})
+	log.AddHook(logHook)
+
+	router := h.NewRouter()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s", uid, h.HashEndpoint), bytes.NewReader(testSHA256("request-id")))
+	r.Header.Set("Content-Type", h.BinType)
+	r.Header.Set("X-Auth-Token", testAuth)
+	r.Header.Set(h.RequestIDHeader, "test-request-id")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: %d, %s", w.Code, w.Body.String())
+	}
+	if id := w.Header().Get(h.RequestIDHeader); id != "test-request-id" {
+		t.Errorf("request ID was not returned in response header: %q", id)
+	}
+	if backendRequestID != "test-request-id" {
+		t.Errorf("request ID was not forwarded to backend: %q", backendRequestID)
+	}
+
+	var logged int
+	for _, entry := range logHook.AllEntries() {
+		if entry.Data["requestID"] == "test-request-id" {
+			logged++
+		}
+	}
+	if logged == 0 {
+		t.Error("request ID does not appear in logs")
+	}
+
+	// a request ID is generated if the request has none
+	r = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/%s", uid, h.HashEndpoint), bytes.NewReader(testSHA256("generated")))
+	r.Header.Set("Content-Type", h.BinType)
+	r.Header.Set("X-Auth-Token", testAuth)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	generatedID := w.Header().Get(h.RequestIDHeader)
+	if _, err := uuid.Parse(generatedID); err != nil {
+		t.Errorf("no request ID generated: %q", generatedID)
+	}
+	if backendRequestID != generatedID {
+		t.Errorf("generated request ID was not forwarded to backend: %q", backendRequestID)
+	}
+}
+
+func TestServices_Gzip(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	router := h.NewRouter()
+	router.Use(h.MaxBodySize(4096))
+	router.Post(fmt.Sprintf("/{%s}", h.UUIDKey), (&ChainingService{Signer: signer}).HandleRequest)
+
+	newRequest := func(data []byte, contentEncoding string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s", uid), bytes.NewReader(data))
+		r.Header.Set("Content-Type", h.JSONType)
+		r.Header.Set("Content-Encoding", contentEncoding)
+		r.Header.Set("Accept-Encoding", "gzip")
+		r.Header.Set("X-Auth-Token", testAuth)
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest(gzipData(t, []byte(`{"b": 2, "a": 1}`)), "gzip"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: %d, %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("response is not gzip encoded: %v", w.Header())
+	}
+
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp signingResponse
+	err = json.NewDecoder(gz).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the decompressed JSON was canonicalized before hashing
+	expectedHash := sha256.Sum256([]byte(`{"a":1,"b":2}`))
+	if !bytes.Equal(resp.Hash, expectedHash[:]) {
+		t.Errorf("unexpected hash: expected %x, got %x", expectedHash, resp.Hash)
+	}
+
+	// a small compressed body which expands beyond the maximum body size is rejected
+	bomb := gzipData(t, bytes.Repeat([]byte(" "), 1<<20))
+	if len(bomb) > 4096 {
+		t.Fatalf("compressed body too large for test: %d bytes", len(bomb))
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest(bomb, "gzip"))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected response code for decompression beyond limit: expected %d, got %d",
+			http.StatusRequestEntityTooLarge, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newRequest([]byte(`{"a": 1}`), "br"))
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("unexpected response code for unsupported content encoding: expected %d, got %d",
+			http.StatusUnsupportedMediaType, w.Code)
+	}
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	b := &bytes.Buffer{}
+	gz := gzip.NewWriter(b)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestServices_BodySignature(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, ctxManager := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+	secret := []byte("shared secret")
+	signer.BodySecrets = map[uuid.UUID][]byte{uid: secret}
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}", h.UUIDKey), (&ChainingService{Signer: signer}).HandleRequest)
+	router.Post(fmt.Sprintf("/{%s}/{%s}/%s", h.UUIDKey, h.OperationKey, h.BatchEndpoint),
+		(&BatchSigningService{Signer: signer, MaxBatchSize: 3}).HandleRequest)
+
+	sign := func(body []byte) string {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	var tests = []struct {
+		name         string
+		path         string
+		body         []byte
+		signature    string
+		expectedCode int
+	}{
+		{
+			name:         "valid signature",
+			path:         fmt.Sprintf("/%s", uid),
+			body:         []byte(`{"id": 1}`),
+			signature:    sign([]byte(`{"id": 1}`)),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "valid signature with prefix",
+			path:         fmt.Sprintf("/%s", uid),
+			body:         []byte(`{"id": 2}`),
+			signature:    "sha256=" + sign([]byte(`{"id": 2}`)),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "tampered body",
+			path:         fmt.Sprintf("/%s", uid),
+			body:         []byte(`{"id": 4}`),
+			signature:    sign([]byte(`{"id": 3}`)),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "missing signature",
+			path:         fmt.Sprintf("/%s", uid),
+			body:         []byte(`{"id": 5}`),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "malformed signature",
+			path:         fmt.Sprintf("/%s", uid),
+			body:         []byte(`{"id": 6}`),
+			signature:    "not hex",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "valid batch signature",
+			path:         fmt.Sprintf("/%s/chain/%s", uid, h.BatchEndpoint),
+			body:         []byte(`["` + testHash("7") + `"]`),
+			signature:    sign([]byte(`["` + testHash("7") + `"]`)),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "tampered batch",
+			path:         fmt.Sprintf("/%s/chain/%s", uid, h.BatchEndpoint),
+			body:         []byte(`["` + testHash("8") + `"]`),
+			signature:    sign([]byte(`["` + testHash("7") + `"]`)),
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chainBefore := ctxManager.signature(uid)
+
+			r := httptest.NewRequest(http.MethodPost, test.path, bytes.NewReader(test.body))
+			r.Header.Set("Content-Type", h.JSONType)
+			r.Header.Set("X-Auth-Token", testAuth)
+			if test.signature != "" {
+				r.Header.Set(h.SignatureHeader, test.signature)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", test.expectedCode, w.Code, w.Body.String())
+			}
+			chainAfter := ctxManager.signature(uid)
+			if w.Code == http.StatusUnauthorized && !bytes.Equal(chainBefore, chainAfter) {
+				t.Error("request with invalid signature was signed")
+			}
+		})
+	}
+
+	// identities without shared secret are not required to sign their requests
+	otherUID := newTestIdentity(t, signer.Protocol)
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s", otherUID), bytes.NewReader([]byte(`{"id": 9}`)))
+	r.Header.Set("Content-Type", h.JSONType)
+	r.Header.Set("X-Auth-Token", testAuth)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("unsigned request of identity without shared secret was rejected: %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSigningService_UPPHint(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+	router.Post(fmt.Sprintf("/{%s}/{%s}/%s", h.UUIDKey, h.OperationKey, h.HashEndpoint), (&SigningService{Signer: signer}).HandleRequest)
+
+	var tests = []struct {
+		name         string
+		path         string
+		hint         string
+		expectedCode int
+		expectedHint ubirch.Hint
+	}{
+		{"operation hint", "/anchor", "", http.StatusOK, ubirch.Binary},
+		{"custom hint", "/anchor", "66", http.StatusOK, ubirch.Hint(66)},
+		{"custom hint replaces operation hint", "/disable", "255", http.StatusOK, ubirch.Hint(255)},
+		{"zero hint", "/delete", "0", http.StatusOK, ubirch.Hint(0)},
+		{"out of range", "/anchor", "256", http.StatusBadRequest, 0},
+		{"negative", "/anchor", "-1", http.StatusBadRequest, 0},
+		{"not a number", "/anchor", "0x42", http.StatusBadRequest, 0},
+		{"chained UPP", "", "66", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s%s/%s", uid, test.path, h.HashEndpoint), bytes.NewReader(testSHA256(test.name)))
+			r.Header.Set(h.XAuthHeader, testAuth)
+			r.Header.Set("Content-Type", h.BinType)
+			if test.hint != "" {
+				r.Header.Set(UPPHintHeader, test.hint)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", test.expectedCode, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp signingResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			upp, err := ubirch.Decode(resp.UPP)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if upp.GetHint() != test.expectedHint {
+				t.Errorf("unexpected hint: expected %d, got %d", test.expectedHint, upp.GetHint())
+			}
+		})
+	}
+}
+
+func TestServices_UPPType(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, ctxManager := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	router := chi.NewMux()
+	router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.HashEndpoint), (&ChainingService{Signer: signer}).HandleRequest)
+	router.Post(fmt.Sprintf("/{%s}/{%s}/%s", h.UUIDKey, h.OperationKey, h.HashEndpoint), (&SigningService{Signer: signer}).HandleRequest)
+
+	var tests = []struct {
+		name            string
+		path            string
+		uppType         string
+		hint            string
+		expectedCode    int
+		expectedVersion ubirch.ProtocolVersion
+	}{
+		{"chaining endpoint", "", "", "", http.StatusOK, ubirch.Chained},
+		{"chained on chaining endpoint", "", "chained", "", http.StatusOK, ubirch.Chained},
+		{"signed on chaining endpoint", "", "signed", "", http.StatusOK, ubirch.Signed},
+		{"signed with hint on chaining endpoint", "", "Signed", "66", http.StatusOK, ubirch.Signed},
+		{"operation endpoint", "/anchor", "", "", http.StatusOK, ubirch.Signed},
+		{"signed on operation endpoint", "/anchor", "signed", "", http.StatusOK, ubirch.Signed},
+		{"chained on operation endpoint", "/anchor", "chained", "", http.StatusOK, ubirch.Chained},
+		{"chained update operation", "/disable", "chained", "", http.StatusBadRequest, 0},
+		{"chained with hint on chaining endpoint", "", "chained", "66", http.StatusBadRequest, 0},
+		{"chained with hint on operation endpoint", "/anchor", "chained", "66", http.StatusBadRequest, 0},
+		{"unknown type", "", "sealed", "", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chainBefore := ctxManager.signature(uid)
+
+			r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s%s/%s", uid, test.path, h.HashEndpoint), bytes.NewReader(testSHA256(test.name)))
+			r.Header.Set(h.XAuthHeader, testAuth)
+			r.Header.Set("Content-Type", h.BinType)
+			if test.uppType != "" {
+				r.Header.Set(UPPTypeHeader, test.uppType)
+			}
+			if test.hint != "" {
+				r.Header.Set(UPPHintHeader, test.hint)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", test.expectedCode, w.Code, w.Body.String())
+			}
+			chainAdvanced := !bytes.Equal(ctxManager.signature(uid), chainBefore)
+			if w.Code != http.StatusOK {
+				if chainAdvanced {
+					t.Error("rejected request advanced the chain")
+				}
+				return
+			}
+
+			var resp signingResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			upp, err := ubirch.Decode(resp.UPP)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if upp.GetVersion() != test.expectedVersion {
+				t.Errorf("unexpected UPP type: expected %#x, got %#x", test.expectedVersion, upp.GetVersion())
+			}
+
+			// only chained UPPs advance the chain, signed UPPs do not disturb it
+			if chainAdvanced != (test.expectedVersion == ubirch.Chained) {
+				t.Errorf("unexpected change of the chain: %t", chainAdvanced)
+			}
+			if chainBefore == nil {
+				chainBefore = make([]byte, signer.Protocol.SignatureLength())
+			}
+			if test.expectedVersion == ubirch.Chained && !bytes.Equal(upp.GetPrevSignature(), chainBefore) {
+				t.Error("chained UPP is not chained to the previous UPP of the identity")
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const (
+	SignCommandName = "sign"
+
+	SignCommandUsage = "usage: sign [--op (chain | anchor | disable | enable | delete)] [--hash <base64 hash>] " +
+		"[--config-dir <directory>] <UUID> [<file>]"
+)
+
+// SignCommand signs the hash of a file, or a given hash, with an identity and sends the UPP to the ubirch backend
+// from the command line, without running the HTTP server, e.g. to sign artifacts from scripts
+type SignCommand struct {
+	UUID      uuid.UUID
+	File      string    // file whose hash is signed, if no hash is given
+	Hash      h.Hash    // precomputed hash which is signed instead of the hash of a file
+	Operation operation // the signing operation, defaults to "chain"
+	ConfigDir string    // directory of the configuration, defaults to the working directory
+}
+
+// ParseSignCommand parses the arguments of the sign command, i.e. the arguments after "sign".
+// Flags and positional arguments may be given in any order.
+func ParseSignCommand(args []string) (*SignCommand, error) {
+	var op, hashBase64 string
+	c := &SignCommand{}
+
+	fs := flag.NewFlagSet(SignCommandName, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&op, "op", string(chainHash), "")
+	fs.StringVar(&hashBase64, "hash", "", "")
+	fs.StringVar(&c.ConfigDir, "config-dir", "", "")
+
+	positional, err := parseCommandArgs(fs, args)
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, SignCommandUsage)
+	}
+
+	switch operation(op) {
+	case chainHash, anchorHash, disableHash, enableHash, deleteHash:
+		c.Operation = operation(op)
+	default:
+		return nil, fmt.Errorf("invalid operation: expected (\"%s\" | \"%s\" | \"%s\" | \"%s\" | \"%s\"), got \"%s\"",
+			chainHash, anchorHash, disableHash, enableHash, deleteHash, op)
+	}
+
+	c.Hash, err = decodeHashArg(hashBase64)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(positional) == 2 && c.Hash == nil:
+		c.File = positional[1]
+	case len(positional) == 1 && c.Hash != nil:
+	default:
+		return nil, fmt.Errorf("expected UUID and either file or hash\n%s", SignCommandUsage)
+	}
+
+	c.UUID, err = uuid.Parse(positional[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid UUID: \"%s\": %v", positional[0], err)
+	}
+
+	return c, nil
+}
+
+// Run signs the hash and sends the UPP to the ubirch backend. If no hash was given, the file is hashed with
+// the hash algorithm of the identity while it is read. The signing response is written to w as JSON.
+// Returns an error if the UPP could not be created or was not accepted by the backend.
+func (c *SignCommand) Run(ctx context.Context, s *Signer, w io.Writer) error {
+	exists, err := s.checkExists(c.UUID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("unknown UUID: %s", c.UUID)
+	}
+
+	alg, err := h.GetHashAlgorithm(s.HashAlgorithms[c.UUID])
+	if err != nil {
+		return err
+	}
+
+	hash := c.Hash
+	if hash == nil {
+		hash, err = hashFile(c.File, alg)
+		if err != nil {
+			return err
+		}
+	} else if len(hash) != alg.Size {
+		return fmt.Errorf("invalid %s hash size: expected %d bytes, got %d bytes", alg.Name, alg.Size, len(hash))
+	}
+
+	auth, err := s.getAuth(c.UUID)
+	if err != nil {
+		return err
+	}
+
+	msg := h.HTTPRequest{ID: c.UUID, Auth: auth, Hash: hash}
+
+	var resp h.HTTPResponse
+	if c.Operation == chainHash {
+		resp = s.chainWithLock(ctx, msg)
+	} else {
+		resp = s.Sign(ctx, msg, c.Operation)
+	}
+
+	if _, err = fmt.Fprintf(w, "%s\n", resp.Content); err != nil {
+		return err
+	}
+	if h.HttpFailed(resp.StatusCode) {
+		return fmt.Errorf("%s failed: (%d) %s", c.Operation, resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
+
+// parseCommandArgs parses the flags of a command and returns its positional arguments.
+// In contrast to flag.FlagSet.Parse, flags may follow positional arguments.
+func parseCommandArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// decodeHashArg decodes a base64 encoded hash argument, or returns nil if the argument is empty
+func decodeHashArg(hashBase64 string) (h.Hash, error) {
+	if hashBase64 == "" {
+		return nil, nil
+	}
+	hash, err := base64.StdEncoding.DecodeString(hashBase64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hash: %v", err)
+	}
+	return hash, nil
+}
+
+func hashFile(file string, alg h.HashAlgorithm) (h.Hash, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer f.Close()
+
+	return alg.SumReader(f)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSignCommand(t *testing.T) {
+	var mutex sync.Mutex
+	var received [][]byte
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upp, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		mutex.Lock()
+		received = append(received, upp)
+		mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	file := filepath.Join(t.TempDir(), "artifact.bin")
+	data := bytes.Repeat([]byte("artifact"), 100000)
+	err := ioutil.WriteFile(file, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileHash := sha256.Sum256(data)
+
+	testCases := []struct {
+		name         string
+		args         []string
+		expectedHash []byte
+	}{
+		{
+			name:         "file",
+			args:         []string{uid.String(), file},
+			expectedHash: fileHash[:],
+		},
+		{
+			name:         "file anchor",
+			args:         []string{"--op", "anchor", uid.String(), file},
+			expectedHash: fileHash[:],
+		},
+		{
+			name:         "hash",
+			args:         []string{uid.String(), "--hash", base64.StdEncoding.EncodeToString(testSHA256("hash"))},
+			expectedHash: testSHA256("hash"),
+		},
+	}
+
+	for i, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd, err := ParseSignCommand(c.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := &bytes.Buffer{}
+			err = cmd.Run(context.Background(), signer, out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var resp signingResponse
+			err = json.Unmarshal(out.Bytes(), &resp)
+			if err != nil {
+				t.Fatalf("output is no signing response: %v: %s", err, out)
+			}
+			if !bytes.Equal(resp.Hash, c.expectedHash) {
+				t.Errorf("unexpected hash: expected %x, got %x", c.expectedHash, resp.Hash)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if len(received) != i+1 || !bytes.Equal(received[i], resp.UPP) {
+				t.Errorf("UPP was not sent to the backend")
+			}
+		})
+	}
+}
+
+func TestSignCommand_BackendError(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	cmd, err := ParseSignCommand([]string{"--hash", base64.StdEncoding.EncodeToString(testSHA256("1")), uid.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	err = cmd.Run(context.Background(), signer, out)
+	if err == nil {
+		t.Error("rejected UPP did not fail the command")
+	}
+	if out.Len() == 0 {
+		t.Error("signing response was not written")
+	}
+}
+
+func TestSignCommand_Invalid(t *testing.T) {
+	signer, _ := newTestSigner(t, "")
+	uid := newTestIdentity(t, signer.Protocol)
+	hashBase64 := base64.StdEncoding.EncodeToString(testSHA256("1"))
+
+	for _, args := range [][]string{
+		{},
+		{uid.String()},
+		{uid.String(), "file", "--hash", hashBase64},
+		{uid.String(), "file", "other file"},
+		{"invalid", "file"},
+		{"--op", "invalid", uid.String(), "file"},
+		{"--hash", "invalid base64", uid.String()},
+		{"--unknown", uid.String(), "file"},
+	} {
+		if _, err := ParseSignCommand(args); err == nil {
+			t.Errorf("invalid arguments %q were accepted", args)
+		}
+	}
+
+	for _, args := range [][]string{
+		{uuid.NewString(), "--hash", hashBase64},
+		{uid.String(), "--hash", base64.StdEncoding.EncodeToString([]byte("too short"))},
+		{uid.String(), filepath.Join(t.TempDir(), "missing")},
+	} {
+		cmd, err := ParseSignCommand(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = cmd.Run(context.Background(), signer, ioutil.Discard); err == nil {
+			t.Errorf("command with arguments %q did not fail", args)
+		}
+	}
+}
@@ -15,16 +15,26 @@
 package handlers
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ubirch/ubirch-client-go/main/adapters/audit"
+	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
+	"github.com/ubirch/ubirch-client-go/main/adapters/deadletter"
+	"github.com/ubirch/ubirch-client-go/main/adapters/kafka"
+	"github.com/ubirch/ubirch-client-go/main/adapters/quota"
+	"github.com/ubirch/ubirch-client-go/main/adapters/recorder"
 	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-client-go/main/adapters/webhook"
 	"github.com/ubirch/ubirch-client-go/main/ent"
 	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
 
@@ -40,6 +50,7 @@ const (
 	disableHash operation = "disable"
 	enableHash  operation = "enable"
 	deleteHash  operation = "delete"
+	chainHash   operation = "chain"
 
 	lenRequestID = 16
 )
@@ -52,17 +63,38 @@ var hintLookup = map[operation]ubirch.Hint{
 }
 
 type signingResponse struct {
-	Error     string         `json:"error,omitempty"`
-	Hash      []byte         `json:"hash,omitempty"`
-	UPP       []byte         `json:"upp,omitempty"`
-	Response  h.HTTPResponse `json:"response,omitempty"`
-	RequestID string         `json:"requestID,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	Hash          []byte         `json:"hash,omitempty"`
+	UPP           []byte         `json:"upp,omitempty"`
+	PrevSignature []byte         `json:"prevSignature,omitempty"` // the signature the UPP is chained to, only for chained UPPs
+	Response      h.HTTPResponse `json:"response,omitempty"`
+	RequestID     string         `json:"requestID,omitempty"`
 }
 
 type Signer struct {
 	Protocol             *repository.ExtendedProtocol
 	AuthTokensBuffer     map[uuid.UUID]string
 	AuthTokenBufferMutex *sync.RWMutex
+	SlowBackendThreshold time.Duration // if > 0, requests are answered with 202 when the backend takes longer than this
+	BearerAuth           bool          // accept the auth token as bearer token in the Authorization header
+	BackendRetries       int           // number of retries of backend requests which failed with a transient error
+	BackendRetryBackoff  time.Duration // wait time before the first retry, doubled with each further retry
+	Recorder             *recorder.RequestRecorder
+	HashAlgorithms       map[uuid.UUID]string   // default hash algorithm per UUID, SHA-256 if not set
+	Canonicalization     h.Canonicalization     // canonicalization of JSON original data before hashing
+	RateLimiter          *RateLimiter           // limits the number of signing requests per UUID, disabled if nil
+	Quota                *quota.Counter         // limits the number of signing requests per UUID and day, disabled if nil
+	BodySecrets          map[uuid.UUID][]byte   // shared secrets for the HMAC over the request body (X-Signature header) per UUID
+	ReplayGuard          *ReplayGuard           // rejects replayed signing requests, disabled if nil
+	Idempotency          *IdempotencyCache      // responses of requests with an Idempotency-Key header, disabled if nil
+	Dedup                *IdempotencyCache      // responses of requests per hash and operation within the dedup window, disabled if nil
+	DeadLetters          *deadletter.Queue      // queue of UPPs which could not be delivered to the backend, disabled if nil
+	Offline              *OfflineMode           // queues UPPs right away while the backend is unreachable, disabled if nil
+	Audit                *audit.Log             // audit log of all signed UPPs, disabled if nil
+	Kafka                *kafka.Sink            // publishes the UPPs which were acknowledged by the backend to Kafka, disabled if nil
+	Webhook              *webhook.Sink          // delivers the UPPs which were acknowledged by the backend to a webhook, disabled if nil
+	additionalAuth       map[uuid.UUID][]string // auth tokens accepted in addition to the stored auth token, guarded by AuthTokenBufferMutex
+	chaining             chainingRequests       // chaining requests in flight per UUID
 }
 
 func (s *Signer) checkExists(uid uuid.UUID) (bool, error) {
@@ -96,121 +128,391 @@ func (s *Signer) getAuth(uid uuid.UUID) (auth string, err error) {
 	return auth, nil
 }
 
+// getAcceptedAuth returns the auth token of the identity, followed by the auth tokens
+// which are accepted in addition, e.g. the old auth token during a token rotation
+func (s *Signer) getAcceptedAuth(uid uuid.UUID) ([]string, error) {
+	auth, err := s.getAuth(uid)
+	if err != nil {
+		return nil, err
+	}
+	if auth == revokedAuthToken {
+		return nil, nil
+	}
+
+	s.AuthTokenBufferMutex.RLock()
+	defer s.AuthTokenBufferMutex.RUnlock()
+	return append([]string{auth}, s.additionalAuth[uid]...), nil
+}
+
+// SetAdditionalAuthTokens sets the auth tokens which are accepted for an identity in addition to
+// its stored auth token. An empty list removes the additional auth tokens of the identity.
+func (s *Signer) SetAdditionalAuthTokens(uid uuid.UUID, tokens []string) {
+	s.AuthTokenBufferMutex.Lock()
+	defer s.AuthTokenBufferMutex.Unlock()
+
+	if len(tokens) == 0 {
+		delete(s.additionalAuth, uid)
+		return
+	}
+	if s.additionalAuth == nil {
+		s.additionalAuth = map[uuid.UUID][]string{}
+	}
+	s.additionalAuth[uid] = tokens
+}
+
+// ForgetAuthToken removes the buffered auth token of an identity, e.g. after the identity was deleted
+func (s *Signer) ForgetAuthToken(uid uuid.UUID) {
+	s.AuthTokenBufferMutex.Lock()
+	delete(s.AuthTokensBuffer, uid)
+	s.AuthTokenBufferMutex.Unlock()
+}
+
+// getHashAlgorithm returns the hash algorithm selected by the request header
+// or the default hash algorithm of the identity
+func (s *Signer) getHashAlgorithm(r *http.Request, uid uuid.UUID) (h.HashAlgorithm, error) {
+	return h.RequestHashAlgorithm(r.Header, s.HashAlgorithms[uid])
+}
+
 // handle incoming messages, create, sign and send a chained ubirch protocol packet (UPP) to the ubirch backend
-func (s *Signer) chain(msg h.HTTPRequest, tx interface{}, identity *ent.Identity) h.HTTPResponse {
-	log.Infof("%s: anchor hash [chained]: %s", msg.ID, base64.StdEncoding.EncodeToString(msg.Hash[:]))
+func (s *Signer) chain(ctx context.Context, msg h.HTTPRequest, tx interface{}, identity *ent.Identity) h.HTTPResponse {
+	log.WithContext(ctx).Infof("%s: anchor hash [chained]: %s", msg.ID, base64.StdEncoding.EncodeToString(msg.Hash))
+	s.record(msg, chainHash)
 
 	timer := prometheus.NewTimer(prom.SignatureCreationDuration)
-	uppBytes, err := s.getChainedUPP(msg.ID, msg.Hash, identity.PrivateKey, identity.Signature)
+	uppBytes, err := s.getChainedUPP(ctx, msg.ID, msg.Hash, identity.PrivateKey, identity.Signature)
 	timer.ObserveDuration()
 	if err != nil {
-		log.Errorf("%s: could not create chained UPP: %v", msg.ID, err)
+		log.WithContext(ctx).Errorf("%s: could not create chained UPP: %v", msg.ID, err)
+		_ = s.Protocol.CloseTransaction(tx, repository.Rollback)
 		return errorResponse(http.StatusInternalServerError, "")
 	}
-	log.Debugf("%s: chained UPP: %x", msg.ID, uppBytes)
-
-	resp := s.sendUPP(msg, uppBytes)
+	log.WithContext(ctx).Debugf("%s: chained UPP: %x", msg.ID, uppBytes)
+	prom.SignedUPPsTotal.WithLabelValues(string(chainHash)).Inc()
+
+	persist := func(resp h.HTTPResponse) h.HTTPResponse {
+		resp = s.deadLetterIfUndelivered(msg, chainHash, uppBytes, resp)
+
+		// persist last signature only if UPP was successfully received by ubirch backend
+		// or was queued for re-submission
+		if h.HttpFailed(resp.StatusCode) {
+			err := s.Protocol.CloseTransaction(tx, repository.Rollback)
+			if err != nil {
+				log.WithContext(ctx).Debugf("%s: rollback failed: %v", msg.ID, err)
+			}
+			return resp
+		}
 
-	// persist last signature only if UPP was successfully received by ubirch backend
-	if h.HttpSuccess(resp.StatusCode) {
 		signature := uppBytes[len(uppBytes)-s.Protocol.SignatureLength():]
 
-		err = s.Protocol.SetSignature(tx, msg.ID, signature)
+		err := s.Protocol.PersistSignature(tx, msg.ID, identity.Signature, signature)
 		if err != nil {
 			// this usually happens, if the request context was cancelled because the client already left (timeout or cancel)
-			log.Errorf("%s: storing signature failed: %v", msg.ID, err)
-			log.Warnf("%s: request has been processed, but response could not be sent: (%d) %s",
+			log.WithContext(ctx).Errorf("%s: storing signature failed: %v", msg.ID, err)
+			log.WithContext(ctx).Warnf("%s: request has been processed, but response could not be sent: (%d) %s",
 				msg.ID, resp.StatusCode, string(resp.Content))
 			return errorResponse(http.StatusInternalServerError, "")
 		}
 
 		prom.SignatureCreationCounter.Inc()
+		return resp
+	}
+
+	finish := func(resp h.HTTPResponse) h.HTTPResponse {
+		resp = persist(resp)
+		s.auditUPP(ctx, msg, chainHash, uppBytes, resp)
+		s.publishUPP(ctx, msg, chainHash, uppBytes, resp)
+		return resp
+	}
+
+	if err := s.mustQueue(msg.ID); err != nil {
+		return finish(s.deadLetter(msg, chainHash, uppBytes, err.Error()))
 	}
+	return s.submit(ctx, msg, uppBytes, finish)
+}
 
-	return resp
+// sendChained chains the request by the worker of the identity, if chaining workers are set,
+// or locks the identity and chains the request right away
+func (s *Signer) sendChained(ctx context.Context, workers *ChainWorkers, msg h.HTTPRequest) h.HTTPResponse {
+	if workers != nil {
+		return workers.SendChainedUpp(ctx, msg)
+	}
+	return s.chainWithLock(ctx, msg)
 }
 
-func (s *Signer) Sign(msg h.HTTPRequest, op operation) h.HTTPResponse {
-	log.Infof("%s: %s hash: %s", msg.ID, op, base64.StdEncoding.EncodeToString(msg.Hash[:]))
+// chainWithLock locks the identity, creates a chained UPP and sends it to the ubirch backend
+func (s *Signer) chainWithLock(ctx context.Context, msg h.HTTPRequest) h.HTTPResponse {
+	done := s.startChaining(msg.ID)
+	defer done()
+
+	// if the submission may be completed in the background, the transaction
+	// must not be bound to the lifetime of the request
+	txCtx := ctx
+	if s.SlowBackendThreshold > 0 {
+		txCtx = context.Background()
+	}
+
+	tx, identity, err := s.Protocol.FetchIdentityWithLock(txCtx, msg.ID)
+	if err != nil {
+		log.WithContext(ctx).Errorf("%s: %v", msg.ID, err)
+		return errorResponse(http.StatusServiceUnavailable, "")
+	}
+
+	return s.chain(ctx, msg, tx, identity)
+}
+
+func (s *Signer) Sign(ctx context.Context, msg h.HTTPRequest, op operation) h.HTTPResponse {
+	log.WithContext(ctx).Infof("%s: %s hash: %s", msg.ID, op, base64.StdEncoding.EncodeToString(msg.Hash))
+	s.record(msg, op)
 
 	privateKeyPEM, err := s.Protocol.GetPrivateKey(msg.ID)
 	if err != nil {
-		log.Errorf("%s: could not fetch private Key for UUID: %v", msg.ID, err)
+		log.WithContext(ctx).Errorf("%s: could not fetch private Key for UUID: %v", msg.ID, err)
 		return errorResponse(http.StatusInternalServerError, "")
 	}
 
-	uppBytes, err := s.getSignedUPP(msg.ID, msg.Hash, privateKeyPEM, op)
+	uppBytes, err := s.getSignedUPP(ctx, msg, privateKeyPEM, op)
 	if err != nil {
-		log.Errorf("%s: could not create signed UPP: %v", msg.ID, err)
+		log.WithContext(ctx).Errorf("%s: could not create signed UPP: %v", msg.ID, err)
 		return errorResponse(http.StatusInternalServerError, "")
 	}
-	log.Debugf("%s: signed UPP: %x", msg.ID, uppBytes)
+	log.WithContext(ctx).Debugf("%s: signed UPP: %x", msg.ID, uppBytes)
+	prom.SignedUPPsTotal.WithLabelValues(string(op)).Inc()
+
+	finish := func(resp h.HTTPResponse) h.HTTPResponse {
+		s.auditUPP(ctx, msg, op, uppBytes, resp)
+		s.publishUPP(ctx, msg, op, uppBytes, resp)
+		return resp
+	}
+
+	if err := s.mustQueue(msg.ID); err != nil {
+		return finish(s.deadLetter(msg, op, uppBytes, err.Error()))
+	}
+	return s.submit(ctx, msg, uppBytes, func(resp h.HTTPResponse) h.HTTPResponse {
+		return finish(s.deadLetterIfUndelivered(msg, op, uppBytes, resp))
+	})
+}
+
+// submit sends the UPP to the ubirch backend and passes the backend response to the finish function.
+// If a slow backend threshold is set and the backend does not respond within the threshold,
+// a 202 response is returned right away and the submission is completed in the background.
+func (s *Signer) submit(ctx context.Context, msg h.HTTPRequest, upp []byte, finish func(h.HTTPResponse) h.HTTPResponse) h.HTTPResponse {
+	if s.SlowBackendThreshold <= 0 {
+		return finish(s.sendUPP(ctx, msg, upp))
+	}
+
+	done := make(chan h.HTTPResponse, 1)
+	go func() {
+		// the submission may outlive the request, so it must not be bound to the request context
+		done <- finish(s.sendUPP(context.Background(), msg, upp))
+	}()
+
+	select {
+	case resp := <-done:
+		return resp
+	case <-time.After(s.SlowBackendThreshold):
+		log.WithContext(ctx).Infof("%s: backend did not respond within %s, completing submission in background", msg.ID, s.SlowBackendThreshold)
+		go func() {
+			resp := <-done
+			if h.HttpFailed(resp.StatusCode) {
+				log.WithContext(ctx).Errorf("%s: background submission failed: (%d) %s", msg.ID, resp.StatusCode, string(resp.Content))
+			} else {
+				log.WithContext(ctx).Infof("%s: background submission completed: (%d)", msg.ID, resp.StatusCode)
+			}
+		}()
+		return getSigningResponse(http.StatusAccepted, msg, upp, h.HTTPResponse{}, "", "")
+	}
+}
+
+// record writes the request inputs to the request log, if request recording is enabled
+func (s *Signer) record(msg h.HTTPRequest, op operation) {
+	if s.Recorder == nil {
+		return
+	}
+	err := s.Recorder.Record(msg.ID, string(op), msg.Hash)
+	if err != nil {
+		log.Errorf("%s: recording request failed: %v", msg.ID, err)
+	}
+}
+
+// publishUPP publishes the signed UPP to Kafka and delivers it to the webhook, if enabled and the UPP was
+// acknowledged by the backend. UPPs which were queued for re-submission are not published.
+func (s *Signer) publishUPP(ctx context.Context, msg h.HTTPRequest, op operation, upp []byte, resp h.HTTPResponse) {
+	if h.HttpFailed(resp.StatusCode) || resp.Header.Get(DeadLetterIDHeader) != "" {
+		return
+	}
+
+	if s.Kafka != nil {
+		ok := s.Kafka.Publish(kafka.Message{
+			UUID:          msg.ID,
+			Operation:     string(op),
+			Hash:          msg.Hash,
+			UPP:           upp,
+			RequestID:     h.GetRequestID(ctx),
+			BackendStatus: resp.StatusCode,
+		})
+		if !ok {
+			log.WithContext(ctx).Errorf("%s: kafka sink buffer full, UPP not published", msg.ID)
+		}
+	}
+
+	if s.Webhook != nil {
+		ok := s.Webhook.Publish(webhook.Message{
+			UUID:          msg.ID,
+			Operation:     string(op),
+			Hash:          msg.Hash,
+			UPP:           upp,
+			RequestID:     h.GetRequestID(ctx),
+			BackendStatus: resp.StatusCode,
+		})
+		if !ok {
+			log.WithContext(ctx).Errorf("%s: webhook buffer full, UPP not delivered", msg.ID)
+		}
+	}
+}
 
-	return s.sendUPP(msg, uppBytes)
+// auditUPP appends the signed UPP and the status of the signing response to the audit log, if enabled
+func (s *Signer) auditUPP(ctx context.Context, msg h.HTTPRequest, op operation, upp []byte, resp h.HTTPResponse) {
+	if s.Audit == nil {
+		return
+	}
+	ok := s.Audit.Append(audit.Entry{
+		UUID:          msg.ID,
+		Operation:     string(op),
+		Hash:          msg.Hash,
+		UPP:           upp,
+		RequestID:     h.GetRequestID(ctx),
+		BackendStatus: resp.StatusCode,
+	})
+	if !ok {
+		log.WithContext(ctx).Errorf("%s: audit log buffer full, entry dropped", msg.ID)
+	}
 }
 
-func (s *Signer) getChainedUPP(id uuid.UUID, hash [32]byte, privateKeyPEM, prevSignature []byte) ([]byte, error) {
-	return s.Protocol.Sign(
+func (s *Signer) getChainedUPP(ctx context.Context, id uuid.UUID, hash h.Hash, privateKeyPEM, prevSignature []byte) ([]byte, error) {
+	return s.Protocol.SignContext(
+		ctx,
 		privateKeyPEM,
 		&ubirch.ChainedUPP{
 			Version:       ubirch.Chained,
 			Uuid:          id,
 			PrevSignature: prevSignature,
 			Hint:          ubirch.Binary,
-			Payload:       hash[:],
+			Payload:       hash,
 		})
 }
 
-func (s *Signer) getSignedUPP(id uuid.UUID, hash [32]byte, privateKeyPEM []byte, op operation) ([]byte, error) {
+// getSignedUPP creates a signed UPP with the hint of the operation, or the custom hint of the request, if set
+func (s *Signer) getSignedUPP(ctx context.Context, msg h.HTTPRequest, privateKeyPEM []byte, op operation) ([]byte, error) {
 	hint, found := hintLookup[op]
 	if !found {
-		return nil, fmt.Errorf("%s: invalid operation: \"%s\"", id, op)
+		return nil, fmt.Errorf("%s: invalid operation: \"%s\"", msg.ID, op)
+	}
+	if msg.Hint != nil {
+		hint = ubirch.Hint(*msg.Hint)
 	}
 
-	return s.Protocol.Sign(
+	return s.Protocol.SignContext(
+		ctx,
 		privateKeyPEM,
 		&ubirch.SignedUPP{
 			Version: ubirch.Signed,
-			Uuid:    id,
+			Uuid:    msg.ID,
 			Hint:    hint,
-			Payload: hash[:],
+			Payload: msg.Hash,
 		})
 }
 
-func (s *Signer) sendUPP(msg h.HTTPRequest, upp []byte) h.HTTPResponse {
+func (s *Signer) sendUPP(ctx context.Context, msg h.HTTPRequest, upp []byte) h.HTTPResponse {
 	// send UPP to ubirch backend
-	timer := prometheus.NewTimer(prom.UpstreamResponseDuration)
-	backendResp, err := s.Protocol.SendToAuthService(msg.ID, msg.Auth, upp)
-	timer.ObserveDuration()
+	backendResp, err := s.sendWithRetry(ctx, msg, upp)
 	if err != nil {
-		if os.IsTimeout(err) {
-			log.Errorf("%s: request to UBIRCH Authentication Service timed out after %s: %v", msg.ID, h.BackendRequestTimeout.String(), err)
+		if isUnreachable(ctx, err) {
+			s.Offline.setOffline(err)
+		}
+		if err == clients.ErrCircuitOpen || err == clients.ErrBackendBusy {
+			log.WithContext(ctx).Errorf("%s: request to UBIRCH Authentication Service not sent: %v", msg.ID, err)
+			return errorResponse(http.StatusServiceUnavailable, "")
+		} else if os.IsTimeout(err) {
+			log.WithContext(ctx).Errorf("%s: request to UBIRCH Authentication Service timed out after %s: %v", msg.ID, s.Protocol.RequestTimeout().String(), err)
 			return errorResponse(http.StatusGatewayTimeout, "")
 		} else {
-			log.Errorf("%s: sending request to UBIRCH Authentication Service failed: %v", msg.ID, err)
+			log.WithContext(ctx).Errorf("%s: sending request to UBIRCH Authentication Service failed: %v", msg.ID, err)
 			return errorResponse(http.StatusInternalServerError, "")
 		}
 	}
-	log.Debugf("%s: backend response: (%d) %x", msg.ID, backendResp.StatusCode, backendResp.Content)
+	log.WithContext(ctx).Debugf("%s: backend response: (%d) %x", msg.ID, backendResp.StatusCode, backendResp.Content)
 
 	// decode the backend response UPP and get request ID
 	var requestID string
 	responseUPPStruct, err := ubirch.Decode(backendResp.Content)
 	if err != nil {
-		log.Warnf("decoding backend response failed: %v, backend response: (%d) %q",
+		log.WithContext(ctx).Warnf("decoding backend response failed: %v, backend response: (%d) %q",
 			err, backendResp.StatusCode, backendResp.Content)
 	} else {
 		requestID, err = getRequestID(responseUPPStruct)
 		if err != nil {
-			log.Warnf("could not get request ID from backend response: %v", err)
+			log.WithContext(ctx).Warnf("could not get request ID from backend response: %v", err)
 		} else {
-			log.Infof("%s: request ID: %s", msg.ID, requestID)
+			log.WithContext(ctx).Infof("%s: request ID: %s", msg.ID, requestID)
 		}
 	}
 
 	return getSigningResponse(backendResp.StatusCode, msg, upp, backendResp, requestID, "")
 }
 
+// sendWithRetry sends the UPP to the ubirch backend and retries with exponential backoff and jitter
+// if the request failed with a transient error, until either the maximum number of retries is reached
+// or the deadline of the context would be exceeded. Retries always resend the exact same UPP, so
+// retrying a chained UPP can never create a second chain entry.
+func (s *Signer) sendWithRetry(ctx context.Context, msg h.HTTPRequest, upp []byte) (h.HTTPResponse, error) {
+	backoff := s.BackendRetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		resp, err := s.Protocol.SendToAuthService(ctx, msg.ID, msg.Auth, upp)
+		if attempt > s.BackendRetries || !isTransientFailure(resp, err) {
+			return resp, err
+		}
+
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			log.WithContext(ctx).Debugf("%s: no retry of backend request: request deadline would be exceeded", msg.ID)
+			return resp, err
+		}
+
+		if err != nil {
+			log.WithContext(ctx).Debugf("%s: backend request attempt %d failed: %v, retrying in %s", msg.ID, attempt, err, wait)
+		} else {
+			log.WithContext(ctx).Debugf("%s: backend request attempt %d failed: (%d), retrying in %s", msg.ID, attempt, resp.StatusCode, wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+	}
+}
+
+// isTransientFailure returns true if a backend request failed with a transport error or a
+// status code which indicates that the request may succeed if it is repeated.
+// Requests which were not sent because the circuit breaker is open or the backend is busy are not retried.
+func isTransientFailure(resp h.HTTPResponse, err error) bool {
+	if err == clients.ErrCircuitOpen || err == clients.ErrBackendBusy {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
 func getRequestID(respUPP ubirch.UPP) (string, error) {
 	respPayload := respUPP.GetPayload()
 	if len(respPayload) < lenRequestID {
@@ -234,13 +536,27 @@ func errorResponse(code int, message string) h.HTTPResponse {
 	}
 }
 
+// getPrevSignature returns the previous signature of a chained UPP, so that clients can verify
+// the continuity of the chain, or nil if the UPP is not chained
+func getPrevSignature(upp []byte) []byte {
+	if len(upp) == 0 {
+		return nil
+	}
+	uppStruct, err := ubirch.Decode(upp)
+	if err != nil || uppStruct.GetVersion() != ubirch.Chained {
+		return nil
+	}
+	return uppStruct.GetPrevSignature()
+}
+
 func getSigningResponse(respCode int, msg h.HTTPRequest, upp []byte, backendResp h.HTTPResponse, requestID string, errMsg string) h.HTTPResponse {
 	signingResp, err := json.Marshal(signingResponse{
-		Hash:      msg.Hash[:],
-		UPP:       upp,
-		Response:  backendResp,
-		RequestID: requestID,
-		Error:     errMsg,
+		Hash:          msg.Hash,
+		UPP:           upp,
+		PrevSignature: getPrevSignature(upp),
+		Response:      backendResp,
+		RequestID:     requestID,
+		Error:         errMsg,
 	})
 	if err != nil {
 		log.Warnf("error serializing signing response: %v", err)
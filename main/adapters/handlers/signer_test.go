@@ -0,0 +1,704 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
+	"github.com/ubirch/ubirch-client-go/main/adapters/kafka"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-client-go/main/adapters/webhook"
+	"github.com/ubirch/ubirch-client-go/main/ent"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+	prom "github.com/ubirch/ubirch-client-go/main/prometheus"
+)
+
+const testAuth = "test-auth"
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSigner_SlowBackend(t *testing.T) {
+	var tests = []struct {
+		name         string
+		backendDelay time.Duration
+		expectedCode int
+	}{
+		{
+			name:         "fast backend",
+			backendDelay: 0,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "slow backend",
+			backendDelay: 200 * time.Millisecond,
+			expectedCode: http.StatusAccepted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(test.backendDelay)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer backend.Close()
+
+			signer, ctxManager := newTestSigner(t, backend.URL)
+			signer.SlowBackendThreshold = 50 * time.Millisecond
+			uid := newTestIdentity(t, signer.Protocol)
+
+			tx, identity, err := signer.Protocol.FetchIdentityWithLock(context.Background(), uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := signer.chain(context.Background(), h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("test")}, tx, identity)
+			if resp.StatusCode != test.expectedCode {
+				t.Errorf("unexpected response code: expected %d, got %d", test.expectedCode, resp.StatusCode)
+			}
+
+			// the chain must be continued once the backend responded, also if completed in background
+			deadline := time.Now().Add(time.Second)
+			for ctxManager.signature(uid) == nil && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if ctxManager.signature(uid) == nil {
+				t.Error("signature was not stored after successful submission")
+			}
+		})
+	}
+}
+
+func TestSigner_Metrics(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer backend.Close()
+
+	router := chi.NewMux()
+	prom.InitPromMetrics(router)
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	metrics := []string{
+		`signed_upps_total{operation="disable"}`,
+		`backend_error_responses_total{status="502"}`,
+		`upstream_response_duration_count`,
+	}
+	before := scrapeMetrics(t, router, metrics)
+
+	resp := signer.Sign(context.Background(), h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("test")}, disableHash)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusBadGateway, resp.StatusCode)
+	}
+
+	after := scrapeMetrics(t, router, metrics)
+
+	for _, metric := range metrics {
+		if after[metric] != before[metric]+1 {
+			t.Errorf("%s was not incremented: before %v, after %v", metric, before[metric], after[metric])
+		}
+	}
+}
+
+func TestSigner_BackendRetries(t *testing.T) {
+	var tests = []struct {
+		name             string
+		failures         int
+		retries          int
+		failureThreshold int
+		expectedCode     int
+		expectedRequests int
+	}{
+		{
+			name:             "no retries",
+			failures:         1,
+			retries:          0,
+			expectedCode:     http.StatusServiceUnavailable,
+			expectedRequests: 1,
+		},
+		{
+			name:             "success after retry",
+			failures:         2,
+			retries:          3,
+			expectedCode:     http.StatusOK,
+			expectedRequests: 3,
+		},
+		{
+			name:             "retries exhausted",
+			failures:         5,
+			retries:          2,
+			expectedCode:     http.StatusServiceUnavailable,
+			expectedRequests: 3,
+		},
+		{
+			name:             "circuit breaker opened",
+			failures:         5,
+			retries:          4,
+			failureThreshold: 2,
+			expectedCode:     http.StatusServiceUnavailable,
+			expectedRequests: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests [][]byte
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				requests = append(requests, body)
+				if len(requests) <= test.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer backend.Close()
+
+			signer, _ := newTestSigner(t, backend.URL)
+			signer.BackendRetries = test.retries
+			signer.BackendRetryBackoff = time.Millisecond
+			if test.failureThreshold > 0 {
+				signer.Protocol.AuthBreaker = clients.NewCircuitBreaker("test", test.failureThreshold, time.Minute)
+			}
+			uid := newTestIdentity(t, signer.Protocol)
+
+			tx, identity, err := signer.Protocol.FetchIdentityWithLock(context.Background(), uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := signer.chain(context.Background(), h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("test")}, tx, identity)
+			if resp.StatusCode != test.expectedCode {
+				t.Errorf("unexpected response code: expected %d, got %d", test.expectedCode, resp.StatusCode)
+			}
+			if len(requests) != test.expectedRequests {
+				t.Fatalf("unexpected number of backend requests: expected %d, got %d", test.expectedRequests, len(requests))
+			}
+
+			// retries must resend the identical chained UPP
+			for i := range requests {
+				if !bytes.Equal(requests[i], requests[0]) {
+					t.Errorf("request %d differs from the first request", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestSigner_ChainWithFileManager(t *testing.T) {
+	var tests = []struct {
+		name              string
+		backendCode       int
+		expectedPersisted bool
+	}{
+		{
+			name:              "backend accepted UPP",
+			backendCode:       http.StatusOK,
+			expectedPersisted: true,
+		},
+		{
+			name:              "backend rejected UPP",
+			backendCode:       http.StatusBadRequest,
+			expectedPersisted: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.backendCode)
+			}))
+			defer backend.Close()
+
+			fileManager, err := repository.NewFileManager(t.TempDir(), []byte("0123456789abcdef"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fileManager.Close()
+
+			p, err := repository.NewExtendedProtocol(fileManager, testSecret, &clients.Client{AuthServiceURL: backend.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer := &Signer{
+				Protocol:             p,
+				AuthTokensBuffer:     map[uuid.UUID]string{},
+				AuthTokenBufferMutex: &sync.RWMutex{},
+			}
+			uid := newTestIdentity(t, p)
+
+			tx, identity, err := p.FetchIdentityWithLock(context.Background(), uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := signer.chain(context.Background(), h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("test")}, tx, identity)
+			if resp.StatusCode != test.backendCode {
+				t.Errorf("unexpected response code: expected %d, got %d", test.backendCode, resp.StatusCode)
+			}
+
+			tx, stored, err := p.FetchIdentityWithLock(context.Background(), uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = p.CloseTransaction(tx, repository.Rollback)
+
+			persisted := !bytes.Equal(stored.Signature, identity.Signature)
+			if persisted != test.expectedPersisted {
+				t.Errorf("unexpected state of stored signature: expected persisted=%v, got %x", test.expectedPersisted, stored.Signature)
+			}
+		})
+	}
+}
+
+func TestSigner_PrevSignature(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	var prevUPP []byte
+	for i := 0; i < 2; i++ {
+		tx, identity, err := signer.Protocol.FetchIdentityWithLock(context.Background(), uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := signer.chain(context.Background(), h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256(strconv.Itoa(i))}, tx, identity)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected response code: expected %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+
+		var signingResp signingResponse
+		err = json.Unmarshal(resp.Content, &signingResp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(signingResp.PrevSignature, identity.Signature) {
+			t.Errorf("unexpected previous signature: expected %x, got %x", identity.Signature, signingResp.PrevSignature)
+		}
+		if prevUPP != nil && !bytes.Equal(signingResp.PrevSignature, prevUPP[len(prevUPP)-signer.Protocol.SignatureLength():]) {
+			t.Error("previous signature is not the signature of the previous UPP")
+		}
+		prevUPP = signingResp.UPP
+	}
+
+	// signed UPPs are not chained
+	resp := signer.Sign(context.Background(), h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("signed")}, anchorHash)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response code: expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if bytes.Contains(resp.Content, []byte(`"prevSignature"`)) {
+		t.Errorf("signing response of signed UPP contains previous signature: %s", resp.Content)
+	}
+}
+
+func TestSigner_BackendRetriesDeadline(t *testing.T) {
+	var requests int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	signer.BackendRetries = 10
+	signer.BackendRetryBackoff = time.Second
+	uid := newTestIdentity(t, signer.Protocol)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	resp := signer.Sign(ctx, h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("test")}, disableHash)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusBadGateway, resp.StatusCode)
+	}
+	if requests != 1 {
+		t.Errorf("retry exceeding the request deadline was not skipped: %d backend requests", requests)
+	}
+}
+
+func testSHA256(data string) h.Hash {
+	hash := sha256.Sum256([]byte(data))
+	return hash[:]
+}
+
+// scrapeMetrics requests the metrics endpoint and returns the values of the given metrics
+func scrapeMetrics(t *testing.T, router http.Handler, metrics []string) map[string]float64 {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	values := map[string]float64{}
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		for _, metric := range metrics {
+			if strings.HasPrefix(line, metric+" ") {
+				v, err := strconv.ParseFloat(strings.TrimPrefix(line, metric+" "), 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				values[metric] = v
+			}
+		}
+	}
+	return values
+}
+
+type mockKafkaProducer struct {
+	mutex   sync.Mutex
+	records []kafka.Record
+}
+
+func (m *mockKafkaProducer) Produce(_ context.Context, _ string, records []kafka.Record) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.records = append(m.records, records...)
+	return nil
+}
+
+func (m *mockKafkaProducer) Close() error {
+	return nil
+}
+
+func TestSigner_Kafka(t *testing.T) {
+	backendCode := http.StatusOK
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(backendCode)
+	}))
+	defer backend.Close()
+
+	producer := &mockKafkaProducer{}
+	signer, _ := newTestSigner(t, backend.URL)
+	signer.Kafka = kafka.NewSink(producer, "upps", 0)
+	uid := newTestIdentity(t, signer.Protocol)
+
+	ctx := h.WithRequestID(context.Background(), "test-request")
+
+	var upps [][]byte
+	sign := func(resp h.HTTPResponse) {
+		if resp.StatusCode != backendCode {
+			t.Fatalf("unexpected response code: expected %d, got %d", backendCode, resp.StatusCode)
+		}
+		var signingResp signingResponse
+		if err := json.Unmarshal(resp.Content, &signingResp); err != nil {
+			t.Fatal(err)
+		}
+		upps = append(upps, signingResp.UPP)
+	}
+	sign(signer.chainWithLock(ctx, h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("1")}))
+	sign(signer.Sign(ctx, h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("2")}, anchorHash))
+
+	// UPPs which were not acknowledged by the backend are not published
+	backendCode = http.StatusBadRequest
+	sign(signer.chainWithLock(ctx, h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("3")}))
+
+	if err := signer.Kafka.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(producer.records) != 2 {
+		t.Fatalf("unexpected number of messages: expected 2, got %d", len(producer.records))
+	}
+	for i, op := range []operation{chainHash, anchorHash} {
+		var msg kafka.Message
+		if err := json.Unmarshal(producer.records[i].Value, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.UUID != uid || msg.Operation != string(op) || msg.RequestID != "test-request" || msg.BackendStatus != http.StatusOK {
+			t.Errorf("unexpected message: %+v", msg)
+		}
+		if !bytes.Equal(msg.UPP, upps[i]) || !bytes.Equal(msg.Hash, testSHA256(strconv.Itoa(i+1))) {
+			t.Errorf("unexpected UPP or hash in message: %+v", msg)
+		}
+	}
+}
+
+func TestSigner_Webhook(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	var mutex sync.Mutex
+	var requests int
+	var messages []webhook.Message
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		// the first delivery fails and is retried
+		if requests++; requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var msg webhook.Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Error(err)
+		}
+		messages = append(messages, msg)
+	}))
+	defer hook.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	var err error
+	signer.Webhook, err = webhook.NewSink(webhook.Params{URL: hook.URL, Retries: 1, RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := newTestIdentity(t, signer.Protocol)
+
+	ctx := h.WithRequestID(context.Background(), "test-request")
+	resp := signer.chainWithLock(ctx, h.HTTPRequest{ID: uid, Auth: testAuth, Hash: testSHA256("1")})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response code: %d", resp.StatusCode)
+	}
+	var signingResp signingResponse
+	if err = json.Unmarshal(resp.Content, &signingResp); err != nil {
+		t.Fatal(err)
+	}
+
+	signer.Webhook.Close(time.Second)
+
+	if requests != 2 || len(messages) != 1 {
+		t.Fatalf("unexpected number of webhook requests: %d, messages: %d", requests, len(messages))
+	}
+	msg := messages[0]
+	if msg.UUID != uid || msg.Operation != string(chainHash) || msg.RequestID != "test-request" || !bytes.Equal(msg.UPP, signingResp.UPP) {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+}
+
+func newTestSigner(t *testing.T, backendURL string) (*Signer, *mockCtxManager) {
+	ctxManager := newMockCtxManager()
+
+	p, err := repository.NewExtendedProtocol(ctxManager, testSecret, &clients.Client{AuthServiceURL: backendURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Signer{
+		Protocol:             p,
+		AuthTokensBuffer:     map[uuid.UUID]string{},
+		AuthTokenBufferMutex: &sync.RWMutex{},
+	}, ctxManager
+}
+
+func newTestIdentity(t *testing.T, p *repository.ExtendedProtocol) uuid.UUID {
+	uid := uuid.New()
+
+	privKeyPEM, err := p.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyPEM, err := p.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := p.StartTransaction(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.StoreNewIdentity(tx, &ent.Identity{
+		Uid:        uid.String(),
+		PrivateKey: privKeyPEM,
+		PublicKey:  pubKeyPEM,
+		Signature:  make([]byte, p.SignatureLength()),
+		AuthToken:  testAuth,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.CloseTransaction(tx, repository.Commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return uid
+}
+
+// mockCtxManager is an in-memory implementation of the ContextManager interface for testing.
+// It keeps track of the last signature that was set for an identity.
+type mockCtxManager struct {
+	identities map[uuid.UUID]*ent.Identity
+	signatures map[uuid.UUID][]byte
+	mutex      *sync.RWMutex
+}
+
+var _ repository.ContextManager = (*mockCtxManager)(nil)
+
+func newMockCtxManager() *mockCtxManager {
+	return &mockCtxManager{
+		identities: map[uuid.UUID]*ent.Identity{},
+		signatures: map[uuid.UUID][]byte{},
+		mutex:      &sync.RWMutex{},
+	}
+}
+
+func (m *mockCtxManager) signature(uid uuid.UUID) []byte {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.signatures[uid]
+}
+
+func (m *mockCtxManager) StartTransaction(context.Context) (interface{}, error) {
+	return m, nil
+}
+
+func (m *mockCtxManager) StartTransactionWithLock(ctx context.Context, _ uuid.UUID) (interface{}, error) {
+	return m.StartTransaction(ctx)
+}
+
+func (m *mockCtxManager) CloseTransaction(interface{}, bool) error {
+	return nil
+}
+
+func (m *mockCtxManager) Exists(uid uuid.UUID) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, found := m.identities[uid]
+	return found, nil
+}
+
+func (m *mockCtxManager) GetUIDs() ([]uuid.UUID, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var uids []uuid.UUID
+	for uid := range m.identities {
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+func (m *mockCtxManager) GetIdentityInfos(limit, offset int) ([]ent.IdentityInfo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	infos := []ent.IdentityInfo{}
+	for _, i := range m.identities {
+		infos = append(infos, ent.IdentityInfo{
+			Uid:          i.Uid,
+			HasPublicKey: len(i.PublicKey) > 0,
+			HasSignature: !bytes.Equal(i.Signature, make([]byte, len(i.Signature))),
+		})
+	}
+	sort.Slice(infos, func(a, b int) bool { return infos[a].Uid < infos[b].Uid })
+
+	if offset > len(infos) {
+		offset = len(infos)
+	}
+	infos = infos[offset:]
+	if limit < len(infos) {
+		infos = infos[:limit]
+	}
+	return infos, nil
+}
+
+func (m *mockCtxManager) StoreNewIdentity(_ interface{}, identity *ent.Identity) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	uid := uuid.MustParse(identity.Uid)
+	if _, found := m.identities[uid]; found {
+		return repository.ErrExists
+	}
+	i := *identity
+	m.identities[uid] = &i
+	return nil
+}
+
+func (m *mockCtxManager) FetchIdentity(_ interface{}, uid uuid.UUID) (*ent.Identity, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	i, found := m.identities[uid]
+	if !found {
+		return nil, fmt.Errorf("%s: identity not found", uid)
+	}
+	id := *i
+	return &id, nil
+}
+
+func (m *mockCtxManager) SetSignature(_ interface{}, uid uuid.UUID, signature []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i, found := m.identities[uid]
+	if !found {
+		return fmt.Errorf("%s: identity not found", uid)
+	}
+	i.Signature = signature
+	m.signatures[uid] = signature
+	return nil
+}
+
+func (m *mockCtxManager) SetAuthToken(_ interface{}, uid uuid.UUID, authToken string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i, found := m.identities[uid]
+	if !found {
+		return fmt.Errorf("%s: identity not found", uid)
+	}
+	i.AuthToken = authToken
+	return nil
+}
+
+func (m *mockCtxManager) DeleteIdentity(_ interface{}, uid uuid.UUID) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, found := m.identities[uid]; !found {
+		return repository.ErrNotExist
+	}
+	delete(m.identities, uid)
+	delete(m.signatures, uid)
+	return nil
+}
+
+func (m *mockCtxManager) GetPrivateKey(uid uuid.UUID) ([]byte, error) {
+	i, err := m.FetchIdentity(nil, uid)
+	if err != nil {
+		return nil, err
+	}
+	return i.PrivateKey, nil
+}
+
+func (m *mockCtxManager) GetPublicKey(uid uuid.UUID) ([]byte, error) {
+	i, err := m.FetchIdentity(nil, uid)
+	if err != nil {
+		return nil, err
+	}
+	return i.PublicKey, nil
+}
+
+func (m *mockCtxManager) GetAuthToken(uid uuid.UUID) (string, error) {
+	i, err := m.FetchIdentity(nil, uid)
+	if err != nil {
+		return "", err
+	}
+	return i.AuthToken, nil
+}
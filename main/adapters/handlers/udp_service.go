@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/udp"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+// packet types of UDP packets
+const (
+	udpChainHash byte = 0x01 // the payload is a SHA256 hash for chained anchoring
+	udpRelayUPP  byte = 0x02 // the payload is a complete UPP, which is verified and forwarded to the backend
+)
+
+const (
+	udpUUIDLen      = 16
+	udpHeaderLen    = 1 + udpUUIDLen + 1 // packet type, UUID and length of the auth token
+	udpMaxAuthLen   = 255
+	udpHashLen      = h.HashLen
+	udpMinPacketLen = udpHeaderLen + 1 + 1
+)
+
+// UDPChainingService handles UDP packets with the following layout:
+//
+//	offset  size  field
+//	0       1     packet type (0x01: hash for chained anchoring, 0x02: UPP to relay)
+//	1       16    UUID
+//	17      1     length n of the auth token
+//	18      n     auth token
+//	18+n          payload: 32 byte SHA256 hash (0x01) or complete UPP (0x02)
+//
+// and replies with the same response as the HTTP chaining endpoint. UPPs to relay are verified and
+// forwarded to the backend as they are, if relaying is enabled.
+type UDPChainingService struct {
+	*Signer
+	Workers   *ChainWorkers // if set, requests are chained by one worker per UUID
+	RelayUPPs bool          // accept complete UPPs, which are verified and forwarded to the backend
+}
+
+// Ensure UDPChainingService implements the PacketHandler interface
+var _ udp.PacketHandler = (*UDPChainingService)(nil)
+
+func (s *UDPChainingService) HandlePacket(packet []byte) ([]byte, error) {
+	packetType, msg, payload, err := parseUDPPacket(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	if packetType == udpRelayUPP && !s.RelayUPPs {
+		return nil, fmt.Errorf("%s: relaying UPPs is disabled", msg.ID)
+	}
+
+	exists, err := s.checkExists(msg.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", msg.ID, err)
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("%s: unknown UUID", msg.ID)
+	}
+
+	idAuth, err := s.getAcceptedAuth(msg.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", msg.ID, err)
+	}
+
+	if !matchAuth(idAuth, msg.Auth) {
+		return nil, fmt.Errorf("%s: invalid auth token", msg.ID)
+	}
+
+	if err = s.checkHTTPOnly(msg.ID); err != nil {
+		return nil, fmt.Errorf("%s: %v", msg.ID, err)
+	}
+
+	if limitErr := s.checkLimits(msg.ID, 1); limitErr != nil {
+		return limitResponse(msg.ID, limitErr).Content, nil
+	}
+
+	var resp h.HTTPResponse
+	if packetType == udpRelayUPP {
+		resp = s.Relay(context.Background(), msg, payload)
+	} else {
+		resp = s.sendChained(context.Background(), s.Workers, msg)
+	}
+	return resp.Content, nil
+}
+
+// parseUDPPacket parses a UDP packet with the layout described at UDPChainingService and returns
+// the packet type, the request and the payload. The hash of the request is set for hashes only.
+func parseUDPPacket(packet []byte) (byte, h.HTTPRequest, []byte, error) {
+	var msg h.HTTPRequest
+
+	if len(packet) < udpMinPacketLen {
+		return 0, msg, nil, fmt.Errorf("malformed packet: expected at least %d bytes, got %d bytes", udpMinPacketLen, len(packet))
+	}
+
+	packetType := packet[0]
+	if packetType != udpChainHash && packetType != udpRelayUPP {
+		return 0, msg, nil, fmt.Errorf("malformed packet: unknown packet type: 0x%02x", packetType)
+	}
+
+	id, err := uuid.FromBytes(packet[1 : 1+udpUUIDLen])
+	if err != nil {
+		return 0, msg, nil, fmt.Errorf("malformed packet: %v", err)
+	}
+
+	authLen := int(packet[udpHeaderLen-1])
+	if len(packet) <= udpHeaderLen+authLen {
+		return 0, msg, nil, fmt.Errorf("malformed packet: no payload after auth token of %d bytes", authLen)
+	}
+	payload := packet[udpHeaderLen+authLen:]
+
+	if packetType == udpChainHash && len(payload) != udpHashLen {
+		return 0, msg, nil, fmt.Errorf("malformed packet: expected %d bytes for an auth token of %d bytes, got %d bytes",
+			udpHeaderLen+authLen+udpHashLen, authLen, len(packet))
+	}
+
+	msg.ID = id
+	msg.Auth = string(packet[udpHeaderLen : udpHeaderLen+authLen])
+	if packetType == udpChainHash {
+		msg.Hash = append(h.Hash{}, payload...)
+	}
+
+	return packetType, msg, payload, nil
+}
+
+// newUDPPacket returns a UDP packet with the layout described at UDPChainingService
+func newUDPPacket(packetType byte, uid uuid.UUID, auth string, payload []byte) ([]byte, error) {
+	if len(auth) > udpMaxAuthLen {
+		return nil, fmt.Errorf("auth token too long: maximum %d bytes, got %d bytes", udpMaxAuthLen, len(auth))
+	}
+
+	packet := make([]byte, 0, udpHeaderLen+len(auth)+len(payload))
+	packet = append(packet, packetType)
+	packet = append(packet, uid[:]...)
+	packet = append(packet, byte(len(auth)))
+	packet = append(packet, auth...)
+	return append(packet, payload...), nil
+}
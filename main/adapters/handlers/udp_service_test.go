@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUDPChainingService_HandlePacket(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, ctxManager := newTestSigner(t, backend.URL)
+	uid := newTestIdentity(t, signer.Protocol)
+	service := &UDPChainingService{Signer: signer}
+
+	hash := sha256.Sum256([]byte("test"))
+	packet, err := newUDPPacket(udpChainHash, uid, testAuth, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := service.HandlePacket(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp signingResponse
+	err = json.Unmarshal(reply, &resp)
+	if err != nil {
+		t.Fatalf("unable to decode reply: %v", err)
+	}
+	if string(resp.Hash) != string(hash[:]) {
+		t.Errorf("unexpected hash in reply: %x", resp.Hash)
+	}
+	if ctxManager.signature(uid) == nil {
+		t.Error("signature was not stored")
+	}
+
+	wrongAuth, _ := newUDPPacket(udpChainHash, uid, "wrong", hash[:])
+	unknownType, _ := newUDPPacket(0x7f, uid, testAuth, hash[:])
+	wrongAuthLen := append([]byte{}, packet...)
+	wrongAuthLen[udpHeaderLen-1]++
+
+	// malformed and unauthorized packets are rejected
+	for name, p := range map[string][]byte{
+		"too short":           packet[:udpMinPacketLen-1],
+		"truncated hash":      packet[:len(packet)-1],
+		"trailing bytes":      append(append([]byte{}, packet...), 0),
+		"wrong auth length":   wrongAuthLen,
+		"unknown packet type": unknownType,
+		"invalid token":       wrongAuth,
+	} {
+		_, err = service.HandlePacket(p)
+		if err == nil {
+			t.Errorf("%s: packet was not rejected", name)
+		}
+	}
+
+	if _, err = newUDPPacket(udpChainHash, uid, strings.Repeat("a", udpMaxAuthLen+1), hash[:]); err == nil {
+		t.Error("packet with too long auth token was created")
+	}
+}
+
+func TestUDPChainingService_RateLimit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	signer, _ := newTestSigner(t, backend.URL)
+	signer.RateLimiter = NewRateLimiter(Rate{Limit: 1, Interval: time.Minute}, nil, 0)
+	uid := newTestIdentity(t, signer.Protocol)
+	service := &UDPChainingService{Signer: signer, Workers: NewChainWorkers(signer, 1, 10)}
+
+	for i := 1; i <= 2; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprint(i)))
+		packet, err := newUDPPacket(udpChainHash, uid, testAuth, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := service.HandlePacket(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp signingResponse
+		err = json.Unmarshal(reply, &resp)
+		if i == 1 && (err != nil || string(resp.Hash) != string(hash[:])) {
+			t.Errorf("unexpected reply: %s", reply)
+		}
+		if i == 2 && string(reply) != "rate limit exceeded" {
+			t.Errorf("packet beyond the rate limit: unexpected reply: %s", reply)
+		}
+	}
+}
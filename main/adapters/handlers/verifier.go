@@ -17,6 +17,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -26,6 +27,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
 	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
 	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
 
@@ -40,41 +42,261 @@ type verification struct {
 }
 
 type verificationResponse struct {
-	Error  string `json:"error,omitempty"`
-	Hash   []byte `json:"hash,omitempty"`
-	UPP    []byte `json:"upp,omitempty"`
-	UUID   string `json:"uuid,omitempty"`
-	PubKey []byte `json:"pubKey,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Hash    []byte   `json:"hash,omitempty"`
+	UPP     []byte   `json:"upp,omitempty"`
+	UUID    string   `json:"uuid,omitempty"`
+	PubKey  []byte   `json:"pubKey,omitempty"`
+	Anchors []anchor `json:"anchors,omitempty"`
 }
 
+// anchorVerification is the response of the verification service for a verification with blockchain anchors
+type anchorVerification struct {
+	UPP     []byte        `json:"upp"`
+	Anchors []anchorEntry `json:"anchors"`
+}
+
+type anchorEntry struct {
+	Label      string `json:"label"`
+	Properties struct {
+		PublicChain string `json:"public_chain"`
+		Hash        string `json:"hash"`
+		Timestamp   string `json:"timestamp"`
+	} `json:"properties"`
+}
+
+// anchor is a blockchain transaction into which a UPP was anchored
+type anchor struct {
+	Blockchain  string `json:"blockchain"`
+	TxID        string `json:"txid"`
+	Timestamp   string `json:"timestamp,omitempty"`
+	ExplorerURL string `json:"explorerURL,omitempty"`
+}
+
+const (
+	anchorPath        = "/anchor"
+	publicChainLabel  = "PUBLIC_CHAIN"
+	defaultAnchorPoll = 5 * time.Second
+	defaultAnchorWait = 60 * time.Second
+)
+
+// explorerURLs maps the names of the blockchains, which the ubirch backend anchors into,
+// to the URL templates of their block explorers
+var explorerURLs = map[string]string{
+	"ETHEREUM_MAINNET_NETWORK":                 "https://etherscan.io/tx/%s",
+	"ETHEREUM_TESTNET_RINKEBY_TESTNET_NETWORK": "https://rinkeby.etherscan.io/tx/%s",
+	"ETHEREUM-CLASSIC_MAINNET_NETWORK":         "https://blockscout.com/etc/mainnet/tx/%s",
+	"POLYGON_MAINNET_NETWORK":                  "https://polygonscan.com/tx/%s",
+	"POLYGON_TESTNET_MUMBAI_NETWORK":           "https://mumbai.polygonscan.com/tx/%s",
+	"IOTA_MAINNET_MAINNET_NETWORK":             "https://thetangle.org/transaction/%s",
+	"IOTA_TESTNET_DEVNET_NETWORK":              "https://devnet.thetangle.org/transaction/%s",
+}
+
+// ErrUnknownIdentity is returned if the verification of UPPs is restricted to known identities
+// and the UPP is from an identity which is not stored in the local context
+var ErrUnknownIdentity = fmt.Errorf("retrieved certificate for requested hash is from unknown identity " +
+	"and verification is restricted to known identities")
+
 type Verifier struct {
 	Protocol                      *repository.ExtendedProtocol
 	VerifyFromKnownIdentitiesOnly bool
+	KeyCache                      *KeyCache          // caches the public keys of unknown identities from the key service, if set
+	AnchorPollInterval            time.Duration      // time between requests for the blockchain anchors of a hash, defaults to 5s
+	AnchorTimeout                 time.Duration      // time after which polling for the blockchain anchors of a hash is given up, defaults to 60s
+	Canonicalization              h.Canonicalization // canonicalization of JSON original data before hashing
 }
 
-func (v *Verifier) Verify(hash []byte) h.HTTPResponse {
-	log.Infof("verifying hash %s", base64.StdEncoding.EncodeToString(hash))
+func (v *Verifier) Verify(ctx context.Context, hash []byte) h.HTTPResponse {
+	log.WithContext(ctx).Infof("verifying hash %s", base64.StdEncoding.EncodeToString(hash))
 
 	// retrieve certificate for hash from the ubirch backend
-	code, upp, err := v.loadUPP(hash)
+	code, upp, err := v.loadUPP(ctx, hash)
 	if err != nil {
-		log.Error(err)
+		log.WithContext(ctx).Error(err)
 		return errorResponse(code, err.Error())
 	}
-	log.Debugf("retrieved UPP %x", upp)
+	log.WithContext(ctx).Debugf("retrieved UPP %x", upp)
 
 	// verify validity of the retrieved UPP locally
 	id, pkey, err := v.verifyUPP(upp)
+	if err == ErrUnknownIdentity {
+		return getVerificationResponse(http.StatusForbidden, hash, upp, id, pkey, err.Error())
+	}
 	if err != nil {
 		return getVerificationResponse(http.StatusUnprocessableEntity, hash, upp, id, pkey, err.Error())
 	}
-	log.Debugf("verified UPP from identity %s using public key %s", id, base64.StdEncoding.EncodeToString(pkey))
+	log.WithContext(ctx).Debugf("verified UPP from identity %s using public key %s", id, base64.StdEncoding.EncodeToString(pkey))
 
 	return getVerificationResponse(http.StatusOK, hash, upp, id, pkey, "")
 }
 
+// VerifyAnchored polls the ubirch verification service until the UPP which contains the given hash
+// was anchored into a public blockchain or the anchor timeout elapsed. The polling is stopped, if the
+// context is done, e.g. because the client disconnected.
+// Returns 200 with the anchors, 202 if the UPP was not anchored yet and 404 if the hash is unknown.
+func (v *Verifier) VerifyAnchored(ctx context.Context, hash []byte) h.HTTPResponse {
+	log.WithContext(ctx).Infof("verifying anchors of hash %s", base64.StdEncoding.EncodeToString(hash))
+
+	pollInterval := v.AnchorPollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultAnchorPoll
+	}
+	wait := v.AnchorTimeout
+	if wait <= 0 {
+		wait = defaultAnchorWait
+	}
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	var latest *anchorVerification // the latest successful response of the verification service
+
+	for n := 1; ; n++ {
+		code, vf, err := v.loadAnchors(ctx, hash)
+		if code == http.StatusNotFound {
+			return errorResponse(http.StatusNotFound, "hash is unknown to the UBIRCH verification service")
+		}
+		if err == clients.ErrCircuitOpen {
+			return errorResponse(http.StatusServiceUnavailable, err.Error())
+		}
+		if err != nil {
+			log.WithContext(ctx).Warnf("loading anchors failed: %v", err)
+		} else if anchors := publicChainAnchors(vf.Anchors); len(anchors) > 0 {
+			return v.getAnchoredVerificationResponse(http.StatusOK, hash, vf.UPP, anchors)
+		} else {
+			latest = vf
+		}
+
+		log.WithContext(ctx).Debugf("hash not anchored yet. Retry... %d", n)
+
+		select {
+		case <-ctx.Done():
+			log.WithContext(ctx).Warnf("stopped polling for anchors: %v", ctx.Err())
+			return errorResponse(http.StatusGatewayTimeout, "")
+		case <-timeout.C:
+			if latest == nil {
+				return errorResponse(code, err.Error())
+			}
+			return v.getAnchoredVerificationResponse(http.StatusAccepted, hash, latest.UPP, nil)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// AwaitAnchors polls the ubirch verification service until the UPP which contains the given hash was
+// anchored into a public blockchain, and returns the verification response with the anchors.
+// It returns an error if the UPP was not anchored within the anchor timeout.
+func (v *Verifier) AwaitAnchors(ctx context.Context, hash []byte) (json.RawMessage, error) {
+	resp := v.VerifyAnchored(ctx, hash)
+	if resp.StatusCode == http.StatusAccepted {
+		return nil, fmt.Errorf("UPP was not anchored within %s", v.AnchorTimeout)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("(%d) %s", resp.StatusCode, resp.Content)
+	}
+	return resp.Content, nil
+}
+
+// loadAnchors retrieves the UPP which contains a given hash and its blockchain anchors from the ubirch backend
+func (v *Verifier) loadAnchors(ctx context.Context, hash []byte) (int, *anchorVerification, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, v.Protocol.RequestTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, v.Protocol.VerifyServiceURL+anchorPath,
+		strings.NewReader(base64.StdEncoding.EncodeToString(hash)))
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	req.Header.Set("Content-Type", h.TextType)
+	h.SetRequestIDHeader(ctx, req.Header)
+
+	if err = v.Protocol.VerifyBreaker.Allow(); err != nil {
+		return http.StatusServiceUnavailable, nil, err
+	}
+
+	resp, err := v.Protocol.HTTPClient().Do(req)
+	v.Protocol.VerifyBreaker.Record(statusCode(resp), err)
+	if err != nil {
+		return http.StatusBadGateway, nil, fmt.Errorf("error sending verification request: %v", err)
+	}
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	if h.HttpFailed(resp.StatusCode) {
+		respBodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, nil, fmt.Errorf("UBIRCH verification service: - %s - %q", resp.Status, respBodyBytes)
+	}
+
+	vf := &anchorVerification{}
+	err = json.NewDecoder(resp.Body).Decode(vf)
+	if err != nil {
+		return http.StatusBadGateway, nil, fmt.Errorf("unable to decode verification response: %v", err)
+	}
+	return resp.StatusCode, vf, nil
+}
+
+func statusCode(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// publicChainAnchors returns the blockchain transactions from the anchor entries of a verification response
+func publicChainAnchors(entries []anchorEntry) []anchor {
+	var anchors []anchor
+	for _, e := range entries {
+		if e.Label != publicChainLabel || e.Properties.Hash == "" {
+			continue
+		}
+		a := anchor{
+			Blockchain: e.Properties.PublicChain,
+			TxID:       e.Properties.Hash,
+			Timestamp:  e.Properties.Timestamp,
+		}
+		if explorerURL, found := explorerURLs[a.Blockchain]; found {
+			a.ExplorerURL = fmt.Sprintf(explorerURL, a.TxID)
+		}
+		anchors = append(anchors, a)
+	}
+	return anchors
+}
+
+func (v *Verifier) getAnchoredVerificationResponse(respCode int, hash []byte, upp []byte, anchors []anchor) h.HTTPResponse {
+	id, pkey, err := v.verifyUPP(upp)
+	errMsg := ""
+	if err == ErrUnknownIdentity {
+		respCode = http.StatusForbidden
+		errMsg = err.Error()
+	} else if err != nil {
+		respCode = http.StatusUnprocessableEntity
+		errMsg = err.Error()
+	}
+
+	verificationResp, err := json.Marshal(verificationResponse{
+		Hash:    hash,
+		UPP:     upp,
+		UUID:    id.String(),
+		PubKey:  pkey,
+		Anchors: anchors,
+		Error:   errMsg,
+	})
+	if err != nil {
+		log.Warnf("error serializing response: %v", err)
+	}
+
+	if h.HttpFailed(respCode) {
+		log.Errorf("%s", string(verificationResp))
+	}
+
+	return h.HTTPResponse{
+		StatusCode: respCode,
+		Header:     http.Header{"Content-Type": {h.JSONType}},
+		Content:    verificationResp,
+	}
+}
+
 // loadUPP retrieves the UPP which contains a given hash from the ubirch backend
-func (v *Verifier) loadUPP(hash []byte) (int, []byte, error) {
+func (v *Verifier) loadUPP(ctx context.Context, hash []byte) (int, []byte, error) {
 	var resp *http.Response
 	var err error
 	hashBase64String := base64.StdEncoding.EncodeToString(hash)
@@ -86,14 +308,27 @@ func (v *Verifier) loadUPP(hash []byte) (int, []byte, error) {
 		case <-timeout:
 			stay = false
 		default:
-			resp, err = http.Post(v.Protocol.VerifyServiceURL, "text/plain", strings.NewReader(hashBase64String))
+			if err = v.Protocol.VerifyBreaker.Allow(); err != nil {
+				return http.StatusServiceUnavailable, nil, err
+			}
+			var req *http.Request
+			req, err = http.NewRequest(http.MethodPost, v.Protocol.VerifyServiceURL, strings.NewReader(hashBase64String))
+			if err != nil {
+				return http.StatusInternalServerError, nil, err
+			}
+			req.Header.Set("Content-Type", h.TextType)
+			h.SetRequestIDHeader(ctx, req.Header)
+
+			resp, err = v.Protocol.HTTPClient().Do(req)
 			if err != nil {
+				v.Protocol.VerifyBreaker.Record(0, err)
 				return http.StatusInternalServerError, nil, fmt.Errorf("error sending verification request: %v", err)
 			}
+			v.Protocol.VerifyBreaker.Record(resp.StatusCode, nil)
 			stay = h.HttpFailed(resp.StatusCode)
 			if stay {
 				_ = resp.Body.Close()
-				log.Debugf("Couldn't verify hash yet (%d). Retry... %d", resp.StatusCode, n)
+				log.WithContext(ctx).Debugf("Couldn't verify hash yet (%d). Retry... %d", resp.StatusCode, n)
 				time.Sleep(time.Second)
 			}
 		}
@@ -104,7 +339,7 @@ func (v *Verifier) loadUPP(hash []byte) (int, []byte, error) {
 	if h.HttpFailed(resp.StatusCode) {
 		respBodyBytes, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			log.Warnf("unable to decode verification response: %v", err)
+			log.WithContext(ctx).Warnf("unable to decode verification response: %v", err)
 		}
 		return resp.StatusCode, nil, fmt.Errorf("could not retrieve certificate for hash %s from UBIRCH verification service: - %s - %q", hashBase64String, resp.Status, respBodyBytes)
 	}
@@ -127,33 +362,77 @@ func (v *Verifier) verifyUPP(upp []byte) (uuid.UUID, []byte, error) {
 	id := uppStruct.GetUuid()
 
 	pubKeyPEM, err := v.Protocol.GetPublicKey(id)
-	if err != nil {
-		if v.VerifyFromKnownIdentitiesOnly {
-			return id, nil, fmt.Errorf("retrieved certificate for requested hash is from unknown identity")
-		} else {
-			log.Warnf("couldn't get public key for identity %s from local context", id)
-			pubKeyBytes, err := v.loadPublicKey(id)
-			if err != nil {
-				return id, nil, err
-			}
-			pubKeyPEM, err = v.Protocol.PublicKeyBytesToPEM(pubKeyBytes)
-			if err != nil {
-				return id, nil, err
-			}
+	if err == nil {
+		verified, err := v.Protocol.Verify(pubKeyPEM, upp)
+		if !verified {
+			return id, pubKeyPEM, verificationError(err)
 		}
+		return id, pubKeyPEM, nil
+	}
+
+	if v.VerifyFromKnownIdentitiesOnly {
+		return id, nil, ErrUnknownIdentity
+	}
+	log.Warnf("couldn't get public key for identity %s from local context", id)
+
+	pubKeyPEM, cached, err := v.unknownPublicKey(id)
+	if err != nil {
+		return id, nil, err
 	}
 
 	verified, err := v.Protocol.Verify(pubKeyPEM, upp)
-	if !verified {
+	if !verified && cached {
+		// the cached key might have been replaced at the key service in the meantime
+		log.Debugf("verification with cached public key of identity %s failed, invalidating cache entry", id)
+		v.KeyCache.Invalidate(id)
+
+		pubKeyPEM, _, err = v.unknownPublicKey(id)
 		if err != nil {
-			log.Error(err)
+			return id, nil, err
 		}
-		return id, pubKeyPEM, fmt.Errorf("signature of retrieved certificate for requested hash could not be verified")
+		verified, err = v.Protocol.Verify(pubKeyPEM, upp)
+	}
+	if !verified {
+		if v.KeyCache != nil {
+			v.KeyCache.Invalidate(id)
+		}
+		return id, pubKeyPEM, verificationError(err)
 	}
 
 	return id, pubKeyPEM, nil // todo return bytes
 }
 
+// unknownPublicKey returns the public key of an identity which is not stored in the local context
+// from the key cache or, if the key is not cached, from the key service
+func (v *Verifier) unknownPublicKey(id uuid.UUID) (pubKeyPEM []byte, cached bool, err error) {
+	if v.KeyCache != nil {
+		if pubKeyPEM, found := v.KeyCache.Get(id); found {
+			return pubKeyPEM, true, nil
+		}
+	}
+
+	pubKeyBytes, err := v.loadPublicKey(id)
+	if err != nil {
+		return nil, false, err
+	}
+	pubKeyPEM, err = v.Protocol.PublicKeyBytesToPEM(pubKeyBytes)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if v.KeyCache != nil {
+		v.KeyCache.Put(id, pubKeyPEM)
+	}
+	return pubKeyPEM, false, nil
+}
+
+func verificationError(err error) error {
+	if err != nil {
+		log.Error(err)
+	}
+	return fmt.Errorf("signature of retrieved certificate for requested hash could not be verified")
+}
+
 // loadPublicKey retrieves the first valid public key associated with an identity from the key service
 func (v *Verifier) loadPublicKey(id uuid.UUID) (pubKeyBytes []byte, err error) {
 	log.Debugf("requesting public key for identity %s from key service", id.String())
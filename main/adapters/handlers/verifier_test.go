@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+)
+
+const testTxID = "0x4ba3e5b8f1c7fc5f3e9aa0b4b1cc7b70d1a4c1b0f1ab3dbd7d0c7c5e6f7a8b9c"
+
+func TestVerifier_VerifyAnchored(t *testing.T) {
+	var tests = []struct {
+		name            string
+		unknown         bool
+		anchoredAfter   int // number of requests after which the UPP is anchored, 0 if never
+		expectedCode    int
+		expectedAnchors int
+	}{
+		{
+			name:            "anchored",
+			anchoredAfter:   1,
+			expectedCode:    http.StatusOK,
+			expectedAnchors: 1,
+		},
+		{
+			name:            "anchored while polling",
+			anchoredAfter:   3,
+			expectedCode:    http.StatusOK,
+			expectedAnchors: 1,
+		},
+		{
+			name:         "not anchored within timeout",
+			expectedCode: http.StatusAccepted,
+		},
+		{
+			name:         "unknown hash",
+			unknown:      true,
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, upp := newTestVerifier(t)
+			hash := testSHA256(test.name)
+
+			requests := 0
+			verifyService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if r.URL.Path != anchorPath {
+					t.Errorf("unexpected path of verification request: %s", r.URL.Path)
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != base64.StdEncoding.EncodeToString(hash) {
+					t.Errorf("unexpected hash in verification request: %s", body)
+				}
+				if test.unknown {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_, _ = w.Write(testAnchorVerification(upp, test.anchoredAfter > 0 && requests >= test.anchoredAfter))
+			}))
+			defer verifyService.Close()
+			v.Protocol.VerifyServiceURL = verifyService.URL
+
+			resp := v.VerifyAnchored(context.Background(), hash)
+
+			if resp.StatusCode != test.expectedCode {
+				t.Fatalf("unexpected response code: expected %d, got %d: %s", test.expectedCode, resp.StatusCode, resp.Content)
+			}
+			if test.anchoredAfter > 0 && requests != test.anchoredAfter {
+				t.Errorf("unexpected number of verification requests: expected %d, got %d", test.anchoredAfter, requests)
+			}
+			if test.unknown {
+				return
+			}
+
+			var vr verificationResponse
+			err := json.Unmarshal(resp.Content, &vr)
+			if err != nil {
+				t.Fatalf("unable to decode response: %v", err)
+			}
+			if string(vr.UPP) != string(upp) {
+				t.Errorf("unexpected UPP in response: %x", vr.UPP)
+			}
+			if len(vr.Anchors) != test.expectedAnchors {
+				t.Fatalf("unexpected number of anchors: expected %d, got %d", test.expectedAnchors, len(vr.Anchors))
+			}
+			for _, a := range vr.Anchors {
+				if a.TxID != testTxID {
+					t.Errorf("unexpected transaction ID: %s", a.TxID)
+				}
+				if a.ExplorerURL != fmt.Sprintf("https://rinkeby.etherscan.io/tx/%s", testTxID) {
+					t.Errorf("unexpected explorer URL: %s", a.ExplorerURL)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifier_VerifyAnchoredCanceled(t *testing.T) {
+	v, upp := newTestVerifier(t)
+	v.AnchorTimeout = time.Minute
+
+	verifyService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(testAnchorVerification(upp, false))
+	}))
+	defer verifyService.Close()
+	v.Protocol.VerifyServiceURL = verifyService.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	resp := v.VerifyAnchored(ctx, testSHA256("canceled"))
+
+	if time.Since(start) > time.Second {
+		t.Errorf("polling was not stopped when the request context was done")
+	}
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+}
+
+func TestVerifier_AwaitAnchors(t *testing.T) {
+	v, upp := newTestVerifier(t)
+
+	anchored := false
+	verifyService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(testAnchorVerification(upp, anchored))
+	}))
+	defer verifyService.Close()
+	v.Protocol.VerifyServiceURL = verifyService.URL
+
+	if _, err := v.AwaitAnchors(context.Background(), testSHA256("await")); err == nil {
+		t.Error("no error for UPP which was not anchored within the timeout")
+	}
+
+	anchored = true
+	verification, err := v.AwaitAnchors(context.Background(), testSHA256("await"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vr verificationResponse
+	if err = json.Unmarshal(verification, &vr); err != nil {
+		t.Fatal(err)
+	}
+	if len(vr.Anchors) != 1 || vr.Anchors[0].TxID != testTxID {
+		t.Errorf("unexpected anchors: %v", vr.Anchors)
+	}
+}
+
+func TestVerifier_VerifyKnownOnly(t *testing.T) {
+	var tests = []struct {
+		name               string
+		knownOnly          bool
+		unknownIdentity    bool
+		expectedCode       int
+		expectedKeyRequest bool
+	}{
+		{
+			name:         "known identity",
+			knownOnly:    true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:            "unknown identity, known only",
+			knownOnly:       true,
+			unknownIdentity: true,
+			expectedCode:    http.StatusForbidden,
+		},
+		{
+			name:               "unknown identity",
+			unknownIdentity:    true,
+			expectedCode:       http.StatusOK,
+			expectedKeyRequest: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, upp := newTestVerifier(t)
+			v.VerifyFromKnownIdentitiesOnly = test.knownOnly
+
+			if test.unknownIdentity {
+				// remove the identity from the local context, but keep its public key at the key service
+				uppStruct, err := ubirch.Decode(upp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				uid := uppStruct.GetUuid()
+				pubKeyPEM, err := v.Protocol.GetPublicKey(uid)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pubKey, err := v.Protocol.PublicKeyPEMToBytes(pubKeyPEM)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = v.Protocol.DeleteIdentityWithLock(context.Background(), uid)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				keyService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if !test.expectedKeyRequest {
+						t.Error("unexpected key service request")
+					}
+					_ = json.NewEncoder(w).Encode([]ubirch.SignedKeyRegistration{{
+						PubKeyInfo: ubirch.KeyRegistration{
+							HwDeviceId: uid.String(),
+							PubKey:     base64.StdEncoding.EncodeToString(pubKey),
+						},
+					}})
+				}))
+				defer keyService.Close()
+				v.Protocol.KeyServiceURL = keyService.URL
+			}
+
+			verifyService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(map[string][]byte{"upp": upp})
+			}))
+			defer verifyService.Close()
+			v.Protocol.VerifyServiceURL = verifyService.URL
+
+			resp := v.Verify(context.Background(), testSHA256(test.name))
+
+			if resp.StatusCode != test.expectedCode {
+				t.Errorf("unexpected response code: expected %d, got %d: %s", test.expectedCode, resp.StatusCode, resp.Content)
+			}
+		})
+	}
+}
+
+func TestVerifier_KeyCache(t *testing.T) {
+	p, err := repository.NewExtendedProtocol(newMockCtxManager(), testSecret, &clients.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := uuid.New() // identity which is not stored in the local context
+
+	var pubKey []byte
+	requests := 0
+	keyService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_ = json.NewEncoder(w).Encode([]ubirch.SignedKeyRegistration{{
+			PubKeyInfo: ubirch.KeyRegistration{
+				HwDeviceId: uid.String(),
+				PubKey:     base64.StdEncoding.EncodeToString(pubKey),
+			},
+		}})
+	}))
+	defer keyService.Close()
+	p.KeyServiceURL = keyService.URL
+
+	v := &Verifier{
+		Protocol: p,
+		KeyCache: NewKeyCache(10, time.Minute),
+	}
+
+	newKeyAndUPP := func() []byte {
+		privKeyPEM, err := p.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKeyPEM, err := p.GetPublicKeyFromPrivateKey(privKeyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKey, err = p.PublicKeyPEMToBytes(pubKeyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upp, err := p.Sign(privKeyPEM, &ubirch.SignedUPP{
+			Version: ubirch.Signed,
+			Uuid:    uid,
+			Hint:    ubirch.Binary,
+			Payload: testSHA256("upp"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return upp
+	}
+
+	// repeated verifications reuse the cached key
+	upp := newKeyAndUPP()
+	for i := 0; i < 3; i++ {
+		_, _, err = v.verifyUPP(upp)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("unexpected number of key service requests: expected 1, got %d", requests)
+	}
+
+	// a rotated key is fetched again
+	upp = newKeyAndUPP()
+	_, _, err = v.verifyUPP(upp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("unexpected number of key service requests: expected 2, got %d", requests)
+	}
+}
+
+func newTestVerifier(t *testing.T) (*Verifier, []byte) {
+	p, err := repository.NewExtendedProtocol(newMockCtxManager(), testSecret, &clients.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := newTestIdentity(t, p)
+
+	privKeyPEM, err := p.GetPrivateKey(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upp, err := p.Sign(privKeyPEM, &ubirch.SignedUPP{
+		Version: ubirch.Signed,
+		Uuid:    uid,
+		Hint:    ubirch.Binary,
+		Payload: testSHA256("upp"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Verifier{
+		Protocol:                      p,
+		VerifyFromKnownIdentitiesOnly: true,
+		AnchorPollInterval:            10 * time.Millisecond,
+		AnchorTimeout:                 100 * time.Millisecond,
+	}, upp
+}
+
+func testAnchorVerification(upp []byte, anchored bool) []byte {
+	vf := map[string]interface{}{
+		"upp":     upp,
+		"anchors": []interface{}{},
+	}
+	if anchored {
+		vf["anchors"] = []interface{}{
+			map[string]interface{}{
+				"label": publicChainLabel,
+				"properties": map[string]string{
+					"public_chain": "ETHEREUM_TESTNET_RINKEBY_TESTNET_NETWORK",
+					"hash":         testTxID,
+					"timestamp":    "2021-06-01T12:00:00.000Z",
+				},
+			},
+		}
+	}
+	resp, _ := json.Marshal(vf)
+	return resp
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const (
+	VerifyCommandName = "verify"
+
+	VerifyCommandUsage = "usage: verify [--hash <base64 hash>] [--hash-algorithm (sha256 | sha512)] [--anchored] " +
+		"[--env (dev | demo | prod)] [--json] [--config-dir <directory>] [<file>]"
+)
+
+// VerifyCommand verifies the hash of a file, or a given hash, with the ubirch verification service
+// from the command line, without running the HTTP server, e.g. as a gate in a CI pipeline
+type VerifyCommand struct {
+	File          string // file whose hash is verified, if no hash is given
+	Hash          h.Hash // precomputed hash which is verified instead of the hash of a file
+	HashAlgorithm string // hash algorithm of the file, defaults to SHA-256
+	Anchored      bool   // wait for the blockchain anchors of the UPP
+	JSON          bool   // write the verification response as JSON instead of a human-readable report
+	Env           string // the ubirch backend environment, defaults to the environment of the configuration
+	ConfigDir     string // directory of the configuration, defaults to the working directory
+}
+
+// ParseVerifyCommand parses the arguments of the verify command, i.e. the arguments after "verify".
+// Flags and positional arguments may be given in any order.
+func ParseVerifyCommand(args []string) (*VerifyCommand, error) {
+	var hashBase64 string
+	c := &VerifyCommand{}
+
+	fs := flag.NewFlagSet(VerifyCommandName, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&hashBase64, "hash", "", "")
+	fs.StringVar(&c.HashAlgorithm, "hash-algorithm", h.DefaultHashAlgorithm, "")
+	fs.BoolVar(&c.Anchored, "anchored", false, "")
+	fs.BoolVar(&c.JSON, "json", false, "")
+	fs.StringVar(&c.Env, "env", "", "")
+	fs.StringVar(&c.ConfigDir, "config-dir", "", "")
+
+	positional, err := parseCommandArgs(fs, args)
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, VerifyCommandUsage)
+	}
+
+	c.Hash, err = decodeHashArg(hashBase64)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(positional) == 1 && c.Hash == nil:
+		c.File = positional[0]
+	case len(positional) == 0 && c.Hash != nil:
+	default:
+		return nil, fmt.Errorf("expected either file or hash\n%s", VerifyCommandUsage)
+	}
+
+	alg, err := h.GetHashAlgorithm(c.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	if c.Hash != nil && len(c.Hash) != alg.Size {
+		return nil, fmt.Errorf("invalid %s hash size: expected %d bytes, got %d bytes", alg.Name, alg.Size, len(c.Hash))
+	}
+	c.HashAlgorithm = alg.Name
+
+	return c, nil
+}
+
+// Run verifies the hash with the verification service and writes the result to w. If no hash was given,
+// the file is hashed while it is read. Returns an error if the hash could not be verified.
+// A UPP which was not anchored into a blockchain yet is verified, but has no anchors.
+func (c *VerifyCommand) Run(ctx context.Context, v *Verifier, w io.Writer) error {
+	hash := c.Hash
+	if hash == nil {
+		alg, err := h.GetHashAlgorithm(c.HashAlgorithm)
+		if err != nil {
+			return err
+		}
+		hash, err = hashFile(c.File, alg)
+		if err != nil {
+			return err
+		}
+	}
+
+	var resp h.HTTPResponse
+	if c.Anchored {
+		resp = v.VerifyAnchored(ctx, hash)
+	} else {
+		resp = v.Verify(ctx, hash)
+	}
+
+	var vr verificationResponse
+	if resp.Header.Get("Content-Type") == h.JSONType {
+		if err := json.Unmarshal(resp.Content, &vr); err != nil {
+			return fmt.Errorf("unable to decode verification response: %v", err)
+		}
+	} else {
+		vr = verificationResponse{Hash: hash, Error: string(resp.Content)}
+	}
+	verified := h.HttpSuccess(resp.StatusCode)
+
+	var err error
+	if c.JSON {
+		_, err = fmt.Fprintf(w, "%s\n", resp.Content)
+	} else {
+		err = writeVerificationReport(w, vr, verified, c.Anchored && resp.StatusCode == http.StatusAccepted)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !verified {
+		return fmt.Errorf("hash %s could not be verified: (%d) %s",
+			base64.StdEncoding.EncodeToString(hash), resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
+
+func writeVerificationReport(w io.Writer, vr verificationResponse, verified, notAnchoredYet bool) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "verified: %t\n", verified)
+	fmt.Fprintf(b, "hash:     %s\n", base64.StdEncoding.EncodeToString(vr.Hash))
+	if vr.UUID != "" && vr.UUID != uuid.Nil.String() {
+		fmt.Fprintf(b, "uuid:     %s\n", vr.UUID)
+	}
+	if len(vr.UPP) > 0 {
+		fmt.Fprintf(b, "upp:      %s\n", base64.StdEncoding.EncodeToString(vr.UPP))
+	}
+	if notAnchoredYet {
+		fmt.Fprintf(b, "anchors:  not anchored yet\n")
+	}
+	if len(vr.Anchors) > 0 {
+		fmt.Fprintf(b, "anchors:\n")
+		for _, a := range vr.Anchors {
+			fmt.Fprintf(b, "  - %s %s %s %s\n", a.Blockchain, a.TxID, a.Timestamp, a.ExplorerURL)
+		}
+	}
+	if vr.Error != "" {
+		fmt.Fprintf(b, "error:    %s\n", vr.Error)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyCommand(t *testing.T) {
+	v, upp := newTestVerifier(t)
+
+	invalidUPP := make([]byte, len(upp))
+	copy(invalidUPP, upp)
+	invalidUPP[len(invalidUPP)-1] ^= 0xff // invalidate the signature
+
+	file := filepath.Join(t.TempDir(), "artifact.bin")
+	data := []byte("artifact")
+	err := ioutil.WriteFile(file, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileHash := sha256.Sum256(data)
+
+	testCases := []struct {
+		name             string
+		args             []string
+		upp              []byte
+		anchored         bool
+		expectedVerified bool
+		expectedReport   []string
+	}{
+		{
+			name:             "verified file",
+			args:             []string{file},
+			upp:              upp,
+			expectedVerified: true,
+			expectedReport:   []string{"verified: true", base64.StdEncoding.EncodeToString(fileHash[:])},
+		},
+		{
+			name:             "verified hash",
+			args:             []string{"--hash", base64.StdEncoding.EncodeToString(testSHA256("hash"))},
+			upp:              upp,
+			expectedVerified: true,
+			expectedReport:   []string{"verified: true", base64.StdEncoding.EncodeToString(testSHA256("hash"))},
+		},
+		{
+			name:             "verified anchored",
+			args:             []string{"--anchored", file},
+			upp:              upp,
+			anchored:         true,
+			expectedVerified: true,
+			expectedReport:   []string{"verified: true", testTxID},
+		},
+		{
+			name:             "verified not anchored yet",
+			args:             []string{file, "--anchored"},
+			upp:              upp,
+			expectedVerified: true,
+			expectedReport:   []string{"verified: true", "not anchored yet"},
+		},
+		{
+			name:             "unverified",
+			args:             []string{file},
+			upp:              invalidUPP,
+			expectedVerified: false,
+			expectedReport:   []string{"verified: false", "could not be verified"},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			verifyService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == anchorPath {
+					_, _ = w.Write(testAnchorVerification(c.upp, c.anchored))
+					return
+				}
+				_ = json.NewEncoder(w).Encode(verification{UPP: c.upp})
+			}))
+			defer verifyService.Close()
+			v.Protocol.VerifyServiceURL = verifyService.URL
+
+			cmd, err := ParseVerifyCommand(c.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := &bytes.Buffer{}
+			err = cmd.Run(context.Background(), v, out)
+			if c.expectedVerified && err != nil {
+				t.Errorf("verification failed: %v", err)
+			}
+			if !c.expectedVerified && err == nil {
+				t.Error("unverified hash did not fail the command")
+			}
+			for _, s := range c.expectedReport {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("report does not contain %q: %s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyCommand_JSON(t *testing.T) {
+	v, upp := newTestVerifier(t)
+
+	verifyService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(verification{UPP: upp})
+	}))
+	defer verifyService.Close()
+	v.Protocol.VerifyServiceURL = verifyService.URL
+
+	cmd, err := ParseVerifyCommand([]string{"--json", "--hash", base64.StdEncoding.EncodeToString(testSHA256("1"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	err = cmd.Run(context.Background(), v, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vr verificationResponse
+	err = json.Unmarshal(out.Bytes(), &vr)
+	if err != nil {
+		t.Fatalf("output is no verification response: %v: %s", err, out)
+	}
+	if !bytes.Equal(vr.UPP, upp) {
+		t.Errorf("unexpected UPP: %x", vr.UPP)
+	}
+}
+
+func TestVerifyCommand_Invalid(t *testing.T) {
+	hashBase64 := base64.StdEncoding.EncodeToString(testSHA256("1"))
+
+	for _, args := range [][]string{
+		{},
+		{"file", "other file"},
+		{"file", "--hash", hashBase64},
+		{"--hash", "invalid base64"},
+		{"--hash", hashBase64, "--hash-algorithm", "sha512"},
+		{"--hash-algorithm", "md5", "file"},
+		{"--unknown", "file"},
+	} {
+		if _, err := ParseVerifyCommand(args); err == nil {
+			t.Errorf("invalid arguments %q were accepted", args)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+//go:build cgo
+// +build cgo
+
+package hsm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miekg/pkcs11"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+)
+
+const (
+	signatureLength = 64 // length of a raw P-256 signature: r || s
+	keyIDLength     = 16
+)
+
+// object identifier of the NIST P-256 curve
+var oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+
+// CryptoContext is a ubirch.Crypto which generates the keys of new identities inside a PKCS#11 token and signs
+// with them there. The key pairs are labeled with the UUID of the identity, instead of the private key, a reference
+// to the key pair is stored. Identities with private keys which are not held by the token, e.g. which were created
+// before the HSM was enabled, continue to work with the local ECDSA crypto context.
+type CryptoContext struct {
+	ubirch.ECDSACryptoContext
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	mutex   sync.Mutex // a PKCS#11 session must not be used concurrently
+}
+
+// Ensure CryptoContext implements the Crypto interface and the optional interface of the protocol
+var (
+	_ ubirch.Crypto               = (*CryptoContext)(nil)
+	_ repository.UUIDKeyGenerator = (*CryptoContext)(nil)
+)
+
+// NewCryptoContext loads the PKCS#11 module and logs in to the token. The crypto context must be closed.
+func NewCryptoContext(conf Config) (*CryptoContext, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	ctx := pkcs11.New(conf.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("unable to load PKCS#11 module %s", conf.Module)
+	}
+
+	err := ctx.Initialize()
+	if err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("unable to initialize PKCS#11 module %s: %v", conf.Module, err)
+	}
+
+	c := &CryptoContext{ctx: ctx}
+
+	err = c.openSession(conf.TokenLabel, conf.PIN)
+	if err != nil {
+		_ = ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *CryptoContext) openSession(tokenLabel, pin string) error {
+	slots, err := c.ctx.GetSlotList(true)
+	if err != nil {
+		return fmt.Errorf("unable to list PKCS#11 slots: %v", err)
+	}
+
+	for _, slot := range slots {
+		info, err := c.ctx.GetTokenInfo(slot)
+		if err != nil {
+			return fmt.Errorf("unable to get info of PKCS#11 token in slot %d: %v", slot, err)
+		}
+		if strings.TrimSpace(info.Label) != tokenLabel {
+			continue
+		}
+
+		c.session, err = c.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return fmt.Errorf("unable to open session with PKCS#11 token %s: %v", tokenLabel, err)
+		}
+
+		err = c.ctx.Login(c.session, pkcs11.CKU_USER, pin)
+		if err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			_ = c.ctx.CloseSession(c.session)
+			return fmt.Errorf("unable to log in to PKCS#11 token %s: %v", tokenLabel, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("PKCS#11 token %s not found", tokenLabel)
+}
+
+// Close logs out of the token and unloads the PKCS#11 module
+func (c *CryptoContext) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_ = c.ctx.Logout(c.session)
+	_ = c.ctx.CloseSession(c.session)
+	err := c.ctx.Finalize()
+	c.ctx.Destroy()
+	return err
+}
+
+// GenerateKey generates a new key pair in the token and returns the reference to it
+func (c *CryptoContext) GenerateKey() (privKeyPEM []byte, err error) {
+	return c.generateKey("")
+}
+
+// GenerateKeyForUUID generates a new key pair, which is labeled with the UUID, in the token
+// and returns the reference to it
+func (c *CryptoContext) GenerateKeyForUUID(uid uuid.UUID) (privKeyPEM []byte, err error) {
+	return c.generateKey(uid.String())
+}
+
+func (c *CryptoContext) generateKey(label string) ([]byte, error) {
+	// the label of a key pair is not unique, since an identity has a new key pair after a key rotation,
+	// therefore the key pair is referenced by a random ID
+	id := make([]byte, keyIDLength)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ecParams, err := asn1.Marshal(oidNamedCurveP256)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ecParams),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	privateKeyTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, _, err = c.ctx.GenerateKeyPair(c.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		publicKeyTemplate, privateKeyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("generating key pair in PKCS#11 token failed: %v", err)
+	}
+
+	return KeyReference(id), nil
+}
+
+// findObject returns the handle of the key with the class (public or private) and the ID. The mutex must be held.
+func (c *CryptoContext) findObject(class uint, id []byte) (pkcs11.ObjectHandle, error) {
+	err := c.ctx.FindObjectsInit(c.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("searching key %x in PKCS#11 token failed: %v", id, err)
+	}
+
+	objects, _, err := c.ctx.FindObjects(c.session, 1)
+	finalErr := c.ctx.FindObjectsFinal(c.session)
+	if err != nil {
+		return 0, fmt.Errorf("searching key %x in PKCS#11 token failed: %v", id, err)
+	}
+	if finalErr != nil {
+		return 0, fmt.Errorf("searching key %x in PKCS#11 token failed: %v", id, finalErr)
+	}
+	if len(objects) == 0 {
+		return 0, fmt.Errorf("key %x not found in PKCS#11 token", id)
+	}
+	return objects[0], nil
+}
+
+// GetPublicKeyFromPrivateKey reads the public key of the key pair from the token
+func (c *CryptoContext) GetPublicKeyFromPrivateKey(privKeyPEM []byte) ([]byte, error) {
+	id, ok, err := keyID(privKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return c.ECDSACryptoContext.GetPublicKeyFromPrivateKey(privKeyPEM)
+	}
+
+	pub, err := c.publicKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return c.EncodePublicKey(pub)
+}
+
+func (c *CryptoContext) publicKey(id []byte) (*ecdsa.PublicKey, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	handle, err := c.findObject(pkcs11.CKO_PUBLIC_KEY, id)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := c.ctx.GetAttributeValue(c.session, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil || len(attributes) == 0 {
+		return nil, fmt.Errorf("reading public key %x from PKCS#11 token failed: %v", id, err)
+	}
+
+	// the EC point is a DER encoded octet string, but some modules return the raw point
+	point := attributes[0].Value
+	var octets []byte
+	if rest, err := asn1.Unmarshal(point, &octets); err == nil && len(rest) == 0 {
+		point = octets
+	}
+
+	x, y := elliptic.Unmarshal(elliptic.P256(), point)
+	if x == nil {
+		return nil, fmt.Errorf("public key %x in PKCS#11 token is not an uncompressed P-256 point", id)
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+func (c *CryptoContext) Sign(privKeyPEM []byte, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty data")
+	}
+
+	hash := sha256.Sum256(data)
+	return c.SignHash(privKeyPEM, hash[:])
+}
+
+// SignHash signs the SHA256 hash with the private key in the token
+func (c *CryptoContext) SignHash(privKeyPEM []byte, hash []byte) ([]byte, error) {
+	id, ok, err := keyID(privKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return c.ECDSACryptoContext.SignHash(privKeyPEM, hash)
+	}
+
+	if len(hash) != sha256.Size {
+		return nil, fmt.Errorf("invalid sha256 size: expected %d, got %d", sha256.Size, len(hash))
+	}
+
+	return c.signHash(id, hash)
+}
+
+// signHash returns the raw signature (r || s), which is the format of CKM_ECDSA signatures
+func (c *CryptoContext) signHash(id []byte, hash []byte) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	handle, err := c.findObject(pkcs11.CKO_PRIVATE_KEY, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.ctx.SignInit(c.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, handle)
+	if err != nil {
+		return nil, fmt.Errorf("signing with key %x in PKCS#11 token failed: %v", id, err)
+	}
+
+	signature, err := c.ctx.Sign(c.session, hash)
+	if err != nil {
+		return nil, fmt.Errorf("signing with key %x in PKCS#11 token failed: %v", id, err)
+	}
+	if len(signature) != signatureLength {
+		return nil, fmt.Errorf("signature of key %x in PKCS#11 token has invalid length: expected %d, got %d", id, signatureLength, len(signature))
+	}
+	return signature, nil
+}
+
+// GetSignedKeyRegistration creates the self-signed JSON key certificate like the ECDSA crypto context,
+// but signs it with the private key in the token
+func (c *CryptoContext) GetSignedKeyRegistration(privKeyPEM []byte, uid uuid.UUID) ([]byte, error) {
+	if _, ok, _ := keyID(privKeyPEM); !ok {
+		return c.ECDSACryptoContext.GetSignedKeyRegistration(privKeyPEM, uid)
+	}
+
+	const timeFormat = "2006-01-02T15:04:05.000Z"
+
+	pubKeyPEM, err := c.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := c.PublicKeyPEMToBytes(pubKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	keyRegistration := ubirch.KeyRegistration{
+		Algorithm:      "ecdsa-p256v1",
+		Created:        now.Format(timeFormat),
+		HwDeviceId:     uid.String(),
+		PubKey:         base64.StdEncoding.EncodeToString(pubKey),
+		PubKeyId:       base64.StdEncoding.EncodeToString(pubKey),
+		ValidNotAfter:  now.Add(10 * 365 * 24 * time.Hour).Format(timeFormat), // valid for 10 years
+		ValidNotBefore: now.Format(timeFormat),
+	}
+
+	jsonKeyReg, err := json.Marshal(keyRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := c.Sign(privKeyPEM, jsonKeyReg)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(ubirch.SignedKeyRegistration{
+		PubKeyInfo: keyRegistration,
+		Signature:  base64.StdEncoding.EncodeToString(signature),
+	})
+}
+
+// GetCSR creates the certificate signing request like the ECDSA crypto context,
+// but signs it with the private key in the token
+func (c *CryptoContext) GetCSR(privKeyPEM []byte, id uuid.UUID, subjectCountry string, subjectOrganization string) ([]byte, error) {
+	keyID, ok, err := keyID(privKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return c.ECDSACryptoContext.GetCSR(privKeyPEM, id, subjectCountry, subjectOrganization)
+	}
+
+	pub, err := c.publicKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.CertificateRequest{
+		SignatureAlgorithm: x509.ECDSAWithSHA256,
+		Subject: pkix.Name{
+			Country:      []string{subjectCountry},
+			Organization: []string{subjectOrganization},
+			CommonName:   id.String(),
+		},
+	}
+
+	return x509.CreateCertificateRequest(rand.Reader, template, &signer{crypto: c, keyID: keyID, pub: pub})
+}
+
+// signer is a crypto.Signer for a private key in the token
+type signer struct {
+	crypto *CryptoContext
+	keyID  []byte
+	pub    *ecdsa.PublicKey
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign returns the ASN.1 DER encoded signature, which is expected by the x509 package
+func (s *signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 {
+		return nil, fmt.Errorf("unsupported hash function: %v", opts.HashFunc())
+	}
+
+	raw, err := s.crypto.signHash(s.keyID, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(raw[:signatureLength/2]),
+		S: new(big.Int).SetBytes(raw[signatureLength/2:]),
+	})
+}
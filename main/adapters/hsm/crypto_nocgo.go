@@ -0,0 +1,24 @@
+//go:build !cgo
+// +build !cgo
+
+package hsm
+
+import (
+	"fmt"
+
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+)
+
+// CryptoContext is not available without cgo, since PKCS#11 modules are shared libraries
+type CryptoContext struct {
+	ubirch.ECDSACryptoContext
+}
+
+// NewCryptoContext fails, since PKCS#11 modules can not be loaded without cgo
+func NewCryptoContext(Config) (*CryptoContext, error) {
+	return nil, fmt.Errorf("PKCS#11 is not supported by this build, it requires a build with cgo (CGO_ENABLED=1)")
+}
+
+func (c *CryptoContext) Close() error {
+	return nil
+}
@@ -0,0 +1,303 @@
+//go:build cgo
+// +build cgo
+
+package hsm
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/miekg/pkcs11"
+	"github.com/ubirch/ubirch-client-go/main/adapters/encrypters"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+)
+
+const (
+	testTokenLabel = "ubirch-client-test"
+	testSOPIN      = "87654321"
+	testPIN        = "12345678"
+)
+
+// paths of the SoftHSM library in common distributions, the path can also be set with SOFTHSM2_LIB
+var softHSMPaths = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/lib64/pkcs11/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+}
+
+// newTestCryptoContext initializes a SoftHSM token in a temporary directory and returns a crypto context for it.
+// The test is skipped if SoftHSM is not installed.
+func newTestCryptoContext(t *testing.T) *CryptoContext {
+	module := os.Getenv("SOFTHSM2_LIB")
+	for _, path := range softHSMPaths {
+		if module != "" {
+			break
+		}
+		if _, err := os.Stat(path); err == nil {
+			module = path
+		}
+	}
+	if module == "" {
+		t.Skip("SoftHSM is not installed, set SOFTHSM2_LIB to the path of libsofthsm2.so")
+	}
+
+	tokenDir := t.TempDir()
+	confFile := filepath.Join(tokenDir, "softhsm2.conf")
+	err := ioutil.WriteFile(confFile, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", tokenDir)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("SOFTHSM2_CONF", confFile)
+	t.Cleanup(func() { os.Unsetenv("SOFTHSM2_CONF") })
+
+	initTestToken(t, module)
+
+	c, err := NewCryptoContext(Config{Module: module, TokenLabel: testTokenLabel, PIN: testPIN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := c.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return c
+}
+
+// initTestToken initializes the token and sets the user PIN, like "softhsm2-util --init-token"
+func initTestToken(t *testing.T, module string) {
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		t.Fatalf("unable to load %s", module)
+	}
+	defer ctx.Destroy()
+
+	err := ctx.Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//noinspection GoUnhandledErrorResult
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("no free SoftHSM slot: %v", err)
+	}
+
+	err = ctx.InitToken(slots[0], testSOPIN, testTokenLabel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SoftHSM reassigns the slot of an initialized token
+	slots, err = ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || strings.TrimSpace(info.Label) != testTokenLabel {
+			continue
+		}
+
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			t.Fatal(err)
+		}
+		//noinspection GoUnhandledErrorResult
+		defer ctx.CloseSession(session)
+
+		err = ctx.Login(session, pkcs11.CKU_SO, testSOPIN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ctx.InitPIN(session, testPIN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ctx.Logout(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	t.Fatalf("initialized token %s not found", testTokenLabel)
+}
+
+func TestCryptoContext(t *testing.T) {
+	c := newTestCryptoContext(t)
+	uid := uuid.New()
+
+	privKeyPEM, err := c.GenerateKeyForUUID(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !encrypters.IsKeyReference(privKeyPEM) {
+		t.Fatalf("generated key is not a key reference: %s", privKeyPEM)
+	}
+
+	pubKeyPEM, err := c.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("test data")
+	signature, err := c.Sign(privKeyPEM, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signature) != c.SignatureLength() {
+		t.Errorf("unexpected signature length: expected %d, got %d", c.SignatureLength(), len(signature))
+	}
+
+	verified, err := c.Verify(pubKeyPEM, data, signature)
+	if err != nil || !verified {
+		t.Errorf("signature could not be verified with the public key from the token: %v", err)
+	}
+
+	// a second key pair for the same UUID, e.g. after a key rotation, is a different key pair
+	newPrivKeyPEM, err := c.GenerateKeyForUUID(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPubKeyPEM, err := c.GetPublicKeyFromPrivateKey(newPrivKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(newPubKeyPEM) == string(pubKeyPEM) {
+		t.Error("second key pair for the same UUID has the same public key")
+	}
+}
+
+func TestCryptoContext_UPP(t *testing.T) {
+	c := newTestCryptoContext(t)
+	uid := uuid.New()
+
+	privKeyPEM, err := c.GenerateKeyForUUID(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyPEM, err := c.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &ubirch.Protocol{Crypto: c}
+
+	hash := make([]byte, c.HashLength())
+	upp, err := p.Sign(privKeyPEM, &ubirch.SignedUPP{Version: ubirch.Signed, Uuid: uid, Hint: ubirch.Binary, Payload: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := p.Verify(pubKeyPEM, upp)
+	if err != nil || !verified {
+		t.Errorf("UPP could not be verified: %v", err)
+	}
+}
+
+func TestCryptoContext_GetSignedKeyRegistration(t *testing.T) {
+	c := newTestCryptoContext(t)
+	uid := uuid.New()
+
+	privKeyPEM, err := c.GenerateKeyForUUID(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyPEM, err := c.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyRegistration, err := c.GetSignedKeyRegistration(privKeyPEM, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cert ubirch.SignedKeyRegistration
+	err = json.Unmarshal(keyRegistration, &cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedData, err := json.Marshal(cert.PubKeyInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(cert.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := c.Verify(pubKeyPEM, signedData, signature)
+	if err != nil || !verified {
+		t.Errorf("key registration could not be verified: %v", err)
+	}
+}
+
+func TestCryptoContext_GetCSR(t *testing.T) {
+	c := newTestCryptoContext(t)
+	uid := uuid.New()
+
+	privKeyPEM, err := c.GenerateKeyForUUID(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrDER, err := c.GetCSR(privKeyPEM, uid, "DE", "ubirch GmbH")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = csr.CheckSignature(); err != nil {
+		t.Errorf("CSR signature could not be verified: %v", err)
+	}
+}
+
+func TestCryptoContext_LocalKey(t *testing.T) {
+	c := newTestCryptoContext(t)
+
+	// keys which are not held by the token are handled by the ECDSA crypto context
+	privKeyPEM, err := c.ECDSACryptoContext.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyPEM, err := c.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("test data")
+	signature, err := c.Sign(privKeyPEM, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := c.Verify(pubKeyPEM, data, signature)
+	if err != nil || !verified {
+		t.Errorf("signature could not be verified: %v", err)
+	}
+}
+
+func TestCryptoContext_UnknownKey(t *testing.T) {
+	c := newTestCryptoContext(t)
+
+	_, err := c.Sign(KeyReference([]byte("unknown")), []byte("test data"))
+	if err == nil {
+		t.Error("signing with an unknown key did not fail")
+	}
+}
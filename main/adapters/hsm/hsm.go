@@ -0,0 +1,52 @@
+// Package hsm implements a ubirch.Crypto which generates the keys of the identities inside a PKCS#11 token,
+// e.g. a hardware security module, and signs with them there, so that the private keys never leave the token.
+//
+// PKCS#11 modules are shared libraries, therefore the crypto context is only available in builds with cgo.
+package hsm
+
+import (
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/ubirch/ubirch-client-go/main/adapters/encrypters"
+)
+
+// Config configures the access to the PKCS#11 token
+type Config struct {
+	Module     string // path of the PKCS#11 library of the HSM, e.g. "/usr/lib/softhsm/libsofthsm2.so"
+	TokenLabel string // label of the token which holds the keys
+	PIN        string // user PIN of the token
+}
+
+func (c Config) validate() error {
+	if c.Module == "" {
+		return fmt.Errorf("PKCS#11 module is not set")
+	}
+	if c.TokenLabel == "" {
+		return fmt.Errorf("PKCS#11 token label is not set")
+	}
+	if c.PIN == "" {
+		return fmt.Errorf("PKCS#11 user PIN is not set")
+	}
+	return nil
+}
+
+// KeyReference returns the PEM encoded reference to the key pair with the PKCS#11 ID (CKA_ID),
+// which is stored instead of a private key
+func KeyReference(id []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: encrypters.KeyReferencePEMType, Bytes: []byte(hex.EncodeToString(id))})
+}
+
+// keyID returns the PKCS#11 ID of the key pair, if the private key is a reference to a key pair in the token
+func keyID(privKeyPEM []byte) ([]byte, bool, error) {
+	if !encrypters.IsKeyReference(privKeyPEM) {
+		return nil, false, nil
+	}
+	block, _ := pem.Decode(privKeyPEM)
+	id, err := hex.DecodeString(string(block.Bytes))
+	if err != nil || len(id) == 0 {
+		return nil, true, fmt.Errorf("invalid PKCS#11 key reference: %s", block.Bytes)
+	}
+	return id, true, nil
+}
@@ -0,0 +1,63 @@
+package httphelper
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var accessLogDisabled int32
+
+// SetAccessLog enables or disables the access log, which is enabled by default.
+// Deployments with a very high throughput may disable it to reduce the log volume.
+func SetAccessLog(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&accessLogDisabled, 0)
+	} else {
+		atomic.StoreInt32(&accessLogDisabled, 1)
+	}
+}
+
+func IsAccessLogEnabled() bool {
+	return atomic.LoadInt32(&accessLogDisabled) == 0
+}
+
+// AccessLog is a middleware that logs one line per request with the method, path, UUID (if the route
+// has one), status code, response size and duration. Liveness and readiness checks are not logged.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsAccessLogEnabled() || r.URL.Path == HealthPath || r.URL.Path == ReadyPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		fields := log.Fields{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"status":   status,
+			"size":     ww.BytesWritten(),
+			"duration": time.Since(start).String(),
+		}
+		// the URL parameters are available after the request was routed
+		if uid := chi.URLParam(r, UUIDKey); uid != "" {
+			fields["uuid"] = uid
+		}
+
+		log.WithContext(r.Context()).WithFields(fields).Infof("%s %s: %d", r.Method, r.URL.Path, status)
+	})
+}
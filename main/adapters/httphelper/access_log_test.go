@@ -0,0 +1,71 @@
+package httphelper
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestAccessLog(t *testing.T) {
+	logHook := &logtest.Hook{}
+	oldHooks := log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+	defer log.StandardLogger().ReplaceHooks(oldHooks)
+	log.AddHook(RequestIDLogHook{})
+	log.AddHook(logHook)
+
+	router := NewRouter()
+	router.Post(fmt.Sprintf("/{%s}", UUIDKey), func(w http.ResponseWriter, r *http.Request) {
+		Error(uuid.Nil, w, fmt.Errorf("invalid request"), http.StatusBadRequest)
+	})
+
+	uid := uuid.New()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/"+uid.String(), nil)
+	r.Header.Set(RequestIDHeader, "access-log-request")
+	router.ServeHTTP(w, r)
+
+	entry := logHook.LastEntry()
+	if entry == nil || entry.Level != log.InfoLevel {
+		t.Fatalf("request was not logged: %v", entry)
+	}
+
+	expected := log.Fields{
+		"method":    http.MethodPost,
+		"path":      "/" + uid.String(),
+		"uuid":      uid.String(),
+		"status":    http.StatusBadRequest,
+		"size":      w.Body.Len(),
+		"requestID": "access-log-request",
+	}
+	for k, v := range expected {
+		if entry.Data[k] != v {
+			t.Errorf("unexpected %s in log entry: expected %v, got %v", k, v, entry.Data[k])
+		}
+	}
+	if entry.Data["duration"] == nil {
+		t.Errorf("duration missing in log entry: %v", entry.Data)
+	}
+
+	// health checks are not logged
+	logHook.Reset()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, HealthPath, nil))
+	if len(logHook.AllEntries()) != 0 {
+		t.Errorf("health check was logged: %v", logHook.LastEntry())
+	}
+
+	// disabled access log
+	SetAccessLog(false)
+	defer SetAccessLog(true)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/"+uid.String(), nil))
+	for _, e := range logHook.AllEntries() {
+		if e.Level == log.InfoLevel {
+			t.Errorf("request was logged with disabled access log: %v", e)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package httphelper
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ACMEChallengeAddr is the address on which the HTTP-01 challenges of the ACME CA are answered.
+// The CA always sends the challenges to port 80.
+const ACMEChallengeAddr = ":80"
+
+// newACMEManager returns a manager which obtains and renews the TLS certificates
+// for the ACME hosts from Let's Encrypt and caches them in the ACME cache directory
+func (srv *HTTPServer) newACMEManager() *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(srv.ACMEHosts...),
+		Cache:      autocert.DirCache(srv.ACMECacheDir),
+	}
+}
+
+// serveACMEChallenges answers the HTTP-01 challenges of the ACME CA until the context is canceled.
+// Other requests are redirected to HTTPS. If port 80 is not available, the certificates can still
+// be obtained with the TLS-ALPN-01 challenge on the HTTPS port.
+func serveACMEChallenges(ctx context.Context, m *autocert.Manager) {
+	server := &http.Server{
+		Addr:         ACMEChallengeAddr,
+		Handler:      m.HTTPHandler(nil),
+		ReadTimeout:  ReadTimeout,
+		WriteTimeout: WriteTimeout,
+		IdleTimeout:  IdleTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	log.Infof("answering ACME HTTP-01 challenges on %s", ACMEChallengeAddr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Warnf("unable to answer ACME HTTP-01 challenges: %v", err)
+	}
+}
+
+// setUpACME makes the TLS configuration get the certificates from the ACME manager
+// and accept the TLS-ALPN-01 challenges of the ACME CA
+func setUpACME(tlsConfig *tls.Config, m *autocert.Manager) {
+	tlsConfig.GetCertificate = m.GetCertificate
+	tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+}
@@ -0,0 +1,45 @@
+package httphelper
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+const (
+	AdminPath        = "/admin"
+	AdminTokenHeader = "X-Admin-Token"
+)
+
+// RequireAdmin returns a middleware that rejects requests with 401, if the "X-Admin-Token"
+// header does not match the admin token. The tokens are compared in constant time.
+func RequireAdmin(adminToken string) func(http.Handler) http.Handler {
+	expectedHash := sha256.Sum256([]byte(adminToken))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actualHash := sha256.Sum256([]byte(r.Header.Get(AdminTokenHeader)))
+			if adminToken == "" || subtle.ConstantTimeCompare(expectedHash[:], actualHash[:]) != 1 {
+				Error(uuid.Nil, w, fmt.Errorf("invalid admin token"), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SetUpAdmin mounts the admin router for privileged operations under AdminPath. All requests to the
+// admin router require the admin token in the "X-Admin-Token" header. If the admin token is empty,
+// the admin router is not mounted and the privileged operations are not available.
+func (srv *HTTPServer) SetUpAdmin(adminToken string) {
+	srv.Admin = chi.NewRouter()
+	srv.Admin.Use(RequireAdmin(adminToken))
+
+	if adminToken != "" {
+		srv.Router.Mount(AdminPath, srv.Admin)
+	}
+}
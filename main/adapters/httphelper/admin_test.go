@@ -0,0 +1,66 @@
+package httphelper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPServer_SetUpAdmin(t *testing.T) {
+	const adminToken = "admin-token"
+
+	tests := []struct {
+		name           string
+		adminToken     string
+		header         map[string]string
+		expectedStatus int
+	}{
+		{
+			name:           "missing admin token",
+			adminToken:     adminToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong admin token",
+			adminToken:     adminToken,
+			header:         map[string]string{AdminTokenHeader: "wrong"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "device auth token is not accepted",
+			adminToken:     adminToken,
+			header:         map[string]string{"X-Auth-Token": adminToken},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "correct admin token",
+			adminToken:     adminToken,
+			header:         map[string]string{AdminTokenHeader: adminToken},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "admin API disabled",
+			header:         map[string]string{AdminTokenHeader: ""},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := &HTTPServer{Router: NewRouter()}
+			srv.SetUpAdmin(test.adminToken)
+			srv.Admin.Get("/test", Health("test"))
+
+			r := httptest.NewRequest(http.MethodGet, AdminPath+"/test", nil)
+			for k, v := range test.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			srv.Router.ServeHTTP(w, r)
+
+			if w.Code != test.expectedStatus {
+				t.Errorf("unexpected response code: expected %d, got %d", test.expectedStatus, w.Code)
+			}
+		})
+	}
+}
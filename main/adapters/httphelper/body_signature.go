@@ -0,0 +1,85 @@
+package httphelper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	SignatureHeader = "X-Signature"
+
+	signaturePrefix = "sha256="
+)
+
+// ErrInvalidSignature is returned if the HMAC in the X-Signature header does not match the request body
+var ErrInvalidSignature = fmt.Errorf("invalid request signature")
+
+// BodySignature computes the HMAC-SHA256 over the raw request body, i.e. the body as it was sent before it is
+// decompressed, while the body is read, so that the body does not have to be kept in memory
+type BodySignature struct {
+	body     io.ReadCloser
+	mac      hash.Hash
+	expected []byte
+}
+
+// NewBodySignature replaces the body of the request by a reader which computes the HMAC-SHA256 over the
+// body with the given secret. The values of the signed headers are included in the HMAC before the body,
+// each followed by a newline, so that they can not be altered either. The expected HMAC is the hex encoded
+// HMAC in the X-Signature header, optionally prefixed with "sha256=". Returns ErrInvalidSignature if the
+// header is missing or malformed.
+func NewBodySignature(r *http.Request, secret []byte, signedHeaders ...string) (*BodySignature, error) {
+	signature := strings.TrimPrefix(strings.TrimSpace(r.Header.Get(SignatureHeader)), signaturePrefix)
+	if signature == "" {
+		return nil, fmt.Errorf("%v: missing %s header", ErrInvalidSignature, SignatureHeader)
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil || len(expected) != sha256.Size {
+		return nil, fmt.Errorf("%v: expected hex encoded HMAC-SHA256 in %s header", ErrInvalidSignature, SignatureHeader)
+	}
+
+	s := &BodySignature{
+		body:     r.Body,
+		mac:      hmac.New(sha256.New, secret),
+		expected: expected,
+	}
+	if s.body == nil {
+		s.body = http.NoBody
+	}
+	for _, header := range signedHeaders {
+		s.mac.Write([]byte(r.Header.Get(header) + "\n"))
+	}
+	r.Body = s
+	return s, nil
+}
+
+func (s *BodySignature) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	s.mac.Write(p[:n])
+	return n, err
+}
+
+func (s *BodySignature) Close() error {
+	return s.body.Close()
+}
+
+// Verify reads the rest of the body and compares the HMAC over the body with the expected HMAC in
+// constant time. Returns ErrInvalidSignature if they do not match.
+func (s *BodySignature) Verify() error {
+	_, err := io.Copy(ioutil.Discard, s)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(s.mac.Sum(nil), s.expected) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
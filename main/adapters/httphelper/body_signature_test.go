@@ -0,0 +1,113 @@
+package httphelper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBodySignature(t *testing.T) {
+	secret := []byte("shared secret")
+	data := []byte(`{"id": 1}`)
+
+	// the HMAC is computed over the body as it was sent, i.e. over the compressed body
+	compressed := &bytes.Buffer{}
+	gz := gzip.NewWriter(compressed)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(compressed.Bytes())
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed.Bytes()))
+	r.Header.Set("Content-Encoding", GzipEncoding)
+	r.Header.Set(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+
+	signature, err := NewBodySignature(r, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ReadBody(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, data) {
+		t.Errorf("unexpected body: %s", body)
+	}
+	if err = signature.Verify(); err != nil {
+		t.Errorf("valid signature was rejected: %v", err)
+	}
+
+	// the part of the body which was not read yet is included
+	plainMAC := hmac.New(sha256.New, secret)
+	plainMAC.Write(data)
+
+	r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	r.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(plainMAC.Sum(nil)))
+
+	signature, err = NewBodySignature(r, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Body.Read(make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err = signature.Verify(); err != nil {
+		t.Errorf("valid signature of partially read body was rejected: %v", err)
+	}
+
+	// the signature of the compressed body does not match the uncompressed body
+	r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	r.Header.Set(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+
+	signature, err = NewBodySignature(r, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(r.Body); err != nil {
+		t.Fatal(err)
+	}
+	if err = signature.Verify(); err != ErrInvalidSignature {
+		t.Errorf("unexpected error for invalid signature: %v", err)
+	}
+	if BodyErrorCode(err) != http.StatusUnauthorized {
+		t.Errorf("unexpected response code for invalid signature: %d", BodyErrorCode(err))
+	}
+
+	// the values of the signed headers are included before the body
+	headerMAC := hmac.New(sha256.New, secret)
+	headerMAC.Write([]byte("1622588400\nnonce\n"))
+	headerMAC.Write(data)
+
+	for header, expected := range map[string]error{"nonce": nil, "other nonce": ErrInvalidSignature} {
+		r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+		r.Header.Set(SignatureHeader, hex.EncodeToString(headerMAC.Sum(nil)))
+		r.Header.Set("X-Timestamp", "1622588400")
+		r.Header.Set("X-Nonce", header)
+
+		signature, err = NewBodySignature(r, secret, "X-Timestamp", "X-Nonce")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = signature.Verify(); err != expected {
+			t.Errorf("%s: unexpected result of verification: %v", header, err)
+		}
+	}
+
+	for _, header := range []string{"", "sha256=", "invalid", hex.EncodeToString([]byte("too short"))} {
+		r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+		r.Header.Set(SignatureHeader, header)
+		if _, err = NewBodySignature(r, secret); err == nil {
+			t.Errorf("invalid signature header was accepted: %q", header)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package httphelper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	CanonicalizationHeader = "X-JSON-Canonicalization"
+
+	LegacyCanonicalization = "legacy" // sorted keys and compact, numbers as formatted by encoding/json
+	JCSCanonicalization    = "jcs"    // JSON Canonicalization Scheme (RFC 8785)
+	NoCanonicalization     = "none"   // the JSON data is hashed as-is
+
+	DefaultCanonicalization = LegacyCanonicalization
+)
+
+// Canonicalization is a scheme which is applied to JSON original data before it is hashed,
+// so that semantically equal JSON documents result in the same hash
+type Canonicalization struct {
+	Name         string
+	canonicalize func(data []byte) ([]byte, error)
+}
+
+// Canonicalize returns the canonical representation of the JSON data.
+// The zero value applies the default canonicalization.
+func (c Canonicalization) Canonicalize(data []byte) ([]byte, error) {
+	if c.canonicalize == nil {
+		return GetSortedCompactJSON(data)
+	}
+	return c.canonicalize(data)
+}
+
+var canonicalizations = map[string]Canonicalization{
+	LegacyCanonicalization: {
+		Name:         LegacyCanonicalization,
+		canonicalize: GetSortedCompactJSON,
+	},
+	JCSCanonicalization: {
+		Name:         JCSCanonicalization,
+		canonicalize: GetJCS,
+	},
+	NoCanonicalization: {
+		Name:         NoCanonicalization,
+		canonicalize: validJSON,
+	},
+}
+
+// GetCanonicalization returns the canonicalization with the given name
+// or the default canonicalization (legacy) if the name is empty
+func GetCanonicalization(name string) (Canonicalization, error) {
+	if name == "" {
+		name = DefaultCanonicalization
+	}
+
+	c, found := canonicalizations[strings.ToLower(name)]
+	if !found {
+		return Canonicalization{}, fmt.Errorf("unknown canonicalization: "+
+			"expected (\"%s\" | \"%s\" | \"%s\"), got \"%s\"", LegacyCanonicalization, JCSCanonicalization, NoCanonicalization, name)
+	}
+	return c, nil
+}
+
+// RequestCanonicalization returns the canonicalization selected by the "X-JSON-Canonicalization" request header.
+// If the header is not set, the given default canonicalization is returned.
+func RequestCanonicalization(header http.Header, defaultCanon Canonicalization) (Canonicalization, error) {
+	name := header.Get(CanonicalizationHeader)
+	if name == "" {
+		return defaultCanon, nil
+	}
+	return GetCanonicalization(name)
+}
+
+// validJSON returns the JSON data unchanged, so that the exact bytes of the request body are hashed
+func validJSON(data []byte) ([]byte, error) {
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("unable to parse JSON request body: invalid JSON")
+	}
+	return data, nil
+}
+
+// GetJCS returns the canonical representation of the JSON data according to the
+// JSON Canonicalization Scheme (RFC 8785). In contrast to GetSortedCompactJSON, the keys
+// are sorted by their UTF-16 code units, numbers are serialized like ECMAScript does and
+// only the characters which must be escaped in JSON strings are escaped.
+func GetJCS(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var v interface{}
+	err := decoder.Decode(&v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse JSON request body: %v", err)
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unable to parse JSON request body: unexpected data after top-level value")
+	}
+
+	var buf bytes.Buffer
+	err = writeJCS(&buf, v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to canonicalize JSON object: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJCS(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %s: %v", v, err)
+		}
+		n, err := formatJCSNumber(f)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(n)
+	case string:
+		writeJCSString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := writeJCS(buf, elem)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJCSString(buf, k)
+			buf.WriteByte(':')
+			err := writeJCS(buf, v[k])
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected JSON type %T", v)
+	}
+	return nil
+}
+
+// formatJCSNumber serializes the number like ECMAScript's Number.prototype.toString
+func formatJCSNumber(f float64) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("invalid number: %v", f)
+	}
+	if f == 0 {
+		return "0", nil // also for -0
+	}
+
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+
+	// shortest representation which round-trips: d.ddde±x
+	parts := strings.SplitN(strconv.FormatFloat(f, 'e', -1, 64), "e", 2)
+	digits := strings.Replace(parts[0], ".", "", 1)
+	e, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	k := len(digits)
+	n := e + 1 // position of the decimal point relative to the digits
+
+	switch {
+	case k <= n && n <= 21:
+		return sign + digits + strings.Repeat("0", n-k), nil
+	case 0 < n && n <= 21:
+		return sign + digits[:n] + "." + digits[n:], nil
+	case -6 < n && n <= 0:
+		return sign + "0." + strings.Repeat("0", -n) + digits, nil
+	}
+
+	expSign := "+"
+	if n-1 < 0 {
+		expSign = "-"
+	}
+	expAbs := strconv.Itoa(int(math.Abs(float64(n - 1))))
+
+	if k == 1 {
+		return sign + digits + "e" + expSign + expAbs, nil
+	}
+	return sign + digits[:1] + "." + digits[1:] + "e" + expSign + expAbs, nil
+}
+
+// writeJCSString writes the string in quotes and escapes only quotation mark, reverse solidus and control characters
+func writeJCSString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// lessUTF16 compares the strings by their UTF-16 code units
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
@@ -0,0 +1,227 @@
+package httphelper
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// test vectors from RFC 8785
+func TestGetJCS(t *testing.T) {
+	var tests = []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "sample (RFC 8785, section 3.2.2)",
+			input: `{
+  "numbers": [333333333.33333329, 1E30, 4.50,
+              2e-3, 0.000000000000000000000000001],
+  "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+  "literals": [null, true, false]
+}`,
+			expected: `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		{
+			name: "sorting of properties (RFC 8785, section 3.2.3)",
+			input: `{
+  "\u20ac": "Euro Sign",
+  "\r": "Carriage Return",
+  "\ufb33": "Hebrew Letter Dalet With Dagesh",
+  "1": "One",
+  "\ud83d\ude00": "Emoji: Grinning Face",
+  "\u0080": "Control",
+  "\u00f6": "Latin Small Letter O With Diaeresis"
+}`,
+			expected: "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"ö\":\"Latin Small Letter O With Diaeresis\"," +
+				"\"€\":\"Euro Sign\",\"😀\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+		},
+		{
+			name:     "nested",
+			input:    ` [ {"b": [1.0, {"d": -0, "c": 1e21}], "a": "<&>"} ] `,
+			expected: `[{"a":"<&>","b":[1,{"c":1e+21,"d":0}]}]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := GetJCS([]byte(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != test.expected {
+				t.Errorf("unexpected output:\n- expected: %s\n-      got: %s", test.expected, out)
+			}
+		})
+	}
+}
+
+// number serialization test vectors from RFC 8785, appendix B
+func TestFormatJCSNumber(t *testing.T) {
+	var tests = []struct {
+		bits     uint64
+		expected string
+	}{
+		{0x0000000000000000, "0"},
+		{0x8000000000000000, "0"},
+		{0x0000000000000001, "5e-324"},
+		{0x8000000000000001, "-5e-324"},
+		{0x7fefffffffffffff, "1.7976931348623157e+308"},
+		{0xffefffffffffffff, "-1.7976931348623157e+308"},
+		{0x4340000000000000, "9007199254740992"},
+		{0xc340000000000000, "-9007199254740992"},
+		{0x4430000000000000, "295147905179352830000"},
+		{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+		{0x44b52d02c7e14af6, "1e+23"},
+		{0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+		{0x444b1ae4d6e2ef4e, "999999999999999700000"},
+		{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+		{0x444b1ae4d6e2ef50, "1e+21"},
+		{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+		{0x3eb0c6f7a0b5ed8d, "0.000001"},
+		{0x41b3de4355555553, "333333333.3333332"},
+		{0x41b3de4355555554, "333333333.33333325"},
+		{0x41b3de4355555555, "333333333.3333333"},
+		{0x41b3de4355555556, "333333333.3333334"},
+		{0x41b3de4355555557, "333333333.33333343"},
+		{0xbecbf647612f3696, "-0.0000033333333333333333"},
+		{0x43143ff3c1cb0959, "1424953923781206.2"},
+	}
+
+	for _, test := range tests {
+		out, err := formatJCSNumber(math.Float64frombits(test.bits))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != test.expected {
+			t.Errorf("%016x: expected %s, got %s", test.bits, test.expected, out)
+		}
+	}
+
+	for _, invalid := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := formatJCSNumber(invalid); err == nil {
+			t.Errorf("invalid number %v was serialized", invalid)
+		}
+	}
+}
+
+func TestGetCanonicalization(t *testing.T) {
+	for _, name := range []string{"", LegacyCanonicalization, JCSCanonicalization, "JCS"} {
+		if _, err := GetCanonicalization(name); err != nil {
+			t.Errorf("canonicalization %q was rejected: %v", name, err)
+		}
+	}
+	if _, err := GetCanonicalization("xml"); err == nil {
+		t.Error("unknown canonicalization was accepted")
+	}
+
+	// the legacy canonicalization sorts the keys by their UTF-8 bytes and escapes line and paragraph separators
+	input := []byte(`{"\ufb33": 1, "\ud83d\ude00": 2, "s": "\u2028"}`)
+
+	legacy, err := Canonicalization{}.Canonicalize(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{\"s\":\"\\u2028\",\"\ufb33\":1,\"😀\":2}"; string(legacy) != expected {
+		t.Errorf("unexpected legacy output: expected %s, got %s", expected, legacy)
+	}
+
+	jcs, err := canonicalizations[JCSCanonicalization].Canonicalize(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{\"s\":\"\u2028\",\"😀\":2,\"\ufb33\":1}"; string(jcs) != expected {
+		t.Errorf("unexpected JCS output: expected %s, got %s", expected, jcs)
+	}
+}
+
+func TestGetHash_NoCanonicalization(t *testing.T) {
+	alg, err := GetHashAlgorithm(SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	none, err := GetCanonicalization(NoCanonicalization)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := "{\"z\": 1,\n \"a\": [2, 1]}\n"
+	verbatim := alg.Sum([]byte(body))
+	sorted := alg.Sum([]byte(`{"a":[2,1],"z":1}`))
+
+	var tests = []struct {
+		name         string
+		defaultCanon Canonicalization
+		header       string
+		body         string
+		expectedHash Hash
+		expectErr    bool
+	}{
+		{
+			name:         "passthrough selected by header",
+			header:       NoCanonicalization,
+			body:         body,
+			expectedHash: verbatim,
+		},
+		{
+			name:         "passthrough by default",
+			defaultCanon: none,
+			body:         body,
+			expectedHash: verbatim,
+		},
+		{
+			name:         "sorted by default",
+			body:         body,
+			expectedHash: sorted,
+		},
+		{
+			name:         "sorted selected by header",
+			defaultCanon: none,
+			header:       LegacyCanonicalization,
+			body:         body,
+			expectedHash: sorted,
+		},
+		{
+			name:      "invalid JSON",
+			header:    NoCanonicalization,
+			body:      `{"z": 1`,
+			expectErr: true,
+		},
+		{
+			name:      "unknown canonicalization",
+			header:    "unknown",
+			body:      body,
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/"+uuid.NewString(), strings.NewReader(test.body))
+			r.Header.Set("Content-Type", JSONType)
+			if test.header != "" {
+				r.Header.Set(CanonicalizationHeader, test.header)
+			}
+
+			hash, err := GetHash(r, alg, test.defaultCanon)
+			if test.expectErr {
+				if err == nil {
+					t.Error("invalid request was accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(hash, test.expectedHash) {
+				t.Errorf("unexpected hash: expected %x, got %x", test.expectedHash, hash)
+			}
+		})
+	}
+}
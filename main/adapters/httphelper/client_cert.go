@@ -0,0 +1,57 @@
+package httphelper
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// LoadClientCAs loads the PEM encoded CA certificates from a file, which are
+// used to verify the client certificates of TLS connections
+func LoadClientCAs(file string) (*x509.CertPool, error) {
+	caPEM, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client CA file: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no PEM encoded certificates found in client CA file %s", file)
+	}
+	return pool, nil
+}
+
+// ClientCertUUIDs returns the UUIDs which are named in the subject common name,
+// the DNS names or the URIs (e.g. "urn:uuid:<UUID>") of a client certificate
+func ClientCertUUIDs(cert *x509.Certificate) []uuid.UUID {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+
+	var uids []uuid.UUID
+	for _, name := range names {
+		if uid, err := uuid.Parse(name); err == nil {
+			uids = append(uids, uid)
+		}
+	}
+	return uids
+}
+
+// HasClientCertFor returns true if the request was sent with a client certificate, which was
+// verified against the client CAs during the TLS handshake and was issued for the given UUID
+func HasClientCertFor(r *http.Request, uid uuid.UUID) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return false
+	}
+
+	for _, certUID := range ClientCertUUIDs(r.TLS.VerifiedChains[0][0]) {
+		if certUID == uid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package httphelper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestHTTPServer_ClientCert(t *testing.T) {
+	uid := uuid.New()
+
+	ca, caKey := newTestCA(t)
+	otherCA, otherCAKey := newTestCA(t)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !HasClientCertFor(r, uid) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+
+	tests := []struct {
+		name              string
+		requireClientCert bool
+		clientCert        *tls.Certificate
+		expectedStatus    int // 0 if the TLS handshake is expected to fail
+	}{
+		{
+			name:           "client certificate",
+			clientCert:     newTestClientCert(t, ca, caKey, uid.String()),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "client certificate of other UUID",
+			clientCert:     newTestClientCert(t, ca, caKey, uuid.NewString()),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "no client certificate",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:              "no client certificate but required",
+			requireClientCert: true,
+		},
+		{
+			name:       "client certificate of unknown CA",
+			clientCert: newTestClientCert(t, otherCA, otherCAKey, uid.String()),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := &HTTPServer{TLS: true, ClientCAFile: caFile, RequireClientCert: test.requireClientCert}
+			tlsConfig, err := srv.tlsConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := httptest.NewUnstartedServer(handler)
+			server.TLS = tlsConfig
+			server.StartTLS()
+			defer server.Close()
+
+			client := server.Client()
+			if test.clientCert != nil {
+				client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{*test.clientCert}
+			}
+
+			resp, err := client.Get(server.URL)
+			if test.expectedStatus == 0 {
+				if err == nil {
+					_ = resp.Body.Close()
+					t.Fatalf("TLS handshake succeeded: %d", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != test.expectedStatus {
+				t.Errorf("unexpected response code: expected %d, got %d", test.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestLoadClientCAs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	err := ioutil.WriteFile(file, []byte("not a PEM"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadClientCAs(file); err == nil {
+		t.Error("no error for file without certificates")
+	}
+	if _, err := LoadClientCAs(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("no error for missing file")
+	}
+}
+
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func newTestClientCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, commonName string) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
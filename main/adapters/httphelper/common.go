@@ -2,7 +2,6 @@ package httphelper
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -26,95 +25,159 @@ const (
 	WriteTimeout          = 99 * time.Second // time after which the connection will be closed if response was not written -> this should never happen
 	IdleTimeout           = 60 * time.Second // time to wait for the next request when keep-alives are enabled
 
+	DefaultAddr = ":8080" // TCP address the server listens on if no address is set
+
+	UnixSocketPrefix = "unix:" // prefix of addresses of Unix domain sockets, e.g. "unix:/run/ubirch/client.sock"
+	UnixSocketMode   = 0660    // file permissions of the Unix domain socket
+
 	UUIDKey          = "uuid"
 	OperationKey     = "operation"
 	VerifyPath       = "verify"
+	AnchoredEndpoint = "anchored"
+	InspectPath      = "inspect"
 	HashEndpoint     = "hash"
+	BatchEndpoint    = "batch"
+	KeyEndpoint      = "key"
+	ImportEndpoint   = "import"
+	GenerateEndpoint = "generate"
+	RotateEndpoint   = "rotate"
+	CSREndpoint      = "csr"
 	RegisterEndpoint = "register"
+	ChainEndpoint    = "chain"
+	ResetPath        = "reset"
 
-	BinType  = "application/octet-stream"
-	TextType = "text/plain"
-	JSONType = "application/json"
+	BinType     = "application/octet-stream"
+	TextType    = "text/plain"
+	JSONType    = "application/json"
+	PEMType     = "application/x-pem-file"
+	MsgpackType = "application/msgpack"
+	CBORType    = "application/cbor"
 
-	HexEncoding = "hex"
+	HexEncoding    = "hex"
+	Base64Encoding = "base64"
 
-	HashLen = 32
+	BearerPrefix = "Bearer "
+
+	HashLen = 32 // length of a SHA-256 hash
 )
 
 type HTTPRequest struct {
 	ID   uuid.UUID
 	Auth string
-	Hash Sha256Sum
+	Hash Hash
+	Hint *uint8 // custom hint of the UPP, if set it replaces the hint of the operation
 }
 
-type Sha256Sum [HashLen]byte
+// Hash is the digest of the original data, which is the payload of the UPP.
+// Its length depends on the selected hash algorithm.
+type Hash []byte
+
+// GetHash returns the hash from the request body. If the request contains original data,
+// it is hashed with the given algorithm. JSON data is canonicalized before, with the canonicalization
+// selected by the request header or the given default canonicalization. If the request contains
+// a hash, its length must match the digest size of the given algorithm.
+//
+// Binary original data is hashed while the body is read, without buffering it. Other requests are
+// read into memory, since JSON data must be canonicalized and hashes are short.
+func GetHash(r *http.Request, alg HashAlgorithm, canon Canonicalization) (Hash, error) {
+	if !IsHashRequest(r) && ContentType(r.Header) == BinType && ContentEncoding(r.Header) != Base64Encoding {
+		return getHashFromBinaryBody(r, alg)
+	}
 
-// GetHash returns the hash from the request body
-func GetHash(r *http.Request) (Sha256Sum, error) {
 	rBody, err := ReadBody(r)
 	if err != nil {
-		return Sha256Sum{}, err
+		return nil, err
 	}
 
 	if IsHashRequest(r) { // request contains hash
-		return getHashFromHashRequest(r.Header, rBody)
+		return getHashFromHashRequest(r.Header, rBody, alg)
 	} else { // request contains original data
-		return getHashFromDataRequest(r.Header, rBody)
+		return getHashFromDataRequest(r.Header, rBody, alg, canon)
 	}
 }
 
-func getHashFromDataRequest(header http.Header, data []byte) (hash Sha256Sum, err error) {
+func getHashFromDataRequest(header http.Header, data []byte, alg HashAlgorithm, canon Canonicalization) (hash Hash, err error) {
+	// original data may be base64 encoded for transports which only support text
+	if ContentEncoding(header) == Base64Encoding {
+		data, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil {
+			return nil, fmt.Errorf("decoding base64 encoded original data failed: %v", err)
+		}
+	}
+
 	switch ContentType(header) {
 	case JSONType:
-		data, err = GetSortedCompactJSON(data)
+		canon, err = RequestCanonicalization(header, canon)
+		if err != nil {
+			return nil, err
+		}
+		data, err = canon.Canonicalize(data)
 		if err != nil {
-			return Sha256Sum{}, err
+			return nil, err
 		}
-		log.Debugf("sorted compact JSON: %s", string(data))
+		log.Debugf("canonical JSON: %s", string(data))
 
 		fallthrough
 	case BinType:
 		// hash original data
-		return sha256.Sum256(data), nil
+		return alg.Sum(data), nil
 	default:
-		return Sha256Sum{}, fmt.Errorf("invalid content-type for original data: "+
+		return nil, fmt.Errorf("invalid content-type for original data: "+
 			"expected (\"%s\" | \"%s\")", BinType, JSONType)
 	}
 }
 
-func getHashFromHashRequest(header http.Header, data []byte) (hash Sha256Sum, err error) {
+func getHashFromBinaryBody(r *http.Request, alg HashAlgorithm) (Hash, error) {
+	body, err := requestBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := alg.SumReader(body)
+	if err == ErrBodyTooLarge {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read request body: %v", err)
+	}
+	return hash, nil
+}
+
+func getHashFromHashRequest(header http.Header, data []byte, alg HashAlgorithm) (hash Hash, err error) {
 	switch ContentType(header) {
 	case TextType:
 		if ContentEncoding(header) == HexEncoding {
 			data, err = hex.DecodeString(string(data))
 			if err != nil {
-				return Sha256Sum{}, fmt.Errorf("decoding hex encoded hash failed: %v (%s)", err, string(data))
+				return nil, fmt.Errorf("decoding hex encoded hash failed: %v (%s)", err, string(data))
 			}
 		} else {
 			data, err = base64.StdEncoding.DecodeString(string(data))
 			if err != nil {
-				return Sha256Sum{}, fmt.Errorf("decoding base64 encoded hash failed: %v (%s)", err, string(data))
+				return nil, fmt.Errorf("decoding base64 encoded hash failed: %v (%s)", err, string(data))
 			}
 		}
 		fallthrough
 	case BinType:
-		if len(data) != HashLen {
-			return Sha256Sum{}, fmt.Errorf("invalid SHA256 hash size: "+
-				"expected %d bytes, got %d bytes", HashLen, len(data))
+		if len(data) != alg.Size {
+			return nil, fmt.Errorf("invalid %s hash size: "+
+				"expected %d bytes, got %d bytes", alg.Name, alg.Size, len(data))
 		}
 
-		copy(hash[:], data)
-		return hash, nil
+		return data, nil
 	default:
-		return Sha256Sum{}, fmt.Errorf("invalid content-type for hash: "+
+		return nil, fmt.Errorf("invalid content-type for hash: "+
 			"expected (\"%s\" | \"%s\")", BinType, TextType)
 	}
 }
 
 // forwards response to sender
 func SendResponse(w http.ResponseWriter, resp HTTPResponse) {
-	for k, v := range resp.Header {
-		w.Header().Set(k, v[0])
+	for k, values := range resp.Header {
+		w.Header().Del(k)
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
 	}
 	w.WriteHeader(resp.StatusCode)
 	_, err := w.Write(resp.Content)
@@ -144,6 +207,15 @@ func AuthToken(header http.Header) string {
 	return header.Get("X-Auth-Token")
 }
 
+// helper function to get the bearer token from the "Authorization" request header
+func BearerToken(header http.Header) string {
+	auth := header.Get("Authorization")
+	if len(auth) > len(BearerPrefix) && strings.EqualFold(auth[:len(BearerPrefix)], BearerPrefix) {
+		return strings.TrimSpace(auth[len(BearerPrefix):])
+	}
+	return ""
+}
+
 // getUUID returns the UUID parameter from the request URL
 func GetUUID(r *http.Request) (uuid.UUID, error) {
 	uuidParam := chi.URLParam(r, UUIDKey)
@@ -154,8 +226,17 @@ func GetUUID(r *http.Request) (uuid.UUID, error) {
 	return id, nil
 }
 
+// ReadBody reads the request body into memory. A gzip encoded body is decompressed.
 func ReadBody(r *http.Request) ([]byte, error) {
-	rBody, err := ioutil.ReadAll(r.Body)
+	body, err := requestBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rBody, err := ioutil.ReadAll(body)
+	if err == ErrBodyTooLarge {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to read request body: %v", err)
 	}
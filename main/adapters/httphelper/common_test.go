@@ -0,0 +1,190 @@
+package httphelper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSendResponse(t *testing.T) {
+	resp := HTTPResponse{
+		StatusCode: http.StatusCreated,
+		Header: http.Header{
+			"Content-Type": {"application/json"},
+			"X-Request-Id": {"1234"},
+			"Set-Cookie":   {"a=1", "b=2"},
+		},
+		Content: []byte("{}"),
+	}
+
+	w := httptest.NewRecorder()
+	SendResponse(w, resp)
+
+	if w.Code != resp.StatusCode {
+		t.Errorf("unexpected response code: expected %d, got %d", resp.StatusCode, w.Code)
+	}
+	for k, v := range resp.Header {
+		if !reflect.DeepEqual(w.Result().Header[k], v) {
+			t.Errorf("header %s did not reach the client: expected %v, got %v", k, v, w.Result().Header[k])
+		}
+	}
+	if w.Body.String() != string(resp.Content) {
+		t.Errorf("unexpected response content: %s", w.Body.String())
+	}
+}
+
+func TestGetHash_Base64Data(t *testing.T) {
+	alg, err := GetHashAlgorithm(SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binData := []byte{0x00, 0xff, 0x10, 0x80}
+	jsonData := []byte(`{"b":2,"a":1}`)
+
+	var tests = []struct {
+		name         string
+		contentType  string
+		body         string
+		expectedHash Hash
+		expectErr    bool
+	}{
+		{
+			name:         "binary",
+			contentType:  BinType,
+			body:         base64.StdEncoding.EncodeToString(binData),
+			expectedHash: alg.Sum(binData),
+		},
+		{
+			name:         "JSON",
+			contentType:  JSONType,
+			body:         base64.StdEncoding.EncodeToString(jsonData) + "\n",
+			expectedHash: alg.Sum([]byte(`{"a":1,"b":2}`)),
+		},
+		{
+			name:        "invalid base64",
+			contentType: BinType,
+			body:        "not base64!",
+			expectErr:   true,
+		},
+		{
+			name:        "invalid JSON",
+			contentType: JSONType,
+			body:        base64.StdEncoding.EncodeToString([]byte("not JSON")),
+			expectErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/"+uuid.NewString(), strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			r.Header.Set("Content-Transfer-Encoding", Base64Encoding)
+
+			hash, err := GetHash(r, alg, Canonicalization{})
+			if test.expectErr {
+				if err == nil {
+					t.Error("invalid request was accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(hash, test.expectedHash) {
+				t.Errorf("unexpected hash: expected %x, got %x", test.expectedHash, hash)
+			}
+		})
+	}
+}
+
+func TestGetHash_StreamedBinaryData(t *testing.T) {
+	alg, err := GetHashAlgorithm(SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const bodySize = 64 << 20
+	const maxAlloc = 1 << 20 // the body must not be buffered
+
+	expected := sha256.New()
+	_, err = io.Copy(expected, testDataReader(bodySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/"+uuid.NewString(), testDataReader(bodySize))
+	r.Header.Set("Content-Type", BinType)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	hash, err := GetHash(r, alg, Canonicalization{})
+
+	runtime.ReadMemStats(&after)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hash, expected.Sum(nil)) {
+		t.Errorf("unexpected hash: expected %x, got %x", expected.Sum(nil), hash)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > maxAlloc {
+		t.Errorf("hashing a body of %d bytes allocated %d bytes", bodySize, alloc)
+	}
+}
+
+func TestGetHash_BodyTooLarge(t *testing.T) {
+	alg, err := GetHashAlgorithm(SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, contentType := range []string{BinType, JSONType} {
+		handler := MaxBodySize(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := GetHash(r, alg, Canonicalization{})
+			if err != nil {
+				Error(uuid.Nil, w, err, BodyErrorCode(err))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		r := httptest.NewRequest(http.MethodPost, "/"+uuid.NewString(), testDataReader(2048))
+		r.ContentLength = -1 // the body size is not declared
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: unexpected response code: expected %d, got %d", contentType, http.StatusRequestEntityTooLarge, w.Code)
+		}
+	}
+}
+
+// testDataReader returns a reader of n bytes of test data, which does not hold the data in memory
+func testDataReader(n int64) io.Reader {
+	return io.LimitReader(&patternReader{}, n)
+}
+
+type patternReader struct {
+	i byte
+}
+
+func (p *patternReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = p.i
+		p.i++
+	}
+	return len(b), nil
+}
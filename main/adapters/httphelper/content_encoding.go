@@ -0,0 +1,86 @@
+package httphelper
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	GzipEncoding     = "gzip"
+	IdentityEncoding = "identity"
+
+	// defaultMaxDecompressedSize limits the size of decompressed request bodies,
+	// if the maximum body size is not set by the MaxBodySize middleware
+	defaultMaxDecompressedSize = 32 << 20
+
+	// compressionLevel is the gzip level of compressed responses
+	compressionLevel = flate.DefaultCompression
+)
+
+// ErrUnsupportedContentEncoding is returned when reading a request body with a content encoding other than gzip
+var ErrUnsupportedContentEncoding = fmt.Errorf("unsupported content encoding: expected (\"%s\" | \"%s\")",
+	GzipEncoding, IdentityEncoding)
+
+type maxBodySizeKey struct{}
+
+func withMaxBodySize(ctx context.Context, limit int64) context.Context {
+	return context.WithValue(ctx, maxBodySizeKey{}, limit)
+}
+
+// maxBodySize returns the maximum size of the request body, which also limits the size of the decompressed body
+func maxBodySize(r *http.Request) int64 {
+	if limit, ok := r.Context().Value(maxBodySizeKey{}).(int64); ok {
+		return limit
+	}
+	return defaultMaxDecompressedSize
+}
+
+// helper function to get "Content-Encoding" from request header
+func RequestContentEncoding(header http.Header) string {
+	return strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+}
+
+// requestBody returns a reader of the request body, which decompresses a gzip encoded body ("Content-Encoding: gzip").
+// The size of the decompressed body is limited to the maximum body size, so that a small compressed body
+// can not be expanded into a huge body in memory. Reading a larger decompressed body fails with ErrBodyTooLarge.
+func requestBody(r *http.Request) (io.Reader, error) {
+	switch RequestContentEncoding(r.Header) {
+	case "", IdentityEncoding:
+		return r.Body, nil
+	case GzipEncoding:
+		gz, err := gzip.NewReader(r.Body)
+		if err == ErrBodyTooLarge {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress request body: %v", err)
+		}
+		return &decompressedBodyReader{Reader: gz, limit: maxBodySize(r)}, nil
+	default:
+		return nil, ErrUnsupportedContentEncoding
+	}
+}
+
+type decompressedBodyReader struct {
+	io.Reader
+	limit int64
+	read  int64
+}
+
+func (d *decompressedBodyReader) Read(p []byte) (int, error) {
+	n, err := d.Reader.Read(p)
+	d.read += int64(n)
+
+	if d.read > d.limit {
+		return n, ErrBodyTooLarge
+	}
+	if err != nil && err != io.EOF && err != ErrBodyTooLarge {
+		err = fmt.Errorf("unable to decompress request body: %v", err)
+	}
+	return n, err
+}
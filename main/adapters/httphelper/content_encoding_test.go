@@ -0,0 +1,68 @@
+package httphelper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGetHash_GzipData(t *testing.T) {
+	alg, err := GetHashAlgorithm(SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte{0x00, 0xff, 0x10, 0x80}, 1000)
+
+	compressed := &bytes.Buffer{}
+	gz := gzip.NewWriter(compressed)
+	_, err = gz.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gz.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name        string
+		limit       int64
+		body        io.Reader
+		expectedErr error
+	}{
+		{
+			name:  "within limit",
+			limit: int64(len(data)),
+			body:  bytes.NewReader(compressed.Bytes()),
+		},
+		{
+			name:        "decompressed body exceeds limit",
+			limit:       int64(len(data)) - 1,
+			body:        bytes.NewReader(compressed.Bytes()),
+			expectedErr: ErrBodyTooLarge,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/"+uuid.NewString(), test.body)
+			r.Header.Set("Content-Type", BinType)
+			r.Header.Set("Content-Encoding", GzipEncoding)
+			r = r.WithContext(withMaxBodySize(r.Context(), test.limit))
+
+			hash, err := GetHash(r, alg, Canonicalization{})
+			if err != test.expectedErr {
+				t.Fatalf("unexpected error: expected %v, got %v", test.expectedErr, err)
+			}
+			if err == nil && !bytes.Equal(hash, alg.Sum(data)) {
+				t.Errorf("unexpected hash: expected %x, got %x", alg.Sum(data), hash)
+			}
+		})
+	}
+}
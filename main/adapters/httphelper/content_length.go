@@ -0,0 +1,40 @@
+package httphelper
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ContentLengthCheck is a middleware that wraps the request body so that reading it
+// fails, if the actual body length does not match the declared Content-Length
+func ContentLengthCheck(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength >= 0 && r.Body != nil && r.Body != http.NoBody {
+			r.Body = &contentLengthReader{
+				ReadCloser: r.Body,
+				declared:   r.ContentLength,
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type contentLengthReader struct {
+	io.ReadCloser
+	declared int64
+	read     int64
+}
+
+func (c *contentLengthReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.read += int64(n)
+
+	if c.read > c.declared {
+		return n, fmt.Errorf("request body exceeds declared Content-Length of %d bytes", c.declared)
+	}
+	if err == io.EOF && c.read != c.declared {
+		return n, fmt.Errorf("request body length (%d bytes) does not match declared Content-Length (%d bytes)", c.read, c.declared)
+	}
+	return n, err
+}
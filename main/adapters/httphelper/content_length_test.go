@@ -0,0 +1,59 @@
+package httphelper
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContentLengthCheck(t *testing.T) {
+	var tests = []struct {
+		name          string
+		body          []byte
+		contentLength int64
+		expectedCode  int
+	}{
+		{
+			name:          "matching",
+			body:          []byte("0123456789"),
+			contentLength: 10,
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name:          "declared larger than body",
+			body:          []byte("0123456789"),
+			contentLength: 100,
+			expectedCode:  http.StatusBadRequest,
+		},
+		{
+			name:          "declared smaller than body",
+			body:          []byte("0123456789"),
+			contentLength: 5,
+			expectedCode:  http.StatusBadRequest,
+		},
+	}
+
+	handler := ContentLengthCheck(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := ReadBody(r)
+		if err != nil {
+			Respond400(w, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(test.body))
+			r.ContentLength = test.contentLength
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Errorf("unexpected response code: expected %d, got %d", test.expectedCode, w.Code)
+			}
+		})
+	}
+}
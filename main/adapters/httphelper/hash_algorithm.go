@@ -0,0 +1,82 @@
+package httphelper
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	HashAlgorithmHeader = "X-Hash-Algorithm"
+
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+
+	DefaultHashAlgorithm = SHA256
+)
+
+// HashAlgorithm is a digest algorithm which can be selected to hash the original data of a request
+type HashAlgorithm struct {
+	Name string
+	Size int
+	new  func() hash.Hash
+}
+
+// Sum returns the digest of the data
+func (a HashAlgorithm) Sum(data []byte) Hash {
+	digest := a.new()
+	digest.Write(data)
+	return digest.Sum(nil)
+}
+
+// SumReader returns the digest of the data read from r. The data is hashed while it is
+// read, so that large files do not have to be loaded into memory.
+func (a HashAlgorithm) SumReader(r io.Reader) (Hash, error) {
+	digest := a.new()
+	if _, err := io.Copy(digest, r); err != nil {
+		return nil, err
+	}
+	return digest.Sum(nil), nil
+}
+
+var hashAlgorithms = map[string]HashAlgorithm{
+	SHA256: {
+		Name: SHA256,
+		Size: sha256.Size,
+		new:  sha256.New,
+	},
+	SHA512: {
+		Name: SHA512,
+		Size: sha512.Size,
+		new:  sha512.New,
+	},
+}
+
+// GetHashAlgorithm returns the hash algorithm with the given name
+// or the default algorithm (SHA-256) if the name is empty
+func GetHashAlgorithm(name string) (HashAlgorithm, error) {
+	if name == "" {
+		name = DefaultHashAlgorithm
+	}
+
+	alg, found := hashAlgorithms[strings.ToLower(name)]
+	if !found {
+		return HashAlgorithm{}, fmt.Errorf("unknown hash algorithm: "+
+			"expected (\"%s\" | \"%s\"), got \"%s\"", SHA256, SHA512, name)
+	}
+	return alg, nil
+}
+
+// RequestHashAlgorithm returns the hash algorithm selected by the "X-Hash-Algorithm" request header.
+// If the header is not set, the algorithm with the given default name is returned.
+func RequestHashAlgorithm(header http.Header, defaultName string) (HashAlgorithm, error) {
+	name := header.Get(HashAlgorithmHeader)
+	if name == "" {
+		name = defaultName
+	}
+	return GetHashAlgorithm(name)
+}
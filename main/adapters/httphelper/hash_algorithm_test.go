@@ -0,0 +1,133 @@
+package httphelper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetHash(t *testing.T) {
+	data := []byte("test")
+	sha256Hash := sha256.Sum256(data)
+	sha512Hash := sha512.Sum512(data)
+
+	var tests = []struct {
+		name         string
+		path         string
+		contentType  string
+		body         []byte
+		algorithm    string
+		expectedHash []byte
+		expectError  bool
+	}{
+		{
+			name:         "original data, default",
+			path:         "/",
+			contentType:  BinType,
+			body:         data,
+			expectedHash: sha256Hash[:],
+		},
+		{
+			name:         "original data, sha512",
+			path:         "/",
+			contentType:  BinType,
+			body:         data,
+			algorithm:    SHA512,
+			expectedHash: sha512Hash[:],
+		},
+		{
+			name:         "sha512 hash",
+			path:         "/" + HashEndpoint,
+			contentType:  TextType,
+			body:         []byte(base64.StdEncoding.EncodeToString(sha512Hash[:])),
+			algorithm:    SHA512,
+			expectedHash: sha512Hash[:],
+		},
+		{
+			name:        "sha256 hash with sha512 algorithm",
+			path:        "/" + HashEndpoint,
+			contentType: TextType,
+			body:        []byte(base64.StdEncoding.EncodeToString(sha256Hash[:])),
+			algorithm:   SHA512,
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, test.path, bytes.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			r.Header.Set(HashAlgorithmHeader, test.algorithm)
+
+			alg, err := RequestHashAlgorithm(r.Header, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hash, err := GetHash(r, alg, Canonicalization{})
+			if test.expectError {
+				if err == nil {
+					t.Error("GetHash did not return error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(hash, test.expectedHash) {
+				t.Errorf("unexpected hash: expected %x, got %x", test.expectedHash, hash)
+			}
+		})
+	}
+}
+
+func TestRequestHashAlgorithm(t *testing.T) {
+	header := http.Header{}
+
+	alg, err := RequestHashAlgorithm(header, SHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alg.Name != SHA512 {
+		t.Errorf("default algorithm was not used: %s", alg.Name)
+	}
+
+	header.Set(HashAlgorithmHeader, strings.ToUpper(SHA256))
+	alg, err = RequestHashAlgorithm(header, SHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alg.Name != SHA256 {
+		t.Errorf("header did not override default algorithm: %s", alg.Name)
+	}
+
+	header.Set(HashAlgorithmHeader, "md5")
+	_, err = RequestHashAlgorithm(header, "")
+	if err == nil {
+		t.Error("unknown algorithm was not rejected")
+	}
+}
+
+func TestHashAlgorithm_SumReader(t *testing.T) {
+	data := bytes.Repeat([]byte("test"), 100000)
+
+	for _, name := range []string{SHA256, SHA512} {
+		alg, err := GetHashAlgorithm(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := alg.SumReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(hash, alg.Sum(data)) {
+			t.Errorf("%s: digest of reader does not match digest of data: %x", name, hash)
+		}
+	}
+}
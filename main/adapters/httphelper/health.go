@@ -0,0 +1,97 @@
+package httphelper
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	HealthPath = "/health"
+	ReadyPath  = "/ready"
+)
+
+var (
+	ready int32
+
+	unhealthy      = map[string]bool{} // dependencies which are currently unhealthy
+	unhealthyMutex = &sync.RWMutex{}
+)
+
+// SetReady marks the service as ready to process requests
+func SetReady() {
+	atomic.StoreInt32(&ready, 1)
+}
+
+// SetNotReady marks the service as not ready to process requests, e.g. during shutdown
+func SetNotReady() {
+	atomic.StoreInt32(&ready, 0)
+}
+
+// SetDependencyHealth records the health of a dependency of the service, e.g. the database.
+// The service is not ready while a dependency is unhealthy, i.e. while err is not nil.
+func SetDependencyHealth(dependency string, err error) {
+	unhealthyMutex.Lock()
+	defer unhealthyMutex.Unlock()
+
+	if err != nil {
+		unhealthy[dependency] = true
+	} else {
+		delete(unhealthy, dependency)
+	}
+}
+
+// IsReady returns true if the service was marked as ready and all dependencies are healthy
+func IsReady() bool {
+	return atomic.LoadInt32(&ready) == 1 && len(unhealthyDependencies()) == 0
+}
+
+func unhealthyDependencies() []string {
+	unhealthyMutex.RLock()
+	defer unhealthyMutex.RUnlock()
+
+	var dependencies []string
+	for dependency := range unhealthy {
+		dependencies = append(dependencies, dependency)
+	}
+	return dependencies
+}
+
+// HealthChecks is a middleware that answers liveness checks on HealthPath and
+// readiness checks on ReadyPath, regardless of which routes are set up
+func HealthChecks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			switch r.URL.Path {
+			case HealthPath:
+				Ok(w, http.StatusText(http.StatusOK))
+				return
+			case ReadyPath:
+				readinessResponse(w)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func readinessResponse(w http.ResponseWriter) {
+	if IsReady() {
+		Ok(w, http.StatusText(http.StatusOK))
+		return
+	}
+
+	w.Header().Set(HeaderContentType, JSONType)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	status := map[string]string{"status": "not ready"}
+	for _, dependency := range unhealthyDependencies() {
+		status[dependency] = "unhealthy"
+	}
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		log.Errorf("unable to write response: %s", err)
+	}
+}
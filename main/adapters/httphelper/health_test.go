@@ -0,0 +1,60 @@
+package httphelper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthChecks(t *testing.T) {
+	router := NewRouter()
+	srv := HTTPServer{Router: router}
+	srv.Router.Get("/other", Health("test"))
+
+	check := func(path string, expectedCode int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != expectedCode {
+			t.Errorf("%s: unexpected response code: expected %d, got %d", path, expectedCode, w.Code)
+		}
+	}
+
+	SetNotReady()
+	check(HealthPath, http.StatusOK)
+	check(ReadyPath, http.StatusServiceUnavailable)
+	check("/other", http.StatusOK)
+
+	SetReady()
+	check(HealthPath, http.StatusOK)
+	check(ReadyPath, http.StatusOK)
+
+	// the service is not ready while a dependency is unhealthy
+	SetDependencyHealth("database", fmt.Errorf("connection refused"))
+	check(HealthPath, http.StatusOK)
+	check(ReadyPath, http.StatusServiceUnavailable)
+
+	SetDependencyHealth("database", nil)
+	check(ReadyPath, http.StatusOK)
+
+	// readiness is revoked on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	serverReadyCtx, serverReady := context.WithCancel(context.Background())
+	srv.Addr = "127.0.0.1:0"
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(ctx, serverReady)
+	}()
+	<-serverReadyCtx.Done()
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	check(HealthPath, http.StatusOK)
+	check(ReadyPath, http.StatusServiceUnavailable)
+}
@@ -2,9 +2,14 @@ package httphelper
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -27,16 +32,30 @@ func (*ServerEndpoint) HandleOptions(http.ResponseWriter, *http.Request) {
 }
 
 type HTTPServer struct {
-	Router   *chi.Mux
-	Addr     string
-	TLS      bool
-	CertFile string
-	KeyFile  string
+	Router            *chi.Mux
+	Admin             chi.Router // router for privileged operations under AdminPath, see SetUpAdmin
+	Addr              string
+	TLS               bool
+	CertFile          string
+	KeyFile           string
+	ClientCAFile      string        // if set, client certificates are verified against the CAs from this file
+	RequireClientCert bool          // reject TLS connections without a valid client certificate
+	MinVersion        uint16        // minimum TLS version, defaults to TLS 1.2
+	CipherSuites      []uint16      // accepted cipher suites for TLS versions below 1.3, defaults to Go's secure cipher suites
+	ACME              bool          // obtain and renew the TLS certificates automatically from Let's Encrypt instead of using the cert and key files
+	ACMEHosts         []string      // host names to obtain TLS certificates for with ACME
+	ACMECacheDir      string        // directory to cache the TLS certificates obtained with ACME in
+	DrainDelay        time.Duration // time between reporting not ready and shutting down, so that load balancers can drain traffic
 }
 
 func NewRouter() *chi.Mux {
 	router := chi.NewMux()
+	router.Use(RequestID)
+	router.Use(AccessLog)
+	router.Use(Recoverer)
+	router.Use(middleware.Compress(compressionLevel, JSONType, MimeApplicationProblem))
 	router.Use(middleware.Timeout(GatewayTimeout))
+	router.Use(HealthChecks)
 	return router
 }
 
@@ -44,14 +63,43 @@ func (srv *HTTPServer) SetUpCORS(allowedOrigins []string, debug bool) {
 	srv.Router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   allowedOrigins,
 		AllowedMethods:   []string{"POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Content-Type", "Content-Length", "X-Auth-Token"},
-		ExposedHeaders:   []string{"Accept", "Content-Type", "Content-Length", "X-Auth-Token"},
+		AllowedHeaders:   []string{"Accept", "Content-Type", "Content-Length", "Content-Encoding", "X-Auth-Token", "X-Callback-URL", "X-Hash-Algorithm", "X-JSON-Canonicalization", "X-Signature", "X-Timestamp", "X-Nonce", "Idempotency-Key", "X-UPP-Hint", "X-UPP-Type"},
+		ExposedHeaders:   []string{"Accept", "Content-Type", "Content-Length", "X-Auth-Token", "X-Job-ID", "X-Request-ID", "Idempotent-Replayed", "X-Deduplicated"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 		Debug:            debug,
 	}))
 }
 
+// SetUpContentLengthCheck makes the server reject requests with a body that does not match
+// the declared Content-Length header, instead of processing a truncated or extended body
+func (srv *HTTPServer) SetUpContentLengthCheck() {
+	srv.Router.Use(ContentLengthCheck)
+}
+
+// SetUpMaxBodySize makes the server reject requests with a body larger than the given number of bytes
+func (srv *HTTPServer) SetUpMaxBodySize(limit int64) {
+	srv.Router.Use(MaxBodySize(limit))
+}
+
+// SetUpProblemJSON makes the server respond with RFC 7807 problem details
+// ("application/problem+json") instead of plain text error messages
+func (srv *HTTPServer) SetUpProblemJSON() {
+	srv.Router.Use(ProblemJSON)
+}
+
+// SetUpSecurityHeaders makes the server set security headers for browser-facing deployments on
+// all responses. The "Strict-Transport-Security" header is only set if TLS is enabled, with the
+// given max-age in seconds, or DefaultHSTSMaxAge if it is not positive.
+func (srv *HTTPServer) SetUpSecurityHeaders(hstsMaxAge int) {
+	if !srv.TLS {
+		hstsMaxAge = 0
+	} else if hstsMaxAge <= 0 {
+		hstsMaxAge = DefaultHSTSMaxAge
+	}
+	srv.Router.Use(SecurityHeaders(hstsMaxAge))
+}
+
 func (srv *HTTPServer) AddServiceEndpoint(endpoint ServerEndpoint) {
 	hashEndpointPath := path.Join(endpoint.Path, HashEndpoint)
 
@@ -63,20 +111,45 @@ func (srv *HTTPServer) AddServiceEndpoint(endpoint ServerEndpoint) {
 }
 
 func (srv *HTTPServer) Serve(cancelCtx context.Context, serverReady context.CancelFunc) error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = DefaultAddr
+	}
+
 	server := &http.Server{
-		Addr:         srv.Addr,
+		Addr:         addr,
 		Handler:      srv.Router,
 		ReadTimeout:  ReadTimeout,
 		WriteTimeout: WriteTimeout,
 		IdleTimeout:  IdleTimeout,
 	}
+	if srv.TLS {
+		tlsConfig, err := srv.tlsConfig()
+		if err != nil {
+			return err
+		}
+		if srv.ACME {
+			acmeManager := srv.newACMEManager()
+			setUpACME(tlsConfig, acmeManager)
+			go serveACMEChallenges(cancelCtx, acmeManager)
+		}
+		server.TLSConfig = tlsConfig
+	}
 	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
 
 	go func() {
 		<-cancelCtx.Done()
+		SetNotReady()                      // let load balancers drain traffic
 		server.SetKeepAlivesEnabled(false) // disallow clients to create new long-running conns
 
-		shutdownWithTimeoutCtx, _ := context.WithTimeout(shutdownCtx, ShutdownTimeout)
+		// keep serving requests until the load balancers noticed that the server is not ready
+		if srv.DrainDelay > 0 {
+			log.Infof("waiting %s for load balancers to drain traffic", srv.DrainDelay)
+			time.Sleep(srv.DrainDelay)
+		}
+
+		shutdownWithTimeoutCtx, shutdownWithTimeoutCancel := context.WithTimeout(shutdownCtx, ShutdownTimeout)
+		defer shutdownWithTimeoutCancel()
 		defer shutdownCancel()
 
 		if err := server.Shutdown(shutdownWithTimeoutCtx); err != nil {
@@ -86,14 +159,20 @@ func (srv *HTTPServer) Serve(cancelCtx context.Context, serverReady context.Canc
 		}
 	}()
 
-	log.Infof("starting HTTP server")
+	log.Infof("starting HTTP server on %s", addr)
 	serverReady()
 
-	var err error
-	if srv.TLS {
-		err = server.ListenAndServeTLS(srv.CertFile, srv.KeyFile)
+	listener, err := listen(addr)
+	if err != nil {
+		return fmt.Errorf("error starting HTTP server: %v", err)
+	}
+
+	if srv.TLS && srv.ACME {
+		err = server.ServeTLS(listener, "", "") // the certificates are provided by the ACME manager
+	} else if srv.TLS {
+		err = server.ServeTLS(listener, srv.CertFile, srv.KeyFile)
 	} else {
-		err = server.ListenAndServe()
+		err = server.Serve(listener)
 	}
 	if err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("error starting HTTP server: %v", err)
@@ -103,3 +182,63 @@ func (srv *HTTPServer) Serve(cancelCtx context.Context, serverReady context.Canc
 	<-shutdownCtx.Done()
 	return nil
 }
+
+// tlsConfig returns the TLS configuration of the server. If a client CA file is set,
+// client certificates are requested and verified against the CAs from the file.
+func (srv *HTTPServer) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:   srv.MinVersion,
+		CipherSuites: srv.CipherSuites,
+	}
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12 // reject TLS 1.0 and 1.1
+	}
+
+	if srv.ClientCAFile != "" {
+		clientCAs, err := LoadClientCAs(srv.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = clientCAs
+
+		if srv.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// listen listens on the TCP address or, if the address has the form "unix:/path/to.sock", on a Unix
+// domain socket. A stale socket file of a previous run is removed before and the socket file is
+// made accessible for the owner and group only. The socket file is removed when the listener is closed.
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, UnixSocketPrefix) {
+		return net.Listen("tcp", addr)
+	}
+	socketPath := strings.TrimPrefix(addr, UnixSocketPrefix)
+
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("unable to create Unix socket %s: file exists and is not a socket", socketPath)
+		}
+		log.Warnf("removing stale Unix socket %s", socketPath)
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(socketPath, UnixSocketMode)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -2,9 +2,21 @@ package httphelper
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 const (
@@ -51,3 +63,232 @@ func TestSortedCompactJson(t *testing.T) {
 		}
 	}
 }
+
+func TestHTTPServer_TLSVersion(t *testing.T) {
+	tests := []struct {
+		name             string
+		minVersion       uint16
+		clientMaxVersion uint16
+		refused          bool
+	}{
+		{
+			name:             "TLS 1.0 is refused by default",
+			clientMaxVersion: tls.VersionTLS10,
+			refused:          true,
+		},
+		{
+			name:             "TLS 1.1 is refused by default",
+			clientMaxVersion: tls.VersionTLS11,
+			refused:          true,
+		},
+		{
+			name:             "TLS 1.2 is accepted by default",
+			clientMaxVersion: tls.VersionTLS12,
+		},
+		{
+			name:             "TLS 1.2 is refused with minimum version 1.3",
+			minVersion:       tls.VersionTLS13,
+			clientMaxVersion: tls.VersionTLS12,
+			refused:          true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := &HTTPServer{TLS: true, MinVersion: test.minVersion}
+			tlsConfig, err := srv.tlsConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			server.TLS = tlsConfig
+			server.StartTLS()
+			defer server.Close()
+
+			client := server.Client()
+			clientTLSConfig := client.Transport.(*http.Transport).TLSClientConfig
+			clientTLSConfig.MinVersion = tls.VersionTLS10
+			clientTLSConfig.MaxVersion = test.clientMaxVersion
+
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			if test.refused && err == nil {
+				t.Errorf("TLS handshake with version %x was not refused", test.clientMaxVersion)
+			}
+			if !test.refused && err != nil {
+				t.Errorf("TLS handshake with version %x failed: %v", test.clientMaxVersion, err)
+			}
+		})
+	}
+}
+
+func TestHTTPServer_ACME(t *testing.T) {
+	srv := &HTTPServer{
+		TLS:          true,
+		ACME:         true,
+		ACMEHosts:    []string{"client.example.com"},
+		ACMECacheDir: t.TempDir(),
+	}
+
+	tlsConfig, err := srv.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acmeManager := srv.newACMEManager()
+	setUpACME(tlsConfig, acmeManager)
+
+	if tlsConfig.GetCertificate == nil {
+		t.Error("certificates are not provided by the ACME manager")
+	}
+	if len(tlsConfig.NextProtos) == 0 || tlsConfig.NextProtos[len(tlsConfig.NextProtos)-1] != acme.ALPNProto {
+		t.Errorf("TLS-ALPN-01 challenges are not accepted: %v", tlsConfig.NextProtos)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("unexpected minimum TLS version: %x", tlsConfig.MinVersion)
+	}
+
+	if cacheDir, ok := acmeManager.Cache.(autocert.DirCache); !ok || string(cacheDir) != srv.ACMECacheDir {
+		t.Errorf("unexpected ACME cache: %v", acmeManager.Cache)
+	}
+	if err := acmeManager.HostPolicy(context.Background(), "client.example.com"); err != nil {
+		t.Errorf("configured host was rejected: %v", err)
+	}
+	if err := acmeManager.HostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Error("unknown host was accepted")
+	}
+}
+
+func TestHTTPServer_UnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "client.sock")
+
+	// leave a stale socket file behind
+	stale, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	srv := &HTTPServer{Router: NewRouter(), Addr: UnixSocketPrefix + socketPath}
+	srv.Router.Get("/", Health("test"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverReadyCtx, serverReady := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ctx, serverReady)
+	}()
+	<-serverReadyCtx.Done()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ { // the server is ready before it listens
+		resp, err = client.Get("http://unix/")
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("request via Unix socket failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != UnixSocketMode {
+		t.Errorf("unexpected socket permissions: %v", info.Mode().Perm())
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("server returned error: %v", err)
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("socket file was not removed on shutdown: %v", err)
+	}
+}
+
+func TestListen_NoSocket(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "client.sock")
+	err := ioutil.WriteFile(file, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := listen(UnixSocketPrefix + file); err == nil {
+		t.Error("regular file was replaced by Unix socket")
+	}
+}
+
+func TestHTTPServer_DrainDelay(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "client.sock")
+
+	srv := &HTTPServer{Router: NewRouter(), Addr: UnixSocketPrefix + socketPath, DrainDelay: 500 * time.Millisecond}
+	srv.Router.Get("/", Health("test"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverReadyCtx, serverReady := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ctx, serverReady)
+	}()
+	<-serverReadyCtx.Done()
+
+	client := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	get := func() error {
+		resp, err := client.Get("http://unix/")
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	var err error
+	for i := 0; i < 50; i++ { // the server is ready before it listens
+		if err = get(); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("request via Unix socket failed: %v", err)
+	}
+
+	SetReady()
+	shutdown := time.Now()
+	cancel()
+	for IsReady() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// requests are still served while load balancers drain traffic
+	if err = get(); err != nil {
+		t.Errorf("request during drain delay failed: %v", err)
+	}
+
+	if err = <-served; err != nil {
+		t.Errorf("server returned error: %v", err)
+	}
+	if elapsed := time.Since(shutdown); elapsed < srv.DrainDelay {
+		t.Errorf("server was shut down before the drain delay passed: %s", elapsed)
+	}
+}
@@ -0,0 +1,37 @@
+package httphelper
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const InfoPath = "/info"
+
+// BuildInfo is the build and deployment information of the client. It must never contain secrets,
+// since the info endpoint does not require authentication.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	GoVersion string `json:"goVersion"`
+	Env       string `json:"env"` // the UBIRCH backend environment
+}
+
+// Info returns a handler which responds with the build info as JSON, so that operators
+// can confirm which build is deployed and which backend environment it targets
+func Info(info BuildInfo) http.HandlerFunc {
+	content, err := json.Marshal(info)
+	if err != nil {
+		log.Errorf("unable to encode build info: %v", err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderContentType, JSONType)
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write(content)
+		if err != nil {
+			log.Errorf("unable to write response: %s", err)
+		}
+	}
+}
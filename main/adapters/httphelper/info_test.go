@@ -0,0 +1,50 @@
+package httphelper
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	router := NewRouter()
+	router.Get(InfoPath, Info(BuildInfo{
+		Version:   "v1.2.3",
+		Revision:  "abc1234",
+		GoVersion: runtime.Version(),
+		Env:       "demo",
+	}))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, InfoPath, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response code: expected %d, got %d", http.StatusOK, w.Code)
+	}
+	if contentType := w.Header().Get(HeaderContentType); contentType != JSONType {
+		t.Errorf("unexpected content type: %s", contentType)
+	}
+
+	var info map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &info)
+	if err != nil {
+		t.Fatalf("unable to decode response: %v", err)
+	}
+
+	expected := map[string]string{
+		"version":   "v1.2.3",
+		"revision":  "abc1234",
+		"goVersion": runtime.Version(),
+		"env":       "demo",
+	}
+	if len(info) != len(expected) {
+		t.Errorf("unexpected fields: %v", info)
+	}
+	for k, v := range expected {
+		if info[k] != v {
+			t.Errorf("unexpected %s: expected %s, got %s", k, v, info[k])
+		}
+	}
+}
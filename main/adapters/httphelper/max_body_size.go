@@ -0,0 +1,59 @@
+package httphelper
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// ErrBodyTooLarge is returned when reading a request body which exceeds the maximum body size
+var ErrBodyTooLarge = fmt.Errorf("request body too large")
+
+// MaxBodySize is a middleware that limits the size of request bodies to the given number of bytes.
+// Requests which declare a larger Content-Length are rejected right away, reading a larger body
+// without declared length fails with ErrBodyTooLarge. The limit applies to decompressed bodies as well.
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				Error(uuid.Nil, w, fmt.Errorf("%v: exceeds maximum of %d bytes", ErrBodyTooLarge, limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &maxBodySizeReader{ReadCloser: http.MaxBytesReader(w, r.Body, limit)}
+			}
+			next.ServeHTTP(w, r.WithContext(withMaxBodySize(r.Context(), limit)))
+		})
+	}
+}
+
+// maxBodySizeReader replaces the untyped error of http.MaxBytesReader by ErrBodyTooLarge
+type maxBodySizeReader struct {
+	io.ReadCloser
+}
+
+func (m *maxBodySizeReader) Read(p []byte) (int, error) {
+	n, err := m.ReadCloser.Read(p)
+	if err != nil && err.Error() == "http: request body too large" {
+		err = ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// BodyErrorCode returns the response code for an error which occurred while reading or parsing
+// a request body, i.e. 413 if the body was too large, 415 if its content encoding is not supported,
+// 401 if it does not match its signature and 400 otherwise
+func BodyErrorCode(err error) int {
+	switch err {
+	case ErrInvalidSignature:
+		return http.StatusUnauthorized
+	case ErrBodyTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrUnsupportedContentEncoding:
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusBadRequest
+	}
+}
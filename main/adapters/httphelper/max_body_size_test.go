@@ -0,0 +1,67 @@
+package httphelper
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	var tests = []struct {
+		name          string
+		bodySize      int
+		contentLength int64
+		expectedCode  int
+	}{
+		{
+			name:          "within limit",
+			bodySize:      10,
+			contentLength: 10,
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name:          "at limit",
+			bodySize:      100,
+			contentLength: 100,
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name:          "declared larger than limit",
+			bodySize:      101,
+			contentLength: 101,
+			expectedCode:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:          "undeclared larger than limit",
+			bodySize:      101,
+			contentLength: -1,
+			expectedCode:  http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	handler := MaxBodySize(100)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := ReadBody(r)
+		if err != nil {
+			Error(uuid.Nil, w, err, BodyErrorCode(err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(make([]byte, test.bodySize)))
+			r.ContentLength = test.contentLength
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.expectedCode {
+				t.Errorf("unexpected response code: expected %d, got %d", test.expectedCode, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package httphelper
+
+import (
+	"net/http/pprof"
+
+	"github.com/go-chi/chi"
+)
+
+const PprofPath = "/debug/pprof"
+
+// SetUpPprof mounts the runtime profiling endpoints of net/http/pprof under PprofPath, e.g. to investigate
+// performance problems or goroutine leaks in production. Like the admin API, all requests require the
+// admin token in the "X-Admin-Token" header, therefore the endpoints are useless without an admin token.
+func (srv *HTTPServer) SetUpPprof(adminToken string) {
+	router := chi.NewRouter()
+	router.Use(RequireAdmin(adminToken))
+
+	router.Get("/", pprof.Index)
+	router.Get("/cmdline", pprof.Cmdline)
+	router.Get("/profile", pprof.Profile)
+	router.Get("/symbol", pprof.Symbol)
+	router.Post("/symbol", pprof.Symbol)
+	router.Get("/trace", pprof.Trace)
+	router.Get("/{profile}", pprof.Index) // named profiles, e.g. "heap" or "goroutine"
+
+	srv.Router.Mount(PprofPath, router)
+}
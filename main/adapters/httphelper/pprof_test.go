@@ -0,0 +1,86 @@
+package httphelper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPServer_SetUpPprof(t *testing.T) {
+	const adminToken = "admin-token"
+
+	tests := []struct {
+		name           string
+		pprof          bool
+		path           string
+		header         map[string]string
+		expectedStatus int
+	}{
+		{
+			name:           "disabled",
+			path:           PprofPath + "/",
+			header:         map[string]string{AdminTokenHeader: adminToken},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "disabled named profile",
+			path:           PprofPath + "/goroutine",
+			header:         map[string]string{AdminTokenHeader: adminToken},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "missing admin token",
+			pprof:          true,
+			path:           PprofPath + "/",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong admin token",
+			pprof:          true,
+			path:           PprofPath + "/heap",
+			header:         map[string]string{AdminTokenHeader: "wrong"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "index",
+			pprof:          true,
+			path:           PprofPath + "/",
+			header:         map[string]string{AdminTokenHeader: adminToken},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "named profile",
+			pprof:          true,
+			path:           PprofPath + "/goroutine?debug=1",
+			header:         map[string]string{AdminTokenHeader: adminToken},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "cmdline",
+			pprof:          true,
+			path:           PprofPath + "/cmdline",
+			header:         map[string]string{AdminTokenHeader: adminToken},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := &HTTPServer{Router: NewRouter()}
+			if test.pprof {
+				srv.SetUpPprof(adminToken)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			for k, v := range test.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			srv.Router.ServeHTTP(w, r)
+
+			if w.Code != test.expectedStatus {
+				t.Errorf("unexpected response code: expected %d, got %d", test.expectedStatus, w.Code)
+			}
+		})
+	}
+}
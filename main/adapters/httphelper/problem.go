@@ -1,9 +1,11 @@
 package httphelper
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 // HttpProblem is an implementation of https://tools.ietf.org/html/rfc7807.
@@ -22,8 +24,9 @@ type HttpProblemInstance struct {
 	HttpProblem
 	Detail   string `json:"detail,omitempty"`
 	Instance string `json:"instance,omitempty"`
-	// We are not supporting extension members at the moment
-	// (https://tools.ietf.org/html/rfc7807#section-3.2)
+	// extension member (https://tools.ietf.org/html/rfc7807#section-3.2)
+	// which correlates the response with the request and the logs
+	RequestID string `json:"requestId,omitempty"`
 }
 
 // Respond400 sends a HTTP status 400 response to the provided
@@ -87,3 +90,71 @@ func RespondProblem(w http.ResponseWriter, pi HttpProblemInstance) {
 		fmt.Fprintf(w, "Error: %v (%v)", err, pi)
 	}
 }
+
+// ProblemJSON is a middleware that converts plain text error responses (status >= 400) into
+// application/problem+json responses. The error message becomes the detail of the problem.
+// The problem carries the request ID from the request context (see RequestID).
+func ProblemJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := &problemWriter{ResponseWriter: w, requestID: GetRequestID(r.Context())}
+		next.ServeHTTP(pw, r)
+		pw.flushProblem()
+	})
+}
+
+// problemWriter buffers plain text error responses, so that they can be sent as problem instead
+type problemWriter struct {
+	http.ResponseWriter
+	requestID   string
+	wroteHeader bool
+	status      int          // status code of a buffered error response, zero if not an error response
+	detail      bytes.Buffer // buffered error message
+}
+
+func (pw *problemWriter) WriteHeader(code int) {
+	if pw.wroteHeader {
+		return
+	}
+	pw.wroteHeader = true
+
+	contentType := pw.Header().Get(HeaderContentType)
+	if code >= http.StatusBadRequest && (contentType == "" || strings.HasPrefix(contentType, MimeTextPlain)) {
+		pw.status = code
+		return
+	}
+	pw.ResponseWriter.WriteHeader(code)
+}
+
+func (pw *problemWriter) Write(b []byte) (int, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	if pw.status != 0 {
+		return pw.detail.Write(b)
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+func (pw *problemWriter) flushProblem() {
+	if pw.status == 0 {
+		return
+	}
+
+	detail := strings.TrimSpace(pw.detail.String())
+	if detail == http.StatusText(pw.status) {
+		detail = ""
+	}
+
+	pw.Header().Del("Content-Length")
+	pw.Header().Del("X-Content-Type-Options")
+
+	RespondProblem(pw.ResponseWriter, HttpProblemInstance{
+		HttpProblem: HttpProblem{
+			Type:   "about:blank",
+			Title:  http.StatusText(pw.status),
+			Status: pw.status,
+		},
+		Detail:    detail,
+		RequestID: pw.requestID,
+	})
+}
@@ -0,0 +1,122 @@
+package httphelper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestProblemJSON(t *testing.T) {
+	tests := []struct {
+		name            string
+		handler         http.HandlerFunc
+		expectedStatus  int
+		expectedType    string
+		expectedBody    string
+		expectedProblem *HttpProblemInstance
+	}{
+		{
+			name: "error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				Error(uuid.Nil, w, fmt.Errorf("invalid request"), http.StatusBadRequest)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedType:   MimeApplicationProblem,
+			expectedProblem: &HttpProblemInstance{
+				HttpProblem: HttpProblem{Type: "about:blank", Title: "Bad Request", Status: http.StatusBadRequest},
+				Detail:      "invalid request",
+			},
+		},
+		{
+			name: "error response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				SendResponse(w, HTTPResponse{
+					StatusCode: http.StatusServiceUnavailable,
+					Header:     http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+					Content:    []byte(http.StatusText(http.StatusServiceUnavailable)),
+				})
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedType:   MimeApplicationProblem,
+			expectedProblem: &HttpProblemInstance{
+				HttpProblem: HttpProblem{Type: "about:blank", Title: "Service Unavailable", Status: http.StatusServiceUnavailable},
+			},
+		},
+		{
+			name: "error without body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedType:   MimeApplicationProblem,
+			expectedProblem: &HttpProblemInstance{
+				HttpProblem: HttpProblem{Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound},
+			},
+		},
+		{
+			name: "JSON error response is not converted",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				SendResponse(w, HTTPResponse{
+					StatusCode: http.StatusBadGateway,
+					Header:     http.Header{"Content-Type": {JSONType}},
+					Content:    []byte(`{"error":"backend failed"}`),
+				})
+			},
+			expectedStatus: http.StatusBadGateway,
+			expectedType:   JSONType,
+			expectedBody:   `{"error":"backend failed"}`,
+		},
+		{
+			name: "success is not converted",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				Ok(w, "OK")
+			},
+			expectedStatus: http.StatusOK,
+			expectedType:   MimeTextPlain,
+			expectedBody:   "OK",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			RequestID(ProblemJSON(test.handler)).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+
+			if w.Code != test.expectedStatus {
+				t.Errorf("unexpected response code: expected %d, got %d", test.expectedStatus, w.Code)
+			}
+			if ct := w.Header().Get(HeaderContentType); ct != test.expectedType {
+				t.Errorf("unexpected content type: expected %s, got %s", test.expectedType, ct)
+			}
+
+			requestID := w.Header().Get(RequestIDHeader)
+			if _, err := uuid.Parse(requestID); err != nil {
+				t.Errorf("no generated request ID in response header: %q", requestID)
+			}
+
+			if test.expectedProblem == nil {
+				if w.Body.String() != test.expectedBody {
+					t.Errorf("unexpected body: expected %s, got %s", test.expectedBody, w.Body.String())
+				}
+				return
+			}
+
+			var problem HttpProblemInstance
+			err := json.Unmarshal(w.Body.Bytes(), &problem)
+			if err != nil {
+				t.Fatalf("response is not JSON: %v: %s", err, w.Body.String())
+			}
+
+			test.expectedProblem.RequestID = requestID
+			if problem != *test.expectedProblem {
+				t.Errorf("unexpected problem:\n"+
+					"- expected: %+v\n"+
+					"-      got: %+v", *test.expectedProblem, problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package httphelper
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Recoverer is a middleware that recovers from panics in the handlers, logs the panic with the
+// stack trace and responds with 500, so that a single bad request can not crash the server.
+// http.ErrAbortHandler is re-panicked, so that the server aborts the response as intended.
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			if rvr == http.ErrAbortHandler {
+				panic(rvr)
+			}
+
+			log.WithContext(r.Context()).WithField("stack", string(debug.Stack())).
+				Errorf("recovered from panic while handling %s %s: %v", r.Method, r.URL.Path, rvr)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
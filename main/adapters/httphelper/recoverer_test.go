@@ -0,0 +1,80 @@
+package httphelper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestRecoverer(t *testing.T) {
+	logHook := &logtest.Hook{}
+	oldHooks := log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+	defer log.StandardLogger().ReplaceHooks(oldHooks)
+	log.AddHook(RequestIDLogHook{})
+	log.AddHook(logHook)
+
+	router := NewRouter()
+	router.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["nil map"]++
+	})
+	router.Get("/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	router.Get("/ok", Health("test"))
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/panic", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(RequestIDHeader, "panic-request")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("unexpected response code: expected %d, got %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+
+	var entry *log.Entry
+	for _, e := range logHook.AllEntries() {
+		if e.Level == log.ErrorLevel {
+			entry = e
+		}
+	}
+	if entry == nil {
+		t.Fatal("panic was not logged")
+	}
+	if entry.Data["requestID"] != "panic-request" {
+		t.Errorf("request ID missing in log entry: %v", entry.Data)
+	}
+	if stack, _ := entry.Data["stack"].(string); !strings.Contains(stack, "TestRecoverer") {
+		t.Errorf("stack trace missing in log entry: %v", entry.Data)
+	}
+
+	// the response is aborted
+	resp, err = http.Get(server.URL + "/abort")
+	if err == nil {
+		_ = resp.Body.Close()
+		t.Error("response of aborted handler was not aborted")
+	}
+
+	// the server is still alive
+	resp, err = http.Get(server.URL + "/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("server not alive after panic: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,67 @@
+package httphelper
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestID is a middleware that takes the request ID from the "X-Request-ID" request header or
+// generates one, stores it in the request context and returns it in the "X-Request-ID" response
+// header. The request ID is forwarded to the ubirch backend and added to the log lines of the request,
+// so that a request can be followed through the logs of the client and the backend.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// WithRequestID returns a copy of the context which carries the request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// GetRequestID returns the request ID from the context, or an empty string if there is none
+func GetRequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// SetRequestIDHeader sets the "X-Request-ID" header of an outgoing request to the request ID
+// from the context, so that the request ID is forwarded to the ubirch backend
+func SetRequestIDHeader(ctx context.Context, header http.Header) {
+	if requestID := GetRequestID(ctx); requestID != "" {
+		header.Set(RequestIDHeader, requestID)
+	}
+}
+
+// RequestIDLogHook adds the request ID to log entries which were created with
+// log.WithContext and the context of a request
+type RequestIDLogHook struct{}
+
+func (RequestIDLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (RequestIDLogHook) Fire(entry *log.Entry) error {
+	if requestID := GetRequestID(entry.Context); requestID != "" {
+		entry.Data["requestID"] = requestID
+	}
+	return nil
+}
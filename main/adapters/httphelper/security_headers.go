@@ -0,0 +1,27 @@
+package httphelper
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const DefaultHSTSMaxAge = 365 * 24 * 60 * 60 // one year in seconds
+
+// SecurityHeaders returns a middleware that sets the "X-Content-Type-Options: nosniff" and
+// "X-Frame-Options: DENY" headers on all responses. If hstsMaxAge is positive, the
+// "Strict-Transport-Security" header is set with the given max-age in seconds.
+func SecurityHeaders(hstsMaxAge int) func(http.Handler) http.Handler {
+	hsts := fmt.Sprintf("max-age=%d", hstsMaxAge)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("X-Frame-Options", "DENY")
+			if hstsMaxAge > 0 {
+				header.Set("Strict-Transport-Security", hsts)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
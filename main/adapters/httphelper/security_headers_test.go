@@ -0,0 +1,76 @@
+package httphelper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPServer_SetUpSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name            string
+		enabled         bool
+		tls             bool
+		hstsMaxAge      int
+		expectedHeaders map[string]string
+	}{
+		{
+			name:    "enabled with TLS",
+			enabled: true,
+			tls:     true,
+			expectedHeaders: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Strict-Transport-Security": "max-age=31536000",
+			},
+		},
+		{
+			name:       "enabled with TLS and HSTS max-age",
+			enabled:    true,
+			tls:        true,
+			hstsMaxAge: 600,
+			expectedHeaders: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Strict-Transport-Security": "max-age=600",
+			},
+		},
+		{
+			name:    "enabled without TLS",
+			enabled: true,
+			expectedHeaders: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			name: "disabled",
+			tls:  true,
+			expectedHeaders: map[string]string{
+				"X-Content-Type-Options":    "",
+				"X-Frame-Options":           "",
+				"Strict-Transport-Security": "",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := &HTTPServer{Router: NewRouter(), TLS: test.tls}
+			if test.enabled {
+				srv.SetUpSecurityHeaders(test.hstsMaxAge)
+			}
+			srv.Router.Get("/", Health("test"))
+
+			w := httptest.NewRecorder()
+			srv.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			for k, v := range test.expectedHeaders {
+				if w.Header().Get(k) != v {
+					t.Errorf("unexpected %s header: expected %q, got %q", k, v, w.Header().Get(k))
+				}
+			}
+		})
+	}
+}
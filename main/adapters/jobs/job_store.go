@@ -0,0 +1,227 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/google/uuid"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type Status string
+
+const (
+	Pending   Status = "pending"
+	Completed Status = "completed"
+	Failed    Status = "failed"
+	Unknown   Status = "unknown"
+
+	filePerm = 0644
+
+	MaxFinished = 1000 // maximum number of finished jobs whose status is kept
+)
+
+// Suspended is an in-flight job which could not be processed before shutdown. It is persisted
+// together with its payload, so that it can be resumed after a restart.
+type Suspended struct {
+	ID      uuid.UUID       `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type storeFile struct {
+	Pending   []uuid.UUID             `json:"pending"`
+	Suspended []Suspended             `json:"suspended,omitempty"`
+	Owners    map[uuid.UUID]uuid.UUID `json:"owners,omitempty"`
+}
+
+// Store keeps track of the status of asynchronous jobs. The IDs of in-flight jobs are
+// persisted to a file, so that jobs which were lost due to a restart can be reported as failed.
+// The store also keeps the UUID of the identity which owns a job, so that the status of a job
+// is only reported to the owner.
+type Store struct {
+	file          string
+	pending       map[uuid.UUID]struct{}
+	owners        map[uuid.UUID]uuid.UUID
+	suspended     []Suspended
+	finished      map[uuid.UUID]Status
+	finishedOrder []uuid.UUID
+	mutex         *sync.Mutex
+}
+
+// NewStore loads the in-flight jobs of a previous run from the file and marks them as failed,
+// unless they were suspended on shutdown. Suspended jobs stay pending until they are resumed.
+func NewStore(file string) (*Store, error) {
+	s := &Store{
+		file:     file,
+		pending:  map[uuid.UUID]struct{}{},
+		owners:   map[uuid.UUID]uuid.UUID{},
+		finished: map[uuid.UUID]Status{},
+		mutex:    &sync.Mutex{},
+	}
+
+	stored, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for id, owner := range stored.Owners {
+		s.owners[id] = owner
+	}
+
+	s.suspended = stored.Suspended
+	for _, job := range s.suspended {
+		s.pending[job.ID] = struct{}{}
+	}
+
+	lost := 0
+	for _, id := range stored.Pending {
+		if _, found := s.pending[id]; !found {
+			s.finish(id, Failed)
+			lost++
+		}
+	}
+	if lost > 0 {
+		log.Warnf("%d asynchronous jobs were lost on restart and are reported as failed", lost)
+	}
+	if len(s.suspended) > 0 {
+		log.Infof("%d asynchronous jobs were suspended on shutdown and will be resumed", len(s.suspended))
+	}
+
+	err = s.persist()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add registers a new in-flight job of the identity with the owner UUID
+func (s *Store) Add(id, owner uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pending[id] = struct{}{}
+	s.owners[id] = owner
+	return s.persist()
+}
+
+// Finish sets the final status of an in-flight job
+func (s *Store) Finish(id uuid.UUID, status Status) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.pending, id)
+	s.finish(id, status)
+	return s.persist()
+}
+
+// Suspend persists the payload of an in-flight job, which could not be processed before shutdown,
+// so that it can be resumed after a restart
+func (s *Store) Suspend(id uuid.UUID, payload json.RawMessage) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.pending[id]; !found {
+		return fmt.Errorf("job %s is not in-flight", id)
+	}
+	s.suspended = append(s.suspended, Suspended{ID: id, Payload: payload})
+	return s.persist()
+}
+
+// Resume returns the jobs which were suspended on shutdown in the order in which they were
+// suspended. The jobs stay in-flight until they are finished.
+func (s *Store) Resume() ([]Suspended, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	suspended := s.suspended
+	s.suspended = nil
+	return suspended, s.persist()
+}
+
+// Status returns the status of a job or Unknown if the job is not known (anymore)
+func (s *Store) Status(id uuid.UUID) Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, found := s.pending[id]; found {
+		return Pending
+	}
+	if status, found := s.finished[id]; found {
+		return status
+	}
+	return Unknown
+}
+
+// Owner returns the UUID of the identity which owns a job or uuid.Nil if the owner is not known,
+// e.g. because the job was registered by a previous version
+func (s *Store) Owner(id uuid.UUID) uuid.UUID {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.owners[id]
+}
+
+func (s *Store) finish(id uuid.UUID, status Status) {
+	if len(s.finishedOrder) >= MaxFinished {
+		delete(s.finished, s.finishedOrder[0])
+		delete(s.owners, s.finishedOrder[0])
+		s.finishedOrder = s.finishedOrder[1:]
+	}
+	s.finished[id] = status
+	s.finishedOrder = append(s.finishedOrder, id)
+}
+
+func (s *Store) load() (storeFile, error) {
+	var stored storeFile
+
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return stored, nil
+	}
+	if err != nil {
+		return stored, fmt.Errorf("unable to read job file: %v", err)
+	}
+
+	// files of previous versions contain only the IDs of the in-flight jobs
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &stored.Pending)
+	} else {
+		err = json.Unmarshal(data, &stored)
+	}
+	if err != nil {
+		return stored, fmt.Errorf("unable to parse job file %s: %v", s.file, err)
+	}
+	return stored, nil
+}
+
+// persist writes the IDs and owners of all in-flight jobs and the suspended jobs to the file. The file is
+// replaced atomically, so that a crash can not leave a partially written file behind.
+func (s *Store) persist() error {
+	stored := storeFile{
+		Pending:   make([]uuid.UUID, 0, len(s.pending)),
+		Suspended: s.suspended,
+		Owners:    map[uuid.UUID]uuid.UUID{},
+	}
+	for id := range s.pending {
+		stored.Pending = append(stored.Pending, id)
+		if owner, found := s.owners[id]; found {
+			stored.Owners[id] = owner
+		}
+	}
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := s.file + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, filePerm)
+	if err != nil {
+		return fmt.Errorf("unable to write job file: %v", err)
+	}
+	return os.Rename(tmpFile, s.file)
+}
@@ -0,0 +1,158 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "jobs.json")
+
+	s, err := NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	completed, lost, owner := uuid.New(), uuid.New(), uuid.New()
+
+	for _, id := range []uuid.UUID{completed, lost} {
+		err = s.Add(id, owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Status(id) != Pending {
+			t.Errorf("unexpected status of new job: %s", s.Status(id))
+		}
+	}
+
+	err = s.Finish(completed, Completed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status(completed) != Completed {
+		t.Errorf("unexpected status of completed job: %s", s.Status(completed))
+	}
+	if s.Status(uuid.New()) != Unknown {
+		t.Error("unexpected status of unknown job")
+	}
+	if s.Owner(completed) != owner || s.Owner(uuid.New()) != uuid.Nil {
+		t.Error("unexpected owner of job")
+	}
+
+	// simulate a restart: jobs which were in-flight are reported as failed
+	s, err = NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status(lost) != Failed {
+		t.Errorf("unexpected status of lost job: %s", s.Status(lost))
+	}
+	if s.Owner(lost) != owner {
+		t.Errorf("owner of lost job was not restored: %s", s.Owner(lost))
+	}
+	if s.Status(completed) != Unknown {
+		t.Errorf("unexpected status of job completed before restart: %s", s.Status(completed))
+	}
+}
+
+func TestStore_MaxFinished(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "jobs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := uuid.New()
+	for i := 0; i <= MaxFinished; i++ {
+		id := uuid.New()
+		if i == 0 {
+			id = first
+		}
+		err = s.Add(id, uuid.New())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Finish(id, Completed)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s.Status(first) != Unknown || s.Owner(first) != uuid.Nil {
+		t.Errorf("status of oldest finished job was not dropped: %s", s.Status(first))
+	}
+}
+
+func TestStore_Suspend(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "jobs.json")
+
+	s, err := NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suspended, lost := uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{suspended, lost} {
+		err = s.Add(id, uuid.New())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.Suspend(suspended, []byte(`{"test":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Suspend(uuid.New(), nil); err == nil {
+		t.Error("unknown job was suspended")
+	}
+
+	// simulate a restart: suspended jobs stay pending, other in-flight jobs are reported as failed
+	s, err = NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status(suspended) != Pending {
+		t.Errorf("unexpected status of suspended job: %s", s.Status(suspended))
+	}
+	if s.Status(lost) != Failed {
+		t.Errorf("unexpected status of lost job: %s", s.Status(lost))
+	}
+
+	resumed, err := s.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resumed) != 1 || resumed[0].ID != suspended || string(resumed[0].Payload) != `{"test":1}` {
+		t.Fatalf("unexpected resumed jobs: %v", resumed)
+	}
+
+	// resumed jobs are not resumed again
+	s, err = NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed, _ = s.Resume(); len(resumed) != 0 {
+		t.Errorf("jobs were resumed twice: %v", resumed)
+	}
+}
+
+func TestStore_LegacyFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "jobs.json")
+	lost := uuid.New()
+
+	err := ioutil.WriteFile(file, []byte(`["`+lost.String()+`"]`), filePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status(lost) != Failed {
+		t.Errorf("unexpected status of lost job: %s", s.Status(lost))
+	}
+}
@@ -0,0 +1,105 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+const (
+	clientID     = "ubirch-client"
+	dialTimeout  = 10 * time.Second
+	batchTimeout = 10 * time.Millisecond // the records of a Produce call are published right away, they are batched by the Sink
+
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// ProducerConfig configures the connection of the BrokerProducer to the Kafka brokers
+type ProducerConfig struct {
+	Brokers       []string    // addresses of the bootstrap brokers
+	TLS           *tls.Config // nil if the connections are not encrypted
+	SASLMechanism string      // SASLPlain, SASLScramSHA256 or SASLScramSHA512, no SASL authentication if empty
+	Username      string      // SASL user name
+	Password      string      // SASL password
+}
+
+// BrokerProducer publishes records to the Kafka brokers with the kafka-go writer. The records are acknowledged
+// by all in-sync replicas. The partition of a record is the FNV-1a hash of its key modulo the number of partitions.
+//
+// The writer does not retry failed records, the retries are done by the Sink. The topic is not created
+// automatically, it must exist.
+type BrokerProducer struct {
+	writer *kafka.Writer
+}
+
+var _ Producer = (*BrokerProducer)(nil)
+
+func NewBrokerProducer(conf ProducerConfig) (*BrokerProducer, error) {
+	if len(conf.Brokers) == 0 {
+		return nil, fmt.Errorf("no Kafka brokers")
+	}
+
+	mechanism, err := saslMechanism(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BrokerProducer{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(conf.Brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			MaxAttempts:  1,
+			BatchTimeout: batchTimeout,
+			Transport: &kafka.Transport{
+				ClientID:    clientID,
+				DialTimeout: dialTimeout,
+				TLS:         conf.TLS,
+				SASL:        mechanism,
+			},
+		},
+	}, nil
+}
+
+func saslMechanism(conf ProducerConfig) (sasl.Mechanism, error) {
+	if conf.SASLMechanism == "" {
+		return nil, nil
+	}
+	if conf.Username == "" || conf.Password == "" {
+		return nil, fmt.Errorf("user name and password must be set for SASL authentication")
+	}
+
+	switch conf.SASLMechanism {
+	case SASLPlain:
+		return plain.Mechanism{Username: conf.Username, Password: conf.Password}, nil
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, conf.Username, conf.Password)
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, conf.Username, conf.Password)
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism: \"%s\", expected (\"%s\" | \"%s\" | \"%s\")",
+			conf.SASLMechanism, SASLPlain, SASLScramSHA256, SASLScramSHA512)
+	}
+}
+
+// Produce publishes the records to the topic and waits until they were acknowledged
+func (p *BrokerProducer) Produce(ctx context.Context, topic string, records []Record) error {
+	messages := make([]kafka.Message, len(records))
+	for i, r := range records {
+		messages[i] = kafka.Message{Topic: topic, Key: r.Key, Value: r.Value}
+	}
+	return p.writer.WriteMessages(ctx, messages...)
+}
+
+// Close closes the connections to the brokers
+func (p *BrokerProducer) Close() error {
+	return p.writer.Close()
+}
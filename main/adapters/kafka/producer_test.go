@@ -0,0 +1,69 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestNewBrokerProducer(t *testing.T) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	p, err := NewBrokerProducer(ProducerConfig{
+		Brokers:       []string{"kafka-1:9092", "kafka-2:9092"},
+		TLS:           tlsConfig,
+		SASLMechanism: SASLScramSHA512,
+		Username:      "user",
+		Password:      "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.writer.RequiredAcks != kafka.RequireAll {
+		t.Errorf("unexpected required acks: %v", p.writer.RequiredAcks)
+	}
+	transport, ok := p.writer.Transport.(*kafka.Transport)
+	if !ok {
+		t.Fatalf("unexpected transport: %T", p.writer.Transport)
+	}
+	if transport.TLS != tlsConfig || transport.SASL == nil || transport.SASL.Name() != SASLScramSHA512 {
+		t.Errorf("unexpected transport: TLS: %v, SASL: %v", transport.TLS, transport.SASL)
+	}
+
+	for name, conf := range map[string]ProducerConfig{
+		"no brokers":            {},
+		"unsupported mechanism": {Brokers: []string{"kafka:9092"}, SASLMechanism: "GSSAPI", Username: "user", Password: "secret"},
+		"missing password":      {Brokers: []string{"kafka:9092"}, SASLMechanism: SASLPlain, Username: "user"},
+	} {
+		if _, err = NewBrokerProducer(conf); err == nil {
+			t.Errorf("%s: invalid config was accepted", name)
+		}
+	}
+}
+
+func TestBrokerProducer_Unreachable(t *testing.T) {
+	// find a free TCP port, on which no broker is listening
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	p, err := NewBrokerProducer(ProducerConfig{Brokers: []string{addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = p.Produce(ctx, "signed-upps", []Record{{Key: []byte("key"), Value: []byte("value")}}); err == nil {
+		t.Error("records were published without broker")
+	}
+}
@@ -0,0 +1,155 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/asyncqueue"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultBufferSize   = 1000
+	DefaultDrainTimeout = 5 * time.Second // time to publish the buffered messages on shutdown
+
+	maxBatchSize   = 100                    // maximum number of messages per produce request
+	produceTimeout = 10 * time.Second       // timeout of a produce request
+	maxAttempts    = 3                      // attempts to publish a batch before its messages are dropped
+	retryBackoff   = 500 * time.Millisecond // wait time before the first retry, doubled with each further retry
+)
+
+// Message is published for each UPP which was acknowledged by the UBIRCH backend.
+// It must never contain secrets like auth tokens.
+type Message struct {
+	Timestamp     time.Time `json:"timestamp"`
+	UUID          uuid.UUID `json:"uuid"`
+	Operation     string    `json:"operation"`
+	Hash          []byte    `json:"hash"`
+	UPP           []byte    `json:"upp"`
+	RequestID     string    `json:"requestID,omitempty"`
+	BackendStatus int       `json:"backendStatus"`
+}
+
+// Record is a Kafka record. Records with the same key are published to the same partition.
+type Record struct {
+	Key   []byte
+	Value []byte
+}
+
+// Producer publishes records to a Kafka topic
+type Producer interface {
+	Produce(ctx context.Context, topic string, records []Record) error
+	Close() error
+}
+
+// Sink publishes messages to a Kafka topic. Messages are published asynchronously, so that signing is never
+// blocked by a slow or unreachable Kafka cluster. They are buffered and published in batches by a single
+// publisher, so the messages of an identity are published in the order in which they were added. The key
+// of a record is the UUID, so the messages of an identity are published to the same partition.
+//
+// Messages are dropped and counted if the buffer is full, if their batch could not be published after
+// three attempts, or if they are still buffered when the drain timeout elapses on shutdown.
+type Sink struct {
+	producer     Producer
+	topic        string
+	retryBackoff time.Duration
+	queue        *asyncqueue.Queue
+}
+
+func NewSink(producer Producer, topic string, bufferSize int) *Sink {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	s := &Sink{
+		producer:     producer,
+		topic:        topic,
+		retryBackoff: retryBackoff,
+	}
+	s.queue = asyncqueue.New(bufferSize, s.publish)
+	return s
+}
+
+// Publish adds a message to the sink without waiting for it to be published.
+// It returns false if the buffer is full or the sink was closed and the message was dropped.
+func (s *Sink) Publish(m Message) bool {
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now().UTC()
+	}
+
+	return s.queue.Add(m)
+}
+
+// Dropped returns the number of messages which were dropped
+func (s *Sink) Dropped() uint64 {
+	return s.queue.Dropped()
+}
+
+// Close publishes the buffered messages until the drain timeout elapses, drops the remaining messages
+// and closes the producer. Messages which are published after the sink was closed are dropped.
+func (s *Sink) Close(drainTimeout time.Duration) error {
+	s.queue.Close(drainTimeout)
+
+	if dropped := s.Dropped(); dropped > 0 {
+		log.Warnf("kafka sink: %d messages were dropped", dropped)
+	}
+	return s.producer.Close()
+}
+
+// publish publishes the buffered messages in batches until the sink is closed
+func (s *Sink) publish(ctx context.Context, messages <-chan interface{}) {
+	for m := range messages {
+		batch := []Message{m.(Message)}
+		for len(batch) < maxBatchSize && len(messages) > 0 {
+			if m, ok := <-messages; ok {
+				batch = append(batch, m.(Message))
+			}
+		}
+
+		if ctx.Err() != nil {
+			// the drain timeout elapsed, the buffered messages are dropped
+			s.queue.Drop(len(batch))
+			continue
+		}
+
+		err := s.publishBatch(ctx, batch)
+		if err != nil {
+			log.Errorf("kafka sink: publishing %d messages to topic %s failed: %v", len(batch), s.topic, err)
+			s.queue.Drop(len(batch))
+		}
+	}
+}
+
+// publishBatch publishes the batch, retrying with exponential backoff
+func (s *Sink) publishBatch(ctx context.Context, batch []Message) error {
+	records := make([]Record, 0, len(batch))
+	for _, m := range batch {
+		value, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		records = append(records, Record{Key: []byte(m.UUID.String()), Value: value})
+	}
+
+	var err error
+	backoff := s.retryBackoff
+	for attempt := 1; ; attempt++ {
+		produceCtx, cancel := context.WithTimeout(ctx, produceTimeout)
+		err = s.producer.Produce(produceCtx, s.topic, records)
+		cancel()
+		if err == nil || attempt == maxAttempts {
+			return err
+		}
+
+		log.Warnf("kafka sink: publishing %d messages failed: %v, retrying in %s", len(records), err, backoff)
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type mockProducer struct {
+	mutex    sync.Mutex
+	records  []Record
+	failures int           // number of produce requests which fail before requests succeed
+	block    chan struct{} // if set, produce requests block until it is closed or the context is done
+	closed   bool
+}
+
+func (m *mockProducer) Produce(ctx context.Context, topic string, records []Record) error {
+	if m.block != nil {
+		select {
+		case <-m.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if topic != "upps" {
+		return fmt.Errorf("unexpected topic: %s", topic)
+	}
+	if m.failures > 0 {
+		m.failures--
+		return fmt.Errorf("broker not available")
+	}
+	m.records = append(m.records, records...)
+	return nil
+}
+
+func (m *mockProducer) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.closed = true
+	return nil
+}
+
+func (m *mockProducer) messages(t *testing.T) []Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var messages []Message
+	for _, r := range m.records {
+		var msg Message
+		if err := json.Unmarshal(r.Value, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Key) != msg.UUID.String() {
+			t.Errorf("unexpected record key: %s", r.Key)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func TestSink(t *testing.T) {
+	producer := &mockProducer{}
+	sink := NewSink(producer, "upps", 0)
+
+	uid := uuid.New()
+	for i := 0; i < 250; i++ {
+		if !sink.Publish(Message{UUID: uid, Operation: "chain", Hash: []byte{byte(i)}, BackendStatus: 200}) {
+			t.Fatalf("message %d was dropped", i)
+		}
+	}
+
+	if err := sink.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !producer.closed {
+		t.Error("producer was not closed")
+	}
+
+	messages := producer.messages(t)
+	if len(messages) != 250 {
+		t.Fatalf("unexpected number of messages: %d", len(messages))
+	}
+	for i, msg := range messages {
+		if msg.Hash[0] != byte(i) {
+			t.Errorf("message %d was published out of order", i)
+		}
+		if msg.Timestamp.IsZero() {
+			t.Errorf("message %d has no timestamp", i)
+		}
+	}
+
+	if sink.Publish(Message{UUID: uid}) || sink.Dropped() != 1 {
+		t.Error("message was accepted after the sink was closed")
+	}
+}
+
+func TestSink_Retry(t *testing.T) {
+	producer := &mockProducer{failures: maxAttempts - 1}
+	sink := NewSink(producer, "upps", 0)
+	sink.retryBackoff = time.Millisecond
+
+	sink.Publish(Message{UUID: uuid.New()})
+	if err := sink.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.messages(t)) != 1 || sink.Dropped() != 0 {
+		t.Errorf("message was not published after retry: dropped %d", sink.Dropped())
+	}
+
+	// the messages are dropped if all attempts fail
+	producer = &mockProducer{failures: maxAttempts}
+	sink = NewSink(producer, "upps", 0)
+	sink.retryBackoff = time.Millisecond
+
+	sink.Publish(Message{UUID: uuid.New()})
+	if err := sink.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.messages(t)) != 0 || sink.Dropped() != 1 {
+		t.Errorf("unexpected number of dropped messages: %d", sink.Dropped())
+	}
+}
+
+func TestSink_BoundedLoss(t *testing.T) {
+	// a broker which does not respond
+	producer := &mockProducer{block: make(chan struct{})}
+	sink := NewSink(producer, "upps", 10)
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		sink.Publish(Message{UUID: uuid.New()})
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("publishing was blocked by the producer")
+	}
+
+	// at most the buffer and the batch which is being published are lost
+	if err := sink.Close(50 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if sink.Dropped() != 20 {
+		t.Errorf("unexpected number of dropped messages: %d", sink.Dropped())
+	}
+}
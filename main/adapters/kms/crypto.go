@@ -0,0 +1,333 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/encrypters"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	awskms "github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+const (
+	UUIDTagKey     = "ubirch-uuid" // tag of the KMS keys with the UUID of the identity
+	DefaultTimeout = 15 * time.Second
+
+	signatureLength = 64 // length of a raw P-256 signature: r || s
+)
+
+// Client is the part of the AWS KMS API which is used by the crypto context, so that it can be mocked
+type Client interface {
+	CreateKey(ctx context.Context, params *awskms.CreateKeyInput, optFns ...func(*awskms.Options)) (*awskms.CreateKeyOutput, error)
+	GetPublicKey(ctx context.Context, params *awskms.GetPublicKeyInput, optFns ...func(*awskms.Options)) (*awskms.GetPublicKeyOutput, error)
+	Sign(ctx context.Context, params *awskms.SignInput, optFns ...func(*awskms.Options)) (*awskms.SignOutput, error)
+}
+
+// CryptoContext is a ubirch.Crypto which generates the keys of new identities as asymmetric keys in AWS KMS
+// and signs with them, so that the private keys never leave KMS. Instead of the private key, a reference to
+// the KMS key is stored. Identities with private keys which are not held by KMS, e.g. which were created
+// before KMS was enabled, continue to work with the local ECDSA crypto context.
+type CryptoContext struct {
+	ubirch.ECDSACryptoContext
+	Client  Client
+	Timeout time.Duration // timeout of the KMS requests, which are not bound to the context of a request
+}
+
+// Ensure CryptoContext implements the Crypto interface and the optional interfaces of the protocol
+var (
+	_ ubirch.Crypto               = (*CryptoContext)(nil)
+	_ repository.ContextCrypto    = (*CryptoContext)(nil)
+	_ repository.UUIDKeyGenerator = (*CryptoContext)(nil)
+)
+
+func NewCryptoContext(client Client, timeout time.Duration) *CryptoContext {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &CryptoContext{
+		Client:  client,
+		Timeout: timeout,
+	}
+}
+
+// NewCryptoContextFromEnv creates the crypto context with a KMS client, which takes the AWS region and
+// credentials from the standard AWS environment variables and shared configuration files
+func NewCryptoContextFromEnv(ctx context.Context, timeout time.Duration) (*CryptoContext, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS configuration: %v", err)
+	}
+	if awsConfig.Region == "" {
+		return nil, fmt.Errorf("AWS region is not set, set the environment variable AWS_REGION")
+	}
+	return NewCryptoContext(awskms.NewFromConfig(awsConfig), timeout), nil
+}
+
+// KeyReference returns the PEM encoded reference to the KMS key, which is stored instead of a private key
+func KeyReference(keyID string) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: encrypters.KeyReferencePEMType, Bytes: []byte(keyID)})
+}
+
+// keyID returns the ID of the KMS key, if the private key is a reference to a KMS key
+func keyID(privKeyPEM []byte) (string, bool) {
+	if !encrypters.IsKeyReference(privKeyPEM) {
+		return "", false
+	}
+	block, _ := pem.Decode(privKeyPEM)
+	return string(block.Bytes), true
+}
+
+func (c *CryptoContext) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.Timeout)
+}
+
+// GenerateKey creates a new key in KMS and returns the reference to it
+func (c *CryptoContext) GenerateKey() (privKeyPEM []byte, err error) {
+	return c.generateKey(nil)
+}
+
+// GenerateKeyForUUID creates a new key in KMS, which is tagged with the UUID, and returns the reference to it
+func (c *CryptoContext) GenerateKeyForUUID(uid uuid.UUID) (privKeyPEM []byte, err error) {
+	return c.generateKey([]types.Tag{{TagKey: aws.String(UUIDTagKey), TagValue: aws.String(uid.String())}})
+}
+
+func (c *CryptoContext) generateKey(tags []types.Tag) ([]byte, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	resp, err := c.Client.CreateKey(ctx, &awskms.CreateKeyInput{
+		KeySpec:     types.KeySpecEccNistP256,
+		KeyUsage:    types.KeyUsageTypeSignVerify,
+		Description: aws.String("ubirch client identity key"),
+		Tags:        tags,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating KMS key failed: %v", err)
+	}
+	if resp.KeyMetadata == nil || aws.ToString(resp.KeyMetadata.KeyId) == "" {
+		return nil, fmt.Errorf("creating KMS key failed: no key ID in response")
+	}
+
+	return KeyReference(aws.ToString(resp.KeyMetadata.KeyId)), nil
+}
+
+// GetPublicKeyFromPrivateKey requests the public key of a KMS key from KMS
+func (c *CryptoContext) GetPublicKeyFromPrivateKey(privKeyPEM []byte) ([]byte, error) {
+	id, ok := keyID(privKeyPEM)
+	if !ok {
+		return c.ECDSACryptoContext.GetPublicKeyFromPrivateKey(privKeyPEM)
+	}
+
+	pub, err := c.publicKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return c.EncodePublicKey(pub)
+}
+
+func (c *CryptoContext) publicKey(keyID string) (*ecdsa.PublicKey, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	resp, err := c.Client.GetPublicKey(ctx, &awskms.GetPublicKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return nil, fmt.Errorf("requesting public key of KMS key %s failed: %v", keyID, err)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse public key of KMS key %s: %v", keyID, err)
+	}
+
+	ecdsaPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok || ecdsaPub.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("KMS key %s is not an ECC_NIST_P256 key", keyID)
+	}
+	return ecdsaPub, nil
+}
+
+func (c *CryptoContext) Sign(privKeyPEM []byte, data []byte) ([]byte, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	return c.SignContext(ctx, privKeyPEM, data)
+}
+
+// SignContext signs the SHA256 hash of the data with the KMS key. The KMS request is canceled with the context.
+func (c *CryptoContext) SignContext(ctx context.Context, privKeyPEM []byte, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty data")
+	}
+
+	hash := sha256.Sum256(data)
+	return c.signHash(ctx, privKeyPEM, hash[:])
+}
+
+func (c *CryptoContext) SignHash(privKeyPEM []byte, hash []byte) ([]byte, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	return c.signHash(ctx, privKeyPEM, hash)
+}
+
+func (c *CryptoContext) signHash(ctx context.Context, privKeyPEM []byte, hash []byte) ([]byte, error) {
+	id, ok := keyID(privKeyPEM)
+	if !ok {
+		return c.ECDSACryptoContext.SignHash(privKeyPEM, hash)
+	}
+
+	if len(hash) != sha256.Size {
+		return nil, fmt.Errorf("invalid sha256 size: expected %d, got %d", sha256.Size, len(hash))
+	}
+
+	der, err := c.signDigest(ctx, id, hash)
+	if err != nil {
+		return nil, err
+	}
+	return rawSignature(der)
+}
+
+// signDigest signs the SHA256 digest with the KMS key and returns the ASN.1 DER encoded signature
+func (c *CryptoContext) signDigest(ctx context.Context, keyID string, digest []byte) ([]byte, error) {
+	resp, err := c.Client.Sign(ctx, &awskms.SignInput{
+		KeyId:            aws.String(keyID),
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: types.SigningAlgorithmSpecEcdsaSha256,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("signing with KMS key %s failed: %v", keyID, err)
+	}
+	return resp.Signature, nil
+}
+
+// rawSignature converts an ASN.1 DER encoded ECDSA signature to the raw signature (r || s) of the ubirch protocol
+func rawSignature(der []byte) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil || len(rest) != 0 || sig.R == nil || sig.S == nil {
+		return nil, fmt.Errorf("invalid ECDSA signature from KMS")
+	}
+	if sig.R.BitLen() > 256 || sig.S.BitLen() > 256 {
+		return nil, fmt.Errorf("invalid ECDSA signature from KMS: r or s exceeds 32 bytes")
+	}
+
+	raw := make([]byte, signatureLength)
+	sig.R.FillBytes(raw[:signatureLength/2])
+	sig.S.FillBytes(raw[signatureLength/2:])
+	return raw, nil
+}
+
+// GetSignedKeyRegistration creates the self-signed JSON key certificate like the ECDSA crypto context,
+// but signs it with the KMS key
+func (c *CryptoContext) GetSignedKeyRegistration(privKeyPEM []byte, uid uuid.UUID) ([]byte, error) {
+	if _, ok := keyID(privKeyPEM); !ok {
+		return c.ECDSACryptoContext.GetSignedKeyRegistration(privKeyPEM, uid)
+	}
+
+	const timeFormat = "2006-01-02T15:04:05.000Z"
+
+	pubKeyPEM, err := c.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := c.PublicKeyPEMToBytes(pubKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	keyRegistration := ubirch.KeyRegistration{
+		Algorithm:      "ecdsa-p256v1",
+		Created:        now.Format(timeFormat),
+		HwDeviceId:     uid.String(),
+		PubKey:         base64.StdEncoding.EncodeToString(pubKey),
+		PubKeyId:       base64.StdEncoding.EncodeToString(pubKey),
+		ValidNotAfter:  now.Add(10 * 365 * 24 * time.Hour).Format(timeFormat), // valid for 10 years
+		ValidNotBefore: now.Format(timeFormat),
+	}
+
+	jsonKeyReg, err := json.Marshal(keyRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := c.Sign(privKeyPEM, jsonKeyReg)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(ubirch.SignedKeyRegistration{
+		PubKeyInfo: keyRegistration,
+		Signature:  base64.StdEncoding.EncodeToString(signature),
+	})
+}
+
+// GetCSR creates the certificate signing request like the ECDSA crypto context, but signs it with the KMS key
+func (c *CryptoContext) GetCSR(privKeyPEM []byte, id uuid.UUID, subjectCountry string, subjectOrganization string) ([]byte, error) {
+	keyID, ok := keyID(privKeyPEM)
+	if !ok {
+		return c.ECDSACryptoContext.GetCSR(privKeyPEM, id, subjectCountry, subjectOrganization)
+	}
+
+	pub, err := c.publicKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.CertificateRequest{
+		SignatureAlgorithm: x509.ECDSAWithSHA256,
+		Subject: pkix.Name{
+			Country:      []string{subjectCountry},
+			Organization: []string{subjectOrganization},
+			CommonName:   id.String(),
+		},
+	}
+
+	return x509.CreateCertificateRequest(rand.Reader, template, &signer{crypto: c, keyID: keyID, pub: pub})
+}
+
+// signer is a crypto.Signer for a KMS key
+type signer struct {
+	crypto *CryptoContext
+	keyID  string
+	pub    *ecdsa.PublicKey
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 {
+		return nil, fmt.Errorf("unsupported hash function: %v", opts.HashFunc())
+	}
+
+	ctx, cancel := s.crypto.context()
+	defer cancel()
+
+	return s.crypto.signDigest(ctx, s.keyID, digest)
+}
@@ -0,0 +1,264 @@
+package kms
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/encrypters"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	awskms "github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// mockClient holds the keys in memory and behaves like AWS KMS
+type mockClient struct {
+	keys  map[string]*ecdsa.PrivateKey
+	tags  map[string][]types.Tag
+	mutex sync.Mutex
+}
+
+func newMockClient() *mockClient {
+	return &mockClient{
+		keys: map[string]*ecdsa.PrivateKey{},
+		tags: map[string][]types.Tag{},
+	}
+}
+
+func (m *mockClient) CreateKey(_ context.Context, params *awskms.CreateKeyInput, _ ...func(*awskms.Options)) (*awskms.CreateKeyOutput, error) {
+	if params.KeySpec != types.KeySpecEccNistP256 || params.KeyUsage != types.KeyUsageTypeSignVerify {
+		return nil, fmt.Errorf("unexpected key spec or usage: %s, %s", params.KeySpec, params.KeyUsage)
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	id := uuid.NewString()
+	m.keys[id] = priv
+	m.tags[id] = params.Tags
+	return &awskms.CreateKeyOutput{KeyMetadata: &types.KeyMetadata{KeyId: aws.String(id)}}, nil
+}
+
+func (m *mockClient) key(keyID *string) (*ecdsa.PrivateKey, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	priv, found := m.keys[aws.ToString(keyID)]
+	if !found {
+		return nil, fmt.Errorf("NotFoundException: key %s does not exist", aws.ToString(keyID))
+	}
+	return priv, nil
+}
+
+func (m *mockClient) GetPublicKey(_ context.Context, params *awskms.GetPublicKeyInput, _ ...func(*awskms.Options)) (*awskms.GetPublicKeyOutput, error) {
+	priv, err := m.key(params.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &awskms.GetPublicKeyOutput{KeyId: params.KeyId, PublicKey: der}, nil
+}
+
+func (m *mockClient) Sign(_ context.Context, params *awskms.SignInput, _ ...func(*awskms.Options)) (*awskms.SignOutput, error) {
+	if params.MessageType != types.MessageTypeDigest || params.SigningAlgorithm != types.SigningAlgorithmSpecEcdsaSha256 {
+		return nil, fmt.Errorf("unexpected message type or signing algorithm: %s, %s", params.MessageType, params.SigningAlgorithm)
+	}
+
+	priv, err := m.key(params.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := ecdsa.SignASN1(rand.Reader, priv, params.Message)
+	if err != nil {
+		return nil, err
+	}
+	return &awskms.SignOutput{KeyId: params.KeyId, Signature: der}, nil
+}
+
+func TestCryptoContext(t *testing.T) {
+	client := newMockClient()
+	c := NewCryptoContext(client, 0)
+	uid := uuid.New()
+
+	privKeyPEM, err := c.GenerateKeyForUUID(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !encrypters.IsKeyReference(privKeyPEM) {
+		t.Fatalf("generated key is not a key reference: %s", privKeyPEM)
+	}
+
+	id, _ := keyID(privKeyPEM)
+	tags := client.tags[id]
+	if len(tags) != 1 || aws.ToString(tags[0].TagKey) != UUIDTagKey || aws.ToString(tags[0].TagValue) != uid.String() {
+		t.Errorf("KMS key is not tagged with the UUID: %v", tags)
+	}
+
+	pubKeyPEM, err := c.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("test data")
+	signature, err := c.Sign(privKeyPEM, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signature) != c.SignatureLength() {
+		t.Errorf("unexpected signature length: expected %d, got %d", c.SignatureLength(), len(signature))
+	}
+
+	verified, err := c.Verify(pubKeyPEM, data, signature)
+	if err != nil || !verified {
+		t.Errorf("signature could not be verified with the public key of the KMS key: %v", err)
+	}
+}
+
+func TestCryptoContext_UPP(t *testing.T) {
+	c := NewCryptoContext(newMockClient(), 0)
+	uid := uuid.New()
+
+	privKeyPEM, err := c.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyPEM, err := c.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &ubirch.Protocol{Crypto: c}
+
+	hash := make([]byte, c.HashLength())
+	upp, err := p.Sign(privKeyPEM, &ubirch.SignedUPP{Version: ubirch.Signed, Uuid: uid, Hint: ubirch.Binary, Payload: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := p.Verify(pubKeyPEM, upp)
+	if err != nil || !verified {
+		t.Errorf("UPP could not be verified: %v", err)
+	}
+}
+
+func TestCryptoContext_GetSignedKeyRegistration(t *testing.T) {
+	c := NewCryptoContext(newMockClient(), 0)
+	uid := uuid.New()
+
+	privKeyPEM, err := c.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyPEM, err := c.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyRegistration, err := c.GetSignedKeyRegistration(privKeyPEM, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cert ubirch.SignedKeyRegistration
+	err = json.Unmarshal(keyRegistration, &cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.PubKeyInfo.HwDeviceId != uid.String() {
+		t.Errorf("unexpected hwDeviceId: %s", cert.PubKeyInfo.HwDeviceId)
+	}
+
+	signedData, err := json.Marshal(cert.PubKeyInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(cert.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := c.Verify(pubKeyPEM, signedData, signature)
+	if err != nil || !verified {
+		t.Errorf("key registration could not be verified: %v", err)
+	}
+}
+
+func TestCryptoContext_GetCSR(t *testing.T) {
+	c := NewCryptoContext(newMockClient(), 0)
+	uid := uuid.New()
+
+	privKeyPEM, err := c.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrDER, err := c.GetCSR(privKeyPEM, uid, "DE", "ubirch GmbH")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = csr.CheckSignature(); err != nil {
+		t.Errorf("CSR signature could not be verified: %v", err)
+	}
+	if csr.Subject.CommonName != uid.String() {
+		t.Errorf("unexpected common name: %s", csr.Subject.CommonName)
+	}
+}
+
+func TestCryptoContext_LocalKey(t *testing.T) {
+	c := NewCryptoContext(newMockClient(), 0)
+
+	// keys which are not held by KMS are handled by the ECDSA crypto context
+	privKeyPEM, err := c.ECDSACryptoContext.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyPEM, err := c.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("test data")
+	signature, err := c.Sign(privKeyPEM, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := c.Verify(pubKeyPEM, data, signature)
+	if err != nil || !verified {
+		t.Errorf("signature could not be verified: %v", err)
+	}
+}
+
+func TestCryptoContext_UnknownKey(t *testing.T) {
+	c := NewCryptoContext(newMockClient(), 0)
+
+	_, err := c.Sign(KeyReference("unknown"), []byte("test data"))
+	if err == nil {
+		t.Error("signing with an unknown KMS key did not fail")
+	}
+}
@@ -0,0 +1,252 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	log "github.com/sirupsen/logrus"
+	prom "github.com/ubirch/ubirch-client-go/main/prometheus"
+)
+
+const (
+	// UUIDPlaceholder marks the level of a topic which carries the UUID of the identity
+	UUIDPlaceholder = "<uuid>"
+
+	DefaultWorkers   = 10   // number of go routines which handle the received messages
+	DefaultQueueSize = 1000 // maximum number of received messages which wait for a worker
+
+	publishTimeout      = 10 * time.Second
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
+// MessageHandler handles a message, which was received on the request topic of an identity, and returns
+// the reply, which is published to the response topic of the identity. If the message is malformed or
+// unauthorized, an error is returned and no reply is published.
+type MessageHandler interface {
+	HandleMessage(uid uuid.UUID, payload []byte) (reply []byte, err error)
+}
+
+// Client is a connection to an MQTT broker
+type Client interface {
+	// Connect connects to the broker
+	Connect(ctx context.Context) error
+	// Subscribe subscribes to the topic filter and passes the received messages to the handler
+	Subscribe(ctx context.Context, filter string, handler func(topic string, payload []byte)) error
+	// Publish publishes the payload to the topic
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Done returns a channel which is closed when the connection is lost or disconnected
+	Done() <-chan struct{}
+	// Err returns the error which terminated the connection
+	Err() error
+	// Disconnect disconnects from the broker gracefully
+	Disconnect() error
+}
+
+// Bridge subscribes to the request topics of all identities and passes the received messages to the
+// message handler. The replies are published to the response topic of the identity. The UUID of the
+// identity is carried by the level of the topics which is marked with the UUID placeholder,
+// e.g. "ubirch/<uuid>/hash".
+//
+// The messages are handled by a fixed number of workers. If all workers are busy and the queue is full,
+// further messages are dropped, so that a flood of messages can not exhaust the resources of the client.
+type Bridge struct {
+	Workers   int // number of workers which handle the messages, defaults to DefaultWorkers
+	QueueSize int // maximum number of messages which wait for a worker, defaults to DefaultQueueSize
+
+	client        Client
+	handler       MessageHandler
+	filter        string // subscription filter of the request topics
+	uuidLevel     int    // index of the topic level which carries the UUID
+	responseTopic string
+
+	reconnectBackoff time.Duration // wait time before the first reconnect, doubled with each further reconnect
+}
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+func NewBridge(client Client, topic, responseTopic string, handler MessageHandler) (*Bridge, error) {
+	uuidLevel, err := parseTopic(topic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT topic \"%s\": %v", topic, err)
+	}
+	if _, err = parseTopic(responseTopic); err != nil {
+		return nil, fmt.Errorf("invalid MQTT response topic \"%s\": %v", responseTopic, err)
+	}
+
+	return &Bridge{
+		client:        client,
+		handler:       handler,
+		filter:        strings.Replace(topic, UUIDPlaceholder, "+", 1),
+		uuidLevel:     uuidLevel,
+		responseTopic: responseTopic,
+
+		reconnectBackoff: minReconnectBackoff,
+	}, nil
+}
+
+// parseTopic returns the index of the topic level which is marked with the UUID placeholder.
+// The placeholder must mark exactly one complete level and the topic must not contain wildcards.
+func parseTopic(topic string) (int, error) {
+	if strings.ContainsAny(topic, "+#") {
+		return 0, fmt.Errorf("wildcards are not allowed")
+	}
+
+	uuidLevel := -1
+	for i, level := range strings.Split(topic, "/") {
+		if level == UUIDPlaceholder {
+			if uuidLevel != -1 {
+				return 0, fmt.Errorf("more than one level is marked with %s", UUIDPlaceholder)
+			}
+			uuidLevel = i
+		} else if strings.Contains(level, UUIDPlaceholder) {
+			return 0, fmt.Errorf("%s must mark a complete level", UUIDPlaceholder)
+		}
+	}
+	if uuidLevel == -1 {
+		return 0, fmt.Errorf("no level is marked with %s", UUIDPlaceholder)
+	}
+	return uuidLevel, nil
+}
+
+// Serve connects to the broker and passes the received messages to the message handler until the
+// context is cancelled. If the connection is lost, it reconnects with exponential backoff.
+// On shutdown, the replies to the messages in progress are published before it disconnects.
+func (b *Bridge) Serve(cancelCtx context.Context) error {
+	backoff := b.reconnectBackoff
+	for {
+		connected, err := b.session(cancelCtx)
+		if cancelCtx.Err() != nil {
+			return nil
+		}
+		if connected {
+			backoff = b.reconnectBackoff
+		}
+
+		log.Warnf("MQTT connection failed: %v, reconnecting in %s", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-cancelCtx.Done():
+			return nil
+		}
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// session connects to the broker and subscribes to the request topics. It returns when the
+// context is cancelled or the connection is lost, and whether the subscription was established.
+func (b *Bridge) session(cancelCtx context.Context) (bool, error) {
+	if err := b.client.Connect(cancelCtx); err != nil {
+		return false, err
+	}
+
+	workers := b.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	queueSize := b.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+
+	queue := make(chan mqttMessage, queueSize)
+	inProgress := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		inProgress.Add(1)
+		go func() {
+			defer inProgress.Done()
+			for m := range queue {
+				b.handle(m.topic, m.payload)
+			}
+		}()
+	}
+
+	stopping := false
+	mutex := &sync.Mutex{} // guards stopping, so that no message is queued after the queue was closed
+	stop := func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !stopping {
+			stopping = true
+			close(queue)
+		}
+	}
+	defer stop()
+
+	err := b.client.Subscribe(cancelCtx, b.filter, func(topic string, payload []byte) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if stopping {
+			return
+		}
+		select {
+		case queue <- mqttMessage{topic: topic, payload: payload}:
+		default:
+			prom.MQTTDroppedMessages.Inc()
+			log.Debugf("dropped MQTT message on topic %s: queue is full", topic)
+		}
+	})
+	if err != nil {
+		_ = b.client.Disconnect()
+		return false, err
+	}
+	log.Infof("subscribed to MQTT topic %s", b.filter)
+
+	select {
+	case <-cancelCtx.Done():
+		// the messages in the queue are handled before the bridge disconnects
+		stop()
+		inProgress.Wait()
+		if err = b.client.Disconnect(); err != nil {
+			log.Warnf("could not disconnect from MQTT broker: %v", err)
+		} else {
+			log.Debug("shut down MQTT bridge")
+		}
+		return true, nil
+	case <-b.client.Done():
+		return true, b.client.Err()
+	}
+}
+
+func (b *Bridge) handle(topic string, payload []byte) {
+	levels := strings.Split(topic, "/")
+	if len(levels) <= b.uuidLevel {
+		prom.MQTTDroppedMessages.Inc()
+		log.Warnf("dropped MQTT message on topic %s: unexpected topic", topic)
+		return
+	}
+
+	uid, err := uuid.Parse(levels[b.uuidLevel])
+	if err != nil {
+		prom.MQTTDroppedMessages.Inc()
+		log.Warnf("dropped MQTT message on topic %s: invalid UUID: %v", topic, err)
+		return
+	}
+
+	reply, err := b.handler.HandleMessage(uid, payload)
+	if err != nil {
+		prom.MQTTDroppedMessages.Inc()
+		log.Warnf("dropped MQTT message on topic %s: %v", topic, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	responseTopic := strings.Replace(b.responseTopic, UUIDPlaceholder, uid.String(), 1)
+	if err = b.client.Publish(ctx, responseTopic, reply); err != nil {
+		log.Errorf("%s: unable to publish MQTT reply to topic %s: %v", uid, responseTopic, err)
+	}
+}
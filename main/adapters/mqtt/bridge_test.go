@@ -0,0 +1,253 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type published struct {
+	topic   string
+	payload string
+}
+
+type mockClient struct {
+	mutex       sync.Mutex
+	connects    int
+	filter      string
+	handler     func(topic string, payload []byte)
+	published   []published
+	done        chan struct{}
+	err         error
+	disconnects int
+}
+
+func (m *mockClient) Connect(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.connects++
+	m.done = make(chan struct{})
+	m.err = nil
+	return nil
+}
+
+func (m *mockClient) Subscribe(ctx context.Context, filter string, handler func(topic string, payload []byte)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.filter = filter
+	m.handler = handler
+	return nil
+}
+
+func (m *mockClient) Publish(ctx context.Context, topic string, payload []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.published = append(m.published, published{topic: topic, payload: string(payload)})
+	return nil
+}
+
+func (m *mockClient) Done() <-chan struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.done
+}
+
+func (m *mockClient) Err() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.err
+}
+
+func (m *mockClient) Disconnect() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.disconnects++
+	m.handler = nil
+	close(m.done)
+	return nil
+}
+
+// receive passes a message to the handler of the subscription, as the client does for received messages
+func (m *mockClient) receive(topic, payload string) {
+	m.mutex.Lock()
+	handler := m.handler
+	m.mutex.Unlock()
+	handler(topic, []byte(payload))
+}
+
+// lose closes the connection with an error
+func (m *mockClient) lose(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.handler = nil
+	m.err = err
+	close(m.done)
+}
+
+func (m *mockClient) state() (connects int, filter string, published []published, disconnects int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.connects, m.filter, append(published, m.published...), m.disconnects
+}
+
+// echoHandler replies with the payload, unless the payload is "malformed"
+type echoHandler struct {
+	delay time.Duration
+}
+
+func (e echoHandler) HandleMessage(uid uuid.UUID, payload []byte) ([]byte, error) {
+	time.Sleep(e.delay)
+	if string(payload) == "malformed" {
+		return nil, fmt.Errorf("malformed message")
+	}
+	return []byte(fmt.Sprintf("%s:%s", uid, payload)), nil
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	for start := time.Now(); !condition(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timeout")
+		}
+	}
+}
+
+func TestNewBridge(t *testing.T) {
+	for _, topic := range []string{"<uuid>", "ubirch/<uuid>/hash", "/<uuid>/"} {
+		if _, err := NewBridge(&mockClient{}, topic, "ubirch/<uuid>/response", echoHandler{}); err != nil {
+			t.Errorf("%s: %v", topic, err)
+		}
+	}
+
+	for _, topic := range []string{"", "ubirch/hash", "ubirch/+/hash", "ubirch/<uuid>/#", "ubirch/<uuid>/<uuid>", "ubirch/id-<uuid>"} {
+		if _, err := NewBridge(&mockClient{}, topic, "ubirch/<uuid>/response", echoHandler{}); err == nil {
+			t.Errorf("%s: invalid topic was accepted", topic)
+		}
+		if _, err := NewBridge(&mockClient{}, "ubirch/<uuid>/hash", topic, echoHandler{}); err == nil {
+			t.Errorf("%s: invalid response topic was accepted", topic)
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	client := &mockClient{}
+	bridge, err := NewBridge(client, "ubirch/<uuid>/hash", "ubirch/<uuid>/response", echoHandler{delay: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge.reconnectBackoff = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- bridge.Serve(ctx)
+	}()
+	waitFor(t, func() bool { _, filter, _, _ := client.state(); return filter != "" })
+
+	_, filter, _, _ := client.state()
+	if filter != "ubirch/+/hash" {
+		t.Errorf("unexpected subscription filter: %s", filter)
+	}
+
+	// malformed messages and messages with invalid UUIDs are dropped without reply
+	uid := uuid.New()
+	client.receive(fmt.Sprintf("ubirch/%s/hash", uid), "malformed")
+	client.receive("ubirch/not-a-uuid/hash", "hello")
+	client.receive(fmt.Sprintf("ubirch/%s/hash", uid), "hello")
+	waitFor(t, func() bool { _, _, published, _ := client.state(); return len(published) == 1 })
+
+	_, _, replies, _ := client.state()
+	expected := published{topic: fmt.Sprintf("ubirch/%s/response", uid), payload: fmt.Sprintf("%s:hello", uid)}
+	if replies[0] != expected {
+		t.Errorf("unexpected reply: %v", replies[0])
+	}
+
+	// the bridge reconnects after the connection was lost
+	client.lose(fmt.Errorf("connection reset"))
+	waitFor(t, func() bool { connects, _, _, _ := client.state(); return connects == 2 })
+	waitFor(t, func() bool {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		return client.handler != nil
+	})
+
+	// on shutdown, the replies to the messages in progress are published before it disconnects
+	client.receive(fmt.Sprintf("ubirch/%s/hash", uid), "in progress")
+	cancel()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("MQTT bridge did not shut down after context was cancelled")
+	}
+
+	_, _, replies, disconnects := client.state()
+	if len(replies) != 2 || replies[1].payload != fmt.Sprintf("%s:in progress", uid) {
+		t.Errorf("reply to message in progress was not published: %v", replies)
+	}
+	if disconnects != 1 {
+		t.Errorf("unexpected number of disconnects: %d", disconnects)
+	}
+}
+
+// blockingHandler replies with the payload once it is released
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b blockingHandler) HandleMessage(uid uuid.UUID, payload []byte) ([]byte, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return payload, nil
+}
+
+func TestBridge_QueueFull(t *testing.T) {
+	client := &mockClient{}
+	handler := blockingHandler{started: make(chan struct{}, 10), release: make(chan struct{})}
+	bridge, err := NewBridge(client, "ubirch/<uuid>/hash", "ubirch/<uuid>/response", handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge.Workers = 1
+	bridge.QueueSize = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- bridge.Serve(ctx)
+	}()
+	waitFor(t, func() bool { _, filter, _, _ := client.state(); return filter != "" })
+
+	// the worker is busy with the first message, the second message waits in the queue,
+	// further messages are dropped
+	topic := fmt.Sprintf("ubirch/%s/hash", uuid.New())
+	client.receive(topic, "1")
+	<-handler.started
+	for _, payload := range []string{"2", "3", "4"} {
+		client.receive(topic, payload)
+	}
+
+	close(handler.release)
+	waitFor(t, func() bool { _, _, published, _ := client.state(); return len(published) == 2 })
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Error(err)
+	}
+
+	_, _, replies, _ := client.state()
+	if len(replies) != 2 || replies[0].payload != "1" || replies[1].payload != "2" {
+		t.Errorf("unexpected replies: %v", replies)
+	}
+}
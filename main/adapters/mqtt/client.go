@@ -0,0 +1,228 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	subscribeQoS    = 1 // the maximum QoS of the messages which the broker sends to the client
+	publishQoS      = 0
+	subscribeFailed = 0x80 // return code of the broker for a rejected subscription
+
+	keepAlive       = 30 * time.Second
+	connectTimeout  = 10 * time.Second
+	writeTimeout    = 10 * time.Second
+	disconnectQuiet = 250 // milliseconds to complete the work in progress before disconnecting
+	clientIDPrefix  = "ubirch-client-"
+)
+
+// BrokerClient connects to the MQTT broker with the Eclipse Paho MQTT client via TCP or TLS with a clean
+// session and a random client ID. Messages are received with QoS 1 and acknowledged after they were passed
+// to the handler. Messages are published with QoS 0.
+//
+// The Paho client does not reconnect by itself, reconnects are done by the Bridge, which subscribes again
+// after each reconnect.
+type BrokerClient struct {
+	addr string
+	opts *paho.ClientOptions
+
+	client    paho.Client   // nil if not connected
+	done      chan struct{} // closed when the connection is lost or disconnected
+	closeDone func()        // closes done once
+	err       error
+	mutex     *sync.Mutex // guards the connection state above
+}
+
+var _ Client = (*BrokerClient)(nil)
+
+// NewBrokerClient returns a client for the broker with the given URL, in the form
+// "tcp://[user:password@]host[:port]" or "tls://[user:password@]host[:port]".
+// The default port is 1883 for TCP and 8883 for TLS.
+func NewBrokerClient(brokerURL string) (*BrokerClient, error) {
+	u, err := url.Parse(brokerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := paho.NewClientOptions()
+
+	port := u.Port()
+	scheme := "tcp"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		if port == "" {
+			port = "1883"
+		}
+	case "tls", "ssl", "mqtts":
+		if port == "" {
+			port = "8883"
+		}
+		scheme = "ssl"
+		opts.SetTLSConfig(&tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12})
+	default:
+		return nil, fmt.Errorf("unsupported scheme of MQTT broker URL: \"%s\", expected (\"tcp\" | \"tls\")", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("MQTT broker URL has no host")
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	opts.AddBroker(scheme + "://" + addr)
+
+	if u.User != nil {
+		opts.SetUsername(u.User.Username())
+		if password, ok := u.User.Password(); ok {
+			opts.SetPassword(password)
+		}
+	}
+
+	random := make([]byte, 8)
+	if _, err = rand.Read(random); err != nil {
+		return nil, err
+	}
+	opts.SetClientID(clientIDPrefix + hex.EncodeToString(random))
+
+	opts.SetCleanSession(true)
+	opts.SetKeepAlive(keepAlive)
+	opts.SetConnectTimeout(connectTimeout)
+	opts.SetWriteTimeout(writeTimeout)
+	opts.SetAutoReconnect(false)
+	opts.SetConnectRetry(false)
+	opts.SetOrderMatters(false) // the handler is called in its own go routine
+
+	return &BrokerClient{
+		addr:      addr,
+		opts:      opts,
+		done:      make(chan struct{}),
+		closeDone: func() {},
+		mutex:     &sync.Mutex{},
+	}, nil
+}
+
+// Connect connects to the broker. If the client was connected before, the subscriptions are discarded.
+func (c *BrokerClient) Connect(ctx context.Context) error {
+	done := make(chan struct{})
+	once := &sync.Once{}
+	closeDone := func() { once.Do(func() { close(done) }) }
+
+	lost := func(client paho.Client, err error) {
+		c.mutex.Lock()
+		if c.client == client { // the connection was not closed by Disconnect
+			c.client = nil
+			c.err = err
+		}
+		c.mutex.Unlock()
+		closeDone()
+	}
+
+	// the options are copied by the Paho client
+	c.opts.SetConnectionLostHandler(lost)
+	client := paho.NewClient(c.opts)
+
+	token := client.Connect()
+	if err := wait(ctx, token); err != nil {
+		// the connection may still be established after the context was cancelled
+		go func() {
+			<-token.Done()
+			client.Disconnect(0)
+		}()
+		return fmt.Errorf("could not connect to MQTT broker %s: %v", c.addr, err)
+	}
+
+	c.mutex.Lock()
+	c.client = client
+	c.done = done
+	c.closeDone = closeDone
+	c.err = nil
+	c.mutex.Unlock()
+
+	// the connection may have been lost before the client was set
+	if !client.IsConnectionOpen() {
+		lost(client, fmt.Errorf("connection to MQTT broker was closed"))
+	}
+	return nil
+}
+
+// Subscribe subscribes to the topic filter and waits for the acknowledgement of the broker.
+// The handler is called for each received message, it must not block.
+func (c *BrokerClient) Subscribe(ctx context.Context, filter string, handler func(topic string, payload []byte)) error {
+	c.mutex.Lock()
+	client := c.client
+	c.mutex.Unlock()
+	if client == nil {
+		return fmt.Errorf("not connected to MQTT broker")
+	}
+
+	token := client.Subscribe(filter, subscribeQoS, func(_ paho.Client, msg paho.Message) {
+		handler(msg.Topic(), msg.Payload())
+	})
+	if err := wait(ctx, token); err != nil {
+		return err
+	}
+
+	if subToken, ok := token.(*paho.SubscribeToken); ok {
+		if qos, found := subToken.Result()[filter]; !found || qos == subscribeFailed || qos > subscribeQoS {
+			return fmt.Errorf("subscription to topic %s was rejected by MQTT broker", filter)
+		}
+	}
+	return nil
+}
+
+// Publish publishes the payload to the topic with QoS 0
+func (c *BrokerClient) Publish(ctx context.Context, topic string, payload []byte) error {
+	c.mutex.Lock()
+	client := c.client
+	c.mutex.Unlock()
+	if client == nil {
+		return fmt.Errorf("not connected to MQTT broker")
+	}
+
+	return wait(ctx, client.Publish(topic, publishQoS, false, payload))
+}
+
+func (c *BrokerClient) Done() <-chan struct{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.done
+}
+
+func (c *BrokerClient) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+// Disconnect completes the work in progress and disconnects from the broker
+func (c *BrokerClient) Disconnect() error {
+	c.mutex.Lock()
+	client, closeDone := c.client, c.closeDone
+	c.client = nil
+	c.mutex.Unlock()
+	if client == nil {
+		return nil
+	}
+
+	// the connection lost handler is not called on a graceful disconnect
+	client.Disconnect(disconnectQuiet)
+	closeDone()
+	return nil
+}
+
+// wait waits until the token completes or the context is cancelled
+func wait(ctx context.Context, token paho.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
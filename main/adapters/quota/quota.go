@@ -0,0 +1,268 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	filePerm  = 0644
+	dayLayout = "2006-01-02"
+
+	DefaultPersistInterval = time.Second
+)
+
+// Usage is the number of signing requests of an identity on the current day (UTC)
+type Usage struct {
+	UID      uuid.UUID `json:"uuid"`
+	Day      string    `json:"day"`
+	Count    int       `json:"count"`
+	Quota    int       `json:"quota"` // 0 if the identity has no quota
+	ResetsAt time.Time `json:"resetsAt"`
+}
+
+// Counter counts the signing requests of each identity per day and rejects requests beyond the daily quota
+// of the identity. The counters are reset at midnight UTC. They are persisted to a file, so that a restart
+// does not reset the quota. The counts are persisted in the background at the persist interval instead of
+// with each request, so on a crash at most the requests of one persist interval are not counted.
+type Counter struct {
+	file            string
+	defaultQuota    int
+	quotas          map[uuid.UUID]int // quotas which override the default quota
+	counts          map[uuid.UUID]int // counts of the current day
+	day             string
+	dirty           bool // the counts changed since they were persisted
+	mutex           *sync.Mutex
+	now             func() time.Time
+	persistInterval time.Duration
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+type persistedCounts struct {
+	Day    string            `json:"day"`
+	Counts map[uuid.UUID]int `json:"counts"`
+}
+
+// NewCounter returns a counter with a default daily quota for all UUIDs and optional quotas for specific UUIDs,
+// and loads the counts of the current day from the file. UUIDs without a quota are not limited, if the default
+// quota is 0. The counter must be closed, so that the counts are persisted on shutdown.
+func NewCounter(file string, defaultQuota int, quotas map[uuid.UUID]int, persistInterval time.Duration) (*Counter, error) {
+	if persistInterval <= 0 {
+		persistInterval = DefaultPersistInterval
+	}
+
+	c := &Counter{
+		file:            file,
+		defaultQuota:    defaultQuota,
+		quotas:          quotas,
+		counts:          map[uuid.UUID]int{},
+		mutex:           &sync.Mutex{},
+		now:             time.Now,
+		persistInterval: persistInterval,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	c.day = c.today()
+
+	err := c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	go c.persistPeriodically()
+	return c, nil
+}
+
+// Take counts n signing requests of the identity. If the requests would exceed the daily quota of the identity,
+// they are not counted and Take returns false and the time until the quota is reset.
+func (c *Counter) Take(uid uuid.UUID, n int) (ok bool, resetsIn time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reset()
+
+	quota := c.quota(uid)
+	if quota <= 0 {
+		return true, 0 // no quota
+	}
+	if c.counts[uid]+n > quota {
+		return false, c.resetsAt().Sub(c.now())
+	}
+
+	c.counts[uid] += n
+	c.dirty = true
+	return true, 0
+}
+
+// Usage returns the usage of the identity on the current day
+func (c *Counter) Usage(uid uuid.UUID) Usage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reset()
+	return c.usage(uid)
+}
+
+// List returns the usage of all identities which have sent signing requests on the current day
+func (c *Counter) List() []Usage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reset()
+
+	usage := make([]Usage, 0, len(c.counts))
+	for uid := range c.counts {
+		usage = append(usage, c.usage(uid))
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].UID.String() < usage[j].UID.String()
+	})
+	return usage
+}
+
+func (c *Counter) usage(uid uuid.UUID) Usage {
+	return Usage{
+		UID:      uid,
+		Day:      c.day,
+		Count:    c.counts[uid],
+		Quota:    c.quota(uid),
+		ResetsAt: c.resetsAt(),
+	}
+}
+
+func (c *Counter) quota(uid uuid.UUID) int {
+	if quota, found := c.quotas[uid]; found {
+		return quota
+	}
+	return c.defaultQuota
+}
+
+func (c *Counter) today() string {
+	return c.now().UTC().Format(dayLayout)
+}
+
+// reset clears the counts of the previous day
+func (c *Counter) reset() {
+	if today := c.today(); today != c.day {
+		c.day = today
+		c.counts = map[uuid.UUID]int{}
+		c.dirty = true
+	}
+}
+
+// resetsAt returns the next midnight UTC
+func (c *Counter) resetsAt() time.Time {
+	day, _ := time.Parse(dayLayout, c.day)
+	return day.AddDate(0, 0, 1)
+}
+
+func (c *Counter) load() error {
+	data, err := ioutil.ReadFile(c.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read quota file: %v", err)
+	}
+
+	var p persistedCounts
+	err = json.Unmarshal(data, &p)
+	if err != nil {
+		return fmt.Errorf("unable to parse quota file %s: %v", c.file, err)
+	}
+
+	// counts of a previous day are discarded
+	if p.Day == c.day && p.Counts != nil {
+		c.counts = p.Counts
+	}
+	return nil
+}
+
+// Close stops the background persistence and persists the counts
+func (c *Counter) Close() error {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	<-c.done
+
+	return c.persist()
+}
+
+// persistPeriodically persists the counts at the persist interval until the counter is closed
+func (c *Counter) persistPeriodically() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.persistInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.persist(); err != nil {
+				log.Errorf("persisting daily quota usage failed: %v", err)
+			}
+		}
+	}
+}
+
+// persist writes the counts of the current day to the file, if they changed since they were persisted.
+// The counts are written and synced to a temporary file, which then replaces the file, so that a crash
+// can not leave a partially written file behind. The counts are not persisted concurrently, since only
+// the background persistence and Close, after it was stopped, call persist.
+func (c *Counter) persist() error {
+	c.mutex.Lock()
+	if !c.dirty {
+		c.mutex.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(persistedCounts{Day: c.day, Counts: c.counts})
+	c.dirty = false
+	c.mutex.Unlock()
+
+	if err == nil {
+		err = writeFileSync(c.file, data)
+	}
+	if err != nil {
+		// the counts are persisted with the next attempt
+		c.mutex.Lock()
+		c.dirty = true
+		c.mutex.Unlock()
+	}
+	return err
+}
+
+func writeFileSync(file string, data []byte) error {
+	tmpFile := file + ".tmp"
+
+	f, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerm)
+	if err != nil {
+		return fmt.Errorf("unable to write quota file: %v", err)
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write quota file: %v", err)
+	}
+
+	return os.Rename(tmpFile, file)
+}
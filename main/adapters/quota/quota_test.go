@@ -0,0 +1,143 @@
+package quota
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCounter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quota_usage.json")
+	uid, limitedUID, unlimitedUID := uuid.New(), uuid.New(), uuid.New()
+	quotas := map[uuid.UUID]int{limitedUID: 1, unlimitedUID: 0}
+
+	c, err := NewCounter(file, 3, quotas, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 6, 1, 23, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	c.day = c.today()
+
+	for i := 1; i <= 4; i++ {
+		ok, resetsIn := c.Take(uid, 1)
+		if ok != (i <= 3) {
+			t.Errorf("request %d: unexpected result: %t", i, ok)
+		}
+		if !ok && resetsIn != time.Hour {
+			t.Errorf("unexpected time until reset: %s", resetsIn)
+		}
+	}
+
+	if ok, _ := c.Take(limitedUID, 2); ok {
+		t.Error("requests beyond the quota of the UUID were accepted")
+	}
+	if ok, _ := c.Take(limitedUID, 1); !ok {
+		t.Error("request within the quota of the UUID was rejected")
+	}
+	for i := 0; i < 10; i++ {
+		if ok, _ := c.Take(unlimitedUID, 1); !ok {
+			t.Fatal("request of UUID without quota was rejected")
+		}
+	}
+
+	usage := c.Usage(uid)
+	if usage.Count != 3 || usage.Quota != 3 || usage.Day != "2021-06-01" ||
+		!usage.ResetsAt.Equal(time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+	if list := c.List(); len(list) != 2 {
+		t.Errorf("unexpected usage list: %+v", list)
+	}
+
+	// simulate a restart: the counts of the day must survive, since they are persisted on close
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c, err = NewCounter(file, 3, quotas, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return now }
+	c.day = c.today()
+	err = c.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := c.Take(uid, 1); ok {
+		t.Error("quota was reset by the restart")
+	}
+
+	// the quota is reset at midnight UTC
+	now = now.Add(time.Hour)
+	if ok, _ := c.Take(uid, 1); !ok {
+		t.Error("quota was not reset at midnight")
+	}
+	if usage = c.Usage(uid); usage.Count != 1 || usage.Day != "2021-06-02" {
+		t.Errorf("unexpected usage after reset: %+v", usage)
+	}
+	if usage = c.Usage(limitedUID); usage.Count != 0 {
+		t.Errorf("unexpected usage after reset: %+v", usage)
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCounter_DiscardPreviousDay(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quota_usage.json")
+	uid := uuid.New()
+
+	c, err := NewCounter(file, 1, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return time.Now().AddDate(0, 0, -1) }
+	c.day = c.today()
+
+	if ok, _ := c.Take(uid, 1); !ok {
+		t.Fatal("request was rejected")
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewCounter(file, 1, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if ok, _ := c.Take(uid, 1); !ok {
+		t.Error("counts of the previous day were loaded")
+	}
+}
+
+func TestCounter_PersistPeriodically(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quota_usage.json")
+	uid := uuid.New()
+
+	c, err := NewCounter(file, 2, nil, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Take(uid, 1); !ok {
+		t.Fatal("request was rejected")
+	}
+
+	// the counts are persisted in the background, without closing the counter
+	time.Sleep(100 * time.Millisecond)
+
+	restarted, err := NewCounter(file, 2, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	if usage := restarted.Usage(uid); usage.Count != 1 {
+		t.Errorf("counts were not persisted: %+v", usage)
+	}
+}
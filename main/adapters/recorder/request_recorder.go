@@ -0,0 +1,133 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	filePerm = 0644
+
+	DefaultMaxSize = 10 * 1024 * 1024 // 10 MB
+)
+
+// Record contains the minimal inputs of a signing request, which are needed to replay it.
+// It must never contain secrets like auth tokens.
+type Record struct {
+	Timestamp time.Time `json:"timestamp"`
+	UUID      uuid.UUID `json:"uuid"`
+	Operation string    `json:"operation"`
+	Hash      []byte    `json:"hash"`
+}
+
+// RequestRecorder writes one JSON encoded Record per line to a file. If the file would
+// exceed the maximum size, it is moved to "<file>.1" and a new file is started, so at most
+// two files of the maximum size exist at any time.
+type RequestRecorder struct {
+	file    string
+	maxSize int64
+	size    int64
+	f       *os.File
+	mutex   *sync.Mutex
+}
+
+func NewRequestRecorder(file string, maxSize int64) (*RequestRecorder, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	r := &RequestRecorder{
+		file:    file,
+		maxSize: maxSize,
+		mutex:   &sync.Mutex{},
+	}
+
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Record appends a record of a signing request to the request log
+func (r *RequestRecorder) Record(uid uuid.UUID, operation string, hash []byte) error {
+	line, err := json.Marshal(Record{
+		Timestamp: time.Now().UTC(),
+		UUID:      uid,
+		Operation: operation,
+		Hash:      hash,
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.size+int64(len(line)) > r.maxSize {
+		err = r.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := r.f.Write(line)
+	r.size += int64(n)
+	return err
+}
+
+func (r *RequestRecorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.f.Close()
+}
+
+// ParseRecord parses a line of the request log back into the request parameters
+func ParseRecord(line []byte) (Record, error) {
+	var record Record
+	err := json.Unmarshal(line, &record)
+	if err != nil {
+		return Record{}, fmt.Errorf("unable to parse request record: %v", err)
+	}
+	return record, nil
+}
+
+func (r *RequestRecorder) open() error {
+	f, err := os.OpenFile(r.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, filePerm)
+	if err != nil {
+		return fmt.Errorf("unable to open request log file: %v", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+// rotate moves the file to "<file>.1" and starts a new file. The file is renamed before it is closed,
+// so that the recorder can keep writing to the current file if the rotation fails.
+func (r *RequestRecorder) rotate() error {
+	err := os.Rename(r.file, r.file+".1")
+	if err != nil {
+		return fmt.Errorf("unable to rotate request log file: %v", err)
+	}
+
+	prev := r.f
+	err = r.open()
+	if err != nil {
+		return err
+	}
+	return prev.Close()
+}
@@ -0,0 +1,129 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRequestRecorder(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "requests.log")
+
+	r, err := NewRequestRecorder(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uid := uuid.New()
+	hashes := [][32]byte{sha256.Sum256([]byte("1")), sha256.Sum256([]byte("2"))}
+
+	for _, hash := range hashes {
+		err = r.Record(uid, "anchor", hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var i int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		record, err := ParseRecord(scanner.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.UUID != uid {
+			t.Errorf("unexpected UUID: expected %s, got %s", uid, record.UUID)
+		}
+		if record.Operation != "anchor" {
+			t.Errorf("unexpected operation: %s", record.Operation)
+		}
+		if !bytes.Equal(record.Hash, hashes[i][:]) {
+			t.Errorf("unexpected hash: %x", record.Hash)
+		}
+		if record.Timestamp.IsZero() {
+			t.Error("record has no timestamp")
+		}
+		i++
+	}
+
+	if i != len(hashes) {
+		t.Errorf("unexpected number of records: expected %d, got %d", len(hashes), i)
+	}
+}
+
+func TestRequestRecorder_MaxSize(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "requests.log")
+	const maxSize = 512
+
+	r, err := NewRequestRecorder(file, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for i := 0; i < 100; i++ {
+		err = r.Record(uuid.New(), "chain", make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, f := range []string{file, file + ".1"} {
+		info, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > maxSize {
+			t.Errorf("%s exceeds maximum size: %d bytes", f, info.Size())
+		}
+	}
+}
+
+func TestRequestRecorder_RotateFailed(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "requests.log")
+	const maxSize = 512
+
+	r, err := NewRequestRecorder(file, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// a non-empty directory at the rotation target lets the rotation fail
+	blocker := filepath.Join(file+".1", "blocker")
+	if err = os.MkdirAll(blocker, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var failed bool
+	for i := 0; i < 100 && !failed; i++ {
+		failed = r.Record(uuid.New(), "chain", make([]byte, 32)) != nil
+	}
+	if !failed {
+		t.Fatal("rotation did not fail")
+	}
+
+	// the recorder recovers once the rotation succeeds
+	if err = os.RemoveAll(file + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Record(uuid.New(), "chain", make([]byte, 32)); err != nil {
+		t.Fatalf("recording after failed rotation failed: %v", err)
+	}
+}
@@ -15,7 +15,8 @@ const (
 )
 
 var (
-	ErrExists = errors.New("entry already exists")
+	ErrExists   = errors.New("entry already exists")
+	ErrNotExist = errors.New("entry does not exist")
 )
 
 type ContextManager interface {
@@ -24,20 +25,44 @@ type ContextManager interface {
 	CloseTransaction(transactionCtx interface{}, commit bool) error
 
 	Exists(uid uuid.UUID) (bool, error)
+	GetUIDs() ([]uuid.UUID, error)
+	GetIdentityInfos(limit, offset int) ([]ent.IdentityInfo, error)
 
 	StoreNewIdentity(transactionCtx interface{}, identity *ent.Identity) error
 	FetchIdentity(transactionCtx interface{}, uid uuid.UUID) (*ent.Identity, error)
 
 	SetSignature(transactionCtx interface{}, uid uuid.UUID, signature []byte) error
+	SetAuthToken(transactionCtx interface{}, uid uuid.UUID, authToken string) error
+
+	DeleteIdentity(transactionCtx interface{}, uid uuid.UUID) error
 
 	GetPrivateKey(uid uuid.UUID) ([]byte, error)
 	GetPublicKey(uid uuid.UUID) ([]byte, error)
 	GetAuthToken(uid uuid.UUID) (string, error)
 }
 
+// SignatureUpdater is implemented by context managers which can persist the signature of a chained UPP
+// in a new transaction, if the transaction of the UPP was aborted because of a conflict with a
+// concurrent transaction
+type SignatureUpdater interface {
+	UpdateSignature(ctx context.Context, uid uuid.UUID, prevSignature, signature []byte) error
+}
+
+// DatabaseParamsFromConfig returns the connection pool settings of the postgres database from the configuration
+func DatabaseParamsFromConfig(c config.Config) DatabaseParams {
+	return DatabaseParams{
+		MaxOpenConns:            c.DBMaxOpenConns,
+		MaxIdleConns:            c.DBMaxIdleConns,
+		ConnMaxLifetime:         c.DBConnMaxLifetimeDuration,
+		MaxSerializationRetries: c.DBMaxSerializationRetries,
+	}
+}
+
 func GetCtxManager(c config.Config) (ContextManager, error) {
-	if c.PostgresDSN != "" {
-		return NewSqlDatabaseInfo(c.PostgresDSN, PostgreSqlIdentityTableName)
+	if IsSQLiteDSN(c.PostgresDSN) {
+		return NewSQLiteManager(c.PostgresDSN, PostgreSqlIdentityTableName)
+	} else if c.PostgresDSN != "" {
+		return NewSqlDatabaseInfo(c.PostgresDSN, PostgreSqlIdentityTableName, DatabaseParamsFromConfig(c))
 	} else {
 		return nil, fmt.Errorf("file-based context management is not supported in the current version. " +
 			"Please set a postgres DSN in the configuration and conntect to a database or downgrade to a version < 2.0.0")
@@ -15,9 +15,15 @@
 package repository
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -29,49 +35,111 @@ import (
 
 const (
 	PostgreSql string = "postgres"
+
+	DefaultMaxOpenConns    = 100
+	DefaultMaxIdleConns    = 70
+	DefaultConnMaxLifetime = 10 * time.Minute
+
+	DefaultMaxSerializationRetries = 5
+
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 100 * time.Millisecond
 )
 
+// ErrChainAdvanced is returned by UpdateSignature, if the chain of the identity was advanced by another UPP
+var ErrChainAdvanced = errors.New("stored signature is not the previous signature of the UPP")
+
+// DatabaseParams are the settings of the connection pool, the default is used for each setting which is not set
+type DatabaseParams struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// MaxSerializationRetries is the number of retries of a transaction which was aborted
+	// because of a serialization failure or deadlock with a concurrent transaction
+	MaxSerializationRetries int
+}
+
 // DatabaseManager contains the postgres database connection, and offers methods
 // for interacting with the database.
 type DatabaseManager struct {
 	options   *sql.TxOptions
 	db        *sql.DB
 	tableName string
+
+	maxRetries              int           // number of retries of an operation which failed with a transient error
+	maxSerializationRetries int           // number of retries of a transaction which failed with a serialization failure
+	retryBackoff            time.Duration // wait time before the first retry, doubled with each further retry
 }
 
+// Ensure Database implements the SignatureUpdater interface
+var _ SignatureUpdater = (*DatabaseManager)(nil)
+
 // Ensure Database implements the ContextManager interface
 var _ ContextManager = (*DatabaseManager)(nil)
 
 // NewSqlDatabaseInfo takes a database connection string, returns a new initialized
 // database.
-func NewSqlDatabaseInfo(dataSourceName, tableName string) (*DatabaseManager, error) {
+func NewSqlDatabaseInfo(dataSourceName, tableName string, params DatabaseParams) (*DatabaseManager, error) {
 	pg, err := sql.Open(PostgreSql, dataSourceName)
 	if err != nil {
 		return nil, err
 	}
-	pg.SetMaxOpenConns(100)
-	pg.SetMaxIdleConns(70)
-	pg.SetConnMaxLifetime(10 * time.Minute)
+	params.setDefaults()
+	pg.SetMaxOpenConns(params.MaxOpenConns)
+	pg.SetMaxIdleConns(params.MaxIdleConns)
+	pg.SetConnMaxLifetime(params.ConnMaxLifetime)
 	if err = pg.Ping(); err != nil {
 		return nil, err
 	}
 
 	log.Print("preparing postgres usage")
 
-	dbManager := &DatabaseManager{
+	dbManager := newDatabaseManager(pg, tableName)
+	dbManager.maxSerializationRetries = params.MaxSerializationRetries
+
+	if _, err = dbManager.db.Exec(CreateTable(PostgresIdentity, tableName)); err != nil {
+		return nil, err
+	}
+
+	return dbManager, nil
+}
+
+func newDatabaseManager(db *sql.DB, tableName string) *DatabaseManager {
+	return &DatabaseManager{
 		options: &sql.TxOptions{
 			Isolation: sql.LevelReadCommitted,
 			ReadOnly:  false,
 		},
-		db:        pg,
-		tableName: tableName,
+		db:                      db,
+		tableName:               tableName,
+		maxRetries:              defaultMaxRetries,
+		maxSerializationRetries: DefaultMaxSerializationRetries,
+		retryBackoff:            defaultRetryBackoff,
 	}
+}
 
-	if _, err = dbManager.db.Exec(CreateTable(PostgresIdentity, tableName)); err != nil {
-		return nil, err
+func (p *DatabaseParams) setDefaults() {
+	if p.MaxOpenConns <= 0 {
+		p.MaxOpenConns = DefaultMaxOpenConns
+	}
+	if p.MaxIdleConns <= 0 {
+		p.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if p.MaxIdleConns > p.MaxOpenConns {
+		p.MaxIdleConns = p.MaxOpenConns
+	}
+	if p.ConnMaxLifetime <= 0 {
+		p.ConnMaxLifetime = DefaultConnMaxLifetime
 	}
+	if p.MaxSerializationRetries <= 0 {
+		p.MaxSerializationRetries = DefaultMaxSerializationRetries
+	}
+}
 
-	return dbManager, nil
+// Ping checks that the database is reachable
+func (dm *DatabaseManager) Ping(ctx context.Context) error {
+	return dm.db.PingContext(ctx)
 }
 
 func (dm *DatabaseManager) Exists(uid uuid.UUID) (bool, error) {
@@ -79,19 +147,91 @@ func (dm *DatabaseManager) Exists(uid uuid.UUID) (bool, error) {
 
 	query := fmt.Sprintf("SELECT uid FROM %s WHERE uid = $1", dm.tableName)
 
-	err := dm.db.QueryRow(query, uid.String()).Scan(&id)
+	err := dm.retry(func() error {
+		return dm.db.QueryRow(query, uid.String()).Scan(&id)
+	})
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
 	if err != nil {
-		if dm.isConnectionAvailable(err) {
-			return dm.Exists(uid)
+		return false, err
+	}
+	return true, nil
+}
+
+// GetUIDs returns the UUIDs of all stored identities
+func (dm *DatabaseManager) GetUIDs() ([]uuid.UUID, error) {
+	query := fmt.Sprintf("SELECT uid FROM %s", dm.tableName)
+
+	var uids []uuid.UUID
+
+	err := dm.retry(func() error {
+		uids = nil
+
+		rows, err := dm.db.Query(query)
+		if err != nil {
+			return err
 		}
-		if err == sql.ErrNoRows {
-			return false, nil
-		} else {
-			return false, err
+		//noinspection GoUnhandledErrorResult
+		defer rows.Close()
+
+		for rows.Next() {
+			var id string
+			if err = rows.Scan(&id); err != nil {
+				return err
+			}
+
+			uid, err := uuid.Parse(id)
+			if err != nil {
+				return fmt.Errorf("invalid stored uid \"%s\": %v", id, err)
+			}
+			uids = append(uids, uid)
 		}
-	} else {
-		return true, nil
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return uids, nil
+}
+
+// GetIdentityInfos returns the metadata of at most limit stored identities ordered by their UUID,
+// starting at the given offset. A signature consisting of zeros only is the initial value, i.e.
+// no UPP was chained with the identity yet.
+func (dm *DatabaseManager) GetIdentityInfos(limit, offset int) ([]ent.IdentityInfo, error) {
+	query := fmt.Sprintf("SELECT uid, octet_length(public_key) > 0, "+
+		"signature <> decode(repeat('00', octet_length(signature)), 'hex') "+
+		"FROM %s ORDER BY uid LIMIT $1 OFFSET $2", dm.tableName)
+
+	var infos []ent.IdentityInfo
+
+	err := dm.retry(func() error {
+		infos = []ent.IdentityInfo{}
+
+		rows, err := dm.db.Query(query, limit, offset)
+		if err != nil {
+			return err
+		}
+		//noinspection GoUnhandledErrorResult
+		defer rows.Close()
+
+		for rows.Next() {
+			var info ent.IdentityInfo
+			if err = rows.Scan(&info.Uid, &info.HasPublicKey, &info.HasSignature); err != nil {
+				return err
+			}
+			infos = append(infos, info)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
 	}
+
+	return infos, nil
 }
 
 func (dm *DatabaseManager) GetPrivateKey(uid uuid.UUID) ([]byte, error) {
@@ -99,11 +239,10 @@ func (dm *DatabaseManager) GetPrivateKey(uid uuid.UUID) ([]byte, error) {
 
 	query := fmt.Sprintf("SELECT private_key FROM %s WHERE uid = $1", dm.tableName)
 
-	err := dm.db.QueryRow(query, uid.String()).Scan(&privateKey)
+	err := dm.retry(func() error {
+		return dm.db.QueryRow(query, uid.String()).Scan(&privateKey)
+	})
 	if err != nil {
-		if dm.isConnectionAvailable(err) {
-			return dm.GetPrivateKey(uid)
-		}
 		return nil, err
 	}
 
@@ -115,11 +254,10 @@ func (dm *DatabaseManager) GetPublicKey(uid uuid.UUID) ([]byte, error) {
 
 	query := fmt.Sprintf("SELECT public_key FROM %s WHERE uid = $1", dm.tableName)
 
-	err := dm.db.QueryRow(query, uid.String()).Scan(&publicKey)
+	err := dm.retry(func() error {
+		return dm.db.QueryRow(query, uid.String()).Scan(&publicKey)
+	})
 	if err != nil {
-		if dm.isConnectionAvailable(err) {
-			return dm.GetPublicKey(uid)
-		}
 		return nil, err
 	}
 
@@ -131,11 +269,10 @@ func (dm *DatabaseManager) GetAuthToken(uid uuid.UUID) (string, error) {
 
 	query := fmt.Sprintf("SELECT auth_token FROM %s WHERE uid = $1", dm.tableName)
 
-	err := dm.db.QueryRow(query, uid.String()).Scan(&authToken)
+	err := dm.retry(func() error {
+		return dm.db.QueryRow(query, uid.String()).Scan(&authToken)
+	})
 	if err != nil {
-		if dm.isConnectionAvailable(err) {
-			return dm.GetAuthToken(uid)
-		}
 		return "", err
 	}
 
@@ -143,27 +280,56 @@ func (dm *DatabaseManager) GetAuthToken(uid uuid.UUID) (string, error) {
 }
 
 func (dm *DatabaseManager) StartTransaction(ctx context.Context) (transactionCtx interface{}, err error) {
-	return dm.db.BeginTx(ctx, dm.options)
+	var tx *sql.Tx
+
+	err = dm.retry(func() error {
+		tx, err = dm.db.BeginTx(ctx, dm.options)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
 }
 
 // StartTransactionWithLock starts a transaction and acquires a lock on the row with the specified uuid as key.
 // Returns error if row does not exist.
 func (dm *DatabaseManager) StartTransactionWithLock(ctx context.Context, uid uuid.UUID) (transactionCtx interface{}, err error) {
-	tx, err := dm.db.BeginTx(ctx, dm.options)
+	var tx *sql.Tx
+
+	// acquiring the lock may fail with a deadlock of concurrent transactions
+	err = dm.retryOn(isSerializationFailure, dm.maxSerializationRetries, func() (err error) {
+		tx, err = dm.startTransactionWithLock(ctx, uid)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var id string
+	return tx, nil
+}
 
+func (dm *DatabaseManager) startTransactionWithLock(ctx context.Context, uid uuid.UUID) (tx *sql.Tx, err error) {
 	query := fmt.Sprintf("SELECT uid FROM %s WHERE uid = $1 FOR UPDATE", dm.tableName)
 
-	// lock row FOR UPDATE
-	err = tx.QueryRow(query, uid).Scan(&id)
-	if err != nil {
-		if dm.isConnectionAvailable(err) {
-			return dm.StartTransactionWithLock(ctx, uid)
+	err = dm.retry(func() error {
+		tx, err = dm.db.BeginTx(ctx, dm.options)
+		if err != nil {
+			return err
 		}
+
+		var id string
+
+		// lock row FOR UPDATE
+		err = tx.QueryRow(query, uid).Scan(&id)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -195,9 +361,6 @@ func (dm *DatabaseManager) FetchIdentity(transactionCtx interface{}, uid uuid.UU
 
 	err := tx.QueryRow(query, uid.String()).Scan(&id.Uid, &id.PrivateKey, &id.PublicKey, &id.Signature, &id.AuthToken)
 	if err != nil {
-		if dm.isConnectionAvailable(err) {
-			return dm.FetchIdentity(tx, uid)
-		}
 		return nil, err
 	}
 
@@ -213,12 +376,88 @@ func (dm *DatabaseManager) SetSignature(transactionCtx interface{}, uid uuid.UUI
 	query := fmt.Sprintf("UPDATE %s SET signature = $1 WHERE uid = $2;", dm.tableName)
 
 	_, err := tx.Exec(query, &signature, uid.String())
+	return err
+}
+
+// UpdateSignature replaces the previous signature of the identity by the signature of a chained UPP in a new
+// transaction. It is used to persist the signature, if the transaction of the UPP was aborted because of a
+// conflict with a concurrent transaction, and is retried until it succeeds or the maximum number of retries
+// is reached. To make sure the chain is not advanced twice, the signature is only stored as long as the stored
+// signature is the previous signature of the UPP, otherwise ErrChainAdvanced is returned.
+func (dm *DatabaseManager) UpdateSignature(ctx context.Context, uid uuid.UUID, prevSignature, signature []byte) error {
+	return dm.retryOn(isSerializationFailure, dm.maxSerializationRetries, func() error {
+		return dm.updateSignature(ctx, uid, prevSignature, signature)
+	})
+}
+
+func (dm *DatabaseManager) updateSignature(ctx context.Context, uid uuid.UUID, prevSignature, signature []byte) error {
+	tx, err := dm.startTransactionWithLock(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	var storedSignature []byte
+
+	query := fmt.Sprintf("SELECT signature FROM %s WHERE uid = $1", dm.tableName)
+
+	err = tx.QueryRow(query, uid.String()).Scan(&storedSignature)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if bytes.Equal(storedSignature, signature) {
+		// the signature was already stored
+		return tx.Rollback()
+	}
+	if !bytes.Equal(storedSignature, prevSignature) {
+		_ = tx.Rollback()
+		return ErrChainAdvanced
+	}
+
+	err = dm.SetSignature(tx, uid, signature)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (dm *DatabaseManager) SetAuthToken(transactionCtx interface{}, uid uuid.UUID, authToken string) error {
+	tx, ok := transactionCtx.(*sql.Tx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for database manager is not of expected type *sql.Tx")
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET auth_token = $1 WHERE uid = $2;", dm.tableName)
+
+	_, err := tx.Exec(query, &authToken, uid.String())
+	return err
+}
+
+// DeleteIdentity removes the identity with its keys, signature and auth token.
+// Returns ErrNotExist if there is no identity with the specified uuid.
+func (dm *DatabaseManager) DeleteIdentity(transactionCtx interface{}, uid uuid.UUID) error {
+	tx, ok := transactionCtx.(*sql.Tx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for database manager is not of expected type *sql.Tx")
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE uid = $1;", dm.tableName)
+
+	result, err := tx.Exec(query, uid.String())
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
 	if err != nil {
-		if dm.isConnectionAvailable(err) {
-			return dm.SetSignature(tx, uid, signature)
-		}
 		return err
 	}
+	if deleted == 0 {
+		return ErrNotExist
+	}
 
 	return nil
 }
@@ -235,19 +474,14 @@ func (dm *DatabaseManager) StoreNewIdentity(transactionCtx interface{}, identity
 	query := fmt.Sprintf("SELECT uid FROM %s WHERE uid = $1 FOR UPDATE;", dm.tableName)
 
 	err := tx.QueryRow(query, identity.Uid).Scan(&id)
+	if err == sql.ErrNoRows {
+		// there were no rows, but otherwise no error occurred
+		return dm.storeIdentity(tx, identity)
+	}
 	if err != nil {
-		if dm.isConnectionAvailable(err) {
-			return dm.StoreNewIdentity(tx, identity)
-		}
-		if err == sql.ErrNoRows {
-			// there were no rows, but otherwise no error occurred
-			return dm.storeIdentity(tx, identity)
-		} else {
-			return err
-		}
-	} else {
-		return ErrExists
+		return err
 	}
+	return ErrExists
 }
 
 func (dm *DatabaseManager) storeIdentity(tx *sql.Tx, identity *ent.Identity) error {
@@ -256,21 +490,67 @@ func (dm *DatabaseManager) storeIdentity(tx *sql.Tx, identity *ent.Identity) err
 		dm.tableName)
 
 	_, err := tx.Exec(query, &identity.Uid, &identity.PrivateKey, &identity.PublicKey, &identity.Signature, &identity.AuthToken)
-	if err != nil {
-		if dm.isConnectionAvailable(err) {
-			return dm.storeIdentity(tx, identity)
+	return err
+}
+
+// retry runs the database operation and repeats it with exponential backoff, if it failed with a transient
+// error, e.g. because the connection to the database was lost or the database has too many connections,
+// until the maximum number of retries is reached. Only operations which are not part of a transaction, or
+// which start a transaction, may be retried: a statement of a transaction can not be repeated on its own,
+// since the transaction is aborted by the failure.
+func (dm *DatabaseManager) retry(op func() error) error {
+	return dm.retryOn(isTransientDatabaseError, dm.maxRetries, op)
+}
+
+// retryOn runs the database operation and repeats it with exponential backoff, as long as it fails with
+// an error for which retryable returns true, until the maximum number of retries is reached
+func (dm *DatabaseManager) retryOn(retryable func(error) bool, maxRetries int, op func() error) error {
+	backoff := dm.retryBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt > maxRetries || !retryable(err) {
+			return err
 		}
-		return err
+
+		log.Debugf("database operation attempt %d failed: %v, retrying in %s", attempt, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
 	}
+}
 
-	return nil
+// isTransientDatabaseError returns true if the error indicates that the database operation may succeed if
+// it is repeated, i.e. if the connection failed or the database is temporarily not accepting connections
+func isTransientDatabaseError(err error) bool {
+	// the deadline of the operation has passed, so repeating it would fail again
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "53300", // too_many_connections
+			"53400", // configuration_limit_exceeded
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now
+			return true
+		}
+		return strings.HasPrefix(string(pqErr.Code), "08") // connection_exception
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
 }
 
-func (dm *DatabaseManager) isConnectionAvailable(err error) bool {
-	if err.Error() == pq.ErrorCode("53300").Name() || // "53300": "too_many_connections",
-		err.Error() == pq.ErrorCode("53400").Name() { // "53400": "configuration_limit_exceeded",
-		time.Sleep(100 * time.Millisecond)
-		return true
+// isSerializationFailure returns true if the transaction was aborted because of a conflict
+// with a concurrent transaction and may succeed if it is repeated
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || // serialization_failure
+			pqErr.Code == "40P01" // deadlock_detected
 	}
 	return false
 }
@@ -3,11 +3,19 @@ package repository
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/base64"
 	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/ubirch/ubirch-client-go/main/config"
 	"github.com/ubirch/ubirch-client-go/main/ent"
 )
@@ -153,6 +161,232 @@ func TestDatabaseManager(t *testing.T) {
 	}
 }
 
+func TestDatabaseManager_Retry(t *testing.T) {
+	var tests = []struct {
+		name             string
+		failures         int32
+		err              error
+		expectedAttempts int32
+		expectedErr      bool
+	}{
+		{name: "no failure", expectedAttempts: 1},
+		{name: "recovers", failures: 2, err: &pq.Error{Code: "57P03"}, expectedAttempts: 3},
+		{name: "too many connections", failures: 1, err: &pq.Error{Code: "53300"}, expectedAttempts: 2},
+		{name: "connection lost", failures: 1, err: io.ErrUnexpectedEOF, expectedAttempts: 2},
+		{name: "does not recover", failures: 10, err: &pq.Error{Code: "08006"}, expectedAttempts: defaultMaxRetries + 1, expectedErr: true},
+		{name: "not transient", failures: 10, err: &pq.Error{Code: "42601"}, expectedAttempts: 1, expectedErr: true},
+		{name: "deadline exceeded", failures: 10, err: context.DeadlineExceeded, expectedAttempts: 1, expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &stubDB{failures: test.failures, err: test.err}
+			dm := newDatabaseManager(sql.OpenDB(stub), TestTableName)
+			dm.retryBackoff = time.Millisecond
+
+			exists, err := dm.Exists(uuid.MustParse(TestUUID))
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.expectedErr && !exists {
+				t.Error("dm.Exists returned FALSE")
+			}
+			if attempts := atomic.LoadInt32(&stub.attempts); attempts != test.expectedAttempts {
+				t.Errorf("unexpected number of attempts: expected %d, got %d", test.expectedAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestDatabaseManager_NoRetryInTransaction(t *testing.T) {
+	stub := &stubDB{failures: 1, err: io.ErrUnexpectedEOF}
+	dm := newDatabaseManager(sql.OpenDB(stub), TestTableName)
+	dm.retryBackoff = time.Millisecond
+
+	tx, err := dm.StartTransaction(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the transaction is aborted by the failure, so the statement must not be repeated within it
+	err = dm.SetSignature(tx, uuid.MustParse(TestUUID), bytes.Repeat([]byte{1}, 64))
+	if err == nil {
+		t.Error("failed statement within transaction did not return error")
+	}
+	if attempts := atomic.LoadInt32(&stub.attempts); attempts != 1 {
+		t.Errorf("statement within transaction was retried: %d attempts", attempts)
+	}
+	_ = dm.CloseTransaction(tx, Rollback)
+}
+
+func TestDatabaseManager_UpdateSignature(t *testing.T) {
+	prevSignature := bytes.Repeat([]byte{1}, 64)
+	signature := bytes.Repeat([]byte{2}, 64)
+	otherSignature := bytes.Repeat([]byte{3}, 64)
+
+	var tests = []struct {
+		name              string
+		storedSignature   []byte
+		commitFailures    int
+		commitErr         error
+		expectedErr       bool
+		expectedSignature []byte
+	}{
+		{name: "serialization failure", storedSignature: prevSignature, commitFailures: 2,
+			commitErr: &pq.Error{Code: "40001"}, expectedSignature: signature},
+		{name: "deadlock", storedSignature: prevSignature, commitFailures: 1,
+			commitErr: &pq.Error{Code: "40P01"}, expectedSignature: signature},
+		{name: "too many conflicts", storedSignature: prevSignature, commitFailures: DefaultMaxSerializationRetries + 1,
+			commitErr: &pq.Error{Code: "40001"}, expectedErr: true, expectedSignature: prevSignature},
+		{name: "other error", storedSignature: prevSignature, commitFailures: 1,
+			commitErr: &pq.Error{Code: "23505"}, expectedErr: true, expectedSignature: prevSignature},
+		{name: "already stored", storedSignature: signature, expectedSignature: signature},
+		{name: "chain advanced", storedSignature: otherSignature, expectedErr: true, expectedSignature: otherSignature},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &stubDB{signature: test.storedSignature, commitFailures: test.commitFailures, commitErr: test.commitErr}
+			dm := newDatabaseManager(sql.OpenDB(stub), TestTableName)
+			dm.retryBackoff = time.Millisecond
+
+			err := dm.UpdateSignature(context.Background(), uuid.MustParse(TestUUID), prevSignature, signature)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(stub.storedSignature(), test.expectedSignature) {
+				t.Errorf("unexpected stored signature: expected %x, got %x", test.expectedSignature, stub.storedSignature())
+			}
+		})
+	}
+}
+
+func TestDatabaseParams(t *testing.T) {
+	params := DatabaseParams{MaxOpenConns: 10}
+	params.setDefaults()
+
+	if params.MaxOpenConns != 10 {
+		t.Errorf("unexpected max open connections: %d", params.MaxOpenConns)
+	}
+	if params.MaxIdleConns != 10 {
+		t.Errorf("max idle connections not limited to max open connections: %d", params.MaxIdleConns)
+	}
+	if params.ConnMaxLifetime != DefaultConnMaxLifetime {
+		t.Errorf("unexpected connection lifetime: %s", params.ConnMaxLifetime)
+	}
+	if params.MaxSerializationRetries != DefaultMaxSerializationRetries {
+		t.Errorf("unexpected number of serialization retries: %d", params.MaxSerializationRetries)
+	}
+}
+
+// stubDB is a database connector whose operations fail with the error until the number of failures
+// is reached, e.g. while the database is restarted. Queries return the test UUID, or the stored signature.
+// Commits fail with the commit error until the number of commit failures is reached.
+type stubDB struct {
+	failures int32
+	err      error
+	attempts int32
+
+	mutex          sync.Mutex
+	signature      []byte
+	commitFailures int
+	commitErr      error
+}
+
+type stubConn struct {
+	db        *stubDB
+	signature []byte // signature which is stored when the transaction is committed
+}
+
+type stubTx struct {
+	conn *stubConn
+}
+
+type stubRows struct {
+	values []driver.Value
+}
+
+func (s *stubDB) Connect(context.Context) (driver.Conn, error) { return &stubConn{db: s}, nil }
+func (s *stubDB) Driver() driver.Driver                        { return nil }
+
+func (s *stubDB) fail() error {
+	atomic.AddInt32(&s.attempts, 1)
+	if atomic.AddInt32(&s.failures, -1) >= 0 {
+		return s.err
+	}
+	return nil
+}
+
+func (s *stubDB) storedSignature() []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.signature
+}
+
+func (c *stubConn) Prepare(string) (driver.Stmt, error) { return nil, fmt.Errorf("not supported") }
+func (c *stubConn) Close() error                        { return nil }
+func (c *stubConn) Begin() (driver.Tx, error)           { return &stubTx{conn: c}, nil }
+func (c *stubConn) Ping(context.Context) error          { return c.db.fail() }
+
+func (c *stubConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return &stubTx{conn: c}, nil
+}
+
+func (c *stubConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if err := c.db.fail(); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(query, "SELECT signature") {
+		return &stubRows{values: []driver.Value{c.db.storedSignature()}}, nil
+	}
+	return &stubRows{values: []driver.Value{TestUUID}}, nil
+}
+
+func (c *stubConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.db.fail(); err != nil {
+		return nil, err
+	}
+	if strings.Contains(query, "SET signature") {
+		c.signature = args[0].Value.([]byte)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (tx *stubTx) Commit() error {
+	db := tx.conn.db
+	signature := tx.conn.signature
+	tx.conn.signature = nil
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.commitFailures > 0 {
+		db.commitFailures--
+		return db.commitErr
+	}
+	if signature != nil {
+		db.signature = signature
+	}
+	return nil
+}
+
+func (tx *stubTx) Rollback() error {
+	tx.conn.signature = nil
+	return nil
+}
+
+func (r *stubRows) Columns() []string { return []string{"value"} }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.values[0]
+	r.values = r.values[1:]
+	return nil
+}
+
 func initDB() (*DatabaseManager, error) {
 	conf := &config.Config{}
 	err := conf.Load("../../", "config.json")
@@ -160,7 +394,7 @@ func initDB() (*DatabaseManager, error) {
 		return nil, fmt.Errorf("ERROR: unable to load configuration: %s", err)
 	}
 
-	return NewSqlDatabaseInfo(conf.PostgresDSN, TestTableName)
+	return NewSqlDatabaseInfo(conf.PostgresDSN, TestTableName, DatabaseParamsFromConfig(*conf))
 }
 
 func initTestIdentity() *ent.Identity {
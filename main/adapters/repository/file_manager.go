@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -17,42 +18,65 @@ import (
 )
 
 const (
-	keyFileName      = "keys.json"
+	keyFileName      = "keys.json" // monolithic keystore file, split into files per identity on load
+	keyDirName       = "keys"
 	signatureDirName = "signatures"
 	authTokenDirName = "tokens"
+	lockFileName     = ".lock"
 	filePerm         = 0644
 	dirPerm          = 0755
 
 	contextFileName_Legacy = "protocol.json" // TODO: DEPRECATED
 )
 
+// FileManager stores the keys, the last signature and the auth token of each identity in separate files,
+// so an identity can be updated without rewriting the context of all other identities.
 type FileManager struct {
 	keyFile           string
+	keyDir            string
 	signatureDir      string
 	authTokenDir      string
 	identities        []ent.Identity
 	EncryptedKeystore *ubirch.EncryptedKeystore
 	keystoreMutex     *sync.RWMutex
+	lockFile          *os.File
+	identityLocks     map[uuid.UUID]chan struct{}
+	identityLocksMtx  *sync.Mutex
 }
 
-// TODO // Ensure FileManager implements the ContextManager interface
-//var _ ContextManager = (*FileManager)(nil)
+// Ensure FileManager implements the ContextManager interface
+var _ ContextManager = (*FileManager)(nil)
 
-func NewFileManager(configDir string, secret []byte) (*FileManager, error) {
+// NewFileManager loads the protocol context from the config directory. The config directory is locked
+// until the file manager is closed, so that no other process can modify the protocol context concurrently.
+func NewFileManager(configDir string, secret []byte) (_ *FileManager, err error) {
 	f := &FileManager{
 		keyFile:           filepath.Join(configDir, keyFileName),
+		keyDir:            filepath.Join(configDir, keyDirName),
 		signatureDir:      filepath.Join(configDir, signatureDirName),
 		authTokenDir:      filepath.Join(configDir, authTokenDirName),
 		EncryptedKeystore: ubirch.NewEncryptedKeystore(secret),
 		keystoreMutex:     &sync.RWMutex{},
+		identityLocks:     map[uuid.UUID]chan struct{}{},
+		identityLocksMtx:  &sync.Mutex{},
 	}
 
-	err := initDirectories([]string{f.signatureDir, f.authTokenDir})
+	f.lockFile, err = lockDirectory(configDir)
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+		}
+	}()
 
-	log.Debugf(" - keystore file: %s", f.keyFile)
+	err = initDirectories([]string{f.keyDir, f.signatureDir, f.authTokenDir})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf(" - key dir: %s", f.keyDir)
 	log.Debugf(" - signature dir: %s", f.signatureDir)
 	log.Debugf(" - token dir: %s", f.authTokenDir)
 
@@ -66,6 +90,11 @@ func NewFileManager(configDir string, secret []byte) (*FileManager, error) {
 		return nil, err
 	}
 
+	err = f.splitKeystoreFile()
+	if err != nil {
+		return nil, err
+	}
+
 	err = f.loadKeys()
 	if err != nil {
 		return nil, err
@@ -99,10 +128,17 @@ func (f *FileManager) GetPrivateKey(uid uuid.UUID) ([]byte, error) {
 }
 
 func (f *FileManager) SetPrivateKey(uid uuid.UUID, key []byte) error {
+	unlock, _ := f.lockIdentity(context.Background(), uid)
+	defer unlock()
+
 	f.keystoreMutex.Lock()
-	defer f.keystoreMutex.Unlock()
+	err := f.EncryptedKeystore.SetPrivateKey(uid, key)
+	f.keystoreMutex.Unlock()
+	if err != nil {
+		return err
+	}
 
-	return f.EncryptedKeystore.SetPrivateKey(uid, key)
+	return f.persistKeys(uid)
 }
 
 func (f *FileManager) GetPublicKey(uid uuid.UUID) ([]byte, error) {
@@ -113,20 +149,23 @@ func (f *FileManager) GetPublicKey(uid uuid.UUID) ([]byte, error) {
 }
 
 func (f *FileManager) SetPublicKey(uid uuid.UUID, key []byte) error {
+	unlock, _ := f.lockIdentity(context.Background(), uid)
+	defer unlock()
+
 	f.keystoreMutex.Lock()
-	defer f.keystoreMutex.Unlock()
+	err := f.EncryptedKeystore.SetPublicKey(uid, key)
+	f.keystoreMutex.Unlock()
+	if err != nil {
+		return err
+	}
 
-	return f.EncryptedKeystore.SetPublicKey(uid, key)
+	return f.persistKeys(uid)
 }
 
 func (f *FileManager) GetSignature(uid uuid.UUID) ([]byte, error) {
 	return ioutil.ReadFile(f.signatureFile(uid))
 }
 
-func (f *FileManager) SetSignature(uid uuid.UUID, signature []byte) error {
-	return ioutil.WriteFile(f.signatureFile(uid), signature, filePerm)
-}
-
 func (f *FileManager) GetAuthToken(uid uuid.UUID) (string, error) {
 	tokenBytes, err := ioutil.ReadFile(f.authTokenFile(uid))
 	if err != nil {
@@ -136,12 +175,19 @@ func (f *FileManager) GetAuthToken(uid uuid.UUID) (string, error) {
 	return string(tokenBytes), nil
 }
 
-func (f *FileManager) SetAuthToken(uid uuid.UUID, authToken string) error {
-	return ioutil.WriteFile(f.authTokenFile(uid), []byte(authToken), filePerm)
-}
-
+// Close releases the lock of the config directory
 func (f *FileManager) Close() error {
-	return nil
+	if f.lockFile == nil {
+		return nil
+	}
+
+	err := unlockFile(f.lockFile)
+	if err != nil {
+		return err
+	}
+	err = f.lockFile.Close()
+	f.lockFile = nil
+	return err
 }
 
 func (f *FileManager) signatureFile(uid uuid.UUID) string {
@@ -154,12 +200,100 @@ func (f *FileManager) authTokenFile(uid uuid.UUID) string {
 	return filepath.Join(f.authTokenDir, authTokenFileName)
 }
 
+func (f *FileManager) keyFileOf(uid uuid.UUID) string {
+	keyFileName := uid.String() + ".json"
+	return filepath.Join(f.keyDir, keyFileName)
+}
+
+// lockIdentity acquires the lock of the identity and returns the function to release it.
+// Waits until the lock is released by concurrent writers or the context is done.
+func (f *FileManager) lockIdentity(ctx context.Context, uid uuid.UUID) (unlock func(), err error) {
+	f.identityLocksMtx.Lock()
+	lock, found := f.identityLocks[uid]
+	if !found {
+		lock = make(chan struct{}, 1)
+		f.identityLocks[uid] = lock
+	}
+	f.identityLocksMtx.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return func() {}, ctx.Err()
+	}
+}
+
+// loadKeys loads the key files of all identities into the keystore
 func (f *FileManager) loadKeys() error {
-	return loadFile(f.keyFile, f.EncryptedKeystore.Keystore)
+	files, err := ioutil.ReadDir(f.keyDir)
+	if err != nil {
+		return err
+	}
+
+	exists := make(map[string]bool, len(files))
+	for _, file := range files {
+		exists[file.Name()] = true
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		// a backup without key file is left if the process crashed while the key file was replaced
+		if filepath.Ext(name) == ".bck" && !exists[strings.TrimSuffix(name, ".bck")] {
+			name = strings.TrimSuffix(name, ".bck")
+		}
+		if file.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		keys := map[string]string{}
+		err = loadFile(filepath.Join(f.keyDir, name), &keys)
+		if err != nil {
+			return fmt.Errorf("unable to load key file %s: %v", name, err)
+		}
+
+		for name, key := range keys {
+			(*f.EncryptedKeystore.Keystore)[name] = key
+		}
+	}
+	return nil
 }
 
-func (f *FileManager) persistKeys() error {
-	return persistFile(f.keyFile, f.EncryptedKeystore.Keystore)
+// persistKeys persists the encrypted keys of the identity to the key file of the identity
+func (f *FileManager) persistKeys(uid uuid.UUID) error {
+	f.keystoreMutex.RLock()
+	keys := identityKeys(*f.EncryptedKeystore.Keystore, uid)
+	f.keystoreMutex.RUnlock()
+
+	return persistFile(f.keyFileOf(uid), keys)
+}
+
+// identityKeys returns the entries of the identity's private and public key from the keystore
+func identityKeys(keystore map[string]string, uid uuid.UUID) map[string]string {
+	keys := map[string]string{}
+	for _, name := range []string{"_" + uid.String(), uid.String()} {
+		if key, found := keystore[name]; found {
+			keys[name] = key
+		}
+	}
+	return keys
+}
+
+// lockDirectory acquires an exclusive lock on the lock file in the directory.
+// Returns an error if the lock is held by another process.
+func lockDirectory(dir string) (*os.File, error) {
+	lockFile, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_CREATE|os.O_RDWR, filePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tryLockFile(lockFile)
+	if err != nil {
+		_ = lockFile.Close()
+		return nil, fmt.Errorf("unable to lock directory %s, it is in use by another instance of the client: %v", dir, err)
+	}
+
+	return lockFile, nil
 }
 
 func initDirectories(directories []string) error {
@@ -174,38 +308,117 @@ func initDirectories(directories []string) error {
 	return nil
 }
 
+// loadFile decodes the JSON file into dest. If the file is missing or corrupt, its backup is loaded instead,
+// so that a crash while the file was replaced never loses its content. It is no error if neither the file
+// nor a backup exists yet.
 func loadFile(file string, dest interface{}) error {
-	if _, err := os.Stat(file); os.IsNotExist(err) { // if file does not exist yet, return right away
+	err := loadJSON(file, dest)
+	if err == nil {
 		return nil
 	}
+
+	backupErr := loadJSON(file+".bck", dest)
+	if backupErr == nil {
+		log.Warnf("unable to load %s: %v, loaded backup file instead", file, err)
+		return nil
+	}
+	if os.IsNotExist(err) && os.IsNotExist(backupErr) {
+		return nil
+	}
+	return err
+}
+
+func loadJSON(file string, dest interface{}) error {
 	contextBytes, err := ioutil.ReadFile(file)
 	if err != nil {
-		file = file + ".bck"
-		contextBytes, err = ioutil.ReadFile(file)
+		return err
+	}
+	return json.Unmarshal(contextBytes, dest)
+}
+
+// persistFile writes the source as JSON to the file. The previous content of the file is kept as backup,
+// which is copied rather than moved, so that there is always a complete file to load.
+func persistFile(file string, source interface{}) error {
+	if previous, err := ioutil.ReadFile(file); err == nil {
+		err = writeFileAtomic(file+".bck", previous, filePerm)
 		if err != nil {
-			return err
+			log.Warnf("unable to create backup file for %s: %v", file, err)
 		}
 	}
-	err = json.Unmarshal(contextBytes, dest)
+	contextBytes, _ := json.MarshalIndent(source, "", "  ")
+	return writeFileAtomic(file, contextBytes, filePerm)
+}
+
+// writeFileAtomic writes the data to a temporary file which then replaces the file,
+// so the file is never left truncated if the process crashes during the write
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
 	if err != nil {
-		if strings.HasSuffix(file, ".bck") {
-			return err
-		} else {
-			return loadFile(file+".bck", dest)
-		}
+		return err
 	}
-	return nil
+	//noinspection GoUnhandledErrorResult
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
 }
 
-func persistFile(file string, source interface{}) error {
-	if _, err := os.Stat(file); !os.IsNotExist(err) { // if file already exists, create a backup
-		err = os.Rename(file, file+".bck")
+// splitKeystoreFile moves the keys from the monolithic keystore file into
+// separate key files per identity and removes the monolithic keystore file
+func (f *FileManager) splitKeystoreFile() error {
+	if _, err := os.Stat(f.keyFile); os.IsNotExist(err) { // if file does not exist, return right away
+		return nil
+	}
+
+	keystore := map[string]string{}
+	err := loadFile(f.keyFile, &keystore)
+	if err != nil {
+		return fmt.Errorf("unable to load keystore file: %v", err)
+	}
+
+	var count int
+	for name := range keystore {
+		if !strings.HasPrefix(name, "_") {
+			continue
+		}
+		uid, err := uuid.Parse(strings.TrimPrefix(name, "_"))
 		if err != nil {
-			log.Warnf("unable to create backup file for %s: %v", file, err)
+			return fmt.Errorf("invalid key entry in keystore file: %s", name)
 		}
+
+		err = persistFile(f.keyFileOf(uid), identityKeys(keystore, uid))
+		if err != nil {
+			return fmt.Errorf("unable to persist keys of identity %s: %v", uid, err)
+		}
+		count++
 	}
-	contextBytes, _ := json.MarshalIndent(source, "", "  ")
-	return ioutil.WriteFile(file, contextBytes, filePerm)
+	log.Infof("moved keys of %d identities from %s to %s", count, f.keyFile, f.keyDir)
+
+	err = os.Remove(f.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to delete keystore file: %v", err)
+	}
+	err = os.Remove(f.keyFile + ".bck")
+	if err != nil && !os.IsNotExist(err) {
+		log.Warnf("unable to delete keystore backup file: %v", err)
+	}
+
+	return nil
 }
 
 // this is here only for the purpose of backwards compatibility TODO: DEPRECATE
@@ -268,7 +481,7 @@ func (f *FileManager) persistSignatures(signatures map[uuid.UUID][]byte) error {
 			return fmt.Errorf("invalid signature length: expected 64, got %d", len(signature))
 		}
 
-		err := f.SetSignature(uid, signature)
+		err := writeFileAtomic(f.signatureFile(uid), signature, filePerm)
 		if err != nil {
 			return err
 		}
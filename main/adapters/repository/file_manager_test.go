@@ -0,0 +1,319 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+)
+
+var testSecret16 = []byte("0123456789abcdef")
+
+func TestFileManager_Lock(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := NewFileManager(dir, testSecret16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewFileManager(dir, testSecret16)
+	if err == nil {
+		t.Fatal("second file manager acquired lock of config directory")
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock is released when the first file manager is closed
+	f, err = NewFileManager(dir, testSecret16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileManager_SetSignature(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := NewFileManager(dir, testSecret16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	uid := uuid.MustParse(testIdentity.Uid)
+	ctx := context.Background()
+
+	tx, err := f.StartTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.StoreNewIdentity(tx, testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.CloseTransaction(tx, Commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig2, _ := base64.StdEncoding.DecodeString(TestSignature2)
+
+	var tests = []struct {
+		name              string
+		commit            bool
+		expectedSignature []byte
+	}{
+		{
+			name:              "rollback",
+			commit:            Rollback,
+			expectedSignature: testIdentity.Signature,
+		},
+		{
+			name:              "commit",
+			commit:            Commit,
+			expectedSignature: sig2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, err := f.StartTransactionWithLock(ctx, uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = f.SetSignature(tx, uid, sig2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the signature is staged until the transaction is committed
+			stored, err := f.GetSignature(uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(stored, testIdentity.Signature) {
+				t.Errorf("signature was persisted before commit: %x", stored)
+			}
+
+			err = f.CloseTransaction(tx, test.commit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tx, err = f.StartTransactionWithLock(ctx, uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.CloseTransaction(tx, Rollback)
+
+			id, err := f.FetchIdentity(tx, uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(id.Signature, test.expectedSignature) {
+				t.Errorf("unexpected signature: %x", id.Signature)
+			}
+		})
+	}
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(filepath.Join(dir, signatureDirName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("unexpected number of files in signature directory: %d", len(files))
+	}
+}
+
+func TestFileManager_SplitKeystoreFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// create monolithic keystore file
+	keystore := ubirch.NewEncryptedKeystore(testSecret16)
+	uids := []uuid.UUID{uuid.New(), uuid.New()}
+	for _, uid := range uids {
+		err := keystore.SetPrivateKey(uid, []byte("private key "+uid.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = keystore.SetPublicKey(uid, []byte("public key "+uid.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	keystoreBytes, err := json.Marshal(keystore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, keyFileName), keystoreBytes, filePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewFileManager(dir, testSecret16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, keyFileName)); !os.IsNotExist(err) {
+		t.Errorf("monolithic keystore file was not removed: %v", err)
+	}
+
+	newUID := uuid.New()
+	err = f.SetPrivateKey(newUID, []byte("private key "+newUID.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.SetPublicKey(newUID, []byte("public key "+newUID.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids = append(uids, newUID)
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// load keys from the key files per identity
+	f, err = NewFileManager(dir, testSecret16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, uid := range uids {
+		if _, err := os.Stat(f.keyFileOf(uid)); err != nil {
+			t.Errorf("%s: missing key file: %v", uid, err)
+		}
+
+		priv, err := f.GetPrivateKey(uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(priv) != "private key "+uid.String() {
+			t.Errorf("%s: unexpected private key: %s", uid, priv)
+		}
+
+		pub, err := f.GetPublicKey(uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(pub) != "public key "+uid.String() {
+			t.Errorf("%s: unexpected public key: %s", uid, pub)
+		}
+	}
+}
+
+func TestFileManager_KeyFileCrash(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := NewFileManager(dir, testSecret16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uidMissing, uidCorrupt := uuid.New(), uuid.New()
+	for _, uid := range []uuid.UUID{uidMissing, uidCorrupt} {
+		err = f.SetPrivateKey(uid, []byte("private key "+uid.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the key file is replaced, so that a backup of the key file exists
+		err = f.SetPublicKey(uid, []byte("public key "+uid.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(f.keyFileOf(uid) + ".bck"); err != nil {
+			t.Fatalf("backup of key file was not created: %v", err)
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the process crashed while the key files were replaced
+	err = os.Remove(f.keyFileOf(uidMissing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(f.keyFileOf(uidCorrupt), []byte(`{"_ab`), filePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = NewFileManager(dir, testSecret16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, uid := range []uuid.UUID{uidMissing, uidCorrupt} {
+		priv, err := f.GetPrivateKey(uid)
+		if err != nil {
+			t.Fatalf("%s: key was lost: %v", uid, err)
+		}
+		if string(priv) != "private key "+uid.String() {
+			t.Errorf("%s: unexpected private key: %s", uid, priv)
+		}
+	}
+}
+
+func TestFileManager_SignatureAbsent(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := NewFileManager(dir, testSecret16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p, err := NewExtendedProtocol(f, testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uid := uuid.New()
+	storeTestIdentity(t, p, uid)
+
+	// a key without a signature, e.g. from a context where the identity was never used for chaining
+	err = os.Remove(f.signatureFile(uid))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chained, err := p.CheckChainState(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chained {
+		t.Error("identity without signature was reported as chained")
+	}
+
+	tx, i, err := p.FetchIdentityWithLock(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.CloseTransaction(tx, Rollback)
+
+	if !bytes.Equal(i.Signature, make([]byte, p.SignatureLength())) {
+		t.Errorf("unexpected initial signature: %x", i.Signature)
+	}
+}
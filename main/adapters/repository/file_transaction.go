@@ -0,0 +1,283 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/ent"
+)
+
+// fileTx is the transaction context of the file manager. Changes within a transaction
+// are staged and only written to the files when the transaction is committed, so e.g.
+// the signature of a chained UPP is not persisted if the backend did not accept the UPP.
+type fileTx struct {
+	lockedUID uuid.UUID
+	unlock    func()
+	changes   []fileChange
+	done      bool
+}
+
+type fileChange struct {
+	uid   uuid.UUID
+	write func() error
+}
+
+func (f *FileManager) StartTransaction(context.Context) (transactionCtx interface{}, err error) {
+	return &fileTx{unlock: func() {}}, nil
+}
+
+// StartTransactionWithLock starts a transaction and acquires a lock on the identity with the specified uuid.
+// Returns error if the identity does not exist.
+func (f *FileManager) StartTransactionWithLock(ctx context.Context, uid uuid.UUID) (transactionCtx interface{}, err error) {
+	unlock, err := f.lockIdentity(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := f.Exists(uid)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	if !exists {
+		unlock()
+		return nil, fmt.Errorf("%s: %v", uid, ErrNotExist)
+	}
+
+	return &fileTx{lockedUID: uid, unlock: unlock}, nil
+}
+
+// CloseTransaction writes the staged changes of a committed transaction to the files
+// and releases the lock of the transaction
+func (f *FileManager) CloseTransaction(transactionCtx interface{}, commit bool) error {
+	tx, ok := transactionCtx.(*fileTx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for file manager is not of expected type *fileTx")
+	}
+	if tx.done {
+		return fmt.Errorf("transaction has already been committed or rolled back")
+	}
+	tx.done = true
+	defer tx.unlock()
+
+	if !commit {
+		return nil
+	}
+
+	for _, c := range tx.changes {
+		err := f.writeChange(tx, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeChange writes the change under the lock of the identity, unless the transaction already holds it
+func (f *FileManager) writeChange(tx *fileTx, c fileChange) error {
+	if c.uid != tx.lockedUID {
+		unlock, err := f.lockIdentity(context.Background(), c.uid)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	return c.write()
+}
+
+// GetUIDs returns the UUIDs of all stored identities
+func (f *FileManager) GetUIDs() ([]uuid.UUID, error) {
+	f.keystoreMutex.RLock()
+	defer f.keystoreMutex.RUnlock()
+
+	return f.EncryptedKeystore.GetIDs()
+}
+
+// GetIdentityInfos returns the metadata of at most limit stored identities ordered by their UUID,
+// starting at the given offset
+func (f *FileManager) GetIdentityInfos(limit, offset int) ([]ent.IdentityInfo, error) {
+	uids, err := f.GetUIDs()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(uids, func(a, b int) bool { return uids[a].String() < uids[b].String() })
+
+	if offset > len(uids) {
+		offset = len(uids)
+	}
+	uids = uids[offset:]
+	if limit < len(uids) {
+		uids = uids[:limit]
+	}
+
+	infos := []ent.IdentityInfo{}
+	for _, uid := range uids {
+		_, pubKeyErr := f.GetPublicKey(uid)
+
+		signature, err := f.GetSignature(uid)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		infos = append(infos, ent.IdentityInfo{
+			Uid:          uid.String(),
+			HasPublicKey: pubKeyErr == nil,
+			HasSignature: !isZero(signature),
+		})
+	}
+	return infos, nil
+}
+
+func (f *FileManager) StoreNewIdentity(transactionCtx interface{}, identity *ent.Identity) error {
+	tx, ok := transactionCtx.(*fileTx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for file manager is not of expected type *fileTx")
+	}
+
+	uid, err := uuid.Parse(identity.Uid)
+	if err != nil {
+		return err
+	}
+
+	// make sure identity does not exist yet
+	exists, err := f.Exists(uid)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrExists
+	}
+
+	i := *identity
+	tx.changes = append(tx.changes, fileChange{uid: uid, write: func() error {
+		return f.storeIdentity(uid, &i)
+	}})
+	return nil
+}
+
+func (f *FileManager) FetchIdentity(transactionCtx interface{}, uid uuid.UUID) (*ent.Identity, error) {
+	if _, ok := transactionCtx.(*fileTx); !ok {
+		return nil, fmt.Errorf("transactionCtx for file manager is not of expected type *fileTx")
+	}
+
+	var err error
+	id := ent.Identity{Uid: uid.String()}
+
+	id.PrivateKey, err = f.GetPrivateKey(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	id.PublicKey, err = f.GetPublicKey(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	id.Signature, err = f.GetSignature(uid)
+	if err != nil && !os.IsNotExist(err) { // the signature does not exist, if no UPP was chained with the identity yet
+		return nil, err
+	}
+
+	id.AuthToken, err = f.GetAuthToken(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &id, nil
+}
+
+// SetSignature stages the signature. The signature is persisted when the transaction is committed.
+func (f *FileManager) SetSignature(transactionCtx interface{}, uid uuid.UUID, signature []byte) error {
+	tx, ok := transactionCtx.(*fileTx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for file manager is not of expected type *fileTx")
+	}
+
+	tx.changes = append(tx.changes, fileChange{uid: uid, write: func() error {
+		return writeFileAtomic(f.signatureFile(uid), signature, filePerm)
+	}})
+	return nil
+}
+
+// SetAuthToken stages the auth token. The auth token is persisted when the transaction is committed.
+func (f *FileManager) SetAuthToken(transactionCtx interface{}, uid uuid.UUID, authToken string) error {
+	tx, ok := transactionCtx.(*fileTx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for file manager is not of expected type *fileTx")
+	}
+
+	tx.changes = append(tx.changes, fileChange{uid: uid, write: func() error {
+		return writeFileAtomic(f.authTokenFile(uid), []byte(authToken), filePerm)
+	}})
+	return nil
+}
+
+// DeleteIdentity removes the identity with its keys, signature and auth token.
+// Returns ErrNotExist if there is no identity with the specified uuid.
+func (f *FileManager) DeleteIdentity(transactionCtx interface{}, uid uuid.UUID) error {
+	tx, ok := transactionCtx.(*fileTx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for file manager is not of expected type *fileTx")
+	}
+
+	exists, err := f.Exists(uid)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotExist
+	}
+
+	tx.changes = append(tx.changes, fileChange{uid: uid, write: func() error {
+		return f.removeIdentity(uid)
+	}})
+	return nil
+}
+
+func (f *FileManager) storeIdentity(uid uuid.UUID, i *ent.Identity) error {
+	f.keystoreMutex.Lock()
+	err := f.EncryptedKeystore.SetPrivateKey(uid, i.PrivateKey)
+	if err == nil {
+		err = f.EncryptedKeystore.SetPublicKey(uid, i.PublicKey)
+	}
+	f.keystoreMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = f.persistKeys(uid)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(f.signatureFile(uid), i.Signature, filePerm)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(f.authTokenFile(uid), []byte(i.AuthToken), filePerm)
+}
+
+func (f *FileManager) removeIdentity(uid uuid.UUID) error {
+	f.keystoreMutex.Lock()
+	for name := range identityKeys(*f.EncryptedKeystore.Keystore, uid) {
+		delete(*f.EncryptedKeystore.Keystore, name)
+	}
+	f.keystoreMutex.Unlock()
+
+	for _, file := range []string{
+		f.keyFileOf(uid) + ".bck", // the backup first, so that it is never loaded in place of a deleted key file
+		f.keyFileOf(uid),
+		f.signatureFile(uid),
+		f.authTokenFile(uid),
+	} {
+		err := os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
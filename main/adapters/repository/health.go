@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Pinger is implemented by context managers which depend on a database connection
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// MonitorHealth pings the database at the interval until the context is done. The result of the first
+// check and each change of the health of the database is passed to the report function, with a nil
+// error if the database is reachable.
+func MonitorHealth(ctx context.Context, db Pinger, interval time.Duration, report func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var healthy, reported bool
+
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := db.Ping(pingCtx)
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if !reported || healthy != (err == nil) {
+			if err != nil {
+				log.Errorf("database unreachable: %v", err)
+			} else if reported {
+				log.Infof("database reachable again")
+			}
+			healthy, reported = err == nil, true
+			report(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
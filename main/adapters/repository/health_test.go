@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestMonitorHealth(t *testing.T) {
+	stub := &stubDB{failures: 2, err: &pq.Error{Code: "57P03"}}
+	dm := newDatabaseManager(sql.OpenDB(stub), TestTableName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reports := make(chan error, 10)
+	go MonitorHealth(ctx, dm, 10*time.Millisecond, func(err error) { reports <- err })
+
+	// the database is reported as unhealthy once and as healthy again after it recovered
+	for i, expectHealthy := range []bool{false, true} {
+		select {
+		case err := <-reports:
+			if (err == nil) != expectHealthy {
+				t.Errorf("report #%d: unexpected health: expected healthy=%v, got error %v", i, expectHealthy, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("report #%d: no report received", i)
+		}
+	}
+
+	select {
+	case err := <-reports:
+		t.Errorf("unexpected report without change of health: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
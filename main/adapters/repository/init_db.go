@@ -18,6 +18,7 @@ const MigrationVersion = "1.0.1"
 const (
 	PostgresIdentity = iota
 	PostgresVersion
+	SQLiteIdentity
 	PostgreSqlIdentityTableName string = "identity"
 	PostgreSqlVersionTableName  string = "version"
 )
@@ -37,6 +38,12 @@ var create = map[int]string{
 	PostgresVersion: "CREATE TABLE IF NOT EXISTS %s(" +
 		"id VARCHAR(255) NOT NULL PRIMARY KEY, " +
 		"migration_version VARCHAR(255) NOT NULL);",
+	SQLiteIdentity: "CREATE TABLE IF NOT EXISTS %s(" +
+		"uid VARCHAR(255) NOT NULL PRIMARY KEY, " +
+		"private_key BLOB NOT NULL, " +
+		"public_key BLOB NOT NULL, " +
+		"signature BLOB NOT NULL, " +
+		"auth_token VARCHAR(255) NOT NULL);",
 	//MySQL:    "CREATE TABLE identity (id INT, datetime TIMESTAMP)",
 	//SQLite:   "CREATE TABLE identity (id INTEGER, datetime TEXT)",
 }
@@ -46,10 +53,14 @@ func CreateTable(tableType int, tableName string) string {
 }
 
 func Migrate(c config.Config) error {
+	if IsSQLiteDSN(c.PostgresDSN) {
+		return fmt.Errorf("migration of file based context is only supported for postgres")
+	}
+
 	txCtx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	dbManager, err := NewSqlDatabaseInfo(c.PostgresDSN, PostgreSqlIdentityTableName)
+	dbManager, err := NewSqlDatabaseInfo(c.PostgresDSN, PostgreSqlIdentityTableName, DatabaseParamsFromConfig(c))
 	if err != nil {
 		return err
 	}
@@ -83,7 +94,7 @@ func getAllIdentitiesFromLegacyCtx(c config.Config) ([]ent.Identity, error) {
 
 	secret16Bytes, err := base64.StdEncoding.DecodeString(c.Secret16Base64)
 	if err != nil {
-		return nil, fmt.Errorf("unable to decode base64 encoded secret for legacy key store decoding (%s): %v", c.Secret16Base64, err)
+		return nil, fmt.Errorf("unable to decode base64 encoded secret for legacy key store decoding ('secret'): %v", err)
 	}
 	if len(secret16Bytes) != 16 {
 		return nil, fmt.Errorf("invalid secret for legacy key store decoding: secret length must be 16 bytes (is %d)", len(secret16Bytes))
@@ -93,6 +104,8 @@ func getAllIdentitiesFromLegacyCtx(c config.Config) ([]ent.Identity, error) {
 	if err != nil {
 		return nil, err
 	}
+	//noinspection GoUnhandledErrorResult
+	defer fileManager.Close()
 
 	uids, err := fileManager.EncryptedKeystore.GetIDs()
 	if err != nil {
@@ -129,7 +142,7 @@ func getAllIdentitiesFromLegacyCtx(c config.Config) ([]ent.Identity, error) {
 		i.AuthToken, err = fileManager.GetAuthToken(uid)
 		if err != nil {
 			if os.IsNotExist(err) { // if file does not exist -> get auth token from config
-				i.AuthToken = c.Devices[uid.String()]
+				i.AuthToken = c.Devices[uid.String()].Primary()
 			} else { // file exists but something went wrong
 				return nil, fmt.Errorf("%s: %v", uid, err)
 			}
@@ -0,0 +1,257 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/config"
+	"github.com/ubirch/ubirch-client-go/main/ent"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MigrationReport summarizes the migration of the file based context into the database
+type MigrationReport struct {
+	Migrated []uuid.UUID         // identities which were written to the database
+	Skipped  []uuid.UUID         // identities which were already present in the database
+	Failed   map[uuid.UUID]error // identities which could not be written or verified
+}
+
+func (r *MigrationReport) String() string {
+	return fmt.Sprintf("migrated: %d %v, skipped: %d %v, failed: %d %v",
+		len(r.Migrated), r.Migrated, len(r.Skipped), r.Skipped, len(r.Failed), r.Failed)
+}
+
+// MigrateFileToDatabase copies all identities of the file based context in the config directory into the
+// database of the configured DSN (postgres or SQLite). Each identity is written in its own transaction and
+// read back from the database to verify it.
+//
+// The migration is idempotent: identities which are already present in the database with the same public
+// key are skipped. If an identity is present with a different public key, the migration is refused unless
+// force is true, in which case the identity in the database is replaced by the identity from the files.
+func MigrateFileToDatabase(c config.Config, force bool) (*MigrationReport, error) {
+	identities, err := getAllIdentitiesFromLegacyCtx(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ctxManager, err := GetCtxManager(c)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := NewExtendedProtocol(ctxManager, c.SecretBytes32, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return migrateFileIdentities(p, identities, force)
+}
+
+func migrateFileIdentities(p *ExtendedProtocol, identities []ent.Identity, force bool) (*MigrationReport, error) {
+	present, err := findPresentIdentities(p, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []uuid.UUID
+	for uid, conflict := range present {
+		if conflict {
+			conflicts = append(conflicts, uid)
+		}
+	}
+	if len(conflicts) > 0 && !force {
+		sort.Slice(conflicts, func(a, b int) bool { return conflicts[a].String() < conflicts[b].String() })
+		return nil, fmt.Errorf("%d identities already exist in the database with a different public key: %v, "+
+			"migrate with force to replace them", len(conflicts), conflicts)
+	}
+
+	report := &MigrationReport{Failed: map[uuid.UUID]error{}}
+
+	for i, id := range identities {
+		uid := uuid.MustParse(id.Uid) // validated by findPresentIdentities
+		log.Infof("%4d: %s", i+1, uid)
+
+		conflict, exists := present[uid]
+		if exists && !conflict {
+			log.Infof("%s: already present in database -> skip", uid)
+			report.Skipped = append(report.Skipped, uid)
+			continue
+		}
+
+		if conflict {
+			log.Warnf("%s: replacing identity with different public key in database", uid)
+		}
+
+		err = storeAndVerifyIdentity(p, id, conflict)
+		if err != nil {
+			log.Errorf("%s: migration failed: %v", uid, err)
+			report.Failed[uid] = err
+			continue
+		}
+		report.Migrated = append(report.Migrated, uid)
+	}
+
+	return report, nil
+}
+
+// findPresentIdentities returns the identities which already exist in the database,
+// mapped to true if the identity in the database has a different public key
+func findPresentIdentities(p *ExtendedProtocol, identities []ent.Identity) (map[uuid.UUID]bool, error) {
+	present := map[uuid.UUID]bool{}
+
+	for _, id := range identities {
+		uid, err := uuid.Parse(id.Uid)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", id.Uid, err)
+		}
+
+		exists, err := p.Exists(uid)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", uid, err)
+		}
+		if !exists {
+			continue
+		}
+
+		same, err := samePublicKey(p, uid, id.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", uid, err)
+		}
+		present[uid] = !same
+	}
+
+	return present, nil
+}
+
+func samePublicKey(p *ExtendedProtocol, uid uuid.UUID, pubKeyPEM []byte) (bool, error) {
+	pubKey, err := p.PublicKeyPEMToBytes(pubKeyPEM)
+	if err != nil {
+		return false, err
+	}
+
+	storedPubKey, err := p.ctxManager.GetPublicKey(uid)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(pubKey, storedPubKey), nil
+}
+
+// storeAndVerifyIdentity writes the identity to the database and reads it back to verify that the
+// stored keys, signature and auth token match the identity. If replace is true, the identity in the
+// database is removed in the same transaction in which the identity is written, so that it is kept
+// if the identity can not be written. If the verification fails, the written identity is removed again
+// and the replaced identity is restored, so that a repeated migration does not skip the identity.
+func storeAndVerifyIdentity(p *ExtendedProtocol, id ent.Identity, replace bool) error {
+	uid := uuid.MustParse(id.Uid)
+	ctx := context.Background()
+
+	var (
+		tx       interface{}
+		replaced *ent.Identity
+		err      error
+	)
+	if replace {
+		tx, err = p.StartTransactionWithLock(ctx, uid)
+	} else {
+		tx, err = p.StartTransaction(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	if replace {
+		// the replaced identity is kept as it is stored, i.e. with the encrypted private key
+		replaced, err = p.ctxManager.FetchIdentity(tx, uid)
+		if err == nil {
+			err = p.DeleteIdentity(tx, uid)
+		}
+		if err != nil {
+			_ = p.CloseTransaction(tx, Rollback)
+			return fmt.Errorf("removing conflicting identity failed: %v", err)
+		}
+	}
+
+	stored := id // StoreNewIdentity encrypts the private key of the passed identity
+	err = p.StoreNewIdentity(tx, &stored)
+	if err != nil {
+		_ = p.CloseTransaction(tx, Rollback)
+		return err
+	}
+
+	err = p.CloseTransaction(tx, Commit)
+	if err != nil {
+		return err
+	}
+
+	err = verifyIdentity(p, id)
+	if err != nil {
+		if restoreErr := restoreIdentity(p, uid, replaced); restoreErr != nil {
+			log.Errorf("%s: removing unverified identity failed: %v", uid, restoreErr)
+		}
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	return nil
+}
+
+// restoreIdentity removes the identity and stores the replaced identity in its place, if not nil
+func restoreIdentity(p *ExtendedProtocol, uid uuid.UUID, replaced *ent.Identity) error {
+	tx, err := p.StartTransactionWithLock(context.Background(), uid)
+	if err != nil {
+		return err
+	}
+
+	err = p.DeleteIdentity(tx, uid)
+	if err == nil && replaced != nil {
+		err = p.ctxManager.StoreNewIdentity(tx, replaced)
+	}
+	if err != nil {
+		_ = p.CloseTransaction(tx, Rollback)
+		return err
+	}
+
+	return p.CloseTransaction(tx, Commit)
+}
+
+// verifyIdentity compares the identity in the database with the expected identity
+func verifyIdentity(p *ExtendedProtocol, expected ent.Identity) error {
+	uid := uuid.MustParse(expected.Uid)
+
+	tx, err := p.StartTransaction(context.Background())
+	if err != nil {
+		return err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer p.CloseTransaction(tx, Rollback)
+
+	i, err := p.FetchIdentity(tx, uid)
+	if err != nil {
+		return err
+	}
+
+	same, err := samePublicKey(p, uid, expected.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return fmt.Errorf("stored public key does not match")
+	}
+
+	// the PEM encoding of the decrypted private key may differ, so the private key is verified by its public key
+	err = p.CheckKeyConsistency(uid)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(i.Signature, expected.Signature) {
+		return fmt.Errorf("stored signature does not match")
+	}
+	if i.AuthToken != expected.AuthToken {
+		return fmt.Errorf("stored auth token does not match")
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/config"
+	"github.com/ubirch/ubirch-client-go/main/ent"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+)
+
+func TestMigrateFileToDatabase(t *testing.T) {
+	dir := t.TempDir()
+	identities := storeTestFileIdentities(t, dir, 2)
+
+	dsn := SQLiteScheme + filepath.Join(dir, "identities.db")
+	conf := config.Config{
+		ConfigDir:      dir,
+		Secret16Base64: base64.StdEncoding.EncodeToString(testSecret16),
+		SecretBytes32:  testSecret,
+		PostgresDSN:    dsn,
+	}
+
+	report, err := MigrateFileToDatabase(conf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Migrated) != 2 || len(report.Skipped) != 0 || len(report.Failed) != 0 {
+		t.Fatalf("unexpected migration report: %s", report)
+	}
+
+	sm, err := NewSQLiteManager(dsn, PostgreSqlIdentityTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewExtendedProtocol(sm, testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range identities {
+		err = verifyIdentity(p, id)
+		if err != nil {
+			t.Errorf("%s: %v", id.Uid, err)
+		}
+	}
+
+	// the migration is idempotent
+	report, err = MigrateFileToDatabase(conf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Migrated) != 0 || len(report.Skipped) != 2 || len(report.Failed) != 0 {
+		t.Fatalf("unexpected migration report of repeated migration: %s", report)
+	}
+}
+
+func TestMigrateFileToDatabase_Conflict(t *testing.T) {
+	dir := t.TempDir()
+	identities := storeTestFileIdentities(t, dir, 2)
+
+	dsn := SQLiteScheme + filepath.Join(dir, "identities.db")
+	conf := config.Config{
+		ConfigDir:      dir,
+		Secret16Base64: base64.StdEncoding.EncodeToString(testSecret16),
+		SecretBytes32:  testSecret,
+		PostgresDSN:    dsn,
+	}
+
+	sm, err := NewSQLiteManager(dsn, PostgreSqlIdentityTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewExtendedProtocol(sm, testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an identity with the same UUID, but a different key, is already in the database
+	conflictUID := uuid.MustParse(identities[0].Uid)
+	storeTestIdentity(t, p, conflictUID)
+
+	_, err = MigrateFileToDatabase(conf, false)
+	if err == nil {
+		t.Fatal("migration with conflicting identity in database did not fail")
+	}
+
+	// nothing was migrated
+	exists, err := p.Exists(uuid.MustParse(identities[1].Uid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("identity was migrated although the migration was refused")
+	}
+
+	report, err := MigrateFileToDatabase(conf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Migrated) != 2 || len(report.Failed) != 0 {
+		t.Fatalf("unexpected migration report: %s", report)
+	}
+
+	// the conflicting identity was replaced by the identity from the files
+	err = verifyIdentity(p, identities[0])
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrateFileToDatabase_ConflictKeptOnFailure(t *testing.T) {
+	sm, err := NewSQLiteManager(SQLiteScheme+filepath.Join(t.TempDir(), "identities.db"), PostgreSqlIdentityTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewExtendedProtocol(sm, testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identities := storeTestFileIdentities(t, t.TempDir(), 1)
+	uid := uuid.MustParse(identities[0].Uid)
+	storeTestIdentity(t, p, uid)
+	pubKeyPEM, err := p.GetPublicKey(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the identity from the files can not be stored, so the conflicting identity must be kept
+	identities[0].Signature = []byte("invalid signature")
+
+	report, err := migrateFileIdentities(p, identities, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Migrated) != 0 || len(report.Failed) != 1 {
+		t.Fatalf("unexpected migration report: %s", report)
+	}
+
+	storedPubKeyPEM, err := p.GetPublicKey(uid)
+	if err != nil {
+		t.Fatalf("conflicting identity was removed: %v", err)
+	}
+	if !bytes.Equal(storedPubKeyPEM, pubKeyPEM) {
+		t.Error("conflicting identity was replaced although the migration failed")
+	}
+}
+
+// storeTestFileIdentities stores identities in the file based context like a legacy client, i.e. with PEM encoded keys
+func storeTestFileIdentities(t *testing.T, dir string, n int) []ent.Identity {
+	f, err := NewFileManager(dir, testSecret16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	c := &ubirch.ECDSACryptoContext{}
+	var identities []ent.Identity
+
+	for i := 0; i < n; i++ {
+		privKeyPEM, err := c.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKeyPEM, err := c.GetPublicKeyFromPrivateKey(privKeyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id := ent.Identity{
+			Uid:        uuid.NewString(),
+			PrivateKey: privKeyPEM,
+			PublicKey:  pubKeyPEM,
+			Signature:  bytes.Repeat([]byte{byte(i + 1)}, c.SignatureLength()),
+			AuthToken:  TestAuthToken,
+		}
+
+		tx, err := f.StartTransaction(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = f.StoreNewIdentity(tx, &id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = f.CloseTransaction(tx, Commit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		identities = append(identities, id)
+	}
+
+	return identities
+}
@@ -15,7 +15,10 @@
 package repository
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
 
 	"github.com/google/uuid"
@@ -23,6 +26,8 @@ import (
 	"github.com/ubirch/ubirch-client-go/main/adapters/encrypters"
 	"github.com/ubirch/ubirch-client-go/main/ent"
 	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type ExtendedProtocol struct {
@@ -35,9 +40,24 @@ type ExtendedProtocol struct {
 // Ensure ExtendedProtocol implements the ContextManager interface
 var _ ContextManager = (*ExtendedProtocol)(nil)
 
+// ContextCrypto is implemented by crypto backends which sign with network requests, e.g. AWS KMS,
+// so that the signing respects the request context
+type ContextCrypto interface {
+	SignContext(ctx context.Context, privKeyPEM []byte, data []byte) ([]byte, error)
+}
+
+// UUIDKeyGenerator is implemented by crypto backends which manage the keys of the identities themselves,
+// e.g. AWS KMS, so that a new key can be associated with the UUID of the identity
+type UUIDKeyGenerator interface {
+	GenerateKeyForUUID(uid uuid.UUID) (privKeyPEM []byte, err error)
+}
+
 func NewExtendedProtocol(ctxManager ContextManager, secret []byte, client *clients.Client) (*ExtendedProtocol, error) {
-	crypto := &ubirch.ECDSACryptoContext{}
+	return NewExtendedProtocolWithCrypto(&ubirch.ECDSACryptoContext{}, ctxManager, secret, client)
+}
 
+// NewExtendedProtocolWithCrypto creates the protocol with a custom crypto backend, e.g. for signing with AWS KMS
+func NewExtendedProtocolWithCrypto(crypto ubirch.Crypto, ctxManager ContextManager, secret []byte, client *clients.Client) (*ExtendedProtocol, error) {
 	enc, err := encrypters.NewKeyEncrypter(secret, crypto)
 	if err != nil {
 		return nil, err
@@ -71,6 +91,14 @@ func (p *ExtendedProtocol) Exists(uid uuid.UUID) (bool, error) {
 	return p.ctxManager.Exists(uid)
 }
 
+func (p *ExtendedProtocol) GetUIDs() ([]uuid.UUID, error) {
+	return p.ctxManager.GetUIDs()
+}
+
+func (p *ExtendedProtocol) GetIdentityInfos(limit, offset int) ([]ent.IdentityInfo, error) {
+	return p.ctxManager.GetIdentityInfos(limit, offset)
+}
+
 func (p *ExtendedProtocol) StoreNewIdentity(tx interface{}, i *ent.Identity) error {
 	// check validity of identity attributes
 	err := p.checkIdentityAttributes(i)
@@ -99,6 +127,11 @@ func (p *ExtendedProtocol) FetchIdentity(tx interface{}, uid uuid.UUID) (*ent.Id
 		return nil, err
 	}
 
+	// an identity which was never used for chaining starts the chain with the initial signature
+	if len(i.Signature) == 0 {
+		i.Signature = make([]byte, p.SignatureLength())
+	}
+
 	err = p.checkIdentityAttributes(i)
 	if err != nil {
 		return nil, err
@@ -128,6 +161,7 @@ func (p *ExtendedProtocol) FetchIdentityWithLock(ctx context.Context, uid uuid.U
 
 	identity, err = p.FetchIdentity(transactionCtx, uid)
 	if err != nil {
+		_ = p.CloseTransaction(transactionCtx, Rollback)
 		return nil, nil, fmt.Errorf("could not fetch identity: %v", err)
 	}
 
@@ -148,6 +182,121 @@ func (p *ExtendedProtocol) SetSignature(tx interface{}, uid uuid.UUID, signature
 	return p.CloseTransaction(tx, Commit)
 }
 
+// PersistSignature stores the signature of a chained UPP and commits the transaction. If the transaction
+// was aborted because of a serialization failure or deadlock with a concurrent transaction, the signature
+// is stored in a new transaction, as long as the chain was not advanced by another UPP in the meantime,
+// i.e. the stored signature is still the previous signature of the UPP.
+func (p *ExtendedProtocol) PersistSignature(tx interface{}, uid uuid.UUID, prevSignature, signature []byte) error {
+	err := p.SetSignature(tx, uid, signature)
+	if err == nil || !isSerializationFailure(err) {
+		return err
+	}
+
+	updater, ok := p.ctxManager.(SignatureUpdater)
+	if !ok {
+		return err
+	}
+
+	// the aborted transaction must be closed before the signature can be stored in a new transaction
+	_ = p.CloseTransaction(tx, Rollback)
+
+	log.Warnf("%s: storing signature failed: %v, retrying in new transaction", uid, err)
+	return updater.UpdateSignature(context.Background(), uid, prevSignature, signature)
+}
+
+// GenerateKeyForUUID generates a new private key for the identity
+func (p *ExtendedProtocol) GenerateKeyForUUID(uid uuid.UUID) (privKeyPEM []byte, err error) {
+	if generator, ok := p.Crypto.(UUIDKeyGenerator); ok {
+		return generator.GenerateKeyForUUID(uid)
+	}
+	return p.GenerateKey()
+}
+
+// Sign encodes, signs and appends the signature to a UPP. In contrast to ubirch.Protocol.Sign,
+// which only accepts payloads of the size of a SHA-256 hash, the payload may also be a SHA-512 hash.
+func (p *ExtendedProtocol) Sign(privKeyPEM []byte, upp ubirch.UPP) ([]byte, error) {
+	return p.SignContext(context.Background(), privKeyPEM, upp)
+}
+
+// SignContext is like Sign, but a crypto backend which signs with network requests respects the context
+func (p *ExtendedProtocol) SignContext(ctx context.Context, privKeyPEM []byte, upp ubirch.UPP) ([]byte, error) {
+	if len(upp.GetPayload()) != sha256.Size && len(upp.GetPayload()) != sha512.Size {
+		return nil, fmt.Errorf("invalid hash size: expected %d or %d, got %d bytes", sha256.Size, sha512.Size, len(upp.GetPayload()))
+	}
+	if upp.GetVersion() == ubirch.Chained && len(upp.GetPrevSignature()) != p.SignatureLength() {
+		return nil, fmt.Errorf("invalid prev. signature size: expected %d, got %d bytes", p.SignatureLength(), len(upp.GetPrevSignature()))
+	}
+
+	encoded, err := ubirch.Encode(upp)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last byte is the placeholder for the empty signature
+	uppWithoutSig := encoded[:len(encoded)-1]
+
+	var signature []byte
+	if contextCrypto, ok := p.Crypto.(ContextCrypto); ok {
+		signature, err = contextCrypto.SignContext(ctx, privKeyPEM, uppWithoutSig)
+	} else {
+		signature, err = p.Crypto.Sign(privKeyPEM, uppWithoutSig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(signature) != p.SignatureLength() {
+		return nil, fmt.Errorf("generated signature has invalid length: expected %d, got %d bytes", p.SignatureLength(), len(signature))
+	}
+
+	// append signature as msgpack bin 8
+	uppWithSig := append(uppWithoutSig, 0xC4, byte(len(signature)))
+	return append(uppWithSig, signature...), nil
+}
+
+func (p *ExtendedProtocol) SetAuthToken(tx interface{}, uid uuid.UUID, authToken string) error {
+	if len(authToken) == 0 {
+		return fmt.Errorf("empty auth token")
+	}
+	return p.ctxManager.SetAuthToken(tx, uid, authToken)
+}
+
+// SetAuthTokenWithLock locks the identity and replaces its auth token
+func (p *ExtendedProtocol) SetAuthTokenWithLock(ctx context.Context, uid uuid.UUID, authToken string) error {
+	tx, err := p.StartTransactionWithLock(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("starting transaction with lock failed: %v", err)
+	}
+
+	err = p.SetAuthToken(tx, uid, authToken)
+	if err != nil {
+		_ = p.CloseTransaction(tx, Rollback)
+		return err
+	}
+
+	return p.CloseTransaction(tx, Commit)
+}
+
+func (p *ExtendedProtocol) DeleteIdentity(tx interface{}, uid uuid.UUID) error {
+	return p.ctxManager.DeleteIdentity(tx, uid)
+}
+
+// DeleteIdentityWithLock locks and removes the identity. Since the identity is locked before it is
+// removed, an in-flight chaining request for the identity is completed first.
+func (p *ExtendedProtocol) DeleteIdentityWithLock(ctx context.Context, uid uuid.UUID) error {
+	tx, err := p.StartTransactionWithLock(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("starting transaction with lock failed: %v", err)
+	}
+
+	err = p.DeleteIdentity(tx, uid)
+	if err != nil {
+		_ = p.CloseTransaction(tx, Rollback)
+		return err
+	}
+
+	return p.CloseTransaction(tx, Commit)
+}
+
 func (p *ExtendedProtocol) GetPrivateKey(uid uuid.UUID) ([]byte, error) {
 	encryptedPrivateKey, err := p.ctxManager.GetPrivateKey(uid)
 	if err != nil {
@@ -179,6 +328,55 @@ func (p *ExtendedProtocol) GetAuthToken(uid uuid.UUID) (string, error) {
 	return authToken, nil
 }
 
+// CheckKeyConsistency derives the public key from the stored private key of an identity
+// and returns an error if it does not match the stored public key
+func (p *ExtendedProtocol) CheckKeyConsistency(uid uuid.UUID) error {
+	privKeyPEM, err := p.GetPrivateKey(uid)
+	if err != nil {
+		return fmt.Errorf("could not get private key: %v", err)
+	}
+
+	storedPubKey, err := p.ctxManager.GetPublicKey(uid)
+	if err != nil {
+		return fmt.Errorf("could not get public key: %v", err)
+	}
+
+	derivedPubKeyPEM, err := p.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		return fmt.Errorf("could not derive public key from private key: %v", err)
+	}
+
+	derivedPubKey, err := p.PublicKeyPEMToBytes(derivedPubKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(storedPubKey, derivedPubKey) {
+		return fmt.Errorf("stored public key does not match public key derived from private key")
+	}
+
+	return nil
+}
+
+// CheckChainState loads the stored signature of an identity, i.e. the state from which chaining
+// continues, and returns whether any UPP was chained with the identity yet. Returns an error if
+// the stored identity is invalid, e.g. if the signature has an unexpected length.
+func (p *ExtendedProtocol) CheckChainState(uid uuid.UUID) (chained bool, err error) {
+	tx, err := p.StartTransaction(context.Background())
+	if err != nil {
+		return false, err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer p.CloseTransaction(tx, Rollback)
+
+	i, err := p.FetchIdentity(tx, uid)
+	if err != nil {
+		return false, err
+	}
+
+	return !isZero(i.Signature), nil
+}
+
 func (p *ExtendedProtocol) checkIdentityAttributes(i *ent.Identity) error {
 	_, err := uuid.Parse(i.Uid)
 	if err != nil {
@@ -203,3 +401,12 @@ func (p *ExtendedProtocol) checkIdentityAttributes(i *ent.Identity) error {
 
 	return nil
 }
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,416 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ubirch/ubirch-client-go/main/ent"
+	"github.com/ubirch/ubirch-protocol-go/ubirch/v2"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestExtendedProtocol_CheckKeyConsistency(t *testing.T) {
+	p, err := NewExtendedProtocol(newMockCtxManager(), testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uid := uuid.New()
+	storeTestIdentity(t, p, uid)
+
+	err = p.CheckKeyConsistency(uid)
+	if err != nil {
+		t.Errorf("consistent keys were reported as inconsistent: %v", err)
+	}
+
+	// replace stored public key with the public key of another key pair
+	otherPrivKeyPEM, err := p.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPubKeyPEM, err := p.GetPublicKeyFromPrivateKey(otherPrivKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPubKey, err := p.PublicKeyPEMToBytes(otherPubKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ctxManager.(*mockCtxManager).identities[uid].PublicKey = otherPubKey
+
+	err = p.CheckKeyConsistency(uid)
+	if err == nil {
+		t.Error("mismatched public key was not detected")
+	}
+}
+
+func TestExtendedProtocol_PersistSignature(t *testing.T) {
+	uid := uuid.MustParse(TestUUID)
+	prevSignature := bytes.Repeat([]byte{1}, 64)
+	signature := bytes.Repeat([]byte{2}, 64)
+
+	// the commit of the transaction of the UPP and the first retry are aborted by concurrent transactions
+	stub := &stubDB{signature: prevSignature, commitFailures: 2, commitErr: &pq.Error{Code: "40001"}}
+	dm := newDatabaseManager(sql.OpenDB(stub), TestTableName)
+	dm.retryBackoff = time.Millisecond
+
+	p, err := NewExtendedProtocol(dm, testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := p.StartTransactionWithLock(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.PersistSignature(tx, uid, prevSignature, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stub.storedSignature(), signature) {
+		t.Errorf("unexpected stored signature: expected %x, got %x", signature, stub.storedSignature())
+	}
+}
+
+func TestExtendedProtocol_PersistSignature_ChainAdvanced(t *testing.T) {
+	uid := uuid.MustParse(TestUUID)
+	prevSignature := bytes.Repeat([]byte{1}, 64)
+	signature := bytes.Repeat([]byte{2}, 64)
+	otherSignature := bytes.Repeat([]byte{3}, 64)
+
+	stub := &stubDB{signature: prevSignature, commitFailures: 1, commitErr: &pq.Error{Code: "40P01"}}
+	dm := newDatabaseManager(sql.OpenDB(stub), TestTableName)
+	dm.retryBackoff = time.Millisecond
+
+	p, err := NewExtendedProtocol(dm, testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := p.StartTransactionWithLock(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the chain is advanced by the concurrent transaction which caused the conflict
+	stub.mutex.Lock()
+	stub.signature = otherSignature
+	stub.mutex.Unlock()
+
+	err = p.PersistSignature(tx, uid, prevSignature, signature)
+	if err != ErrChainAdvanced {
+		t.Fatalf("unexpected error: expected %v, got %v", ErrChainAdvanced, err)
+	}
+	if !bytes.Equal(stub.storedSignature(), otherSignature) {
+		t.Errorf("signature of concurrent transaction was overwritten: %x", stub.storedSignature())
+	}
+}
+
+func TestExtendedProtocol_CheckChainState(t *testing.T) {
+	var tests = []struct {
+		name            string
+		signature       []byte
+		expectedChained bool
+		expectedErr     bool
+	}{
+		{
+			name:            "key present, signature absent",
+			signature:       []byte{},
+			expectedChained: false,
+		},
+		{
+			name:            "initial signature",
+			signature:       make([]byte, 64),
+			expectedChained: false,
+		},
+		{
+			name:            "chained",
+			signature:       bytes.Repeat([]byte{1}, 64),
+			expectedChained: true,
+		},
+		{
+			name:        "invalid signature length",
+			signature:   bytes.Repeat([]byte{1}, 32),
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := NewExtendedProtocol(newMockCtxManager(), testSecret, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			uid := uuid.New()
+			storeTestIdentity(t, p, uid)
+			p.ctxManager.(*mockCtxManager).identities[uid].Signature = test.signature
+
+			chained, err := p.CheckChainState(uid)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if chained != test.expectedChained {
+				t.Errorf("unexpected chain state: expected chained=%v, got %v", test.expectedChained, chained)
+			}
+		})
+	}
+}
+
+func TestExtendedProtocol_Sign(t *testing.T) {
+	p, err := NewExtendedProtocol(newMockCtxManager(), testSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privKeyPEM, err := p.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyPEM, err := p.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sha256Hash := sha256.Sum256([]byte("test"))
+	sha512Hash := sha512.Sum512([]byte("test"))
+
+	for _, payload := range [][]byte{sha256Hash[:], sha512Hash[:]} {
+		upp, err := p.Sign(privKeyPEM, &ubirch.SignedUPP{
+			Version: ubirch.Signed,
+			Uuid:    uuid.New(),
+			Hint:    ubirch.Binary,
+			Payload: payload,
+		})
+		if err != nil {
+			t.Fatalf("signing UPP with %d byte payload failed: %v", len(payload), err)
+		}
+
+		ok, err := p.Verify(pubKeyPEM, upp)
+		if err != nil || !ok {
+			t.Errorf("UPP with %d byte payload could not be verified: %v", len(payload), err)
+		}
+
+		decoded, err := ubirch.Decode(upp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded.GetPayload(), payload) {
+			t.Errorf("unexpected payload: %x", decoded.GetPayload())
+		}
+	}
+
+	_, err = p.Sign(privKeyPEM, &ubirch.SignedUPP{
+		Version: ubirch.Signed,
+		Uuid:    uuid.New(),
+		Hint:    ubirch.Binary,
+		Payload: []byte("invalid"),
+	})
+	if err == nil {
+		t.Error("UPP with invalid payload size was signed")
+	}
+}
+
+func storeTestIdentity(t *testing.T, p *ExtendedProtocol, uid uuid.UUID) {
+	privKeyPEM, err := p.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyPEM, err := p.GetPublicKeyFromPrivateKey(privKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := p.StartTransaction(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.StoreNewIdentity(tx, &ent.Identity{
+		Uid:        uid.String(),
+		PrivateKey: privKeyPEM,
+		PublicKey:  pubKeyPEM,
+		Signature:  make([]byte, p.SignatureLength()),
+		AuthToken:  TestAuthToken,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.CloseTransaction(tx, Commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mockCtxManager is an in-memory implementation of the ContextManager interface for testing
+type mockCtxManager struct {
+	identities map[uuid.UUID]*ent.Identity
+	mutex      *sync.RWMutex
+}
+
+var _ ContextManager = (*mockCtxManager)(nil)
+
+func newMockCtxManager() *mockCtxManager {
+	return &mockCtxManager{
+		identities: map[uuid.UUID]*ent.Identity{},
+		mutex:      &sync.RWMutex{},
+	}
+}
+
+func (m *mockCtxManager) StartTransaction(context.Context) (interface{}, error) {
+	return m, nil
+}
+
+func (m *mockCtxManager) StartTransactionWithLock(ctx context.Context, uid uuid.UUID) (interface{}, error) {
+	return m.StartTransaction(ctx)
+}
+
+func (m *mockCtxManager) CloseTransaction(interface{}, bool) error {
+	return nil
+}
+
+func (m *mockCtxManager) Exists(uid uuid.UUID) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, found := m.identities[uid]
+	return found, nil
+}
+
+func (m *mockCtxManager) GetUIDs() ([]uuid.UUID, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var uids []uuid.UUID
+	for uid := range m.identities {
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+func (m *mockCtxManager) GetIdentityInfos(limit, offset int) ([]ent.IdentityInfo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	infos := []ent.IdentityInfo{}
+	for _, i := range m.identities {
+		infos = append(infos, ent.IdentityInfo{
+			Uid:          i.Uid,
+			HasPublicKey: len(i.PublicKey) > 0,
+			HasSignature: !bytes.Equal(i.Signature, make([]byte, len(i.Signature))),
+		})
+	}
+	sort.Slice(infos, func(a, b int) bool { return infos[a].Uid < infos[b].Uid })
+
+	if offset > len(infos) {
+		offset = len(infos)
+	}
+	infos = infos[offset:]
+	if limit < len(infos) {
+		infos = infos[:limit]
+	}
+	return infos, nil
+}
+
+func (m *mockCtxManager) StoreNewIdentity(_ interface{}, identity *ent.Identity) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	uid := uuid.MustParse(identity.Uid)
+	if _, found := m.identities[uid]; found {
+		return ErrExists
+	}
+	i := *identity
+	m.identities[uid] = &i
+	return nil
+}
+
+func (m *mockCtxManager) FetchIdentity(_ interface{}, uid uuid.UUID) (*ent.Identity, error) {
+	i, err := m.get(uid)
+	if err != nil {
+		return nil, err
+	}
+	id := *i
+	return &id, nil
+}
+
+func (m *mockCtxManager) SetSignature(_ interface{}, uid uuid.UUID, signature []byte) error {
+	i, err := m.get(uid)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	i.Signature = signature
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *mockCtxManager) SetAuthToken(_ interface{}, uid uuid.UUID, authToken string) error {
+	i, err := m.get(uid)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	i.AuthToken = authToken
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *mockCtxManager) DeleteIdentity(_ interface{}, uid uuid.UUID) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, found := m.identities[uid]; !found {
+		return ErrNotExist
+	}
+	delete(m.identities, uid)
+	return nil
+}
+
+func (m *mockCtxManager) GetPrivateKey(uid uuid.UUID) ([]byte, error) {
+	i, err := m.get(uid)
+	if err != nil {
+		return nil, err
+	}
+	return i.PrivateKey, nil
+}
+
+func (m *mockCtxManager) GetPublicKey(uid uuid.UUID) ([]byte, error) {
+	i, err := m.get(uid)
+	if err != nil {
+		return nil, err
+	}
+	return i.PublicKey, nil
+}
+
+func (m *mockCtxManager) GetAuthToken(uid uuid.UUID) (string, error) {
+	i, err := m.get(uid)
+	if err != nil {
+		return "", err
+	}
+	return i.AuthToken, nil
+}
+
+func (m *mockCtxManager) get(uid uuid.UUID) (*ent.Identity, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	i, found := m.identities[uid]
+	if !found {
+		return nil, fmt.Errorf("%s: identity not found", uid)
+	}
+	return i, nil
+}
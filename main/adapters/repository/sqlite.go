@@ -0,0 +1,444 @@
+// Copyright (c) 2019-2020 ubirch GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/ent"
+
+	log "github.com/sirupsen/logrus"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	SQLite       string = "sqlite"
+	SQLiteScheme string = "sqlite://"
+	SQLiteSuffix string = ".db"
+
+	// sqliteParams are the query parameters for the sqlite driver: wait for locks held by other
+	// connections instead of failing, allow readers while writing and lock the database for
+	// writing as soon as a transaction is started
+	sqliteParams = "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+)
+
+// SQLiteManager contains the connection to a SQLite database file, and offers methods
+// for interacting with the database.
+//
+// SQLite only supports locks on the whole database, so locking the database for the
+// duration of a chaining request would serialize all requests. Instead, the row lock
+// of an identity is held in memory. Changes within a transaction are collected and
+// written to the database in a short transaction when the transaction is committed.
+type SQLiteManager struct {
+	db        *sql.DB
+	tableName string
+
+	locks      map[uuid.UUID]*sqliteLock
+	locksMutex *sync.Mutex
+}
+
+// sqliteLock is the in-memory row lock of an identity. It is removed from the locks
+// of the manager as soon as no transaction holds or waits for it anymore.
+type sqliteLock struct {
+	ch   chan struct{}
+	refs int // number of transactions which hold or wait for the lock
+}
+
+// sqliteTx is the transaction context of the SQLite manager
+type sqliteTx struct {
+	ctx        context.Context
+	statements []sqliteStatement
+	deleted    map[uuid.UUID]bool // identities which are deleted by the transaction and can be stored again
+	unlock     func()
+	done       bool
+}
+
+type sqliteStatement struct {
+	query string
+	args  []interface{}
+}
+
+// Ensure SQLiteManager implements the ContextManager interface
+var _ ContextManager = (*SQLiteManager)(nil)
+
+// IsSQLiteDSN returns true, if the data source name has the scheme "sqlite://"
+// or is the path of a ".db" file
+func IsSQLiteDSN(dataSourceName string) bool {
+	return strings.HasPrefix(dataSourceName, SQLiteScheme) || strings.HasSuffix(dataSourceName, SQLiteSuffix)
+}
+
+// NewSQLiteManager takes a data source name, i.e. the path of a database file with or
+// without the scheme "sqlite://", and returns a new initialized database.
+// The database file and the identity table are created, if they do not exist yet.
+func NewSQLiteManager(dataSourceName, tableName string) (*SQLiteManager, error) {
+	path := strings.TrimPrefix(dataSourceName, SQLiteScheme)
+	if path == "" {
+		return nil, fmt.Errorf("missing path of SQLite database file in DSN: %s", dataSourceName)
+	}
+
+	db, err := sql.Open(SQLite, path+sqliteParams)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("preparing SQLite usage: %s", path)
+
+	if _, err = db.Exec(CreateTable(SQLiteIdentity, tableName)); err != nil {
+		return nil, err
+	}
+
+	return &SQLiteManager{
+		db:         db,
+		tableName:  tableName,
+		locks:      map[uuid.UUID]*sqliteLock{},
+		locksMutex: &sync.Mutex{},
+	}, nil
+}
+
+// Ping checks that the database file is accessible
+func (sm *SQLiteManager) Ping(ctx context.Context) error {
+	return sm.db.PingContext(ctx)
+}
+
+func (sm *SQLiteManager) Exists(uid uuid.UUID) (bool, error) {
+	var id string
+
+	query := fmt.Sprintf("SELECT uid FROM %s WHERE uid = ?", sm.tableName)
+
+	err := sm.db.QueryRow(query, uid.String()).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetUIDs returns the UUIDs of all stored identities
+func (sm *SQLiteManager) GetUIDs() ([]uuid.UUID, error) {
+	query := fmt.Sprintf("SELECT uid FROM %s", sm.tableName)
+
+	rows, err := sm.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer rows.Close()
+
+	var uids []uuid.UUID
+
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		uid, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stored uid \"%s\": %v", id, err)
+		}
+		uids = append(uids, uid)
+	}
+
+	return uids, rows.Err()
+}
+
+// GetIdentityInfos returns the metadata of at most limit stored identities ordered by their UUID,
+// starting at the given offset. A signature consisting of zeros only is the initial value, i.e.
+// no UPP was chained with the identity yet.
+func (sm *SQLiteManager) GetIdentityInfos(limit, offset int) ([]ent.IdentityInfo, error) {
+	query := fmt.Sprintf("SELECT uid, length(public_key) > 0, signature <> zeroblob(length(signature)) "+
+		"FROM %s ORDER BY uid LIMIT ? OFFSET ?", sm.tableName)
+
+	rows, err := sm.db.Query(query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer rows.Close()
+
+	infos := []ent.IdentityInfo{}
+
+	for rows.Next() {
+		var info ent.IdentityInfo
+		if err = rows.Scan(&info.Uid, &info.HasPublicKey, &info.HasSignature); err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, rows.Err()
+}
+
+func (sm *SQLiteManager) GetPrivateKey(uid uuid.UUID) ([]byte, error) {
+	var privateKey []byte
+
+	query := fmt.Sprintf("SELECT private_key FROM %s WHERE uid = ?", sm.tableName)
+
+	err := sm.db.QueryRow(query, uid.String()).Scan(&privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return privateKey, nil
+}
+
+func (sm *SQLiteManager) GetPublicKey(uid uuid.UUID) ([]byte, error) {
+	var publicKey []byte
+
+	query := fmt.Sprintf("SELECT public_key FROM %s WHERE uid = ?", sm.tableName)
+
+	err := sm.db.QueryRow(query, uid.String()).Scan(&publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return publicKey, nil
+}
+
+func (sm *SQLiteManager) GetAuthToken(uid uuid.UUID) (string, error) {
+	var authToken string
+
+	query := fmt.Sprintf("SELECT auth_token FROM %s WHERE uid = ?", sm.tableName)
+
+	err := sm.db.QueryRow(query, uid.String()).Scan(&authToken)
+	if err != nil {
+		return "", err
+	}
+
+	return authToken, nil
+}
+
+func (sm *SQLiteManager) StartTransaction(ctx context.Context) (transactionCtx interface{}, err error) {
+	return &sqliteTx{ctx: ctx, unlock: func() {}}, nil
+}
+
+// StartTransactionWithLock starts a transaction and acquires a lock on the identity with the specified uuid.
+// Waits until the lock is released by concurrent transactions or the context is done.
+// Returns error if the identity does not exist.
+func (sm *SQLiteManager) StartTransactionWithLock(ctx context.Context, uid uuid.UUID) (transactionCtx interface{}, err error) {
+	lock := sm.lock(uid)
+
+	select {
+	case lock.ch <- struct{}{}:
+	case <-ctx.Done():
+		sm.release(uid, lock)
+		return nil, ctx.Err()
+	}
+	unlock := func() {
+		<-lock.ch
+		sm.release(uid, lock)
+	}
+
+	exists, err := sm.Exists(uid)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	if !exists {
+		unlock()
+		return nil, sql.ErrNoRows
+	}
+
+	return &sqliteTx{ctx: ctx, unlock: unlock}, nil
+}
+
+// CloseTransaction writes the changes of a committed transaction to the database
+// and releases the lock of the transaction
+func (sm *SQLiteManager) CloseTransaction(transactionCtx interface{}, commit bool) error {
+	tx, ok := transactionCtx.(*sqliteTx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for SQLite manager is not of expected type *sqliteTx")
+	}
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	defer tx.unlock()
+
+	if !commit || len(tx.statements) == 0 {
+		return nil
+	}
+
+	sqlTx, err := sm.db.BeginTx(tx.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range tx.statements {
+		_, err = sqlTx.Exec(s.query, s.args...)
+		if err != nil {
+			_ = sqlTx.Rollback()
+			// an identity which was stored concurrently after StoreNewIdentity checked that it does not exist
+			if isUniqueViolation(err) {
+				return ErrExists
+			}
+			return err
+		}
+	}
+
+	return sqlTx.Commit()
+}
+
+func (sm *SQLiteManager) FetchIdentity(transactionCtx interface{}, uid uuid.UUID) (*ent.Identity, error) {
+	if _, ok := transactionCtx.(*sqliteTx); !ok {
+		return nil, fmt.Errorf("transactionCtx for SQLite manager is not of expected type *sqliteTx")
+	}
+
+	var id ent.Identity
+
+	query := fmt.Sprintf("SELECT uid, private_key, public_key, signature, auth_token FROM %s WHERE uid = ?", sm.tableName)
+
+	err := sm.db.QueryRow(query, uid.String()).Scan(&id.Uid, &id.PrivateKey, &id.PublicKey, &id.Signature, &id.AuthToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &id, nil
+}
+
+func (sm *SQLiteManager) SetSignature(transactionCtx interface{}, uid uuid.UUID, signature []byte) error {
+	tx, ok := transactionCtx.(*sqliteTx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for SQLite manager is not of expected type *sqliteTx")
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET signature = ? WHERE uid = ?;", sm.tableName)
+
+	tx.add(query, signature, uid.String())
+	return nil
+}
+
+func (sm *SQLiteManager) SetAuthToken(transactionCtx interface{}, uid uuid.UUID, authToken string) error {
+	tx, ok := transactionCtx.(*sqliteTx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for SQLite manager is not of expected type *sqliteTx")
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET auth_token = ? WHERE uid = ?;", sm.tableName)
+
+	tx.add(query, authToken, uid.String())
+	return nil
+}
+
+// DeleteIdentity removes the identity with its keys, signature and auth token.
+// Returns ErrNotExist if there is no identity with the specified uuid.
+func (sm *SQLiteManager) DeleteIdentity(transactionCtx interface{}, uid uuid.UUID) error {
+	tx, ok := transactionCtx.(*sqliteTx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for SQLite manager is not of expected type *sqliteTx")
+	}
+
+	exists, err := sm.Exists(uid)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotExist
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE uid = ?;", sm.tableName)
+
+	tx.add(query, uid.String())
+	if tx.deleted == nil {
+		tx.deleted = map[uuid.UUID]bool{}
+	}
+	tx.deleted[uid] = true
+	return nil
+}
+
+func (sm *SQLiteManager) StoreNewIdentity(transactionCtx interface{}, identity *ent.Identity) error {
+	tx, ok := transactionCtx.(*sqliteTx)
+	if !ok {
+		return fmt.Errorf("transactionCtx for SQLite manager is not of expected type *sqliteTx")
+	}
+
+	uid, err := uuid.Parse(identity.Uid)
+	if err != nil {
+		return err
+	}
+
+	// make sure identity does not exist yet, or is replaced within the transaction
+	exists, err := sm.Exists(uid)
+	if err != nil {
+		return err
+	}
+	if exists && !tx.deleted[uid] {
+		return ErrExists
+	}
+
+	query := fmt.Sprintf(
+		"INSERT INTO %s (uid, private_key, public_key, signature, auth_token) VALUES (?, ?, ?, ?, ?);",
+		sm.tableName)
+
+	tx.add(query, identity.Uid, identity.PrivateKey, identity.PublicKey, identity.Signature, identity.AuthToken)
+	return nil
+}
+
+// lock returns the lock of the identity with the specified uuid. The lock must be
+// released with release, when the transaction does not hold or wait for it anymore.
+func (sm *SQLiteManager) lock(uid uuid.UUID) *sqliteLock {
+	sm.locksMutex.Lock()
+	defer sm.locksMutex.Unlock()
+
+	lock, found := sm.locks[uid]
+	if !found {
+		lock = &sqliteLock{ch: make(chan struct{}, 1)}
+		sm.locks[uid] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// release removes the lock of the identity with the specified uuid, if no other
+// transaction holds or waits for it, so that the locks do not grow unbounded
+func (sm *SQLiteManager) release(uid uuid.UUID, lock *sqliteLock) {
+	sm.locksMutex.Lock()
+	defer sm.locksMutex.Unlock()
+
+	lock.refs--
+	if lock.refs == 0 {
+		delete(sm.locks, uid)
+	}
+}
+
+// isUniqueViolation returns true if the error is a violation of the primary key or a unique constraint
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY, sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+		return true
+	default:
+		return false
+	}
+}
+
+func (tx *sqliteTx) add(query string, args ...interface{}) {
+	tx.statements = append(tx.statements, sqliteStatement{query: query, args: args})
+}
@@ -0,0 +1,98 @@
+// Package systemd implements the sd_notify protocol, so that systemd knows when a service of
+// Type=notify is ready and can restart it when it stops pinging the watchdog. All functions are
+// no-ops if the client is not run by systemd, i.e. if the environment variable NOTIFY_SOCKET is not set.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	Ready    = "READY=1"    // the service finished starting up
+	Stopping = "STOPPING=1" // the service is beginning its shutdown
+	Watchdog = "WATCHDOG=1" // keep-alive ping of the watchdog
+)
+
+// Notify sends the state to the socket in NOTIFY_SOCKET and returns false if the socket is not set
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// a leading "@" denotes a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("unable to connect to systemd notify socket: %v", err)
+	}
+	//noinspection GoUnhandledErrorResult
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		return false, fmt.Errorf("unable to send %s to systemd: %v", state, err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout of systemd from WATCHDOG_USEC,
+// or 0 if the watchdog is not enabled for this process
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+
+	// the watchdog may be meant for another process, e.g. the parent of the client
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	s, err := strconv.Atoi(usec)
+	if err != nil || s <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC: %s", usec)
+	}
+	return time.Duration(s) * time.Microsecond, nil
+}
+
+// StartWatchdog pings the watchdog of systemd at half the watchdog timeout until the context is canceled.
+// It does nothing if the watchdog is not enabled.
+func StartWatchdog(ctx context.Context) {
+	timeout, err := WatchdogInterval()
+	if err != nil {
+		log.Warnf("systemd watchdog disabled: %v", err)
+		return
+	}
+	if timeout == 0 || os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+
+	log.Debugf("pinging systemd watchdog every %s", timeout/2)
+
+	go func() {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := Notify(Watchdog); err != nil {
+					log.Warn(err)
+				}
+			}
+		}
+	}()
+}
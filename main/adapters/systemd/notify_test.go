@@ -0,0 +1,113 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listen creates a notify socket like systemd and sets NOTIFY_SOCKET
+func listen(t *testing.T) *net.UnixConn {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unix datagram sockets are not supported: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	t.Cleanup(func() { os.Unsetenv("NOTIFY_SOCKET") })
+
+	return conn
+}
+
+func receive(t *testing.T, conn *net.UnixConn, timeout time.Duration) string {
+	err := conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no notification received: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	conn := listen(t)
+
+	sent, err := Notify(Ready)
+	if err != nil || !sent {
+		t.Fatalf("notification was not sent: %v", err)
+	}
+
+	if state := receive(t, conn, time.Second); state != Ready {
+		t.Errorf("unexpected state: expected %s, got %s", Ready, state)
+	}
+}
+
+func TestNotify_NoSocket(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+
+	sent, err := Notify(Ready)
+	if err != nil || sent {
+		t.Errorf("notification without NOTIFY_SOCKET was not a no-op: %v", err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name        string
+		usec        string
+		pid         string
+		expected    time.Duration
+		expectedErr bool
+	}{
+		{name: "disabled"},
+		{name: "enabled", usec: "30000000", expected: 30 * time.Second},
+		{name: "enabled for this process", usec: "1000", pid: strconv.Itoa(os.Getpid()), expected: time.Millisecond},
+		{name: "enabled for other process", usec: "1000", pid: "1"},
+		{name: "invalid", usec: "soon", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Setenv("WATCHDOG_USEC", test.usec)
+			defer os.Unsetenv("WATCHDOG_USEC")
+			os.Setenv("WATCHDOG_PID", test.pid)
+			defer os.Unsetenv("WATCHDOG_PID")
+
+			interval, err := WatchdogInterval()
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if interval != test.expected {
+				t.Errorf("unexpected interval: expected %s, got %s", test.expected, interval)
+			}
+		})
+	}
+}
+
+func TestStartWatchdog(t *testing.T) {
+	conn := listen(t)
+
+	os.Setenv("WATCHDOG_USEC", "20000") // 20ms
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartWatchdog(ctx)
+
+	for i := 0; i < 2; i++ {
+		if state := receive(t, conn, time.Second); state != Watchdog {
+			t.Errorf("unexpected state: expected %s, got %s", Watchdog, state)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package udp
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+	prom "github.com/ubirch/ubirch-client-go/main/prometheus"
+)
+
+const (
+	maxPacketSize    = 2048
+	DefaultWorkers   = 10   // number of go routines which handle the received packets
+	DefaultQueueSize = 1000 // maximum number of received packets which wait for a worker
+)
+
+// PacketHandler handles a received datagram and returns the reply, which is sent back to the
+// source address. If the packet is malformed or unauthorized, an error is returned and no reply is sent.
+type PacketHandler interface {
+	HandlePacket(packet []byte) (reply []byte, err error)
+}
+
+type UDPServer struct {
+	Addr      string
+	Handler   PacketHandler
+	Workers   int // number of workers which handle the packets, defaults to DefaultWorkers
+	QueueSize int // maximum number of packets which wait for a worker, defaults to DefaultQueueSize
+}
+
+type udpPacket struct {
+	addr net.Addr
+	data []byte
+}
+
+// Serve listens for datagrams on the UDP address of the server and passes them to the packet
+// handler until the context is cancelled. The packets are handled by a fixed number of workers.
+// If all workers are busy and the queue is full, further packets are dropped, so that a flood
+// of packets can not exhaust the resources of the client.
+func (srv *UDPServer) Serve(cancelCtx context.Context) error {
+	conn, err := net.ListenPacket("udp", srv.Addr)
+	if err != nil {
+		return fmt.Errorf("error starting UDP server: %v", err)
+	}
+
+	go func() {
+		<-cancelCtx.Done()
+		if err := conn.Close(); err != nil {
+			log.Warnf("could not close UDP connection: %s", err)
+		} else {
+			log.Debug("shut down UDP server")
+		}
+	}()
+
+	workers := srv.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	queueSize := srv.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+
+	queue := make(chan udpPacket, queueSize)
+	defer close(queue)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for p := range queue {
+				srv.handle(conn, p.addr, p.data)
+			}
+		}()
+	}
+
+	log.Infof("starting UDP server on %s", conn.LocalAddr())
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if cancelCtx.Err() != nil {
+				return nil
+			}
+			log.Warnf("UDP read failed: %v", err)
+			continue
+		}
+
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+
+		select {
+		case queue <- udpPacket{addr: addr, data: packet}:
+		default:
+			prom.UDPDroppedPackets.Inc()
+			log.Debugf("dropped UDP packet from %s: queue is full", addr)
+		}
+	}
+}
+
+func (srv *UDPServer) handle(conn net.PacketConn, addr net.Addr, packet []byte) {
+	reply, err := srv.Handler.HandlePacket(packet)
+	if err != nil {
+		prom.UDPDroppedPackets.Inc()
+		log.Warnf("dropped UDP packet from %s: %v", addr, err)
+		return
+	}
+
+	_, err = conn.WriteTo(reply, addr)
+	if err != nil {
+		log.Errorf("unable to send UDP reply to %s: %v", addr, err)
+	}
+}
@@ -0,0 +1,134 @@
+package udp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type echoHandler struct{}
+
+func (echoHandler) HandlePacket(packet []byte) ([]byte, error) {
+	if bytes.Equal(packet, []byte("malformed")) {
+		return nil, fmt.Errorf("malformed packet")
+	}
+	return packet, nil
+}
+
+// blockingHandler counts the packets and blocks until release is closed
+type blockingHandler struct {
+	handled int32
+	release chan struct{}
+}
+
+func (b *blockingHandler) HandlePacket(packet []byte) ([]byte, error) {
+	atomic.AddInt32(&b.handled, 1)
+	<-b.release
+	return packet, nil
+}
+
+func freeUDPAddr(t *testing.T) string {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.LocalAddr().String()
+}
+
+func TestUDPServer(t *testing.T) {
+	addr := freeUDPAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := UDPServer{Addr: addr, Handler: echoHandler{}}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// malformed packets are dropped without reply and don't stop the reader loop
+	for _, packet := range [][]byte{[]byte("malformed"), []byte("hello")} {
+		_, err = conn.Write(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	reply := make([]byte, maxPacketSize)
+	n, err := conn.Read(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply[:n]) != "hello" {
+		t.Errorf("unexpected reply: %q", reply[:n])
+	}
+
+	cancel()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("UDP server did not shut down after context was cancelled")
+	}
+}
+
+func TestUDPServer_Overflow(t *testing.T) {
+	addr := freeUDPAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := &blockingHandler{release: make(chan struct{})}
+	srv := UDPServer{Addr: addr, Handler: handler, Workers: 1, QueueSize: 1}
+	go func() {
+		_ = srv.Serve(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the worker blocks on the first packet and the queue holds one more, further packets are dropped
+	for i := 0; i < 10; i++ {
+		if _, err = conn.Write([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(handler.release)
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	reply := make([]byte, maxPacketSize)
+	replies := 0
+	for {
+		if _, err = conn.Read(reply); err != nil {
+			break
+		}
+		replies++
+	}
+
+	if handled := atomic.LoadInt32(&handler.handled); handled < 1 || handled > 2 {
+		t.Errorf("unexpected number of handled packets: %d", handled)
+	}
+	if replies != int(atomic.LoadInt32(&handler.handled)) {
+		t.Errorf("unexpected number of replies: %d", replies)
+	}
+}
@@ -0,0 +1,262 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/asyncqueue"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultBufferSize   = 1000
+	DefaultDrainTimeout = 5 * time.Second // time to deliver the buffered messages on shutdown
+	DefaultRetryBackoff = time.Second
+
+	workers         = 4                // number of concurrent deliveries
+	anchorWorkers   = 32               // number of concurrent deliveries, if the blockchain anchors are awaited
+	requestTimeout  = 15 * time.Second // timeout of a webhook request
+	maxResponseSize = 4 << 10          // maximum size of the response body which is logged
+)
+
+// Fields are the names of the fields of a message
+var Fields = []string{"timestamp", "uuid", "operation", "hash", "upp", "requestID", "backendStatus", "verification"}
+
+// Message is delivered for each UPP which was acknowledged by the UBIRCH backend.
+// It must never contain secrets like auth tokens.
+type Message struct {
+	Timestamp     time.Time       `json:"timestamp"`
+	UUID          uuid.UUID       `json:"uuid"`
+	Operation     string          `json:"operation"`
+	Hash          []byte          `json:"hash"`
+	UPP           []byte          `json:"upp"`
+	RequestID     string          `json:"requestID,omitempty"`
+	BackendStatus int             `json:"backendStatus"`
+	Verification  json.RawMessage `json:"verification,omitempty"` // the blockchain anchors of the UPP, only if the anchors are awaited
+}
+
+// AnchorWaiter waits until the UPP with the given hash was anchored in a public blockchain
+// and returns the verification of the UPP with its blockchain anchors
+type AnchorWaiter func(ctx context.Context, hash []byte) (json.RawMessage, error)
+
+type Params struct {
+	URL          string            // URL to post the messages to
+	Header       map[string]string // additional headers of the requests, e.g. for authentication
+	Fields       []string          // fields of the messages, defaults to all fields
+	Retries      int               // number of retries of failed deliveries
+	RetryBackoff time.Duration     // wait time before the first retry, doubled with each further retry, defaults to 1s
+	BufferSize   int               // maximum number of buffered messages, defaults to 1000
+	AwaitAnchors AnchorWaiter      // if set, messages are delivered after the UPP was anchored
+}
+
+// Sink posts messages as JSON to a webhook URL. Messages are delivered asynchronously, so that signing is
+// never blocked by a slow or unreachable webhook. Failed deliveries are retried with exponential backoff,
+// if the request failed with a transport error or the webhook responded with 429 or 5xx.
+//
+// Messages are dropped and counted if the buffer is full, if their delivery failed permanently, or if they
+// are still buffered when the drain timeout elapses on shutdown.
+type Sink struct {
+	params Params
+	fields map[string]bool // selected fields, nil if all fields are selected
+	client *http.Client
+	queue  *asyncqueue.Queue
+}
+
+func NewSink(p Params) (*Sink, error) {
+	u, err := url.Parse(p.URL)
+	if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid webhook URL: %q", p.URL)
+	}
+
+	var fields map[string]bool
+	if len(p.Fields) > 0 {
+		fields = make(map[string]bool, len(p.Fields))
+		for _, f := range p.Fields {
+			if !isField(f) {
+				return nil, fmt.Errorf("unknown webhook field: \"%s\", expected one of %q", f, Fields)
+			}
+			fields[f] = true
+		}
+	}
+
+	if p.Retries < 0 {
+		return nil, fmt.Errorf("number of webhook retries must not be negative (is %d)", p.Retries)
+	}
+	if p.RetryBackoff <= 0 {
+		p.RetryBackoff = DefaultRetryBackoff
+	}
+	if p.BufferSize <= 0 {
+		p.BufferSize = DefaultBufferSize
+	}
+
+	s := &Sink{
+		params: p,
+		fields: fields,
+		client: &http.Client{Timeout: requestTimeout},
+	}
+	s.queue = asyncqueue.New(p.BufferSize, s.deliverAll)
+	return s, nil
+}
+
+func isField(name string) bool {
+	for _, f := range Fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish adds a message to the sink without waiting for it to be delivered.
+// It returns false if the buffer is full or the sink was closed and the message was dropped.
+func (s *Sink) Publish(m Message) bool {
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now().UTC()
+	}
+
+	return s.queue.Add(m)
+}
+
+// Dropped returns the number of messages which were dropped
+func (s *Sink) Dropped() uint64 {
+	return s.queue.Dropped()
+}
+
+// Close delivers the buffered messages until the drain timeout elapses and drops the remaining messages.
+// Messages which are published after the sink was closed are dropped.
+func (s *Sink) Close(drainTimeout time.Duration) {
+	s.queue.Close(drainTimeout)
+
+	if dropped := s.Dropped(); dropped > 0 {
+		log.Warnf("webhook: %d messages were dropped", dropped)
+	}
+}
+
+// deliverAll delivers the buffered messages with concurrent workers until the sink is closed
+func (s *Sink) deliverAll(ctx context.Context, messages <-chan interface{}) {
+	n := workers
+	if s.params.AwaitAnchors != nil {
+		n = anchorWorkers
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for item := range messages {
+				if ctx.Err() != nil {
+					// the drain timeout elapsed, the buffered messages are dropped
+					s.queue.Drop(1)
+					continue
+				}
+
+				m := item.(Message)
+				err := s.deliver(ctx, &m)
+				if err != nil {
+					log.Errorf("%s: webhook: delivery of UPP failed: %v", m.UUID, err)
+					s.queue.Drop(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// permanentError is returned if a delivery must not be retried
+type permanentError struct {
+	error
+}
+
+// deliver delivers the message, retrying with exponential backoff
+func (s *Sink) deliver(ctx context.Context, m *Message) error {
+	backoff := s.params.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := s.post(ctx, m)
+		if _, permanent := err.(permanentError); err == nil || permanent || attempt == s.params.Retries {
+			return err
+		}
+
+		log.Warnf("%s: webhook: delivery of UPP failed: %v, retrying in %s", m.UUID, err, backoff)
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// post waits for the blockchain anchors of the UPP, if enabled, and posts the message to the webhook URL
+func (s *Sink) post(ctx context.Context, m *Message) error {
+	if s.params.AwaitAnchors != nil && m.Verification == nil {
+		verification, err := s.params.AwaitAnchors(ctx, m.Hash)
+		if err != nil {
+			return fmt.Errorf("waiting for blockchain anchors failed: %v", err)
+		}
+		m.Verification = verification
+	}
+
+	body, err := s.encode(m)
+	if err != nil {
+		return permanentError{err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.params.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.params.Header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("(%d) %q", resp.StatusCode, respBody)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return permanentError{err}
+}
+
+// encode returns the JSON encoding of the selected fields of the message
+func (s *Sink) encode(m *Message) ([]byte, error) {
+	body, err := json.Marshal(m)
+	if err != nil || s.fields == nil {
+		return body, err
+	}
+
+	all := map[string]json.RawMessage{}
+	if err = json.Unmarshal(body, &all); err != nil {
+		return nil, err
+	}
+	selected := make(map[string]json.RawMessage, len(s.fields))
+	for f := range s.fields {
+		if v, found := all[f]; found {
+			selected[f] = v
+		}
+	}
+	return json.Marshal(selected)
+}
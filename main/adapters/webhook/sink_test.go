@@ -0,0 +1,215 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// webhookServer records the delivered messages and responds with the given status codes, then with 200
+type webhookServer struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	responses []int
+	requests  int
+	messages  []map[string]json.RawMessage
+}
+
+func newWebhookServer(t *testing.T, responses ...int) *webhookServer {
+	s := &webhookServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Error("header was not set")
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.requests++
+		if len(s.responses) > 0 {
+			code := s.responses[0]
+			s.responses = s.responses[1:]
+			w.WriteHeader(code)
+			return
+		}
+
+		var m map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			t.Error(err)
+		}
+		s.messages = append(s.messages, m)
+	}))
+	return s
+}
+
+func (s *webhookServer) state() (int, []map[string]json.RawMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests, s.messages
+}
+
+func newTestSink(t *testing.T, server *webhookServer, p Params) *Sink {
+	p.URL = server.URL
+	p.Header = map[string]string{"Authorization": "Bearer secret"}
+	p.RetryBackoff = time.Millisecond
+	sink, err := NewSink(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink
+}
+
+func TestSink(t *testing.T) {
+	server := newWebhookServer(t)
+	defer server.Close()
+
+	sink := newTestSink(t, server, Params{})
+	uid := uuid.New()
+	for i := 0; i < 10; i++ {
+		if !sink.Publish(Message{UUID: uid, Operation: "chain", Hash: []byte{byte(i)}, BackendStatus: 200}) {
+			t.Fatalf("message %d was dropped", i)
+		}
+	}
+	sink.Close(time.Second)
+
+	_, messages := server.state()
+	if len(messages) != 10 || sink.Dropped() != 0 {
+		t.Fatalf("unexpected number of delivered messages: %d, dropped: %d", len(messages), sink.Dropped())
+	}
+	for _, m := range messages {
+		for _, f := range []string{"timestamp", "uuid", "operation", "hash", "upp", "backendStatus"} {
+			if _, found := m[f]; !found {
+				t.Errorf("field %s is missing", f)
+			}
+		}
+	}
+
+	if sink.Publish(Message{UUID: uid}) || sink.Dropped() != 1 {
+		t.Error("message was accepted after the sink was closed")
+	}
+}
+
+func TestSink_Fields(t *testing.T) {
+	server := newWebhookServer(t)
+	defer server.Close()
+
+	sink := newTestSink(t, server, Params{Fields: []string{"uuid", "hash"}})
+	uid := uuid.New()
+	sink.Publish(Message{UUID: uid, Operation: "chain", Hash: []byte{1}, UPP: []byte{2}})
+	sink.Close(time.Second)
+
+	_, messages := server.state()
+	if len(messages) != 1 || len(messages[0]) != 2 || string(messages[0]["uuid"]) != fmt.Sprintf("%q", uid) {
+		t.Errorf("unexpected message: %s", messages)
+	}
+
+	if _, err := NewSink(Params{URL: server.URL, Fields: []string{"auth"}}); err == nil {
+		t.Error("unknown field was accepted")
+	}
+	if _, err := NewSink(Params{URL: "/webhook"}); err == nil {
+		t.Error("relative URL was accepted")
+	}
+}
+
+func TestSink_Retry(t *testing.T) {
+	// 5xx and 429 responses are retried
+	server := newWebhookServer(t, http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	sink := newTestSink(t, server, Params{Retries: 3})
+	sink.Publish(Message{UUID: uuid.New()})
+	sink.Close(time.Second)
+
+	requests, messages := server.state()
+	if requests != 4 || len(messages) != 1 || sink.Dropped() != 0 {
+		t.Errorf("message was not delivered after retries: %d requests, dropped %d", requests, sink.Dropped())
+	}
+
+	// the message is dropped if all retries fail
+	server = newWebhookServer(t, http.StatusBadGateway, http.StatusBadGateway)
+	defer server.Close()
+
+	sink = newTestSink(t, server, Params{Retries: 1})
+	sink.Publish(Message{UUID: uuid.New()})
+	sink.Close(time.Second)
+
+	if requests, messages = server.state(); requests != 2 || len(messages) != 0 || sink.Dropped() != 1 {
+		t.Errorf("unexpected result: %d requests, dropped %d", requests, sink.Dropped())
+	}
+
+	// other 4xx responses are not retried
+	server = newWebhookServer(t, http.StatusBadRequest)
+	defer server.Close()
+
+	sink = newTestSink(t, server, Params{Retries: 3})
+	sink.Publish(Message{UUID: uuid.New()})
+	sink.Close(time.Second)
+
+	if requests, _ = server.state(); requests != 1 || sink.Dropped() != 1 {
+		t.Errorf("client error was retried: %d requests", requests)
+	}
+}
+
+func TestSink_AwaitAnchors(t *testing.T) {
+	server := newWebhookServer(t)
+	defer server.Close()
+
+	var calls int
+	sink := newTestSink(t, server, Params{
+		Retries: 1,
+		AwaitAnchors: func(ctx context.Context, hash []byte) (json.RawMessage, error) {
+			if calls++; calls == 1 {
+				return nil, fmt.Errorf("not anchored yet")
+			}
+			return json.RawMessage(`{"anchors":[]}`), nil
+		},
+	})
+	sink.Publish(Message{UUID: uuid.New(), Hash: []byte{1}})
+	sink.Close(time.Second)
+
+	_, messages := server.state()
+	if len(messages) != 1 || string(messages[0]["verification"]) != `{"anchors":[]}` {
+		t.Errorf("message was not delivered with anchors: %s", messages)
+	}
+}
+
+func TestSink_BoundedLoss(t *testing.T) {
+	// a webhook which does not respond
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(block)
+
+	sink, err := NewSink(Params{URL: server.URL, BufferSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		sink.Publish(Message{UUID: uuid.New()})
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("publishing was blocked by the webhook")
+	}
+
+	sink.Close(50 * time.Millisecond)
+	if sink.Dropped() != 20 {
+		t.Errorf("unexpected number of dropped messages: %d", sink.Dropped())
+	}
+}
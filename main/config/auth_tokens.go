@@ -0,0 +1,70 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// AuthTokens are the accepted auth tokens of a device. In the configuration, the auth tokens of a
+// device are either a single string or a list of strings, e.g. the new and the old token during the
+// rotation of the auth token. The first token is stored as the auth token of the identity.
+type AuthTokens []string
+
+// Primary returns the first auth token, which is stored as the auth token of the identity
+func (a AuthTokens) Primary() string {
+	if len(a) == 0 {
+		return ""
+	}
+	return a[0]
+}
+
+// Additional returns the auth tokens which are accepted in addition to the primary auth token
+func (a AuthTokens) Additional() []string {
+	if len(a) < 2 {
+		return nil
+	}
+	return a[1:]
+}
+
+func (a AuthTokens) Equal(other AuthTokens) bool {
+	if len(a) != len(other) {
+		return false
+	}
+	for i := range a {
+		if a[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// UnmarshalJSON accepts a single auth token as string for backward compatibility, or a list of auth tokens
+func (a *AuthTokens) UnmarshalJSON(data []byte) error {
+	var token string
+	if err := json.Unmarshal(data, &token); err == nil {
+		*a = AuthTokens{token}
+		return nil
+	}
+
+	var tokens []string
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return err
+	}
+	*a = tokens
+	return nil
+}
+
+// MarshalJSON writes a single auth token as string, so that the configuration stays readable for older versions
+func (a AuthTokens) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// Decode reads the auth tokens from an environment variable, multiple auth tokens are separated by ";",
+// since "," and ":" already separate the devices and their UUIDs in UBIRCH_DEVICES
+func (a *AuthTokens) Decode(value string) error {
+	*a = strings.Split(value, ";")
+	return nil
+}
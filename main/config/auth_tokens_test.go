@@ -0,0 +1,56 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+func TestAuthTokens_JSON(t *testing.T) {
+	var c Config
+	err := json.Unmarshal([]byte(`{"devices": {"single": "auth", "list": ["new-auth", "old-auth"]}}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Devices["single"].Equal(AuthTokens{"auth"}) {
+		t.Errorf("unexpected auth tokens from string: %v", c.Devices["single"])
+	}
+	if !c.Devices["list"].Equal(AuthTokens{"new-auth", "old-auth"}) {
+		t.Errorf("unexpected auth tokens from list: %v", c.Devices["list"])
+	}
+	if c.Devices["list"].Primary() != "new-auth" || len(c.Devices["list"].Additional()) != 1 {
+		t.Errorf("unexpected primary and additional auth tokens: %v", c.Devices["list"])
+	}
+
+	data, err := json.Marshal(c.Devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"list":["new-auth","old-auth"],"single":"auth"}` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+}
+
+func TestAuthTokens_Env(t *testing.T) {
+	err := os.Setenv("TEST_UBIRCH_DEVICES", "single:auth,list:new-auth;old-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("TEST_UBIRCH_DEVICES")
+
+	var c Config
+	err = envconfig.Process("test_ubirch", &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Devices["single"].Equal(AuthTokens{"auth"}) {
+		t.Errorf("unexpected auth tokens: %v", c.Devices["single"])
+	}
+	if !c.Devices["list"].Equal(AuthTokens{"new-auth", "old-auth"}) {
+		t.Errorf("unexpected auth tokens: %v", c.Devices["list"])
+	}
+}
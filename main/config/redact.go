@@ -0,0 +1,115 @@
+package config
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	redacted = "***"
+
+	// values which are shorter than this are not redacted, since masking them
+	// would mangle the log without protecting anything worth protecting
+	minRedactLength = 4
+)
+
+// patterns of sensitive values in log output, the first group is kept
+var sensitivePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?s)(-----BEGIN [A-Z ]*PRIVATE KEY-----).*?-----END [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`(?i)(X-(?:Auth|Admin|Vault)-Token["']?\s*[:=]\s*\[?["']?)[^\s"'\],}]+`),
+	regexp.MustCompile(`(?i)(Authorization["']?\s*[:=]\s*\[?["']?(?:[a-z]+\s+)?)[^\s"'\],}]+`),
+	regexp.MustCompile(`(?i)("(?:secret|secret32|registerAuth|adminToken|vaultToken|PKCS11PIN|kafkaPassword|password)"\s*:\s*")[^"]*`),
+	regexp.MustCompile(`(?i)(password=)[^\s&]+`),
+}
+
+var (
+	logRedactor    = &RedactHook{}
+	addLogRedactor sync.Once
+)
+
+// RedactHook is a logrus hook which replaces secrets, auth tokens and private keys
+// in the message and the string fields of log entries with "***"
+type RedactHook struct {
+	secrets []string
+	mutex   sync.RWMutex
+}
+
+// SetSecrets sets the known secret values which are redacted in addition to the sensitive patterns
+func (h *RedactHook) SetSecrets(secrets ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.secrets = h.secrets[:0]
+	for _, s := range secrets {
+		if len(s) >= minRedactLength {
+			h.secrets = append(h.secrets, s)
+		}
+	}
+}
+
+// Redact returns the string with all known secrets and sensitive patterns replaced by "***"
+func (h *RedactHook) Redact(s string) string {
+	h.mutex.RLock()
+	for _, secret := range h.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	h.mutex.RUnlock()
+
+	for _, p := range sensitivePatterns {
+		s = p.ReplaceAllString(s, "${1}"+redacted)
+	}
+	return s
+}
+
+func (h *RedactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *RedactHook) Fire(entry *log.Entry) error {
+	entry.Message = h.Redact(entry.Message)
+
+	for k, v := range entry.Data {
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case error:
+			s = v.Error()
+		default:
+			continue
+		}
+		if r := h.Redact(s); r != s {
+			entry.Data[k] = r
+		}
+	}
+	return nil
+}
+
+// setUpLogRedaction adds the redact hook to the logger, if it was not added yet,
+// and sets the secrets and auth tokens of the configuration as values to redact
+func (c *Config) setUpLogRedaction() {
+	addLogRedactor.Do(func() { log.AddHook(logRedactor) })
+
+	secrets := []string{c.Secret16Base64, c.Secret32Base64, c.RegisterAuth, c.AdminToken, c.VaultToken, c.PKCS11PIN, c.KafkaPassword}
+	for _, auth := range c.Devices {
+		secrets = append(secrets, auth...)
+	}
+	for _, secret := range c.BodySecrets {
+		secrets = append(secrets, secret)
+	}
+	for _, header := range c.WebhookHeaders {
+		secrets = append(secrets, header)
+	}
+	for _, rawURL := range []string{c.PostgresDSN, c.BackendProxy, c.MQTTBroker} {
+		if u, err := url.Parse(rawURL); err == nil && u.User != nil {
+			if password, ok := u.User.Password(); ok {
+				secrets = append(secrets, password)
+			}
+		}
+	}
+	logRedactor.SetSecrets(secrets...)
+}
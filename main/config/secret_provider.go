@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// secretProvider provides a base64 encoded key store secret from one of the supported sources:
+// inline in the config, from a file, from an environment variable or from HashiCorp Vault
+type secretProvider interface {
+	Secret() (string, error)
+}
+
+// newVaultSecretProvider creates the provider for the secret in Vault, it is replaced in tests
+var newVaultSecretProvider = func(c *Config) secretProvider {
+	return newVaultSecret(c.VaultAddr, c.VaultToken, c.VaultRole, c.VaultSecretPath, vaultSecretKey)
+}
+
+// inlineSecret is a secret which is set in the config itself
+type inlineSecret string
+
+func (s inlineSecret) Secret() (string, error) {
+	return string(s), nil
+}
+
+// envSecret is a secret which is referenced in the config by the name of an environment
+// variable, e.g. "secret32": "${UBIRCH_SECRET32}"
+type envSecret string
+
+func (s envSecret) Secret() (string, error) {
+	value, found := os.LookupEnv(string(s))
+	if !found {
+		return "", fmt.Errorf("environment variable %s is not set", string(s))
+	}
+	return value, nil
+}
+
+// fileSecret is a secret which is read from a file
+type fileSecret string
+
+func (s fileSecret) Secret() (string, error) {
+	data, err := ioutil.ReadFile(string(s))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// resolveSecrets loads the key store secrets from their sources, so that the secrets
+// do not have to be stored in the config file itself
+func (c *Config) resolveSecrets() error {
+	err := resolveSecret(&c.Secret16Base64, secretSource(c.Secret16Base64, c.Secret16File, nil), "secret")
+	if err != nil {
+		return err
+	}
+
+	var vault secretProvider
+	if c.VaultSecretPath != "" {
+		vault = newVaultSecretProvider(c)
+	}
+	return resolveSecret(&c.Secret32Base64, secretSource(c.Secret32Base64, c.Secret32File, vault), "secret32")
+}
+
+// secretSource returns the providers of the configured sources of a secret
+func secretSource(secret, secretFile string, vault secretProvider) []secretProvider {
+	var providers []secretProvider
+	if strings.HasPrefix(secret, "${") && strings.HasSuffix(secret, "}") {
+		providers = append(providers, envSecret(strings.TrimSuffix(strings.TrimPrefix(secret, "${"), "}")))
+	} else if secret != "" {
+		providers = append(providers, inlineSecret(secret))
+	}
+	if secretFile != "" {
+		providers = append(providers, fileSecret(secretFile))
+	}
+	if vault != nil {
+		providers = append(providers, vault)
+	}
+	return providers
+}
+
+func resolveSecret(secret *string, providers []secretProvider, key string) error {
+	if len(providers) == 0 {
+		return nil
+	}
+	if len(providers) > 1 {
+		return fmt.Errorf("only one source of the secret ('%s', '%sFile' or Vault) must be set", key, key)
+	}
+
+	value, err := providers[0].Secret()
+	if err != nil {
+		return fmt.Errorf("unable to load secret ('%s'): %v", key, err)
+	}
+	*secret = value
+	return nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+)
+
+type mockSecretProvider struct {
+	secret string
+	err    error
+	calls  int
+}
+
+func (m *mockSecretProvider) Secret() (string, error) {
+	m.calls++
+	return m.secret, m.err
+}
+
+func TestConfig_SecretProvider(t *testing.T) {
+	tests := []struct {
+		name           string
+		conf           Config
+		provider       *mockSecretProvider
+		expectErr      bool
+		expectedSecret string
+		expectCalled   bool
+	}{
+		{
+			name:           "secret from Vault",
+			conf:           Config{VaultSecretPath: "secret/data/ubirch-client"},
+			provider:       &mockSecretProvider{secret: testSecret32},
+			expectedSecret: testSecret32,
+			expectCalled:   true,
+		},
+		{
+			name:         "Vault failure",
+			conf:         Config{VaultSecretPath: "secret/data/ubirch-client"},
+			provider:     &mockSecretProvider{err: fmt.Errorf("Vault is unreachable")},
+			expectErr:    true,
+			expectCalled: true,
+		},
+		{
+			name:      "inline secret and Vault",
+			conf:      Config{Secret32Base64: testSecret32, VaultSecretPath: "secret/data/ubirch-client"},
+			provider:  &mockSecretProvider{secret: testSecret32},
+			expectErr: true,
+		},
+		{
+			name:           "Vault not configured",
+			conf:           Config{Secret32Base64: testSecret32},
+			provider:       &mockSecretProvider{secret: "not used"},
+			expectedSecret: testSecret32,
+		},
+	}
+
+	defer func(f func(*Config) secretProvider) { newVaultSecretProvider = f }(newVaultSecretProvider)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newVaultSecretProvider = func(*Config) secretProvider { return test.provider }
+
+			err := test.conf.resolveSecrets()
+			if test.expectErr {
+				if err == nil {
+					t.Error("no error")
+				}
+			} else if err != nil {
+				t.Fatalf("resolving secret failed: %v", err)
+			} else if test.conf.Secret32Base64 != test.expectedSecret {
+				t.Errorf("unexpected secret: %q", test.conf.Secret32Base64)
+			}
+
+			if called := test.provider.calls > 0; called != test.expectCalled {
+				t.Errorf("unexpected call of the Vault secret provider: expected %v, got %v", test.expectCalled, called)
+			}
+		})
+	}
+}
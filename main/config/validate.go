@@ -0,0 +1,81 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// Validate checks the parts of the loaded configuration which are otherwise only checked when they
+// are used, e.g. the TLS files when the HTTPS server is started, and returns all problems found
+func (c *Config) Validate() []error {
+	var errs []error
+
+	if c.PostgresDSN == "" {
+		errs = append(errs, fmt.Errorf("data source name of the database ('postgresDSN') wasn't set"))
+	}
+
+	for name, auth := range c.Devices {
+		if _, err := uuid.Parse(name); err != nil {
+			errs = append(errs, fmt.Errorf("invalid device name (\"%s\") in 'devices' (not a UUID): %v", name, err))
+		}
+		missing := len(auth) == 0
+		for _, token := range auth {
+			missing = missing || len(token) == 0
+		}
+		if missing {
+			errs = append(errs, fmt.Errorf("missing auth token for device %s in 'devices'", name))
+		}
+	}
+
+	if c.TLS {
+		if !c.TLS_ACME {
+			if err := checkTLSFiles(c.TLS_CertFile, c.TLS_KeyFile); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if c.TLS_ClientCA != "" {
+			if err := checkClientCA(c.TLS_ClientCA); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if c.RequestLogFile != "" {
+		if _, err := os.Stat(filepath.Dir(c.RequestLogFile)); err != nil {
+			errs = append(errs, fmt.Errorf("directory of request log file ('requestLogFile') not accessible: %v", err))
+		}
+	}
+
+	return errs
+}
+
+func checkTLSFiles(certFile, keyFile string) error {
+	for _, file := range []string{certFile, keyFile} {
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("TLS enabled, but unable to access file: %v", err)
+		}
+	}
+
+	_, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("invalid TLS certificate ('TLSCertFile') or key ('TLSKeyFile'): %v", err)
+	}
+	return nil
+}
+
+func checkClientCA(file string) error {
+	caPEM, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("unable to read client CA file ('TLSClientCA'): %v", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no PEM encoded certificates found in client CA file ('TLSClientCA'): %s", file)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	vaultSecretKey           = "secret32"                                            // key of the secret in the Vault secret
+	vaultKubernetesLoginPath = "auth/kubernetes/login"                               // path of the login of the Kubernetes auth method
+	vaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token" // token of the Kubernetes service account
+	vaultTimeout             = 10 * time.Second
+)
+
+// vaultSecret is a secret which is read from the KV secrets engine (version 1 or 2) of HashiCorp Vault.
+// The client authenticates with a token or, if a role is set, with the Kubernetes auth method.
+type vaultSecret struct {
+	addr      string
+	token     string
+	role      string
+	path      string
+	key       string
+	tokenFile string
+	client    *http.Client
+}
+
+func newVaultSecret(addr, token, role, path, key string) *vaultSecret {
+	return &vaultSecret{
+		addr:      strings.TrimSuffix(addr, "/"),
+		token:     token,
+		role:      role,
+		path:      strings.Trim(path, "/"),
+		key:       key,
+		tokenFile: vaultKubernetesTokenFile,
+		client:    &http.Client{Timeout: vaultTimeout},
+	}
+}
+
+type vaultResponse struct {
+	Data map[string]interface{} `json:"data"`
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func (v *vaultSecret) Secret() (string, error) {
+	if v.addr == "" {
+		return "", fmt.Errorf("Vault address ('vaultAddr') is not set")
+	}
+
+	token := v.token
+	if token == "" {
+		if v.role == "" {
+			return "", fmt.Errorf("neither Vault token ('vaultToken') nor Vault role ('vaultRole') is set")
+		}
+
+		var err error
+		token, err = v.login()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	resp, err := v.request(http.MethodGet, v.path, token, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// the KV secrets engine version 2 wraps the secret data in another "data" object
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+
+	secret, ok := data[v.key].(string)
+	if !ok || secret == "" {
+		return "", fmt.Errorf("Vault secret %s does not contain key \"%s\"", v.path, v.key)
+	}
+	return secret, nil
+}
+
+// login logs in with the token of the Kubernetes service account and returns the Vault token
+func (v *vaultSecret) login() (string, error) {
+	jwt, err := ioutil.ReadFile(v.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read Kubernetes service account token: %v", err)
+	}
+
+	body, err := json.Marshal(map[string]string{"role": v.role, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := v.request(http.MethodPost, vaultKubernetesLoginPath, "", body)
+	if err != nil {
+		return "", err
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("Vault login with role %s returned no token", v.role)
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+func (v *vaultSecret) request(method, path, token string, body []byte) (*vaultResponse, error) {
+	url := fmt.Sprintf("%s/v1/%s", v.addr, path)
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	httpResp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Vault is unreachable: %v", err)
+	}
+	//noinspection GoUnhandledErrorResult
+	defer httpResp.Body.Close()
+
+	var resp vaultResponse
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault request %s %s failed: (%d) %s", method, url, httpResp.StatusCode, strings.Join(resp.Errors, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode Vault response: %v", err)
+	}
+	return &resp, nil
+}
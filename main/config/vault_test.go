@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testVaultToken = "test-vault-token"
+	testVaultJWT   = "test-service-account-token"
+)
+
+func newTestVault() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path == "/v1/"+vaultKubernetesLoginPath {
+			var login map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&login)
+			if login["role"] != "ubirch-client" || login["jwt"] != testVaultJWT {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"` + testVaultToken + `"}}`))
+			return
+		}
+
+		if r.Header.Get("X-Vault-Token") != testVaultToken {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/ubirch-client": // KV version 2
+			_, _ = w.Write([]byte(`{"data":{"data":{"secret32":"` + testSecret32 + `"},"metadata":{"version":1}}}`))
+		case "/v1/kv/ubirch-client": // KV version 1
+			_, _ = w.Write([]byte(`{"data":{"secret32":"` + testSecret32 + `"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+}
+
+func TestVaultSecret(t *testing.T) {
+	vault := newTestVault()
+	defer vault.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := ioutil.WriteFile(tokenFile, []byte(testVaultJWT+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		addr        string
+		token       string
+		role        string
+		path        string
+		expectedErr string
+	}{
+		{
+			name:  "KV version 2 with token",
+			addr:  vault.URL,
+			token: testVaultToken,
+			path:  "secret/data/ubirch-client",
+		},
+		{
+			name:  "KV version 1 with token",
+			addr:  vault.URL,
+			token: testVaultToken,
+			path:  "/kv/ubirch-client",
+		},
+		{
+			name: "Kubernetes login",
+			addr: vault.URL,
+			role: "ubirch-client",
+			path: "secret/data/ubirch-client",
+		},
+		{
+			name:        "Kubernetes login with unknown role",
+			addr:        vault.URL,
+			role:        "unknown",
+			path:        "secret/data/ubirch-client",
+			expectedErr: "permission denied",
+		},
+		{
+			name:        "invalid token",
+			addr:        vault.URL,
+			token:       "wrong",
+			path:        "secret/data/ubirch-client",
+			expectedErr: "(403)",
+		},
+		{
+			name:        "unknown path",
+			addr:        vault.URL,
+			token:       testVaultToken,
+			path:        "secret/data/unknown",
+			expectedErr: "(404)",
+		},
+		{
+			name:        "unreachable",
+			addr:        "http://127.0.0.1:1",
+			token:       testVaultToken,
+			path:        "secret/data/ubirch-client",
+			expectedErr: "Vault is unreachable",
+		},
+		{
+			name:        "no credentials",
+			addr:        vault.URL,
+			path:        "secret/data/ubirch-client",
+			expectedErr: "neither Vault token",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := newVaultSecret(test.addr, test.token, test.role, test.path, vaultSecretKey)
+			v.tokenFile = tokenFile
+
+			secret, err := v.Secret()
+			if test.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+					t.Errorf("expected error containing %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if secret != testSecret32 {
+				t.Errorf("unexpected secret: %q", secret)
+			}
+		})
+	}
+}
+
+func TestVaultSecret_MissingKey(t *testing.T) {
+	vault := newTestVault()
+	defer vault.Close()
+
+	v := newVaultSecret(vault.URL, testVaultToken, "", "secret/data/ubirch-client", "secret16")
+	_, err := v.Secret()
+	if err == nil || !strings.Contains(err.Error(), `does not contain key "secret16"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
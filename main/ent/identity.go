@@ -7,3 +7,10 @@ type Identity struct {
 	Signature  []byte
 	AuthToken  string
 }
+
+// IdentityInfo contains the metadata of an identity which can be disclosed without revealing its secrets
+type IdentityInfo struct {
+	Uid          string `json:"uuid"`
+	HasPublicKey bool   `json:"hasPublicKey"`
+	HasSignature bool   `json:"hasSignature"` // false, if no UPP was chained yet
+}
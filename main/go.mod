@@ -3,16 +3,28 @@ module github.com/ubirch/ubirch-client-go/main
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/aws/aws-sdk-go-v2 v1.17.1
+	github.com/aws/aws-sdk-go-v2/config v1.18.3
+	github.com/aws/aws-sdk-go-v2/service/kms v1.18.18
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/fxamacker/cbor/v2 v2.4.0
 	github.com/go-chi/chi v1.5.4
 	github.com/go-chi/cors v1.2.0
-	github.com/google/uuid v1.2.0
+	github.com/google/uuid v1.3.0
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/lib/pq v1.10.1
+	github.com/miekg/pkcs11 v1.1.1
 	github.com/prometheus/client_golang v1.11.0
+	github.com/segmentio/kafka-go v0.4.35
 	github.com/sirupsen/logrus v1.8.1
-	github.com/stretchr/testify v1.7.0
+	github.com/stretchr/testify v1.8.0
 	github.com/ubirch/ubirch-protocol-go/ubirch/v2 v2.2.6-0.20210428143952-0a0718362749
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	github.com/youmark/pkcs8 v0.0.0-20201027041543-1326539a0a0a
-	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b // indirect
+	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.14.8
 )
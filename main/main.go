@@ -16,16 +16,32 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
 	"sync"
 	"syscall"
 
 	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/audit"
 	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
+	"github.com/ubirch/ubirch-client-go/main/adapters/deadletter"
 	"github.com/ubirch/ubirch-client-go/main/adapters/handlers"
+	"github.com/ubirch/ubirch-client-go/main/adapters/hsm"
+	"github.com/ubirch/ubirch-client-go/main/adapters/jobs"
+	"github.com/ubirch/ubirch-client-go/main/adapters/kafka"
+	"github.com/ubirch/ubirch-client-go/main/adapters/kms"
+	"github.com/ubirch/ubirch-client-go/main/adapters/mqtt"
+	"github.com/ubirch/ubirch-client-go/main/adapters/quota"
+	"github.com/ubirch/ubirch-client-go/main/adapters/recorder"
 	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-client-go/main/adapters/systemd"
+	"github.com/ubirch/ubirch-client-go/main/adapters/udp"
+	"github.com/ubirch/ubirch-client-go/main/adapters/webhook"
 	"github.com/ubirch/ubirch-client-go/main/config"
 	"github.com/ubirch/ubirch-client-go/main/uc"
 	"golang.org/x/sync/errgroup"
@@ -44,6 +60,11 @@ func shutdown(cancel context.CancelFunc) {
 	sig := <-signals
 	log.Infof("shutting down after receiving: %v", sig)
 
+	// let systemd know that the shutdown is intended, if the client runs as a service of Type=notify
+	if _, err := systemd.Notify(systemd.Stopping); err != nil {
+		log.Warn(err)
+	}
+
 	// cancel the go routines contexts
 	cancel()
 }
@@ -57,26 +78,55 @@ var (
 
 func main() {
 	const (
-		serviceName = "ubirch-client"
-		configFile  = "config.json"
-		MigrateArg  = "--migrate"
-		InitArg     = "--init-identities-conf"
+		serviceName        = "ubirch-client"
+		configFile         = "config.json"
+		jobsFileName       = "async_jobs.json"
+		deadLetterFileName = "dead_letters.json"
+		quotaFileName      = "quota_usage.json"
+		MigrateArg         = "--migrate"
+		MigrateFileArg     = "--migrate-file-to-db"
+		ForceArg           = "--force"
+		InitArg            = "--init-identities-conf"
+		ValidateArg        = "--validate"
+		PingBackendsArg    = "--ping-backends"
 	)
 
 	var (
 		configDir      string
 		migrate        bool
+		migrateFile    bool
+		force          bool
 		initIdentities bool
+		validate       bool
+		pingBackends   bool
 		serverID       = fmt.Sprintf("%s/%s", serviceName, Version)
 	)
 
+	// sign a file or hash from the command line without starting the client
+	if len(os.Args) > 1 && os.Args[1] == handlers.SignCommandName {
+		os.Exit(runSignCommand(os.Args[2:], configFile))
+	}
+
+	// verify a file or hash from the command line without starting the client
+	if len(os.Args) > 1 && os.Args[1] == handlers.VerifyCommandName {
+		os.Exit(runVerifyCommand(os.Args[2:], configFile))
+	}
+
 	if len(os.Args) > 1 {
 		for i, arg := range os.Args[1:] {
 			log.Infof("arg #%d: %s", i+1, arg)
 			if arg == MigrateArg {
 				migrate = true
+			} else if arg == MigrateFileArg {
+				migrateFile = true
+			} else if arg == ForceArg {
+				force = true
 			} else if arg == InitArg {
 				initIdentities = true
+			} else if arg == ValidateArg {
+				validate = true
+			} else if arg == PingBackendsArg {
+				pingBackends = true
 			} else {
 				configDir = arg
 			}
@@ -84,8 +134,17 @@ func main() {
 	}
 
 	log.SetFormatter(&log.JSONFormatter{})
+	log.AddHook(h.RequestIDLogHook{})
 	log.Printf("UBIRCH client (version=%s, revision=%s)", Version, Revision)
 
+	// check the configuration without starting the client
+	if validate {
+		if !validateConfig(os.Stdout, configDir, configFile, pingBackends) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// read configuration
 	conf := config.Config{}
 	err := conf.Load(configDir, configFile)
@@ -94,8 +153,9 @@ func main() {
 	}
 
 	globals := handlers.Globals{
-		Config:  conf,
-		Version: Version,
+		Config:   conf,
+		Version:  Version,
+		Revision: Revision,
 	}
 
 	// create a waitgroup that contains all asynchronous operations
@@ -108,15 +168,36 @@ func main() {
 
 	// set up HTTP server
 	httpServer := h.HTTPServer{
-		Router:   h.NewRouter(),
-		Addr:     conf.TCP_addr,
-		TLS:      conf.TLS,
-		CertFile: conf.TLS_CertFile,
-		KeyFile:  conf.TLS_KeyFile,
+		Router:            h.NewRouter(),
+		Addr:              conf.TCP_addr,
+		TLS:               conf.TLS,
+		CertFile:          conf.TLS_CertFile,
+		KeyFile:           conf.TLS_KeyFile,
+		ClientCAFile:      conf.TLS_ClientCA,
+		RequireClientCert: conf.TLS_RequireClientCert,
+		MinVersion:        conf.TLSMinVersionID,
+		CipherSuites:      conf.TLSCipherSuiteIDs,
+		ACME:              conf.TLS_ACME,
+		ACMEHosts:         conf.TLS_ACME_Hosts,
+		ACMECacheDir:      conf.TLS_ACME_CacheDir,
+		DrainDelay:        conf.ShutdownDrainDuration,
 	}
 	if conf.CORS && config.IsDevelopment { // never enable CORS on production stage
 		httpServer.SetUpCORS(conf.CORS_Origins, conf.Debug)
 	}
+	httpServer.SetUpMaxBodySize(conf.MaxBodySize)
+	if conf.StrictContentLength {
+		httpServer.SetUpContentLengthCheck()
+	}
+	if conf.ProblemJSON {
+		httpServer.SetUpProblemJSON()
+	}
+	if conf.SecurityHeaders {
+		httpServer.SetUpSecurityHeaders(conf.HSTSMaxAge)
+	}
+	if conf.DisableAccessLog {
+		h.SetAccessLog(false)
+	}
 
 	// start HTTP server
 	serverReadyCtx, serverReady := context.WithCancel(context.Background())
@@ -127,11 +208,27 @@ func main() {
 	<-serverReadyCtx.Done()
 
 	// set up metrics
-	prom.InitPromMetrics(httpServer.Router)
+	if !conf.DisableMetrics {
+		prom.InitPromMetrics(httpServer.Router)
+	}
+
+	// set up admin API, after all middlewares, since mounting it adds a route
+	httpServer.SetUpAdmin(conf.AdminToken)
+	if conf.Pprof {
+		httpServer.SetUpPprof(conf.AdminToken)
+	}
 
 	// set up endpoint for liveliness checks
 	httpServer.Router.Get("/healtz", h.Health(serverID))
 
+	// set up endpoint for build info
+	httpServer.Router.Get(h.InfoPath, h.Info(h.BuildInfo{
+		Version:   globals.Version,
+		Revision:  globals.Revision,
+		GoVersion: runtime.Version(),
+		Env:       globals.Config.Env,
+	}))
+
 	if migrate {
 		err := repository.Migrate(conf)
 		if err != nil {
@@ -140,23 +237,42 @@ func main() {
 		os.Exit(0)
 	}
 
+	if migrateFile {
+		report, err := repository.MigrateFileToDatabase(conf, force)
+		if err != nil {
+			log.Fatalf("migration failed: %v", err)
+		}
+		log.Infof("migration of file based context into database finished: %s", report)
+		if len(report.Failed) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// initialize ubirch protocol
 	ctxManager, err := repository.GetCtxManager(conf)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	client := &clients.Client{
-		AuthServiceURL:     conf.Niomon,
-		VerifyServiceURL:   conf.VerifyService,
-		KeyServiceURL:      conf.KeyService,
-		IdentityServiceURL: conf.IdentityService,
+	// the client is not ready while the database is unreachable
+	if db, ok := ctxManager.(repository.Pinger); ok {
+		go repository.MonitorHealth(ctx, db, conf.DBHealthCheckDuration, func(err error) {
+			h.SetDependencyHealth("database", err)
+		})
 	}
 
-	protocol, err := repository.NewExtendedProtocol(ctxManager, conf.SecretBytes32, client)
+	client, err := newClient(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	protocol, closeCrypto, err := newProtocol(ctx, conf, ctxManager, client)
 	if err != nil {
 		log.Fatal(err)
 	}
+	//noinspection GoUnhandledErrorResult
+	defer closeCrypto()
 
 	idHandler := &handlers.IdentityHandler{
 		Protocol:            protocol,
@@ -173,37 +289,297 @@ func main() {
 		os.Exit(0)
 	}
 
+	if !conf.DisableKeyVerification {
+		err = idHandler.VerifyKeys()
+		if err != nil {
+			log.Fatalf("key verification failed: %v", err)
+		}
+		log.Infof("successfully verified keys of stored identities")
+	}
+
+	err = idHandler.CheckChainStates()
+	if err != nil {
+		log.Error(err)
+	}
+
+	hashAlgorithms, err := getHashAlgorithms(conf.HashAlgorithms)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rateLimiter, err := getRateLimiter(conf.RateLimitPerUUID, conf.RateLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bodySecrets, err := getBodySecrets(conf.BodySecrets)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	canonicalization, err := h.GetCanonicalization(conf.Canonicalization)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	signer := handlers.Signer{
 		Protocol:             protocol,
 		AuthTokensBuffer:     map[uuid.UUID]string{},
 		AuthTokenBufferMutex: &sync.RWMutex{},
+		SlowBackendThreshold: conf.SlowBackendDuration,
+		BearerAuth:           conf.BearerAuth,
+		BackendRetries:       conf.BackendRetries,
+		BackendRetryBackoff:  conf.BackendRetryBackoffDuration,
+		HashAlgorithms:       hashAlgorithms,
+		Canonicalization:     canonicalization,
+		RateLimiter:          rateLimiter,
+		BodySecrets:          bodySecrets,
+	}
+
+	if conf.ReplayProtection {
+		signer.ReplayGuard = handlers.NewReplayGuard(conf.ReplayWindowDuration, handlers.DefaultMaxNonces)
+
+		if conf.UDP || conf.MQTTBroker != "" {
+			log.Warn("replay protection is only supported via HTTP, all requests via UDP and MQTT are rejected")
+		}
+	}
+
+	if conf.Idempotency {
+		signer.Idempotency = handlers.NewIdempotencyCache(conf.IdempotencyTTLDuration, handlers.DefaultIdempotencyEntries)
+	}
+
+	if conf.DedupWindowDuration > 0 {
+		signer.Dedup = handlers.NewIdempotencyCache(conf.DedupWindowDuration, handlers.DefaultIdempotencyEntries)
+	}
+
+	if conf.RequestLogFile != "" {
+		signer.Recorder, err = recorder.NewRequestRecorder(conf.RequestLogFile, conf.RequestLogMaxSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer signer.Recorder.Close()
+	}
+
+	if conf.AuditLog {
+		signer.Audit, err = audit.NewLog(conf.AuditLogFile, audit.DefaultBufferSize, audit.DefaultFlushInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer signer.Audit.Close()
+
+		// key rotations are recorded, so that the local chain can be verified with the key which was valid for each UPP
+		idHandler.Audit = signer.Audit
+
+		// set up admin endpoint to query the audit log
+		auditService := &handlers.AuditService{
+			Log: signer.Audit,
+		}
+		httpServer.Admin.Get(fmt.Sprintf("/%s", handlers.AuditPath), auditService.HandleRequest)
+	}
+
+	if len(conf.KafkaBrokers) > 0 {
+		producerConf := kafka.ProducerConfig{
+			Brokers:       conf.KafkaBrokers,
+			SASLMechanism: conf.KafkaSASLMechanism,
+			Username:      conf.KafkaUsername,
+			Password:      conf.KafkaPassword,
+		}
+		if conf.KafkaTLS {
+			producerConf.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		producer, err := kafka.NewBrokerProducer(producerConf)
+		if err != nil {
+			log.Fatalf("invalid Kafka configuration: %v", err)
+		}
+		signer.Kafka = kafka.NewSink(producer, conf.KafkaTopic, kafka.DefaultBufferSize)
+		defer signer.Kafka.Close(kafka.DefaultDrainTimeout)
 	}
 
 	verifier := handlers.Verifier{
 		Protocol:                      protocol,
-		VerifyFromKnownIdentitiesOnly: false, // TODO: make configurable
+		VerifyFromKnownIdentitiesOnly: conf.VerifyKnownOnly,
+		KeyCache:                      handlers.NewKeyCache(conf.VerifyKeyCacheSize, handlers.DefaultKeyCacheTTL),
+		AnchorPollInterval:            conf.VerifyAnchorPollDuration,
+		AnchorTimeout:                 conf.VerifyAnchorTimeoutDuration,
+		Canonicalization:              canonicalization,
 	}
 
+	if conf.WebhookURL != "" {
+		webhookParams := webhook.Params{
+			URL:          conf.WebhookURL,
+			Header:       conf.WebhookHeaders,
+			Fields:       conf.WebhookFields,
+			Retries:      conf.WebhookRetries,
+			RetryBackoff: conf.WebhookRetryBackoffDuration,
+			BufferSize:   webhook.DefaultBufferSize,
+		}
+		if conf.WebhookAwaitAnchors {
+			webhookParams.AwaitAnchors = verifier.AwaitAnchors
+		}
+		signer.Webhook, err = webhook.NewSink(webhookParams)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer signer.Webhook.Close(webhook.DefaultDrainTimeout)
+	}
+
+	if conf.DeadLetterQueue || conf.OfflineMode {
+		if conf.OfflineMode {
+			signer.Offline = handlers.NewOfflineMode()
+		}
+		signer.DeadLetters, err = deadletter.NewQueue(filepath.Join(conf.ConfigDir, deadLetterFileName))
+		if err != nil {
+			log.Fatal(err)
+		}
+		deadLetterService := handlers.NewDeadLetterService(&signer, conf.DeadLetterRetryDuration)
+		deadLetterService.Start(ctx)
+
+		// set up admin endpoints to manage undelivered UPPs
+		httpServer.Admin.Get(fmt.Sprintf("/%s", handlers.DeadLetterPath), deadLetterService.HandleList)
+		httpServer.Admin.Post(fmt.Sprintf("/%s/{%s}/retry", handlers.DeadLetterPath, handlers.DeadLetterIDKey), deadLetterService.HandleRetry)
+		httpServer.Admin.Delete(fmt.Sprintf("/%s/{%s}", handlers.DeadLetterPath, handlers.DeadLetterIDKey), deadLetterService.HandleDrop)
+	}
+
+	if conf.DailyQuota > 0 || len(conf.DailyQuotas) > 0 {
+		quotas, err := getDailyQuotas(conf.DailyQuota, conf.DailyQuotas)
+		if err != nil {
+			log.Fatal(err)
+		}
+		signer.Quota, err = quota.NewCounter(filepath.Join(conf.ConfigDir, quotaFileName), conf.DailyQuota, quotas,
+			quota.DefaultPersistInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer signer.Quota.Close()
+
+		// set up admin endpoint to query the daily usage
+		quotaService := &handlers.QuotaService{
+			Quota: signer.Quota,
+		}
+		httpServer.Admin.Get(fmt.Sprintf("/%s", handlers.QuotaPath), quotaService.HandleRequest)
+	}
+
+	// apply changes of the device map in the configuration on SIGHUP
+	configReloader := handlers.NewConfigReloader(conf, idHandler, &signer)
+	configReloader.Start(ctx, func() (config.Config, error) {
+		reloadedConf := config.Config{}
+		err := reloadedConf.Load(configDir, configFile)
+		return reloadedConf, err
+	})
+
 	// set up endpoint for identity registration
-	identity := createIdentityUseCases(globals.Config.RegisterAuth, idHandler)
+	identity := createIdentityUseCases(globals.Config.RegisterAuth, idHandler, &signer)
 	httpServer.Router.Put(fmt.Sprintf("/%s", h.RegisterEndpoint), identity.handler.Put(identity.storeIdentity, identity.checkIdentity))
 
+	// set up admin endpoint to list registered identities
+	httpServer.Admin.Get(fmt.Sprintf("/%s", h.RegisterEndpoint), identity.handler.List(identity.listIdentities))
+
+	// set up admin endpoint for identity deregistration
+	httpServer.Admin.Delete(fmt.Sprintf("/%s/{%s}", h.RegisterEndpoint, h.UUIDKey), identity.handler.Delete(identity.deleteIdentity, identity.checkIdentity))
+
 	// set up endpoint for chaining
+	var chainWorkers *handlers.ChainWorkers
+	if conf.MaxChainWorkers > 0 {
+		chainWorkers = handlers.NewChainWorkers(&signer, conf.MaxChainWorkers, conf.ChainQueueSize)
+	}
+
+	chainingService := &handlers.ChainingService{
+		Signer:  &signer,
+		Workers: chainWorkers,
+	}
+
 	httpServer.AddServiceEndpoint(h.ServerEndpoint{
-		Path: fmt.Sprintf("/{%s}", h.UUIDKey),
-		Service: &handlers.ChainingService{
-			Signer: &signer,
-		},
+		Path:    fmt.Sprintf("/{%s}", h.UUIDKey),
+		Service: chainingService,
 	})
 
 	// set up endpoint for signing
+	signingService := &handlers.SigningService{
+		Signer:  &signer,
+		Workers: chainWorkers,
+	}
+
+	if conf.AsyncSigning {
+		jobStore, err := jobs.NewStore(filepath.Join(conf.ConfigDir, jobsFileName))
+		if err != nil {
+			log.Fatal(err)
+		}
+		signingService.Async = handlers.NewAsyncSigner(&signer, jobStore, conf.AsyncQueueSize)
+		signingService.Async.DrainTimeout = conf.AsyncDrainDuration
+		signingService.Async.AllowedCallbackURLs = conf.AllowedCallbackURLs
+		signingService.Async.Start(ctx)
+
+		// process or suspend the queued jobs before the client exits
+		g.Go(func() error {
+			signingService.Async.Wait()
+			return nil
+		})
+
+		// set up endpoint for job status requests
+		httpServer.Router.Get(fmt.Sprintf("/%s/{%s}", handlers.JobsPath, handlers.JobIDKey), signingService.Async.HandleJobRequest)
+	}
+
 	httpServer.AddServiceEndpoint(h.ServerEndpoint{
-		Path: fmt.Sprintf("/{%s}/{%s}", h.UUIDKey, h.OperationKey),
-		Service: &handlers.SigningService{
-			Signer: &signer,
-		},
+		Path:    fmt.Sprintf("/{%s}/{%s}", h.UUIDKey, h.OperationKey),
+		Service: signingService,
 	})
 
+	// set up endpoint for batch signing
+	batchSigningService := &handlers.BatchSigningService{
+		Signer:       &signer,
+		MaxBatchSize: conf.MaxBatchSize,
+	}
+	httpServer.Router.Post(fmt.Sprintf("/{%s}/{%s}/%s", h.UUIDKey, h.OperationKey, h.BatchEndpoint), batchSigningService.HandleRequest)
+
+	// set up endpoint for public key requests
+	publicKeyService := &handlers.PublicKeyService{
+		Signer: &signer,
+	}
+	httpServer.Router.Get(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.KeyEndpoint), publicKeyService.HandleRequest)
+
+	// set up endpoint for CSR re-submission
+	csrService := &handlers.CSRService{
+		IdentityHandler: idHandler,
+		Signer:          &signer,
+		BearerAuth:      conf.BearerAuth,
+	}
+	httpServer.Router.Post(fmt.Sprintf("/{%s}/%s", h.UUIDKey, h.CSREndpoint), csrService.HandleRequest)
+
+	// set up admin endpoint for the import of externally generated keys
+	keyImportService := &handlers.KeyImportService{
+		IdentityHandler: idHandler,
+		Signer:          &signer,
+	}
+	httpServer.Admin.Post(fmt.Sprintf("/{%s}/%s/%s", h.UUIDKey, h.KeyEndpoint, h.ImportEndpoint), keyImportService.HandleRequest)
+
+	// set up admin endpoint for the generation of keys, whose public key is registered by the operator
+	keyGenerationService := &handlers.KeyGenerationService{
+		IdentityHandler: idHandler,
+		Signer:          &signer,
+	}
+	httpServer.Admin.Post(fmt.Sprintf("/{%s}/%s/%s", h.UUIDKey, h.KeyEndpoint, h.GenerateEndpoint), keyGenerationService.HandleRequest)
+
+	// set up admin endpoint for key rotation
+	keyRotationService := &handlers.KeyRotationService{
+		IdentityHandler: idHandler,
+	}
+	httpServer.Admin.Post(fmt.Sprintf("/{%s}/%s/%s", h.UUIDKey, h.KeyEndpoint, h.RotateEndpoint), keyRotationService.HandleRequest)
+
+	// set up admin endpoint for the verification of the local chain, the audit log is the local UPP history
+	chainVerificationService := &handlers.ChainVerificationService{
+		IdentityHandler: idHandler,
+	}
+	if signer.Audit != nil {
+		chainVerificationService.History = signer.Audit
+	}
+	httpServer.Admin.Get(fmt.Sprintf("/{%s}/%s/%s", h.UUIDKey, h.ChainEndpoint, h.VerifyPath), chainVerificationService.HandleRequest)
+
+	// set up admin endpoint to reset the chain of an identity
+	chainResetService := &handlers.ChainResetService{
+		Signer: &signer,
+	}
+	httpServer.Admin.Post(fmt.Sprintf("/{%s}/%s/%s", h.UUIDKey, h.ChainEndpoint, h.ResetPath), chainResetService.HandleRequest)
+
 	// set up endpoint for verification
 	httpServer.AddServiceEndpoint(h.ServerEndpoint{
 		Path: fmt.Sprintf("/%s", h.VerifyPath),
@@ -212,9 +588,75 @@ func main() {
 		},
 	})
 
+	// set up endpoint for verification with blockchain anchors
+	httpServer.AddServiceEndpoint(h.ServerEndpoint{
+		Path: fmt.Sprintf("/%s/%s", h.VerifyPath, h.AnchoredEndpoint),
+		Service: &handlers.AnchoredVerificationService{
+			Verifier: &verifier,
+		},
+	})
+
+	// set up endpoint for UPP inspection
+	httpServer.Router.Post(fmt.Sprintf("/%s", h.InspectPath), (&handlers.InspectionService{}).HandleRequest)
+
+	// start UDP server
+	if conf.UDP {
+		udpServer := udp.UDPServer{
+			Addr: conf.UDP_addr,
+			Handler: &handlers.UDPChainingService{
+				Signer:    &signer,
+				Workers:   chainWorkers,
+				RelayUPPs: conf.RelayUPPs,
+			},
+			Workers:   conf.UDPWorkers,
+			QueueSize: conf.UDPQueueSize,
+		}
+		g.Go(func() error {
+			return udpServer.Serve(ctx)
+		})
+	}
+
+	// start MQTT bridge
+	if conf.MQTTBroker != "" {
+		mqttClient, err := mqtt.NewBrokerClient(conf.MQTTBroker)
+		if err != nil {
+			log.Fatalf("invalid MQTT broker URL ('mqttBroker'): %v", err)
+		}
+		mqttBridge, err := mqtt.NewBridge(mqttClient, conf.MQTTTopic, conf.MQTTResponseTopic, &handlers.MQTTSigningService{
+			Signer:    &signer,
+			Workers:   chainWorkers,
+			RelayUPPs: conf.RelayUPPs,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		mqttBridge.Workers = conf.MQTTWorkers
+		mqttBridge.QueueSize = conf.MQTTQueueSize
+		g.Go(func() error {
+			return mqttBridge.Serve(ctx)
+		})
+	}
+
 	// set up endpoint for readiness checks
 	httpServer.Router.Get("/readiness", h.Health(serverID))
-	log.Info("ready")
+
+	uids, err := protocol.GetUIDs()
+	if err != nil {
+		log.Fatalf("could not load stored identities: %v", err)
+	}
+	if len(uids) > 0 {
+		h.SetReady()
+		log.Info("ready")
+	} else {
+		log.Warn("not ready: no identities registered yet")
+	}
+
+	// let systemd know that the client is up, if it runs as a service of Type=notify, and ping its watchdog.
+	// The client is started even without identities, since identities are registered via the HTTP server.
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		log.Warn(err)
+	}
+	systemd.StartWatchdog(ctx)
 
 	// wait for all go routines of the waitgroup to return
 	if err = g.Wait(); err != nil {
@@ -224,16 +666,191 @@ func main() {
 	log.Debug("shut down client")
 }
 
+// newClient returns the client for requests to the ubirch backend
+func newClient(conf config.Config) (*clients.Client, error) {
+	client := &clients.Client{
+		AuthServiceURL:        conf.Niomon,
+		VerifyServiceURL:      conf.VerifyService,
+		KeyServiceURL:         conf.KeyService,
+		IdentityServiceURL:    conf.IdentityService,
+		BackendRequestTimeout: conf.BackendRequestTimeoutDuration,
+		HTTP: clients.NewHTTPClient(clients.TransportParams{
+			MaxIdleConns:        conf.BackendMaxIdleConns,
+			MaxIdleConnsPerHost: conf.BackendMaxIdleConnsPerHost,
+			IdleConnTimeout:     conf.BackendIdleConnDuration,
+			Proxy:               conf.BackendProxyURL,
+			RootCAs:             conf.BackendRootCAs,
+		}),
+	}
+
+	if len(conf.NiomonURLs) > 1 {
+		client.AuthFailover = clients.NewFailover("niomon", conf.NiomonURLs, clients.DefaultFailbackInterval)
+	}
+
+	if conf.MaxConcurrentBackendRequests > 0 {
+		client.AuthLimiter = clients.NewConcurrencyLimiter(conf.MaxConcurrentBackendRequests, clients.DefaultSlotWait)
+	}
+
+	if conf.BackendFailureThreshold > 0 {
+		client.AuthBreaker = clients.NewCircuitBreaker("niomon", conf.BackendFailureThreshold, conf.BackendCooldownDuration)
+		client.VerifyBreaker = clients.NewCircuitBreaker("verify", conf.BackendFailureThreshold, conf.BackendCooldownDuration)
+	}
+
+	// the mock backend answers all requests to the backend services, the configuration refuses it in production
+	if conf.MockBackend {
+		mock, err := clients.NewMockBackend(client)
+		if err != nil {
+			return nil, err
+		}
+		client.HTTP = &http.Client{Transport: mock}
+	}
+
+	return client, nil
+}
+
+// newProtocol returns the ubirch protocol with the configured crypto context (AWS KMS, PKCS#11 token or software keys)
+// and a function which closes the crypto context
+func newProtocol(ctx context.Context, conf config.Config, ctxManager repository.ContextManager,
+	client *clients.Client) (*repository.ExtendedProtocol, func() error, error) {
+
+	if conf.AWSKMS {
+		kmsCrypto, err := kms.NewCryptoContextFromEnv(ctx, conf.BackendRequestTimeoutDuration)
+		if err != nil {
+			return nil, nil, err
+		}
+		protocol, err := repository.NewExtendedProtocolWithCrypto(kmsCrypto, ctxManager, conf.SecretBytes32, client)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Info("keys of new identities are generated in AWS KMS")
+		return protocol, noClose, nil
+	}
+
+	if conf.PKCS11Module != "" {
+		hsmCrypto, err := hsm.NewCryptoContext(hsm.Config{
+			Module:     conf.PKCS11Module,
+			TokenLabel: conf.PKCS11TokenLabel,
+			PIN:        conf.PKCS11PIN,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		protocol, err := repository.NewExtendedProtocolWithCrypto(hsmCrypto, ctxManager, conf.SecretBytes32, client)
+		if err != nil {
+			_ = hsmCrypto.Close()
+			return nil, nil, err
+		}
+		log.Infof("keys of new identities are generated in PKCS#11 token %s", conf.PKCS11TokenLabel)
+		return protocol, hsmCrypto.Close, nil
+	}
+
+	protocol, err := repository.NewExtendedProtocol(ctxManager, conf.SecretBytes32, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	return protocol, noClose, nil
+}
+
+func noClose() error { return nil }
+
+// getHashAlgorithms parses the configured default hash algorithms per UUID
+func getHashAlgorithms(conf map[string]string) (map[uuid.UUID]string, error) {
+	hashAlgorithms := make(map[uuid.UUID]string, len(conf))
+	for id, alg := range conf {
+		uid, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UUID in hash algorithm configuration (\"%s\"): %v", id, err)
+		}
+		hashAlg, err := h.GetHashAlgorithm(alg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", uid, err)
+		}
+		hashAlgorithms[uid] = hashAlg.Name
+	}
+	return hashAlgorithms, nil
+}
+
+// getRateLimiter parses the configured rate limits and returns a rate limiter,
+// or nil if no rate limit is configured
+func getRateLimiter(defaultLimit string, conf map[string]string) (*handlers.RateLimiter, error) {
+	if defaultLimit == "" && len(conf) == 0 {
+		return nil, nil
+	}
+
+	var defaultRate handlers.Rate
+	if defaultLimit != "" {
+		var err error
+		defaultRate, err = handlers.ParseRate(defaultLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit ('rateLimitPerUUID'): %v", err)
+		}
+	}
+
+	rates := make(map[uuid.UUID]handlers.Rate, len(conf))
+	for id, limit := range conf {
+		uid, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UUID in rate limit configuration (\"%s\"): %v", id, err)
+		}
+		rates[uid], err = handlers.ParseRate(limit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", uid, err)
+		}
+	}
+
+	return handlers.NewRateLimiter(defaultRate, rates, handlers.DefaultMaxRateLimitBuckets), nil
+}
+
+// getBodySecrets parses the UUIDs of the configured shared secrets for the HMAC over request bodies
+func getBodySecrets(conf map[string]string) (map[uuid.UUID][]byte, error) {
+	secrets := make(map[uuid.UUID][]byte, len(conf))
+	for id, secret := range conf {
+		uid, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UUID in body secret configuration (\"%s\"): %v", id, err)
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("%s: empty body secret", uid)
+		}
+		secrets[uid] = []byte(secret)
+	}
+	return secrets, nil
+}
+
+// getDailyQuotas parses the UUIDs of the configured daily quotas
+func getDailyQuotas(defaultQuota int, conf map[string]int) (map[uuid.UUID]int, error) {
+	if defaultQuota < 0 {
+		return nil, fmt.Errorf("daily quota ('dailyQuota') must not be negative (is %d)", defaultQuota)
+	}
+
+	quotas := make(map[uuid.UUID]int, len(conf))
+	for id, limit := range conf {
+		uid, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UUID in daily quota configuration (\"%s\"): %v", id, err)
+		}
+		if limit < 0 {
+			return nil, fmt.Errorf("%s: daily quota must not be negative (is %d)", uid, limit)
+		}
+		quotas[uid] = limit
+	}
+	return quotas, nil
+}
+
 type identities struct {
-	handler       handlers.IdentityCreator
-	storeIdentity handlers.StoreIdentity
-	checkIdentity handlers.CheckIdentityExists
+	handler        handlers.IdentityCreator
+	storeIdentity  handlers.StoreIdentity
+	checkIdentity  handlers.CheckIdentityExists
+	deleteIdentity handlers.DeleteIdentity
+	listIdentities handlers.ListIdentities
 }
 
-func createIdentityUseCases(auth string, handler *handlers.IdentityHandler) identities {
+func createIdentityUseCases(auth string, handler *handlers.IdentityHandler, signer *handlers.Signer) identities {
 	return identities{
-		handler:       handlers.NewIdentityCreator(auth),
-		storeIdentity: uc.NewIdentityStorer(handler),
-		checkIdentity: uc.NewIdentityChecker(handler),
+		handler:        handlers.NewIdentityCreator(auth),
+		storeIdentity:  uc.NewIdentityStorer(handler),
+		checkIdentity:  uc.NewIdentityChecker(handler),
+		deleteIdentity: uc.NewIdentityDeleter(handler, signer),
+		listIdentities: uc.NewIdentityLister(handler),
 	}
 }
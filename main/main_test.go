@@ -87,7 +87,7 @@ func TestMain(m *testing.M) {
 	// extract the UUID and the Auth toke from the FIRST entry in the config
 	for key, value := range conf.Devices {
 		defaultUUID = key
-		defaultAuthToken = value
+		defaultAuthToken = value.Primary()
 		break
 	}
 
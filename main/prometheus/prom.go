@@ -77,6 +77,71 @@ var IdentityCreationCounter = prometheus.NewCounter(prometheus.CounterOpts{
 	Help: "Number of identities which have been successfully created and stored.",
 })
 
+var SignedUPPsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "signed_upps_total",
+		Help: "Number of signed UPPs per operation.",
+	},
+	[]string{"operation"},
+)
+
+var BackendErrorResponses = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "backend_error_responses_total",
+		Help: "Number of non-2xx responses from the UBIRCH authentication service per status code.",
+	},
+	[]string{"status"},
+)
+
+var UDPDroppedPackets = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "udp_dropped_packets",
+	Help: "Number of malformed or unauthorized UDP packets and of UDP packets exceeding the queue which have been dropped.",
+})
+
+var MQTTDroppedMessages = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "mqtt_dropped_messages",
+	Help: "Number of malformed or unauthorized MQTT messages which have been dropped.",
+})
+
+var KeyCacheRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "verify_key_cache_requests_total",
+		Help: "Number of lookups in the cache of public keys from the key service per result (hit | miss).",
+	},
+	[]string{"result"},
+)
+
+var BackendCircuitBreakerState = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "backend_circuit_breaker_state",
+		Help: "State of the circuit breaker per UBIRCH backend service (0: closed, 1: half-open, 2: open).",
+	},
+	[]string{"service"},
+)
+
+var BackendRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "backend_requests_in_flight",
+	Help: "Number of pending requests to the UBIRCH authentication service.",
+})
+
+var QueuedUPPs = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "queued_upps",
+	Help: "Number of UPPs in the queue for re-submission to the UBIRCH authentication service.",
+})
+
+var BackendOffline = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "backend_offline",
+	Help: "Whether the UBIRCH authentication service is considered unreachable in offline mode (0: online, 1: offline).",
+})
+
+var ChainQueueDepth = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "chain_queue_depth",
+		Help: "Number of queued chaining requests per UUID with an active chaining worker.",
+	},
+	[]string{"uuid"},
+)
+
 func RegisterPromMetrics() {
 	prometheus.Register(totalRequests)
 	prometheus.Register(responseStatus)
@@ -86,6 +151,16 @@ func RegisterPromMetrics() {
 	prometheus.Register(SignatureCreationCounter)
 	prometheus.Register(IdentityCreationDuration)
 	prometheus.Register(IdentityCreationCounter)
+	prometheus.Register(UDPDroppedPackets)
+	prometheus.Register(MQTTDroppedMessages)
+	prometheus.Register(SignedUPPsTotal)
+	prometheus.Register(BackendErrorResponses)
+	prometheus.Register(KeyCacheRequests)
+	prometheus.Register(BackendCircuitBreakerState)
+	prometheus.Register(BackendRequestsInFlight)
+	prometheus.Register(QueuedUPPs)
+	prometheus.Register(BackendOffline)
+	prometheus.Register(ChainQueueDepth)
 }
 
 func PromMiddleware(next http.Handler) http.Handler {
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/google/uuid"
+	"github.com/ubirch/ubirch-client-go/main/adapters/audit"
+	"github.com/ubirch/ubirch-client-go/main/adapters/handlers"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-client-go/main/config"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runSignCommand signs a file or a hash with an identity of the configured context, sends the UPP to the
+// ubirch backend and writes the signing response to stdout. Log messages are written to stderr.
+// Returns the exit code of the command.
+func runSignCommand(args []string, configFile string) int {
+	cmd, err := handlers.ParseSignCommand(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	err = signFromCommandLine(cmd, configFile)
+	if err != nil {
+		log.Errorf("%s: %v", handlers.SignCommandName, err)
+		return 1
+	}
+	return 0
+}
+
+func signFromCommandLine(cmd *handlers.SignCommand, configFile string) error {
+	conf := config.Config{}
+	err := conf.Load(cmd.ConfigDir, configFile)
+	if err != nil {
+		return fmt.Errorf("unable to load configuration: %v", err)
+	}
+
+	// abort the request to the backend on SIGINT or SIGTERM
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	ctxManager, err := repository.GetCtxManager(conf)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(conf)
+	if err != nil {
+		return err
+	}
+
+	protocol, closeCrypto, err := newProtocol(ctx, conf, ctxManager, client)
+	if err != nil {
+		return err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer closeCrypto()
+
+	hashAlgorithms, err := getHashAlgorithms(conf.HashAlgorithms)
+	if err != nil {
+		return err
+	}
+
+	// the command exits after signing, so it always waits for the backend response instead of
+	// completing the submission in the background after the slow backend threshold of the server
+	signer := &handlers.Signer{
+		Protocol:             protocol,
+		AuthTokensBuffer:     map[uuid.UUID]string{},
+		AuthTokenBufferMutex: &sync.RWMutex{},
+		BackendRetries:       conf.BackendRetries,
+		BackendRetryBackoff:  conf.BackendRetryBackoffDuration,
+		HashAlgorithms:       hashAlgorithms,
+	}
+
+	// UPPs signed from the command line are part of the UPP history like UPPs signed by the server
+	if conf.AuditLog {
+		signer.Audit, err = audit.NewLog(conf.AuditLogFile, audit.DefaultBufferSize, audit.DefaultFlushInterval)
+		if err != nil {
+			return err
+		}
+		//noinspection GoUnhandledErrorResult
+		defer signer.Audit.Close()
+	}
+
+	return cmd.Run(ctx, signer, os.Stdout)
+}
@@ -3,6 +3,7 @@ package uc
 import (
 	"github.com/google/uuid"
 	"github.com/ubirch/ubirch-client-go/main/adapters/handlers"
+	"github.com/ubirch/ubirch-client-go/main/ent"
 )
 
 func NewIdentityStorer(idHandler *handlers.IdentityHandler) handlers.StoreIdentity {
@@ -16,3 +17,20 @@ func NewIdentityChecker(idHandler *handlers.IdentityHandler) handlers.CheckIdent
 		return idHandler.Protocol.Exists(uid)
 	}
 }
+
+func NewIdentityLister(idHandler *handlers.IdentityHandler) handlers.ListIdentities {
+	return func(limit, offset int) ([]ent.IdentityInfo, error) {
+		return idHandler.Protocol.GetIdentityInfos(limit, offset)
+	}
+}
+
+func NewIdentityDeleter(idHandler *handlers.IdentityHandler, signer *handlers.Signer) handlers.DeleteIdentity {
+	return func(uid uuid.UUID, deactivateKey bool) error {
+		err := idHandler.DeleteIdentity(uid, deactivateKey)
+		if err != nil {
+			return err
+		}
+		signer.ForgetAuthToken(uid)
+		return nil
+	}
+}
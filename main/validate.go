@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ubirch/ubirch-client-go/main/adapters/clients"
+	"github.com/ubirch/ubirch-client-go/main/config"
+
+	h "github.com/ubirch/ubirch-client-go/main/adapters/httphelper"
+)
+
+const backendPingTimeout = 10 * time.Second
+
+// validateConfig loads and checks the configuration without starting the client and writes a
+// human-readable report to w. If pingBackends is set, the reachability of the configured backend
+// services is checked as well. Returns false if any check failed.
+func validateConfig(w io.Writer, configDir, configFile string, pingBackends bool) bool {
+	r := &validationReport{w: w}
+
+	conf := config.Config{}
+	err := conf.Load(configDir, configFile)
+	r.check("load configuration (mandatory values, secret length, backend URLs, proxy and CA file)", err)
+	if err != nil {
+		return r.summary()
+	}
+
+	errs := conf.Validate()
+	for _, err := range errs {
+		r.check("validate configuration", err)
+	}
+	if len(errs) == 0 {
+		r.check("validate configuration (devices, database, TLS files)", nil)
+	}
+
+	_, err = getHashAlgorithms(conf.HashAlgorithms)
+	r.check("hash algorithms ('hashAlgorithms')", err)
+
+	_, err = getRateLimiter(conf.RateLimitPerUUID, conf.RateLimits)
+	r.check("rate limits ('rateLimitPerUUID', 'rateLimits')", err)
+
+	_, err = getDailyQuotas(conf.DailyQuota, conf.DailyQuotas)
+	r.check("daily quotas ('dailyQuota', 'dailyQuotas')", err)
+
+	_, err = getBodySecrets(conf.BodySecrets)
+	r.check("body secrets ('bodySecrets')", err)
+
+	_, err = h.GetCanonicalization(conf.Canonicalization)
+	r.check("canonicalization ('canonicalization')", err)
+
+	if pingBackends {
+		// the backend services are reached via the configured proxy and CAs, like the client does
+		client := clients.NewHTTPClient(clients.TransportParams{Proxy: conf.BackendProxyURL, RootCAs: conf.BackendRootCAs})
+		client.Timeout = backendPingTimeout
+		type backendService struct {
+			name string
+			url  string
+		}
+		backends := []backendService{
+			{"UBIRCH Authentication Service", conf.Niomon},
+			{"UBIRCH Verification Service", conf.VerifyService},
+			{"UBIRCH Key Service", conf.KeyService},
+			{"UBIRCH Identity Service", conf.IdentityService},
+		}
+		if len(conf.NiomonURLs) > 1 {
+			for _, url := range conf.NiomonURLs[1:] {
+				backends = append(backends, backendService{"UBIRCH Authentication Service (failover)", url})
+			}
+		}
+		for _, backend := range backends {
+			r.check(fmt.Sprintf("reach %s (%s)", backend.name, backend.url), ping(client, backend.url))
+		}
+	}
+
+	return r.summary()
+}
+
+// ping checks if the backend service responds. Any HTTP response counts as reachable,
+// since the services do not accept requests without payload and auth token.
+func ping(client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+type validationReport struct {
+	w      io.Writer
+	failed int
+}
+
+func (r *validationReport) check(name string, err error) {
+	if err != nil {
+		r.failed++
+		_, _ = fmt.Fprintf(r.w, "[FAIL] %s: %v\n", name, err)
+		return
+	}
+	_, _ = fmt.Fprintf(r.w, "[ OK ] %s\n", name)
+}
+
+func (r *validationReport) summary() bool {
+	if r.failed > 0 {
+		_, _ = fmt.Fprintf(r.w, "configuration invalid: %d check(s) failed\n", r.failed)
+		return false
+	}
+	_, _ = fmt.Fprintln(r.w, "configuration valid")
+	return true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ubirch/ubirch-client-go/main/adapters/handlers"
+	"github.com/ubirch/ubirch-client-go/main/adapters/repository"
+	"github.com/ubirch/ubirch-client-go/main/config"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runVerifyCommand verifies a file or a hash with the ubirch verification service and writes the result
+// to stdout. Log messages are written to stderr. Returns the exit code of the command, which is not 0 if
+// the hash could not be verified.
+func runVerifyCommand(args []string, configFile string) int {
+	cmd, err := handlers.ParseVerifyCommand(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	err = verifyFromCommandLine(cmd, configFile)
+	if err != nil {
+		log.Errorf("%s: %v", handlers.VerifyCommandName, err)
+		return 1
+	}
+	return 0
+}
+
+func verifyFromCommandLine(cmd *handlers.VerifyCommand, configFile string) error {
+	conf := config.Config{}
+	err := conf.Load(cmd.ConfigDir, configFile)
+	if err != nil {
+		return fmt.Errorf("unable to load configuration: %v", err)
+	}
+
+	if cmd.Env != "" {
+		err = conf.SetEnv(cmd.Env)
+		if err != nil {
+			return err
+		}
+	}
+
+	// abort the verification on SIGINT or SIGTERM
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	ctxManager, err := repository.GetCtxManager(conf)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(conf)
+	if err != nil {
+		return err
+	}
+
+	protocol, closeCrypto, err := newProtocol(ctx, conf, ctxManager, client)
+	if err != nil {
+		return err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer closeCrypto()
+
+	verifier := &handlers.Verifier{
+		Protocol:                      protocol,
+		VerifyFromKnownIdentitiesOnly: conf.VerifyKnownOnly,
+		AnchorPollInterval:            conf.VerifyAnchorPollDuration,
+		AnchorTimeout:                 conf.VerifyAnchorTimeoutDuration,
+	}
+
+	return cmd.Run(ctx, verifier, os.Stdout)
+}